@@ -17,10 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -37,11 +39,12 @@ import (
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	listerscorev1 "k8s.io/client-go/listers/core/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
@@ -51,10 +54,17 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/heptiolabs/healthcheck"
+	"github.com/kubermatic/machine-controller/pkg/adminapi"
 	"github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1/migrations"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/events"
 	"github.com/kubermatic/machine-controller/pkg/clusterinfo"
 	machinecontroller "github.com/kubermatic/machine-controller/pkg/controller/machine"
+	"github.com/kubermatic/machine-controller/pkg/controller/nodemetadata"
 	machinehealth "github.com/kubermatic/machine-controller/pkg/health"
+	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+	"github.com/kubermatic/machine-controller/pkg/phonehome"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	"github.com/kubermatic/machine-controller/pkg/signals"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
@@ -64,7 +74,6 @@ import (
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	clusterv1alpha1clientset "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset"
 	clusterinformers "sigs.k8s.io/cluster-api/pkg/client/informers_generated/externalversions"
-	clusterlistersv1alpha1 "sigs.k8s.io/cluster-api/pkg/client/listers_generated/cluster/v1alpha1"
 	machinedeploymentcontroller "sigs.k8s.io/cluster-api/pkg/controller/machinedeployment"
 	machinesetcontroller "sigs.k8s.io/cluster-api/pkg/controller/machineset"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -83,6 +92,47 @@ var (
 	externalCloudProvider            bool
 	bootstrapTokenServiceAccountName string
 	skipEvictionAfter                time.Duration
+	instanceEventsListenAddress      string
+	instanceEventsSecretFile         string
+	instanceEventsTLSCertFile        string
+	instanceEventsTLSKeyFile         string
+	preCreateHookURL                 string
+	preCreateHookTimeout             time.Duration
+	deleteOnInstanceInterruption     bool
+	deleteStaleNodes                 bool
+	finalizerDeleteInstance          string
+	finalizerDeleteNode              string
+	drainNamespacePriorities         string
+	phoneHomeListenAddress           string
+	phoneHomeURL                     string
+	phoneHomeSecretFile              string
+	adminAPIListenAddress            string
+	adminAPITokenFile                string
+	adminAPITLSCertFile              string
+	adminAPITLSKeyFile               string
+	nodeStartupTaints                string
+	nodeStartupTaintGracePeriod      time.Duration
+	nodeCredentialsRecoveryPeriod    time.Duration
+	validateCredentials              bool
+	preDrainDaemonSets               string
+	preDrainTimeout                  time.Duration
+	drainExcludePodSelector          string
+	baseUserDataFile                 string
+	inPlaceResize                    bool
+	apiServerUnreachableThreshold    time.Duration
+	readinessGateDaemonSetNamespaces string
+	nodeInstanceTypeLabel            string
+	drainMaxUnavailable              int
+	recoverDeletingMachines          bool
+	volumeDetachTimeout              time.Duration
+	auditLogFile                     string
+	drainTopologySpreadCheck         bool
+	regionErrorThreshold             int
+	regionErrorCooldown              time.Duration
+	nodeLabelsFromTags               string
+	drainProgressUpdateInterval      time.Duration
+	reconcileKubeletConfig           bool
+	drainMaintenanceWindow           string
 )
 
 const (
@@ -109,42 +159,11 @@ type controllerRunOptions struct {
 	// ctrlruntimeclient is a client that knows how to consume everything
 	ctrlruntimeClient ctrlruntimeclient.Client
 
-	// this essentially sets the cluster DNS IP addresses. The list is passed to kubelet and then down to pods.
-	clusterDNSIPs []net.IP
-
-	// metrics a struct that holds all metrics we want to collect
-	metrics *machinecontroller.MetricsCollection
-
 	// leaderElectionClient holds a client that is used by the leader election library
 	leaderElectionClient *kubernetes.Clientset
 
-	// nodeInformer holds a shared informer for Nodes
-	nodeInformer cache.SharedIndexInformer
-
-	// nodeLister holds a lister that knows how to list Nodes from a cache
-	nodeLister listerscorev1.NodeLister
-
-	// secretSystemNsLister knows hot to list Secrects that are inside kube-system namespace from a cache
-	secretSystemNsLister listerscorev1.SecretLister
-
-	// pvLister knows how to list PersistentVolumes
-	pvLister listerscorev1.PersistentVolumeLister
-
-	// machineInformer holds a shared informer for Machines
-	machineInformer cache.SharedIndexInformer
-
-	// machineLister holds a lister that knows how to list Machines from a cache
-	machineLister clusterlistersv1alpha1.MachineLister
-
-	// kubeconfigProvider knows how to get cluster information stored under a ConfigMap
-	kubeconfigProvider machinecontroller.KubeconfigProvider
-
-	// name of the controller. When set the controller will only process machines with the label "machine.k8s.io/controller": name
-	name string
-
-	// Name of the ServiceAccount from which the bootstrap token secret will be fetched. A bootstrap token will be created
-	// if this is nil
-	bootstrapTokenServiceAccountName *types.NamespacedName
+	// machineDeploymentInformer holds a shared informer for MachineDeployments
+	machineDeploymentInformer cache.SharedIndexInformer
 
 	// parentCtx carries a cancellation signal
 	parentCtx context.Context
@@ -155,21 +174,11 @@ type controllerRunOptions struct {
 	// it should be the other way around i.e. derive a new context from the parent
 	parentCtxDone context.CancelFunc
 
-	// prometheusRegisterer is used by the MachineController instance to register its metrics
-	prometheusRegisterer prometheus.Registerer
-
 	// The cfg is used by the migration to conditionally spawn additional clients
 	cfg *restclient.Config
 
-	// The timeout in which machines owned by a MachineSet must join the cluster to avoid being
-	// deleted by the machine-controller
-	joinClusterTimeout *time.Duration
-
-	// Flag to initialize kubelets with --cloud-provider=external
-	externalCloudProvider bool
-
-	// Will instruct the machine-controller to skip the eviction if the machine deletion is older than skipEvictionAfter
-	skipEvictionAfter time.Duration
+	// Options are passed to the machine controller
+	machinecontroller.Options
 }
 
 func main() {
@@ -191,6 +200,47 @@ func main() {
 	flag.BoolVar(&profiling, "enable-profiling", false, "when set, enables the endpoints on the http server under /debug/pprof/")
 	flag.BoolVar(&externalCloudProvider, "external-cloud-provider", false, "when set, kubelets will receive --cloud-provider=external flag")
 	flag.DurationVar(&skipEvictionAfter, "skip-eviction-after", 2*time.Hour, "Skips the eviction if a machine is not gone after the specified duration.")
+	flag.StringVar(&instanceEventsListenAddress, "instance-events-listen-address", "", "When set, the controller receives instance state change events (AWS EventBridge, Azure Event Grid) on this address and immediately processes the affected machine. Otherwise machines get polled periodically. Requires -instance-events-secret-file")
+	flag.StringVar(&instanceEventsSecretFile, "instance-events-secret-file", "", "Path to a file containing the secret the senders of instance events must send as bearer token or in the X-Api-Key header")
+	flag.StringVar(&instanceEventsTLSCertFile, "instance-events-tls-cert-file", "", "Path to the TLS certificate of the instance events receiver. Without it the receiver may only listen on a loopback address")
+	flag.StringVar(&instanceEventsTLSKeyFile, "instance-events-tls-key-file", "", "Path to the TLS private key of the instance events receiver")
+	flag.StringVar(&preCreateHookURL, "pre-create-hook-url", "", "When set, the controller posts machines to this url before creating their instance. The instance only gets created once the hook allows it")
+	flag.DurationVar(&preCreateHookTimeout, "pre-create-hook-timeout", 10*time.Second, "Timeout for calls to the pre-create hook. A timed out call is treated as pending and retried")
+	flag.BoolVar(&deleteOnInstanceInterruption, "delete-on-instance-interruption", false, "When set, machines get drained and deleted as soon as a spot interruption warning for their instance is received. Requires -instance-events-listen-address")
+	flag.BoolVar(&deleteStaleNodes, "delete-stale-nodes", false, "When set, a node which has the name of the node of a new instance but belongs to an instance which is gone gets deleted, so the new instance can register. Only nodes which are not ready or older than the new instance get deleted")
+	flag.StringVar(&finalizerDeleteInstance, "delete-instance-finalizer-name", machinecontroller.FinalizerDeleteInstance, "Name of the finalizer which protects the instance of a machine. Must be unique if multiple controllers manage machines")
+	flag.StringVar(&finalizerDeleteNode, "delete-node-finalizer-name", machinecontroller.FinalizerDeleteNode, "Name of the finalizer which protects the node of a machine. Must be unique if multiple controllers manage machines")
+	flag.StringVar(&drainNamespacePriorities, "drain-namespace-priorities", "", "Comma-separated list of namespace=priority pairs. When draining a node, pods in namespaces with a lower priority get evicted first. Unlisted namespaces have the priority 0")
+	flag.StringVar(&phoneHomeListenAddress, "phone-home-listen-address", "", "When set, nodes report the result of their bootstrap to the controller on this address. Requires -phone-home-url and -phone-home-secret-file")
+	flag.StringVar(&phoneHomeURL, "phone-home-url", "", "The URL under which nodes reach the phone-home listener, e.g. https://machine-controller.example.com/phone-home")
+	flag.StringVar(&phoneHomeSecretFile, "phone-home-secret-file", "", "Path to a file containing the secret from which the phone-home tokens of the machines get derived")
+	flag.StringVar(&adminAPIListenAddress, "admin-api-listen-address", "", "When set, the admin API to recreate machines, cordon their nodes and get their status is served on this address. Requires -admin-api-token-file")
+	flag.StringVar(&adminAPITokenFile, "admin-api-token-file", "", "Path to a file containing the bearer token clients of the admin API must send")
+	flag.StringVar(&adminAPITLSCertFile, "admin-api-tls-cert-file", "", "Path to the TLS certificate of the admin API. Without it the admin API may only listen on a loopback address")
+	flag.StringVar(&adminAPITLSKeyFile, "admin-api-tls-key-file", "", "Path to the TLS private key of the admin API")
+	flag.StringVar(&nodeStartupTaints, "node-startup-taints", "", "Comma-separated list of taint keys which external controllers remove from new nodes once they initialized them. Nodes are considered healthy despite them until the grace period is over, afterwards the machine gets re-created")
+	flag.DurationVar(&nodeStartupTaintGracePeriod, "node-startup-taint-grace-period", 15*time.Minute, "The time after the node creation until which the taints from -node-startup-taints must be removed")
+	flag.DurationVar(&nodeCredentialsRecoveryPeriod, "node-credentials-recovery-grace-period", 0, "When set, nodes which stopped reporting their status because their kubelet client certificate expired get re-provisioned with a fresh bootstrap token once they are not ready for this duration")
+	flag.BoolVar(&validateCredentials, "validate-credentials", false, "When set, the cloud provider credentials of all machines get validated at startup and before an instance gets created, so invalid credentials are reported on the machines instead of failing the instance creation")
+	flag.StringVar(&preDrainDaemonSets, "pre-drain-daemonsets", "", "Comma-separated list of namespace/name DaemonSets whose pods on a node get the annotation machine-controller.kubermatic.io/shutdown-requested before the node gets drained. The drain waits until they set machine-controller.kubermatic.io/shutdown-acknowledged: \"true\" or -pre-drain-timeout is over")
+	flag.DurationVar(&preDrainTimeout, "pre-drain-timeout", 5*time.Minute, "The time to wait for the pods of -pre-drain-daemonsets to acknowledge their shutdown")
+	flag.StringVar(&drainExcludePodSelector, "drain-exclude-pod-selector", "", "Label selector for pods which do not get evicted when draining a node, e.g. agents which coordinate their own shutdown. They get taken down with the node. DaemonSet and mirror pods are never evicted")
+	flag.StringVar(&baseUserDataFile, "base-userdata-file", "", "Path to a cloud-config file with write_files and runcmd entries which get merged into the cloud-config of all machines. Its files get written and its commands run before the ones of the machine")
+	flag.BoolVar(&inPlaceResize, "in-place-resize", false, "When set, the instances of machines whose instance type got changed get resized in place on AWS, GCP and OpenStack. Their node gets cordoned and drained first and uncordoned once the instance runs with the new instance type")
+	flag.DurationVar(&apiServerUnreachableThreshold, "apiserver-unreachable-threshold", 0, "When set, the reconciliation of all machines gets paused once the apiserver is unreachable for this duration, so no instances get deleted or finalizers removed based on stale cached data. It resumes as soon as the apiserver is reachable again")
+	flag.StringVar(&readinessGateDaemonSetNamespaces, "readiness-gate-daemonset-namespaces", "", "Comma-separated list of namespaces, e.g. kube-system. A machine is only considered provisioned once the pods of all DaemonSets in them which target its node are ready on the node")
+	flag.StringVar(&nodeLabelsFromTags, "node-labels-from-tags", "", "Comma-separated list of instance tag keys which get synced onto the nodes as labels with the same key, or tag=label pairs to use another label key. The labels get updated when the tags change on the cloud provider and removed when the tags got removed. Supported on AWS, Azure and OpenStack, on GCP the labels of the instances are used")
+	flag.DurationVar(&drainProgressUpdateInterval, "drain-progress-update-interval", 10*time.Second, "How often the progress of a drain, i.e. the number of evicted and remaining pods and the pod the drain waits for, gets recorded in .status.providerStatus.drainStatus of the machine. 0 disables it")
+	flag.BoolVar(&reconcileKubeletConfig, "reconcile-kubelet-config", false, "When set, the kubeletConfig of machines gets set as annotation on their nodes, so a node agent applies changes of it by restarting the kubelet instead of re-creating the machine. Supported on Ubuntu and CentOS")
+	flag.StringVar(&drainMaintenanceWindow, "drain-maintenance-window", "", "When set, nodes only get drained for deletions and in-place resizes within these time ranges in UTC, separated by ';' and optionally preceded by weekdays, e.g. 'Sat-Sun 00:00-24:00; Mon-Fri 22:00-04:00'. Can be overridden per MachineDeployment with the machine-controller.kubermatic.io/drain-maintenance-window annotation")
+	flag.StringVar(&nodeInstanceTypeLabel, "node-instance-type-label", machinecontroller.DefaultNodeInstanceTypeLabelName, "The label which gets set to the instance type of the machine on its node, e.g. for scheduling onto specific instance types. It gets updated when an instance gets resized in place. Set to an empty string to disable it")
+	flag.IntVar(&drainMaxUnavailable, "drain-max-unavailable", 0, "When set, at most this many nodes get drained at the same time across all MachineDeployments, for deletions and in-place resizes. A node counts as unavailable from the start of its drain until it is deleted or resized. Other drains wait until a node becomes available again")
+	flag.BoolVar(&recoverDeletingMachines, "recover-deleting-machines", false, "When set, machines which are being deleted get checked at startup. The ones whose instance is gone get their node deleted and their finalizers removed, the others get queued to resume their deletion")
+	flag.StringVar(&auditLogFile, "audit-log-file", "", "When set, every instance creation, deletion and resize the controller performs against a cloud provider gets appended to this file as JSON line, with the controller identity, the machine, the operation, the redacted provider spec and the result")
+	flag.BoolVar(&drainTopologySpreadCheck, "drain-topology-spread-check", false, "When set, a node only gets drained once the pods on it with topology spread constraints of whenUnsatisfiable: DoNotSchedule can be rescheduled onto the remaining nodes without violating them and with enough free cpu and memory")
+	flag.IntVar(&regionErrorThreshold, "region-error-threshold", 0, "When set, no instances get created in a region of a cloud provider anymore once this many consecutive calls to the cloud provider in it failed, e.g. during a partial outage. Machines in other regions are not affected. After -region-error-cooldown a single creation gets retried, the region recovers with the first successful call")
+	flag.DurationVar(&regionErrorCooldown, "region-error-cooldown", 5*time.Minute, "The time the creation of instances in a failing region is paused before it gets retried")
+	flag.DurationVar(&volumeDetachTimeout, "volume-detach-timeout", 0, "When set, instances only get terminated once all VolumeAttachments of their node are removed, so CSI drivers can detach the volumes cleanly. After this timeout the instance gets terminated anyway and the machine gets the condition VolumesDetached set to false")
 
 	flag.Parse()
 	kubeconfig = flag.Lookup("kubeconfig").Value.(flag.Getter).Get().(string)
@@ -201,6 +251,51 @@ func main() {
 		glog.Fatalf("invalid cluster dns specified: %v", err)
 	}
 
+	if finalizerDeleteInstance == "" || finalizerDeleteNode == "" || finalizerDeleteInstance == finalizerDeleteNode {
+		glog.Fatalf("delete-instance-finalizer-name and delete-node-finalizer-name must be set to different values")
+	}
+
+	parsedDrainNamespacePriorities, err := eviction.ParseNamespacePriorities(drainNamespacePriorities)
+	if err != nil {
+		glog.Fatalf("invalid drain-namespace-priorities specified: %v", err)
+	}
+	preDrainHook, err := machinecontroller.NewPreDrainHook(preDrainDaemonSets, preDrainTimeout)
+	if err != nil {
+		glog.Fatalf("invalid pre-drain-daemonsets specified: %v", err)
+	}
+	var parsedDrainExcludePodSelector labels.Selector
+	if drainExcludePodSelector != "" {
+		parsedDrainExcludePodSelector, err = labels.Parse(drainExcludePodSelector)
+		if err != nil {
+			glog.Fatalf("invalid drain-exclude-pod-selector specified: %v", err)
+		}
+	}
+	baseUserData, err := machinecontroller.NewBaseUserData(baseUserDataFile)
+	if err != nil {
+		glog.Fatalf("invalid base-userdata-file specified: %v", err)
+	}
+	var auditor *cloudprovider.Auditor
+	if auditLogFile != "" {
+		sink, err := cloudprovider.NewFileAuditSink(auditLogFile)
+		if err != nil {
+			glog.Fatalf("invalid audit-log-file specified: %v", err)
+		}
+		auditor = cloudprovider.NewAuditor(sink, auditActor(name))
+	}
+	if nodeInstanceTypeLabel != "" {
+		if errs := validation.IsQualifiedName(nodeInstanceTypeLabel); len(errs) > 0 {
+			glog.Fatalf("invalid node-instance-type-label specified: %s", strings.Join(errs, ", "))
+		}
+	}
+	parsedDrainMaintenanceWindow, err := machinecontroller.ParseMaintenanceWindows(drainMaintenanceWindow)
+	if err != nil {
+		glog.Fatalf("invalid drain-maintenance-window specified: %v", err)
+	}
+	parsedNodeLabelsFromTags, err := machinecontroller.NewNodeTagLabels(nodeLabelsFromTags)
+	if err != nil {
+		glog.Fatalf("invalid node-labels-from-tags specified: %v", err)
+	}
+
 	var parsedJoinClusterTimeout *time.Duration
 	if joinClusterTimeout != "" {
 		parsedJoinClusterTimeoutLiteral, err := time.ParseDuration(joinClusterTimeout)
@@ -242,6 +337,11 @@ func main() {
 		glog.Fatalf("error building kubernetes clientset for kubeClient: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("error building dynamic client: %v", err)
+	}
+
 	extClient, err := apiextclient.NewForConfig(cfg)
 	if err != nil {
 		glog.Fatalf("error building kubernetes clientset for extClient: %v", err)
@@ -276,30 +376,69 @@ func main() {
 	kubeSystemInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, time.Second*30, metav1.NamespaceSystem, nil)
 	defaultKubeInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, time.Second*30, metav1.NamespaceDefault, nil)
 
+	templateInformer := providerconfig.NewTemplateInformer(dynamicClient, time.Minute*15)
+
 	kubeconfigProvider := clusterinfo.New(cfg, kubePublicKubeInformerFactory.Core().V1().ConfigMaps().Lister(), defaultKubeInformerFactory.Core().V1().Endpoints().Lister())
 	runOptions := controllerRunOptions{
-		kubeClient:            kubeClient,
-		extClient:             extClient,
-		machineClient:         machineClient,
-		ctrlruntimeClient:     ctrlruntimeClient,
-		metrics:               machinecontroller.NewMachineControllerMetrics(),
-		clusterDNSIPs:         ips,
-		leaderElectionClient:  leaderElectionClient,
-		nodeInformer:          kubeInformerFactory.Core().V1().Nodes().Informer(),
-		nodeLister:            kubeInformerFactory.Core().V1().Nodes().Lister(),
-		secretSystemNsLister:  kubeSystemInformerFactory.Core().V1().Secrets().Lister(),
-		pvLister:              kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
-		machineInformer:       clusterInformerFactory.Cluster().V1alpha1().Machines().Informer(),
-		machineLister:         clusterInformerFactory.Cluster().V1alpha1().Machines().Lister(),
-		kubeconfigProvider:    kubeconfigProvider,
-		name:                  name,
-		prometheusRegisterer:  prometheusRegistry,
-		cfg:                   machineCfg,
-		externalCloudProvider: externalCloudProvider,
-		skipEvictionAfter:     skipEvictionAfter,
+		kubeClient:                kubeClient,
+		extClient:                 extClient,
+		machineClient:             machineClient,
+		ctrlruntimeClient:         ctrlruntimeClient,
+		leaderElectionClient:      leaderElectionClient,
+		machineDeploymentInformer: clusterInformerFactory.Cluster().V1alpha1().MachineDeployments().Informer(),
+		cfg:                       machineCfg,
+		Options: machinecontroller.Options{
+			KubeClient:                   kubeClient,
+			MachineClient:                machineClient,
+			Metrics:                      machinecontroller.NewMachineControllerMetrics(),
+			ClusterDNSIPs:                ips,
+			NodeInformer:                 kubeInformerFactory.Core().V1().Nodes().Informer(),
+			NodeLister:                   kubeInformerFactory.Core().V1().Nodes().Lister(),
+			SecretSystemNsLister:         kubeSystemInformerFactory.Core().V1().Secrets().Lister(),
+			PVLister:                     kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+			MachineInformer:              clusterInformerFactory.Cluster().V1alpha1().Machines().Informer(),
+			MachineLister:                clusterInformerFactory.Cluster().V1alpha1().Machines().Lister(),
+			MachineDeploymentLister:      clusterInformerFactory.Cluster().V1alpha1().MachineDeployments().Lister(),
+			KubeconfigProvider:           kubeconfigProvider,
+			Name:                         name,
+			PrometheusRegisterer:         prometheusRegistry,
+			ExternalCloudProvider:        externalCloudProvider,
+			SkipEvictionAfter:            skipEvictionAfter,
+			DeleteOnInstanceInterruption: deleteOnInstanceInterruption,
+			DeleteStaleNodes:             deleteStaleNodes,
+			FinalizerDeleteInstance:      finalizerDeleteInstance,
+			FinalizerDeleteNode:          finalizerDeleteNode,
+			DrainNamespacePriorities:     parsedDrainNamespacePriorities,
+			StartupTaints:                machinecontroller.NewStartupTaints(nodeStartupTaints, nodeStartupTaintGracePeriod),
+			ValidateCredentials:          validateCredentials,
+			PreDrainHook:                 preDrainHook,
+			DrainExcludePodSelector:      parsedDrainExcludePodSelector,
+			BaseUserData:                 baseUserData,
+			InPlaceResize:                inPlaceResize,
+			APIServerCircuitBreaker:      machinecontroller.NewAPIServerCircuitBreaker(kubeClient, apiServerUnreachableThreshold),
+			DaemonSetReadinessGate:       machinecontroller.NewDaemonSetReadinessGate(readinessGateDaemonSetNamespaces),
+			NodeInstanceTypeLabel:        nodeInstanceTypeLabel,
+			DrainBudget:                  machinecontroller.NewDrainBudget(drainMaxUnavailable),
+			ProviderConfigTemplates:      providerconfig.NewTemplateResolver(templateInformer),
+			RecoverDeletingMachines:      recoverDeletingMachines,
+			VolumeDetachTimeout:          volumeDetachTimeout,
+			Auditor:                      auditor,
+			RegionCircuitBreaker:         machinecontroller.NewRegionCircuitBreaker(regionErrorThreshold, regionErrorCooldown),
+			NodeTagLabels:                parsedNodeLabelsFromTags,
+			DrainProgressUpdateInterval:  drainProgressUpdateInterval,
+			ReconcileKubeletConfig:       reconcileKubeletConfig,
+			DrainMaintenanceWindow:       parsedDrainMaintenanceWindow,
+		},
+	}
+	if nodeCredentialsRecoveryPeriod > 0 {
+		runOptions.NodeCredentialsRecovery = machinecontroller.NewNodeCredentialsRecovery(
+			kubeInformerFactory.Certificates().V1beta1().CertificateSigningRequests().Lister(), nodeCredentialsRecoveryPeriod)
+	}
+	if drainTopologySpreadCheck {
+		runOptions.TopologySpreadCheck = machinecontroller.NewTopologySpreadCheck(kubeClient.CoreV1().RESTClient())
 	}
 	if parsedJoinClusterTimeout != nil {
-		runOptions.joinClusterTimeout = parsedJoinClusterTimeout
+		runOptions.JoinClusterTimeout = parsedJoinClusterTimeout
 	}
 
 	if bootstrapTokenServiceAccountName != "" {
@@ -307,7 +446,89 @@ func main() {
 		if flagPartsLen := len(flagParts); flagPartsLen != 2 {
 			glog.Fatalf("Splitting the bootstrap-token-service-account-name flag value in '/' returned %d parts, expected exactly two", flagPartsLen)
 		}
-		runOptions.bootstrapTokenServiceAccountName = &types.NamespacedName{Namespace: flagParts[0], Name: flagParts[1]}
+		runOptions.BootstrapTokenServiceAccountName = &types.NamespacedName{Namespace: flagParts[0], Name: flagParts[1]}
+	}
+
+	if preCreateHookURL != "" {
+		runOptions.PreCreateHook = machinecontroller.NewPreCreateHook(preCreateHookURL, preCreateHookTimeout)
+	}
+
+	var phoneHomeServer *http.Server
+	if phoneHomeListenAddress != "" {
+		if phoneHomeURL == "" || phoneHomeSecretFile == "" {
+			glog.Fatalf("phone-home-url and phone-home-secret-file are required when phone-home-listen-address is set")
+		}
+		secret, err := ioutil.ReadFile(phoneHomeSecretFile)
+		if err != nil {
+			glog.Fatalf("failed to read phone-home secret: %v", err)
+		}
+		if len(bytes.TrimSpace(secret)) == 0 {
+			glog.Fatalf("phone-home secret file %s is empty", phoneHomeSecretFile)
+		}
+		runOptions.PhoneHome = phonehome.NewReceiver(phoneHomeURL, bytes.TrimSpace(secret))
+		phoneHomeServer = &http.Server{
+			Addr:         phoneHomeListenAddress,
+			Handler:      runOptions.PhoneHome,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
+
+	var adminAPIServer *http.Server
+	if adminAPIListenAddress != "" {
+		if adminAPITokenFile == "" {
+			glog.Fatalf("admin-api-token-file is required when admin-api-listen-address is set")
+		}
+		token, err := ioutil.ReadFile(adminAPITokenFile)
+		if err != nil {
+			glog.Fatalf("failed to read admin API token: %v", err)
+		}
+		if len(bytes.TrimSpace(token)) == 0 {
+			glog.Fatalf("admin API token file %s is empty", adminAPITokenFile)
+		}
+		if (adminAPITLSCertFile == "") != (adminAPITLSKeyFile == "") {
+			glog.Fatalf("admin-api-tls-cert-file and admin-api-tls-key-file must be set together")
+		}
+		// The token authorizes recreating machines, so it must not be sent in plain text over the network
+		if adminAPITLSCertFile == "" && !adminapi.IsLoopbackAddress(adminAPIListenAddress) {
+			glog.Fatalf("admin-api-listen-address %q is not a loopback address, which requires admin-api-tls-cert-file and admin-api-tls-key-file", adminAPIListenAddress)
+		}
+		adminAPIServer = &http.Server{
+			Addr:         adminAPIListenAddress,
+			Handler:      adminapi.NewServer(bytes.TrimSpace(token), kubeClient, machineClient),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
+
+	var instanceEventsServer *http.Server
+	if instanceEventsListenAddress != "" {
+		if instanceEventsSecretFile == "" {
+			glog.Fatalf("instance-events-secret-file is required when instance-events-listen-address is set")
+		}
+		secret, err := ioutil.ReadFile(instanceEventsSecretFile)
+		if err != nil {
+			glog.Fatalf("failed to read instance events secret: %v", err)
+		}
+		if len(bytes.TrimSpace(secret)) == 0 {
+			glog.Fatalf("instance events secret file %s is empty", instanceEventsSecretFile)
+		}
+		if (instanceEventsTLSCertFile == "") != (instanceEventsTLSKeyFile == "") {
+			glog.Fatalf("instance-events-tls-cert-file and instance-events-tls-key-file must be set together")
+		}
+		// The secret must not be sent in plain text over the network, otherwise anyone could get machines deleted
+		// with fake interruption events
+		if instanceEventsTLSCertFile == "" && !adminapi.IsLoopbackAddress(instanceEventsListenAddress) {
+			glog.Fatalf("instance-events-listen-address %q is not a loopback address, which requires instance-events-tls-cert-file and instance-events-tls-key-file", instanceEventsListenAddress)
+		}
+		receiver := events.NewReceiver(bytes.TrimSpace(secret))
+		runOptions.InstanceEvents = receiver.Events()
+		instanceEventsServer = &http.Server{
+			Addr:         instanceEventsListenAddress,
+			Handler:      receiver,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
 	}
 
 	kubeInformerFactory.Start(stopCh)
@@ -315,6 +536,7 @@ func main() {
 	defaultKubeInformerFactory.Start(stopCh)
 	clusterInformerFactory.Start(stopCh)
 	kubeSystemInformerFactory.Start(stopCh)
+	go templateInformer.Run(stopCh)
 
 	syncsMaps := []map[reflect.Type]bool{
 		kubeInformerFactory.WaitForCacheSync(stopCh),
@@ -330,6 +552,9 @@ func main() {
 			}
 		}
 	}
+	if !cache.WaitForCacheSync(stopCh, templateInformer.HasSynced) {
+		glog.Fatal("unable to sync the ProviderConfigTemplates")
+	}
 
 	ctx, ctxDone := context.WithCancel(context.Background())
 	var g run.Group
@@ -337,6 +562,7 @@ func main() {
 		prometheusRegistry.MustRegister(machinecontroller.NewMachineCollector(
 			clusterInformerFactory.Cluster().V1alpha1().Machines().Lister(),
 			kubeClient,
+			runOptions.ProviderConfigTemplates,
 		))
 
 		s := createUtilHTTPServer(kubeClient, kubeconfigProvider, prometheus.DefaultGatherer)
@@ -351,6 +577,48 @@ func main() {
 			}
 		})
 	}
+	if phoneHomeServer != nil {
+		g.Add(func() error {
+			return phoneHomeServer.ListenAndServe()
+		}, func(err error) {
+			glog.Warningf("shutting down phone-home HTTP server due to: %s", err)
+			srvCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			if err = phoneHomeServer.Shutdown(srvCtx); err != nil {
+				glog.Errorf("failed to shutdown phone-home HTTP server: %s", err)
+			}
+		})
+	}
+	if adminAPIServer != nil {
+		g.Add(func() error {
+			if adminAPITLSCertFile != "" {
+				return adminAPIServer.ListenAndServeTLS(adminAPITLSCertFile, adminAPITLSKeyFile)
+			}
+			return adminAPIServer.ListenAndServe()
+		}, func(err error) {
+			glog.Warningf("shutting down admin API HTTP server due to: %s", err)
+			srvCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			if err = adminAPIServer.Shutdown(srvCtx); err != nil {
+				glog.Errorf("failed to shutdown admin API HTTP server: %s", err)
+			}
+		})
+	}
+	if instanceEventsServer != nil {
+		g.Add(func() error {
+			if instanceEventsTLSCertFile != "" {
+				return instanceEventsServer.ListenAndServeTLS(instanceEventsTLSCertFile, instanceEventsTLSKeyFile)
+			}
+			return instanceEventsServer.ListenAndServe()
+		}, func(err error) {
+			glog.Warningf("shutting down instance events HTTP server due to: %s", err)
+			srvCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			if err = instanceEventsServer.Shutdown(srvCtx); err != nil {
+				glog.Errorf("failed to shutdown instance events HTTP server: %s", err)
+			}
+		})
+	}
 	{
 		g.Add(func() error {
 			select {
@@ -390,8 +658,8 @@ func startControllerViaLeaderElection(runOptions controllerRunOptions) error {
 
 	// add worker name to the election lock name to prevent conflicts between controllers handling different worker labels
 	leaderName := controllerName
-	if runOptions.name != "" {
-		leaderName = runOptions.name + "-" + leaderName
+	if runOptions.Name != "" {
+		leaderName = runOptions.Name + "-" + leaderName
 	}
 
 	rl := resourcelock.EndpointsLock{
@@ -413,7 +681,10 @@ func startControllerViaLeaderElection(runOptions controllerRunOptions) error {
 	runController := func(ctx context.Context) {
 
 		//Migrate MachinesV1Alpha1Machine to ClusterV1Alpha1Machine
-		if err := migrations.MigrateMachinesv1Alpha1MachineToClusterv1Alpha1MachineIfNecessary(ctx, runOptions.ctrlruntimeClient, runOptions.kubeClient); err != nil {
+		if err := migrations.MigrateMachinesv1Alpha1MachineToClusterv1Alpha1MachineIfNecessary(ctx, runOptions.ctrlruntimeClient, runOptions.kubeClient, migrations.Finalizers{
+			DeleteInstance: runOptions.FinalizerDeleteInstance,
+			DeleteNode:     runOptions.FinalizerDeleteNode,
+		}); err != nil {
 			glog.Errorf("Migration to clusterv1alpha1 failed: %v", err)
 			runOptions.parentCtxDone()
 			return
@@ -451,25 +722,15 @@ func startControllerViaLeaderElection(runOptions controllerRunOptions) error {
 			}
 		}()
 
-		machineController, err := machinecontroller.NewMachineController(
+		nodeMetadataController := nodemetadata.New(
 			runOptions.kubeClient,
 			runOptions.machineClient,
-			runOptions.nodeInformer,
-			runOptions.nodeLister,
-			runOptions.machineInformer,
-			runOptions.machineLister,
-			runOptions.secretSystemNsLister,
-			runOptions.pvLister,
-			runOptions.clusterDNSIPs,
-			runOptions.metrics,
-			runOptions.prometheusRegisterer,
-			runOptions.kubeconfigProvider,
-			runOptions.joinClusterTimeout,
-			runOptions.externalCloudProvider,
-			runOptions.name,
-			runOptions.bootstrapTokenServiceAccountName,
-			runOptions.skipEvictionAfter,
+			runOptions.machineDeploymentInformer,
+			runOptions.MachineDeploymentLister,
 		)
+		go nodeMetadataController.Run(1, runOptions.parentCtx.Done())
+
+		machineController, err := machinecontroller.NewMachineController(runOptions.Options)
 		if err != nil {
 			glog.Errorf("failed to create machine-controller: %v", err)
 			runOptions.parentCtxDone()
@@ -588,6 +849,19 @@ func parseClusterDNSIPs(s string) ([]net.IP, error) {
 }
 
 // return label selector to only process machines with a matching machine.k8s.io/controller label
+// auditActor returns the identity of the controller in audit records, e.g. worker1-machine-controller@host1
+func auditActor(workerName string) string {
+	actor := controllerName
+	if workerName != "" {
+		actor = workerName + "-" + actor
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("error getting hostname: %v", err)
+	}
+	return actor + "@" + hostname
+}
+
 func labelSelector(workerName string) func(*metav1.ListOptions) {
 	return func(options *metav1.ListOptions) {
 		var req *labels.Requirement
@@ -22,21 +22,30 @@ package main
 
 import (
 	"flag"
+	"fmt"
 
 	"github.com/golang/glog"
 
 	"github.com/kubermatic/machine-controller/pkg/userdata/convert"
 	"github.com/kubermatic/machine-controller/pkg/userdata/coreos"
 	userdataplugin "github.com/kubermatic/machine-controller/pkg/userdata/plugin"
+	"github.com/kubermatic/machine-controller/pkg/version"
 )
 
 func main() {
 	// Parse flags.
 	var debug bool
+	var printVersion bool
 
 	flag.BoolVar(&debug, "debug", false, "Switch for enabling the plugin debugging")
+	flag.BoolVar(&printVersion, "version", false, "Print the version of the plugin and exit")
 	flag.Parse()
 
+	if printVersion {
+		fmt.Println(version.Version)
+		return
+	}
+
 	// Instantiate provider and start plugin.
 	var provider = &coreos.Provider{}
 	var p = userdataplugin.New(convert.NewIgnition(provider), debug)
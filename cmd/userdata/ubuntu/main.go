@@ -22,20 +22,29 @@ package main
 
 import (
 	"flag"
+	"fmt"
 
 	"github.com/golang/glog"
 
 	userdataplugin "github.com/kubermatic/machine-controller/pkg/userdata/plugin"
 	"github.com/kubermatic/machine-controller/pkg/userdata/ubuntu"
+	"github.com/kubermatic/machine-controller/pkg/version"
 )
 
 func main() {
 	// Parse flags.
 	var debug bool
+	var printVersion bool
 
 	flag.BoolVar(&debug, "debug", false, "Switch for enabling the plugin debugging")
+	flag.BoolVar(&printVersion, "version", false, "Print the version of the plugin and exit")
 	flag.Parse()
 
+	if printVersion {
+		fmt.Println(version.Version)
+		return
+	}
+
 	// Instantiate provider and start plugin.
 	var provider = &ubuntu.Provider{}
 	var p = userdataplugin.New(provider, debug)
@@ -18,12 +18,16 @@ package main
 
 import (
 	"flag"
+	"time"
 
 	"github.com/golang/glog"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/kubermatic/machine-controller/pkg/admission"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatamanager "github.com/kubermatic/machine-controller/pkg/userdata/manager"
 )
 
@@ -59,12 +63,24 @@ func main() {
 		glog.Fatalf("error building kubernetes clientset for kubeClient: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("error building dynamic client: %v", err)
+	}
+
 	um, err := userdatamanager.New()
 	if err != nil {
 		glog.Fatalf("error initialising userdata plugins: %v", err)
 	}
 
-	s := admission.New(admissionListenAddress, kubeClient, um)
+	stopCh := make(chan struct{})
+	templateInformer := providerconfig.NewTemplateInformer(dynamicClient, 15*time.Minute)
+	go templateInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, templateInformer.HasSynced) {
+		glog.Fatal("unable to sync the ProviderConfigTemplates")
+	}
+
+	s := admission.New(admissionListenAddress, kubeClient, um, providerconfig.NewTemplateResolver(templateInformer))
 	if err := s.ListenAndServeTLS(admissionTLSCertPath, admissionTLSKeyPath); err != nil {
 		glog.Fatalf("Failed to start server: %v", err)
 	}
@@ -0,0 +1,270 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Admin API of the machine-controller.
+// Other platforms trigger operations on machines through it instead of
+// setting annotations or deleting objects themselves:
+//
+//   GET  /machines/<namespace>/<name>          status of the machine
+//   POST /machines/<namespace>/<name>/recreate deletes the machine, its MachineSet creates a new one
+//   POST /machines/<namespace>/<name>/cordon   marks the node of the machine unschedulable
+//   POST /machines/<namespace>/<name>/uncordon marks the node of the machine schedulable again
+//
+// All requests must carry the configured token as bearer token. Without TLS the API may
+// only be served on a loopback address, so the token does not cross the network in plain text.
+//
+
+package adminapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterv1alpha1clientset "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset"
+)
+
+const (
+	operationRecreate = "recreate"
+	operationCordon   = "cordon"
+	operationUncordon = "uncordon"
+)
+
+// MachineStatus is the status of a machine returned by the admin API
+type MachineStatus struct {
+	Namespace     string                     `json:"namespace"`
+	Name          string                     `json:"name"`
+	NodeName      string                     `json:"nodeName,omitempty"`
+	NodeReady     bool                       `json:"nodeReady"`
+	Unschedulable bool                       `json:"unschedulable"`
+	Deleting      bool                       `json:"deleting"`
+	ErrorReason   string                     `json:"errorReason,omitempty"`
+	ErrorMessage  string                     `json:"errorMessage,omitempty"`
+	Addresses     []corev1.NodeAddress       `json:"addresses,omitempty"`
+	Conditions    []providerconfig.Condition `json:"conditions,omitempty"`
+}
+
+// Server is a http.Handler which serves the admin API
+type Server struct {
+	token         []byte
+	kubeClient    kubernetes.Interface
+	machineClient clusterv1alpha1clientset.Interface
+}
+
+// NewServer returns a new Server. Requests must carry the given token as bearer token
+func NewServer(token []byte, kubeClient kubernetes.Interface, machineClient clusterv1alpha1clientset.Interface) *Server {
+	return &Server{token: token, kubeClient: kubeClient, machineClient: machineClient}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "machines" || parts[1] == "" || parts[2] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	namespace, name := parts[1], parts[2]
+
+	if len(parts) == 3 {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.getMachineStatus(w, namespace, name)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch operation := parts[3]; operation {
+	case operationRecreate:
+		s.recreateMachine(w, namespace, name)
+	case operationCordon, operationUncordon:
+		s.cordonNode(w, namespace, name, operation == operationCordon)
+	default:
+		http.Error(w, fmt.Sprintf("unknown operation %q", operation), http.StatusNotFound)
+	}
+}
+
+// getMachine writes the error response and returns nil if the machine can not be found
+func (s *Server) getMachine(w http.ResponseWriter, namespace, name string) *clusterv1alpha1.Machine {
+	machine, err := s.machineClient.ClusterV1alpha1().Machines(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("machine %s/%s not found", namespace, name), http.StatusNotFound)
+			return nil
+		}
+		http.Error(w, fmt.Sprintf("failed to get machine %s/%s: %v", namespace, name, err), http.StatusInternalServerError)
+		return nil
+	}
+	return machine
+}
+
+func (s *Server) getMachineStatus(w http.ResponseWriter, namespace, name string) {
+	machine := s.getMachine(w, namespace, name)
+	if machine == nil {
+		return
+	}
+
+	status := MachineStatus{
+		Namespace: machine.Namespace,
+		Name:      machine.Name,
+		Deleting:  machine.DeletionTimestamp != nil,
+		Addresses: machine.Status.Addresses,
+	}
+	if machine.Status.ErrorReason != nil {
+		status.ErrorReason = string(*machine.Status.ErrorReason)
+	}
+	if machine.Status.ErrorMessage != nil {
+		status.ErrorMessage = *machine.Status.ErrorMessage
+	}
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get provider status of machine %s/%s: %v", namespace, name, err), http.StatusInternalServerError)
+		return
+	}
+	status.Conditions = providerStatus.Conditions
+
+	if machine.Status.NodeRef != nil {
+		status.NodeName = machine.Status.NodeRef.Name
+		node, err := s.kubeClient.CoreV1().Nodes().Get(machine.Status.NodeRef.Name, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("failed to get node %s: %v", machine.Status.NodeRef.Name, err), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			status.Unschedulable = node.Spec.Unschedulable
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == corev1.NodeReady {
+					status.NodeReady = condition.Status == corev1.ConditionTrue
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		glog.Errorf("Failed to write status of machine %s/%s: %v", namespace, name, err)
+	}
+}
+
+// recreateMachine deletes the machine. The machine-controller drains its node and deletes its instance like for every
+// other deletion, the MachineSet of the machine replaces it with a new one
+func (s *Server) recreateMachine(w http.ResponseWriter, namespace, name string) {
+	machine := s.getMachine(w, namespace, name)
+	if machine == nil {
+		return
+	}
+	if !ownedByMachineSet(machine) {
+		http.Error(w, fmt.Sprintf("machine %s/%s is not owned by a MachineSet and would not be recreated", namespace, name), http.StatusConflict)
+		return
+	}
+	if machine.DeletionTimestamp != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// The UID precondition makes sure a machine which got recreated meanwhile under the same name is kept
+	err := s.machineClient.ClusterV1alpha1().Machines(namespace).Delete(name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &machine.UID},
+	})
+	if err != nil && !kerrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("failed to delete machine %s/%s: %v", namespace, name, err), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Deleted machine %s/%s to recreate it as requested via the admin API", namespace, name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func ownedByMachineSet(machine *clusterv1alpha1.Machine) bool {
+	for _, ownerRef := range machine.OwnerReferences {
+		if ownerRef.Kind == "MachineSet" && ownerRef.Controller != nil && *ownerRef.Controller {
+			return true
+		}
+	}
+	return false
+}
+
+// cordonNode marks the node of the machine unschedulable or schedulable again
+func (s *Server) cordonNode(w http.ResponseWriter, namespace, name string, unschedulable bool) {
+	machine := s.getMachine(w, namespace, name)
+	if machine == nil {
+		return
+	}
+	if machine.Status.NodeRef == nil {
+		http.Error(w, fmt.Sprintf("machine %s/%s has no node yet", namespace, name), http.StatusConflict)
+		return
+	}
+
+	nodeName := machine.Status.NodeRef.Name
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node, err := s.kubeClient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Spec.Unschedulable == unschedulable {
+			return nil
+		}
+		node.Spec.Unschedulable = unschedulable
+		_, err = s.kubeClient.CoreV1().Nodes().Update(node)
+		return err
+	})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("node %s of machine %s/%s not found", nodeName, namespace, name), http.StatusConflict)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to update node %s: %v", nodeName, err), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Set node %s of machine %s/%s unschedulable=%t as requested via the admin API", nodeName, namespace, name, unschedulable)
+	w.WriteHeader(http.StatusOK)
+}
+
+// IsLoopbackAddress tells if the listen address only accepts connections from the host itself
+func IsLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adminapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+)
+
+const testToken = "token"
+
+func newMachine(name string, ownedByMachineSet bool) *clusterv1alpha1.Machine {
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: name, UID: "machine-uid"},
+		Status: clusterv1alpha1.MachineStatus{
+			NodeRef:   &corev1.ObjectReference{Name: "node-1"},
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+	if ownedByMachineSet {
+		controller := true
+		machine.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineSet", Name: "machineset-1", Controller: &controller}}
+	}
+	return machine
+}
+
+func newNode(ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func serve(server *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetMachineStatus(t *testing.T) {
+	machine := newMachine("machine-1", true)
+	machine.Status.ProviderStatus = &runtime.RawExtension{
+		Raw: []byte(`{"conditions":[{"type":"InstanceStopped","status":"False","reason":"Running"}]}`),
+	}
+	server := NewServer([]byte(testToken), kubefake.NewSimpleClientset(newNode(true)), clusterfake.NewSimpleClientset(machine))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+		expectedBody   *MachineStatus
+	}{
+		{
+			name:           "status",
+			method:         http.MethodGet,
+			path:           "/machines/kube-system/machine-1",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody: &MachineStatus{
+				Namespace: "kube-system",
+				Name:      "machine-1",
+				NodeName:  "node-1",
+				NodeReady: true,
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+				Conditions: []providerconfig.Condition{
+					{Type: "InstanceStopped", Status: "False", Reason: "Running"},
+				},
+			},
+		},
+		{
+			name:           "missing token",
+			method:         http.MethodGet,
+			path:           "/machines/kube-system/machine-1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid token",
+			method:         http.MethodGet,
+			path:           "/machines/kube-system/machine-1",
+			token:          "other-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unknown machine",
+			method:         http.MethodGet,
+			path:           "/machines/kube-system/machine-2",
+			token:          testToken,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid method",
+			method:         http.MethodDelete,
+			path:           "/machines/kube-system/machine-1",
+			token:          testToken,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(server, test.method, test.path, test.token)
+			if w.Code != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, w.Code, w.Body.String())
+			}
+			if test.expectedBody == nil {
+				return
+			}
+			status := &MachineStatus{}
+			if err := json.Unmarshal(w.Body.Bytes(), status); err != nil {
+				t.Fatalf("failed to decode status: %v", err)
+			}
+			if diff := deep.Equal(status, test.expectedBody); diff != nil {
+				t.Errorf("unexpected status, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestRecreateMachine(t *testing.T) {
+	tests := []struct {
+		name           string
+		machine        *clusterv1alpha1.Machine
+		token          string
+		expectedStatus int
+		expectDeleted  bool
+	}{
+		{
+			name:           "machine of a MachineSet gets deleted",
+			machine:        newMachine("machine-1", true),
+			token:          testToken,
+			expectedStatus: http.StatusAccepted,
+			expectDeleted:  true,
+		},
+		{
+			name:           "machine without MachineSet is kept",
+			machine:        newMachine("machine-1", false),
+			token:          testToken,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "invalid token",
+			machine:        newMachine("machine-1", true),
+			token:          "other-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machineClient := clusterfake.NewSimpleClientset(test.machine)
+			server := NewServer([]byte(testToken), kubefake.NewSimpleClientset(newNode(true)), machineClient)
+
+			w := serve(server, http.MethodPost, "/machines/kube-system/machine-1/recreate", test.token)
+			if w.Code != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, w.Code, w.Body.String())
+			}
+
+			_, err := machineClient.ClusterV1alpha1().Machines(metav1.NamespaceSystem).Get("machine-1", metav1.GetOptions{})
+			if deleted := kerrors.IsNotFound(err); deleted != test.expectDeleted {
+				t.Errorf("expected machine deleted: %t, got: %t (%v)", test.expectDeleted, deleted, err)
+			}
+		})
+	}
+
+	t.Run("unknown machine", func(t *testing.T) {
+		server := NewServer([]byte(testToken), kubefake.NewSimpleClientset(), clusterfake.NewSimpleClientset())
+		if w := serve(server, http.MethodPost, "/machines/kube-system/machine-1/recreate", testToken); w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
+
+func TestCordonNode(t *testing.T) {
+	kubeClient := kubefake.NewSimpleClientset(newNode(true))
+	server := NewServer([]byte(testToken), kubeClient, clusterfake.NewSimpleClientset(newMachine("machine-1", true)))
+
+	for _, unschedulable := range []bool{true, false} {
+		path := "/machines/kube-system/machine-1/uncordon"
+		if unschedulable {
+			path = "/machines/kube-system/machine-1/cordon"
+		}
+		if w := serve(server, http.MethodPost, path, testToken); w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		node, err := kubeClient.CoreV1().Nodes().Get("node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get node: %v", err)
+		}
+		if node.Spec.Unschedulable != unschedulable {
+			t.Errorf("expected node unschedulable: %t, got: %t", unschedulable, node.Spec.Unschedulable)
+		}
+	}
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{address: "127.0.0.1:8087", expected: true},
+		{address: "[::1]:8087", expected: true},
+		{address: "localhost:8087", expected: true},
+		{address: ":8087", expected: false},
+		{address: "0.0.0.0:8087", expected: false},
+		{address: "10.0.0.1:8087", expected: false},
+		{address: "127.0.0.1", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			if loopback := IsLoopbackAddress(test.address); loopback != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, loopback)
+			}
+		})
+	}
+}
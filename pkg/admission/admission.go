@@ -33,21 +33,24 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatamanager "github.com/kubermatic/machine-controller/pkg/userdata/manager"
 )
 
 type admissionData struct {
-	coreClient      kubernetes.Interface
-	userDataManager *userdatamanager.Manager
+	coreClient              kubernetes.Interface
+	userDataManager         *userdatamanager.Manager
+	providerConfigTemplates *providerconfig.TemplateResolver
 }
 
 var jsonPatch = admissionv1beta1.PatchTypeJSONPatch
 
-func New(listenAddress string, coreClient kubernetes.Interface, um *userdatamanager.Manager) *http.Server {
+func New(listenAddress string, coreClient kubernetes.Interface, um *userdatamanager.Manager, providerConfigTemplates *providerconfig.TemplateResolver) *http.Server {
 	m := http.NewServeMux()
 	ad := &admissionData{
-		coreClient:      coreClient,
-		userDataManager: um,
+		coreClient:              coreClient,
+		userDataManager:         um,
+		providerConfigTemplates: providerConfigTemplates,
 	}
 	m.HandleFunc("/machinedeployments", handleFuncFactory(ad.mutateMachineDeployments))
 	m.HandleFunc("/machines", handleFuncFactory(ad.mutateMachines))
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"strconv"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+// The cluster-autoscaler reads the capacity of a node group from these annotations
+// when it has to scale up a MachineDeployment without any nodes
+const (
+	AutoscalerCPUCapacityAnnotation      = "capacity.cluster-autoscaler.kubernetes.io/cpu"
+	AutoscalerMemoryCapacityAnnotation   = "capacity.cluster-autoscaler.kubernetes.io/memory"
+	AutoscalerGPUCountCapacityAnnotation = "capacity.cluster-autoscaler.kubernetes.io/gpu-count"
+	AutoscalerGPUTypeCapacityAnnotation  = "capacity.cluster-autoscaler.kubernetes.io/gpu-type"
+
+	gpuResourceName = "nvidia.com/gpu"
+)
+
+// setCapacityAnnotations sets the autoscaler capacity annotations on the MachineDeployment based on the
+// instance the machine template results in. If the provider does not know the capacity, the annotations
+// are left untouched so they can be maintained manually.
+func (ad *admissionData) setCapacityAnnotations(namespace string, md *clusterv1alpha1.MachineDeployment) error {
+	spec, err := ad.resolveMachineSpec(namespace, md.Spec.Template.Spec)
+	if err != nil {
+		return err
+	}
+	providerConfig, err := providerconfig.GetConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to read machine.spec.providerSpec: %v", err)
+	}
+	skg := providerconfig.NewConfigVarResolver(ad.coreClient)
+	prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, skg)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud provider %q: %v", providerConfig.CloudProvider, err)
+	}
+	capacity, err := prov.MachineCapacity(spec)
+	if err != nil {
+		return fmt.Errorf("failed to get machine capacity: %v", err)
+	}
+	if capacity == nil {
+		return nil
+	}
+
+	if md.Annotations == nil {
+		md.Annotations = map[string]string{}
+	}
+	md.Annotations[AutoscalerCPUCapacityAnnotation] = capacity.CPU.String()
+	md.Annotations[AutoscalerMemoryCapacityAnnotation] = capacity.Memory.String()
+	if capacity.GPUs > 0 {
+		md.Annotations[AutoscalerGPUCountCapacityAnnotation] = strconv.Itoa(capacity.GPUs)
+		md.Annotations[AutoscalerGPUTypeCapacityAnnotation] = gpuResourceName
+	} else {
+		delete(md.Annotations, AutoscalerGPUCountCapacityAnnotation)
+		delete(md.Annotations, AutoscalerGPUTypeCapacityAnnotation)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestSetCapacityAnnotations(t *testing.T) {
+	tests := []struct {
+		name                string
+		cloudProviderSpec   string
+		annotations         map[string]string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:              "aws instance type",
+			cloudProviderSpec: `{"cloudProvider": "aws", "cloudProviderSpec": {"accessKeyId": "key", "secretAccessKey": "secret", "instanceType": "m5.xlarge"}}`,
+			expectedAnnotations: map[string]string{
+				AutoscalerCPUCapacityAnnotation:    "4",
+				AutoscalerMemoryCapacityAnnotation: "16Gi",
+			},
+		},
+		{
+			name:              "aws gpu instance type replaces previous capacity",
+			cloudProviderSpec: `{"cloudProvider": "aws", "cloudProviderSpec": {"accessKeyId": "key", "secretAccessKey": "secret", "instanceType": "p3.8xlarge"}}`,
+			annotations: map[string]string{
+				AutoscalerCPUCapacityAnnotation:    "4",
+				AutoscalerMemoryCapacityAnnotation: "16Gi",
+			},
+			expectedAnnotations: map[string]string{
+				AutoscalerCPUCapacityAnnotation:      "32",
+				AutoscalerMemoryCapacityAnnotation:   "244Gi",
+				AutoscalerGPUCountCapacityAnnotation: "4",
+				AutoscalerGPUTypeCapacityAnnotation:  "nvidia.com/gpu",
+			},
+		},
+		{
+			name:              "unknown instance type keeps manually set annotations",
+			cloudProviderSpec: `{"cloudProvider": "aws", "cloudProviderSpec": {"accessKeyId": "key", "secretAccessKey": "secret", "instanceType": "x1e.32xlarge"}}`,
+			annotations: map[string]string{
+				AutoscalerCPUCapacityAnnotation:    "128",
+				AutoscalerMemoryCapacityAnnotation: "3904Gi",
+			},
+			expectedAnnotations: map[string]string{
+				AutoscalerCPUCapacityAnnotation:    "128",
+				AutoscalerMemoryCapacityAnnotation: "3904Gi",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ad := &admissionData{coreClient: kubefake.NewSimpleClientset()}
+			md := &clusterv1alpha1.MachineDeployment{}
+			md.Annotations = test.annotations
+			md.Spec.Template.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(test.cloudProviderSpec)}
+
+			if err := ad.setCapacityAnnotations(md.Namespace, md); err != nil {
+				t.Fatalf("failed to set capacity annotations: %v", err)
+			}
+			if diff := deep.Equal(md.Annotations, test.expectedAnnotations); diff != nil {
+				t.Errorf("unexpected annotations, diff: %v", fmt.Sprint(diff))
+			}
+		})
+	}
+}
@@ -19,11 +19,23 @@ package admission
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+)
+
+const (
+	// InPlaceNodeMetadataUpdateAnnotation opts a MachineDeployment into updating the existing machines and nodes
+	// when only the node labels or annotations of its template change, instead of rolling out new machines
+	InPlaceNodeMetadataUpdateAnnotation = "machine-controller.kubermatic.io/in-place-node-metadata-update"
+	// InPlaceNodeMetadataUpdatePendingAnnotation is set on a MachineDeployment which got paused until its existing
+	// machines and nodes got updated. Its value tells if the MachineDeployment must be resumed afterwards
+	InPlaceNodeMetadataUpdatePendingAnnotation = "machine-controller.kubermatic.io/in-place-node-metadata-update-pending"
 )
 
 func (ad *admissionData) mutateMachineDeployments(ar admissionv1beta1.AdmissionReview) (*admissionv1beta1.AdmissionResponse, error) {
@@ -49,13 +61,40 @@ func (ad *admissionData) mutateMachineDeployments(ar admissionv1beta1.AdmissionR
 		if equal := apiequality.Semantic.DeepEqual(oldMachineDeployment.Spec.Template.Spec, machineDeployment.Spec.Template.Spec); equal {
 			machineSpecNeedsValidation = false
 		}
+		pauseForInPlaceNodeMetadataUpdate(&oldMachineDeployment, &machineDeployment)
 	}
 
 	if machineSpecNeedsValidation {
-		if err := ad.defaultAndValidateMachineSpec(&machineDeployment.Spec.Template.Spec); err != nil {
+		namespace := machineDeployment.Namespace
+		if namespace == "" {
+			namespace = ar.Request.Namespace
+		}
+		if err := ad.defaultAndValidateMachineSpec(namespace, &machineDeployment.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+		if err := ad.setCapacityAnnotations(namespace, &machineDeployment); err != nil {
 			return nil, err
 		}
 	}
 
 	return createAdmissionResponse(machineDeploymentOriginal, &machineDeployment)
 }
+
+// pauseForInPlaceNodeMetadataUpdate pauses a MachineDeployment which opted into in-place node metadata updates if
+// the update only changes the node labels or annotations. A paused MachineDeployment does not create a new MachineSet,
+// the machine-controller applies the change to the existing MachineSets, machines and nodes and resumes it afterwards.
+func pauseForInPlaceNodeMetadataUpdate(oldMachineDeployment, machineDeployment *clusterv1alpha1.MachineDeployment) {
+	if machineDeployment.Annotations[InPlaceNodeMetadataUpdateAnnotation] != "true" {
+		return
+	}
+	if _, pending := machineDeployment.Annotations[InPlaceNodeMetadataUpdatePendingAnnotation]; pending {
+		return
+	}
+	if apiequality.Semantic.DeepEqual(oldMachineDeployment.Spec.Template, machineDeployment.Spec.Template) ||
+		!kuberneteshelper.EqualIgnoringNodeMetadata(&oldMachineDeployment.Spec.Template, &machineDeployment.Spec.Template) {
+		return
+	}
+
+	machineDeployment.Annotations[InPlaceNodeMetadataUpdatePendingAnnotation] = strconv.FormatBool(!machineDeployment.Spec.Paused)
+	machineDeployment.Spec.Paused = true
+}
@@ -63,3 +63,74 @@ func TestMachineDeploymentDefaulting(t *testing.T) {
 		})
 	}
 }
+
+func TestPauseForInPlaceNodeMetadataUpdate(t *testing.T) {
+	template := func(nodeLabels map[string]string, kubelet string) clusterv1alpha1.MachineTemplateSpec {
+		return clusterv1alpha1.MachineTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"foo": "bar"}},
+			Spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: nodeLabels},
+				Versions:   clusterv1alpha1.MachineVersionInfo{Kubelet: kubelet},
+			},
+		}
+	}
+	machineDeployment := func(annotations map[string]string, paused bool, template clusterv1alpha1.MachineTemplateSpec) *clusterv1alpha1.MachineDeployment {
+		return &clusterv1alpha1.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       clusterv1alpha1.MachineDeploymentSpec{Paused: paused, Template: template},
+		}
+	}
+	optIn := func() map[string]string {
+		return map[string]string{InPlaceNodeMetadataUpdateAnnotation: "true"}
+	}
+
+	tests := []struct {
+		name            string
+		old             *clusterv1alpha1.MachineDeployment
+		new             *clusterv1alpha1.MachineDeployment
+		expectedPaused  bool
+		expectedPending string
+	}{
+		{
+			name:            "node label change gets paused",
+			old:             machineDeployment(optIn(), false, template(map[string]string{"role": "a"}, "1.14.0")),
+			new:             machineDeployment(optIn(), false, template(map[string]string{"role": "b"}, "1.14.0")),
+			expectedPaused:  true,
+			expectedPending: "true",
+		},
+		{
+			name:            "paused MachineDeployment stays paused",
+			old:             machineDeployment(optIn(), true, template(nil, "1.14.0")),
+			new:             machineDeployment(optIn(), true, template(map[string]string{"role": "b"}, "1.14.0")),
+			expectedPaused:  true,
+			expectedPending: "false",
+		},
+		{
+			name: "no opt-in",
+			old:  machineDeployment(nil, false, template(map[string]string{"role": "a"}, "1.14.0")),
+			new:  machineDeployment(nil, false, template(map[string]string{"role": "b"}, "1.14.0")),
+		},
+		{
+			name: "kubelet change rolls out",
+			old:  machineDeployment(optIn(), false, template(map[string]string{"role": "a"}, "1.14.0")),
+			new:  machineDeployment(optIn(), false, template(map[string]string{"role": "b"}, "1.15.0")),
+		},
+		{
+			name: "unchanged template",
+			old:  machineDeployment(optIn(), false, template(map[string]string{"role": "a"}, "1.14.0")),
+			new:  machineDeployment(map[string]string{InPlaceNodeMetadataUpdateAnnotation: "true", "other": "x"}, false, template(map[string]string{"role": "a"}, "1.14.0")),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pauseForInPlaceNodeMetadataUpdate(test.old, test.new)
+			if test.new.Spec.Paused != test.expectedPaused {
+				t.Errorf("expected paused to be %t", test.expectedPaused)
+			}
+			if pending := test.new.Annotations[InPlaceNodeMetadataUpdatePendingAnnotation]; pending != test.expectedPending {
+				t.Errorf("expected pending annotation %q, got %q", test.expectedPending, pending)
+			}
+		})
+	}
+}
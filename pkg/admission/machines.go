@@ -29,6 +29,7 @@ import (
 
 	clusterv1alpha1conversions "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1/conversions"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 )
 
@@ -62,10 +63,22 @@ func (ad *admissionData) mutateMachines(ar admissionv1beta1.AdmissionReview) (*a
 		// Allow mutation when:
 		// * oldMachine has Initializers on it
 		// * machine has the `MigrationBypassSpecNoModificationRequirementAnnotation` annotation (used for type migration)
+		// * only the instance type changes and the cloud provider can resize the instance in place
+		// * only the kubelet config changes, which gets applied to the existing node
 		bypassValidationForMigration := machine.Annotations[BypassSpecNoModificationRequirementAnnotation] == "true"
 		if (oldMachine.Initializers == nil || len(oldMachine.Initializers.Pending) == 0) && !bypassValidationForMigration {
 			if equal := apiequality.Semantic.DeepEqual(machine.Spec, oldMachine.Spec); !equal {
-				return nil, fmt.Errorf("machine.spec is immutable")
+				onlyKubeletConfigChanged, err := ad.onlyKubeletConfigChanged(machineNamespace(machine, ar), oldMachine.Spec, machine.Spec)
+				if err != nil {
+					return nil, err
+				}
+				if onlyKubeletConfigChanged {
+					if err := ad.defaultAndValidateMachineSpec(machineNamespace(machine, ar), &machine.Spec); err != nil {
+						return nil, err
+					}
+				} else if err := ad.validateInstanceTypeChange(machineNamespace(machine, ar), oldMachine.Spec, &machine.Spec); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -88,7 +101,7 @@ func (ad *admissionData) mutateMachines(ar admissionv1beta1.AdmissionReview) (*a
 	// Default and verify .Spec on CREATE only, its expensive and not required to do it on UPDATE
 	// as we disallow .Spec changes anyways
 	if ar.Request.Operation == admissionv1beta1.Create {
-		if err := ad.defaultAndValidateMachineSpec(&machine.Spec); err != nil {
+		if err := ad.defaultAndValidateMachineSpec(machineNamespace(machine, ar), &machine.Spec); err != nil {
 			return nil, err
 		}
 	}
@@ -96,7 +109,51 @@ func (ad *admissionData) mutateMachines(ar admissionv1beta1.AdmissionReview) (*a
 	return createAdmissionResponse(machineOriginal, &machine)
 }
 
-func (ad *admissionData) defaultAndValidateMachineSpec(spec *clusterv1alpha1.MachineSpec) error {
+// machineNamespace returns the namespace of the machine, which is unset on CREATE requests
+// if the client omitted it
+func machineNamespace(machine clusterv1alpha1.Machine, ar admissionv1beta1.AdmissionReview) string {
+	if machine.Namespace != "" {
+		return machine.Namespace
+	}
+	return ar.Request.Namespace
+}
+
+// resolveMachineSpec returns a copy of the spec with its provider config merged on top of the referenced
+// ProviderConfigTemplate. Only the copy gets validated, the object keeps referencing the template
+func (ad *admissionData) resolveMachineSpec(namespace string, spec clusterv1alpha1.MachineSpec) (clusterv1alpha1.MachineSpec, error) {
+	providerSpec, err := ad.providerConfigTemplates.Resolve(namespace, spec.ProviderSpec)
+	if err != nil {
+		return spec, fmt.Errorf("failed to resolve machine.spec.providerSpec: %v", err)
+	}
+	spec.ProviderSpec = providerSpec
+	return spec, nil
+}
+
+// onlyKubeletConfigChanged returns true if the specs only differ in their kubeletConfig and the operating system
+// supports changing it, which needs the operating system of the referenced ProviderConfigTemplate
+func (ad *admissionData) onlyKubeletConfigChanged(namespace string, oldSpec, spec clusterv1alpha1.MachineSpec) (bool, error) {
+	resolvedOldSpec, err := ad.resolveMachineSpec(namespace, oldSpec)
+	if err != nil {
+		return false, err
+	}
+	resolvedSpec, err := ad.resolveMachineSpec(namespace, spec)
+	if err != nil {
+		return false, err
+	}
+	onlyKubeletConfigChanged, err := providerconfig.OnlyKubeletConfigChanged(resolvedOldSpec, resolvedSpec)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare machine.spec: %v", err)
+	}
+	return onlyKubeletConfigChanged, nil
+}
+
+func (ad *admissionData) defaultAndValidateMachineSpec(namespace string, spec *clusterv1alpha1.MachineSpec) error {
+	resolvedSpec, err := ad.resolveMachineSpec(namespace, *spec)
+	if err != nil {
+		return err
+	}
+	spec = &resolvedSpec
+
 	providerConfig, err := providerconfig.GetConfig(spec.ProviderSpec)
 	if err != nil {
 		return fmt.Errorf("failed to read machine.spec.providerSpec: %v", err)
@@ -122,6 +179,18 @@ func (ad *admissionData) defaultAndValidateMachineSpec(spec *clusterv1alpha1.Mac
 		return fmt.Errorf("Invalid public keys specified: %v", err)
 	}
 
+	if _, _, err := providerConfig.ClusterDNSConfig(nil); err != nil {
+		return err
+	}
+
+	if err := providerConfig.ValidateWarmPool(); err != nil {
+		return err
+	}
+
+	if err := providerConfig.ValidateKubeletConfig(); err != nil {
+		return err
+	}
+
 	defaultedSpec, err := prov.AddDefaults(*spec)
 	if err != nil {
 		return fmt.Errorf("failed to default machineSpec: %v", err)
@@ -135,6 +204,41 @@ func (ad *admissionData) defaultAndValidateMachineSpec(spec *clusterv1alpha1.Mac
 	return nil
 }
 
+// validateInstanceTypeChange only allows spec changes of existing machines which change the instance type
+// on cloud providers that can resize instances in place. The new instance type gets validated
+func (ad *admissionData) validateInstanceTypeChange(namespace string, oldSpec clusterv1alpha1.MachineSpec, spec *clusterv1alpha1.MachineSpec) error {
+	oldSpec, err := ad.resolveMachineSpec(namespace, oldSpec)
+	if err != nil {
+		return err
+	}
+	resolvedSpec, err := ad.resolveMachineSpec(namespace, *spec)
+	if err != nil {
+		return err
+	}
+
+	providerConfig, err := providerconfig.GetConfig(resolvedSpec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to read machine.spec.providerSpec: %v", err)
+	}
+	skg := providerconfig.NewConfigVarResolver(ad.coreClient)
+	prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, skg)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud provider %q: %v", providerConfig.CloudProvider, err)
+	}
+	resizer, ok := prov.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return fmt.Errorf("machine.spec is immutable")
+	}
+	onlyInstanceTypeChanged, err := resizer.OnlyInstanceTypeChanged(oldSpec, resolvedSpec)
+	if err != nil {
+		return fmt.Errorf("failed to compare machine.spec: %v", err)
+	}
+	if !onlyInstanceTypeChanged {
+		return fmt.Errorf("machine.spec is immutable")
+	}
+	return ad.defaultAndValidateMachineSpec(namespace, spec)
+}
+
 func validatePublicKeys(keys []string) error {
 	for _, s := range keys {
 		_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
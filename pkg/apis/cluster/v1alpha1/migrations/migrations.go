@@ -135,8 +135,16 @@ func MigrateProviderConfigToProviderSpecIfNecesary(ctx context.Context, config *
 	return nil
 }
 
+// Finalizers are the names of the finalizers the controller manages on machines
+type Finalizers struct {
+	// DeleteInstance protects the instance of a machine
+	DeleteInstance string
+	// DeleteNode protects the node of a machine
+	DeleteNode string
+}
+
 func MigrateMachinesv1Alpha1MachineToClusterv1Alpha1MachineIfNecessary(
-	ctx context.Context, client ctrlruntimeclient.Client, kubeClient kubernetes.Interface) error {
+	ctx context.Context, client ctrlruntimeclient.Client, kubeClient kubernetes.Interface, finalizers Finalizers) error {
 
 	err := client.Get(ctx, types.NamespacedName{Name: machines.CRDName}, &apiextensionsv1beta1.CustomResourceDefinition{})
 	if err != nil {
@@ -152,7 +160,7 @@ func MigrateMachinesv1Alpha1MachineToClusterv1Alpha1MachineIfNecessary(
 		return fmt.Errorf("error when checking for existence of 'machines.cluster.k8s.io' crd: %v", err)
 	}
 
-	if err := migrateMachines(ctx, client, kubeClient); err != nil {
+	if err := migrateMachines(ctx, client, kubeClient, finalizers); err != nil {
 		return fmt.Errorf("failed to migrate machines: %v", err)
 	}
 	glog.Infof("Attempting to delete CRD %s", machines.CRDName)
@@ -163,7 +171,7 @@ func MigrateMachinesv1Alpha1MachineToClusterv1Alpha1MachineIfNecessary(
 	return nil
 }
 
-func migrateMachines(ctx context.Context, client ctrlruntimeclient.Client, kubeClient kubernetes.Interface) error {
+func migrateMachines(ctx context.Context, client ctrlruntimeclient.Client, kubeClient kubernetes.Interface, finalizers Finalizers) error {
 	glog.Infof("Starting migration for machine.machines.k8s.io/v1alpha1 to machine.cluster.k8s.io/v1alpha1")
 
 	// Get machinesv1Alpha1Machines
@@ -180,14 +188,10 @@ func migrateMachines(ctx context.Context, client ctrlruntimeclient.Client, kubeC
 	// failes for whatever reason
 	for _, machinesV1Alpha1Machine := range machinesv1Alpha1Machines.Items {
 		glog.Infof("Starting migration for machine.machines.k8s.io/v1alpha1 %s", machinesV1Alpha1Machine.Name)
-		convertedClusterv1alpha1Machine := &clusterv1alpha1.Machine{}
-		err := conversions.Convert_MachinesV1alpha1Machine_To_ClusterV1alpha1Machine(&machinesV1Alpha1Machine,
-			convertedClusterv1alpha1Machine)
+		convertedClusterv1alpha1Machine, err := convertMachine(&machinesV1Alpha1Machine, finalizers)
 		if err != nil {
-			return fmt.Errorf("failed to convert machinesV1alpha1.machine to clusterV1alpha1.machine name=%s err=%v",
-				machinesV1Alpha1Machine.Name, err)
+			return err
 		}
-		convertedClusterv1alpha1Machine.Finalizers = append(convertedClusterv1alpha1Machine.Finalizers, machinecontroller.FinalizerDeleteNode)
 
 		// Some providers need to update the provider instance to the new UID, we get the provider as early as possible
 		// to not fail in a half-migrated state when the providerconfig is invalid
@@ -259,7 +263,7 @@ func migrateMachines(ctx context.Context, client ctrlruntimeclient.Client, kubeC
 			return err
 		}
 
-		if sets.NewString(finalClusterV1Alpha1Machine.Finalizers...).Has(machinecontroller.FinalizerDeleteInstance) {
+		if sets.NewString(finalClusterV1Alpha1Machine.Finalizers...).Has(finalizers.DeleteInstance) {
 			glog.Infof("Attempting to update the UID at the cloud provider for machine.cluster.k8s.io/v1alpha1 %s", machinesV1Alpha1Machine.Name)
 			newMachineWithOldUID := finalClusterV1Alpha1Machine.DeepCopy()
 			newMachineWithOldUID.UID = machinesV1Alpha1Machine.UID
@@ -294,6 +298,27 @@ func migrateMachines(ctx context.Context, client ctrlruntimeclient.Client, kubeC
 	return nil
 }
 
+// convertMachine converts the machine and sets the configured finalizers. Machines of the old API carry the default
+// instance finalizer, which gets replaced, as only the configured finalizers ever get removed by the controller.
+func convertMachine(machinesV1Alpha1Machine *machinesv1alpha1.Machine, finalizers Finalizers) (*clusterv1alpha1.Machine, error) {
+	convertedClusterv1alpha1Machine := &clusterv1alpha1.Machine{}
+	err := conversions.Convert_MachinesV1alpha1Machine_To_ClusterV1alpha1Machine(machinesV1Alpha1Machine,
+		convertedClusterv1alpha1Machine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert machinesV1alpha1.machine to clusterV1alpha1.machine name=%s err=%v",
+			machinesV1Alpha1Machine.Name, err)
+	}
+
+	convertedFinalizers := sets.NewString(convertedClusterv1alpha1Machine.Finalizers...)
+	if convertedFinalizers.Has(machinecontroller.FinalizerDeleteInstance) {
+		convertedFinalizers.Delete(machinecontroller.FinalizerDeleteInstance)
+		convertedFinalizers.Insert(finalizers.DeleteInstance)
+	}
+	convertedFinalizers.Insert(finalizers.DeleteNode)
+	convertedClusterv1alpha1Machine.Finalizers = convertedFinalizers.List()
+	return convertedClusterv1alpha1Machine, nil
+}
+
 func ensureClusterV1Alpha1NodeOwnership(ctx context.Context, machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) error {
 	if machine.Spec.Name == "" {
 		machine.Spec.Name = machine.Name
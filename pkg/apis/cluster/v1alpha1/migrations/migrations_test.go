@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrations
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	machinecontroller "github.com/kubermatic/machine-controller/pkg/controller/machine"
+	machinesv1alpha1 "github.com/kubermatic/machine-controller/pkg/machines/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConvertMachineSetsConfiguredFinalizers(t *testing.T) {
+	tests := []struct {
+		name               string
+		finalizers         []string
+		expectedFinalizers []string
+	}{
+		{
+			name:               "machine with instance",
+			finalizers:         []string{machinecontroller.FinalizerDeleteInstance, "example.com/other"},
+			expectedFinalizers: []string{"example.com/delete-instance", "example.com/delete-node", "example.com/other"},
+		},
+		{
+			name:               "machine without instance",
+			expectedFinalizers: []string{"example.com/delete-node"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &machinesv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Finalizers: test.finalizers},
+			}
+			converted, err := convertMachine(machine, Finalizers{
+				DeleteInstance: "example.com/delete-instance",
+				DeleteNode:     "example.com/delete-node",
+			})
+			if err != nil {
+				t.Fatalf("failed to convert machine: %v", err)
+			}
+			if diff := deep.Equal(converted.Finalizers, test.expectedFinalizers); diff != nil {
+				t.Errorf("unexpected finalizers, diff: %v", diff)
+			}
+		})
+	}
+}
@@ -45,6 +45,16 @@ type UserDataRequest struct {
 	CloudConfig           string
 	DNSIPs                []net.IP
 	ExternalCloudProvider bool
+	PhoneHome             *PhoneHome
+}
+
+// PhoneHome configures the node to report the result of its bootstrap
+// to the machine-controller.
+type PhoneHome struct {
+	// URL the node posts its bootstrap status to
+	URL string
+	// Token authenticates the node against the machine-controller
+	Token string
 }
 
 // UserDataResponse contains the responded user data.
@@ -0,0 +1,279 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// The operations and results of audit records
+const (
+	AuditOperationCreate     = "create"
+	AuditOperationDelete     = "delete"
+	AuditOperationMigrateUID = "migrate-uid"
+	AuditOperationResize     = "resize"
+	AuditOperationStop       = "stop"
+	AuditOperationStart      = "start"
+
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+const redactedValue = "<redacted>"
+
+// sensitiveKeyRegexp matches the keys of provider spec fields whose values must not show up in the audit trail
+var sensitiveKeyRegexp = regexp.MustCompile(`(?i)(password|secret|token|accesskey|privatekey|credentials|clientkey|apikey)`)
+
+// AuditRecord describes a single mutation the controller performed against a cloud provider
+type AuditRecord struct {
+	Time       time.Time   `json:"time"`
+	Actor      string      `json:"actor"`
+	Machine    string      `json:"machine"`
+	MachineUID types.UID   `json:"machineUID"`
+	Provider   string      `json:"provider"`
+	Operation  string      `json:"operation"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Result     string      `json:"result"`
+	Error      string      `json:"error,omitempty"`
+	InstanceID string      `json:"instanceID,omitempty"`
+}
+
+// AuditSink persists audit records. Implementations must be safe for concurrent use
+type AuditSink interface {
+	Write(record AuditRecord) error
+}
+
+type fileAuditSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink returns an AuditSink which appends the records as JSON lines to the given file.
+// Every record gets synced to disk before Write returns
+func NewFileAuditSink(path string) (AuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+func (s *fileAuditSink) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %v", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	return s.file.Sync()
+}
+
+// Auditor records all mutations of cloud providers in an AuditSink
+type Auditor struct {
+	sink  AuditSink
+	actor string
+	now   func() time.Time
+}
+
+// NewAuditor returns an Auditor which records the mutations as performed by actor, the identity of the controller
+func NewAuditor(sink AuditSink, actor string) *Auditor {
+	return &Auditor{sink: sink, actor: actor, now: time.Now}
+}
+
+// Wrap returns a cloudprovider which records its mutations and implements the same optional interfaces as the
+// given cloudprovider. The cloudprovider is returned unchanged if the Auditor is nil
+func (a *Auditor) Wrap(actualProvider cloudprovidertypes.Provider, cloudProvider providerconfig.CloudProvider) cloudprovidertypes.Provider {
+	if a == nil {
+		return actualProvider
+	}
+	wrapper := &auditingWrapper{Provider: actualProvider, auditor: a, cloudProvider: string(cloudProvider)}
+	return withCapabilitiesOf(wrapper, actualProvider)
+}
+
+func (a *Auditor) record(machine *v1alpha1.Machine, cloudProvider, operation string, parameters interface{}, instanceID string, err error) {
+	record := AuditRecord{
+		Time:       a.now().UTC(),
+		Actor:      a.actor,
+		Machine:    machine.Namespace + "/" + machine.Name,
+		MachineUID: machine.UID,
+		Provider:   cloudProvider,
+		Operation:  operation,
+		Parameters: parameters,
+		Result:     AuditResultSuccess,
+		InstanceID: instanceID,
+	}
+	if err != nil {
+		record.Result = AuditResultFailure
+		record.Error = err.Error()
+	}
+	// The mutation already happened, failing it now would only make the controller repeat it
+	if writeErr := a.sink.Write(record); writeErr != nil {
+		glog.Errorf("Failed to write audit record for %s of machine %s: %v", operation, record.Machine, writeErr)
+	}
+}
+
+// RedactedProviderSpec returns the provider spec of the machine with the values of all sensitive fields replaced,
+// so it can be handed to systems outside of the cluster
+func RedactedProviderSpec(machine *v1alpha1.Machine) interface{} {
+	if machine.Spec.ProviderSpec.Value == nil {
+		return nil
+	}
+	var spec interface{}
+	if err := json.Unmarshal(machine.Spec.ProviderSpec.Value.Raw, &spec); err != nil {
+		return redactedValue
+	}
+	return redact(spec)
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if _, isString := field.(string); isString && sensitiveKeyRegexp.MatchString(key) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redact(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return value
+}
+
+type auditingWrapper struct {
+	cloudprovidertypes.Provider
+	auditor       *Auditor
+	cloudProvider string
+}
+
+// Create calls the underlying cloudproviders Create and records the result. The userdata is not recorded as it
+// contains the bootstrap credentials of the node
+func (w *auditingWrapper) Create(m *v1alpha1.Machine, mcd *cloudprovidertypes.MachineCreateDeleteData, userdata string) (instance.Instance, error) {
+	inst, err := w.Provider.Create(m, mcd, userdata)
+	instanceID := ""
+	if err == nil && inst != nil {
+		instanceID = inst.ID()
+	}
+	parameters := map[string]interface{}{"providerSpec": RedactedProviderSpec(m)}
+	w.auditor.record(m, w.cloudProvider, AuditOperationCreate, parameters, instanceID, err)
+	return inst, err
+}
+
+// Cleanup calls the underlying cloudproviders Cleanup and records the result
+func (w *auditingWrapper) Cleanup(m *v1alpha1.Machine, mcd *cloudprovidertypes.MachineCreateDeleteData) (bool, error) {
+	completelyGone, err := w.Provider.Cleanup(m, mcd)
+	parameters := map[string]interface{}{"providerSpec": RedactedProviderSpec(m), "completelyGone": completelyGone}
+	w.auditor.record(m, w.cloudProvider, AuditOperationDelete, parameters, "", err)
+	return completelyGone, err
+}
+
+// MigrateUID calls the underlying cloudproviders MigrateUID and records the result
+func (w *auditingWrapper) MigrateUID(m *v1alpha1.Machine, new types.UID) error {
+	err := w.Provider.MigrateUID(m, new)
+	w.auditor.record(m, w.cloudProvider, AuditOperationMigrateUID, map[string]interface{}{"newUID": new}, "", err)
+	return err
+}
+
+// ValidateCredentials calls the underlying cloudproviders ValidateCredentials
+func (w *auditingWrapper) ValidateCredentials(spec v1alpha1.MachineSpec) error {
+	validator, ok := w.Provider.(cloudprovidertypes.CredentialsValidator)
+	if !ok {
+		return nil
+	}
+	return validator.ValidateCredentials(spec)
+}
+
+// InstanceType calls the underlying cloudproviders InstanceType
+func (w *auditingWrapper) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	typer, ok := w.Provider.(cloudprovidertypes.InstanceTyper)
+	if !ok {
+		return "", nil
+	}
+	return typer.InstanceType(spec)
+}
+
+// OnlyInstanceTypeChanged calls the underlying cloudproviders OnlyInstanceTypeChanged
+func (w *auditingWrapper) OnlyInstanceTypeChanged(old, new v1alpha1.MachineSpec) (bool, error) {
+	resizer, ok := w.Provider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return false, nil
+	}
+	return resizer.OnlyInstanceTypeChanged(old, new)
+}
+
+// InstanceTypes calls the underlying cloudproviders InstanceTypes
+func (w *auditingWrapper) InstanceTypes(machine *v1alpha1.Machine) (string, string, error) {
+	resizer, ok := w.Provider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return "", "", nil
+	}
+	return resizer.InstanceTypes(machine)
+}
+
+// Resize calls the underlying cloudproviders Resize and records the result
+func (w *auditingWrapper) Resize(machine *v1alpha1.Machine) (bool, error) {
+	resizer, ok := w.Provider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return false, fmt.Errorf("resizing instances is not supported")
+	}
+	resized, err := resizer.Resize(machine)
+	parameters := map[string]interface{}{"providerSpec": RedactedProviderSpec(machine), "resized": resized}
+	w.auditor.record(machine, w.cloudProvider, AuditOperationResize, parameters, "", err)
+	return resized, err
+}
+
+// StopInstance calls the underlying cloudproviders StopInstance and records the result
+func (w *auditingWrapper) StopInstance(machine *v1alpha1.Machine) (bool, error) {
+	starter, ok := w.Provider.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("stopping instances is not supported")
+	}
+	stopped, err := starter.StopInstance(machine)
+	w.auditor.record(machine, w.cloudProvider, AuditOperationStop, map[string]interface{}{"stopped": stopped}, "", err)
+	return stopped, err
+}
+
+// StartInstance calls the underlying cloudproviders StartInstance and records the result
+func (w *auditingWrapper) StartInstance(machine *v1alpha1.Machine) (bool, error) {
+	starter, ok := w.Provider.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("starting instances is not supported")
+	}
+	started, err := starter.StartInstance(machine)
+	w.auditor.record(machine, w.cloudProvider, AuditOperationStart, map[string]interface{}{"started": started}, "", err)
+	return started, err
+}
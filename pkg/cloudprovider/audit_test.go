@@ -0,0 +1,255 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+type memoryAuditSink struct {
+	lock    sync.Mutex
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) Write(record AuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+type failingCleanupProvider struct {
+	cloudprovidertypes.Provider
+}
+
+func (p *failingCleanupProvider) Cleanup(_ *v1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData) (bool, error) {
+	return false, errors.New("instance is protected")
+}
+
+func auditTestMachine() *v1alpha1.Machine {
+	return &v1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "machine1", UID: "uid1"},
+		Spec: v1alpha1.MachineSpec{
+			ProviderSpec: v1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{
+				"cloudProvider": "fake",
+				"cloudProviderSpec": {
+					"instanceType": "m5.large",
+					"secretAccessKey": "very-secret",
+					"accessKeyId": {"secretKeyRef": {"namespace": "kube-system", "name": "aws", "key": "accessKeyId"}},
+					"users": [{"password": "hunter2"}]
+				}
+			}`)}},
+		},
+	}
+}
+
+func TestAuditorRecordsCreateAndDelete(t *testing.T) {
+	sink := &memoryAuditSink{}
+	auditor := NewAuditor(sink, "machine-controller@host1")
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	auditor.now = func() time.Time { return now }
+
+	machine := auditTestMachine()
+	prov := auditor.Wrap(fake.New(nil), providerconfig.CloudProviderFake)
+	if _, err := prov.Create(machine, nil, "#cloud-config"); err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	if _, err := prov.Cleanup(machine, nil); err != nil {
+		t.Fatalf("failed to delete instance: %v", err)
+	}
+	prov = auditor.Wrap(&failingCleanupProvider{Provider: fake.New(nil)}, providerconfig.CloudProviderFake)
+	if _, err := prov.Cleanup(machine, nil); err == nil {
+		t.Fatal("expected the cleanup to fail")
+	}
+
+	expectedSpec := map[string]interface{}{
+		"cloudProvider": "fake",
+		"cloudProviderSpec": map[string]interface{}{
+			"instanceType":    "m5.large",
+			"secretAccessKey": redactedValue,
+			"accessKeyId": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"namespace": "kube-system", "name": "aws", "key": "accessKeyId"},
+			},
+			"users": []interface{}{map[string]interface{}{"password": redactedValue}},
+		},
+	}
+	expected := []AuditRecord{
+		{
+			Time:       now,
+			Actor:      "machine-controller@host1",
+			Machine:    "kube-system/machine1",
+			MachineUID: "uid1",
+			Provider:   "fake",
+			Operation:  AuditOperationCreate,
+			Parameters: map[string]interface{}{"providerSpec": expectedSpec},
+			Result:     AuditResultSuccess,
+		},
+		{
+			Time:       now,
+			Actor:      "machine-controller@host1",
+			Machine:    "kube-system/machine1",
+			MachineUID: "uid1",
+			Provider:   "fake",
+			Operation:  AuditOperationDelete,
+			Parameters: map[string]interface{}{"providerSpec": expectedSpec, "completelyGone": true},
+			Result:     AuditResultSuccess,
+		},
+		{
+			Time:       now,
+			Actor:      "machine-controller@host1",
+			Machine:    "kube-system/machine1",
+			MachineUID: "uid1",
+			Provider:   "fake",
+			Operation:  AuditOperationDelete,
+			Parameters: map[string]interface{}{"providerSpec": expectedSpec, "completelyGone": false},
+			Result:     AuditResultFailure,
+			Error:      "instance is protected",
+		},
+	}
+	if !reflect.DeepEqual(sink.records, expected) {
+		t.Errorf("expected records\n%+v\ngot\n%+v", expected, sink.records)
+	}
+}
+
+func TestAuditorNil(t *testing.T) {
+	var auditor *Auditor
+	prov := fake.New(nil)
+	if wrapped := auditor.Wrap(prov, providerconfig.CloudProviderFake); wrapped != prov {
+		t.Error("expected a nil auditor to return the provider unchanged")
+	}
+}
+
+type stoppingProvider struct {
+	cloudprovidertypes.Provider
+}
+
+func (p *stoppingProvider) StopInstance(_ *v1alpha1.Machine) (bool, error) {
+	return true, nil
+}
+
+func (p *stoppingProvider) StartInstance(_ *v1alpha1.Machine) (bool, error) {
+	return true, nil
+}
+
+func TestWrappersKeepCapabilitiesOfProvider(t *testing.T) {
+	auditor := NewAuditor(&memoryAuditSink{}, "machine-controller@host1")
+	tests := []struct {
+		name            string
+		provider        cloudprovidertypes.Provider
+		isValidator     bool
+		isInstanceTyper bool
+		isResizer       bool
+		isStarter       bool
+	}{
+		{
+			name:        "credentials validator",
+			provider:    fake.New(nil),
+			isValidator: true,
+		},
+		{
+			name:      "instance starter",
+			provider:  &stoppingProvider{Provider: &failingCleanupProvider{}},
+			isStarter: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapped := map[string]cloudprovidertypes.Provider{
+				"validation cache": NewValidationCacheWrappingCloudProvider(test.provider, nil),
+				"audit":            auditor.Wrap(test.provider, providerconfig.CloudProviderFake),
+			}
+			for wrapper, prov := range wrapped {
+				if _, ok := prov.(cloudprovidertypes.CredentialsValidator); ok != test.isValidator {
+					t.Errorf("expected the %s wrapper to be a CredentialsValidator: %v, got %v", wrapper, test.isValidator, ok)
+				}
+				if _, ok := prov.(cloudprovidertypes.InstanceTyper); ok != test.isInstanceTyper {
+					t.Errorf("expected the %s wrapper to be an InstanceTyper: %v, got %v", wrapper, test.isInstanceTyper, ok)
+				}
+				if _, ok := prov.(cloudprovidertypes.InstanceResizer); ok != test.isResizer {
+					t.Errorf("expected the %s wrapper to be an InstanceResizer: %v, got %v", wrapper, test.isResizer, ok)
+				}
+				if _, ok := prov.(cloudprovidertypes.InstanceStarter); ok != test.isStarter {
+					t.Errorf("expected the %s wrapper to be an InstanceStarter: %v, got %v", wrapper, test.isStarter, ok)
+				}
+			}
+		})
+	}
+
+	sink := &memoryAuditSink{}
+	prov := NewAuditor(sink, "machine-controller@host1").Wrap(&stoppingProvider{Provider: fake.New(nil)}, providerconfig.CloudProviderFake)
+	if _, err := prov.(cloudprovidertypes.InstanceStarter).StopInstance(auditTestMachine()); err != nil {
+		t.Fatalf("failed to stop instance: %v", err)
+	}
+	if len(sink.records) != 1 || sink.records[0].Operation != AuditOperationStop {
+		t.Errorf("expected the stop to be recorded, got %+v", sink.records)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	for i := 0; i < 2; i++ {
+		// Reopening the file must keep the existing records
+		sink, err := NewFileAuditSink(path)
+		if err != nil {
+			t.Fatalf("failed to open audit log: %v", err)
+		}
+		if err := sink.Write(AuditRecord{Machine: "kube-system/machine1", Operation: AuditOperationCreate, Result: AuditResultSuccess}); err != nil {
+			t.Fatalf("failed to write audit record: %v", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d:\n%s", len(lines), content)
+	}
+	record := AuditRecord{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if record.Machine != "kube-system/machine1" || record.Operation != AuditOperationCreate {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
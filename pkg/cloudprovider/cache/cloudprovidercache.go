@@ -37,9 +37,10 @@ func New() *CloudproviderCache {
 }
 
 // Get returns an error indicating the result of the validation and a boolean indicating if
-// it got a cache hit or miss
-func (c *CloudproviderCache) Get(machineSpec clusterv1alpha1.MachineSpec) (error, bool, error) {
-	id, err := getID(machineSpec)
+// it got a cache hit or miss. The secretVersions identify the content of the secrets the machineSpec
+// references, so a result gets invalidated once one of them is rotated
+func (c *CloudproviderCache) Get(machineSpec clusterv1alpha1.MachineSpec, secretVersions string) (error, bool, error) {
+	id, err := getID(machineSpec, secretVersions)
 	if err != nil {
 		return nil, false, err
 	}
@@ -60,9 +61,9 @@ func (c *CloudproviderCache) Get(machineSpec clusterv1alpha1.MachineSpec) (error
 	return errVal, true, nil
 }
 
-// Set sets the passed value for the given machineSpec
-func (c *CloudproviderCache) Set(machineSpec clusterv1alpha1.MachineSpec, val error) error {
-	id, err := getID(machineSpec)
+// Set sets the passed value for the given machineSpec and secretVersions
+func (c *CloudproviderCache) Set(machineSpec clusterv1alpha1.MachineSpec, secretVersions string, val error) error {
+	id, err := getID(machineSpec, secretVersions)
 	if err != nil {
 		return err
 	}
@@ -71,11 +72,12 @@ func (c *CloudproviderCache) Set(machineSpec clusterv1alpha1.MachineSpec, val er
 	return nil
 }
 
-func getID(machineSpec clusterv1alpha1.MachineSpec) (string, error) {
+func getID(machineSpec clusterv1alpha1.MachineSpec, secretVersions string) (string, error) {
 	b, err := json.Marshal(machineSpec.ProviderSpec)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal MachineSpec: %v", err)
 	}
+	b = append(b, []byte(secretVersions)...)
 
 	sum := sha256.Sum256(b)
 	var sumSlice []byte
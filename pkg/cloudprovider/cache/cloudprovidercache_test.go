@@ -34,10 +34,10 @@ func TestCloudproviderCache(t *testing.T) {
 	m1.Name = "hans"
 
 	// Test SET and GET
-	if err := cache.Set(m1, nil); err != nil {
+	if err := cache.Set(m1, "", nil); err != nil {
 		t.Fatalf("Error setting cache value for m1: %v", err)
 	}
-	val, exists, err := cache.Get(m1)
+	val, exists, err := cache.Get(m1, "")
 	if err != nil {
 		t.Fatalf("Error when getting m1 from cache: %v", err)
 	}
@@ -50,7 +50,7 @@ func TestCloudproviderCache(t *testing.T) {
 
 	// Test metadata gets ignored by cache
 	m1.Name = "wurst"
-	val, exists, err = cache.Get(m1)
+	val, exists, err = cache.Get(m1, "")
 	if err != nil {
 		t.Fatalf("Error getting m1 from cache after changing name: %v", err)
 	}
@@ -63,7 +63,7 @@ func TestCloudproviderCache(t *testing.T) {
 
 	// Test taints get ignored by cache
 	m1.Taints = []corev1.Taint{{Key: "hello", Value: "world"}}
-	val, exists, err = cache.Get(m1)
+	val, exists, err = cache.Get(m1, "")
 	if err != nil {
 		t.Fatalf("Error getting m1 from cache after adding taint: %v", err)
 	}
@@ -76,7 +76,7 @@ func TestCloudproviderCache(t *testing.T) {
 
 	// Test versions field gets ignored by cache
 	m1.Versions.Kubelet = "1.13.0"
-	val, exists, err = cache.Get(m1)
+	val, exists, err = cache.Get(m1, "")
 	if err != nil {
 		t.Fatalf("Error getting m1 from cache after adding kubelet version: %v", err)
 	}
@@ -90,7 +90,7 @@ func TestCloudproviderCache(t *testing.T) {
 	// Test ProviderSpec does not get ignored by cache
 	m2 := clusterv1alpha1.MachineSpec{}
 	m2.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(`{"key":"m2"}`)}
-	val, exists, err = cache.Get(m2)
+	val, exists, err = cache.Get(m2, "")
 	if err != nil {
 		t.Fatalf("Error getting m2 from cache: %v", err)
 	}
@@ -105,10 +105,10 @@ func TestCloudproviderCache(t *testing.T) {
 	m3 := clusterv1alpha1.MachineSpec{}
 	m3.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(`{"key":"m3"}`)}
 	errMsg := "Thou shall not pass"
-	if err := cache.Set(m3, errors.New(errMsg)); err != nil {
+	if err := cache.Set(m3, "", errors.New(errMsg)); err != nil {
 		t.Fatalf("Error setting cache value for m3: %v", err)
 	}
-	val, exists, err = cache.Get(m3)
+	val, exists, err = cache.Get(m3, "")
 	if err != nil {
 		t.Fatalf("Error getting m3 from cache: %v", err)
 	}
@@ -118,4 +118,19 @@ func TestCloudproviderCache(t *testing.T) {
 	if val.Error() != errMsg {
 		t.Errorf("Expected val for m3 to be %s but was %v", errMsg, val)
 	}
+
+	// Test a rotated secret invalidates the cached result
+	if err := cache.Set(m3, "kube-system/credentials=1", errors.New(errMsg)); err != nil {
+		t.Fatalf("Error setting cache value for m3 with secret versions: %v", err)
+	}
+	if _, exists, err = cache.Get(m3, "kube-system/credentials=1"); err != nil || !exists {
+		t.Errorf("Expected val to exist when getting m3 with unchanged secret versions from cache, err: %v", err)
+	}
+	_, exists, err = cache.Get(m3, "kube-system/credentials=2")
+	if err != nil {
+		t.Fatalf("Error getting m3 with rotated secret from cache: %v", err)
+	}
+	if exists {
+		t.Error("Expected val to not exist when getting m3 from cache after its secret got rotated")
+	}
 }
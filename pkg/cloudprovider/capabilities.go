@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+)
+
+// capabilityWrapper is a wrapper around a cloudprovider which implements all optional interfaces of cloudproviders
+type capabilityWrapper interface {
+	cloudprovidertypes.Provider
+	cloudprovidertypes.CredentialsValidator
+	cloudprovidertypes.InstanceTyper
+	cloudprovidertypes.InstanceResizer
+	cloudprovidertypes.InstanceStarter
+}
+
+// withCapabilitiesOf returns the wrapper as a cloudprovider which only implements the optional interfaces
+// actualProvider implements, so the controller does not mistake the wrapped cloudprovider for one that can e.g.
+// resize instances
+func withCapabilitiesOf(wrapper capabilityWrapper, actualProvider cloudprovidertypes.Provider) cloudprovidertypes.Provider {
+	type (
+		provider  = cloudprovidertypes.Provider
+		validator = cloudprovidertypes.CredentialsValidator
+		typer     = cloudprovidertypes.InstanceTyper
+		resizer   = cloudprovidertypes.InstanceResizer
+		starter   = cloudprovidertypes.InstanceStarter
+	)
+
+	capabilities := 0
+	if _, ok := actualProvider.(validator); ok {
+		capabilities |= 1
+	}
+	if _, ok := actualProvider.(typer); ok {
+		capabilities |= 2
+	}
+	if _, ok := actualProvider.(resizer); ok {
+		capabilities |= 4
+	}
+	if _, ok := actualProvider.(starter); ok {
+		capabilities |= 8
+	}
+
+	w := wrapper
+	switch capabilities {
+	case 1:
+		return struct {
+			provider
+			validator
+		}{w, w}
+	case 2:
+		return struct {
+			provider
+			typer
+		}{w, w}
+	case 3:
+		return struct {
+			provider
+			validator
+			typer
+		}{w, w, w}
+	case 4:
+		return struct {
+			provider
+			resizer
+		}{w, w}
+	case 5:
+		return struct {
+			provider
+			validator
+			resizer
+		}{w, w, w}
+	case 6:
+		return struct {
+			provider
+			typer
+			resizer
+		}{w, w, w}
+	case 7:
+		return struct {
+			provider
+			validator
+			typer
+			resizer
+		}{w, w, w, w}
+	case 8:
+		return struct {
+			provider
+			starter
+		}{w, w}
+	case 9:
+		return struct {
+			provider
+			validator
+			starter
+		}{w, w, w}
+	case 10:
+		return struct {
+			provider
+			typer
+			starter
+		}{w, w, w}
+	case 11:
+		return struct {
+			provider
+			validator
+			typer
+			starter
+		}{w, w, w, w}
+	case 12:
+		return struct {
+			provider
+			resizer
+			starter
+		}{w, w, w}
+	case 13:
+		return struct {
+			provider
+			validator
+			resizer
+			starter
+		}{w, w, w, w}
+	case 14:
+		return struct {
+			provider
+			typer
+			resizer
+			starter
+		}{w, w, w, w}
+	case 15:
+		return wrapper
+	}
+	return struct{ provider }{w}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Config contains the PEM encoded CA bundle and client certificate used to connect to
+// cloud provider endpoints which require mutual TLS
+type Config struct {
+	// CACert is used to verify the server certificate instead of the system roots
+	CACert string
+	// ClientCert and ClientKey get presented to the server during the TLS handshake
+	ClientCert string
+	ClientKey  string
+}
+
+// Validate checks that the CA bundle and the client certificate parse and that the
+// client certificate matches its key
+func (c *Config) Validate() error {
+	_, err := c.TLSConfig(false)
+	return err
+}
+
+// IsSet returns true if a CA or a client certificate is configured
+func (c *Config) IsSet() bool {
+	return c.CACert != "" || c.ClientCert != "" || c.ClientKey != ""
+}
+
+// TLSConfig returns a tls.Config which trusts the CA bundle and presents the client certificate
+func (c *Config) TLSConfig(insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+
+	if c.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, errors.New("failed to parse CA certificate: no PEM encoded certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert == "" && c.ClientKey == "" {
+		return tlsConfig, nil
+	}
+	if c.ClientCert == "" || c.ClientKey == "" {
+		return nil, errors.New("client certificate and client key must be set together")
+	}
+	// X509KeyPair also verifies that the public key of the certificate matches the private key
+	cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+	return string(b)
+}
+
+func TestValidate(t *testing.T) {
+	clientCert := readTestdata(t, "client.crt")
+	clientKey := readTestdata(t, "client.key")
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:   "ca only",
+			config: Config{CACert: clientCert},
+		},
+		{
+			name:   "client certificate",
+			config: Config{ClientCert: clientCert, ClientKey: clientKey},
+		},
+		{
+			name:    "invalid ca",
+			config:  Config{CACert: "not a certificate"},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate without key",
+			config:  Config{ClientCert: clientCert},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate does not match key",
+			config:  Config{ClientCert: clientCert, ClientKey: readTestdata(t, "other.key")},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTLSConfigPresentsClientCertificate(t *testing.T) {
+	clientCert := readTestdata(t, "client.crt")
+	block, _ := pem.Decode([]byte(clientCert))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse client certificate: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	config := Config{
+		CACert:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+		ClientCert: clientCert,
+		ClientKey:  readTestdata(t, "client.key"),
+	}
+	tlsConfig, err := config.TLSConfig(false)
+	if err != nil {
+		t.Fatalf("failed to get tls config: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Without the client certificate the server must reject the handshake
+	config.ClientCert, config.ClientKey = "", ""
+	tlsConfig, err = config.TLSConfig(false)
+	if err != nil {
+		t.Fatalf("failed to get tls config: %v", err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("expected request without client certificate to fail")
+	}
+}
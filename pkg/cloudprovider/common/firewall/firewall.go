@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Rules and naming of the cloud firewalls the providers manage per MachineDeployment.
+//
+
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/placementgroup"
+)
+
+const (
+	// DirectionIn matches traffic to the instances
+	DirectionIn = "in"
+	// DirectionOut matches traffic from the instances
+	DirectionOut = "out"
+
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolICMP = "icmp"
+)
+
+// Config is the firewall which gets created for a MachineDeployment and attached to all of its instances
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule allows traffic of a protocol and port range from or to a set of networks
+type Rule struct {
+	// Direction is either in or out
+	Direction string `json:"direction"`
+	// Protocol is one of tcp, udp or icmp
+	Protocol string `json:"protocol"`
+	// Port is a single port like 22 or a range like 30000-32767. It is required for tcp and udp and must not be
+	// set for icmp
+	Port string `json:"port,omitempty"`
+	// CIDRs are the source networks of inbound and the destination networks of outbound rules
+	CIDRs []string `json:"cidrs"`
+}
+
+// Validate checks the firewall rules, a nil config is valid
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Rules) == 0 {
+		return errors.New("at least one rule is required")
+	}
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if r.Direction != DirectionIn && r.Direction != DirectionOut {
+		return fmt.Errorf("direction must be %s or %s, got %q", DirectionIn, DirectionOut, r.Direction)
+	}
+
+	switch r.Protocol {
+	case ProtocolTCP, ProtocolUDP:
+		if err := validatePort(r.Port); err != nil {
+			return err
+		}
+	case ProtocolICMP:
+		if r.Port != "" {
+			return fmt.Errorf("port must not be set for protocol %s", ProtocolICMP)
+		}
+	default:
+		return fmt.Errorf("protocol must be one of %s, %s or %s, got %q", ProtocolTCP, ProtocolUDP, ProtocolICMP, r.Protocol)
+	}
+
+	if len(r.CIDRs) == 0 {
+		return errors.New("at least one cidr is required")
+	}
+	for _, cidr := range r.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q: %v", cidr, err)
+		}
+	}
+	return nil
+}
+
+func validatePort(port string) error {
+	if port == "" {
+		return errors.New("port is required for protocols tcp and udp")
+	}
+	bounds := strings.SplitN(port, "-", 2)
+	var numbers []int
+	for _, bound := range bounds {
+		n, err := strconv.Atoi(bound)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q, must be a number or a range between 1 and 65535", port)
+		}
+		numbers = append(numbers, n)
+	}
+	if len(numbers) == 2 && numbers[0] > numbers[1] {
+		return fmt.Errorf("invalid port range %q, the start must not be greater than the end", port)
+	}
+	return nil
+}
+
+// Name returns the name of the firewall shared by all machines of the MachineDeployment the machine belongs to
+func Name(machine *v1alpha1.Machine) (string, error) {
+	return placementgroup.Name(machine)
+}
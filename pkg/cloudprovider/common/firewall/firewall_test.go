@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        *Config
+		expectedError bool
+	}{
+		{
+			name: "no firewall",
+		},
+		{
+			name: "valid rules",
+			config: &Config{Rules: []Rule{
+				{Direction: DirectionIn, Protocol: ProtocolTCP, Port: "22", CIDRs: []string{"10.0.0.0/8"}},
+				{Direction: DirectionIn, Protocol: ProtocolUDP, Port: "30000-32767", CIDRs: []string{"0.0.0.0/0", "::/0"}},
+				{Direction: DirectionOut, Protocol: ProtocolICMP, CIDRs: []string{"0.0.0.0/0"}},
+			}},
+		},
+		{
+			name:          "no rules",
+			config:        &Config{},
+			expectedError: true,
+		},
+		{
+			name:          "invalid direction",
+			config:        &Config{Rules: []Rule{{Direction: "both", Protocol: ProtocolTCP, Port: "22", CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "invalid protocol",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: "gre", CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "tcp without port",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolTCP, CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "icmp with port",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolICMP, Port: "22", CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "port out of range",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolTCP, Port: "65536", CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "reversed port range",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolTCP, Port: "443-80", CIDRs: []string{"10.0.0.0/8"}}}},
+			expectedError: true,
+		},
+		{
+			name:          "no cidrs",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolTCP, Port: "22"}}},
+			expectedError: true,
+		},
+		{
+			name:          "invalid cidr",
+			config:        &Config{Rules: []Rule{{Direction: DirectionIn, Protocol: ProtocolTCP, Port: "22", CIDRs: []string{"10.0.0.1"}}}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Validate()
+			if test.expectedError && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.expectedError && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Recording of the cloud identity (IAM role, service account, managed identity) an instance got bound to.
+//
+
+package identity
+
+import (
+	"fmt"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Record stores the identity the instance of the machine got bound to in the provider status of the machine
+func Record(data *cloudprovidertypes.MachineCreateDeleteData, machine *v1alpha1.Machine, identity string) error {
+	var modifyErr error
+	if _, err := data.Updater(machine, func(m *v1alpha1.Machine) {
+		providerStatus, err := providerconfig.GetProviderStatus(m.Status.ProviderStatus)
+		if err != nil {
+			modifyErr = fmt.Errorf("failed to get provider status: %v", err)
+			return
+		}
+		providerStatus.Identity = identity
+		if m.Status.ProviderStatus, err = providerStatus.RawExtension(); err != nil {
+			modifyErr = fmt.Errorf("failed to marshal provider status: %v", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to record identity %s: %v", identity, err)
+	}
+	return modifyErr
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"testing"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestRecord(t *testing.T) {
+	status, err := (&providerconfig.ProviderStatus{InstanceType: "t3.large"}).RawExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := &v1alpha1.Machine{Status: v1alpha1.MachineStatus{ProviderStatus: status}}
+	data := &cloudprovidertypes.MachineCreateDeleteData{
+		Updater: func(m *v1alpha1.Machine, modify func(*v1alpha1.Machine)) (*v1alpha1.Machine, error) {
+			modify(m)
+			return m, nil
+		},
+	}
+
+	if err := Record(data, machine, "arn:aws:iam::123456789012:role/nodes"); err != nil {
+		t.Fatalf("failed to record identity: %v", err)
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providerStatus.Identity != "arn:aws:iam::123456789012:role/nodes" {
+		t.Errorf("expected the identity to be recorded, got %q", providerStatus.Identity)
+	}
+	if providerStatus.InstanceType != "t3.large" {
+		t.Errorf("expected the rest of the provider status to be kept, got instance type %q", providerStatus.InstanceType)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Naming of the placement groups the providers manage per MachineDeployment.
+//
+
+package placementgroup
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+)
+
+// Name returns the name of the placement group shared by all machines of the MachineDeployment the
+// machine belongs to. The name stays the same across rollouts, as each rollout creates a new MachineSet.
+func Name(machine *v1alpha1.Machine) (string, error) {
+	deploymentName, ok := kuberneteshelper.MachineDeploymentName(machine)
+	if !ok {
+		return "", fmt.Errorf("machine %s/%s does not belong to a MachineDeployment", machine.Namespace, machine.Name)
+	}
+	return fmt.Sprintf("machine-controller-%s-%s", machine.Namespace, deploymentName), nil
+}
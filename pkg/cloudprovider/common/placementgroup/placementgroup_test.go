@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package placementgroup
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		name          string
+		machine       *v1alpha1.Machine
+		expectedName  string
+		expectedError bool
+	}{
+		{
+			name: "machine of a MachineDeployment",
+			machine: &v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "kube-system",
+				Labels:          map[string]string{"machine-template-hash": "12345"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: "my-workers-" + rand.SafeEncodeString("12345")}},
+			}},
+			expectedName: "machine-controller-kube-system-my-workers",
+		},
+		{
+			name: "machine of a standalone MachineSet",
+			machine: &v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "kube-system",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: "my-workers"}},
+			}},
+			expectedError: true,
+		},
+		{
+			name:          "standalone machine",
+			machine:       &v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system"}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, err := Name(test.machine)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %v, got %v", test.expectedError, err)
+			}
+			if name != test.expectedName {
+				t.Errorf("expected name %q, got %q", test.expectedName, name)
+			}
+		})
+	}
+}
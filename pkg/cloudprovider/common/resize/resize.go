@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Helpers for the providers which resize existing instances in place.
+//
+
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// OnlyCloudProviderSpecFieldChanged returns true if the value of the given field of the cloudProviderSpec
+// is the only difference between the given specs.
+func OnlyCloudProviderSpecFieldChanged(old, new v1alpha1.MachineSpec, field string) (bool, error) {
+	oldProviderSpec, oldValue, err := splitCloudProviderSpecField(old.ProviderSpec, field)
+	if err != nil {
+		return false, err
+	}
+	newProviderSpec, newValue, err := splitCloudProviderSpecField(new.ProviderSpec, field)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(oldValue, newValue) || !reflect.DeepEqual(oldProviderSpec, newProviderSpec) {
+		return false, nil
+	}
+
+	old.ProviderSpec.Value, new.ProviderSpec.Value = nil, nil
+	return apiequality.Semantic.DeepEqual(old, new), nil
+}
+
+// splitCloudProviderSpecField decodes the providerSpec and removes the given field from its cloudProviderSpec.
+// It returns the remaining providerSpec and the value of the field.
+func splitCloudProviderSpecField(providerSpec v1alpha1.ProviderSpec, field string) (map[string]interface{}, interface{}, error) {
+	decoded := map[string]interface{}{}
+	if providerSpec.Value != nil && len(providerSpec.Value.Raw) > 0 {
+		if err := json.Unmarshal(providerSpec.Value.Raw, &decoded); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode providerSpec: %v", err)
+		}
+	}
+	cloudProviderSpec, ok := decoded["cloudProviderSpec"].(map[string]interface{})
+	if !ok {
+		return decoded, nil, nil
+	}
+	value := cloudProviderSpec[field]
+	delete(cloudProviderSpec, field)
+	return decoded, value, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resize
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func machineSpec(kubelet, providerSpec string) v1alpha1.MachineSpec {
+	return v1alpha1.MachineSpec{
+		Versions:     v1alpha1.MachineVersionInfo{Kubelet: kubelet},
+		ProviderSpec: v1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(providerSpec)}},
+	}
+}
+
+func TestOnlyCloudProviderSpecFieldChanged(t *testing.T) {
+	old := machineSpec("1.14.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t2.medium","region":"eu-central-1"}}`)
+	tests := []struct {
+		name     string
+		new      v1alpha1.MachineSpec
+		expected bool
+	}{
+		{
+			name:     "field changed",
+			new:      machineSpec("1.14.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t2.large","region":"eu-central-1"}}`),
+			expected: true,
+		},
+		{
+			name:     "only formatting changed",
+			new:      machineSpec("1.14.1", `{"cloudProviderSpec":{"region":"eu-central-1","instanceType":"t2.medium"},"cloudProvider":"aws"}`),
+			expected: false,
+		},
+		{
+			name:     "other cloudProviderSpec field changed too",
+			new:      machineSpec("1.14.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t2.large","region":"eu-west-1"}}`),
+			expected: false,
+		},
+		{
+			name:     "providerSpec field changed too",
+			new:      machineSpec("1.14.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t2.large","region":"eu-central-1"},"operatingSystem":"ubuntu"}`),
+			expected: false,
+		},
+		{
+			name:     "machine spec changed too",
+			new:      machineSpec("1.14.2", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t2.large","region":"eu-central-1"}}`),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed, err := OnlyCloudProviderSpecFieldChanged(old, test.new, "instanceType")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, changed)
+			}
+		})
+	}
+}
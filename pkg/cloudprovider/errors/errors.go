@@ -46,3 +46,33 @@ func IsTerminalError(err error) (bool, common.MachineStatusError, string) {
 	}
 	return true, tError.Reason, tError.Message
 }
+
+// codedError is a transient error which keeps the error code the cloud provider returned
+type codedError struct {
+	error
+	code string
+}
+
+func (e codedError) Code() string {
+	return e.code
+}
+
+// WithCode annotates the given error with the error code of the cloud provider
+func WithCode(err error, code string) error {
+	if err == nil || code == "" {
+		return err
+	}
+	return codedError{error: err, code: code}
+}
+
+// ErrorCode returns the machine readable reason for the given error. That is the reason of
+// terminal errors or the error code of the cloud provider. It is empty if there is none.
+func ErrorCode(err error) string {
+	switch e := err.(type) {
+	case TerminalError:
+		return string(e.Reason)
+	case interface{ Code() string }:
+		return e.Code()
+	}
+	return ""
+}
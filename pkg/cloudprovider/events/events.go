@@ -0,0 +1,193 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Receiver for instance state change events pushed by cloud providers.
+// Supported are AWS EventBridge (via an API destination) and Azure Event Grid (via a webhook subscription).
+// Spot interruption warnings are only delivered by EventBridge.
+// All requests must carry the configured secret, either as bearer token or in the X-Api-Key header.
+// EventBridge and Event Grid only deliver to HTTPS endpoints, so the receiver has to be served via TLS unless
+// it listens on a loopback address behind a proxy which terminates TLS.
+//
+
+package events
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	awsInstanceStateChangeDetailType      = "EC2 Instance State-change Notification"
+	awsSpotInstanceInterruptionDetailType = "EC2 Spot Instance Interruption Warning"
+
+	azureSubscriptionValidationEventType = "Microsoft.EventGrid.SubscriptionValidationEvent"
+	azureResourceActionPrefix            = "Microsoft.Resources.Resource"
+
+	maxRequestBodySize = 1 << 20
+
+	// APIKeyHeader is the header which carries the secret if it is not sent as bearer token,
+	// e.g. by an EventBridge connection with API key authorization
+	APIKeyHeader = "X-Api-Key"
+)
+
+// InstanceStateEvent is a notification about a state change of a cloud provider instance
+type InstanceStateEvent struct {
+	// InstanceID is the provider specific ID of the instance
+	InstanceID string
+	// State is the new state of the instance as reported by the cloud provider
+	State string
+	// Interruption is true if the cloud provider is about to reclaim the instance, e.g. a spot interruption
+	Interruption bool
+}
+
+// Receiver is a http.Handler which accepts instance state change events and
+// publishes them on a channel
+type Receiver struct {
+	secret []byte
+	events chan InstanceStateEvent
+}
+
+// NewReceiver returns a new Receiver. Requests which do not carry the given secret get rejected
+func NewReceiver(secret []byte) *Receiver {
+	return &Receiver{secret: secret, events: make(chan InstanceStateEvent, 100)}
+}
+
+// Events returns the channel on which all received events get published
+func (r *Receiver) Events() <-chan InstanceStateEvent {
+	return r.events
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Events can lead to the deletion of machines, so they must never be accepted unauthenticated
+	if !r.authenticated(req) {
+		http.Error(w, "invalid secret", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Event Grid always sends a list of events, EventBridge a single object
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		r.handleAzureEvents(w, body)
+		return
+	}
+	r.handleAWSEvent(w, body)
+}
+
+func (r *Receiver) authenticated(req *http.Request) bool {
+	if len(r.secret) == 0 {
+		return false
+	}
+	secret := req.Header.Get(APIKeyHeader)
+	if secret == "" {
+		secret = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), r.secret) == 1
+}
+
+type awsEvent struct {
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		InstanceID     string `json:"instance-id"`
+		State          string `json:"state"`
+		InstanceAction string `json:"instance-action"`
+	} `json:"detail"`
+}
+
+func (r *Receiver) handleAWSEvent(w http.ResponseWriter, body []byte) {
+	event := awsEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if event.DetailType != awsInstanceStateChangeDetailType && event.DetailType != awsSpotInstanceInterruptionDetailType {
+		glog.V(4).Infof("Ignoring EventBridge event of type %q", event.DetailType)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if event.Detail.InstanceID == "" {
+		http.Error(w, "event does not contain an instance-id", http.StatusBadRequest)
+		return
+	}
+
+	if event.DetailType == awsSpotInstanceInterruptionDetailType {
+		r.publish(InstanceStateEvent{InstanceID: event.Detail.InstanceID, State: event.Detail.InstanceAction, Interruption: true})
+	} else {
+		r.publish(InstanceStateEvent{InstanceID: event.Detail.InstanceID, State: event.Detail.State})
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type azureEvent struct {
+	EventType string `json:"eventType"`
+	Subject   string `json:"subject"`
+	Data      struct {
+		ValidationCode string `json:"validationCode"`
+		OperationName  string `json:"operationName"`
+	} `json:"data"`
+}
+
+func (r *Receiver) handleAzureEvents(w http.ResponseWriter, body []byte) {
+	var events []azureEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode events: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		// Event Grid requires a handshake before it starts delivering events to a webhook
+		if event.EventType == azureSubscriptionValidationEventType {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(map[string]string{"validationResponse": event.Data.ValidationCode}); err != nil {
+				glog.Errorf("failed to write Event Grid validation response: %v", err)
+			}
+			return
+		}
+		if !strings.HasPrefix(event.EventType, azureResourceActionPrefix) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(event.Subject), "/providers/microsoft.compute/virtualmachines/") {
+			continue
+		}
+		r.publish(InstanceStateEvent{InstanceID: event.Subject, State: event.Data.OperationName})
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Receiver) publish(event InstanceStateEvent) {
+	glog.V(4).Infof("Received state change event for instance %q: %s", event.InstanceID, event.State)
+	select {
+	case r.events <- event:
+	default:
+		// The periodic resync will pick up the change, so dropping is fine
+		glog.Warningf("Dropping state change event for instance %q as the event queue is full", event.InstanceID)
+	}
+}
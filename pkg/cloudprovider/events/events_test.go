@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+const testSecret = "s3cr3t"
+
+func TestReceiver(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		header         http.Header
+		expectedStatus int
+		expectedBody   string
+		expectedEvents []InstanceStateEvent
+	}{
+		{
+			name: "aws instance state change",
+			body: `{
+  "detail-type": "EC2 Instance State-change Notification",
+  "source": "aws.ec2",
+  "detail": {"instance-id": "i-1234567890abcdef0", "state": "stopping"}
+}`,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []InstanceStateEvent{{InstanceID: "i-1234567890abcdef0", State: "stopping"}},
+		},
+		{
+			name: "aws spot interruption warning",
+			body: `{
+  "detail-type": "EC2 Spot Instance Interruption Warning",
+  "source": "aws.ec2",
+  "detail": {"instance-id": "i-1234567890abcdef0", "instance-action": "terminate"}
+}`,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []InstanceStateEvent{{InstanceID: "i-1234567890abcdef0", State: "terminate", Interruption: true}},
+		},
+		{
+			name:           "aws spot interruption warning with api key",
+			body:           `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-1234567890abcdef0", "instance-action": "terminate"}}`,
+			header:         http.Header{APIKeyHeader: []string{testSecret}},
+			expectedStatus: http.StatusOK,
+			expectedEvents: []InstanceStateEvent{{InstanceID: "i-1234567890abcdef0", State: "terminate", Interruption: true}},
+		},
+		{
+			name:           "aws spot interruption warning without secret",
+			body:           `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-1234567890abcdef0", "instance-action": "terminate"}}`,
+			header:         http.Header{},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "aws spot interruption warning with wrong secret",
+			body:           `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-1234567890abcdef0", "instance-action": "terminate"}}`,
+			header:         http.Header{"Authorization": []string{"Bearer wrong"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "azure subscription validation with wrong api key",
+			body:           `[{"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent", "data": {"validationCode": "512d38b6"}}]`,
+			header:         http.Header{APIKeyHeader: []string{"wrong"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "aws event of other type gets ignored",
+			body:           `{"detail-type": "AWS API Call via CloudTrail", "detail": {}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "aws event without instance-id",
+			body:           `{"detail-type": "EC2 Instance State-change Notification", "detail": {"state": "running"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "azure virtual machine event",
+			body: `[{
+  "eventType": "Microsoft.Resources.ResourceActionSuccess",
+  "subject": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1",
+  "data": {"operationName": "Microsoft.Compute/virtualMachines/deallocate/action"}
+},{
+  "eventType": "Microsoft.Resources.ResourceWriteSuccess",
+  "subject": "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic-1",
+  "data": {}
+}]`,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []InstanceStateEvent{{
+				InstanceID: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1",
+				State:      "Microsoft.Compute/virtualMachines/deallocate/action",
+			}},
+		},
+		{
+			name:           "azure subscription validation",
+			body:           `[{"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent", "data": {"validationCode": "512d38b6"}}]`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"validationResponse":"512d38b6"}`,
+		},
+		{
+			name:           "invalid body",
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := NewReceiver([]byte(testSecret))
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			req.Header = test.header
+			if req.Header == nil {
+				req.Header = http.Header{"Authorization": []string{"Bearer " + testSecret}}
+			}
+			resp := httptest.NewRecorder()
+
+			receiver.ServeHTTP(resp, req)
+
+			if resp.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, resp.Code)
+			}
+			if test.expectedBody != "" && strings.TrimSpace(resp.Body.String()) != test.expectedBody {
+				t.Errorf("expected body %q, got %q", test.expectedBody, resp.Body.String())
+			}
+
+			var receivedEvents []InstanceStateEvent
+			for len(receiver.events) > 0 {
+				receivedEvents = append(receivedEvents, <-receiver.events)
+			}
+			if diff := deep.Equal(receivedEvents, test.expectedEvents); diff != nil {
+				t.Errorf("unexpected events, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestReceiverWithoutSecret(t *testing.T) {
+	receiver := NewReceiver(nil)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-1234567890abcdef0"}}`))
+	req.Header.Set("Authorization", "Bearer ")
+	resp := httptest.NewRecorder()
+
+	receiver.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, resp.Code)
+	}
+	if len(receiver.events) != 0 {
+		t.Errorf("expected no events to be published")
+	}
+}
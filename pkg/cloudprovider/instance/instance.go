@@ -16,14 +16,38 @@ limitations under the License.
 
 package instance
 
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
 // Instance represents a instance on the cloud provider
 type Instance interface {
 	Name() string
 	ID() string
-	Addresses() []string
+	// Addresses returns the IPs and DNS names of the instance with their type
+	Addresses() map[string]corev1.NodeAddressType
 	Status() Status
 }
 
+// Tagger is implemented by instances which have key/value tags on the cloud provider
+type Tagger interface {
+	// Tags returns the tags of the instance as they are on the cloud provider, they may change at any time
+	Tags() map[string]string
+}
+
+// ProviderIDer is implemented by instances whose node does not get their ID in its providerID
+type ProviderIDer interface {
+	// ProviderID returns the ID of the instance as it appears in the providerID of its node
+	ProviderID() string
+}
+
+// CreationTimer is implemented by instances which know when they got created on the cloud provider
+type CreationTimer interface {
+	CreationTime() time.Time
+}
+
 type Status string
 
 const (
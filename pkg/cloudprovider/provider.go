@@ -18,6 +18,7 @@ package cloudprovider
 
 import (
 	"errors"
+	"fmt"
 
 	cloudprovidercache "github.com/kubermatic/machine-controller/pkg/cloudprovider/cache"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/aws"
@@ -28,15 +29,21 @@ import (
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/hetzner"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/kubevirt"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/linode"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/oci"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/openstack"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/packet"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/vsphere"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
 var (
 	cache = cloudprovidercache.New()
+	// credentialsCache holds the credentials validation results separately, as both are keyed by the provider spec
+	// and the versions of the secrets it references
+	credentialsCache = cloudprovidercache.New()
 
 	// ErrProviderNotFound tells that the requested cloud provider was not found
 	ErrProviderNotFound = errors.New("cloudprovider not found")
@@ -75,13 +82,62 @@ var (
 		providerconfig.CloudProviderKubeVirt: func(cvr *providerconfig.ConfigVarResolver) cloudprovidertypes.Provider {
 			return kubevirt.New(cvr)
 		},
+		providerconfig.CloudProviderOCI: func(cvr *providerconfig.ConfigVarResolver) cloudprovidertypes.Provider {
+			return oci.New(cvr)
+		},
+	}
+
+	// providerSpecs are the types the cloud providers decode their cloudProviderSpec into
+	providerSpecs = map[providerconfig.CloudProvider]interface{}{
+		providerconfig.CloudProviderDigitalocean: digitalocean.RawConfig{},
+		providerconfig.CloudProviderAWS:          aws.RawConfig{},
+		providerconfig.CloudProviderOpenstack:    openstack.RawConfig{},
+		providerconfig.CloudProviderGoogle:       gce.CloudProviderSpec{},
+		providerconfig.CloudProviderHetzner:      hetzner.RawConfig{},
+		providerconfig.CloudProviderLinode:       linode.RawConfig{},
+		providerconfig.CloudProviderVsphere:      vsphere.RawConfig{},
+		providerconfig.CloudProviderAzure:        azure.RawConfig{},
+		providerconfig.CloudProviderPacket:       packet.RawConfig{},
+		providerconfig.CloudProviderFake:         fake.CloudProviderSpec{},
+		providerconfig.CloudProviderKubeVirt:     kubevirt.RawConfig{},
+		providerconfig.CloudProviderOCI:          oci.RawConfig{},
 	}
 )
 
+// UnknownProviderSpecFields returns the fields of the provider spec which this version of the machine-controller
+// does not know and therefore ignores, e.g. because the spec was written for a newer version. The operatingSystemSpec
+// is not inspected, it gets decoded by the userdata plugins.
+func UnknownProviderSpecFields(spec v1alpha1.ProviderSpec) ([]string, error) {
+	if spec.Value == nil {
+		return nil, nil
+	}
+	unknown, err := providerconfig.UnknownFields(spec.Value.Raw, providerconfig.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check provider spec: %v", err)
+	}
+
+	config, err := providerconfig.GetConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+	providerSpec, found := providerSpecs[config.CloudProvider]
+	if !found {
+		return unknown, nil
+	}
+	unknownProviderFields, err := providerconfig.UnknownFields(config.CloudProviderSpec.Raw, providerSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check cloudProviderSpec: %v", err)
+	}
+	for _, field := range unknownProviderFields {
+		unknown = append(unknown, "cloudProviderSpec."+field)
+	}
+	return unknown, nil
+}
+
 // ForProvider returns a CloudProvider actuator for the requested provider
 func ForProvider(p providerconfig.CloudProvider, cvr *providerconfig.ConfigVarResolver) (cloudprovidertypes.Provider, error) {
 	if p, found := providers[p]; found {
-		return NewValidationCacheWrappingCloudProvider(p(cvr)), nil
+		return NewValidationCacheWrappingCloudProvider(p(cvr), cvr), nil
 	}
 	return nil, ErrProviderNotFound
 }
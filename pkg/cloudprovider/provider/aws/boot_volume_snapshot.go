@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// snapshotsClient is the subset of the ec2 client needed to validate the boot volume snapshot
+type snapshotsClient interface {
+	DescribeSnapshots(*ec2.DescribeSnapshotsInput) (*ec2.DescribeSnapshotsOutput, error)
+}
+
+// validateBootVolumeSnapshot makes sure the boot volume snapshot exists, is completed and fits into the root
+// volume. Otherwise RunInstances would fail for every instance of the machine.
+func validateBootVolumeSnapshot(client snapshotsClient, config *Config) error {
+	if config.BootVolumeSnapshotID == "" {
+		return nil
+	}
+	out, err := client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: aws.StringSlice([]string{config.BootVolumeSnapshotID}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get boot volume snapshot %s: %v", config.BootVolumeSnapshotID, err)
+	}
+	if len(out.Snapshots) != 1 {
+		return fmt.Errorf("boot volume snapshot %s not found", config.BootVolumeSnapshotID)
+	}
+	snapshot := out.Snapshots[0]
+	if state := aws.StringValue(snapshot.State); state != ec2.SnapshotStateCompleted {
+		return fmt.Errorf("boot volume snapshot %s is %s, volumes can only be created from completed snapshots", config.BootVolumeSnapshotID, state)
+	}
+	if size := aws.Int64Value(snapshot.VolumeSize); size > config.DiskSize {
+		return fmt.Errorf("boot volume snapshot %s has a size of %dGB, the diskSize of %dGB is too small for it", config.BootVolumeSnapshotID, size, config.DiskSize)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type fakeSnapshotsClient struct {
+	snapshots []*ec2.Snapshot
+}
+
+func (f *fakeSnapshotsClient) DescribeSnapshots(input *ec2.DescribeSnapshotsInput) (*ec2.DescribeSnapshotsOutput, error) {
+	out := &ec2.DescribeSnapshotsOutput{}
+	for _, snapshot := range f.snapshots {
+		for _, id := range input.SnapshotIds {
+			if aws.StringValue(snapshot.SnapshotId) == aws.StringValue(id) {
+				out.Snapshots = append(out.Snapshots, snapshot)
+			}
+		}
+	}
+	return out, nil
+}
+
+func TestValidateBootVolumeSnapshot(t *testing.T) {
+	client := &fakeSnapshotsClient{snapshots: []*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-completed"), State: aws.String(ec2.SnapshotStateCompleted), VolumeSize: aws.Int64(20)},
+		{SnapshotId: aws.String("snap-pending"), State: aws.String(ec2.SnapshotStatePending), VolumeSize: aws.Int64(20)},
+	}}
+
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "no snapshot",
+			config: &Config{DiskSize: 10},
+		},
+		{
+			name:   "completed snapshot",
+			config: &Config{DiskSize: 25, BootVolumeSnapshotID: "snap-completed"},
+		},
+		{
+			name:   "snapshot as large as the disk",
+			config: &Config{DiskSize: 20, BootVolumeSnapshotID: "snap-completed"},
+		},
+		{
+			name:        "snapshot larger than the disk",
+			config:      &Config{DiskSize: 10, BootVolumeSnapshotID: "snap-completed"},
+			expectedErr: true,
+		},
+		{
+			name:        "pending snapshot",
+			config:      &Config{DiskSize: 25, BootVolumeSnapshotID: "snap-pending"},
+			expectedErr: true,
+		},
+		{
+			name:        "snapshot not found",
+			config:      &Config{DiskSize: 25, BootVolumeSnapshotID: "snap-missing"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateBootVolumeSnapshot(client, test.config)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRunInstanceFromBootVolumeSnapshot(t *testing.T) {
+	newRequest := func(c *Config) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			InstanceType:        aws.String(c.InstanceType),
+			BlockDeviceMappings: rootBlockDeviceMappings(c, "/dev/xvda"),
+		}
+	}
+
+	client := &recordingRunInstancesClient{}
+	config := &Config{InstanceType: "t3.medium", DiskSize: 25, DiskType: "gp2", BootVolumeSnapshotID: "snap-completed"}
+	if _, err := runInstance(client, config, newRequest); err != nil {
+		t.Fatal(err)
+	}
+	mappings := client.requests[0].BlockDeviceMappings
+	if len(mappings) != 1 || aws.StringValue(mappings[0].DeviceName) != "/dev/xvda" {
+		t.Fatalf("expected a single mapping for the root device /dev/xvda, got %v", mappings)
+	}
+	if snapshotID := aws.StringValue(mappings[0].Ebs.SnapshotId); snapshotID != "snap-completed" {
+		t.Errorf("expected the root volume to be created from snapshot snap-completed, got %q", snapshotID)
+	}
+	if size := aws.Int64Value(mappings[0].Ebs.VolumeSize); size != 25 {
+		t.Errorf("expected a root volume size of 25GB, got %d", size)
+	}
+
+	if mappings := rootBlockDeviceMappings(&Config{DiskSize: 25}, "/dev/xvda"); mappings[0].Ebs.SnapshotId != nil {
+		t.Errorf("expected the root volume to be created from the AMI if no snapshot is set, got %s", aws.StringValue(mappings[0].Ebs.SnapshotId))
+	}
+}
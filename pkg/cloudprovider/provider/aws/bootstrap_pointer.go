@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	defaultBootstrapPointerTag = "Bootstrap-Pointer"
+
+	// Placeholders in the URL of the bootstrap pointer which get replaced per machine
+	bootstrapPointerMachineNamePlaceholder = "{machineName}"
+	bootstrapPointerMachineUIDPlaceholder  = "{machineUID}"
+
+	// Size limits of tags, see https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/Using_Tags.html#tag-restrictions
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+
+	// The UID of a machine is a UUID
+	machineUIDLength = 36
+)
+
+// reservedTags are set by the machine-controller itself
+var reservedTags = sets.NewString(nameTag, machineUIDTag, externalIDTag, machineTemplateHashTag)
+
+// BootstrapPointer is a compact pointer to the bootstrap config of the instance, which gets delivered as instance
+// tag for images without a cloud-init datasource that reads the userdata. A shim on the image reads the tag and
+// fetches its bootstrap config from the URL.
+type BootstrapPointer struct {
+	// Tag is the key of the instance tag
+	Tag string
+	// URL may contain the placeholders {machineName} and {machineUID}
+	URL string
+}
+
+// validateBootstrapPointer makes sure the tag of the bootstrap pointer fits into the size limits of AWS tags.
+// The UID of the machine is not known yet, so the longest possible UID is assumed.
+func validateBootstrapPointer(config *Config, machineName string) error {
+	pointer := config.BootstrapPointer
+	if pointer == nil {
+		return nil
+	}
+	if pointer.URL == "" {
+		return errors.New("bootstrapPointer.url must be specified")
+	}
+	if len(pointer.Tag) > maxTagKeyLength {
+		return fmt.Errorf("bootstrapPointer.tag must not be longer than %d characters", maxTagKeyLength)
+	}
+	if reservedTags.Has(pointer.Tag) || strings.HasPrefix(pointer.Tag, "aws:") {
+		return fmt.Errorf("the tag %s is reserved and can not be used for the bootstrap pointer", pointer.Tag)
+	}
+	if _, ok := config.Tags[pointer.Tag]; ok {
+		return fmt.Errorf("the tag %s is used for the bootstrap pointer and must not be set in tags", pointer.Tag)
+	}
+	if value := bootstrapPointerValue(pointer, machineName, strings.Repeat("x", machineUIDLength)); len(value) > maxTagValueLength {
+		return fmt.Errorf("the bootstrap pointer %q is %d characters long, tags must not be longer than %d characters", value, len(value), maxTagValueLength)
+	}
+	return nil
+}
+
+func bootstrapPointerValue(pointer *BootstrapPointer, machineName, machineUID string) string {
+	return strings.NewReplacer(
+		bootstrapPointerMachineNamePlaceholder, machineName,
+		bootstrapPointerMachineUIDPlaceholder, machineUID,
+	).Replace(pointer.URL)
+}
+
+// bootstrapPointerTag returns the instance tag which carries the bootstrap pointer of the machine
+func bootstrapPointerTag(pointer *BootstrapPointer, machine *v1alpha1.Machine) (*ec2.Tag, error) {
+	value := bootstrapPointerValue(pointer, machine.Spec.Name, string(machine.UID))
+	if len(value) > maxTagValueLength {
+		return nil, fmt.Errorf("the bootstrap pointer %q is %d characters long, tags must not be longer than %d characters", value, len(value), maxTagValueLength)
+	}
+	return &ec2.Tag{Key: aws.String(pointer.Tag), Value: aws.String(value)}, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestBootstrapPointerTag(t *testing.T) {
+	machine := &v1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{UID: "6d5c7b5e-5f1e-4c8f-9a3e-1f2d3c4b5a69"},
+		Spec:       v1alpha1.MachineSpec{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+	}
+	pointer := &BootstrapPointer{
+		Tag: "Shim-Config",
+		URL: "https://bootstrap.example.com/{machineName}/{machineUID}",
+	}
+
+	tag, err := bootstrapPointerTag(pointer, machine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(tag.Key) != "Shim-Config" {
+		t.Errorf("expected the bootstrap pointer to be written to the tag Shim-Config, got %q", aws.StringValue(tag.Key))
+	}
+	expected := "https://bootstrap.example.com/worker-1/6d5c7b5e-5f1e-4c8f-9a3e-1f2d3c4b5a69"
+	if aws.StringValue(tag.Value) != expected {
+		t.Errorf("expected the bootstrap pointer %q, got %q", expected, aws.StringValue(tag.Value))
+	}
+
+	machine.Spec.Name = strings.Repeat("a", maxTagValueLength)
+	if _, err := bootstrapPointerTag(pointer, machine); err == nil {
+		t.Error("expected a bootstrap pointer exceeding the tag size limit to be rejected")
+	}
+}
+
+func TestValidateBootstrapPointer(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		machineName string
+		wantErr     bool
+	}{
+		{
+			name:   "not set",
+			config: &Config{},
+		},
+		{
+			name:        "valid pointer",
+			config:      &Config{BootstrapPointer: &BootstrapPointer{Tag: defaultBootstrapPointerTag, URL: "https://bootstrap.example.com/{machineUID}"}},
+			machineName: "worker-1",
+		},
+		{
+			name:    "missing url",
+			config:  &Config{BootstrapPointer: &BootstrapPointer{Tag: defaultBootstrapPointerTag}},
+			wantErr: true,
+		},
+		{
+			name:    "tag key too long",
+			config:  &Config{BootstrapPointer: &BootstrapPointer{Tag: strings.Repeat("k", maxTagKeyLength+1), URL: "https://bootstrap.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "reserved tag",
+			config:  &Config{BootstrapPointer: &BootstrapPointer{Tag: machineUIDTag, URL: "https://bootstrap.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "aws prefix",
+			config:  &Config{BootstrapPointer: &BootstrapPointer{Tag: "aws:bootstrap", URL: "https://bootstrap.example.com"}},
+			wantErr: true,
+		},
+		{
+			name: "tag also set in tags",
+			config: &Config{
+				BootstrapPointer: &BootstrapPointer{Tag: defaultBootstrapPointerTag, URL: "https://bootstrap.example.com"},
+				Tags:             map[string]string{defaultBootstrapPointerTag: "other"},
+			},
+			wantErr: true,
+		},
+		{
+			// The placeholder of the UID gets replaced by 36 characters
+			name:        "value too long once the uid is set",
+			config:      &Config{BootstrapPointer: &BootstrapPointer{Tag: defaultBootstrapPointerTag, URL: strings.Repeat("u", maxTagValueLength-20) + "{machineUID}"}},
+			machineName: "worker-1",
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateBootstrapPointer(test.config, test.machineName)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
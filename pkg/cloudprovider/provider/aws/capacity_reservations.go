@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// capacityReservationPreferenceTargeted only launches the instance in the reservation with the given ID. The ec2 API
+// has no preference for it, setting the target is enough
+const capacityReservationPreferenceTargeted = "targeted"
+
+var capacityReservationPreferences = sets.NewString(
+	ec2.CapacityReservationPreferenceOpen,
+	ec2.CapacityReservationPreferenceNone,
+	capacityReservationPreferenceTargeted,
+)
+
+// CapacityReservation selects the On-Demand Capacity Reservations the instance can run in
+type CapacityReservation struct {
+	// Preference is either open, none or targeted
+	Preference string
+	// ID of the reservation, only set for the targeted preference
+	ID string
+}
+
+// capacityReservationsClient is the subset of the ec2 client needed to validate capacity reservations
+type capacityReservationsClient interface {
+	DescribeCapacityReservations(*ec2.DescribeCapacityReservationsInput) (*ec2.DescribeCapacityReservationsOutput, error)
+}
+
+// validateCapacityReservation checks the preference and makes sure a targeted reservation is active and matches
+// the instance type and availability zone. Otherwise RunInstances would fail for every instance of the machine.
+func validateCapacityReservation(client capacityReservationsClient, config *Config) error {
+	reservation := config.CapacityReservation
+	if reservation == nil {
+		return nil
+	}
+	if !capacityReservationPreferences.Has(reservation.Preference) {
+		return fmt.Errorf("invalid capacityReservation preference %q, supported: %v", reservation.Preference, capacityReservationPreferences.List())
+	}
+	if config.IsSpotInstance != nil && *config.IsSpotInstance && reservation.Preference != ec2.CapacityReservationPreferenceNone {
+		return errors.New("spot instances can not be launched in a capacity reservation")
+	}
+	if reservation.Preference != capacityReservationPreferenceTargeted {
+		if reservation.ID != "" {
+			return fmt.Errorf("capacityReservation id must only be set for the %s preference", capacityReservationPreferenceTargeted)
+		}
+		return nil
+	}
+
+	if reservation.ID == "" {
+		return fmt.Errorf("capacityReservation id must be set for the %s preference", capacityReservationPreferenceTargeted)
+	}
+	// A reservation is bound to one instance type, the fallbacks could never be launched in it
+	if len(config.InstanceTypeFallbacks) > 0 {
+		return errors.New("instanceTypeFallbacks can not be used with a targeted capacityReservation")
+	}
+
+	out, err := client.DescribeCapacityReservations(&ec2.DescribeCapacityReservationsInput{
+		CapacityReservationIds: aws.StringSlice([]string{reservation.ID}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get capacity reservation %s: %v", reservation.ID, err)
+	}
+	if len(out.CapacityReservations) != 1 {
+		return fmt.Errorf("capacity reservation %s not found", reservation.ID)
+	}
+	found := out.CapacityReservations[0]
+	if state := aws.StringValue(found.State); state != ec2.CapacityReservationStateActive {
+		return fmt.Errorf("capacity reservation %s is %s, instances can only be launched in active reservations", reservation.ID, state)
+	}
+	if instanceType := aws.StringValue(found.InstanceType); instanceType != config.InstanceType {
+		return fmt.Errorf("capacity reservation %s is for instance type %s, not %s", reservation.ID, instanceType, config.InstanceType)
+	}
+	if zone := aws.StringValue(found.AvailabilityZone); zone != config.AvailabilityZone {
+		return fmt.Errorf("capacity reservation %s is in availability zone %s, not %s", reservation.ID, zone, config.AvailabilityZone)
+	}
+	return nil
+}
+
+// capacityReservationSpecification returns the capacity reservation specification for the RunInstances request.
+// nil keeps the default of AWS, which launches the instance in any open reservation with matching attributes.
+func capacityReservationSpecification(config *Config) *ec2.CapacityReservationSpecification {
+	reservation := config.CapacityReservation
+	if reservation == nil {
+		return nil
+	}
+	if reservation.Preference == capacityReservationPreferenceTargeted {
+		return &ec2.CapacityReservationSpecification{
+			CapacityReservationTarget: &ec2.CapacityReservationTarget{CapacityReservationId: aws.String(reservation.ID)},
+		}
+	}
+	return &ec2.CapacityReservationSpecification{CapacityReservationPreference: aws.String(reservation.Preference)}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type fakeCapacityReservationsClient struct {
+	reservations []*ec2.CapacityReservation
+}
+
+func (f *fakeCapacityReservationsClient) DescribeCapacityReservations(input *ec2.DescribeCapacityReservationsInput) (*ec2.DescribeCapacityReservationsOutput, error) {
+	out := &ec2.DescribeCapacityReservationsOutput{}
+	for _, reservation := range f.reservations {
+		for _, id := range input.CapacityReservationIds {
+			if aws.StringValue(reservation.CapacityReservationId) == aws.StringValue(id) {
+				out.CapacityReservations = append(out.CapacityReservations, reservation)
+			}
+		}
+	}
+	return out, nil
+}
+
+// recordingRunInstancesClient keeps the requests it got
+type recordingRunInstancesClient struct {
+	requests []*ec2.RunInstancesInput
+}
+
+func (r *recordingRunInstancesClient) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	r.requests = append(r.requests, input)
+	return &ec2.Reservation{Instances: []*ec2.Instance{{InstanceId: aws.String("i-1"), InstanceType: input.InstanceType}}}, nil
+}
+
+func TestValidateCapacityReservation(t *testing.T) {
+	client := &fakeCapacityReservationsClient{reservations: []*ec2.CapacityReservation{
+		{
+			CapacityReservationId: aws.String("cr-active"),
+			InstanceType:          aws.String("p3.2xlarge"),
+			AvailabilityZone:      aws.String("eu-central-1a"),
+			State:                 aws.String(ec2.CapacityReservationStateActive),
+		},
+		{
+			CapacityReservationId: aws.String("cr-expired"),
+			InstanceType:          aws.String("p3.2xlarge"),
+			AvailabilityZone:      aws.String("eu-central-1a"),
+			State:                 aws.String(ec2.CapacityReservationStateExpired),
+		},
+	}}
+
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "not set",
+			config: &Config{},
+		},
+		{
+			name:   "open",
+			config: &Config{CapacityReservation: &CapacityReservation{Preference: "open"}},
+		},
+		{
+			name:   "none for spot instances",
+			config: &Config{IsSpotInstance: aws.Bool(true), CapacityReservation: &CapacityReservation{Preference: "none"}},
+		},
+		{
+			name:   "targeted",
+			config: &Config{CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-active"}},
+		},
+		{
+			name:        "invalid preference",
+			config:      &Config{CapacityReservation: &CapacityReservation{Preference: "reserved"}},
+			expectedErr: true,
+		},
+		{
+			name:        "targeted without id",
+			config:      &Config{CapacityReservation: &CapacityReservation{Preference: "targeted"}},
+			expectedErr: true,
+		},
+		{
+			name:        "id without targeted",
+			config:      &Config{CapacityReservation: &CapacityReservation{Preference: "open", ID: "cr-active"}},
+			expectedErr: true,
+		},
+		{
+			name:        "open for spot instances",
+			config:      &Config{IsSpotInstance: aws.Bool(true), CapacityReservation: &CapacityReservation{Preference: "open"}},
+			expectedErr: true,
+		},
+		{
+			name: "targeted with instance type fallbacks",
+			config: &Config{
+				InstanceTypeFallbacks: []string{"p3.8xlarge"},
+				CapacityReservation:   &CapacityReservation{Preference: "targeted", ID: "cr-active"},
+			},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown reservation",
+			config:      &Config{CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-unknown"}},
+			expectedErr: true,
+		},
+		{
+			name:        "expired reservation",
+			config:      &Config{CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-expired"}},
+			expectedErr: true,
+		},
+		{
+			name:        "reservation for another instance type",
+			config:      &Config{InstanceType: "p3.8xlarge", CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-active"}},
+			expectedErr: true,
+		},
+		{
+			name:        "reservation in another zone",
+			config:      &Config{AvailabilityZone: "eu-central-1b", CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-active"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.config.InstanceType == "" {
+				test.config.InstanceType = "p3.2xlarge"
+			}
+			if test.config.AvailabilityZone == "" {
+				test.config.AvailabilityZone = "eu-central-1a"
+			}
+			err := validateCapacityReservation(client, test.config)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRunInstanceInCapacityReservation(t *testing.T) {
+	newRequest := func(c *Config) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			InstanceType:                     aws.String(c.InstanceType),
+			CapacityReservationSpecification: capacityReservationSpecification(c),
+		}
+	}
+
+	client := &recordingRunInstancesClient{}
+	config := &Config{InstanceType: "p3.2xlarge", CapacityReservation: &CapacityReservation{Preference: "targeted", ID: "cr-active"}}
+	if _, err := runInstance(client, config, newRequest); err != nil {
+		t.Fatal(err)
+	}
+	spec := client.requests[0].CapacityReservationSpecification
+	if spec == nil || spec.CapacityReservationTarget == nil || aws.StringValue(spec.CapacityReservationTarget.CapacityReservationId) != "cr-active" {
+		t.Errorf("expected the request to target capacity reservation cr-active, got %v", spec)
+	}
+	if spec != nil && spec.CapacityReservationPreference != nil {
+		t.Errorf("expected no preference for a targeted reservation, got %s", aws.StringValue(spec.CapacityReservationPreference))
+	}
+
+	client = &recordingRunInstancesClient{}
+	config = &Config{InstanceType: "p3.2xlarge", CapacityReservation: &CapacityReservation{Preference: "none"}}
+	if _, err := runInstance(client, config, newRequest); err != nil {
+		t.Fatal(err)
+	}
+	if spec := client.requests[0].CapacityReservationSpecification; spec == nil || aws.StringValue(spec.CapacityReservationPreference) != "none" {
+		t.Errorf("expected the request to use no capacity reservation, got %v", spec)
+	}
+
+	if spec := capacityReservationSpecification(&Config{}); spec != nil {
+		t.Errorf("expected no capacity reservation specification if not set, got %v", spec)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// burstableFamilies are the instance families which use CPU credits
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/burstable-performance-instances.html
+var burstableFamilies = sets.NewString("t2", "t3", "t3a", "t4g")
+
+var creditSpecifications = sets.NewString("standard", "unlimited")
+
+func validateCreditSpecification(instanceType, creditSpecification string) error {
+	if creditSpecification == "" {
+		return nil
+	}
+	if !creditSpecifications.Has(creditSpecification) {
+		return fmt.Errorf("invalid credit specification %q, supported: %v", creditSpecification, creditSpecifications.List())
+	}
+	if family := strings.Split(instanceType, ".")[0]; !burstableFamilies.Has(family) {
+		return fmt.Errorf("credit specification is only supported for burstable instance types, got %s", instanceType)
+	}
+	return nil
+}
+
+// creditSpecification returns the credit specification for the RunInstances request. nil keeps
+// the default of the instance family, which is unlimited for T3 and standard for T2.
+func creditSpecification(config *Config) *ec2.CreditSpecificationRequest {
+	if config.CreditSpecification == "" {
+		return nil
+	}
+	return &ec2.CreditSpecificationRequest{CpuCredits: aws.String(config.CreditSpecification)}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestValidateCreditSpecification(t *testing.T) {
+	tests := []struct {
+		name                string
+		instanceType        string
+		creditSpecification string
+		expectedErr         bool
+	}{
+		{
+			name:         "not set",
+			instanceType: "m5.large",
+		},
+		{
+			name:                "unlimited on t3",
+			instanceType:        "t3.medium",
+			creditSpecification: "unlimited",
+		},
+		{
+			name:                "standard on t3a",
+			instanceType:        "t3a.large",
+			creditSpecification: "standard",
+		},
+		{
+			name:                "non-burstable instance type",
+			instanceType:        "m5.large",
+			creditSpecification: "unlimited",
+			expectedErr:         true,
+		},
+		{
+			name:                "invalid credit specification",
+			instanceType:        "t3.medium",
+			creditSpecification: "burst",
+			expectedErr:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCreditSpecification(test.instanceType, test.creditSpecification)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCreditSpecification(t *testing.T) {
+	spec := creditSpecification(&Config{InstanceType: "t3.medium", CreditSpecification: "unlimited"})
+	if spec == nil || aws.StringValue(spec.CpuCredits) != "unlimited" {
+		t.Errorf("expected unlimited CPU credits for the RunInstances request, got %v", spec)
+	}
+	if spec := creditSpecification(&Config{InstanceType: "t3.medium"}); spec != nil {
+		t.Errorf("expected no credit specification if not set, got %v", spec)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ElasticIP is an Elastic IP which gets associated with the primary network interface of the instance
+type ElasticIP struct {
+	// AllocationID of an existing Elastic IP. When empty, an Elastic IP gets allocated for the instance
+	// and released once the instance gets deleted
+	AllocationID string
+}
+
+// elasticIPClient is the subset of the ec2 client needed to manage Elastic IPs
+type elasticIPClient interface {
+	AllocateAddress(*ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error)
+	AssociateAddress(*ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error)
+	CreateTags(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error)
+	DescribeAddresses(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error)
+	DisassociateAddress(*ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error)
+	ReleaseAddress(*ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error)
+}
+
+// validatePublicIP checks that a public IP is only requested where AWS can assign one
+func validatePublicIP(config *Config) error {
+	if !config.AssignPublicIP {
+		return nil
+	}
+	if config.ElasticIP != nil {
+		return errors.New("assignPublicIP and elasticIP are mutually exclusive")
+	}
+	if len(config.AdditionalNetworkInterfaces) > 0 {
+		return errors.New("assignPublicIP is not supported for instances with additional network interfaces, use an elasticIP instead")
+	}
+	return nil
+}
+
+// allocatedElasticIPs returns the Elastic IPs which got allocated for the machine with the given UID
+func allocatedElasticIPs(client elasticIPClient, machineUID types.UID) ([]*ec2.Address, error) {
+	out, err := client.DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + machineUIDTag),
+				Values: aws.StringSlice([]string{string(machineUID)}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe elastic ips: %v", err)
+	}
+	return out.Addresses, nil
+}
+
+// ensureElasticIPAllocated returns the allocation ID of the Elastic IP of the machine. An Elastic IP gets
+// allocated and tagged with the machine UID unless an existing one is configured or got allocated already.
+func ensureElasticIPAllocated(client elasticIPClient, elasticIP *ElasticIP, machineUID types.UID) (string, error) {
+	if elasticIP.AllocationID != "" {
+		return elasticIP.AllocationID, nil
+	}
+
+	addresses, err := allocatedElasticIPs(client, machineUID)
+	if err != nil {
+		return "", err
+	}
+	if len(addresses) > 0 {
+		return aws.StringValue(addresses[0].AllocationId), nil
+	}
+
+	out, err := client.AllocateAddress(&ec2.AllocateAddressInput{Domain: aws.String(ec2.DomainTypeVpc)})
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate elastic ip: %v", err)
+	}
+	if _, err := client.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{out.AllocationId},
+		Tags:      []*ec2.Tag{{Key: aws.String(machineUIDTag), Value: aws.String(string(machineUID))}},
+	}); err != nil {
+		if _, releaseErr := client.ReleaseAddress(&ec2.ReleaseAddressInput{AllocationId: out.AllocationId}); releaseErr != nil {
+			return "", fmt.Errorf("failed to release elastic ip %s due to %v after failing to tag it: %v", aws.StringValue(out.AllocationId), releaseErr, err)
+		}
+		return "", fmt.Errorf("failed to tag elastic ip %s: %v", aws.StringValue(out.AllocationId), err)
+	}
+	return aws.StringValue(out.AllocationId), nil
+}
+
+// associateElasticIP associates the Elastic IP of the machine with the primary network interface of the instance.
+// The network interface gets used instead of the instance, as instances can only be associated once they are running.
+func associateElasticIP(client elasticIPClient, elasticIP *ElasticIP, machineUID types.UID, instance *ec2.Instance) error {
+	var primaryInterfaceID *string
+	for _, ifc := range instance.NetworkInterfaces {
+		if ifc.Attachment != nil && aws.Int64Value(ifc.Attachment.DeviceIndex) == 0 {
+			primaryInterfaceID = ifc.NetworkInterfaceId
+		}
+	}
+	if primaryInterfaceID == nil {
+		return fmt.Errorf("instance %s has no primary network interface", aws.StringValue(instance.InstanceId))
+	}
+
+	allocationID, err := ensureElasticIPAllocated(client, elasticIP, machineUID)
+	if err != nil {
+		return err
+	}
+	if _, err := client.AssociateAddress(&ec2.AssociateAddressInput{
+		AllocationId:       aws.String(allocationID),
+		NetworkInterfaceId: primaryInterfaceID,
+	}); err != nil {
+		return fmt.Errorf("failed to associate elastic ip %s with instance %s: %v", allocationID, aws.StringValue(instance.InstanceId), err)
+	}
+	return nil
+}
+
+// releaseElasticIPs releases the Elastic IPs which got allocated for the machine. Existing Elastic IPs
+// which were configured via their allocation ID are not tagged and therefore kept.
+func releaseElasticIPs(client elasticIPClient, machineUID types.UID) error {
+	addresses, err := allocatedElasticIPs(client, machineUID)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if address.AssociationId != nil {
+			if _, err := client.DisassociateAddress(&ec2.DisassociateAddressInput{AssociationId: address.AssociationId}); err != nil {
+				return fmt.Errorf("failed to disassociate elastic ip %s: %v", aws.StringValue(address.AllocationId), err)
+			}
+		}
+		if _, err := client.ReleaseAddress(&ec2.ReleaseAddressInput{AllocationId: address.AllocationId}); err != nil {
+			return fmt.Errorf("failed to release elastic ip %s: %v", aws.StringValue(address.AllocationId), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// fakeElasticIPClient keeps the Elastic IPs in memory
+type fakeElasticIPClient struct {
+	addresses map[string]*ec2.Address
+	allocated int
+}
+
+func (f *fakeElasticIPClient) AllocateAddress(*ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	f.allocated++
+	id := fmt.Sprintf("eipalloc-%d", f.allocated)
+	f.addresses[id] = &ec2.Address{AllocationId: aws.String(id)}
+	return &ec2.AllocateAddressOutput{AllocationId: aws.String(id)}, nil
+}
+
+func (f *fakeElasticIPClient) AssociateAddress(input *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	address := f.addresses[aws.StringValue(input.AllocationId)]
+	address.AssociationId = aws.String("eipassoc-" + aws.StringValue(input.AllocationId))
+	address.NetworkInterfaceId = input.NetworkInterfaceId
+	return &ec2.AssociateAddressOutput{AssociationId: address.AssociationId}, nil
+}
+
+func (f *fakeElasticIPClient) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	for _, id := range input.Resources {
+		address := f.addresses[aws.StringValue(id)]
+		address.Tags = append(address.Tags, input.Tags...)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeElasticIPClient) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	out := &ec2.DescribeAddressesOutput{}
+	for _, address := range f.addresses {
+		for _, filter := range input.Filters {
+			if aws.StringValue(filter.Name) == "tag:"+machineUIDTag && getTagValue(machineUIDTag, address.Tags) == aws.StringValue(filter.Values[0]) {
+				out.Addresses = append(out.Addresses, address)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeElasticIPClient) DisassociateAddress(input *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	for _, address := range f.addresses {
+		if aws.StringValue(address.AssociationId) == aws.StringValue(input.AssociationId) {
+			address.AssociationId = nil
+			address.NetworkInterfaceId = nil
+		}
+	}
+	return &ec2.DisassociateAddressOutput{}, nil
+}
+
+func (f *fakeElasticIPClient) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	address := f.addresses[aws.StringValue(input.AllocationId)]
+	if address.AssociationId != nil {
+		return nil, fmt.Errorf("elastic ip %s is still associated", aws.StringValue(input.AllocationId))
+	}
+	delete(f.addresses, aws.StringValue(input.AllocationId))
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func newFakeInstance() *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String("i-1"),
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{NetworkInterfaceId: aws.String("eni-2"), Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)}},
+			{NetworkInterfaceId: aws.String("eni-1"), Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)}},
+		},
+	}
+}
+
+func TestPublicIPConfig(t *testing.T) {
+	tests := []struct {
+		name                      string
+		spec                      map[string]interface{}
+		expectedElasticIP         *ElasticIP
+		expectedAssociatePublicIP bool
+	}{
+		{
+			name:                      "ephemeral public ip by default",
+			spec:                      map[string]interface{}{},
+			expectedAssociatePublicIP: true,
+		},
+		{
+			name:                      "no public ip",
+			spec:                      map[string]interface{}{"assignPublicIP": false},
+			expectedAssociatePublicIP: false,
+		},
+		{
+			name:                      "allocated elastic ip",
+			spec:                      map[string]interface{}{"elasticIP": map[string]interface{}{}},
+			expectedElasticIP:         &ElasticIP{},
+			expectedAssociatePublicIP: false,
+		},
+		{
+			name:                      "existing elastic ip",
+			spec:                      map[string]interface{}{"elasticIP": map[string]interface{}{"allocationId": "eipalloc-1"}},
+			expectedElasticIP:         &ElasticIP{AllocationID: "eipalloc-1"},
+			expectedAssociatePublicIP: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.spec["accessKeyId"] = "id"
+			test.spec["secretAccessKey"] = "secret"
+			test.spec["subnetId"] = "subnet-1"
+			cloudProviderSpec, err := json.Marshal(test.spec)
+			if err != nil {
+				t.Fatalf("failed to marshal cloud provider spec: %v", err)
+			}
+			providerSpec, err := json.Marshal(providerconfig.Config{CloudProviderSpec: runtime.RawExtension{Raw: cloudProviderSpec}})
+			if err != nil {
+				t.Fatalf("failed to marshal provider spec: %v", err)
+			}
+
+			p := &provider{configVarResolver: providerconfig.NewConfigVarResolver(nil)}
+			config, _, _, err := p.getConfig(v1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: providerSpec}})
+			if err != nil {
+				t.Fatalf("failed to get config: %v", err)
+			}
+			if err := validatePublicIP(config); err != nil {
+				t.Errorf("expected a valid config, got: %v", err)
+			}
+			if (config.ElasticIP == nil) != (test.expectedElasticIP == nil) ||
+				(config.ElasticIP != nil && *config.ElasticIP != *test.expectedElasticIP) {
+				t.Errorf("expected elastic ip %v, got %v", test.expectedElasticIP, config.ElasticIP)
+			}
+			primary := networkInterfaceSpecifications(config)[0]
+			if aws.BoolValue(primary.AssociatePublicIpAddress) != test.expectedAssociatePublicIP {
+				t.Errorf("expected AssociatePublicIpAddress to be %v, got %v", test.expectedAssociatePublicIP, aws.BoolValue(primary.AssociatePublicIpAddress))
+			}
+		})
+	}
+}
+
+func TestValidatePublicIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "ephemeral public ip",
+			config: &Config{AssignPublicIP: true},
+		},
+		{
+			name:   "elastic ip",
+			config: &Config{ElasticIP: &ElasticIP{}, AdditionalNetworkInterfaces: []NetworkInterface{{SubnetID: "subnet-2"}}},
+		},
+		{
+			name:        "public ip and elastic ip",
+			config:      &Config{AssignPublicIP: true, ElasticIP: &ElasticIP{}},
+			expectedErr: true,
+		},
+		{
+			name:        "public ip with additional network interfaces",
+			config:      &Config{AssignPublicIP: true, AdditionalNetworkInterfaces: []NetworkInterface{{SubnetID: "subnet-2"}}},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePublicIP(test.config)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestAllocatedElasticIP(t *testing.T) {
+	client := &fakeElasticIPClient{addresses: map[string]*ec2.Address{}}
+
+	// Associating must be idempotent, as creating the instance gets retried
+	for i := 0; i < 2; i++ {
+		if err := associateElasticIP(client, &ElasticIP{}, "machine-1", newFakeInstance()); err != nil {
+			t.Fatalf("failed to associate elastic ip: %v", err)
+		}
+	}
+	if len(client.addresses) != 1 {
+		t.Fatalf("expected one allocated elastic ip, got %d", len(client.addresses))
+	}
+	address := client.addresses["eipalloc-1"]
+	if aws.StringValue(address.NetworkInterfaceId) != "eni-1" {
+		t.Errorf("expected the elastic ip to be associated with the primary network interface eni-1, got %q", aws.StringValue(address.NetworkInterfaceId))
+	}
+
+	if err := releaseElasticIPs(client, "machine-1"); err != nil {
+		t.Fatalf("failed to release elastic ips: %v", err)
+	}
+	if len(client.addresses) != 0 {
+		t.Errorf("expected the allocated elastic ip to be released, got %v", client.addresses)
+	}
+}
+
+func TestExistingElasticIP(t *testing.T) {
+	client := &fakeElasticIPClient{addresses: map[string]*ec2.Address{
+		"eipalloc-existing": {AllocationId: aws.String("eipalloc-existing")},
+	}}
+
+	if err := associateElasticIP(client, &ElasticIP{AllocationID: "eipalloc-existing"}, "machine-1", newFakeInstance()); err != nil {
+		t.Fatalf("failed to associate elastic ip: %v", err)
+	}
+	if client.allocated != 0 {
+		t.Errorf("expected no elastic ip to be allocated, got %d", client.allocated)
+	}
+	if aws.StringValue(client.addresses["eipalloc-existing"].NetworkInterfaceId) != "eni-1" {
+		t.Errorf("expected the existing elastic ip to be associated with eni-1")
+	}
+
+	if err := releaseElasticIPs(client, "machine-1"); err != nil {
+		t.Fatalf("failed to release elastic ips: %v", err)
+	}
+	if _, exists := client.addresses["eipalloc-existing"]; !exists {
+		t.Errorf("expected the existing elastic ip to be kept")
+	}
+}
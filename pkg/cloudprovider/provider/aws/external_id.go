@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const externalIDTag = "External-ID"
+
+// externalIDRegexp allows identifiers of up to 64 alphanumeric characters, dots, underscores and dashes,
+// which start and end with an alphanumeric character
+var externalIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]{0,62}[a-zA-Z0-9])?$`)
+
+// externalIDClient is the subset of the ec2 client needed to check if an external ID is in use
+type externalIDClient interface {
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
+
+func validateExternalID(config *Config) error {
+	if config.ExternalID == "" {
+		return nil
+	}
+	if !externalIDRegexp.MatchString(config.ExternalID) {
+		return fmt.Errorf("invalid externalID %q, must consist of at most 64 alphanumeric characters, '.', '_' or '-' and start and end with an alphanumeric character", config.ExternalID)
+	}
+	if _, ok := config.Tags[externalIDTag]; ok {
+		return fmt.Errorf("the tag %s is reserved for the externalID", externalIDTag)
+	}
+	return nil
+}
+
+// checkExternalIDUnused makes sure no instance of another machine carries the external ID. Terminated instances
+// do not count, so the ID of a deleted machine can be reused.
+func checkExternalIDUnused(client externalIDClient, externalID string, machineUID types.UID) error {
+	out, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + externalIDTag), Values: aws.StringSlice([]string{externalID})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending,
+				ec2.InstanceStateNameRunning,
+				ec2.InstanceStateNameShuttingDown,
+				ec2.InstanceStateNameStopping,
+				ec2.InstanceStateNameStopped,
+			})},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list instances with externalID %s: %v", externalID, err)
+	}
+	for _, reservation := range out.Reservations {
+		for _, i := range reservation.Instances {
+			if getTagValue(machineUIDTag, i.Tags) != string(machineUID) {
+				return fmt.Errorf("externalID %s is already used by instance %s", externalID, aws.StringValue(i.InstanceId))
+			}
+		}
+	}
+	return nil
+}
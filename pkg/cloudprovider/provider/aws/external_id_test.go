@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeExternalIDClient returns the instances whose tags match the tag filters of the request
+type fakeExternalIDClient struct {
+	instances []*ec2.Instance
+}
+
+func (f *fakeExternalIDClient) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	reservation := &ec2.Reservation{}
+	for _, i := range f.instances {
+		matches := true
+		for _, filter := range input.Filters {
+			name := aws.StringValue(filter.Name)
+			if !strings.HasPrefix(name, "tag:") {
+				continue
+			}
+			if getTagValue(strings.TrimPrefix(name, "tag:"), i.Tags) != aws.StringValue(filter.Values[0]) {
+				matches = false
+			}
+		}
+		if matches {
+			reservation.Instances = append(reservation.Instances, i)
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+func newFakeTaggedInstance(id, machineUID, externalID string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String(id),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(machineUIDTag), Value: aws.String(machineUID)},
+			{Key: aws.String(externalIDTag), Value: aws.String(externalID)},
+		},
+	}
+}
+
+func TestCheckExternalIDUnused(t *testing.T) {
+	client := &fakeExternalIDClient{instances: []*ec2.Instance{
+		newFakeTaggedInstance("i-1", "machine-1", "asset-0001"),
+	}}
+
+	if err := checkExternalIDUnused(client, "asset-0002", "machine-2"); err != nil {
+		t.Errorf("expected an unused externalID to be accepted, got: %v", err)
+	}
+	// The instance of the machine itself may already carry the ID, e.g. if the creation got retried
+	if err := checkExternalIDUnused(client, "asset-0001", "machine-1"); err != nil {
+		t.Errorf("expected the externalID of the machine's own instance to be accepted, got: %v", err)
+	}
+	err := checkExternalIDUnused(client, "asset-0001", "machine-2")
+	if err == nil {
+		t.Fatal("expected a duplicate externalID to be rejected")
+	}
+	if !strings.Contains(err.Error(), "i-1") {
+		t.Errorf("expected the error to name the instance which uses the externalID, got: %v", err)
+	}
+}
+
+func TestValidateExternalID(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "not set",
+			config: &Config{},
+		},
+		{
+			name:   "valid id",
+			config: &Config{ExternalID: "asset-0042"},
+		},
+		{
+			name:   "dots and underscores",
+			config: &Config{ExternalID: "eu.asset_0042"},
+		},
+		{
+			name:    "leading dash",
+			config:  &Config{ExternalID: "-asset"},
+			wantErr: true,
+		},
+		{
+			name:    "whitespace",
+			config:  &Config{ExternalID: "asset 0042"},
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			config:  &Config{ExternalID: strings.Repeat("a", 65)},
+			wantErr: true,
+		},
+		{
+			name:    "reserved tag",
+			config:  &Config{ExternalID: "asset-0042", Tags: map[string]string{externalIDTag: "asset-0001"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateExternalID(test.config)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
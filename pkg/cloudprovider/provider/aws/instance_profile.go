@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// instanceProfileClient is the subset of the iam client needed to resolve instance profiles
+type instanceProfileClient interface {
+	GetInstanceProfile(*iam.GetInstanceProfileInput) (*iam.GetInstanceProfileOutput, error)
+}
+
+// resolveInstanceProfile returns the instance profile with the given name. Profiles without a role are rejected,
+// as an instance bound to them would not get any credentials.
+func resolveInstanceProfile(client instanceProfileClient, name string) (*iam.InstanceProfile, error) {
+	if name == "" {
+		return nil, errors.New("no instance profile specified")
+	}
+	out, err := client.GetInstanceProfile(&iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeNoSuchEntityException {
+			return nil, fmt.Errorf("instance profile %q does not exist", name)
+		}
+		return nil, fmt.Errorf("failed to get instance profile %q: %v", name, err)
+	}
+	if out.InstanceProfile == nil || len(out.InstanceProfile.Roles) == 0 {
+		return nil, fmt.Errorf("instance profile %q has no role", name)
+	}
+	return out.InstanceProfile, nil
+}
+
+// instanceProfileSpecification binds the instance to the instance profile. The ARN is used rather than the name,
+// so the instance gets the profile which got validated even if it is re-created with the same name meanwhile.
+func instanceProfileSpecification(profile *iam.InstanceProfile) *ec2.IamInstanceProfileSpecification {
+	return &ec2.IamInstanceProfileSpecification{Arn: profile.Arn}
+}
+
+// instanceProfileIdentity returns the ARN of the IAM role instances bound to the profile assume
+func instanceProfileIdentity(profile *iam.InstanceProfile) string {
+	return aws.StringValue(profile.Roles[0].Arn)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// fakeInstanceProfileClient returns the instance profiles it knows by name
+type fakeInstanceProfileClient struct {
+	profiles map[string]*iam.InstanceProfile
+}
+
+func (f *fakeInstanceProfileClient) GetInstanceProfile(input *iam.GetInstanceProfileInput) (*iam.GetInstanceProfileOutput, error) {
+	profile, ok := f.profiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+func TestInstanceProfileBinding(t *testing.T) {
+	client := &fakeInstanceProfileClient{profiles: map[string]*iam.InstanceProfile{
+		"workers": {
+			InstanceProfileName: aws.String("workers"),
+			Arn:                 aws.String("arn:aws:iam::123456789012:instance-profile/workers"),
+			Roles:               []*iam.Role{{Arn: aws.String("arn:aws:iam::123456789012:role/workers")}},
+		},
+		"empty": {
+			InstanceProfileName: aws.String("empty"),
+			Arn:                 aws.String("arn:aws:iam::123456789012:instance-profile/empty"),
+		},
+	}}
+
+	tests := []struct {
+		name             string
+		profile          string
+		expectedArn      string
+		expectedIdentity string
+		expectedErr      bool
+	}{
+		{
+			name:             "profile gets bound by arn",
+			profile:          "workers",
+			expectedArn:      "arn:aws:iam::123456789012:instance-profile/workers",
+			expectedIdentity: "arn:aws:iam::123456789012:role/workers",
+		},
+		{
+			name:        "missing profile",
+			profile:     "ingress",
+			expectedErr: true,
+		},
+		{
+			name:        "profile without role",
+			profile:     "empty",
+			expectedErr: true,
+		},
+		{
+			name:        "no profile",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile, err := resolveInstanceProfile(client, test.profile)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr {
+				return
+			}
+			spec := instanceProfileSpecification(profile)
+			if arn := aws.StringValue(spec.Arn); arn != test.expectedArn {
+				t.Errorf("expected the instance to be bound to %q, got %q", test.expectedArn, arn)
+			}
+			if spec.Name != nil {
+				t.Errorf("expected no name in the specification, got %q", aws.StringValue(spec.Name))
+			}
+			if identity := instanceProfileIdentity(profile); identity != test.expectedIdentity {
+				t.Errorf("expected identity %q, got %q", test.expectedIdentity, identity)
+			}
+		})
+	}
+}
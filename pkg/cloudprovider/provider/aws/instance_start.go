@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// instanceStartClient is the subset of the ec2 client needed to stop and start instances
+type instanceStartClient interface {
+	StartInstances(*ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error)
+	StopInstances(*ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error)
+}
+
+// stopInstance takes the next step to stop the instance. It returns true once the instance is stopped.
+func stopInstance(client instanceStartClient, i *ec2.Instance) (bool, error) {
+	switch aws.StringValue(i.State.Name) {
+	case ec2.InstanceStateNameStopped:
+		return true, nil
+	case ec2.InstanceStateNameRunning:
+		if _, err := client.StopInstances(&ec2.StopInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+			return false, awsErrorToTerminalError(err, "failed to stop instance")
+		}
+	}
+	// The instance is pending or stopping
+	return false, nil
+}
+
+// startInstance takes the next step to start the instance. It returns true once the instance is running.
+func startInstance(client instanceStartClient, i *ec2.Instance) (bool, error) {
+	switch aws.StringValue(i.State.Name) {
+	case ec2.InstanceStateNameRunning:
+		return true, nil
+	case ec2.InstanceStateNameStopped:
+		if _, err := client.StartInstances(&ec2.StartInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+			return false, awsErrorToTerminalError(err, "failed to start instance")
+		}
+	}
+	// The instance is pending or stopping
+	return false, nil
+}
+
+func (p *provider) StopInstance(machine *v1alpha1.Machine) (bool, error) {
+	client, i, err := p.instanceWithClient(machine)
+	if err != nil {
+		return false, err
+	}
+	return stopInstance(client, i)
+}
+
+func (p *provider) StartInstance(machine *v1alpha1.Machine) (bool, error) {
+	client, i, err := p.instanceWithClient(machine)
+	if err != nil {
+		return false, err
+	}
+	return startInstance(client, i)
+}
+
+// instanceWithClient returns the instance of the machine and an ec2 client for its region
+func (p *provider) instanceWithClient(machine *v1alpha1.Machine) (instanceStartClient, *ec2.Instance, error) {
+	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return nil, nil, err
+	}
+	i, err := p.Get(machine)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ec2Client, i.(*awsInstance).instance, nil
+}
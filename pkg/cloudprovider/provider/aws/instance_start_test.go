@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestStopAndStartInstance(t *testing.T) {
+	tests := []struct {
+		name            string
+		state           string
+		start           bool
+		expectedDone    bool
+		expectedStopped int
+		expectedStarted int
+	}{
+		{
+			name:            "running instance gets stopped",
+			state:           ec2.InstanceStateNameRunning,
+			expectedStopped: 1,
+		},
+		{
+			name:  "stopping instance is waited for",
+			state: ec2.InstanceStateNameStopping,
+		},
+		{
+			name:         "stopped instance is done",
+			state:        ec2.InstanceStateNameStopped,
+			expectedDone: true,
+		},
+		{
+			name:            "stopped instance gets started",
+			state:           ec2.InstanceStateNameStopped,
+			start:           true,
+			expectedStarted: 1,
+		},
+		{
+			name:  "pending instance is waited for",
+			state: ec2.InstanceStateNamePending,
+			start: true,
+		},
+		{
+			name:         "running instance is started",
+			state:        ec2.InstanceStateNameRunning,
+			start:        true,
+			expectedDone: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeInstanceResizeClient{instance: &ec2.Instance{
+				InstanceId: aws.String("i-1"),
+				State:      &ec2.InstanceState{Name: aws.String(test.state)},
+			}}
+			var done bool
+			var err error
+			if test.start {
+				done, err = startInstance(client, client.instance)
+			} else {
+				done, err = stopInstance(client, client.instance)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != test.expectedDone {
+				t.Errorf("expected done: %v, got: %v", test.expectedDone, done)
+			}
+			if client.stopped != test.expectedStopped || client.started != test.expectedStarted {
+				t.Errorf("expected %d stops and %d starts, got %d and %d", test.expectedStopped, test.expectedStarted, client.stopped, client.started)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// validateInstanceStore makes sure the instance type has instance-store volumes and their device names
+// are not used by the root or an attached volume
+func validateInstanceStore(config *Config, rootDevicePath string) error {
+	if !config.InstanceStore {
+		return nil
+	}
+	it, ok := instanceTypes[config.InstanceType]
+	if !ok {
+		return fmt.Errorf("instance type %q is unknown, can not tell if it has instance-store volumes", config.InstanceType)
+	}
+	if it.instanceStoreVolumes == 0 {
+		return fmt.Errorf("instance type %q has no instance-store volumes", config.InstanceType)
+	}
+
+	devices := map[string]bool{rootDevicePath: true}
+	for _, volume := range config.AttachVolumes {
+		devices[volume.Device] = true
+	}
+	for _, mapping := range instanceStoreBlockDeviceMappings(config) {
+		if devices[aws.StringValue(mapping.DeviceName)] {
+			return fmt.Errorf("device %s of instance-store volume %s is already in use", aws.StringValue(mapping.DeviceName), aws.StringValue(mapping.VirtualName))
+		}
+	}
+	return nil
+}
+
+// instanceStoreBlockDeviceMappings maps the instance-store volumes of the instance type to /dev/sdb, /dev/sdc and so on.
+// Nitro instances expose them as NVMe devices with other names, the userdata finds them by their model.
+func instanceStoreBlockDeviceMappings(config *Config) []*ec2.BlockDeviceMapping {
+	if !config.InstanceStore {
+		return nil
+	}
+	var mappings []*ec2.BlockDeviceMapping
+	for i := 0; i < instanceTypes[config.InstanceType].instanceStoreVolumes; i++ {
+		mappings = append(mappings, &ec2.BlockDeviceMapping{
+			DeviceName:  aws.String(fmt.Sprintf("/dev/sd%c", 'b'+i)),
+			VirtualName: aws.String(fmt.Sprintf("ephemeral%d", i)),
+		})
+	}
+	return mappings
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestValidateInstanceStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "not set",
+			config: &Config{InstanceType: "t3.medium"},
+		},
+		{
+			name:   "instance type with instance-store volumes",
+			config: &Config{InstanceType: "i3.4xlarge", InstanceStore: true},
+		},
+		{
+			name:        "instance type without instance-store volumes",
+			config:      &Config{InstanceType: "m5.large", InstanceStore: true},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown instance type",
+			config:      &Config{InstanceType: "x9.large", InstanceStore: true},
+			expectedErr: true,
+		},
+		{
+			name: "device used by attached volume",
+			config: &Config{InstanceType: "i3.4xlarge", InstanceStore: true, AttachVolumes: []VolumeRef{
+				{VolumeID: "vol-1", Device: "/dev/sdc"},
+			}},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateInstanceStore(test.config, "/dev/sda1")
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestInstanceStoreBlockDeviceMappings(t *testing.T) {
+	if mappings := instanceStoreBlockDeviceMappings(&Config{InstanceType: "i3.4xlarge"}); len(mappings) != 0 {
+		t.Errorf("expected no mappings without instanceStore, got %v", mappings)
+	}
+
+	mappings := instanceStoreBlockDeviceMappings(&Config{InstanceType: "m5d.16xlarge", InstanceStore: true})
+	expected := [][2]string{
+		{"/dev/sdb", "ephemeral0"},
+		{"/dev/sdc", "ephemeral1"},
+		{"/dev/sdd", "ephemeral2"},
+		{"/dev/sde", "ephemeral3"},
+	}
+	if len(mappings) != len(expected) {
+		t.Fatalf("expected %d mappings, got %d", len(expected), len(mappings))
+	}
+	for i, mapping := range mappings {
+		if aws.StringValue(mapping.DeviceName) != expected[i][0] || aws.StringValue(mapping.VirtualName) != expected[i][1] {
+			t.Errorf("expected mapping %d to be %s=%s, got %s=%s", i, expected[i][0], expected[i][1],
+				aws.StringValue(mapping.DeviceName), aws.StringValue(mapping.VirtualName))
+		}
+		if mapping.Ebs != nil {
+			t.Errorf("expected mapping %d to have no ebs volume", i)
+		}
+	}
+}
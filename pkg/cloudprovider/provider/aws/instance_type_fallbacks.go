@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// insufficientCapacityErrorCode is returned by RunInstances if AWS has no capacity for the instance type in the zone
+const insufficientCapacityErrorCode = "InsufficientInstanceCapacity"
+
+// runInstancesClient is the subset of the ec2 client needed to launch instances
+type runInstancesClient interface {
+	RunInstances(*ec2.RunInstancesInput) (*ec2.Reservation, error)
+}
+
+// withInstanceType returns a copy of the config which requests the given instance type
+func (c *Config) withInstanceType(instanceType string) *Config {
+	fallback := *c
+	fallback.InstanceType = instanceType
+	return &fallback
+}
+
+// validateInstanceTypeFallbacks makes sure every fallback is available in the zone and can be launched with the
+// rest of the config
+func validateInstanceTypeFallbacks(client reservedInstancesOfferingsClient, config *Config, rootDevicePath string) error {
+	seen := map[string]bool{config.InstanceType: true}
+	for _, instanceType := range config.InstanceTypeFallbacks {
+		if instanceType == "" {
+			return fmt.Errorf("instanceTypeFallbacks must not contain empty instance types")
+		}
+		if seen[instanceType] {
+			return fmt.Errorf("instance type %s is specified more than once in instanceType and instanceTypeFallbacks", instanceType)
+		}
+		seen[instanceType] = true
+
+		fallback := config.withInstanceType(instanceType)
+		if err := validateNetworkInterfaces(instanceType, fallback.AdditionalNetworkInterfaces); err != nil {
+			return fmt.Errorf("invalid fallback instance type %s: %v", instanceType, err)
+		}
+		if err := validateCreditSpecification(instanceType, fallback.CreditSpecification); err != nil {
+			return fmt.Errorf("invalid fallback instance type %s: %v", instanceType, err)
+		}
+		if err := validateInstanceStore(fallback, rootDevicePath); err != nil {
+			return fmt.Errorf("invalid fallback instance type %s: %v", instanceType, err)
+		}
+		if err := validateInstanceTypeInZone(client, instanceType, config.AvailabilityZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInstance launches the instance with the requested instance type. If AWS is out of capacity for it, the fallbacks
+// are tried in order. newRequest returns the request for the given config
+func runInstance(client runInstancesClient, config *Config, newRequest func(*Config) *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	instanceTypes := append([]string{config.InstanceType}, config.InstanceTypeFallbacks...)
+	var err error
+	for i, instanceType := range instanceTypes {
+		var reservation *ec2.Reservation
+		if reservation, err = client.RunInstances(newRequest(config.withInstanceType(instanceType))); err == nil {
+			return reservation, nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != insufficientCapacityErrorCode {
+			return nil, err
+		}
+		if i < len(instanceTypes)-1 {
+			glog.V(3).Infof("No capacity for instance type %s in zone %s, falling back to %s", instanceType, config.AvailabilityZone, instanceTypes[i+1])
+		}
+	}
+	return nil, err
+}
+
+// isInstanceTypeFallback returns true if the instance type is one of the fallbacks of the config
+func isInstanceTypeFallback(config *Config, instanceType string) bool {
+	for _, fallback := range config.InstanceTypeFallbacks {
+		if fallback == instanceType {
+			return true
+		}
+	}
+	return false
+}
+
+// recordInstanceType stores the instance type the instance got launched with in the provider status of the machine
+func recordInstanceType(data *cloudprovidertypes.MachineCreateDeleteData, machine *v1alpha1.Machine, instanceType string) error {
+	var modifyErr error
+	if _, err := data.Updater(machine, func(m *v1alpha1.Machine) {
+		providerStatus, err := providerconfig.GetProviderStatus(m.Status.ProviderStatus)
+		if err != nil {
+			modifyErr = fmt.Errorf("failed to get provider status: %v", err)
+			return
+		}
+		providerStatus.InstanceType = instanceType
+		if m.Status.ProviderStatus, err = providerStatus.RawExtension(); err != nil {
+			modifyErr = fmt.Errorf("failed to marshal provider status: %v", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to record instance type %s: %v", instanceType, err)
+	}
+	return modifyErr
+}
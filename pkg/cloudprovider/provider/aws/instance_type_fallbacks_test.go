@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeRunInstancesClient fails to launch the instance types which have an error
+type fakeRunInstancesClient struct {
+	errors    map[string]error
+	requested []string
+}
+
+func (f *fakeRunInstancesClient) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	instanceType := aws.StringValue(input.InstanceType)
+	f.requested = append(f.requested, instanceType)
+	if err := f.errors[instanceType]; err != nil {
+		return nil, err
+	}
+	return &ec2.Reservation{Instances: []*ec2.Instance{{InstanceId: aws.String("i-1"), InstanceType: input.InstanceType}}}, nil
+}
+
+func TestRunInstance(t *testing.T) {
+	capacityErr := awserr.New(insufficientCapacityErrorCode, "We currently do not have sufficient capacity", nil)
+	config := &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{"m5d.xlarge", "r5d.large"}, InstanceStore: true}
+	newRequest := func(c *Config) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			InstanceType:        aws.String(c.InstanceType),
+			BlockDeviceMappings: instanceStoreBlockDeviceMappings(c),
+		}
+	}
+
+	tests := []struct {
+		name              string
+		errors            map[string]error
+		expectedRequested []string
+		expectedType      string
+		expectedErr       bool
+	}{
+		{
+			name:              "capacity for the instance type",
+			expectedRequested: []string{"m5d.large"},
+			expectedType:      "m5d.large",
+		},
+		{
+			name:              "capacity error falls back to the next instance type",
+			errors:            map[string]error{"m5d.large": capacityErr},
+			expectedRequested: []string{"m5d.large", "m5d.xlarge"},
+			expectedType:      "m5d.xlarge",
+		},
+		{
+			name:              "other errors do not fall back",
+			errors:            map[string]error{"m5d.large": awserr.New("InstanceLimitExceeded", "limit exceeded", nil)},
+			expectedRequested: []string{"m5d.large"},
+			expectedErr:       true,
+		},
+		{
+			name:              "no capacity for any instance type",
+			errors:            map[string]error{"m5d.large": capacityErr, "m5d.xlarge": capacityErr, "r5d.large": capacityErr},
+			expectedRequested: []string{"m5d.large", "m5d.xlarge", "r5d.large"},
+			expectedErr:       true,
+		},
+		{
+			name:              "non aws errors do not fall back",
+			errors:            map[string]error{"m5d.large": errors.New("connection reset")},
+			expectedRequested: []string{"m5d.large"},
+			expectedErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeRunInstancesClient{errors: test.errors}
+			reservation, err := runInstance(client, config, newRequest)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+			if !reflect.DeepEqual(client.requested, test.expectedRequested) {
+				t.Errorf("expected requested instance types %v, got %v", test.expectedRequested, client.requested)
+			}
+			if err == nil && aws.StringValue(reservation.Instances[0].InstanceType) != test.expectedType {
+				t.Errorf("expected instance type %s, got %s", test.expectedType, aws.StringValue(reservation.Instances[0].InstanceType))
+			}
+		})
+	}
+}
+
+func TestValidateInstanceTypeFallbacks(t *testing.T) {
+	client := &fakeReservedInstancesOfferingsClient{zones: map[string][]string{
+		"m5d.xlarge": {"eu-central-1a"},
+		"m5.xlarge":  {"eu-central-1a"},
+		"r5d.large":  {"eu-central-1b"},
+	}}
+
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "no fallbacks",
+			config: &Config{InstanceType: "m5d.large", AvailabilityZone: "eu-central-1a"},
+		},
+		{
+			name:   "fallback available in zone",
+			config: &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{"m5d.xlarge"}, AvailabilityZone: "eu-central-1a"},
+		},
+		{
+			name:        "fallback not available in zone",
+			config:      &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{"r5d.large"}, AvailabilityZone: "eu-central-1a"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown fallback",
+			config:      &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{"x9.large"}, AvailabilityZone: "eu-central-1a"},
+			expectedErr: true,
+		},
+		{
+			name:        "fallback is the instance type",
+			config:      &Config{InstanceType: "m5d.xlarge", InstanceTypeFallbacks: []string{"m5d.xlarge"}, AvailabilityZone: "eu-central-1a"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty fallback",
+			config:      &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{""}, AvailabilityZone: "eu-central-1a"},
+			expectedErr: true,
+		},
+		{
+			name: "fallback without instance-store volumes",
+			config: &Config{InstanceType: "m5d.large", InstanceTypeFallbacks: []string{"m5.xlarge"}, AvailabilityZone: "eu-central-1a",
+				InstanceStore: true},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateInstanceTypeFallbacks(client, test.config, "/dev/sda1")
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
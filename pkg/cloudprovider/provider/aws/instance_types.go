@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+)
+
+type instanceType struct {
+	vCPUs    int64
+	memoryMB int64
+	gpus     int
+	// instanceStoreVolumes is the number of NVMe instance-store volumes
+	instanceStoreVolumes int
+}
+
+// instanceTypes contains the resources of commonly used instance types.
+// The vendored SDK has no DescribeInstanceTypes, so the list is maintained here.
+// https://aws.amazon.com/ec2/instance-types/
+var instanceTypes = map[string]instanceType{
+	"t2.nano":    {vCPUs: 1, memoryMB: 512},
+	"t2.micro":   {vCPUs: 1, memoryMB: 1024},
+	"t2.small":   {vCPUs: 1, memoryMB: 2048},
+	"t2.medium":  {vCPUs: 2, memoryMB: 4096},
+	"t2.large":   {vCPUs: 2, memoryMB: 8192},
+	"t2.xlarge":  {vCPUs: 4, memoryMB: 16384},
+	"t2.2xlarge": {vCPUs: 8, memoryMB: 32768},
+
+	"t3.nano":    {vCPUs: 2, memoryMB: 512},
+	"t3.micro":   {vCPUs: 2, memoryMB: 1024},
+	"t3.small":   {vCPUs: 2, memoryMB: 2048},
+	"t3.medium":  {vCPUs: 2, memoryMB: 4096},
+	"t3.large":   {vCPUs: 2, memoryMB: 8192},
+	"t3.xlarge":  {vCPUs: 4, memoryMB: 16384},
+	"t3.2xlarge": {vCPUs: 8, memoryMB: 32768},
+
+	"m5.large":    {vCPUs: 2, memoryMB: 8192},
+	"m5.xlarge":   {vCPUs: 4, memoryMB: 16384},
+	"m5.2xlarge":  {vCPUs: 8, memoryMB: 32768},
+	"m5.4xlarge":  {vCPUs: 16, memoryMB: 65536},
+	"m5.8xlarge":  {vCPUs: 32, memoryMB: 131072},
+	"m5.12xlarge": {vCPUs: 48, memoryMB: 196608},
+	"m5.16xlarge": {vCPUs: 64, memoryMB: 262144},
+	"m5.24xlarge": {vCPUs: 96, memoryMB: 393216},
+
+	"c5.large":    {vCPUs: 2, memoryMB: 4096},
+	"c5.xlarge":   {vCPUs: 4, memoryMB: 8192},
+	"c5.2xlarge":  {vCPUs: 8, memoryMB: 16384},
+	"c5.4xlarge":  {vCPUs: 16, memoryMB: 32768},
+	"c5.9xlarge":  {vCPUs: 36, memoryMB: 73728},
+	"c5.12xlarge": {vCPUs: 48, memoryMB: 98304},
+	"c5.18xlarge": {vCPUs: 72, memoryMB: 147456},
+	"c5.24xlarge": {vCPUs: 96, memoryMB: 196608},
+
+	"r5.large":    {vCPUs: 2, memoryMB: 16384},
+	"r5.xlarge":   {vCPUs: 4, memoryMB: 32768},
+	"r5.2xlarge":  {vCPUs: 8, memoryMB: 65536},
+	"r5.4xlarge":  {vCPUs: 16, memoryMB: 131072},
+	"r5.8xlarge":  {vCPUs: 32, memoryMB: 262144},
+	"r5.12xlarge": {vCPUs: 48, memoryMB: 393216},
+	"r5.16xlarge": {vCPUs: 64, memoryMB: 524288},
+	"r5.24xlarge": {vCPUs: 96, memoryMB: 786432},
+
+	"m5d.large":    {vCPUs: 2, memoryMB: 8192, instanceStoreVolumes: 1},
+	"m5d.xlarge":   {vCPUs: 4, memoryMB: 16384, instanceStoreVolumes: 1},
+	"m5d.2xlarge":  {vCPUs: 8, memoryMB: 32768, instanceStoreVolumes: 1},
+	"m5d.4xlarge":  {vCPUs: 16, memoryMB: 65536, instanceStoreVolumes: 2},
+	"m5d.8xlarge":  {vCPUs: 32, memoryMB: 131072, instanceStoreVolumes: 2},
+	"m5d.12xlarge": {vCPUs: 48, memoryMB: 196608, instanceStoreVolumes: 2},
+	"m5d.16xlarge": {vCPUs: 64, memoryMB: 262144, instanceStoreVolumes: 4},
+	"m5d.24xlarge": {vCPUs: 96, memoryMB: 393216, instanceStoreVolumes: 4},
+
+	"c5d.large":    {vCPUs: 2, memoryMB: 4096, instanceStoreVolumes: 1},
+	"c5d.xlarge":   {vCPUs: 4, memoryMB: 8192, instanceStoreVolumes: 1},
+	"c5d.2xlarge":  {vCPUs: 8, memoryMB: 16384, instanceStoreVolumes: 1},
+	"c5d.4xlarge":  {vCPUs: 16, memoryMB: 32768, instanceStoreVolumes: 1},
+	"c5d.9xlarge":  {vCPUs: 36, memoryMB: 73728, instanceStoreVolumes: 1},
+	"c5d.12xlarge": {vCPUs: 48, memoryMB: 98304, instanceStoreVolumes: 2},
+	"c5d.18xlarge": {vCPUs: 72, memoryMB: 147456, instanceStoreVolumes: 2},
+	"c5d.24xlarge": {vCPUs: 96, memoryMB: 196608, instanceStoreVolumes: 4},
+
+	"r5d.large":    {vCPUs: 2, memoryMB: 16384, instanceStoreVolumes: 1},
+	"r5d.xlarge":   {vCPUs: 4, memoryMB: 32768, instanceStoreVolumes: 1},
+	"r5d.2xlarge":  {vCPUs: 8, memoryMB: 65536, instanceStoreVolumes: 1},
+	"r5d.4xlarge":  {vCPUs: 16, memoryMB: 131072, instanceStoreVolumes: 2},
+	"r5d.8xlarge":  {vCPUs: 32, memoryMB: 262144, instanceStoreVolumes: 2},
+	"r5d.12xlarge": {vCPUs: 48, memoryMB: 393216, instanceStoreVolumes: 2},
+	"r5d.16xlarge": {vCPUs: 64, memoryMB: 524288, instanceStoreVolumes: 4},
+	"r5d.24xlarge": {vCPUs: 96, memoryMB: 786432, instanceStoreVolumes: 4},
+
+	"i3.large":    {vCPUs: 2, memoryMB: 15616, instanceStoreVolumes: 1},
+	"i3.xlarge":   {vCPUs: 4, memoryMB: 31232, instanceStoreVolumes: 1},
+	"i3.2xlarge":  {vCPUs: 8, memoryMB: 62464, instanceStoreVolumes: 1},
+	"i3.4xlarge":  {vCPUs: 16, memoryMB: 124928, instanceStoreVolumes: 2},
+	"i3.8xlarge":  {vCPUs: 32, memoryMB: 249856, instanceStoreVolumes: 4},
+	"i3.16xlarge": {vCPUs: 64, memoryMB: 499712, instanceStoreVolumes: 8},
+
+	"p3.2xlarge":  {vCPUs: 8, memoryMB: 62464, gpus: 1},
+	"p3.8xlarge":  {vCPUs: 32, memoryMB: 249856, gpus: 4},
+	"p3.16xlarge": {vCPUs: 64, memoryMB: 499712, gpus: 8},
+
+	"g4dn.xlarge":   {vCPUs: 4, memoryMB: 16384, gpus: 1, instanceStoreVolumes: 1},
+	"g4dn.2xlarge":  {vCPUs: 8, memoryMB: 32768, gpus: 1, instanceStoreVolumes: 1},
+	"g4dn.4xlarge":  {vCPUs: 16, memoryMB: 65536, gpus: 1, instanceStoreVolumes: 1},
+	"g4dn.8xlarge":  {vCPUs: 32, memoryMB: 131072, gpus: 1, instanceStoreVolumes: 1},
+	"g4dn.12xlarge": {vCPUs: 48, memoryMB: 196608, gpus: 4, instanceStoreVolumes: 1},
+	"g4dn.16xlarge": {vCPUs: 64, memoryMB: 262144, gpus: 1, instanceStoreVolumes: 1},
+}
+
+// instanceTypeCapacity returns the capacity of the given instance type or nil if it is unknown
+func instanceTypeCapacity(name string) *cloudprovidertypes.MachineCapacity {
+	it, ok := instanceTypes[name]
+	if !ok {
+		return nil
+	}
+	return &cloudprovidertypes.MachineCapacity{
+		CPU:    *resource.NewQuantity(it.vCPUs, resource.DecimalSI),
+		Memory: *resource.NewQuantity(it.memoryMB*1024*1024, resource.BinarySI),
+		GPUs:   it.gpus,
+	}
+}
+
+// reservedInstancesOfferingsClient is the part of the EC2 API needed to check in which zones an instance type exists
+type reservedInstancesOfferingsClient interface {
+	DescribeReservedInstancesOfferings(*ec2.DescribeReservedInstancesOfferingsInput) (*ec2.DescribeReservedInstancesOfferingsOutput, error)
+}
+
+// validateInstanceTypeInZone makes sure the instance type can be launched in the availability zone.
+// The vendored SDK has no DescribeInstanceTypeOfferings, but AWS offers reserved instances for every
+// instance type in every zone it is available in.
+func validateInstanceTypeInZone(client reservedInstancesOfferingsClient, instanceType, zone string) error {
+	out, err := client.DescribeReservedInstancesOfferings(&ec2.DescribeReservedInstancesOfferingsInput{
+		AvailabilityZone:   aws.String(zone),
+		InstanceType:       aws.String(instanceType),
+		IncludeMarketplace: aws.Bool(false),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check if instance type %q is available in zone %q: %v", instanceType, zone, err)
+	}
+	if len(out.ReservedInstancesOfferings) == 0 {
+		return fmt.Errorf("instance type %q is not available in zone %q", instanceType, zone)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeReservedInstancesOfferingsClient offers reserved instances for the instance types in the zones
+type fakeReservedInstancesOfferingsClient struct {
+	zones map[string][]string
+}
+
+func (f *fakeReservedInstancesOfferingsClient) DescribeReservedInstancesOfferings(input *ec2.DescribeReservedInstancesOfferingsInput) (*ec2.DescribeReservedInstancesOfferingsOutput, error) {
+	out := &ec2.DescribeReservedInstancesOfferingsOutput{}
+	for _, zone := range f.zones[aws.StringValue(input.InstanceType)] {
+		if zone == aws.StringValue(input.AvailabilityZone) {
+			out.ReservedInstancesOfferings = append(out.ReservedInstancesOfferings, &ec2.ReservedInstancesOffering{
+				AvailabilityZone: aws.String(zone),
+				InstanceType:     input.InstanceType,
+			})
+		}
+	}
+	return out, nil
+}
+
+func TestValidateInstanceTypeInZone(t *testing.T) {
+	client := &fakeReservedInstancesOfferingsClient{zones: map[string][]string{
+		"t2.medium":  {"eu-central-1a", "eu-central-1b"},
+		"p3.2xlarge": {"eu-central-1a"},
+	}}
+
+	tests := []struct {
+		name          string
+		instanceType  string
+		zone          string
+		expectedError bool
+	}{
+		{
+			name:         "instance type available in zone",
+			instanceType: "t2.medium",
+			zone:         "eu-central-1b",
+		},
+		{
+			name:          "instance type not available in zone",
+			instanceType:  "p3.2xlarge",
+			zone:          "eu-central-1b",
+			expectedError: true,
+		},
+		{
+			name:          "unknown instance type",
+			instanceType:  "x9.large",
+			zone:          "eu-central-1a",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateInstanceTypeInZone(client, test.instanceType, test.zone)
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// maxNetworkInterfacesBySize is the number of network interfaces an instance supports
+// depending on its size. Some families support more, this is the lowest common limit.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/using-eni.html#AvailableIpPerENI
+var maxNetworkInterfacesBySize = map[string]int{
+	"nano":     2,
+	"micro":    2,
+	"small":    2,
+	"medium":   2,
+	"large":    3,
+	"xlarge":   4,
+	"2xlarge":  4,
+	"4xlarge":  8,
+	"8xlarge":  8,
+	"9xlarge":  8,
+	"12xlarge": 8,
+	"16xlarge": 15,
+	"18xlarge": 15,
+	"24xlarge": 15,
+	"metal":    15,
+}
+
+// storageNetworkInterfaceDescription is the description of the network interface which carries the storage traffic
+const storageNetworkInterfaceDescription = "storage"
+
+// NetworkInterface is an additional network interface of an instance
+type NetworkInterface struct {
+	SubnetID         string
+	SecurityGroupIDs []string
+	Storage          bool
+}
+
+// maxNetworkInterfaces returns the number of network interfaces the instance type supports.
+// false gets returned if the limit of the instance type is unknown.
+func maxNetworkInterfaces(instanceType string) (int, bool) {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	max, ok := maxNetworkInterfacesBySize[parts[1]]
+	return max, ok
+}
+
+// validateNetworkInterfaces checks the additional network interfaces against the limit of the instance type
+func validateNetworkInterfaces(instanceType string, additionalInterfaces []NetworkInterface) error {
+	storageInterfaces := 0
+	for i, ifc := range additionalInterfaces {
+		if ifc.SubnetID == "" {
+			return fmt.Errorf("subnetId of additional network interface %d must be specified", i)
+		}
+		if len(ifc.SecurityGroupIDs) == 0 {
+			return fmt.Errorf("no security groups were specified for additional network interface %d", i)
+		}
+		if ifc.Storage {
+			storageInterfaces++
+		}
+	}
+	if storageInterfaces > 1 {
+		return fmt.Errorf("only one additional network interface can be the storage interface, got %d", storageInterfaces)
+	}
+	max, ok := maxNetworkInterfaces(instanceType)
+	if ok && len(additionalInterfaces)+1 > max {
+		return fmt.Errorf("instance type %s supports at most %d network interfaces, got %d", instanceType, max, len(additionalInterfaces)+1)
+	}
+	return nil
+}
+
+// networkInterfaceSpecifications returns the network interfaces for the RunInstances request.
+// The security groups of the primary interface get set after the instance got created.
+func networkInterfaceSpecifications(config *Config) []*ec2.InstanceNetworkInterfaceSpecification {
+	primary := &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex:         aws.Int64(0), // eth0
+		DeleteOnTermination: aws.Bool(true),
+		SubnetId:            aws.String(config.SubnetID),
+	}
+	// AWS refuses to assign a public IP to instances with multiple network interfaces. Otherwise it is set
+	// explicitly, so subnets which assign public IPs by default do not override the config
+	if len(config.AdditionalNetworkInterfaces) == 0 {
+		primary.AssociatePublicIpAddress = aws.Bool(config.AssignPublicIP)
+	}
+
+	specs := []*ec2.InstanceNetworkInterfaceSpecification{primary}
+	for i, ifc := range config.AdditionalNetworkInterfaces {
+		spec := &ec2.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:         aws.Int64(int64(i + 1)),
+			DeleteOnTermination: aws.Bool(true),
+			SubnetId:            aws.String(ifc.SubnetID),
+			Groups:              aws.StringSlice(ifc.SecurityGroupIDs),
+		}
+		if ifc.Storage {
+			spec.Description = aws.String(storageNetworkInterfaceDescription)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// disableSourceDestCheck disables the source/destination check on all network interfaces of the instance,
+// which is required for instances which route traffic
+func disableSourceDestCheck(client *ec2.EC2, instance *ec2.Instance) error {
+	for _, ifc := range instance.NetworkInterfaces {
+		if _, err := client.ModifyNetworkInterfaceAttribute(&ec2.ModifyNetworkInterfaceAttributeInput{
+			NetworkInterfaceId: ifc.NetworkInterfaceId,
+			SourceDestCheck:    &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+		}); err != nil {
+			return fmt.Errorf("failed to disable source/destination check of network interface %s: %v", aws.StringValue(ifc.NetworkInterfaceId), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/go-test/deep"
+)
+
+func TestNetworkInterfaceSpecifications(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		expected []*ec2.InstanceNetworkInterfaceSpecification
+	}{
+		{
+			name:   "single network interface with public ip",
+			config: &Config{SubnetID: "subnet-1", AssignPublicIP: true},
+			expected: []*ec2.InstanceNetworkInterfaceSpecification{
+				{
+					DeviceIndex:              aws.Int64(0),
+					AssociatePublicIpAddress: aws.Bool(true),
+					DeleteOnTermination:      aws.Bool(true),
+					SubnetId:                 aws.String("subnet-1"),
+				},
+			},
+		},
+		{
+			name: "additional network interfaces",
+			config: &Config{
+				SubnetID: "subnet-1",
+				AdditionalNetworkInterfaces: []NetworkInterface{
+					{SubnetID: "subnet-2", SecurityGroupIDs: []string{"sg-2"}},
+					{SubnetID: "subnet-3", SecurityGroupIDs: []string{"sg-3", "sg-4"}},
+				},
+			},
+			expected: []*ec2.InstanceNetworkInterfaceSpecification{
+				{
+					DeviceIndex:         aws.Int64(0),
+					DeleteOnTermination: aws.Bool(true),
+					SubnetId:            aws.String("subnet-1"),
+				},
+				{
+					DeviceIndex:         aws.Int64(1),
+					DeleteOnTermination: aws.Bool(true),
+					SubnetId:            aws.String("subnet-2"),
+					Groups:              aws.StringSlice([]string{"sg-2"}),
+				},
+				{
+					DeviceIndex:         aws.Int64(2),
+					DeleteOnTermination: aws.Bool(true),
+					SubnetId:            aws.String("subnet-3"),
+					Groups:              aws.StringSlice([]string{"sg-3", "sg-4"}),
+				},
+			},
+		},
+		{
+			name: "storage network interface",
+			config: &Config{
+				SubnetID: "subnet-1",
+				AdditionalNetworkInterfaces: []NetworkInterface{
+					{SubnetID: "subnet-storage", SecurityGroupIDs: []string{"sg-storage"}, Storage: true},
+				},
+			},
+			expected: []*ec2.InstanceNetworkInterfaceSpecification{
+				{
+					DeviceIndex:         aws.Int64(0),
+					DeleteOnTermination: aws.Bool(true),
+					SubnetId:            aws.String("subnet-1"),
+				},
+				{
+					DeviceIndex:         aws.Int64(1),
+					DeleteOnTermination: aws.Bool(true),
+					SubnetId:            aws.String("subnet-storage"),
+					Groups:              aws.StringSlice([]string{"sg-storage"}),
+					Description:         aws.String("storage"),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := deep.Equal(networkInterfaceSpecifications(test.config), test.expected); diff != nil {
+				t.Errorf("unexpected network interfaces, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestValidateNetworkInterfaces(t *testing.T) {
+	additionalInterface := NetworkInterface{SubnetID: "subnet-2", SecurityGroupIDs: []string{"sg-2"}}
+	storageInterface := NetworkInterface{SubnetID: "subnet-3", SecurityGroupIDs: []string{"sg-3"}, Storage: true}
+	tests := []struct {
+		name                 string
+		instanceType         string
+		additionalInterfaces []NetworkInterface
+		expectedErr          bool
+	}{
+		{
+			name:         "no additional network interfaces",
+			instanceType: "t3.micro",
+		},
+		{
+			name:                 "within the limit",
+			instanceType:         "m5.large",
+			additionalInterfaces: []NetworkInterface{additionalInterface, additionalInterface},
+		},
+		{
+			name:                 "exceeds the limit",
+			instanceType:         "m5.large",
+			additionalInterfaces: []NetworkInterface{additionalInterface, additionalInterface, additionalInterface},
+			expectedErr:          true,
+		},
+		{
+			name:                 "unknown instance size",
+			instanceType:         "x1e.32xlarge",
+			additionalInterfaces: []NetworkInterface{additionalInterface, additionalInterface, additionalInterface},
+		},
+		{
+			name:                 "one storage interface",
+			instanceType:         "m5.large",
+			additionalInterfaces: []NetworkInterface{additionalInterface, storageInterface},
+		},
+		{
+			name:                 "multiple storage interfaces",
+			instanceType:         "m5.xlarge",
+			additionalInterfaces: []NetworkInterface{storageInterface, storageInterface},
+			expectedErr:          true,
+		},
+		{
+			name:                 "missing subnet",
+			instanceType:         "m5.xlarge",
+			additionalInterfaces: []NetworkInterface{{SecurityGroupIDs: []string{"sg-2"}}},
+			expectedErr:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateNetworkInterfaces(test.instanceType, test.additionalInterfaces)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// placementGroupClient is the subset of the ec2 client needed to manage placement groups
+type placementGroupClient interface {
+	CreatePlacementGroup(*ec2.CreatePlacementGroupInput) (*ec2.CreatePlacementGroupOutput, error)
+	DeletePlacementGroup(*ec2.DeletePlacementGroupInput) (*ec2.DeletePlacementGroupOutput, error)
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
+
+// instancePlacement returns the placement of the instance, placementGroup is optional
+func instancePlacement(config *Config, placementGroup string) *ec2.Placement {
+	placement := &ec2.Placement{
+		AvailabilityZone: aws.String(config.AvailabilityZone),
+	}
+	if placementGroup != "" {
+		placement.GroupName = aws.String(placementGroup)
+	}
+	return placement
+}
+
+// ensurePlacementGroup creates the spread placement group unless it already exists.
+// Spread groups place each instance on distinct hardware, with at most 7 instances per availability zone.
+func ensurePlacementGroup(client placementGroupClient, name string) error {
+	_, err := client.CreatePlacementGroup(&ec2.CreatePlacementGroupInput{
+		GroupName: aws.String(name),
+		Strategy:  aws.String(ec2.PlacementStrategySpread),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidPlacementGroup.Duplicate" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create placement group %s: %v", name, err)
+	}
+	return nil
+}
+
+// deletePlacementGroupIfEmpty deletes the placement group once no instance is left in it
+func deletePlacementGroupIfEmpty(client placementGroupClient, name string) error {
+	out, err := client.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("placement-group-name"), Values: aws.StringSlice([]string{name})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending,
+				ec2.InstanceStateNameRunning,
+				ec2.InstanceStateNameShuttingDown,
+				ec2.InstanceStateNameStopping,
+				ec2.InstanceStateNameStopped,
+			})},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list instances of placement group %s: %v", name, err)
+	}
+	for _, reservation := range out.Reservations {
+		if len(reservation.Instances) > 0 {
+			return nil
+		}
+	}
+
+	_, err = client.DeletePlacementGroup(&ec2.DeletePlacementGroupInput{GroupName: aws.String(name)})
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		// Already deleted or another instance got placed in the meantime
+		case "InvalidPlacementGroup.Unknown", "InvalidPlacementGroup.InUse":
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete placement group %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakePlacementGroupClient keeps the placement groups and their instances in memory
+type fakePlacementGroupClient struct {
+	groups    map[string]string
+	instances map[string][]string
+}
+
+func (f *fakePlacementGroupClient) CreatePlacementGroup(input *ec2.CreatePlacementGroupInput) (*ec2.CreatePlacementGroupOutput, error) {
+	name := aws.StringValue(input.GroupName)
+	if _, exists := f.groups[name]; exists {
+		return nil, awserr.New("InvalidPlacementGroup.Duplicate", "the placement group already exists", nil)
+	}
+	f.groups[name] = aws.StringValue(input.Strategy)
+	return &ec2.CreatePlacementGroupOutput{}, nil
+}
+
+func (f *fakePlacementGroupClient) DeletePlacementGroup(input *ec2.DeletePlacementGroupInput) (*ec2.DeletePlacementGroupOutput, error) {
+	delete(f.groups, aws.StringValue(input.GroupName))
+	return &ec2.DeletePlacementGroupOutput{}, nil
+}
+
+func (f *fakePlacementGroupClient) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	out := &ec2.DescribeInstancesOutput{}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "placement-group-name" {
+			continue
+		}
+		reservation := &ec2.Reservation{}
+		for _, id := range f.instances[aws.StringValue(filter.Values[0])] {
+			reservation.Instances = append(reservation.Instances, &ec2.Instance{InstanceId: aws.String(id)})
+		}
+		out.Reservations = append(out.Reservations, reservation)
+	}
+	return out, nil
+}
+
+func TestManagedPlacementGroup(t *testing.T) {
+	const groupName = "machine-controller-kube-system-my-workers"
+	client := &fakePlacementGroupClient{groups: map[string]string{}, instances: map[string][]string{}}
+
+	// Creating the group must be idempotent, as every instance of the MachineDeployment ensures it
+	for i := 0; i < 2; i++ {
+		if err := ensurePlacementGroup(client, groupName); err != nil {
+			t.Fatalf("failed to ensure placement group: %v", err)
+		}
+	}
+	if strategy := client.groups[groupName]; strategy != ec2.PlacementStrategySpread {
+		t.Errorf("expected a %s placement group, got %q", ec2.PlacementStrategySpread, strategy)
+	}
+
+	placement := instancePlacement(&Config{AvailabilityZone: "eu-central-1a"}, groupName)
+	if aws.StringValue(placement.GroupName) != groupName {
+		t.Errorf("expected the instance to be placed in %s, got %q", groupName, aws.StringValue(placement.GroupName))
+	}
+	if aws.StringValue(placement.AvailabilityZone) != "eu-central-1a" {
+		t.Errorf("expected the instance to be placed in eu-central-1a, got %q", aws.StringValue(placement.AvailabilityZone))
+	}
+
+	client.instances[groupName] = []string{"i-2"}
+	if err := deletePlacementGroupIfEmpty(client, groupName); err != nil {
+		t.Fatalf("failed to clean up placement group: %v", err)
+	}
+	if _, exists := client.groups[groupName]; !exists {
+		t.Errorf("expected placement group with instances to be kept")
+	}
+
+	client.instances[groupName] = nil
+	if err := deletePlacementGroupIfEmpty(client, groupName); err != nil {
+		t.Fatalf("failed to clean up placement group: %v", err)
+	}
+	if _, exists := client.groups[groupName]; exists {
+		t.Errorf("expected empty placement group to be deleted")
+	}
+}
+
+func TestInstancePlacementWithoutGroup(t *testing.T) {
+	placement := instancePlacement(&Config{AvailabilityZone: "eu-central-1a"}, "")
+	if placement.GroupName != nil {
+		t.Errorf("expected no placement group, got %q", aws.StringValue(placement.GroupName))
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// hostnameTypeIPName derives the hostname of the instance from its private IPv4 address, e.g. ip-10-0-0-1
+	hostnameTypeIPName = "ip-name"
+	// hostnameTypeResourceName derives the hostname of the instance from its ID, e.g. i-0123456789abcdef0
+	hostnameTypeResourceName = "resource-name"
+)
+
+var hostnameTypes = sets.NewString(hostnameTypeIPName, hostnameTypeResourceName)
+
+// PrivateDNSNameOptions sets the type of the hostname of the instance and which DNS records get created for the
+// resource-name based hostname
+type PrivateDNSNameOptions struct {
+	// HostnameType is ip-name or resource-name. Empty keeps the hostname type of the subnet
+	HostnameType                    string
+	EnableResourceNameDNSARecord    *bool
+	EnableResourceNameDNSAAAARecord *bool
+}
+
+func validatePrivateDNSNameOptions(config *Config) error {
+	if config.PrivateDNSNameOptions == nil || config.PrivateDNSNameOptions.HostnameType == "" {
+		return nil
+	}
+	if !hostnameTypes.Has(config.PrivateDNSNameOptions.HostnameType) {
+		return fmt.Errorf("invalid privateDnsNameOptions.hostnameType %q, supported: %v", config.PrivateDNSNameOptions.HostnameType, hostnameTypes.List())
+	}
+	return nil
+}
+
+// privateDNSNameOptionsClient sends the private DNS name options with the RunInstances request. The vendored SDK
+// predates them, so they get added to the encoded query of the request
+type privateDNSNameOptionsClient struct {
+	*ec2.EC2
+	options *PrivateDNSNameOptions
+}
+
+// runInstancesClientFor returns the client which launches the instances of the config
+func runInstancesClientFor(client *ec2.EC2, config *Config) runInstancesClient {
+	if config.PrivateDNSNameOptions == nil {
+		return client
+	}
+	return privateDNSNameOptionsClient{EC2: client, options: config.PrivateDNSNameOptions}
+}
+
+func (c privateDNSNameOptionsClient) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	req, out := c.RunInstancesRequest(input)
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		addPrivateDNSNameOptions(r, c.options)
+	})
+	return out, req.Send()
+}
+
+// privateDNSNameOptionsParams returns the query parameters of the options
+func privateDNSNameOptionsParams(options *PrivateDNSNameOptions) url.Values {
+	params := url.Values{}
+	if options.HostnameType != "" {
+		params.Set("PrivateDnsNameOptions.HostnameType", options.HostnameType)
+	}
+	if options.EnableResourceNameDNSARecord != nil {
+		params.Set("PrivateDnsNameOptions.EnableResourceNameDnsARecord", strconv.FormatBool(*options.EnableResourceNameDNSARecord))
+	}
+	if options.EnableResourceNameDNSAAAARecord != nil {
+		params.Set("PrivateDnsNameOptions.EnableResourceNameDnsAAAARecord", strconv.FormatBool(*options.EnableResourceNameDNSAAAARecord))
+	}
+	return params
+}
+
+// addPrivateDNSNameOptions adds the options to the body the ec2query build handler encoded
+func addPrivateDNSNameOptions(r *request.Request, options *PrivateDNSNameOptions) {
+	if r.Error != nil {
+		return
+	}
+	raw, err := ioutil.ReadAll(r.GetBody())
+	if err != nil {
+		r.Error = awserr.New("SerializationError", "failed reading EC2 Query request", err)
+		return
+	}
+	body, err := url.ParseQuery(string(raw))
+	if err != nil {
+		r.Error = awserr.New("SerializationError", "failed decoding EC2 Query request", err)
+		return
+	}
+	for key, values := range privateDNSNameOptionsParams(options) {
+		body[key] = values
+	}
+	r.SetBufferBody([]byte(body.Encode()))
+}
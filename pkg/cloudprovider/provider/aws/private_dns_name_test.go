@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestValidatePrivateDNSNameOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     *PrivateDNSNameOptions
+		expectedErr bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:    "resource-name",
+			options: &PrivateDNSNameOptions{HostnameType: "resource-name", EnableResourceNameDNSARecord: aws.Bool(true)},
+		},
+		{
+			name:    "ip-name",
+			options: &PrivateDNSNameOptions{HostnameType: "ip-name"},
+		},
+		{
+			name:    "only records",
+			options: &PrivateDNSNameOptions{EnableResourceNameDNSAAAARecord: aws.Bool(false)},
+		},
+		{
+			name:        "invalid hostname type",
+			options:     &PrivateDNSNameOptions{HostnameType: "instance-id"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePrivateDNSNameOptions(&Config{PrivateDNSNameOptions: test.options})
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRunInstanceWithPrivateDNSNameOptions(t *testing.T) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request: %v", err)
+		}
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			t.Errorf("failed to parse request: %v", err)
+		}
+		requests = append(requests, values)
+		fmt.Fprint(w, `<RunInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><instancesSet><item><instanceId>i-0123456789abcdef0</instanceId></item></instancesSet></RunInstancesResponse>`)
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-central-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec2Client := ec2.New(sess)
+	newRequest := func(c *Config) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			InstanceType: aws.String(c.InstanceType),
+			MinCount:     aws.Int64(1),
+			MaxCount:     aws.Int64(1),
+		}
+	}
+
+	config := &Config{
+		InstanceType: "t3.medium",
+		PrivateDNSNameOptions: &PrivateDNSNameOptions{
+			HostnameType:                    "resource-name",
+			EnableResourceNameDNSARecord:    aws.Bool(true),
+			EnableResourceNameDNSAAAARecord: aws.Bool(false),
+		},
+	}
+	reservation, err := runInstance(runInstancesClientFor(ec2Client, config), config, newRequest)
+	if err != nil {
+		t.Fatalf("failed to run instance: %v", err)
+	}
+	if len(reservation.Instances) != 1 || aws.StringValue(reservation.Instances[0].InstanceId) != "i-0123456789abcdef0" {
+		t.Errorf("expected the launched instance in the reservation, got %v", reservation)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	for key, expected := range map[string]string{
+		"Action":                             "RunInstances",
+		"InstanceType":                       "t3.medium",
+		"PrivateDnsNameOptions.HostnameType": "resource-name",
+		"PrivateDnsNameOptions.EnableResourceNameDnsARecord":    "true",
+		"PrivateDnsNameOptions.EnableResourceNameDnsAAAARecord": "false",
+	} {
+		if value := requests[0].Get(key); value != expected {
+			t.Errorf("expected %s=%s in the request, got %q", key, expected, value)
+		}
+	}
+
+	config = &Config{InstanceType: "t3.medium"}
+	if _, err := runInstance(runInstancesClientFor(ec2Client, config), config, newRequest); err != nil {
+		t.Fatalf("failed to run instance: %v", err)
+	}
+	for key := range requests[1] {
+		if strings.HasPrefix(key, "PrivateDnsNameOptions.") {
+			t.Errorf("expected no private DNS name options if not set, got %s", key)
+		}
+	}
+}
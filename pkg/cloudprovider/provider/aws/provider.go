@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,12 +35,15 @@ import (
 	gocache "github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/identity"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/placementgroup"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	"github.com/kubermatic/machine-controller/pkg/userdata/convert"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -104,6 +108,11 @@ var (
 			// The AWS marketplace ID from Canonical
 			owner: "099720109477",
 		},
+		providerconfig.OperatingSystemWindows: {
+			description: "Microsoft Windows Server 2019 with Containers Locale English AMI provided by Amazon",
+			// The AWS account ID from Amazon
+			owner: "801119661308",
+		},
 	}
 
 	// cacheLock protects concurrent cache misses against a single key. This usually happens when multiple machines get created simultaneously
@@ -130,6 +139,124 @@ type RawConfig struct {
 	DiskSize     int64                          `json:"diskSize"`
 	DiskType     providerconfig.ConfigVarString `json:"diskType"`
 	Tags         map[string]string              `json:"tags"`
+
+	// InstanceTypeFallbacks are tried in order when AWS has no capacity for the instance type in the zone.
+	// The instance type the instance got launched with is recorded in .status.providerStatus.instanceType
+	InstanceTypeFallbacks []providerconfig.ConfigVarString `json:"instanceTypeFallbacks,omitempty"`
+
+	// RootDeviceName is the device name of the root volume, e.g. /dev/xvda. Defaults to the root device name of the AMI
+	RootDeviceName providerconfig.ConfigVarString `json:"rootDeviceName,omitempty"`
+	// BootVolumeSnapshotID is the ID of an EBS snapshot the root volume gets created from instead of the snapshot of
+	// the AMI. The snapshot must not be larger than the diskSize
+	BootVolumeSnapshotID providerconfig.ConfigVarString `json:"bootVolumeSnapshotID,omitempty"`
+
+	// PrivateDNSZoneID is the ID of a Route53 private hosted zone in which an A record gets created for each instance
+	PrivateDNSZoneID providerconfig.ConfigVarString `json:"privateDNSZoneID,omitempty"`
+
+	// AdditionalNetworkInterfaces get attached to the instance in addition to the primary one
+	AdditionalNetworkInterfaces []RawNetworkInterface `json:"additionalNetworkInterfaces,omitempty"`
+	// DisableSourceDestCheck disables the source/destination check of all network interfaces of the instance
+	DisableSourceDestCheck *bool `json:"disableSourceDestCheck,omitempty"`
+
+	// AttachVolumes are existing EBS volumes which get attached to the instance after it got created
+	AttachVolumes []RawVolumeRef `json:"attachVolumes,omitempty"`
+	// InstanceStore maps all instance-store volumes of the instance type. They still need to be formatted
+	// and mounted, e.g. with the instanceStore option of the operating system
+	InstanceStore *bool `json:"instanceStore,omitempty"`
+
+	// ManagedPlacementGroup places all instances of a MachineDeployment in a spread placement group,
+	// which gets created on demand and deleted once the last instance is gone
+	ManagedPlacementGroup *bool `json:"managedPlacementGroup,omitempty"`
+
+	// CreditSpecification is the CPU credit option of burstable instance types, either standard or unlimited
+	CreditSpecification providerconfig.ConfigVarString `json:"creditSpecification,omitempty"`
+
+	// CapacityReservation selects the On-Demand Capacity Reservations the instance can run in
+	CapacityReservation *RawCapacityReservation `json:"capacityReservation,omitempty"`
+
+	// AssignPublicIP assigns an ephemeral public IP to the instance. Defaults to true unless the instance has
+	// additional network interfaces or an ElasticIP
+	AssignPublicIP *bool `json:"assignPublicIP,omitempty"`
+	// ElasticIP gets associated with the instance instead of an ephemeral public IP
+	ElasticIP *RawElasticIP `json:"elasticIP,omitempty"`
+
+	// InstanceInitiatedShutdownBehavior tells if a shutdown of the operating system stops or terminates the instance.
+	// Defaults to stop
+	InstanceInitiatedShutdownBehavior providerconfig.ConfigVarString `json:"instanceInitiatedShutdownBehavior,omitempty"`
+
+	// ExternalID is an identifier allocated by an external system, e.g. for compliance audits. It gets set as
+	// External-ID tag of the instance and must not be used by the instance of another machine
+	ExternalID providerconfig.ConfigVarString `json:"externalID,omitempty"`
+
+	// BootstrapPointer gets set as instance tag, for images without a cloud-init datasource which reads the userdata
+	BootstrapPointer *RawBootstrapPointer `json:"bootstrapPointer,omitempty"`
+
+	// PrivateDNSNameOptions sets the type of the hostname of the instance and the DNS records which get created for it.
+	// Defaults to the settings of the subnet
+	PrivateDNSNameOptions *RawPrivateDNSNameOptions `json:"privateDnsNameOptions,omitempty"`
+
+	// SubnetSelector selects the subnet of the instance by its tags when creating it, instead of the subnetId
+	SubnetSelector *RawSubnetSelector `json:"subnetSelector,omitempty"`
+}
+
+// RawSubnetSelector selects the subnet of an instance by its tags
+type RawSubnetSelector struct {
+	// Tags which the subnet must carry, e.g. tier=private
+	Tags map[string]string `json:"tags"`
+	// Strategy is mostAvailableIPs, which picks the matching subnet with the most free IP addresses, or
+	// balanceAvailabilityZones, which picks one in the availability zone with the fewest instances of the
+	// MachineDeployment. Defaults to mostAvailableIPs
+	Strategy providerconfig.ConfigVarString `json:"strategy,omitempty"`
+}
+
+// RawPrivateDNSNameOptions are the private DNS name options of an instance
+type RawPrivateDNSNameOptions struct {
+	// HostnameType is ip-name, which derives the hostname from the private IPv4 address, or resource-name, which
+	// derives it from the instance ID
+	HostnameType providerconfig.ConfigVarString `json:"hostnameType,omitempty"`
+	// EnableResourceNameDNSARecord answers DNS queries for the resource-name hostname with A records
+	EnableResourceNameDNSARecord *bool `json:"enableResourceNameDnsARecord,omitempty"`
+	// EnableResourceNameDNSAAAARecord answers DNS queries for the resource-name hostname with AAAA records
+	EnableResourceNameDNSAAAARecord *bool `json:"enableResourceNameDnsAAAARecord,omitempty"`
+}
+
+// RawBootstrapPointer is a pointer to the bootstrap config of the instance which gets delivered as instance tag
+type RawBootstrapPointer struct {
+	// Tag is the key of the instance tag. Defaults to Bootstrap-Pointer
+	Tag providerconfig.ConfigVarString `json:"tag,omitempty"`
+	// URL from which the shim on the image fetches its bootstrap config. The placeholders {machineName} and
+	// {machineUID} get replaced
+	URL providerconfig.ConfigVarString `json:"url"`
+}
+
+// RawCapacityReservation selects the On-Demand Capacity Reservations of an instance
+type RawCapacityReservation struct {
+	// Preference is open, none or targeted. open launches the instance in any open reservation with matching
+	// attributes and falls back to regular capacity, none never uses a reservation and targeted only launches
+	// the instance in the reservation with the given ID
+	Preference providerconfig.ConfigVarString `json:"preference"`
+	// ID of the reservation for the targeted preference, e.g. cr-0123456789abcdef0
+	ID providerconfig.ConfigVarString `json:"id,omitempty"`
+}
+
+// RawNetworkInterface is an additional network interface of an instance
+type RawNetworkInterface struct {
+	SubnetID         providerconfig.ConfigVarString   `json:"subnetId"`
+	SecurityGroupIDs []providerconfig.ConfigVarString `json:"securityGroupIDs"`
+	// Storage marks the interface which carries the storage traffic, at most one interface can be marked
+	Storage bool `json:"storage,omitempty"`
+}
+
+// RawElasticIP is an Elastic IP of an instance. When no allocation ID is given, an Elastic IP gets
+// allocated for the instance and released once the instance gets deleted
+type RawElasticIP struct {
+	AllocationID providerconfig.ConfigVarString `json:"allocationId,omitempty"`
+}
+
+// RawVolumeRef is an existing EBS volume which gets attached to the instance
+type RawVolumeRef struct {
+	VolumeID providerconfig.ConfigVarString `json:"volumeId"`
+	Device   providerconfig.ConfigVarString `json:"device"`
 }
 
 type Config struct {
@@ -150,6 +277,38 @@ type Config struct {
 	DiskSize     int64
 	DiskType     string
 	Tags         map[string]string
+
+	InstanceTypeFallbacks []string
+
+	RootDeviceName       string
+	BootVolumeSnapshotID string
+
+	PrivateDNSZoneID string
+
+	AdditionalNetworkInterfaces []NetworkInterface
+	DisableSourceDestCheck      bool
+
+	AttachVolumes []VolumeRef
+	InstanceStore bool
+
+	ManagedPlacementGroup bool
+
+	CreditSpecification string
+
+	CapacityReservation *CapacityReservation
+
+	AssignPublicIP bool
+	ElasticIP      *ElasticIP
+
+	InstanceInitiatedShutdownBehavior string
+
+	ExternalID string
+
+	BootstrapPointer *BootstrapPointer
+
+	PrivateDNSNameOptions *PrivateDNSNameOptions
+
+	SubnetSelector *SubnetSelector
 }
 
 type amiFilter struct {
@@ -233,6 +392,10 @@ func getDefaultRootDevicePath(os providerconfig.OperatingSystem) (string, error)
 		return "/dev/sda1", nil
 	case providerconfig.OperatingSystemCoreos:
 		return "/dev/xvda", nil
+	case providerconfig.OperatingSystemWindows:
+		return "/dev/sda1", nil
+	case providerconfig.OperatingSystemBottlerocket:
+		return "/dev/xvda", nil
 	}
 
 	return "", fmt.Errorf("no default root path found for %s operating system", os)
@@ -291,6 +454,13 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	for _, rawFallback := range rawConfig.InstanceTypeFallbacks {
+		fallback, err := p.configVarResolver.GetConfigVarStringValue(rawFallback)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c.InstanceTypeFallbacks = append(c.InstanceTypeFallbacks, fallback)
+	}
 	c.AMI, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.AMI)
 	if err != nil {
 		return nil, nil, nil, err
@@ -301,7 +471,119 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 		return nil, nil, nil, err
 	}
 	c.Tags = rawConfig.Tags
+	c.RootDeviceName, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.RootDeviceName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.BootVolumeSnapshotID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.BootVolumeSnapshotID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	c.IsSpotInstance = rawConfig.IsSpotInstance
+	c.PrivateDNSZoneID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.PrivateDNSZoneID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, rawInterface := range rawConfig.AdditionalNetworkInterfaces {
+		ifc := NetworkInterface{}
+		ifc.SubnetID, err = p.configVarResolver.GetConfigVarStringValue(rawInterface.SubnetID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, securityGroupIDRaw := range rawInterface.SecurityGroupIDs {
+			securityGroupID, err := p.configVarResolver.GetConfigVarStringValue(securityGroupIDRaw)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			ifc.SecurityGroupIDs = append(ifc.SecurityGroupIDs, securityGroupID)
+		}
+		ifc.Storage = rawInterface.Storage
+		c.AdditionalNetworkInterfaces = append(c.AdditionalNetworkInterfaces, ifc)
+	}
+	c.DisableSourceDestCheck = rawConfig.DisableSourceDestCheck != nil && *rawConfig.DisableSourceDestCheck
+	for _, rawVolume := range rawConfig.AttachVolumes {
+		volume := VolumeRef{}
+		volume.VolumeID, err = p.configVarResolver.GetConfigVarStringValue(rawVolume.VolumeID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		volume.Device, err = p.configVarResolver.GetConfigVarStringValue(rawVolume.Device)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c.AttachVolumes = append(c.AttachVolumes, volume)
+	}
+	c.InstanceStore = rawConfig.InstanceStore != nil && *rawConfig.InstanceStore
+	c.ManagedPlacementGroup = rawConfig.ManagedPlacementGroup != nil && *rawConfig.ManagedPlacementGroup
+	c.CreditSpecification, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CreditSpecification)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if rawConfig.CapacityReservation != nil {
+		c.CapacityReservation = &CapacityReservation{}
+		c.CapacityReservation.Preference, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CapacityReservation.Preference)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c.CapacityReservation.ID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CapacityReservation.ID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if rawConfig.ElasticIP != nil {
+		c.ElasticIP = &ElasticIP{}
+		c.ElasticIP.AllocationID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ElasticIP.AllocationID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	// AWS refuses to assign a public IP to instances with multiple network interfaces
+	c.AssignPublicIP = len(c.AdditionalNetworkInterfaces) == 0 && c.ElasticIP == nil
+	if rawConfig.AssignPublicIP != nil {
+		c.AssignPublicIP = *rawConfig.AssignPublicIP
+	}
+	c.InstanceInitiatedShutdownBehavior, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.InstanceInitiatedShutdownBehavior)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.ExternalID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ExternalID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if rawConfig.BootstrapPointer != nil {
+		c.BootstrapPointer = &BootstrapPointer{}
+		c.BootstrapPointer.Tag, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.BootstrapPointer.Tag)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if c.BootstrapPointer.Tag == "" {
+			c.BootstrapPointer.Tag = defaultBootstrapPointerTag
+		}
+		c.BootstrapPointer.URL, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.BootstrapPointer.URL)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if rawConfig.PrivateDNSNameOptions != nil {
+		c.PrivateDNSNameOptions = &PrivateDNSNameOptions{
+			EnableResourceNameDNSARecord:    rawConfig.PrivateDNSNameOptions.EnableResourceNameDNSARecord,
+			EnableResourceNameDNSAAAARecord: rawConfig.PrivateDNSNameOptions.EnableResourceNameDNSAAAARecord,
+		}
+		c.PrivateDNSNameOptions.HostnameType, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.PrivateDNSNameOptions.HostnameType)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if rawConfig.SubnetSelector != nil {
+		c.SubnetSelector = &SubnetSelector{Tags: rawConfig.SubnetSelector.Tags}
+		c.SubnetSelector.Strategy, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.SubnetSelector.Strategy)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if c.SubnetSelector.Strategy == "" {
+			c.SubnetSelector.Strategy = subnetStrategyMostAvailableIPs
+		}
+	}
 
 	return &c, &pconfig, &rawConfig, err
 }
@@ -348,7 +630,12 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
 
-	if _, osSupported := amiFilters[pc.OperatingSystem]; !osSupported {
+	if pc.OperatingSystem == providerconfig.OperatingSystemBottlerocket {
+		// Bottlerocket images are built per kubernetes minor version, so there is no sensible default
+		if config.AMI == "" {
+			return errors.New("ami must be specified for bottlerocket")
+		}
+	} else if _, osSupported := amiFilters[pc.OperatingSystem]; !osSupported {
 		return fmt.Errorf("unsupported os %s", pc.OperatingSystem)
 	}
 
@@ -366,26 +653,115 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("diskSize must be specified and > 0")
 	}
 
+	if err := validateNetworkInterfaces(config.InstanceType, config.AdditionalNetworkInterfaces); err != nil {
+		return err
+	}
+
+	if err := validateCreditSpecification(config.InstanceType, config.CreditSpecification); err != nil {
+		return err
+	}
+
+	if err := validatePublicIP(config); err != nil {
+		return err
+	}
+
+	if err := validateShutdownBehavior(config); err != nil {
+		return err
+	}
+
+	if err := validatePrivateDNSNameOptions(config); err != nil {
+		return err
+	}
+
+	if err := validateSubnetSelector(config); err != nil {
+		return err
+	}
+
+	if err := validateExternalID(config); err != nil {
+		return err
+	}
+
+	if err := validateTemplateHashTag(config); err != nil {
+		return err
+	}
+
+	if err := validateBootstrapPointer(config, spec.Name); err != nil {
+		return err
+	}
+
+	if err := validateRootDeviceName(config.RootDeviceName); err != nil {
+		return err
+	}
+
 	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
 	if err != nil {
 		return fmt.Errorf("failed to create ec2 client: %v", err)
 	}
+	// The root device of the default AMIs is known, custom AMIs may use a different one
+	rootDevicePath := config.RootDeviceName
 	if config.AMI != "" {
-		_, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{
+		imagesOut, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{
 			ImageIds: aws.StringSlice([]string{config.AMI}),
 		})
 		if err != nil {
 			return fmt.Errorf("failed to validate ami: %v", err)
 		}
+		if len(imagesOut.Images) != 1 {
+			return fmt.Errorf("failed to validate ami: image %q not found", config.AMI)
+		}
+		if err := validateImagePlatform(imagesOut.Images[0], pc.OperatingSystem); err != nil {
+			return err
+		}
+		if rootDevicePath == "" {
+			if rootDevicePath, err = imageRootDeviceName(imagesOut.Images[0], pc.OperatingSystem); err != nil {
+				return err
+			}
+		}
+	}
+	if rootDevicePath == "" {
+		if rootDevicePath, err = getDefaultRootDevicePath(pc.OperatingSystem); err != nil {
+			return err
+		}
+	}
+	if err := validateAttachVolumes(config.AttachVolumes, rootDevicePath); err != nil {
+		return err
+	}
+	if err := validateInstanceStore(config, rootDevicePath); err != nil {
+		return err
 	}
 
 	if _, err := getVpc(ec2Client, config.VpcID); err != nil {
 		return fmt.Errorf("invalid vpc %q specified: %v", config.VpcID, err)
 	}
 
-	_, err = ec2Client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{ZoneNames: aws.StringSlice([]string{config.AvailabilityZone})})
-	if err != nil {
-		return fmt.Errorf("invalid zone %q specified: %v", config.AvailabilityZone, err)
+	// With a subnet selector, the instances get created in the zones of the matching subnets
+	zones := []string{config.AvailabilityZone}
+	if config.SubnetSelector != nil {
+		subnets, err := matchingSubnets(ec2Client, config)
+		if err != nil {
+			return err
+		}
+		zones = subnetAvailabilityZones(subnets)
+	}
+	for _, zone := range zones {
+		_, err = ec2Client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{ZoneNames: aws.StringSlice([]string{zone})})
+		if err != nil {
+			return fmt.Errorf("invalid zone %q specified: %v", zone, err)
+		}
+		if err := validateInstanceTypeInZone(ec2Client, config.InstanceType, zone); err != nil {
+			return err
+		}
+		zoneConfig := *config
+		zoneConfig.AvailabilityZone = zone
+		if err := validateInstanceTypeFallbacks(ec2Client, &zoneConfig, rootDevicePath); err != nil {
+			return err
+		}
+	}
+	if err := validateCapacityReservation(ec2Client, config); err != nil {
+		return err
+	}
+	if err := validateBootVolumeSnapshot(ec2Client, config); err != nil {
+		return err
 	}
 
 	_, err = ec2Client.DescribeRegions(&ec2.DescribeRegionsInput{RegionNames: aws.StringSlice([]string{config.Region})})
@@ -403,21 +779,49 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("failed to validate security group id's: %v", err)
 	}
 
+	if len(config.AttachVolumes) > 0 {
+		existing, err := describeVolumes(ec2Client, config.AttachVolumes)
+		if err != nil {
+			return fmt.Errorf("failed to validate attached volumes: %v", err)
+		}
+		for _, volume := range config.AttachVolumes {
+			if _, ok := existing[volume.VolumeID]; !ok {
+				return fmt.Errorf("volume %s not found", volume.VolumeID)
+			}
+		}
+	}
+
 	iamClient, err := getIAMclient(config.AccessKeyID, config.SecretAccessKey, config.Region)
 	if err != nil {
 		return fmt.Errorf("failed to create iam client: %v", err)
 	}
 
-	if config.InstanceProfile == "" {
-		return fmt.Errorf("invalid instance profile specified %q: %v", config.InstanceProfile, err)
+	if _, err := resolveInstanceProfile(iamClient, config.InstanceProfile); err != nil {
+		return fmt.Errorf("invalid instance profile: %v", err)
 	}
-	if _, err := iamClient.GetInstanceProfile(&iam.GetInstanceProfileInput{InstanceProfileName: aws.String(config.InstanceProfile)}); err != nil {
-		return fmt.Errorf("failed to validate instance profile: %v", err)
+
+	if config.PrivateDNSZoneID != "" {
+		if err := validatePrivateDNSZone(newRoute53Client(config.AccessKeyID, config.SecretAccessKey), config.PrivateDNSZoneID); err != nil {
+			return fmt.Errorf("invalid private dns zone: %v", err)
+		}
 	}
 
 	return nil
 }
 
+// validateImagePlatform makes sure Windows machines use a Windows AMI and vice versa
+func validateImagePlatform(image *ec2.Image, os providerconfig.OperatingSystem) error {
+	// DescribeImages reports the platform in lower case
+	isWindowsImage := strings.EqualFold(aws.StringValue(image.Platform), ec2.PlatformValuesWindows)
+	if os == providerconfig.OperatingSystemWindows && !isWindowsImage {
+		return fmt.Errorf("ami %q is not a windows image", aws.StringValue(image.ImageId))
+	}
+	if os != providerconfig.OperatingSystemWindows && isWindowsImage {
+		return fmt.Errorf("ami %q is a windows image but the operating system is %q", aws.StringValue(image.ImageId), os)
+	}
+	return nil
+}
+
 func getVpc(client *ec2.EC2, id string) (*ec2.Vpc, error) {
 	vpcOut, err := client.DescribeVpcs(&ec2.DescribeVpcsInput{
 		Filters: []*ec2.Filter{
@@ -450,11 +854,6 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		return nil, err
 	}
 
-	rootDevicePath, err := getDefaultRootDevicePath(pc.OperatingSystem)
-	if err != nil {
-		return nil, err
-	}
-
 	amiID := config.AMI
 	if amiID == "" {
 		if amiID, err = getDefaultAMIID(ec2Client, pc.OperatingSystem, config.Region); err != nil {
@@ -465,8 +864,15 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		}
 	}
 
-	if pc.OperatingSystem != providerconfig.OperatingSystemCoreos {
-		// Gzip the userdata in case we don't use CoreOS.
+	rootDevicePath, err := getRootDeviceName(ec2Client, config, amiID, pc.OperatingSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	if pc.OperatingSystem != providerconfig.OperatingSystemCoreos && pc.OperatingSystem != providerconfig.OperatingSystemWindows &&
+		pc.OperatingSystem != providerconfig.OperatingSystemBottlerocket {
+		// Gzip the userdata in case we don't use CoreOS, Windows or Bottlerocket. EC2Launch and
+		// Bottlerocket do not support compressed userdata.
 		userdata, err = convert.GzipString(userdata)
 		if err != nil {
 			return nil, fmt.Errorf("failed to gzip the userdata")
@@ -491,51 +897,107 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		})
 	}
 
+	if tag := templateHashTag(machine); tag != nil {
+		tags = append(tags, tag)
+	}
+
+	if config.ExternalID != "" {
+		if err := checkExternalIDUnused(ec2Client, config.ExternalID, machine.UID); err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: err.Error(),
+			}
+		}
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(externalIDTag),
+			Value: aws.String(config.ExternalID),
+		})
+	}
+
+	if config.BootstrapPointer != nil {
+		tag, err := bootstrapPointerTag(config.BootstrapPointer, machine)
+		if err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: err.Error(),
+			}
+		}
+		tags = append(tags, tag)
+	}
+
+	if config.SubnetSelector != nil {
+		subnet, err := selectSubnet(ec2Client, config, machine)
+		if err != nil {
+			return nil, awsErrorToTerminalError(err, "failed to select subnet")
+		}
+		glog.V(3).Infof("Selected subnet %s in availability zone %s for machine %s", aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.AvailabilityZone), machine.Name)
+		if tag := machineDeploymentTagFor(config, machine); tag != nil {
+			tags = append(tags, tag)
+		}
+		selected := *config
+		selected.SubnetID = aws.StringValue(subnet.SubnetId)
+		selected.AvailabilityZone = aws.StringValue(subnet.AvailabilityZone)
+		config = &selected
+	}
+
+	if len(config.AttachVolumes) > 0 {
+		if err := checkVolumesAvailable(ec2Client, config.AttachVolumes, config.AvailabilityZone); err != nil {
+			return nil, err
+		}
+	}
+
+	var placementGroup string
+	if config.ManagedPlacementGroup {
+		if placementGroup, err = placementgroup.Name(machine); err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: fmt.Sprintf("Failed to determine the placement group: %v", err),
+			}
+		}
+		if err := ensurePlacementGroup(ec2Client, placementGroup); err != nil {
+			return nil, err
+		}
+	}
+
+	iamClient, err := getIAMclient(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return nil, err
+	}
+	instanceProfile, err := resolveInstanceProfile(iamClient, config.InstanceProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the instance profile: %v", err)
+	}
+
 	var instanceMarketOptions *ec2.InstanceMarketOptionsRequest
 	if config.IsSpotInstance != nil && *config.IsSpotInstance {
 		instanceMarketOptions = &ec2.InstanceMarketOptionsRequest{MarketType: aws.String(ec2.MarketTypeSpot)}
 	}
 
-	instanceRequest := &ec2.RunInstancesInput{
-		ImageId:               aws.String(amiID),
-		InstanceMarketOptions: instanceMarketOptions,
-		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
-			{
-				DeviceName: aws.String(rootDevicePath),
-				Ebs: &ec2.EbsBlockDevice{
-					VolumeSize:          aws.Int64(config.DiskSize),
-					DeleteOnTermination: aws.Bool(true),
-					VolumeType:          aws.String(config.DiskType),
+	newInstanceRequest := func(config *Config) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			ImageId:                           aws.String(amiID),
+			InstanceMarketOptions:             instanceMarketOptions,
+			BlockDeviceMappings:               append(rootBlockDeviceMappings(config, rootDevicePath), instanceStoreBlockDeviceMappings(config)...),
+			MaxCount:                          aws.Int64(1),
+			MinCount:                          aws.Int64(1),
+			InstanceType:                      aws.String(config.InstanceType),
+			UserData:                          aws.String(base64.StdEncoding.EncodeToString([]byte(userdata))),
+			Placement:                         instancePlacement(config, placementGroup),
+			NetworkInterfaces:                 networkInterfaceSpecifications(config),
+			CreditSpecification:               creditSpecification(config),
+			CapacityReservationSpecification:  capacityReservationSpecification(config),
+			InstanceInitiatedShutdownBehavior: instanceInitiatedShutdownBehavior(config),
+			IamInstanceProfile:                instanceProfileSpecification(instanceProfile),
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags:         tags,
 				},
 			},
-		},
-		MaxCount:     aws.Int64(1),
-		MinCount:     aws.Int64(1),
-		InstanceType: aws.String(config.InstanceType),
-		UserData:     aws.String(base64.StdEncoding.EncodeToString([]byte(userdata))),
-		Placement: &ec2.Placement{
-			AvailabilityZone: aws.String(config.AvailabilityZone),
-		},
-		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
-			{
-				DeviceIndex:              aws.Int64(0), // eth0
-				AssociatePublicIpAddress: aws.Bool(true),
-				DeleteOnTermination:      aws.Bool(true),
-				SubnetId:                 aws.String(config.SubnetID),
-			},
-		},
-		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-			Name: aws.String(config.InstanceProfile),
-		},
-		TagSpecifications: []*ec2.TagSpecification{
-			{
-				ResourceType: aws.String(ec2.ResourceTypeInstance),
-				Tags:         tags,
-			},
-		},
+		}
 	}
 
-	runOut, err := ec2Client.RunInstances(instanceRequest)
+	runOut, err := runInstance(runInstancesClientFor(ec2Client, config), config, newInstanceRequest)
 	if err != nil {
 		return nil, awsErrorToTerminalError(err, "failed create instance at aws")
 	}
@@ -556,6 +1018,65 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		return nil, awsErrorToTerminalError(modifyInstanceErr, fmt.Sprintf("failed to attach instance %s to security group %v", aws.StringValue(runOut.Instances[0].InstanceId), config.SecurityGroupIDs))
 	}
 
+	if config.DisableSourceDestCheck {
+		if sourceDestCheckErr := disableSourceDestCheck(ec2Client, runOut.Instances[0]); sourceDestCheckErr != nil {
+			_, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{runOut.Instances[0].InstanceId},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete instance %s due to %v after failing to disable the source/destination check: %v", aws.StringValue(runOut.Instances[0].InstanceId), err, sourceDestCheckErr)
+			}
+			return nil, sourceDestCheckErr
+		}
+	}
+
+	if len(config.AttachVolumes) > 0 {
+		if attachErr := attachVolumes(ec2Client, aws.StringValue(runOut.Instances[0].InstanceId), config.AttachVolumes); attachErr != nil {
+			_, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{runOut.Instances[0].InstanceId},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete instance %s due to %v after failing to attach volumes: %v", aws.StringValue(runOut.Instances[0].InstanceId), err, attachErr)
+			}
+			return nil, attachErr
+		}
+	}
+
+	if config.ElasticIP != nil {
+		if eipErr := associateElasticIP(ec2Client, config.ElasticIP, machine.UID, runOut.Instances[0]); eipErr != nil {
+			_, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{runOut.Instances[0].InstanceId},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete instance %s due to %v after failing to associate its elastic ip: %v", aws.StringValue(runOut.Instances[0].InstanceId), err, eipErr)
+			}
+			return nil, eipErr
+		}
+	}
+
+	if config.PrivateDNSZoneID != "" {
+		route53Client := newRoute53Client(config.AccessKeyID, config.SecretAccessKey)
+		if dnsErr := registerInstanceDNSRecord(route53Client, config.PrivateDNSZoneID, machine.Spec.Name, runOut.Instances[0]); dnsErr != nil {
+			_, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{runOut.Instances[0].InstanceId},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete instance %s due to %v after failing to create its dns record: %v", aws.StringValue(runOut.Instances[0].InstanceId), err, dnsErr)
+			}
+			return nil, fmt.Errorf("failed to create dns record for instance %s: %v", aws.StringValue(runOut.Instances[0].InstanceId), dnsErr)
+		}
+	}
+
+	if data != nil && data.Updater != nil {
+		// Only a hint for users, the instance is usable without it
+		if err := recordInstanceType(data, machine, aws.StringValue(runOut.Instances[0].InstanceType)); err != nil {
+			glog.Errorf("Failed to record the instance type of machine %s: %v", machine.Name, err)
+		}
+		if err := identity.Record(data, machine, instanceProfileIdentity(instanceProfile)); err != nil {
+			glog.Errorf("Failed to record the identity of machine %s: %v", machine.Name, err)
+		}
+	}
+
 	return awsInstance, nil
 }
 
@@ -563,6 +1084,12 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	instance, err := p.Get(machine)
 	if err != nil {
 		if err == cloudprovidererrors.ErrInstanceNotFound {
+			if err := p.cleanupPlacementGroup(machine); err != nil {
+				return false, err
+			}
+			if err := p.cleanupElasticIPs(machine); err != nil {
+				return false, err
+			}
 			return true, nil
 		}
 		return false, err
@@ -581,6 +1108,26 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 		return false, err
 	}
 
+	if config.PrivateDNSZoneID != "" {
+		route53Client := newRoute53Client(config.AccessKeyID, config.SecretAccessKey)
+		if err := deregisterInstanceDNSRecord(route53Client, config.PrivateDNSZoneID, machine.Spec.Name); err != nil {
+			return false, fmt.Errorf("failed to delete dns record of instance %s: %v", instance.ID(), err)
+		}
+	}
+
+	if len(config.AttachVolumes) > 0 {
+		if err := detachVolumes(ec2Client, instance.ID(), config.AttachVolumes); err != nil {
+			return false, err
+		}
+	}
+
+	// The Elastic IP gets disassociated first, it can not be released while the instance is terminating
+	if config.ElasticIP != nil && config.ElasticIP.AllocationID == "" {
+		if err := releaseElasticIPs(ec2Client, machine.UID); err != nil {
+			return false, err
+		}
+	}
+
 	tOut, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
 		InstanceIds: aws.StringSlice([]string{instance.ID()}),
 	})
@@ -595,6 +1142,73 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	return false, nil
 }
 
+// cleanupPlacementGroup deletes the managed placement group once the last instance of the MachineDeployment is gone
+func (p *provider) cleanupPlacementGroup(machine *v1alpha1.Machine) error {
+	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+	if !config.ManagedPlacementGroup {
+		return nil
+	}
+	placementGroup, err := placementgroup.Name(machine)
+	if err != nil {
+		// Creating the instance would have failed already
+		return nil
+	}
+
+	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return err
+	}
+	return deletePlacementGroupIfEmpty(ec2Client, placementGroup)
+}
+
+// cleanupElasticIPs releases the Elastic IPs allocated for a machine whose instance is gone, e.g. because
+// associating the Elastic IP failed
+func (p *provider) cleanupElasticIPs(machine *v1alpha1.Machine) error {
+	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+	if config.ElasticIP == nil || config.ElasticIP.AllocationID != "" {
+		return nil
+	}
+
+	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return err
+	}
+	return releaseElasticIPs(ec2Client, machine.UID)
+}
+
+// ValidateCredentials checks the credentials and the permission to describe instances via a dry run
+func (p *provider) ValidateCredentials(spec v1alpha1.MachineSpec) error {
+	config, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return fmt.Errorf("failed to create ec2 client: %v", err)
+	}
+
+	_, err = ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{DryRun: aws.Bool(true)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "DryRunOperation" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to describe instances: %v", err)
+	}
+	return nil
+}
+
 func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -665,6 +1279,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.InstanceType, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -723,13 +1346,19 @@ func (d *awsInstance) ID() string {
 	return aws.StringValue(d.instance.InstanceId)
 }
 
-func (d *awsInstance) Addresses() []string {
-	return []string{
-		aws.StringValue(d.instance.PublicIpAddress),
-		aws.StringValue(d.instance.PublicDnsName),
-		aws.StringValue(d.instance.PrivateIpAddress),
-		aws.StringValue(d.instance.PrivateDnsName),
+func (d *awsInstance) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
+	for address, addressType := range map[*string]corev1.NodeAddressType{
+		d.instance.PublicIpAddress:  corev1.NodeExternalIP,
+		d.instance.PublicDnsName:    corev1.NodeExternalDNS,
+		d.instance.PrivateIpAddress: corev1.NodeInternalIP,
+		d.instance.PrivateDnsName:   corev1.NodeInternalDNS,
+	} {
+		if aws.StringValue(address) != "" {
+			addresses[aws.StringValue(address)] = addressType
+		}
 	}
+	return addresses
 }
 
 func (d *awsInstance) Status() instance.Status {
@@ -747,6 +1376,14 @@ func (d *awsInstance) Status() instance.Status {
 	}
 }
 
+func (d *awsInstance) Tags() map[string]string {
+	tags := map[string]string{}
+	for _, t := range d.instance.Tags {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return tags
+}
+
 func getTagValue(name string, tags []*ec2.Tag) string {
 	for _, t := range tags {
 		if *t.Key == name {
@@ -791,7 +1428,7 @@ func awsErrorToTerminalError(err error, msg string) error {
 				Message: err.Error(),
 			}
 		default:
-			return prepareAndReturnError()
+			return cloudprovidererrors.WithCode(prepareAndReturnError(), aerr.Code())
 		}
 	}
 	return nil
@@ -899,3 +1536,11 @@ func getIntanceCountForMachine(machine v1alpha1.Machine, reservations []*ec2.Res
 	}
 	return count
 }
+
+func (p *provider) MachineCapacity(spec v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	c, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	return instanceTypeCapacity(c.InstanceType), nil
+}
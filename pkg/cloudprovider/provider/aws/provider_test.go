@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+func TestValidateImagePlatform(t *testing.T) {
+	tests := []struct {
+		name        string
+		image       *ec2.Image
+		os          providerconfig.OperatingSystem
+		expectedErr bool
+	}{
+		{
+			name:  "windows image for windows",
+			image: &ec2.Image{ImageId: aws.String("ami-1"), Platform: aws.String("windows")},
+			os:    providerconfig.OperatingSystemWindows,
+		},
+		{
+			name:        "linux image for windows",
+			image:       &ec2.Image{ImageId: aws.String("ami-1")},
+			os:          providerconfig.OperatingSystemWindows,
+			expectedErr: true,
+		},
+		{
+			name:        "windows image for ubuntu",
+			image:       &ec2.Image{ImageId: aws.String("ami-1"), Platform: aws.String("windows")},
+			os:          providerconfig.OperatingSystemUbuntu,
+			expectedErr: true,
+		},
+		{
+			name:  "linux image for ubuntu",
+			image: &ec2.Image{ImageId: aws.String("ami-1")},
+			os:    providerconfig.OperatingSystemUbuntu,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateImagePlatform(test.image, test.os)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/resize"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// instanceResizeClient is the subset of the ec2 client needed to change the instance type of an instance
+type instanceResizeClient interface {
+	ModifyInstanceAttribute(*ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error)
+	StartInstances(*ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error)
+	StopInstances(*ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error)
+}
+
+// resizeInstance takes the next step to run the instance with the given instance type. The instance type
+// can only be changed while the instance is stopped. It returns true once the instance runs with the type.
+func resizeInstance(client instanceResizeClient, i *ec2.Instance, instanceType string) (bool, error) {
+	resized := aws.StringValue(i.InstanceType) == instanceType
+	switch aws.StringValue(i.State.Name) {
+	case ec2.InstanceStateNameRunning:
+		if resized {
+			return true, nil
+		}
+		if _, err := client.StopInstances(&ec2.StopInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+			return false, awsErrorToTerminalError(err, "failed to stop instance")
+		}
+	case ec2.InstanceStateNameStopped:
+		if !resized {
+			_, err := client.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+				InstanceId:   i.InstanceId,
+				InstanceType: &ec2.AttributeValue{Value: aws.String(instanceType)},
+			})
+			if err != nil {
+				return false, awsErrorToTerminalError(err, "failed to change instance type")
+			}
+		}
+		if _, err := client.StartInstances(&ec2.StartInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+			return false, awsErrorToTerminalError(err, "failed to start instance")
+		}
+	}
+	// The instance is pending or stopping
+	return false, nil
+}
+
+func (p *provider) OnlyInstanceTypeChanged(old, new v1alpha1.MachineSpec) (bool, error) {
+	return resize.OnlyCloudProviderSpecFieldChanged(old, new, "instanceType")
+}
+
+func (p *provider) InstanceTypes(machine *v1alpha1.Machine) (string, string, error) {
+	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse config: %v", err)
+	}
+	i, err := p.Get(machine)
+	if err != nil {
+		return "", "", err
+	}
+	current := aws.StringValue(i.(*awsInstance).instance.InstanceType)
+	// Instances which got launched with a fallback instance type do not get resized to the requested one
+	if isInstanceTypeFallback(config, current) {
+		return current, current, nil
+	}
+	return current, config.InstanceType, nil
+}
+
+func (p *provider) Resize(machine *v1alpha1.Machine) (bool, error) {
+	config, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse config: %v", err)
+	}
+	ec2Client, err := getEC2client(config.AccessKeyID, config.SecretAccessKey, config.Region)
+	if err != nil {
+		return false, err
+	}
+	i, err := p.Get(machine)
+	if err != nil {
+		return false, err
+	}
+	return resizeInstance(ec2Client, i.(*awsInstance).instance, config.InstanceType)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeInstanceResizeClient changes the state of the instance right away
+type fakeInstanceResizeClient struct {
+	instance *ec2.Instance
+	stopped  int
+	started  int
+}
+
+func (f *fakeInstanceResizeClient) ModifyInstanceAttribute(input *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.instance.InstanceType = input.InstanceType.Value
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeInstanceResizeClient) StartInstances(*ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error) {
+	f.started++
+	f.instance.State.Name = aws.String(ec2.InstanceStateNameRunning)
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+func (f *fakeInstanceResizeClient) StopInstances(*ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+	f.stopped++
+	f.instance.State.Name = aws.String(ec2.InstanceStateNameStopped)
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func TestResizeInstance(t *testing.T) {
+	tests := []struct {
+		name            string
+		state           string
+		instanceType    string
+		expectedStopped int
+		expectedStarted int
+	}{
+		{
+			name:            "running instance gets stopped, resized and started",
+			state:           ec2.InstanceStateNameRunning,
+			instanceType:    "t3.medium",
+			expectedStopped: 1,
+			expectedStarted: 1,
+		},
+		{
+			name:            "stopped instance gets resized and started",
+			state:           ec2.InstanceStateNameStopped,
+			instanceType:    "t3.medium",
+			expectedStarted: 1,
+		},
+		{
+			name:            "stopped instance which got resized already gets started",
+			state:           ec2.InstanceStateNameStopped,
+			instanceType:    "t3.large",
+			expectedStarted: 1,
+		},
+		{
+			name:         "running instance with the instance type is done",
+			state:        ec2.InstanceStateNameRunning,
+			instanceType: "t3.large",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeInstanceResizeClient{instance: &ec2.Instance{
+				InstanceId:   aws.String("i-1"),
+				InstanceType: aws.String(test.instanceType),
+				State:        &ec2.InstanceState{Name: aws.String(test.state)},
+			}}
+
+			// Every call takes one step, a resize needs at most three
+			var resized bool
+			for i := 0; i < 3 && !resized; i++ {
+				var err error
+				if resized, err = resizeInstance(client, client.instance, "t3.large"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !resized {
+				t.Fatal("instance did not get resized")
+			}
+			if instanceType := aws.StringValue(client.instance.InstanceType); instanceType != "t3.large" {
+				t.Errorf("expected instance type t3.large, got %s", instanceType)
+			}
+			if client.stopped != test.expectedStopped || client.started != test.expectedStarted {
+				t.Errorf("expected the instance to get stopped %d and started %d times, got %d and %d", test.expectedStopped, test.expectedStarted, client.stopped, client.started)
+			}
+		})
+	}
+
+	t.Run("pending instance is not done", func(t *testing.T) {
+		client := &fakeInstanceResizeClient{instance: &ec2.Instance{
+			InstanceId:   aws.String("i-1"),
+			InstanceType: aws.String("t3.large"),
+			State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNamePending)},
+		}}
+		resized, err := resizeInstance(client, client.instance, "t3.large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resized {
+			t.Error("expected the resize of a pending instance to not be done")
+		}
+	})
+}
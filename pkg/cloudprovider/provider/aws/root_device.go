@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+// imageClient is the subset of the ec2 client needed to look up the root device of an AMI
+type imageClient interface {
+	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+}
+
+func validateRootDeviceName(rootDeviceName string) error {
+	if rootDeviceName != "" && !strings.HasPrefix(rootDeviceName, "/dev/") {
+		return fmt.Errorf("invalid rootDeviceName %q, must start with /dev/", rootDeviceName)
+	}
+	return nil
+}
+
+// imageRootDeviceName returns the root device name the image reports. Images which do not report one
+// fall back to the default of the operating system
+func imageRootDeviceName(image *ec2.Image, os providerconfig.OperatingSystem) (string, error) {
+	if name := aws.StringValue(image.RootDeviceName); name != "" {
+		return name, nil
+	}
+	return getDefaultRootDevicePath(os)
+}
+
+// getRootDeviceName returns the configured root device name or the one of the AMI, so the block device mapping
+// modifies the root volume instead of adding a second volume for images with a non-standard root device
+func getRootDeviceName(client imageClient, config *Config, amiID string, os providerconfig.OperatingSystem) (string, error) {
+	if config.RootDeviceName != "" {
+		return config.RootDeviceName, nil
+	}
+
+	cacheKey := fmt.Sprintf("root-device-name-%s-%s", config.Region, amiID)
+	if name, found := cache.Get(cacheKey); found {
+		return name.(string), nil
+	}
+
+	imagesOut, err := client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: aws.StringSlice([]string{amiID})})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe ami %s: %v", amiID, err)
+	}
+	if len(imagesOut.Images) != 1 {
+		return "", fmt.Errorf("ami %s not found", amiID)
+	}
+	name, err := imageRootDeviceName(imagesOut.Images[0], os)
+	if err != nil {
+		return "", err
+	}
+	cache.SetDefault(cacheKey, name)
+	return name, nil
+}
+
+// rootBlockDeviceMappings returns the block device mapping which sets the size, type and source snapshot of the
+// root volume
+func rootBlockDeviceMappings(config *Config, rootDeviceName string) []*ec2.BlockDeviceMapping {
+	ebs := &ec2.EbsBlockDevice{
+		VolumeSize:          aws.Int64(config.DiskSize),
+		DeleteOnTermination: aws.Bool(true),
+		VolumeType:          aws.String(config.DiskType),
+	}
+	if config.BootVolumeSnapshotID != "" {
+		ebs.SnapshotId = aws.String(config.BootVolumeSnapshotID)
+	}
+	return []*ec2.BlockDeviceMapping{
+		{
+			DeviceName: aws.String(rootDeviceName),
+			Ebs:        ebs,
+		},
+	}
+}
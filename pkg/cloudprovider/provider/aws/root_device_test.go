@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+type fakeImageClient struct {
+	images []*ec2.Image
+	err    error
+	calls  int
+}
+
+func (f *fakeImageClient) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &ec2.DescribeImagesOutput{Images: f.images}, nil
+}
+
+func TestRootBlockDeviceMappingsUseRootDeviceName(t *testing.T) {
+	tests := []struct {
+		name               string
+		amiID              string
+		rootDeviceName     string
+		images             []*ec2.Image
+		err                error
+		expectedDeviceName string
+		expectedCalls      int
+		expectedErr        bool
+	}{
+		{
+			name:               "root device name of the ami",
+			amiID:              "ami-custom",
+			images:             []*ec2.Image{{ImageId: aws.String("ami-custom"), RootDeviceName: aws.String("/dev/xvda")}},
+			expectedDeviceName: "/dev/xvda",
+			expectedCalls:      1,
+		},
+		{
+			name:               "ami without root device name",
+			amiID:              "ami-without-root-device",
+			images:             []*ec2.Image{{ImageId: aws.String("ami-without-root-device")}},
+			expectedDeviceName: "/dev/sda1",
+			expectedCalls:      1,
+		},
+		{
+			name:               "override",
+			amiID:              "ami-override",
+			rootDeviceName:     "/dev/nvme0n1",
+			expectedDeviceName: "/dev/nvme0n1",
+		},
+		{
+			name:          "ami not found",
+			amiID:         "ami-missing",
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+		{
+			name:          "api error",
+			amiID:         "ami-error",
+			err:           errors.New("throttled"),
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeImageClient{images: test.images, err: test.err}
+			config := &Config{Region: "eu-central-1", DiskSize: 50, DiskType: "gp2", RootDeviceName: test.rootDeviceName}
+
+			rootDeviceName, err := getRootDeviceName(client, config, test.amiID, providerconfig.OperatingSystemUbuntu)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+			if client.calls != test.expectedCalls {
+				t.Errorf("expected %d DescribeImages calls, got %d", test.expectedCalls, client.calls)
+			}
+			if err != nil {
+				return
+			}
+
+			mappings := rootBlockDeviceMappings(config, rootDeviceName)
+			if len(mappings) != 1 {
+				t.Fatalf("expected one block device mapping, got %d", len(mappings))
+			}
+			if deviceName := aws.StringValue(mappings[0].DeviceName); deviceName != test.expectedDeviceName {
+				t.Errorf("expected the block device mapping to use %s, got %s", test.expectedDeviceName, deviceName)
+			}
+			if size := aws.Int64Value(mappings[0].Ebs.VolumeSize); size != config.DiskSize {
+				t.Errorf("expected a root volume of %d GB, got %d GB", config.DiskSize, size)
+			}
+
+			// The root device name of the ami gets cached
+			if _, err := getRootDeviceName(client, config, test.amiID, providerconfig.OperatingSystemUbuntu); err != nil {
+				t.Fatalf("failed to get root device name: %v", err)
+			}
+			if client.calls != test.expectedCalls {
+				t.Errorf("expected the root device name to be cached, got %d DescribeImages calls", client.calls)
+			}
+		})
+	}
+}
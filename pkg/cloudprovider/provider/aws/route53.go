@@ -0,0 +1,229 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The aws-sdk-go route53 service is not vendored, so this is a minimal client for the
+// few Route53 REST calls needed to register the instances in a private hosted zone.
+
+const (
+	route53Endpoint   = "https://route53.amazonaws.com"
+	route53APIVersion = "2013-04-01"
+	route53Namespace  = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// Route53 is a global service, requests must be signed for us-east-1
+	route53SigningRegion = "us-east-1"
+
+	dnsRecordTTL = 300
+)
+
+type route53Client struct {
+	endpoint   string
+	signer     *v4.Signer
+	httpClient *http.Client
+}
+
+func newRoute53Client(id, secret string) *route53Client {
+	return &route53Client{
+		endpoint:   route53Endpoint,
+		signer:     v4.NewSigner(credentials.NewStaticCredentials(id, secret, "")),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type route53HostedZone struct {
+	ID     string `xml:"Id"`
+	Name   string `xml:"Name"`
+	Config struct {
+		PrivateZone bool `xml:"PrivateZone"`
+	} `xml:"Config"`
+}
+
+type route53GetHostedZoneResponse struct {
+	HostedZone route53HostedZone `xml:"HostedZone"`
+}
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type route53ResourceRecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	TTL             int64                   `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Change struct {
+	Action            string                   `xml:"Action"`
+	ResourceRecordSet route53ResourceRecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeResourceRecordSetsRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53ListResourceRecordSetsResponse struct {
+	ResourceRecordSets []route53ResourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type route53ErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (c *route53Client) do(method, path string, query url.Values, body []byte, out interface{}) error {
+	reqURL := fmt.Sprintf("%s/%s/%s", c.endpoint, route53APIVersion, strings.TrimLeft(path, "/"))
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	if _, err := c.signer.Sign(req, bytes.NewReader(body), "route53", route53SigningRegion, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		errResp := route53ErrorResponse{}
+		if err := xml.Unmarshal(respBody, &errResp); err != nil || errResp.Code == "" {
+			return fmt.Errorf("route53 returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("route53 returned %s: %s", errResp.Code, errResp.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(respBody, out)
+}
+
+func (c *route53Client) getHostedZone(zoneID string) (*route53HostedZone, error) {
+	resp := &route53GetHostedZoneResponse{}
+	if err := c.do(http.MethodGet, "hostedzone/"+zoneID, nil, nil, resp); err != nil {
+		return nil, err
+	}
+	return &resp.HostedZone, nil
+}
+
+func (c *route53Client) changeRecordSet(zoneID, action string, recordSet route53ResourceRecordSet) error {
+	body, err := xml.Marshal(route53ChangeResourceRecordSetsRequest{
+		XMLNS:   route53Namespace,
+		Changes: []route53Change{{Action: action, ResourceRecordSet: recordSet}},
+	})
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, "hostedzone/"+zoneID+"/rrset", nil, append([]byte(xml.Header), body...), nil)
+}
+
+// upsertARecord creates or updates the A record of the given name
+func (c *route53Client) upsertARecord(zoneID, name, ip string) error {
+	return c.changeRecordSet(zoneID, "UPSERT", route53ResourceRecordSet{
+		Name:            name,
+		Type:            "A",
+		TTL:             dnsRecordTTL,
+		ResourceRecords: []route53ResourceRecord{{Value: ip}},
+	})
+}
+
+// deleteARecord deletes the A record of the given name. It is a noop if the record does not exist.
+func (c *route53Client) deleteARecord(zoneID, name string) error {
+	resp := &route53ListResourceRecordSetsResponse{}
+	query := url.Values{"name": []string{name}, "type": []string{"A"}, "maxitems": []string{"1"}}
+	if err := c.do(http.MethodGet, "hostedzone/"+zoneID+"/rrset", query, nil, resp); err != nil {
+		return err
+	}
+	// The list starts at the given name, but contains the following records if it does not exist
+	if len(resp.ResourceRecordSets) == 0 || !dnsNamesEqual(resp.ResourceRecordSets[0].Name, name) || resp.ResourceRecordSets[0].Type != "A" {
+		return nil
+	}
+	// DELETE requires the exact values of the existing record set
+	return c.changeRecordSet(zoneID, "DELETE", resp.ResourceRecordSets[0])
+}
+
+func dnsNamesEqual(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// instanceDNSName returns the name of the record of the machine in the zone
+func instanceDNSName(machineName string, zone *route53HostedZone) string {
+	return fmt.Sprintf("%s.%s", machineName, strings.TrimSuffix(zone.Name, ".")) + "."
+}
+
+// validatePrivateDNSZone makes sure the zone exists and is private
+func validatePrivateDNSZone(client *route53Client, zoneID string) error {
+	zone, err := client.getHostedZone(zoneID)
+	if err != nil {
+		return fmt.Errorf("failed to get hosted zone %q: %v", zoneID, err)
+	}
+	if !zone.Config.PrivateZone {
+		return fmt.Errorf("hosted zone %q is not a private zone", zoneID)
+	}
+	return nil
+}
+
+// registerInstanceDNSRecord creates an A record for the private IP of the instance
+func registerInstanceDNSRecord(client *route53Client, zoneID, machineName string, instance *ec2.Instance) error {
+	ip := aws.StringValue(instance.PrivateIpAddress)
+	if ip == "" {
+		return fmt.Errorf("instance %s has no private ip address", aws.StringValue(instance.InstanceId))
+	}
+	zone, err := client.getHostedZone(zoneID)
+	if err != nil {
+		return fmt.Errorf("failed to get hosted zone %q: %v", zoneID, err)
+	}
+	return client.upsertARecord(zoneID, instanceDNSName(machineName, zone), ip)
+}
+
+// deregisterInstanceDNSRecord removes the A record of the machine from the zone
+func deregisterInstanceDNSRecord(client *route53Client, zoneID, machineName string) error {
+	zone, err := client.getHostedZone(zoneID)
+	if err != nil {
+		return fmt.Errorf("failed to get hosted zone %q: %v", zoneID, err)
+	}
+	return client.deleteARecord(zoneID, instanceDNSName(machineName, zone))
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/go-test/deep"
+)
+
+// fakeRoute53 serves the subset of the Route53 API used by the route53Client
+type fakeRoute53 struct {
+	zones   map[string]route53HostedZone
+	records map[string]route53ResourceRecordSet
+	changes []route53Change
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "request is not signed", http.StatusForbidden)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"+route53APIVersion+"/hostedzone/"), "/")
+	zone, exists := f.zones[parts[0]]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<ErrorResponse><Error><Code>NoSuchHostedZone</Code><Message>No hosted zone found</Message></Error></ErrorResponse>`)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeXML(w, route53GetHostedZoneResponse{HostedZone: zone})
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		resp := route53ListResourceRecordSetsResponse{}
+		if record, exists := f.records[r.URL.Query().Get("name")]; exists {
+			resp.ResourceRecordSets = append(resp.ResourceRecordSets, record)
+		}
+		writeXML(w, resp)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		body, _ := ioutil.ReadAll(r.Body)
+		req := route53ChangeResourceRecordSetsRequest{}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, change := range req.Changes {
+			f.changes = append(f.changes, change)
+			if change.Action == "DELETE" {
+				delete(f.records, change.ResourceRecordSet.Name)
+			} else {
+				f.records[change.ResourceRecordSet.Name] = change.ResourceRecordSet
+			}
+		}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"ChangeResourceRecordSetsResponse"`
+		}{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b) // nolint: errcheck
+}
+
+func newTestRoute53Client(t *testing.T, fake *fakeRoute53) (*route53Client, func()) {
+	server := httptest.NewServer(fake)
+	client := newRoute53Client("access-key", "secret-key")
+	client.endpoint = server.URL
+	return client, server.Close
+}
+
+func TestRegisterInstanceDNSRecord(t *testing.T) {
+	fake := &fakeRoute53{
+		zones: map[string]route53HostedZone{
+			"Z1": {ID: "/hostedzone/Z1", Name: "nodes.example.com."},
+		},
+		records: map[string]route53ResourceRecordSet{},
+	}
+	client, closeServer := newTestRoute53Client(t, fake)
+	defer closeServer()
+
+	instance := &ec2.Instance{InstanceId: aws.String("i-123"), PrivateIpAddress: aws.String("10.0.0.5")}
+	if err := registerInstanceDNSRecord(client, "Z1", "machine-1", instance); err != nil {
+		t.Fatalf("failed to register dns record: %v", err)
+	}
+
+	expectedRecord := route53ResourceRecordSet{
+		Name:            "machine-1.nodes.example.com.",
+		Type:            "A",
+		TTL:             dnsRecordTTL,
+		ResourceRecords: []route53ResourceRecord{{Value: "10.0.0.5"}},
+	}
+	if diff := deep.Equal(fake.records["machine-1.nodes.example.com."], expectedRecord); diff != nil {
+		t.Errorf("unexpected dns record, diff: %v", diff)
+	}
+
+	if err := deregisterInstanceDNSRecord(client, "Z1", "machine-1"); err != nil {
+		t.Fatalf("failed to deregister dns record: %v", err)
+	}
+	if len(fake.records) != 0 {
+		t.Errorf("expected the dns record to be deleted, got %v", fake.records)
+	}
+
+	// Deregistering twice must not fail, Cleanup gets called until the instance is gone
+	if err := deregisterInstanceDNSRecord(client, "Z1", "machine-1"); err != nil {
+		t.Fatalf("failed to deregister a non existing dns record: %v", err)
+	}
+	if len(fake.changes) != 2 {
+		t.Errorf("expected 2 changes, got %d", len(fake.changes))
+	}
+}
+
+func TestValidatePrivateDNSZone(t *testing.T) {
+	privateZone := route53HostedZone{ID: "/hostedzone/Z1", Name: "nodes.example.com."}
+	privateZone.Config.PrivateZone = true
+	fake := &fakeRoute53{
+		zones: map[string]route53HostedZone{
+			"Z1": privateZone,
+			"Z2": {ID: "/hostedzone/Z2", Name: "example.com."},
+		},
+	}
+	client, closeServer := newTestRoute53Client(t, fake)
+	defer closeServer()
+
+	tests := []struct {
+		zoneID      string
+		expectedErr bool
+	}{
+		{zoneID: "Z1", expectedErr: false},
+		{zoneID: "Z2", expectedErr: true},
+		{zoneID: "Z3", expectedErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.zoneID, func(t *testing.T) {
+			err := validatePrivateDNSZone(client, test.zoneID)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
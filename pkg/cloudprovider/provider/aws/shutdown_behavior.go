@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var shutdownBehaviors = sets.NewString(ec2.ShutdownBehaviorStop, ec2.ShutdownBehaviorTerminate)
+
+func validateShutdownBehavior(config *Config) error {
+	if config.InstanceInitiatedShutdownBehavior == "" {
+		return nil
+	}
+	if !shutdownBehaviors.Has(config.InstanceInitiatedShutdownBehavior) {
+		return fmt.Errorf("invalid instanceInitiatedShutdownBehavior %q, supported: %v", config.InstanceInitiatedShutdownBehavior, shutdownBehaviors.List())
+	}
+	// One-time spot instances can not be stopped
+	if config.InstanceInitiatedShutdownBehavior == ec2.ShutdownBehaviorStop && config.IsSpotInstance != nil && *config.IsSpotInstance {
+		return errors.New("spot instances only support the instanceInitiatedShutdownBehavior terminate")
+	}
+	return nil
+}
+
+// instanceInitiatedShutdownBehavior returns the shutdown behavior for the RunInstances request. nil keeps the
+// default of AWS, which stops the instance. The deletion of a machine always terminates its instance, whether it
+// is running or got stopped by a shutdown of the operating system.
+func instanceInitiatedShutdownBehavior(config *Config) *string {
+	if config.InstanceInitiatedShutdownBehavior == "" {
+		return nil
+	}
+	return aws.String(config.InstanceInitiatedShutdownBehavior)
+}
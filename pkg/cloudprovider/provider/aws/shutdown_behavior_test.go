@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestValidateShutdownBehavior(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "not set",
+			config: &Config{},
+		},
+		{
+			name:   "stop",
+			config: &Config{InstanceInitiatedShutdownBehavior: "stop"},
+		},
+		{
+			name:   "terminate spot instance",
+			config: &Config{InstanceInitiatedShutdownBehavior: "terminate", IsSpotInstance: aws.Bool(true)},
+		},
+		{
+			name:        "stop spot instance",
+			config:      &Config{InstanceInitiatedShutdownBehavior: "stop", IsSpotInstance: aws.Bool(true)},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid behavior",
+			config:      &Config{InstanceInitiatedShutdownBehavior: "hibernate"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateShutdownBehavior(test.config)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestInstanceInitiatedShutdownBehavior(t *testing.T) {
+	if behavior := instanceInitiatedShutdownBehavior(&Config{}); behavior != nil {
+		t.Errorf("expected no shutdown behavior to keep the default, got %q", *behavior)
+	}
+	for _, expected := range []string{"stop", "terminate"} {
+		behavior := instanceInitiatedShutdownBehavior(&Config{InstanceInitiatedShutdownBehavior: expected})
+		if aws.StringValue(behavior) != expected {
+			t.Errorf("expected shutdown behavior %q, got %q", expected, aws.StringValue(behavior))
+		}
+	}
+}
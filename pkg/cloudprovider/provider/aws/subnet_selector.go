@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// subnetStrategyMostAvailableIPs picks the matching subnet with the most free IP addresses
+	subnetStrategyMostAvailableIPs = "mostAvailableIPs"
+	// subnetStrategyBalanceAvailabilityZones picks a matching subnet in the availability zone with the fewest
+	// instances of the MachineDeployment
+	subnetStrategyBalanceAvailabilityZones = "balanceAvailabilityZones"
+
+	// machineDeploymentTag holds namespace/name of the MachineDeployment of the instance, so its instances can be
+	// balanced across availability zones
+	machineDeploymentTag = "Machine-Deployment"
+)
+
+var subnetStrategies = sets.NewString(subnetStrategyMostAvailableIPs, subnetStrategyBalanceAvailabilityZones)
+
+// SubnetSelector selects the subnet of the instance by its tags instead of its ID
+type SubnetSelector struct {
+	// Tags which the subnet must carry
+	Tags map[string]string
+	// Strategy which picks one of the matching subnets. Defaults to mostAvailableIPs
+	Strategy string
+}
+
+// subnetSelectorClient is the subset of the ec2 client needed to select subnets
+type subnetSelectorClient interface {
+	DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+}
+
+func validateSubnetSelector(config *Config) error {
+	if _, ok := config.Tags[machineDeploymentTag]; ok {
+		return fmt.Errorf("the tag %s is reserved for the MachineDeployment of the instance", machineDeploymentTag)
+	}
+	selector := config.SubnetSelector
+	if selector == nil {
+		return nil
+	}
+	if config.SubnetID != "" {
+		return errors.New("subnetId and subnetSelector can not be combined")
+	}
+	if len(selector.Tags) == 0 {
+		return errors.New("subnetSelector.tags must not be empty")
+	}
+	if !subnetStrategies.Has(selector.Strategy) {
+		return fmt.Errorf("invalid subnetSelector.strategy %q, supported: %v", selector.Strategy, subnetStrategies.List())
+	}
+	// Without an availability zone the subnets of all zones are eligible, which zonal resources do not allow
+	if config.AvailabilityZone == "" {
+		if len(config.AttachVolumes) > 0 {
+			return errors.New("attachVolumes require an availabilityZone, as volumes can only be attached within their zone")
+		}
+		if config.CapacityReservation != nil && config.CapacityReservation.Preference == capacityReservationPreferenceTargeted {
+			return errors.New("a targeted capacityReservation requires an availabilityZone")
+		}
+	}
+	return nil
+}
+
+// matchingSubnets returns the available subnets of the VPC which carry all tags of the selector. If the config has an
+// availability zone, only the subnets in it are returned
+func matchingSubnets(client subnetSelectorClient, config *Config) ([]*ec2.Subnet, error) {
+	filters := []*ec2.Filter{
+		{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{config.VpcID})},
+		{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.SubnetStateAvailable})},
+	}
+	if config.AvailabilityZone != "" {
+		filters = append(filters, &ec2.Filter{Name: aws.String("availability-zone"), Values: aws.StringSlice([]string{config.AvailabilityZone})})
+	}
+	var keys []string
+	for key := range config.SubnetSelector.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: aws.StringSlice([]string{config.SubnetSelector.Tags[key]})})
+	}
+
+	out, err := client.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subnets: %v", err)
+	}
+	if len(out.Subnets) == 0 {
+		if config.AvailabilityZone != "" {
+			return nil, fmt.Errorf("no subnet of vpc %s in availability zone %s matches the tags %v", config.VpcID, config.AvailabilityZone, config.SubnetSelector.Tags)
+		}
+		return nil, fmt.Errorf("no subnet of vpc %s matches the tags %v", config.VpcID, config.SubnetSelector.Tags)
+	}
+	return out.Subnets, nil
+}
+
+// subnetAvailabilityZones returns the distinct availability zones of the subnets
+func subnetAvailabilityZones(subnets []*ec2.Subnet) []string {
+	zones := sets.NewString()
+	for _, subnet := range subnets {
+		zones.Insert(aws.StringValue(subnet.AvailabilityZone))
+	}
+	return zones.List()
+}
+
+// machineDeploymentTagFor returns the tag with the MachineDeployment of the machine if its instances get balanced
+// across availability zones, nil otherwise
+func machineDeploymentTagFor(config *Config, machine *v1alpha1.Machine) *ec2.Tag {
+	if config.SubnetSelector == nil || config.SubnetSelector.Strategy != subnetStrategyBalanceAvailabilityZones {
+		return nil
+	}
+	deploymentName, ok := kuberneteshelper.MachineDeploymentName(machine)
+	if !ok {
+		return nil
+	}
+	return &ec2.Tag{Key: aws.String(machineDeploymentTag), Value: aws.String(machine.Namespace + "/" + deploymentName)}
+}
+
+// instancesPerAvailabilityZone counts the instances with the given MachineDeployment tag per availability zone
+func instancesPerAvailabilityZone(client subnetSelectorClient, deploymentTag *ec2.Tag) (map[string]int, error) {
+	counts := map[string]int{}
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + aws.StringValue(deploymentTag.Key)), Values: []*string{deploymentTag.Value}},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped,
+			})},
+		},
+	}
+	for {
+		out, err := client.DescribeInstances(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances of %s: %v", aws.StringValue(deploymentTag.Value), err)
+		}
+		for _, reservation := range out.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.Placement != nil {
+					counts[aws.StringValue(instance.Placement.AvailabilityZone)]++
+				}
+			}
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return counts, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// selectSubnet picks the subnet the instance of the machine gets created in. With the balanceAvailabilityZones
+// strategy the availability zone with the fewest instances of the MachineDeployment wins, ties and the
+// mostAvailableIPs strategy go to the subnet with the most free IP addresses
+func selectSubnet(client subnetSelectorClient, config *Config, machine *v1alpha1.Machine) (*ec2.Subnet, error) {
+	subnets, err := matchingSubnets(client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceCounts := map[string]int{}
+	if tag := machineDeploymentTagFor(config, machine); tag != nil {
+		if instanceCounts, err = instancesPerAvailabilityZone(client, tag); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(subnets, func(i, j int) bool {
+		countI, countJ := instanceCounts[aws.StringValue(subnets[i].AvailabilityZone)], instanceCounts[aws.StringValue(subnets[j].AvailabilityZone)]
+		if countI != countJ {
+			return countI < countJ
+		}
+		freeI, freeJ := aws.Int64Value(subnets[i].AvailableIpAddressCount), aws.Int64Value(subnets[j].AvailableIpAddressCount)
+		if freeI != freeJ {
+			return freeI > freeJ
+		}
+		return aws.StringValue(subnets[i].SubnetId) < aws.StringValue(subnets[j].SubnetId)
+	})
+	return subnets[0], nil
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// fakeSubnetSelectorClient keeps the subnets and the instances per MachineDeployment tag in memory
+type fakeSubnetSelectorClient struct {
+	subnets   []*ec2.Subnet
+	instances map[string][]*ec2.Instance
+}
+
+func (f *fakeSubnetSelectorClient) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, subnet := range f.subnets {
+		if subnetMatchesFilters(subnet, input.Filters) {
+			out.Subnets = append(out.Subnets, subnet)
+		}
+	}
+	return out, nil
+}
+
+func subnetMatchesFilters(subnet *ec2.Subnet, filters []*ec2.Filter) bool {
+	for _, filter := range filters {
+		name, value := aws.StringValue(filter.Name), aws.StringValue(filter.Values[0])
+		switch {
+		case name == "vpc-id" && aws.StringValue(subnet.VpcId) != value:
+			return false
+		case name == "availability-zone" && aws.StringValue(subnet.AvailabilityZone) != value:
+			return false
+		case len(name) > 4 && name[:4] == "tag:":
+			found := false
+			for _, tag := range subnet.Tags {
+				if aws.StringValue(tag.Key) == name[4:] && aws.StringValue(tag.Value) == value {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *fakeSubnetSelectorClient) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	out := &ec2.DescribeInstancesOutput{}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) == "tag:"+machineDeploymentTag {
+			out.Reservations = append(out.Reservations, &ec2.Reservation{Instances: f.instances[aws.StringValue(filter.Values[0])]})
+		}
+	}
+	return out, nil
+}
+
+// createInstance records an instance in the subnet like RunInstances would
+func (f *fakeSubnetSelectorClient) createInstance(subnet *ec2.Subnet, deploymentTag *ec2.Tag) {
+	instance := &ec2.Instance{SubnetId: subnet.SubnetId, Placement: &ec2.Placement{AvailabilityZone: subnet.AvailabilityZone}}
+	f.instances[aws.StringValue(deploymentTag.Value)] = append(f.instances[aws.StringValue(deploymentTag.Value)], instance)
+}
+
+func newTestSubnet(id, vpc, zone string, availableIPs int64, tier string) *ec2.Subnet {
+	return &ec2.Subnet{
+		SubnetId:                aws.String(id),
+		VpcId:                   aws.String(vpc),
+		AvailabilityZone:        aws.String(zone),
+		AvailableIpAddressCount: aws.Int64(availableIPs),
+		Tags:                    []*ec2.Tag{{Key: aws.String("tier"), Value: aws.String(tier)}},
+	}
+}
+
+func newDeploymentMachine(name string) *v1alpha1.Machine {
+	return &v1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceSystem,
+			Name:      name,
+			Labels:    map[string]string{"machine-template-hash": "abc"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "MachineSet", Name: "workers-" + rand.SafeEncodeString("abc")},
+			},
+		},
+	}
+}
+
+func TestValidateSubnetSelector(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		expectedErr bool
+	}{
+		{
+			name:   "no selector",
+			config: &Config{SubnetID: "subnet-1"},
+		},
+		{
+			name:   "selector",
+			config: &Config{SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: subnetStrategyBalanceAvailabilityZones}},
+		},
+		{
+			name:        "selector combined with subnetId",
+			config:      &Config{SubnetID: "subnet-1", SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: subnetStrategyMostAvailableIPs}},
+			expectedErr: true,
+		},
+		{
+			name:        "selector without tags",
+			config:      &Config{SubnetSelector: &SubnetSelector{Strategy: subnetStrategyMostAvailableIPs}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid strategy",
+			config:      &Config{SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: "random"}},
+			expectedErr: true,
+		},
+		{
+			name: "volumes without zone",
+			config: &Config{
+				SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: subnetStrategyMostAvailableIPs},
+				AttachVolumes:  []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sdf"}},
+			},
+			expectedErr: true,
+		},
+		{
+			name:        "reserved tag",
+			config:      &Config{SubnetID: "subnet-1", Tags: map[string]string{machineDeploymentTag: "kube-system/workers"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSubnetSelector(test.config)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestMatchingSubnets(t *testing.T) {
+	client := &fakeSubnetSelectorClient{subnets: []*ec2.Subnet{
+		newTestSubnet("subnet-a", "vpc-1", "eu-central-1a", 10, "private"),
+		newTestSubnet("subnet-b", "vpc-1", "eu-central-1b", 10, "public"),
+		newTestSubnet("subnet-c", "vpc-2", "eu-central-1c", 10, "private"),
+	}}
+
+	config := &Config{VpcID: "vpc-1", SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}}}
+	subnets, err := matchingSubnets(client, config)
+	if err != nil {
+		t.Fatalf("failed to get matching subnets: %v", err)
+	}
+	if len(subnets) != 1 || aws.StringValue(subnets[0].SubnetId) != "subnet-a" {
+		t.Errorf("expected only subnet-a to match, got %v", subnets)
+	}
+
+	config.AvailabilityZone = "eu-central-1b"
+	if _, err := matchingSubnets(client, config); err == nil {
+		t.Error("expected an error as no subnet in eu-central-1b matches")
+	}
+}
+
+func TestSelectSubnetMostAvailableIPs(t *testing.T) {
+	client := &fakeSubnetSelectorClient{subnets: []*ec2.Subnet{
+		newTestSubnet("subnet-a", "vpc-1", "eu-central-1a", 10, "private"),
+		newTestSubnet("subnet-b", "vpc-1", "eu-central-1b", 200, "private"),
+		newTestSubnet("subnet-c", "vpc-1", "eu-central-1c", 500, "public"),
+	}}
+	config := &Config{VpcID: "vpc-1", SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: subnetStrategyMostAvailableIPs}}
+
+	subnet, err := selectSubnet(client, config, newDeploymentMachine("workers-1"))
+	if err != nil {
+		t.Fatalf("failed to select subnet: %v", err)
+	}
+	if aws.StringValue(subnet.SubnetId) != "subnet-b" {
+		t.Errorf("expected subnet-b with the most available IPs, got %s", aws.StringValue(subnet.SubnetId))
+	}
+	if tag := machineDeploymentTagFor(config, newDeploymentMachine("workers-1")); tag != nil {
+		t.Errorf("expected no MachineDeployment tag without balancing, got %v", tag)
+	}
+}
+
+func TestSelectSubnetBalancesAvailabilityZones(t *testing.T) {
+	client := &fakeSubnetSelectorClient{
+		subnets: []*ec2.Subnet{
+			newTestSubnet("subnet-a1", "vpc-1", "eu-central-1a", 300, "private"),
+			newTestSubnet("subnet-a2", "vpc-1", "eu-central-1a", 250, "private"),
+			newTestSubnet("subnet-b", "vpc-1", "eu-central-1b", 100, "private"),
+			newTestSubnet("subnet-c", "vpc-1", "eu-central-1c", 50, "private"),
+			newTestSubnet("subnet-public", "vpc-1", "eu-central-1c", 1000, "public"),
+		},
+		instances: map[string][]*ec2.Instance{},
+	}
+	config := &Config{VpcID: "vpc-1", SubnetSelector: &SubnetSelector{Tags: map[string]string{"tier": "private"}, Strategy: subnetStrategyBalanceAvailabilityZones}}
+
+	instancesPerSubnet := map[string]int{}
+	instancesPerZone := map[string]int{}
+	for i := 0; i < 6; i++ {
+		machine := newDeploymentMachine("workers-" + rand.String(5))
+		subnet, err := selectSubnet(client, config, machine)
+		if err != nil {
+			t.Fatalf("failed to select subnet: %v", err)
+		}
+		tag := machineDeploymentTagFor(config, machine)
+		if tag == nil || aws.StringValue(tag.Value) != "kube-system/workers" {
+			t.Fatalf("expected the MachineDeployment tag kube-system/workers, got %v", tag)
+		}
+		client.createInstance(subnet, tag)
+		instancesPerSubnet[aws.StringValue(subnet.SubnetId)]++
+		instancesPerZone[aws.StringValue(subnet.AvailabilityZone)]++
+	}
+
+	for _, zone := range []string{"eu-central-1a", "eu-central-1b", "eu-central-1c"} {
+		if instancesPerZone[zone] != 2 {
+			t.Errorf("expected 2 instances in %s, got %d", zone, instancesPerZone[zone])
+		}
+	}
+	// Within a zone the subnet with the most available IPs wins
+	if instancesPerSubnet["subnet-a1"] != 2 || instancesPerSubnet["subnet-public"] != 0 {
+		t.Errorf("expected the instances in subnet-a1 and none in the untagged subnet, got %v", instancesPerSubnet)
+	}
+}
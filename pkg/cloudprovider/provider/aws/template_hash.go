@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// machineTemplateHashTag holds the template hash of the MachineSet of the machine, so instances can be grouped by the
+// rollout revision of their MachineDeployment
+const machineTemplateHashTag = "Machine-Template-Hash"
+
+func validateTemplateHashTag(config *Config) error {
+	if _, ok := config.Tags[machineTemplateHashTag]; ok {
+		return fmt.Errorf("the tag %s is reserved for the template hash of the MachineSet", machineTemplateHashTag)
+	}
+	return nil
+}
+
+// templateHashTag returns the tag with the template hash of the MachineSet of the machine or nil if the machine does
+// not belong to a MachineDeployment
+func templateHashTag(machine *v1alpha1.Machine) *ec2.Tag {
+	hash, ok := kuberneteshelper.MachineTemplateHash(machine)
+	if !ok {
+		return nil
+	}
+	return &ec2.Tag{Key: aws.String(machineTemplateHashTag), Value: aws.String(hash)}
+}
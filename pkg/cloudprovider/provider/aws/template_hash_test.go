@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestTemplateHashTag(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		expectedValue string
+	}{
+		{
+			name:          "machine of a MachineSet",
+			labels:        map[string]string{"machine-template-hash": "1871258541"},
+			expectedValue: "1871258541",
+		},
+		{
+			name: "machine without MachineSet",
+		},
+		{
+			name:   "hash which is no valid tag value on all providers",
+			labels: map[string]string{"machine-template-hash": "Not/A-Hash"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Labels: test.labels}}
+			tag := templateHashTag(machine)
+			if test.expectedValue == "" {
+				if tag != nil {
+					t.Fatalf("expected no tag, got %v", tag)
+				}
+				return
+			}
+			if tag == nil || aws.StringValue(tag.Key) != machineTemplateHashTag || aws.StringValue(tag.Value) != test.expectedValue {
+				t.Errorf("expected tag %s=%s, got %v", machineTemplateHashTag, test.expectedValue, tag)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateHashTag(t *testing.T) {
+	if err := validateTemplateHashTag(&Config{Tags: map[string]string{"team": "infra"}}); err != nil {
+		t.Errorf("expected custom tags to be valid, got %v", err)
+	}
+	if err := validateTemplateHashTag(&Config{Tags: map[string]string{machineTemplateHashTag: "1"}}); err == nil {
+		t.Error("expected the template hash tag to be reserved")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// VolumeRef is an existing EBS volume which gets attached to the instance
+type VolumeRef struct {
+	VolumeID string
+	Device   string
+}
+
+// volumeClient is the subset of the ec2 client needed to attach and detach existing volumes
+type volumeClient interface {
+	DescribeVolumes(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error)
+	AttachVolume(*ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error)
+	DetachVolume(*ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error)
+	WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error
+}
+
+// validateAttachVolumes checks the volume references without talking to the api
+func validateAttachVolumes(volumes []VolumeRef, rootDevicePath string) error {
+	devices := map[string]bool{rootDevicePath: true}
+	ids := map[string]bool{}
+	for i, volume := range volumes {
+		if volume.VolumeID == "" {
+			return fmt.Errorf("volumeId of attached volume %d must be specified", i)
+		}
+		if volume.Device == "" {
+			return fmt.Errorf("device of attached volume %s must be specified", volume.VolumeID)
+		}
+		if ids[volume.VolumeID] {
+			return fmt.Errorf("volume %s is specified more than once", volume.VolumeID)
+		}
+		if devices[volume.Device] {
+			return fmt.Errorf("device %s of attached volume %s is already in use", volume.Device, volume.VolumeID)
+		}
+		ids[volume.VolumeID] = true
+		devices[volume.Device] = true
+	}
+	return nil
+}
+
+func describeVolumes(client volumeClient, volumes []VolumeRef) (map[string]*ec2.Volume, error) {
+	ids := make([]string, len(volumes))
+	for i, volume := range volumes {
+		ids[i] = volume.VolumeID
+	}
+	out, err := client.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice(ids)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volumes %v: %v", ids, err)
+	}
+	existing := map[string]*ec2.Volume{}
+	for _, volume := range out.Volumes {
+		existing[aws.StringValue(volume.VolumeId)] = volume
+	}
+	return existing, nil
+}
+
+// checkVolumesAvailable makes sure all volumes exist in the availability zone and are not
+// attached to any other instance
+func checkVolumesAvailable(client volumeClient, volumes []VolumeRef, availabilityZone string) error {
+	existing, err := describeVolumes(client, volumes)
+	if err != nil {
+		return err
+	}
+	for _, ref := range volumes {
+		volume, ok := existing[ref.VolumeID]
+		if !ok {
+			return fmt.Errorf("volume %s not found", ref.VolumeID)
+		}
+		if zone := aws.StringValue(volume.AvailabilityZone); zone != availabilityZone {
+			return fmt.Errorf("volume %s is in availability zone %s but the instance is in %s", ref.VolumeID, zone, availabilityZone)
+		}
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.State) == ec2.VolumeAttachmentStateDetached {
+				continue
+			}
+			return fmt.Errorf("volume %s is already attached to instance %s", ref.VolumeID, aws.StringValue(attachment.InstanceId))
+		}
+	}
+	return nil
+}
+
+// attachVolumes attaches the volumes to the instance once it is running, volumes can not be attached to pending instances
+func attachVolumes(client volumeClient, instanceID string, volumes []VolumeRef) error {
+	if err := client.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})}); err != nil {
+		return fmt.Errorf("failed waiting for instance %s to be running: %v", instanceID, err)
+	}
+	for _, volume := range volumes {
+		if _, err := client.AttachVolume(&ec2.AttachVolumeInput{
+			InstanceId: aws.String(instanceID),
+			VolumeId:   aws.String(volume.VolumeID),
+			Device:     aws.String(volume.Device),
+		}); err != nil {
+			return fmt.Errorf("failed to attach volume %s to instance %s: %v", volume.VolumeID, instanceID, err)
+		}
+	}
+	return nil
+}
+
+// detachVolumes detaches all volumes which are still attached to the instance, so they
+// are available again for the next instance
+func detachVolumes(client volumeClient, instanceID string, volumes []VolumeRef) error {
+	existing, err := describeVolumes(client, volumes)
+	if err != nil {
+		return err
+	}
+	for _, ref := range volumes {
+		volume, ok := existing[ref.VolumeID]
+		if !ok {
+			continue
+		}
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) != instanceID {
+				continue
+			}
+			state := aws.StringValue(attachment.State)
+			if state == ec2.VolumeAttachmentStateDetaching || state == ec2.VolumeAttachmentStateDetached {
+				continue
+			}
+			if _, err := client.DetachVolume(&ec2.DetachVolumeInput{
+				InstanceId: aws.String(instanceID),
+				VolumeId:   aws.String(ref.VolumeID),
+			}); err != nil {
+				return fmt.Errorf("failed to detach volume %s from instance %s: %v", ref.VolumeID, instanceID, err)
+			}
+		}
+	}
+	return nil
+}
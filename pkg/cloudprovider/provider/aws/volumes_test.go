@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeVolumeClient keeps the attachments of volumes in memory
+type fakeVolumeClient struct {
+	volumes map[string]*ec2.Volume
+}
+
+func (f *fakeVolumeClient) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	out := &ec2.DescribeVolumesOutput{}
+	for _, id := range input.VolumeIds {
+		if volume, ok := f.volumes[aws.StringValue(id)]; ok {
+			out.Volumes = append(out.Volumes, volume)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeVolumeClient) AttachVolume(input *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+	attachment := &ec2.VolumeAttachment{
+		InstanceId: input.InstanceId,
+		VolumeId:   input.VolumeId,
+		Device:     input.Device,
+		State:      aws.String(ec2.VolumeAttachmentStateAttached),
+	}
+	volume := f.volumes[aws.StringValue(input.VolumeId)]
+	volume.Attachments = append(volume.Attachments, attachment)
+	return attachment, nil
+}
+
+func (f *fakeVolumeClient) DetachVolume(input *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
+	volume := f.volumes[aws.StringValue(input.VolumeId)]
+	volume.Attachments = nil
+	return &ec2.VolumeAttachment{VolumeId: input.VolumeId, State: aws.String(ec2.VolumeAttachmentStateDetaching)}, nil
+}
+
+func (f *fakeVolumeClient) WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error {
+	return nil
+}
+
+func newFakeVolume(id string) *ec2.Volume {
+	return &ec2.Volume{VolumeId: aws.String(id), AvailabilityZone: aws.String("eu-central-1a")}
+}
+
+func TestAttachAndDetachVolumes(t *testing.T) {
+	client := &fakeVolumeClient{volumes: map[string]*ec2.Volume{
+		"vol-1": newFakeVolume("vol-1"),
+		"vol-2": newFakeVolume("vol-2"),
+	}}
+	volumes := []VolumeRef{
+		{VolumeID: "vol-1", Device: "/dev/sdf"},
+		{VolumeID: "vol-2", Device: "/dev/sdg"},
+	}
+
+	if err := checkVolumesAvailable(client, volumes, "eu-central-1a"); err != nil {
+		t.Fatalf("expected volumes to be available: %v", err)
+	}
+	if err := attachVolumes(client, "i-1", volumes); err != nil {
+		t.Fatalf("failed to attach volumes: %v", err)
+	}
+	for _, ref := range volumes {
+		attachments := client.volumes[ref.VolumeID].Attachments
+		if len(attachments) != 1 || aws.StringValue(attachments[0].InstanceId) != "i-1" || aws.StringValue(attachments[0].Device) != ref.Device {
+			t.Errorf("expected volume %s to be attached to i-1 as %s, got %v", ref.VolumeID, ref.Device, attachments)
+		}
+	}
+
+	// Volumes attached to the instance must not be available for another one
+	err := checkVolumesAvailable(client, volumes, "eu-central-1a")
+	if err == nil || !strings.Contains(err.Error(), "already attached to instance i-1") {
+		t.Errorf("expected an error about the volume being attached to i-1, got %v", err)
+	}
+
+	if err := detachVolumes(client, "i-1", volumes); err != nil {
+		t.Fatalf("failed to detach volumes: %v", err)
+	}
+	for _, ref := range volumes {
+		if attachments := client.volumes[ref.VolumeID].Attachments; len(attachments) != 0 {
+			t.Errorf("expected volume %s to be detached, got %v", ref.VolumeID, attachments)
+		}
+	}
+}
+
+func TestDetachVolumesIgnoresOtherInstances(t *testing.T) {
+	volume := newFakeVolume("vol-1")
+	volume.Attachments = []*ec2.VolumeAttachment{
+		{InstanceId: aws.String("i-2"), State: aws.String(ec2.VolumeAttachmentStateAttached)},
+	}
+	client := &fakeVolumeClient{volumes: map[string]*ec2.Volume{"vol-1": volume}}
+
+	if err := detachVolumes(client, "i-1", []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sdf"}}); err != nil {
+		t.Fatalf("failed to detach volumes: %v", err)
+	}
+	if len(volume.Attachments) != 1 {
+		t.Errorf("expected the attachment to instance i-2 to be kept")
+	}
+}
+
+func TestCheckVolumesAvailable(t *testing.T) {
+	tests := []struct {
+		name          string
+		volume        *ec2.Volume
+		expectedError string
+	}{
+		{
+			name:   "unattached volume",
+			volume: newFakeVolume("vol-1"),
+		},
+		{
+			name: "detached volume",
+			volume: &ec2.Volume{
+				VolumeId:         aws.String("vol-1"),
+				AvailabilityZone: aws.String("eu-central-1a"),
+				Attachments:      []*ec2.VolumeAttachment{{InstanceId: aws.String("i-2"), State: aws.String(ec2.VolumeAttachmentStateDetached)}},
+			},
+		},
+		{
+			name: "volume attached elsewhere",
+			volume: &ec2.Volume{
+				VolumeId:         aws.String("vol-1"),
+				AvailabilityZone: aws.String("eu-central-1a"),
+				Attachments:      []*ec2.VolumeAttachment{{InstanceId: aws.String("i-2"), State: aws.String(ec2.VolumeAttachmentStateAttached)}},
+			},
+			expectedError: "volume vol-1 is already attached to instance i-2",
+		},
+		{
+			name:          "volume in other zone",
+			volume:        &ec2.Volume{VolumeId: aws.String("vol-1"), AvailabilityZone: aws.String("eu-central-1b")},
+			expectedError: "volume vol-1 is in availability zone eu-central-1b but the instance is in eu-central-1a",
+		},
+		{
+			name:          "missing volume",
+			volume:        newFakeVolume("vol-2"),
+			expectedError: "volume vol-1 not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeVolumeClient{volumes: map[string]*ec2.Volume{aws.StringValue(test.volume.VolumeId): test.volume}}
+			err := checkVolumesAvailable(client, []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sdf"}}, "eu-central-1a")
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.expectedError {
+				t.Errorf("expected error %q, got %q", test.expectedError, errMsg)
+			}
+		})
+	}
+}
+
+func TestValidateAttachVolumes(t *testing.T) {
+	tests := []struct {
+		name        string
+		volumes     []VolumeRef
+		expectError bool
+	}{
+		{
+			name:    "valid volumes",
+			volumes: []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sdf"}, {VolumeID: "vol-2", Device: "/dev/sdg"}},
+		},
+		{
+			name:        "missing device",
+			volumes:     []VolumeRef{{VolumeID: "vol-1"}},
+			expectError: true,
+		},
+		{
+			name:        "duplicate volume",
+			volumes:     []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sdf"}, {VolumeID: "vol-1", Device: "/dev/sdg"}},
+			expectError: true,
+		},
+		{
+			name:        "root device",
+			volumes:     []VolumeRef{{VolumeID: "vol-1", Device: "/dev/sda1"}},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateAttachVolumes(test.volumes, "/dev/sda1")
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %v, got %v", test.expectError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/glog"
+)
+
+const (
+	// Azure limits the name of availability sets to 80 characters
+	maxAvailabilitySetNameLength = 80
+
+	// managedAvailabilitySetTag marks availability sets created by the machine-controller
+	managedAvailabilitySetTag = "Machine-Controller-Managed"
+)
+
+// availabilitySetID returns the full path of the availability set, as expected by the VM spec
+func availabilitySetID(c *config, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/availabilitySets/%s", c.SubscriptionID, c.ResourceGroup, name)
+}
+
+// ensureAvailabilitySet creates the availability set unless it already exists
+func ensureAvailabilitySet(ctx context.Context, client *compute.AvailabilitySetsClient, c *config, name string) error {
+	if len(name) > maxAvailabilitySetNameLength {
+		return fmt.Errorf("availability set name %q is longer than %d characters", name, maxAvailabilitySetNameLength)
+	}
+
+	existing, err := client.Get(ctx, c.ResourceGroup, name)
+	if err == nil {
+		return nil
+	}
+	if existing.Response.Response == nil || existing.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to get availability set %q: %v", name, err)
+	}
+
+	glog.Infof("Creating availability set %q", name)
+	_, err = client.CreateOrUpdate(ctx, c.ResourceGroup, name, compute.AvailabilitySet{
+		Location: to.StringPtr(c.Location),
+		// Managed disks require an aligned availability set
+		Sku: &compute.Sku{Name: to.StringPtr("Aligned")},
+		AvailabilitySetProperties: &compute.AvailabilitySetProperties{
+			PlatformFaultDomainCount:  to.Int32Ptr(2),
+			PlatformUpdateDomainCount: to.Int32Ptr(5),
+		},
+		Tags: map[string]*string{managedAvailabilitySetTag: to.StringPtr("true")},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create availability set %q: %v", name, err)
+	}
+	return nil
+}
+
+// deleteAvailabilitySetIfEmpty deletes the availability set once no VM is left in it
+func deleteAvailabilitySetIfEmpty(ctx context.Context, client *compute.AvailabilitySetsClient, c *config, name string) error {
+	set, err := client.Get(ctx, c.ResourceGroup, name)
+	if err != nil {
+		if set.Response.Response != nil && set.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get availability set %q: %v", name, err)
+	}
+	if set.AvailabilitySetProperties != nil && set.VirtualMachines != nil && len(*set.VirtualMachines) > 0 {
+		return nil
+	}
+
+	glog.Infof("Deleting empty availability set %q", name)
+	if _, err := client.Delete(ctx, c.ResourceGroup, name); err != nil {
+		return fmt.Errorf("failed to delete availability set %q: %v", name, err)
+	}
+	return nil
+}
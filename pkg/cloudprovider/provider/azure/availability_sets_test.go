@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeAvailabilitySets serves the availability set endpoints of the Azure compute API
+type fakeAvailabilitySets struct {
+	sets map[string]compute.AvailabilitySet
+}
+
+func (f *fakeAvailabilitySets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	switch r.Method {
+	case http.MethodGet:
+		set, exists := f.sets[name]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"ResourceNotFound"}}`)) // nolint: errcheck
+			return
+		}
+		json.NewEncoder(w).Encode(set) // nolint: errcheck
+	case http.MethodPut:
+		set := compute.AvailabilitySet{}
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		set.Name = to.StringPtr(name)
+		f.sets[name] = set
+		json.NewEncoder(w).Encode(set) // nolint: errcheck
+	case http.MethodDelete:
+		delete(f.sets, name)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestManagedAvailabilitySet(t *testing.T) {
+	const setName = "machine-controller-kube-system-my-workers"
+	fake := &fakeAvailabilitySets{sets: map[string]compute.AvailabilitySet{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := compute.NewAvailabilitySetsClientWithBaseURI(server.URL, "subscription")
+	c := &config{SubscriptionID: "subscription", ResourceGroup: "group", Location: "westeurope"}
+
+	// Creating the set must be idempotent, as every VM of the MachineDeployment ensures it
+	for i := 0; i < 2; i++ {
+		if err := ensureAvailabilitySet(context.Background(), &client, c, setName); err != nil {
+			t.Fatalf("failed to ensure availability set: %v", err)
+		}
+	}
+	set, exists := fake.sets[setName]
+	if !exists {
+		t.Fatalf("expected availability set %s to be created", setName)
+	}
+	if set.Sku == nil || to.String(set.Sku.Name) != "Aligned" {
+		t.Errorf("expected an aligned availability set, got %v", set.Sku)
+	}
+
+	expectedID := "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Compute/availabilitySets/" + setName
+	if vmSet := vmAvailabilitySet(c, setName); vmSet == nil || to.String(vmSet.ID) != expectedID {
+		t.Errorf("expected the VM to be assigned to %s, got %v", expectedID, vmSet)
+	}
+
+	set.VirtualMachines = &[]compute.SubResource{{ID: to.StringPtr("vm-2")}}
+	fake.sets[setName] = set
+	if err := deleteAvailabilitySetIfEmpty(context.Background(), &client, c, setName); err != nil {
+		t.Fatalf("failed to clean up availability set: %v", err)
+	}
+	if _, exists := fake.sets[setName]; !exists {
+		t.Errorf("expected availability set with VMs to be kept")
+	}
+
+	set.VirtualMachines = nil
+	fake.sets[setName] = set
+	if err := deleteAvailabilitySetIfEmpty(context.Background(), &client, c, setName); err != nil {
+		t.Fatalf("failed to clean up availability set: %v", err)
+	}
+	if _, exists := fake.sets[setName]; exists {
+		t.Errorf("expected empty availability set to be deleted")
+	}
+
+	// Deleting an already deleted set must not fail
+	if err := deleteAvailabilitySetIfEmpty(context.Background(), &client, c, setName); err != nil {
+		t.Errorf("failed to clean up deleted availability set: %v", err)
+	}
+}
+
+func TestVMAvailabilitySet(t *testing.T) {
+	c := &config{SubscriptionID: "subscription", ResourceGroup: "group"}
+	if vmSet := vmAvailabilitySet(c, ""); vmSet != nil {
+		t.Errorf("expected no availability set, got %v", to.String(vmSet.ID))
+	}
+
+	c.AvailabilitySet = "existing"
+	expectedID := "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Compute/availabilitySets/existing"
+	if vmSet := vmAvailabilitySet(c, ""); vmSet == nil || to.String(vmSet.ID) != expectedID {
+		t.Errorf("expected the VM to be assigned to %s, got %v", expectedID, vmSet)
+	}
+}
@@ -206,6 +206,11 @@ func createOrUpdatePublicIPAddress(ctx context.Context, ipName string, machineUI
 		},
 		Tags: map[string]*string{machineUIDTag: to.StringPtr(string(machineUID))},
 	}
+	// Basic public IPs can not be zonal, so zonal VMs get a standard one in their zone
+	if c.Zone != "" {
+		ipParams.Sku = &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard}
+		ipParams.Zones = vmZones(c)
+	}
 	future, err := ipClient.CreateOrUpdate(ctx, c.ResourceGroup, ipName, ipParams)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create public IP address: %v", err)
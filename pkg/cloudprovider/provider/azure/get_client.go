@@ -21,6 +21,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2018-04-01/network"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
@@ -88,3 +89,36 @@ func getDisksClient(c *config) (*compute.DisksClient, error) {
 
 	return &disksClient, err
 }
+
+func getAvailabilitySetsClient(c *config) (*compute.AvailabilitySetsClient, error) {
+	var err error
+	asClient := compute.NewAvailabilitySetsClient(c.SubscriptionID)
+	asClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %v", err)
+	}
+
+	return &asClient, nil
+}
+
+func getResourceSkusClient(c *config) (*compute.ResourceSkusClient, error) {
+	var err error
+	skusClient := compute.NewResourceSkusClient(c.SubscriptionID)
+	skusClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %v", err)
+	}
+
+	return &skusClient, nil
+}
+
+func getManagedIdentitiesClient(c *config) (*managedIdentitiesClient, error) {
+	var err error
+	identitiesClient := managedIdentitiesClient{Client: autorest.NewClientWithUserAgent(""), BaseURI: compute.DefaultBaseURI}
+	identitiesClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %v", err)
+	}
+
+	return &identitiesClient, nil
+}
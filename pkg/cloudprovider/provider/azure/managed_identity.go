@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// managedIdentityAPIVersion is the version of the Microsoft.ManagedIdentity API used to look up identities
+const managedIdentityAPIVersion = "2018-11-30"
+
+// managedIdentitiesClient looks up user assigned identities. It uses raw requests, as the managed
+// identity API is not vendored.
+type managedIdentitiesClient struct {
+	autorest.Client
+	BaseURI string
+}
+
+// managedIdentityID returns the full path of the user assigned identity, as expected by the VM spec
+func managedIdentityID(c *config) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s", c.SubscriptionID, c.ResourceGroup, c.ManagedIdentity)
+}
+
+// vmIdentity binds the VM to the configured user assigned identity
+func vmIdentity(c *config) *compute.VirtualMachineIdentity {
+	if c.ManagedIdentity == "" {
+		return nil
+	}
+	return &compute.VirtualMachineIdentity{
+		Type:        compute.ResourceIdentityTypeUserAssigned,
+		IdentityIds: &[]string{managedIdentityID(c)},
+	}
+}
+
+// validateManagedIdentity makes sure the configured user assigned identity exists
+func validateManagedIdentity(ctx context.Context, client *managedIdentitiesClient, c *config) error {
+	if c.ManagedIdentity == "" {
+		return nil
+	}
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsGet(),
+		autorest.WithBaseURL(client.BaseURI),
+		autorest.WithPath(managedIdentityID(c)),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": managedIdentityAPIVersion}))
+	if err != nil {
+		return fmt.Errorf("failed to prepare the request for managed identity %q: %v", c.ManagedIdentity, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get managed identity %q: %v", c.ManagedIdentity, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return fmt.Errorf("managed identity %q not found in resource group %q", c.ManagedIdentity, c.ResourceGroup)
+	}
+	if err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByClosing()); err != nil {
+		return fmt.Errorf("failed to get managed identity %q: %v", c.ManagedIdentity, err)
+	}
+	return nil
+}
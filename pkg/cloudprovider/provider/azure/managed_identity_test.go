@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestVMIdentity(t *testing.T) {
+	c := &config{SubscriptionID: "subscription", ResourceGroup: "cluster", ManagedIdentity: "nodes"}
+	identity := vmIdentity(c)
+	if identity == nil {
+		t.Fatal("expected the VM to be bound to the managed identity")
+	}
+	if identity.Type != compute.ResourceIdentityTypeUserAssigned {
+		t.Errorf("expected a user assigned identity, got %q", identity.Type)
+	}
+	expectedID := "/subscriptions/subscription/resourceGroups/cluster/providers/Microsoft.ManagedIdentity/userAssignedIdentities/nodes"
+	if identity.IdentityIds == nil || len(*identity.IdentityIds) != 1 || (*identity.IdentityIds)[0] != expectedID {
+		t.Errorf("expected identity ids [%s], got %v", expectedID, identity.IdentityIds)
+	}
+
+	if identity := vmIdentity(&config{}); identity != nil {
+		t.Errorf("expected no identity without a managed identity, got %v", identity)
+	}
+}
+
+func TestValidateManagedIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != managedIdentityAPIVersion {
+			t.Errorf("unexpected api version %q", r.URL.Query().Get("api-version"))
+		}
+		if r.URL.Path == "/subscriptions/subscription/resourceGroups/cluster/providers/Microsoft.ManagedIdentity/userAssignedIdentities/nodes" {
+			w.Write([]byte(`{"name":"nodes"}`)) // nolint: errcheck
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":"ResourceNotFound"}}`)) // nolint: errcheck
+	}))
+	defer server.Close()
+	client := &managedIdentitiesClient{Client: autorest.NewClientWithUserAgent(""), BaseURI: server.URL}
+
+	tests := []struct {
+		name          string
+		identity      string
+		expectedError bool
+	}{
+		{
+			name: "no managed identity",
+		},
+		{
+			name:     "existing managed identity",
+			identity: "nodes",
+		},
+		{
+			name:          "missing managed identity",
+			identity:      "ingress",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{SubscriptionID: "subscription", ResourceGroup: "cluster", ManagedIdentity: test.identity}
+			err := validateManagedIdentity(context.Background(), client, c)
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -29,6 +29,8 @@ import (
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/glog"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/identity"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/placementgroup"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/ssh"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
@@ -36,6 +38,7 @@ import (
 	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	common "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
@@ -43,8 +46,9 @@ import (
 )
 
 const (
-	machineUIDTag = "Machine-UID"
-	adminUserName = "kubermatic"
+	machineUIDTag          = "Machine-UID"
+	machineTemplateHashTag = "Machine-Template-Hash"
+	adminUserName          = "kubermatic"
 
 	finalizerPublicIP = "kubermatic.io/cleanup-azure-public-ip"
 	finalizerNIC      = "kubermatic.io/cleanup-azure-nic"
@@ -71,9 +75,18 @@ type RawConfig struct {
 	RouteTableName    providerconfig.ConfigVarString `json:"routeTableName"`
 	AvailabilitySet   providerconfig.ConfigVarString `json:"availabilitySet"`
 	SecurityGroupName providerconfig.ConfigVarString `json:"securityGroupName"`
+	// Zone pins all VMs to the given availability zone of the location, e.g. "1"
+	Zone providerconfig.ConfigVarString `json:"zone"`
 
 	AssignPublicIP providerconfig.ConfigVarBool `json:"assignPublicIP"`
 	Tags           map[string]string            `json:"tags"`
+
+	// ManagedAvailabilitySet places all VMs of a MachineDeployment in an availability set,
+	// which gets created on demand and deleted once the last VM is gone
+	ManagedAvailabilitySet providerconfig.ConfigVarBool `json:"managedAvailabilitySet"`
+
+	// ManagedIdentity is the name of a user assigned identity in the resource group the VMs get bound to
+	ManagedIdentity providerconfig.ConfigVarString `json:"managedIdentity"`
 }
 
 type config struct {
@@ -90,18 +103,22 @@ type config struct {
 	RouteTableName    string
 	AvailabilitySet   string
 	SecurityGroupName string
+	Zone              string
 
 	AssignPublicIP bool
 	Tags           map[string]string
+
+	ManagedAvailabilitySet bool
+	ManagedIdentity        string
 }
 
 type azureVM struct {
 	vm          *compute.VirtualMachine
-	ipAddresses []string
+	ipAddresses map[string]corev1.NodeAddressType
 	status      instance.Status
 }
 
-func (vm *azureVM) Addresses() []string {
+func (vm *azureVM) Addresses() map[string]corev1.NodeAddressType {
 	return vm.ipAddresses
 }
 
@@ -117,6 +134,10 @@ func (vm *azureVM) Status() instance.Status {
 	return vm.status
 }
 
+func (vm *azureVM) Tags() map[string]string {
+	return to.StringMap(vm.vm.Tags)
+}
+
 var imageReferences = map[providerconfig.OperatingSystem]compute.ImageReference{
 	providerconfig.OperatingSystemCoreos: {
 		Publisher: to.StringPtr("CoreOS"),
@@ -231,18 +252,33 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*config, *providerconfig.
 		return nil, nil, fmt.Errorf("failed to get the value of \"availabilitySet\" field, error = %v", err)
 	}
 
+	c.ManagedAvailabilitySet, err = p.configVarResolver.GetConfigVarBoolValue(rawCfg.ManagedAvailabilitySet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"managedAvailabilitySet\" field, error = %v", err)
+	}
+
 	c.SecurityGroupName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.SecurityGroupName)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get the value of \"securityGroupName\" field, error = %v", err)
 	}
 
+	c.Zone, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.Zone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"zone\" field, error = %v", err)
+	}
+
+	c.ManagedIdentity, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.ManagedIdentity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"managedIdentity\" field, error = %v", err)
+	}
+
 	c.Tags = rawCfg.Tags
 
 	return &c, &pconfig, nil
 }
 
-func getVMIPAddresses(ctx context.Context, c *config, vm *compute.VirtualMachine) ([]string, error) {
-	var ipAddresses []string
+func getVMIPAddresses(ctx context.Context, c *config, vm *compute.VirtualMachine) (map[string]corev1.NodeAddressType, error) {
+	ipAddresses := map[string]corev1.NodeAddressType{}
 
 	if vm.VirtualMachineProperties == nil {
 		return nil, fmt.Errorf("machine is missing properties")
@@ -267,13 +303,15 @@ func getVMIPAddresses(ctx context.Context, c *config, vm *compute.VirtualMachine
 		if vm.NetworkProfile.NetworkInterfaces == nil {
 			return nil, fmt.Errorf("failed to get addresses for interface %q: %v", ifaceName, err)
 		}
-		ipAddresses = append(ipAddresses, addrs...)
+		for addr, addrType := range addrs {
+			ipAddresses[addr] = addrType
+		}
 	}
 
 	return ipAddresses, nil
 }
 
-func getNICIPAddresses(ctx context.Context, c *config, ifaceName string) ([]string, error) {
+func getNICIPAddresses(ctx context.Context, c *config, ifaceName string) (map[string]corev1.NodeAddressType, error) {
 	ifClient, err := getInterfacesClient(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create interfaces client: %v", err)
@@ -284,7 +322,7 @@ func getNICIPAddresses(ctx context.Context, c *config, ifaceName string) ([]stri
 		return nil, fmt.Errorf("failed to get interface %q: %v", ifaceName, err.Error())
 	}
 
-	var ipAddresses []string
+	ipAddresses := map[string]corev1.NodeAddressType{}
 
 	if netIf.IPConfigurations != nil {
 		for _, conf := range *netIf.IPConfigurations {
@@ -305,14 +343,16 @@ func getNICIPAddresses(ctx context.Context, c *config, ifaceName string) ([]stri
 				return nil, fmt.Errorf("failed to retrieve IP string for IP %q: %v", name, err)
 			}
 
-			ipAddresses = append(ipAddresses, addrStrings...)
+			for addr, addrType := range addrStrings {
+				ipAddresses[addr] = addrType
+			}
 		}
 	}
 
 	return ipAddresses, nil
 }
 
-func getIPAddressStrings(ctx context.Context, c *config, addrName string) ([]string, error) {
+func getIPAddressStrings(ctx context.Context, c *config, addrName string) (map[string]corev1.NodeAddressType, error) {
 	ipClient, err := getIPClient(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create IP address client: %v", err)
@@ -327,12 +367,12 @@ func getIPAddressStrings(ctx context.Context, c *config, addrName string) ([]str
 		return nil, fmt.Errorf("IP %q has nil IPConfiguration", addrName)
 	}
 
-	var ipAddresses []string
+	ipAddresses := map[string]corev1.NodeAddressType{}
 	if ip.IPConfiguration.PublicIPAddress != nil && ip.IPConfiguration.PublicIPAddress.IPAddress != nil {
-		ipAddresses = append(ipAddresses, *ip.IPConfiguration.PublicIPAddress.IPAddress)
+		ipAddresses[*ip.IPConfiguration.PublicIPAddress.IPAddress] = corev1.NodeExternalIP
 	}
 	if ip.IPConfiguration.PrivateIPAddress != nil {
-		ipAddresses = append(ipAddresses, *ip.IPConfiguration.PrivateIPAddress)
+		ipAddresses[*ip.IPConfiguration.PrivateIPAddress] = corev1.NodeInternalIP
 	}
 
 	return ipAddresses, nil
@@ -401,6 +441,9 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		tags[k] = to.StringPtr(v)
 	}
 	tags[machineUIDTag] = to.StringPtr(string(machine.UID))
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		tags[machineTemplateHashTag] = to.StringPtr(hash)
+	}
 
 	vmSpec := compute.VirtualMachine{
 		Location: &config.Location,
@@ -432,14 +475,38 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 			},
 			StorageProfile: &compute.StorageProfile{ImageReference: osRef},
 		},
-		Tags: tags,
+		Identity: vmIdentity(config),
+		Tags:     tags,
+		Zones:    vmZones(config),
+	}
+
+	if config.ManagedIdentity != "" {
+		identitiesClient, err := getManagedIdentitiesClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create managed identities client: %v", err)
+		}
+		if err := validateManagedIdentity(context.TODO(), identitiesClient, config); err != nil {
+			return nil, err
+		}
 	}
 
-	if config.AvailabilitySet != "" {
-		// Azure expects the full path to the resource
-		asURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/availabilitySets/%s", config.SubscriptionID, config.ResourceGroup, config.AvailabilitySet)
-		vmSpec.VirtualMachineProperties.AvailabilitySet = &compute.SubResource{ID: to.StringPtr(asURI)}
+	var managedAvailabilitySet string
+	if config.ManagedAvailabilitySet {
+		if managedAvailabilitySet, err = placementgroup.Name(machine); err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: fmt.Sprintf("failed to determine the availability set: %v", err),
+			}
+		}
+		asClient, err := getAvailabilitySetsClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create availability set client: %v", err)
+		}
+		if err := ensureAvailabilitySet(context.TODO(), asClient, config, managedAvailabilitySet); err != nil {
+			return nil, err
+		}
 	}
+	vmSpec.VirtualMachineProperties.AvailabilitySet = vmAvailabilitySet(config, managedAvailabilitySet)
 
 	glog.Infof("Creating machine %q", machine.Spec.Name)
 	if !kuberneteshelper.HasFinalizer(machine, finalizerDisks) {
@@ -487,6 +554,13 @@ func (p *provider) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.Ma
 		return nil, fmt.Errorf("failed to retrieve status for VM %q: %v", machine.Spec.Name, err.Error())
 	}
 
+	if config.ManagedIdentity != "" && data != nil && data.Updater != nil {
+		// Only a hint for users, the VM is usable without it
+		if err := identity.Record(data, machine, managedIdentityID(config)); err != nil {
+			glog.Errorf("Failed to record the identity of machine %s: %v", machine.Name, err)
+		}
+	}
+
 	return &azureVM{vm: &vm, ipAddresses: ipAddresses, status: status}, nil
 }
 
@@ -542,9 +616,35 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, data *cloudprovidertypes.M
 		return false, err
 	}
 
+	if config.ManagedAvailabilitySet {
+		// Without a name the VM could not have been created
+		if name, err := placementgroup.Name(machine); err == nil {
+			asClient, err := getAvailabilitySetsClient(config)
+			if err != nil {
+				return false, fmt.Errorf("failed to create availability set client: %v", err)
+			}
+			if err := deleteAvailabilitySetIfEmpty(context.TODO(), asClient, config, name); err != nil {
+				return false, err
+			}
+		}
+	}
+
 	return true, nil
 }
 
+// vmAvailabilitySet returns the availability set of the VM, managedAvailabilitySet is optional
+func vmAvailabilitySet(c *config, managedAvailabilitySet string) *compute.SubResource {
+	name := c.AvailabilitySet
+	if managedAvailabilitySet != "" {
+		name = managedAvailabilitySet
+	}
+	if name == "" {
+		return nil
+	}
+	// Azure expects the full path to the resource
+	return &compute.SubResource{ID: to.StringPtr(availabilitySetID(c, name))}
+}
+
 func getVMByUID(ctx context.Context, c *config, uid types.UID) (*compute.VirtualMachine, error) {
 	vmClient, err := getVMClient(c)
 	if err != nil {
@@ -725,6 +825,14 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return errors.New("subnetName is missing")
 	}
 
+	if c.ManagedAvailabilitySet && c.AvailabilitySet != "" {
+		return errors.New("availabilitySet and managedAvailabilitySet are mutually exclusive")
+	}
+
+	if c.Zone != "" && (c.ManagedAvailabilitySet || c.AvailabilitySet != "") {
+		return errors.New("zone can not be combined with availabilitySet or managedAvailabilitySet")
+	}
+
 	vmClient, err := getVMClient(c)
 	if err != nil {
 		return fmt.Errorf("failed to (create) vm client: %v", err.Error())
@@ -743,6 +851,26 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("failed to get subnet: %v", err)
 	}
 
+	if c.Zone != "" {
+		skusClient, err := getResourceSkusClient(c)
+		if err != nil {
+			return fmt.Errorf("failed to create resource skus client: %v", err)
+		}
+		if err := validateZone(context.TODO(), skusClient, c); err != nil {
+			return err
+		}
+	}
+
+	if c.ManagedIdentity != "" {
+		identitiesClient, err := getManagedIdentitiesClient(c)
+		if err != nil {
+			return fmt.Errorf("failed to create managed identities client: %v", err)
+		}
+		if err := validateManagedIdentity(context.TODO(), identitiesClient, c); err != nil {
+			return err
+		}
+	}
+
 	_, err = getOSImageReference(providerCfg.OperatingSystem)
 	return err
 }
@@ -824,6 +952,15 @@ func (p *provider) MigrateUID(machine *v1alpha1.Machine, new types.UID) error {
 	return nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.VMSize, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -839,3 +976,7 @@ func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]s
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const virtualMachinesResourceType = "virtualMachines"
+
+// validateZone makes sure the VM size of the config can be created in its zone. Azure reports the zones
+// per location and VM size via the resource SKUs, minus the zones the subscription is restricted from
+func validateZone(ctx context.Context, client *compute.ResourceSkusClient, c *config) error {
+	skus, err := client.ListComplete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list resource skus: %v", err)
+	}
+	for ; skus.NotDone(); err = skus.Next() {
+		if err != nil {
+			return fmt.Errorf("failed to list resource skus: %v", err)
+		}
+		sku := skus.Value()
+		if to.String(sku.ResourceType) != virtualMachinesResourceType || !strings.EqualFold(to.String(sku.Name), c.VMSize) {
+			continue
+		}
+		if !skuHasZone(sku, c.Location, c.Zone) {
+			return fmt.Errorf("vm size %q is not available in zone %q of location %q", c.VMSize, c.Zone, c.Location)
+		}
+		return nil
+	}
+	return fmt.Errorf("vm size %q does not exist", c.VMSize)
+}
+
+func skuHasZone(sku compute.ResourceSku, location, zone string) bool {
+	if sku.Restrictions != nil {
+		for _, restriction := range *sku.Restrictions {
+			if restriction.RestrictionInfo == nil {
+				continue
+			}
+			if restriction.Type == compute.Location && containsString(restriction.RestrictionInfo.Locations, location) {
+				return false
+			}
+			if restriction.Type == compute.Zone && containsString(restriction.RestrictionInfo.Zones, zone) {
+				return false
+			}
+		}
+	}
+	if sku.LocationInfo == nil {
+		return false
+	}
+	for _, info := range *sku.LocationInfo {
+		if strings.EqualFold(to.String(info.Location), location) && containsString(info.Zones, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values *[]string, value string) bool {
+	if values == nil {
+		return false
+	}
+	for _, v := range *values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// vmZones returns the zones of the VM, nil lets Azure place it in the location
+func vmZones(c *config) *[]string {
+	if c.Zone == "" {
+		return nil
+	}
+	return &[]string{c.Zone}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestValidateZone(t *testing.T) {
+	skus := compute.ResourceSkusResult{Value: &[]compute.ResourceSku{
+		{
+			ResourceType: to.StringPtr("disks"),
+			Name:         to.StringPtr("Standard_D2s_v3"),
+		},
+		{
+			ResourceType: to.StringPtr(virtualMachinesResourceType),
+			Name:         to.StringPtr("Standard_D2s_v3"),
+			LocationInfo: &[]compute.ResourceSkuLocationInfo{
+				{Location: to.StringPtr("northeurope"), Zones: &[]string{"1"}},
+				{Location: to.StringPtr("WestEurope"), Zones: &[]string{"1", "2", "3"}},
+			},
+			Restrictions: &[]compute.ResourceSkuRestrictions{{
+				Type:            compute.Zone,
+				RestrictionInfo: &compute.ResourceSkuRestrictionInfo{Zones: &[]string{"3"}},
+			}},
+		},
+		{
+			ResourceType: to.StringPtr(virtualMachinesResourceType),
+			Name:         to.StringPtr("Standard_F1"),
+			LocationInfo: &[]compute.ResourceSkuLocationInfo{{Location: to.StringPtr("westeurope")}},
+		},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(skus) // nolint: errcheck
+	}))
+	defer server.Close()
+	client := compute.NewResourceSkusClientWithBaseURI(server.URL, "subscription")
+
+	tests := []struct {
+		name          string
+		vmSize        string
+		zone          string
+		expectedError bool
+	}{
+		{
+			name:   "vm size available in zone",
+			vmSize: "Standard_D2s_v3",
+			zone:   "2",
+		},
+		{
+			name:   "vm size gets matched case insensitive",
+			vmSize: "standard_d2s_v3",
+			zone:   "1",
+		},
+		{
+			name:          "zone restricted for the subscription",
+			vmSize:        "Standard_D2s_v3",
+			zone:          "3",
+			expectedError: true,
+		},
+		{
+			name:          "zone does not exist in location",
+			vmSize:        "Standard_D2s_v3",
+			zone:          "4",
+			expectedError: true,
+		},
+		{
+			name:          "vm size without zones",
+			vmSize:        "Standard_F1",
+			zone:          "1",
+			expectedError: true,
+		},
+		{
+			name:          "unknown vm size",
+			vmSize:        "Standard_X1",
+			zone:          "1",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{Location: "westeurope", VMSize: test.vmSize, Zone: test.zone}
+			err := validateZone(context.Background(), &client, c)
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestVMZones(t *testing.T) {
+	if zones := vmZones(&config{}); zones != nil {
+		t.Errorf("expected no zones, got %v", *zones)
+	}
+	zones := vmZones(&config{Zone: "2"})
+	if zones == nil || len(*zones) != 1 || (*zones)[0] != "2" {
+		t.Errorf("expected the VM to be pinned to zone 2, got %v", zones)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/digitalocean/godo"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
+)
+
+// firewallRequest converts the configured rules to a request creating the firewall with the droplet
+func firewallRequest(name string, config *firewall.Config, dropletID int) *godo.FirewallRequest {
+	request := &godo.FirewallRequest{
+		Name:       name,
+		DropletIDs: []int{dropletID},
+	}
+	for _, rule := range config.Rules {
+		if rule.Direction == firewall.DirectionIn {
+			request.InboundRules = append(request.InboundRules, godo.InboundRule{
+				Protocol:  rule.Protocol,
+				PortRange: rule.Port,
+				Sources:   &godo.Sources{Addresses: rule.CIDRs},
+			})
+			continue
+		}
+		request.OutboundRules = append(request.OutboundRules, godo.OutboundRule{
+			Protocol:     rule.Protocol,
+			PortRange:    rule.Port,
+			Destinations: &godo.Destinations{Addresses: rule.CIDRs},
+		})
+	}
+	return request
+}
+
+// getFirewallsByName returns all firewalls with the given name, as DigitalOcean does not enforce unique names
+func getFirewallsByName(ctx context.Context, service godo.FirewallsService, name string) ([]godo.Firewall, error) {
+	firewalls, rsp, err := service.List(ctx, &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return nil, doStatusAndErrToTerminalError(responseStatusCode(rsp), fmt.Errorf("failed to list firewalls: %v", err))
+	}
+	var matching []godo.Firewall
+	for _, fw := range firewalls {
+		if fw.Name == name {
+			matching = append(matching, fw)
+		}
+	}
+	return matching, nil
+}
+
+// ensureFirewall adds the droplet to the firewall and creates the firewall if it does not exist yet
+func ensureFirewall(ctx context.Context, service godo.FirewallsService, name string, config *firewall.Config, dropletID int) error {
+	firewalls, err := getFirewallsByName(ctx, service, name)
+	if err != nil {
+		return err
+	}
+	if len(firewalls) == 0 {
+		if _, rsp, err := service.Create(ctx, firewallRequest(name, config, dropletID)); err != nil {
+			return doStatusAndErrToTerminalError(responseStatusCode(rsp), fmt.Errorf("failed to create firewall %s: %v", name, err))
+		}
+		return nil
+	}
+
+	fw := firewalls[0]
+	for _, id := range fw.DropletIDs {
+		if id == dropletID {
+			return nil
+		}
+	}
+	if rsp, err := service.AddDroplets(ctx, fw.ID, dropletID); err != nil {
+		return doStatusAndErrToTerminalError(responseStatusCode(rsp), fmt.Errorf("failed to add droplet %d to firewall %s: %v", dropletID, name, err))
+	}
+	return nil
+}
+
+// deleteFirewallIfEmpty deletes the firewall once no droplet is left in it. DigitalOcean removes
+// deleted droplets from the firewall.
+func deleteFirewallIfEmpty(ctx context.Context, service godo.FirewallsService, name string) error {
+	firewalls, err := getFirewallsByName(ctx, service, name)
+	if err != nil {
+		return err
+	}
+	for _, fw := range firewalls {
+		if len(fw.DropletIDs) > 0 {
+			continue
+		}
+		rsp, err := service.Delete(ctx, fw.ID)
+		// Already deleted in the meantime
+		if responseStatusCode(rsp) == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return doStatusAndErrToTerminalError(responseStatusCode(rsp), fmt.Errorf("failed to delete firewall %s: %v", name, err))
+		}
+	}
+	return nil
+}
+
+// responseStatusCode returns the status code of the response, which is nil if the request failed before
+// getting one
+func responseStatusCode(rsp *godo.Response) int {
+	if rsp == nil || rsp.Response == nil {
+		return 0
+	}
+	return rsp.StatusCode
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	godocontext "github.com/digitalocean/godo/context"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
+)
+
+// fakeFirewallsService keeps the firewalls in memory, calling any other method panics
+type fakeFirewallsService struct {
+	godo.FirewallsService
+	firewalls []godo.Firewall
+}
+
+func (f *fakeFirewallsService) List(_ godocontext.Context, _ *godo.ListOptions) ([]godo.Firewall, *godo.Response, error) {
+	return f.firewalls, &godo.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+}
+
+func (f *fakeFirewallsService) Create(_ godocontext.Context, request *godo.FirewallRequest) (*godo.Firewall, *godo.Response, error) {
+	fw := godo.Firewall{
+		ID:            strconv.Itoa(len(f.firewalls) + 1),
+		Name:          request.Name,
+		InboundRules:  request.InboundRules,
+		OutboundRules: request.OutboundRules,
+		DropletIDs:    request.DropletIDs,
+	}
+	f.firewalls = append(f.firewalls, fw)
+	return &fw, &godo.Response{Response: &http.Response{StatusCode: http.StatusAccepted}}, nil
+}
+
+func (f *fakeFirewallsService) AddDroplets(_ godocontext.Context, id string, dropletIDs ...int) (*godo.Response, error) {
+	for i := range f.firewalls {
+		if f.firewalls[i].ID == id {
+			f.firewalls[i].DropletIDs = append(f.firewalls[i].DropletIDs, dropletIDs...)
+		}
+	}
+	return &godo.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+}
+
+func (f *fakeFirewallsService) Delete(_ godocontext.Context, id string) (*godo.Response, error) {
+	for i := range f.firewalls {
+		if f.firewalls[i].ID == id {
+			f.firewalls = append(f.firewalls[:i], f.firewalls[i+1:]...)
+			return &godo.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+		}
+	}
+	return &godo.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, &godo.ErrorResponse{Message: "not found"}
+}
+
+func TestManagedFirewall(t *testing.T) {
+	const firewallName = "machine-controller-kube-system-my-workers"
+	ctx := context.Background()
+	service := &fakeFirewallsService{}
+	config := &firewall.Config{Rules: []firewall.Rule{
+		{Direction: firewall.DirectionIn, Protocol: firewall.ProtocolTCP, Port: "22", CIDRs: []string{"10.0.0.0/8"}},
+		{Direction: firewall.DirectionOut, Protocol: firewall.ProtocolUDP, Port: "53", CIDRs: []string{"0.0.0.0/0"}},
+	}}
+
+	// Every droplet of the MachineDeployment ensures the firewall, adding it twice must not fail
+	for _, dropletID := range []int{1, 2, 2} {
+		if err := ensureFirewall(ctx, service, firewallName, config, dropletID); err != nil {
+			t.Fatalf("failed to ensure firewall: %v", err)
+		}
+	}
+	if len(service.firewalls) != 1 {
+		t.Fatalf("expected exactly one firewall, got %d", len(service.firewalls))
+	}
+	fw := service.firewalls[0]
+	if len(fw.InboundRules) != 1 || fw.InboundRules[0].PortRange != "22" || fw.InboundRules[0].Sources.Addresses[0] != "10.0.0.0/8" {
+		t.Errorf("unexpected inbound rules %+v", fw.InboundRules)
+	}
+	if len(fw.OutboundRules) != 1 || fw.OutboundRules[0].Protocol != "udp" || fw.OutboundRules[0].Destinations.Addresses[0] != "0.0.0.0/0" {
+		t.Errorf("unexpected outbound rules %+v", fw.OutboundRules)
+	}
+	if len(fw.DropletIDs) != 2 {
+		t.Errorf("expected 2 droplets in the firewall, got %v", fw.DropletIDs)
+	}
+
+	if err := deleteFirewallIfEmpty(ctx, service, firewallName); err != nil {
+		t.Fatalf("failed to clean up firewall: %v", err)
+	}
+	if len(service.firewalls) != 1 {
+		t.Errorf("expected firewall with droplets to be kept")
+	}
+
+	service.firewalls[0].DropletIDs = nil
+	if err := deleteFirewallIfEmpty(ctx, service, firewallName); err != nil {
+		t.Fatalf("failed to clean up firewall: %v", err)
+	}
+	if len(service.firewalls) != 0 {
+		t.Errorf("expected empty firewall to be deleted")
+	}
+}
@@ -29,12 +29,14 @@ import (
 	"github.com/golang/glog"
 	"golang.org/x/oauth2"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/ssh"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -61,6 +63,10 @@ type RawConfig struct {
 	PrivateNetworking providerconfig.ConfigVarBool     `json:"private_networking"`
 	Monitoring        providerconfig.ConfigVarBool     `json:"monitoring"`
 	Tags              []providerconfig.ConfigVarString `json:"tags"`
+
+	// Firewall gets created for the MachineDeployment and all of its droplets get added to it. It gets deleted
+	// once the last droplet is gone.
+	Firewall *firewall.Config `json:"firewall,omitempty"`
 }
 
 type Config struct {
@@ -72,6 +78,7 @@ type Config struct {
 	PrivateNetworking bool
 	Monitoring        bool
 	Tags              []string
+	Firewall          *firewall.Config
 }
 
 const (
@@ -163,6 +170,7 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 		}
 		c.Tags = append(c.Tags, tagVal)
 	}
+	c.Firewall = rawConfig.Firewall
 
 	return &c, &pconfig, err
 }
@@ -194,6 +202,10 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("invalid operating system specified %q: %v", pc.OperatingSystem, err)
 	}
 
+	if err := c.Firewall.Validate(); err != nil {
+		return fmt.Errorf("invalid firewall config: %v", err)
+	}
+
 	ctx := context.TODO()
 	client := getClient(c.Token)
 
@@ -301,6 +313,17 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 			Message: fmt.Sprintf("Failed to parse MachineSpec, invalid operating system specified %q: %v", pc.OperatingSystem, err),
 		}
 	}
+
+	var firewallName string
+	if c.Firewall != nil {
+		if firewallName, err = firewall.Name(machine); err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: fmt.Sprintf("Failed to determine the firewall: %v", err),
+			}
+		}
+	}
+
 	createRequest := &godo.DropletCreateRequest{
 		Image:             godo.DropletCreateImage{Slug: slug},
 		Name:              machine.Spec.Name,
@@ -340,6 +363,16 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		return false, nil
 	})
 
+	if err == nil && c.Firewall != nil {
+		// The droplet gets deleted again as it would never get added to the firewall afterwards
+		if fwErr := ensureFirewall(ctx, client.Firewalls, firewallName, c.Firewall, droplet.ID); fwErr != nil {
+			if _, err := client.Droplets.Delete(ctx, droplet.ID); err != nil {
+				return nil, fmt.Errorf("failed to delete droplet %d due to %v after failing to add it to its firewall: %v", droplet.ID, err, fwErr)
+			}
+			return nil, fwErr
+		}
+	}
+
 	return &doInstance{droplet: droplet}, err
 }
 
@@ -347,6 +380,9 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	instance, err := p.Get(machine)
 	if err != nil {
 		if err == cloudprovidererrors.ErrInstanceNotFound {
+			if err := p.cleanupFirewall(machine); err != nil {
+				return false, err
+			}
 			return true, nil
 		}
 		return false, err
@@ -375,6 +411,40 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	return false, nil
 }
 
+// cleanupFirewall deletes the firewall once the last droplet of the MachineDeployment is gone
+func (p *provider) cleanupFirewall(machine *v1alpha1.Machine) error {
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+	if c.Firewall == nil {
+		return nil
+	}
+	firewallName, err := firewall.Name(machine)
+	if err != nil {
+		// Creating the droplet would have failed already
+		return nil
+	}
+
+	return deleteFirewallIfEmpty(context.TODO(), getClient(c.Token).Firewalls, firewallName)
+}
+
+// ValidateCredentials checks the token by getting the account it belongs to
+func (p *provider) ValidateCredentials(spec v1alpha1.MachineSpec) error {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if _, _, err := getClient(c.Token).Account.Get(context.TODO()); err != nil {
+		return fmt.Errorf("failed to get account: %v", err)
+	}
+	return nil
+}
+
 func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -447,6 +517,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 	return "", "", nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.Size, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -471,17 +550,33 @@ func (d *doInstance) ID() string {
 	return strconv.Itoa(d.droplet.ID)
 }
 
-func (d *doInstance) Addresses() []string {
-	var addresses []string
+func (d *doInstance) CreationTime() time.Time {
+	created, err := time.Parse(time.RFC3339, d.droplet.Created)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+func (d *doInstance) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, n := range d.droplet.Networks.V4 {
-		addresses = append(addresses, n.IPAddress)
+		addresses[n.IPAddress] = doAddressType(n.Type)
 	}
 	for _, n := range d.droplet.Networks.V6 {
-		addresses = append(addresses, n.IPAddress)
+		addresses[n.IPAddress] = doAddressType(n.Type)
 	}
 	return addresses
 }
 
+// doAddressType maps the type of a droplet network to the node address type
+func doAddressType(networkType string) corev1.NodeAddressType {
+	if networkType == "public" {
+		return corev1.NodeExternalIP
+	}
+	return corev1.NodeInternalIP
+}
+
 func (d *doInstance) Status() instance.Status {
 	switch d.droplet.Status {
 	case "new":
@@ -515,3 +610,7 @@ func doStatusAndErrToTerminalError(status int, err error) error {
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
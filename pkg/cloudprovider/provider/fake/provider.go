@@ -22,10 +22,12 @@ import (
 
 	"github.com/golang/glog"
 
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
@@ -34,7 +36,9 @@ import (
 type provider struct{}
 
 type CloudProviderSpec struct {
-	PassValidation bool `json:"passValidation"`
+	PassValidation            bool `json:"passValidation"`
+	FailCredentialsValidation bool `json:"failCredentialsValidation,omitempty"`
+	InstanceNotFound          bool `json:"instanceNotFound,omitempty"`
 }
 
 type CloudProviderInstance struct{}
@@ -45,7 +49,7 @@ func (f CloudProviderInstance) Name() string {
 func (f CloudProviderInstance) ID() string {
 	return ""
 }
-func (f CloudProviderInstance) Addresses() []string {
+func (f CloudProviderInstance) Addresses() map[string]corev1.NodeAddressType {
 	return nil
 }
 func (f CloudProviderInstance) Status() instance.Status {
@@ -83,7 +87,41 @@ func (p *provider) Validate(machinespec v1alpha1.MachineSpec) error {
 	return fmt.Errorf("failing validation as requested")
 }
 
+// ValidateCredentials fails if requested via its FakeCloudProviderSpec
+func (p *provider) ValidateCredentials(machinespec v1alpha1.MachineSpec) error {
+	pconfig := providerconfig.Config{}
+	if err := json.Unmarshal(machinespec.ProviderSpec.Value.Raw, &pconfig); err != nil {
+		return err
+	}
+
+	fakeCloudProviderSpec := CloudProviderSpec{}
+	if err := json.Unmarshal(pconfig.CloudProviderSpec.Raw, &fakeCloudProviderSpec); err != nil {
+		return err
+	}
+
+	if fakeCloudProviderSpec.FailCredentialsValidation {
+		return fmt.Errorf("failing credentials validation as requested")
+	}
+	return nil
+}
+
+// Get returns cloudprovidererrors.ErrInstanceNotFound if requested via its FakeCloudProviderSpec
 func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
+	pconfig := providerconfig.Config{}
+	if err := json.Unmarshal(machine.Spec.ProviderSpec.Value.Raw, &pconfig); err != nil {
+		return nil, err
+	}
+
+	fakeCloudProviderSpec := CloudProviderSpec{}
+	if len(pconfig.CloudProviderSpec.Raw) > 0 {
+		if err := json.Unmarshal(pconfig.CloudProviderSpec.Raw, &fakeCloudProviderSpec); err != nil {
+			return nil, err
+		}
+	}
+
+	if fakeCloudProviderSpec.InstanceNotFound {
+		return nil, cloudprovidererrors.ErrInstanceNotFound
+	}
 	return CloudProviderInstance{}, nil
 }
 
@@ -111,3 +149,7 @@ func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]s
 func (p *provider) SetMetricsForMachines(_ v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
@@ -24,13 +24,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
+	"cloud.google.com/go/logging"
+	monitoring "cloud.google.com/go/monitoring/apiv3"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 )
 
@@ -58,6 +64,34 @@ var diskTypes = map[string]bool{
 	"pd-ssd":      true,
 }
 
+// reservationTypes maps the configurable reservation affinity types to
+// the consume reservation types of the Google Cloud.
+var reservationTypes = map[string]string{
+	"any":      "ANY_RESERVATION",
+	"specific": "SPECIFIC_RESERVATION",
+	"none":     "NO_RESERVATION",
+}
+
+// reservationNameKey is the label key used to select a specific reservation.
+const reservationNameKey = "compute.googleapis.com/reservation-name"
+
+// Host maintenance behaviors of the Google Cloud.
+const (
+	onHostMaintenanceMigrate   = "MIGRATE"
+	onHostMaintenanceTerminate = "TERMINATE"
+)
+
+// gpuMachineTypeFamilies are the accelerator optimized machine type families.
+// Their instances have GPUs attached, which can not be live migrated.
+var gpuMachineTypeFamilies = map[string]bool{
+	"a2": true,
+	"a3": true,
+	"g2": true,
+}
+
+// iamScope allows to look up the service accounts instances run as.
+const iamScope = "https://www.googleapis.com/auth/iam"
+
 // Default values for disk type and size (in GB).
 const (
 	defaultDiskType = "pd-standard"
@@ -80,6 +114,40 @@ type CloudProviderSpec struct {
 	AssignPublicIPAddress *providerconfig.ConfigVarBool  `json:"assignPublicIPAddress"`
 	MultiZone             providerconfig.ConfigVarBool   `json:"multizone"`
 	Regional              providerconfig.ConfigVarBool   `json:"regional"`
+	ReservationAffinity   *ReservationAffinity           `json:"reservationAffinity,omitempty"`
+	// OnHostMaintenance is either 'MIGRATE' to live migrate the instance during host maintenance
+	// or 'TERMINATE' to stop it. Defaults to 'TERMINATE' for preemptible instances and instances
+	// with GPUs, which can not be live migrated, and to 'MIGRATE' otherwise.
+	OnHostMaintenance providerconfig.ConfigVarString `json:"onHostMaintenance"`
+	// AutomaticRestart restarts the instance after it got terminated by the Google Cloud, e.g.
+	// because of a host maintenance. Defaults to false for preemptible instances and to true otherwise.
+	AutomaticRestart *providerconfig.ConfigVarBool `json:"automaticRestart"`
+
+	// AdditionalNetworkInterfaces get attached to the instance in addition to the primary one.
+	// Each network interface must be in a different network.
+	AdditionalNetworkInterfaces []NetworkInterface `json:"additionalNetworkInterfaces,omitempty"`
+	// CanIPForward allows the instance to send and receive packets with non-matching source or destination IPs.
+	CanIPForward providerconfig.ConfigVarBool `json:"canIPForward"`
+	// BootVolumeSnapshotID is the name of a snapshot in the project the boot disk gets created from instead of the
+	// image of the operating system. The snapshot must not be larger than the diskSize
+	BootVolumeSnapshotID providerconfig.ConfigVarString `json:"bootVolumeSnapshotID,omitempty"`
+	// InstanceServiceAccount is the email of the service account the instance runs as. Defaults to the
+	// service account of the serviceAccount credentials.
+	InstanceServiceAccount providerconfig.ConfigVarString `json:"instanceServiceAccount,omitempty"`
+}
+
+// NetworkInterface is an additional network interface of an instance.
+type NetworkInterface struct {
+	Network    providerconfig.ConfigVarString `json:"network"`
+	Subnetwork providerconfig.ConfigVarString `json:"subnetwork"`
+}
+
+// ReservationAffinity selects the reservations an instance consumes.
+type ReservationAffinity struct {
+	// Type is one of 'any', 'specific' or 'none'.
+	Type providerconfig.ConfigVarString `json:"type"`
+	// Name of the reservation, required for the type 'specific'.
+	Name providerconfig.ConfigVarString `json:"name"`
 }
 
 // newCloudProviderSpec creates a cloud provider specification out of the
@@ -134,6 +202,7 @@ type config struct {
 	machineType           string
 	diskSize              int64
 	diskType              string
+	bootVolumeSnapshotID  string
 	network               string
 	subnetwork            string
 	preemptible           bool
@@ -144,6 +213,20 @@ type config struct {
 	assignPublicIPAddress bool
 	multizone             bool
 	regional              bool
+	reservationType       string
+	reservationName       string
+	onHostMaintenance     string
+	automaticRestart      *bool
+	additionalInterfaces  []networkInterface
+	canIPForward          bool
+	// instanceServiceAccount is the email of the service account the instance runs as
+	instanceServiceAccount string
+}
+
+// networkInterface is an additional network interface of an instance.
+type networkInterface struct {
+	network    string
+	subnetwork string
 }
 
 // newConfig creates a Provider configuration out of the passed resolver and spec.
@@ -187,6 +270,11 @@ func newConfig(resolver *providerconfig.ConfigVarResolver, spec v1alpha1.Provide
 		return nil, fmt.Errorf("cannot retrieve disk type: %v", err)
 	}
 
+	cfg.bootVolumeSnapshotID, err = resolver.GetConfigVarStringValue(cpSpec.BootVolumeSnapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve boot volume snapshot: %v", err)
+	}
+
 	cfg.network, err = resolver.GetConfigVarStringValue(cpSpec.Network)
 	if err != nil {
 		return nil, fmt.Errorf("cannot retrieve network: %v", err)
@@ -222,6 +310,57 @@ func newConfig(resolver *providerconfig.ConfigVarResolver, spec v1alpha1.Provide
 		return nil, fmt.Errorf("failed to retrieve regional: %v", err)
 	}
 
+	if cpSpec.ReservationAffinity != nil {
+		cfg.reservationType, err = resolver.GetConfigVarStringValue(cpSpec.ReservationAffinity.Type)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve reservation affinity type: %v", err)
+		}
+
+		cfg.reservationName, err = resolver.GetConfigVarStringValue(cpSpec.ReservationAffinity.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve reservation name: %v", err)
+		}
+	}
+
+	cfg.onHostMaintenance, err = resolver.GetConfigVarStringValue(cpSpec.OnHostMaintenance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve onHostMaintenance: %v", err)
+	}
+
+	if cpSpec.AutomaticRestart != nil {
+		automaticRestart, err := resolver.GetConfigVarBoolValue(*cpSpec.AutomaticRestart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve automaticRestart: %v", err)
+		}
+		cfg.automaticRestart = &automaticRestart
+	}
+
+	for _, rawInterface := range cpSpec.AdditionalNetworkInterfaces {
+		ifc := networkInterface{}
+		ifc.network, err = resolver.GetConfigVarStringValue(rawInterface.Network)
+		if err != nil {
+			return nil, fmt.Errorf("cannot retrieve network of additional network interface: %v", err)
+		}
+		ifc.subnetwork, err = resolver.GetConfigVarStringValue(rawInterface.Subnetwork)
+		if err != nil {
+			return nil, fmt.Errorf("cannot retrieve subnetwork of additional network interface: %v", err)
+		}
+		cfg.additionalInterfaces = append(cfg.additionalInterfaces, ifc)
+	}
+
+	cfg.canIPForward, err = resolver.GetConfigVarBoolValue(cpSpec.CanIPForward)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve canIPForward: %v", err)
+	}
+
+	cfg.instanceServiceAccount, err = resolver.GetConfigVarStringValue(cpSpec.InstanceServiceAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve instanceServiceAccount: %v", err)
+	}
+	if cfg.instanceServiceAccount == "" {
+		cfg.instanceServiceAccount = cfg.jwtConfig.Email
+	}
+
 	return cfg, nil
 }
 
@@ -238,13 +377,28 @@ func (cfg *config) postprocessServiceAccount() error {
 		return fmt.Errorf("failed unmarshalling service account: %v", err)
 	}
 	cfg.projectID = sam["project_id"]
-	cfg.jwtConfig, err = google.JWTConfigFromJSON(sa, compute.ComputeScope)
+	cfg.jwtConfig, err = google.JWTConfigFromJSON(sa, compute.ComputeScope, iamScope)
 	if err != nil {
 		return fmt.Errorf("failed preparing JWT: %v", err)
 	}
 	return nil
 }
 
+// instanceServiceAccounts returns the service accounts the instance runs as.
+func (cfg *config) instanceServiceAccounts() []*compute.ServiceAccount {
+	return []*compute.ServiceAccount{
+		{
+			Email: cfg.instanceServiceAccount,
+			Scopes: append(
+				monitoring.DefaultAuthScopes(),
+				compute.ComputeScope,
+				compute.DevstorageReadOnlyScope,
+				logging.WriteScope,
+			),
+		},
+	}
+}
+
 // machineTypeDescriptor creates the descriptor out of zone and machine type
 // for the machine type of an instance.
 func (cfg *config) machineTypeDescriptor() string {
@@ -270,3 +424,158 @@ func (cfg *config) sourceImageDescriptor() (string, error) {
 	}
 	return fmt.Sprintf("projects/%s/global/images/family/%s", project, family), nil
 }
+
+// sourceSnapshotDescriptor creates the descriptor out of the snapshot name
+// for the source snapshot of an instance boot disk.
+func (cfg *config) sourceSnapshotDescriptor() string {
+	return fmt.Sprintf("global/snapshots/%s", cfg.bootVolumeSnapshotID)
+}
+
+// reservationAffinity creates the reservation affinity of an instance. It
+// returns nil if no reservation affinity is configured.
+func (cfg *config) reservationAffinity() *reservationAffinity {
+	if cfg.reservationType == "" {
+		return nil
+	}
+	affinity := &reservationAffinity{
+		ConsumeReservationType: reservationTypes[cfg.reservationType],
+	}
+	if cfg.reservationType == "specific" {
+		affinity.Key = reservationNameKey
+		affinity.Values = []string{cfg.reservationName}
+	}
+	return affinity
+}
+
+// hasGPUs returns true if the machine type has GPUs attached.
+func (cfg *config) hasGPUs() bool {
+	return gpuMachineTypeFamilies[strings.Split(cfg.machineType, "-")[0]]
+}
+
+// validateScheduling checks the host maintenance behavior against the
+// instances which can not be live migrated or restarted.
+func (cfg *config) validateScheduling() error {
+	switch cfg.onHostMaintenance {
+	case "", onHostMaintenanceMigrate, onHostMaintenanceTerminate:
+	default:
+		return fmt.Errorf("onHostMaintenance %q is invalid, allowed are '%s' and '%s'", cfg.onHostMaintenance, onHostMaintenanceMigrate, onHostMaintenanceTerminate)
+	}
+	if cfg.preemptible && cfg.automaticRestart != nil && *cfg.automaticRestart {
+		return fmt.Errorf("preemptible instances can not be restarted automatically")
+	}
+	if cfg.onHostMaintenance != onHostMaintenanceMigrate {
+		return nil
+	}
+	if cfg.preemptible {
+		return fmt.Errorf("preemptible instances can not live migrate, onHostMaintenance must be '%s'", onHostMaintenanceTerminate)
+	}
+	if cfg.hasGPUs() {
+		return fmt.Errorf("instances of machine type %s have GPUs and can not live migrate, onHostMaintenance must be '%s'", cfg.machineType, onHostMaintenanceTerminate)
+	}
+	return nil
+}
+
+// scheduling creates the scheduling options of an instance. Unset options
+// are left to the defaults of the Google Cloud, except for instances with
+// GPUs, which get terminated during host maintenance.
+func (cfg *config) scheduling() *compute.Scheduling {
+	scheduling := &compute.Scheduling{
+		Preemptible:       cfg.preemptible,
+		OnHostMaintenance: cfg.onHostMaintenance,
+		AutomaticRestart:  cfg.automaticRestart,
+	}
+	if scheduling.OnHostMaintenance == "" && cfg.hasGPUs() {
+		scheduling.OnHostMaintenance = onHostMaintenanceTerminate
+	}
+	return scheduling
+}
+
+// maxNetworkInterfaces returns the number of network interfaces the machine type supports,
+// which is one per vCPU with at least 2 and at most 8. false gets returned if the number
+// of vCPUs can not be derived from the machine type.
+// https://cloud.google.com/vpc/docs/multiple-interfaces-concepts#max-interfaces
+func (cfg *config) maxNetworkInterfaces() (int, bool) {
+	parts := strings.Split(cfg.machineType, "-")
+	// Shared core machine types like f1-micro or e2-small
+	if len(parts) == 2 {
+		return 2, true
+	}
+	// Custom machine types are named custom-CPUS-MEMORY or FAMILY-custom-CPUS-MEMORY
+	if len(parts) >= 3 && parts[len(parts)-3] == "custom" {
+		parts = parts[:len(parts)-1]
+	}
+	cpus, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case cpus < 2:
+		return 2, true
+	case cpus > 8:
+		return 8, true
+	}
+	return cpus, true
+}
+
+// sharedCoreMachineTypes contains the vCPUs and memory in MiB of the shared core machine types
+var sharedCoreMachineTypes = map[string][2]int64{
+	"f1-micro":  {1, 614},
+	"g1-small":  {1, 1741},
+	"e2-micro":  {2, 1024},
+	"e2-small":  {2, 2048},
+	"e2-medium": {2, 4096},
+}
+
+// memoryPerCPU contains the memory in MiB per vCPU of the predefined machine types
+var memoryPerCPU = map[string]float64{
+	"n1-standard": 3840,
+	"n1-highmem":  6656,
+	"n1-highcpu":  921.6,
+	"n2-standard": 4096,
+	"n2-highmem":  8192,
+	"n2-highcpu":  1024,
+	"e2-standard": 4096,
+	"e2-highmem":  8192,
+	"e2-highcpu":  1024,
+}
+
+// machineCapacity returns the resources of the machine type or nil if they can not be
+// derived from its name.
+// https://cloud.google.com/compute/docs/machine-types
+func (cfg *config) machineCapacity() *cloudprovidertypes.MachineCapacity {
+	newCapacity := func(cpus int64, memoryMB float64) *cloudprovidertypes.MachineCapacity {
+		return &cloudprovidertypes.MachineCapacity{
+			CPU:    *resource.NewQuantity(cpus, resource.DecimalSI),
+			Memory: *resource.NewQuantity(int64(memoryMB*1024*1024), resource.BinarySI),
+		}
+	}
+
+	if mt, ok := sharedCoreMachineTypes[cfg.machineType]; ok {
+		return newCapacity(mt[0], float64(mt[1]))
+	}
+	parts := strings.Split(cfg.machineType, "-")
+	// Custom machine types are named custom-CPUS-MEMORY or FAMILY-custom-CPUS-MEMORY
+	if len(parts) >= 3 && parts[len(parts)-3] == "custom" {
+		cpus, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+		if err != nil {
+			return nil
+		}
+		memory, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		return newCapacity(cpus, float64(memory))
+	}
+	if len(parts) != 3 {
+		return nil
+	}
+	perCPU, ok := memoryPerCPU[parts[0]+"-"+parts[1]]
+	if !ok {
+		return nil
+	}
+	cpus, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return newCapacity(cpus, float64(cpus)*perCPU)
+}
@@ -26,6 +26,8 @@ import (
 	"google.golang.org/api/compute/v1"
 
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 // Possible instance statuses.
@@ -56,14 +58,25 @@ func (gi *googleInstance) ID() string {
 }
 
 // Addresses implements instance.Instance.
-func (gi *googleInstance) Addresses() []string {
-	var addrs []string
+func (gi *googleInstance) Addresses() map[string]corev1.NodeAddressType {
+	addrs := map[string]corev1.NodeAddressType{}
 	for _, ifc := range gi.ci.NetworkInterfaces {
-		addrs = append(addrs, ifc.NetworkIP)
+		addrs[ifc.NetworkIP] = corev1.NodeInternalIP
+		for _, ac := range ifc.AccessConfigs {
+			if ac.NatIP != "" {
+				addrs[ac.NatIP] = corev1.NodeExternalIP
+			}
+		}
 	}
 	return addrs
 }
 
+// Tags implements instance.Tagger. Network tags have no values, so the labels of the
+// instance are used.
+func (gi *googleInstance) Tags() map[string]string {
+	return gi.ci.Labels
+}
+
 // Status implements instance.Instance.
 // TODO Check status mapping for staging, delet(ed|ing), suspend(ed|ing).
 func (gi *googleInstance) Status() instance.Status {
@@ -25,17 +25,18 @@ import (
 	"net/http"
 	"strconv"
 
-	"cloud.google.com/go/logging"
-	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"github.com/golang/glog"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/identity"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 )
 
@@ -49,6 +50,14 @@ const (
 	errInvalidMachineType    = "Machine type is missing"
 	errInvalidDiskSize       = "Disk size must be a positive number"
 	errInvalidDiskType       = "Disk type is missing or has wrong type, allowed are 'pd-standard' and 'pd-ssd'"
+	errInvalidReservation    = "Reservation affinity type %q is invalid, allowed are 'any', 'specific' and 'none'"
+	errMissingReservation    = "Reservation name is missing, it is required for the reservation affinity type 'specific'"
+	errInvalidInterface      = "Network or subnetwork of additional network interface %d is missing"
+	errTooManyInterfaces     = "Machine type %s supports at most %d network interfaces, got %d"
+	errInvalidScheduling     = "Invalid scheduling: %v"
+	errMachineTypeZone       = "Invalid zone or machine type: %v"
+	errBootVolumeSnapshot    = "Invalid boot volume snapshot: %v"
+	errInstanceIdentity      = "Invalid instance service account: %v"
 	errRetrieveInstance      = "Failed to retrieve instance: %v"
 	errGotTooManyInstances   = "Got more than 1 instance matching the machine UID label"
 	errCloudConfig           = "Failed to convert cloud-config to string: %v"
@@ -59,8 +68,9 @@ const (
 
 // Instance labels.
 const (
-	labelMachineName = "machine_name"
-	labelMachineUID  = "machine_uid"
+	labelMachineName         = "machine_name"
+	labelMachineUID          = "machine_uid"
+	labelMachineTemplateHash = "machine_template_hash"
 )
 
 // Compile time verification of Provider implementing cloud.Provider.
@@ -119,10 +129,40 @@ func (p *Provider) Validate(spec v1alpha1.MachineSpec) error {
 	if !diskTypes[cfg.diskType] {
 		return newError(common.InvalidConfigurationMachineError, errInvalidDiskType)
 	}
+	if _, ok := reservationTypes[cfg.reservationType]; cfg.reservationType != "" && !ok {
+		return newError(common.InvalidConfigurationMachineError, errInvalidReservation, cfg.reservationType)
+	}
+	if cfg.reservationType == "specific" && cfg.reservationName == "" {
+		return newError(common.InvalidConfigurationMachineError, errMissingReservation)
+	}
+	for i, ifc := range cfg.additionalInterfaces {
+		if ifc.network == "" && ifc.subnetwork == "" {
+			return newError(common.InvalidConfigurationMachineError, errInvalidInterface, i)
+		}
+	}
+	if max, ok := cfg.maxNetworkInterfaces(); ok && len(cfg.additionalInterfaces)+1 > max {
+		return newError(common.InvalidConfigurationMachineError, errTooManyInterfaces, cfg.machineType, max, len(cfg.additionalInterfaces)+1)
+	}
+	if err := cfg.validateScheduling(); err != nil {
+		return newError(common.InvalidConfigurationMachineError, errInvalidScheduling, err)
+	}
 	_, err = cfg.sourceImageDescriptor()
 	if err != nil {
 		return newError(common.InvalidConfigurationMachineError, errOperatingSystem, cfg.providerConfig.OperatingSystem, err)
 	}
+	svc, err := connectComputeService(cfg)
+	if err != nil {
+		return newError(common.InvalidConfigurationMachineError, errConnect, err)
+	}
+	if err := svc.validateMachineTypeInZone(cfg); err != nil {
+		return newError(common.InvalidConfigurationMachineError, errMachineTypeZone, err)
+	}
+	if err := svc.validateBootVolumeSnapshot(cfg); err != nil {
+		return newError(common.InvalidConfigurationMachineError, errBootVolumeSnapshot, err)
+	}
+	if err := svc.validateInstanceServiceAccount(cfg); err != nil {
+		return newError(common.InvalidConfigurationMachineError, errInstanceIdentity, err)
+	}
 	return nil
 }
 
@@ -199,6 +239,9 @@ func (p *Provider) Create(
 	if err != nil {
 		return nil, newError(common.InvalidConfigurationMachineError, errConnect, err)
 	}
+	if err := svc.validateInstanceServiceAccount(cfg); err != nil {
+		return nil, newError(common.InvalidConfigurationMachineError, errInstanceIdentity, err)
+	}
 	// Create Google compute instance spec and insert it.
 	networkInterfaces, err := svc.networkInterfaces(cfg)
 	if err != nil {
@@ -214,26 +257,18 @@ func (p *Provider) Create(
 	}
 	labels[labelMachineName] = machine.Spec.Name
 	labels[labelMachineUID] = string(machine.UID)
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		labels[labelMachineTemplateHash] = hash
+	}
 	inst := &compute.Instance{
 		Name:              machine.Spec.Name,
 		MachineType:       cfg.machineTypeDescriptor(),
 		NetworkInterfaces: networkInterfaces,
+		CanIpForward:      cfg.canIPForward,
 		Disks:             disks,
 		Labels:            labels,
-		Scheduling: &compute.Scheduling{
-			Preemptible: cfg.preemptible,
-		},
-		ServiceAccounts: []*compute.ServiceAccount{
-			{
-				Email: cfg.jwtConfig.Email,
-				Scopes: append(
-					monitoring.DefaultAuthScopes(),
-					compute.ComputeScope,
-					compute.DevstorageReadOnlyScope,
-					logging.WriteScope,
-				),
-			},
-		},
+		Scheduling:        cfg.scheduling(),
+		ServiceAccounts:   cfg.instanceServiceAccounts(),
 		Metadata: &compute.Metadata{
 			Items: []*compute.MetadataItems{
 				{
@@ -246,7 +281,7 @@ func (p *Provider) Create(
 			Items: cfg.tags,
 		},
 	}
-	op, err := svc.Instances.Insert(cfg.projectID, cfg.zone, inst).Do()
+	op, err := svc.insertInstance(cfg, inst)
 	if err != nil {
 		return nil, newError(common.InvalidConfigurationMachineError, errInsertInstance, err)
 	}
@@ -254,6 +289,12 @@ func (p *Provider) Create(
 	if err != nil {
 		return nil, newError(common.InvalidConfigurationMachineError, errInsertInstance, err)
 	}
+	if data != nil && data.Updater != nil {
+		// Only a hint for users, the instance is usable without it
+		if err := identity.Record(data, machine, cfg.instanceServiceAccount); err != nil {
+			glog.Errorf("Failed to record the identity of machine %s: %v", machine.Name, err)
+		}
+	}
 	// Retrieve it to get a full qualified instance.
 	return p.Get(machine)
 }
@@ -290,6 +331,15 @@ func (p *Provider) Cleanup(
 	return false, nil
 }
 
+// InstanceType returns the machine type the provider config of the spec requests.
+func (p *Provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	cfg, err := newConfig(p.resolver, spec.ProviderSpec)
+	if err != nil {
+		return "", newError(common.InvalidConfigurationMachineError, errMachineSpec, err)
+	}
+	return cfg.machineType, nil
+}
+
 // MachineMetricsLabels returns labels used for the  Prometheus metrics about created machines.
 func (p *Provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	// Read configuration.
@@ -349,6 +399,15 @@ func (p *Provider) MigrateUID(machine *v1alpha1.Machine, newUID types.UID) error
 	return nil
 }
 
+// MachineCapacity returns the resources of the configured machine type.
+func (p *Provider) MachineCapacity(spec v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	cfg, err := newConfig(p.resolver, spec.ProviderSpec)
+	if err != nil {
+		return nil, newError(common.InvalidConfigurationMachineError, errMachineSpec, err)
+	}
+	return cfg.machineCapacity(), nil
+}
+
 // SetMetricsForMachines allows providers to provide provider-specific metrics.
 func (p *Provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"path"
+
+	"google.golang.org/api/compute/v1"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/resize"
+)
+
+// OnlyInstanceTypeChanged returns true if the new spec only changes the machine type of the old spec.
+func (p *Provider) OnlyInstanceTypeChanged(old, new v1alpha1.MachineSpec) (bool, error) {
+	return resize.OnlyCloudProviderSpecFieldChanged(old, new, "machineType")
+}
+
+// InstanceTypes returns the machine type of the instance of the machine and the requested one.
+func (p *Provider) InstanceTypes(machine *v1alpha1.Machine) (string, string, error) {
+	cfg, err := newConfig(p.resolver, machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", "", newError(common.InvalidConfigurationMachineError, errMachineSpec, err)
+	}
+	inst, err := p.Get(machine)
+	if err != nil {
+		return "", "", err
+	}
+	return path.Base(inst.(*googleInstance).ci.MachineType), cfg.machineType, nil
+}
+
+// Resize takes the next step to run the instance of the machine with the requested machine type. The
+// machine type can only be changed while the instance is stopped.
+func (p *Provider) Resize(machine *v1alpha1.Machine) (bool, error) {
+	cfg, err := newConfig(p.resolver, machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, newError(common.InvalidConfigurationMachineError, errMachineSpec, err)
+	}
+	svc, err := connectComputeService(cfg)
+	if err != nil {
+		return false, newError(common.InvalidConfigurationMachineError, errConnect, err)
+	}
+	inst, err := p.Get(machine)
+	if err != nil {
+		return false, err
+	}
+	ci := inst.(*googleInstance).ci
+
+	resized := path.Base(ci.MachineType) == cfg.machineType
+	switch ci.Status {
+	case statusInstanceRunning:
+		if resized {
+			return true, nil
+		}
+		if _, err := svc.Instances.Stop(cfg.projectID, cfg.zone, ci.Name).Do(); err != nil {
+			return false, fmt.Errorf("failed to stop instance: %v", err)
+		}
+	case statusInstanceStopped, statusInstanceTerminated:
+		if !resized {
+			req := &compute.InstancesSetMachineTypeRequest{MachineType: cfg.machineTypeDescriptor()}
+			op, err := svc.Instances.SetMachineType(cfg.projectID, cfg.zone, ci.Name, req).Do()
+			if err != nil {
+				return false, fmt.Errorf("failed to set machine type: %v", err)
+			}
+			if err := svc.waitZoneOperation(cfg, op.Name); err != nil {
+				return false, fmt.Errorf("failed to set machine type: %v", err)
+			}
+		}
+		if _, err := svc.Instances.Start(cfg.projectID, cfg.zone, ci.Name).Do(); err != nil {
+			return false, fmt.Errorf("failed to start instance: %v", err)
+		}
+	}
+	// The instance is starting or stopping
+	return false, nil
+}
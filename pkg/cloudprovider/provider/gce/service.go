@@ -21,11 +21,16 @@ limitations under the License.
 package gce
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"time"
 
 	"golang.org/x/oauth2"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
@@ -40,22 +45,111 @@ const (
 	statusDone = "DONE"
 )
 
+// Google compute snapshot status.
+const (
+	statusReady = "READY"
+)
+
 const (
 	defaultNetwork = "global/networks/default"
 )
 
+// iamBasePath is the endpoint of the IAM API.
+var iamBasePath = "https://iam.googleapis.com/v1/"
+
 // service wraps a GCE compute service for the extension with helper methods.
 type service struct {
 	*compute.Service
+	client *http.Client
+}
+
+// reservationAffinity is the reservation affinity of an instance. It is not
+// part of the vendored compute API yet.
+type reservationAffinity struct {
+	ConsumeReservationType string   `json:"consumeReservationType"`
+	Key                    string   `json:"key,omitempty"`
+	Values                 []string `json:"values,omitempty"`
 }
 
 // connectComputeService establishes a service connection to the Compute Engine.
 func connectComputeService(cfg *config) (*service, error) {
-	svc, err := compute.New(cfg.jwtConfig.Client(oauth2.NoContext))
+	client := cfg.jwtConfig.Client(oauth2.NoContext)
+	svc, err := compute.New(client)
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect to Google Cloud: %v", err)
 	}
-	return &service{svc}, nil
+	return &service{svc, client}, nil
+}
+
+// insertInstance inserts the instance into the configured zone. Instances with a
+// reservation affinity or a boot volume snapshot get inserted with a raw request, as
+// the vendored compute API supports neither reservation affinities nor source snapshots.
+func (svc *service) insertInstance(cfg *config, inst *compute.Instance) (*compute.Operation, error) {
+	affinity := cfg.reservationAffinity()
+	if affinity == nil && cfg.bootVolumeSnapshotID == "" {
+		return svc.Instances.Insert(cfg.projectID, cfg.zone, inst).Do()
+	}
+
+	rawInst, err := json.Marshal(inst)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(rawInst, &fields); err != nil {
+		return nil, err
+	}
+	if affinity != nil {
+		fields["reservationAffinity"] = affinity
+	}
+	if cfg.bootVolumeSnapshotID != "" {
+		if err := setBootDiskSourceSnapshot(fields, cfg.sourceSnapshotDescriptor()); err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := googleapi.ResolveRelative(svc.BasePath, fmt.Sprintf("%s/zones/%s/instances", url.PathEscape(cfg.projectID), url.PathEscape(cfg.zone)))
+	req, err := http.NewRequest(http.MethodPost, urls, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := svc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+	op := &compute.Operation{}
+	if err := json.NewDecoder(resp.Body).Decode(op); err != nil {
+		return nil, fmt.Errorf("failed to decode operation: %v", err)
+	}
+	return op, nil
+}
+
+// setBootDiskSourceSnapshot replaces the source image of the boot disk in the raw
+// instance with the given snapshot, a disk has either a source image or a source snapshot.
+func setBootDiskSourceSnapshot(fields map[string]interface{}, sourceSnapshot string) error {
+	disks, _ := fields["disks"].([]interface{})
+	for _, rawDisk := range disks {
+		disk, ok := rawDisk.(map[string]interface{})
+		if !ok || disk["boot"] != true {
+			continue
+		}
+		params, ok := disk["initializeParams"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("boot disk has no initialize params")
+		}
+		delete(params, "sourceImage")
+		params["sourceSnapshot"] = sourceSnapshot
+		return nil
+	}
+	return fmt.Errorf("instance has no boot disk")
 }
 
 // networkInterfaces returns the configured network interfaces for an instance creation.
@@ -78,7 +172,15 @@ func (svc *service) networkInterfaces(cfg *config) ([]*compute.NetworkInterface,
 		}}
 	}
 
-	return []*compute.NetworkInterface{ifc}, nil
+	ifcs := []*compute.NetworkInterface{ifc}
+	for _, additional := range cfg.additionalInterfaces {
+		ifcs = append(ifcs, &compute.NetworkInterface{
+			Network:    additional.network,
+			Subnetwork: additional.subnetwork,
+		})
+	}
+
+	return ifcs, nil
 }
 
 // attachedDisks returns the configured attached disks for an instance creation.
@@ -99,6 +201,71 @@ func (svc *service) attachedDisks(cfg *config) ([]*compute.AttachedDisk, error)
 	return []*compute.AttachedDisk{bootDisk}, nil
 }
 
+// validateMachineTypeInZone makes sure the machine type can be used in the configured zone. Looking up the
+// machine type in the zone also fails for zones which do not exist.
+func (svc *service) validateMachineTypeInZone(cfg *config) error {
+	_, err := svc.MachineTypes.Get(cfg.projectID, cfg.zone, cfg.machineType).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return fmt.Errorf("machine type %q is not available in zone %q", cfg.machineType, cfg.zone)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get machine type %q in zone %q: %v", cfg.machineType, cfg.zone, err)
+	}
+	return nil
+}
+
+// validateBootVolumeSnapshot makes sure the boot volume snapshot exists, is ready and fits into the boot disk.
+func (svc *service) validateBootVolumeSnapshot(cfg *config) error {
+	if cfg.bootVolumeSnapshotID == "" {
+		return nil
+	}
+	snapshot, err := svc.Snapshots.Get(cfg.projectID, cfg.bootVolumeSnapshotID).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return fmt.Errorf("snapshot %q not found in project %q", cfg.bootVolumeSnapshotID, cfg.projectID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot %q: %v", cfg.bootVolumeSnapshotID, err)
+	}
+	if snapshot.Status != statusReady {
+		return fmt.Errorf("snapshot %q is %s, disks can only be created from ready snapshots", cfg.bootVolumeSnapshotID, snapshot.Status)
+	}
+	if snapshot.DiskSizeGb > cfg.diskSize {
+		return fmt.Errorf("snapshot %q has a size of %dGB, the disk size of %dGB is too small for it", cfg.bootVolumeSnapshotID, snapshot.DiskSizeGb, cfg.diskSize)
+	}
+	return nil
+}
+
+// validateInstanceServiceAccount makes sure the service account the instance runs as exists and is enabled.
+// The service account is looked up with a raw request, as the IAM API is not vendored.
+func (svc *service) validateInstanceServiceAccount(cfg *config) error {
+	if cfg.instanceServiceAccount == "" {
+		return fmt.Errorf("no service account configured")
+	}
+	// The project "-" lets the IAM API infer the project from the email, so the service account can be in another project
+	urls := googleapi.ResolveRelative(iamBasePath, fmt.Sprintf("projects/-/serviceAccounts/%s", url.PathEscape(cfg.instanceServiceAccount)))
+	resp, err := svc.client.Get(urls)
+	if err != nil {
+		return fmt.Errorf("failed to get service account %q: %v", cfg.instanceServiceAccount, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("service account %q not found", cfg.instanceServiceAccount)
+	}
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return fmt.Errorf("failed to get service account %q: %v", cfg.instanceServiceAccount, err)
+	}
+	serviceAccount := struct {
+		Disabled bool `json:"disabled"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&serviceAccount); err != nil {
+		return fmt.Errorf("failed to decode service account %q: %v", cfg.instanceServiceAccount, err)
+	}
+	if serviceAccount.Disabled {
+		return fmt.Errorf("service account %q is disabled", cfg.instanceServiceAccount)
+	}
+	return nil
+}
+
 // waitZoneOperation waits for a GCE operation in a zone to be completed or timed out.
 func (svc *service) waitZoneOperation(cfg *config, opName string) error {
 	return svc.waitOperation(func() (*compute.Operation, error) {
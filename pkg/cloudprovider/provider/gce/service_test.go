@@ -0,0 +1,529 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Google Cloud Provider for the Machine Controller
+//
+// Unit Tests
+//
+
+package gce
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+func TestInsertInstanceReservationAffinity(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           *config
+		expectedAffinity *reservationAffinity
+	}{
+		{
+			name:   "no reservation affinity",
+			config: &config{projectID: "my-project", zone: "my-zone"},
+		},
+		{
+			name:             "any reservation",
+			config:           &config{projectID: "my-project", zone: "my-zone", reservationType: "any"},
+			expectedAffinity: &reservationAffinity{ConsumeReservationType: "ANY_RESERVATION"},
+		},
+		{
+			name:   "specific reservation",
+			config: &config{projectID: "my-project", zone: "my-zone", reservationType: "specific", reservationName: "my-reservation"},
+			expectedAffinity: &reservationAffinity{
+				ConsumeReservationType: "SPECIFIC_RESERVATION",
+				Key:                    reservationNameKey,
+				Values:                 []string{"my-reservation"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received struct {
+				Name                string               `json:"name"`
+				ReservationAffinity *reservationAffinity `json:"reservationAffinity"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/my-project/zones/my-zone/instances" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Errorf("failed to decode instance: %v", err)
+				}
+				w.Write([]byte(`{"name": "operation-1"}`)) // nolint: errcheck
+			}))
+			defer server.Close()
+
+			computeSvc, err := compute.New(server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			computeSvc.BasePath = server.URL + "/"
+			svc := &service{computeSvc, server.Client()}
+
+			op, err := svc.insertInstance(test.config, &compute.Instance{Name: "my-instance"})
+			if err != nil {
+				t.Fatalf("failed to insert instance: %v", err)
+			}
+			if op.Name != "operation-1" {
+				t.Errorf("expected operation operation-1, got %q", op.Name)
+			}
+			if received.Name != "my-instance" {
+				t.Errorf("expected instance my-instance, got %q", received.Name)
+			}
+			if diff := deep.Equal(received.ReservationAffinity, test.expectedAffinity); diff != nil {
+				t.Errorf("unexpected reservation affinity, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestNetworkInterfaces(t *testing.T) {
+	cfg := &config{
+		subnetwork:            "regions/my-region/subnetworks/my-subnetwork",
+		assignPublicIPAddress: true,
+		additionalInterfaces: []networkInterface{
+			{network: "global/networks/data", subnetwork: "regions/my-region/subnetworks/data"},
+			{network: "global/networks/storage"},
+		},
+	}
+	expected := []*compute.NetworkInterface{
+		{
+			Subnetwork:    "regions/my-region/subnetworks/my-subnetwork",
+			AccessConfigs: []*compute.AccessConfig{{Name: "External NAT", Type: "ONE_TO_ONE_NAT"}},
+		},
+		{Network: "global/networks/data", Subnetwork: "regions/my-region/subnetworks/data"},
+		{Network: "global/networks/storage"},
+	}
+
+	ifcs, err := (&service{}).networkInterfaces(cfg)
+	if err != nil {
+		t.Fatalf("failed to get network interfaces: %v", err)
+	}
+	if diff := deep.Equal(ifcs, expected); diff != nil {
+		t.Errorf("unexpected network interfaces, diff: %v", diff)
+	}
+}
+
+func TestMaxNetworkInterfaces(t *testing.T) {
+	tests := []struct {
+		machineType string
+		expected    int
+		known       bool
+	}{
+		{machineType: "f1-micro", expected: 2, known: true},
+		{machineType: "n1-standard-1", expected: 2, known: true},
+		{machineType: "n1-standard-4", expected: 4, known: true},
+		{machineType: "n1-highmem-96", expected: 8, known: true},
+		{machineType: "custom-6-8192", expected: 6, known: true},
+		{machineType: "n2-custom-4-4096", expected: 4, known: true},
+		{machineType: "g1-small", expected: 2, known: true},
+		{machineType: "n1-standard-x", known: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.machineType, func(t *testing.T) {
+			max, known := (&config{machineType: test.machineType}).maxNetworkInterfaces()
+			if known != test.known || max != test.expected {
+				t.Errorf("expected %d (known: %v), got %d (known: %v)", test.expected, test.known, max, known)
+			}
+		})
+	}
+}
+
+func TestMachineCapacity(t *testing.T) {
+	tests := []struct {
+		machineType    string
+		expectedCPU    string
+		expectedMemory string
+	}{
+		{machineType: "e2-medium", expectedCPU: "2", expectedMemory: "4Gi"},
+		{machineType: "n1-standard-4", expectedCPU: "4", expectedMemory: "15Gi"},
+		{machineType: "n2-highmem-8", expectedCPU: "8", expectedMemory: "64Gi"},
+		{machineType: "custom-6-20480", expectedCPU: "6", expectedMemory: "20Gi"},
+		{machineType: "n2-custom-2-3072", expectedCPU: "2", expectedMemory: "3Gi"},
+		{machineType: "m1-ultramem-40"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.machineType, func(t *testing.T) {
+			capacity := (&config{machineType: test.machineType}).machineCapacity()
+			if test.expectedCPU == "" {
+				if capacity != nil {
+					t.Fatalf("expected unknown capacity, got %v", capacity)
+				}
+				return
+			}
+			if capacity == nil {
+				t.Fatal("expected capacity, got nil")
+			}
+			if capacity.CPU.String() != test.expectedCPU || capacity.Memory.String() != test.expectedMemory {
+				t.Errorf("expected %s CPUs and %s memory, got %s CPUs and %s memory",
+					test.expectedCPU, test.expectedMemory, capacity.CPU.String(), capacity.Memory.String())
+			}
+		})
+	}
+}
+
+func TestScheduling(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name     string
+		config   *config
+		expected *compute.Scheduling
+	}{
+		{
+			name:     "defaults",
+			config:   &config{machineType: "n1-standard-2"},
+			expected: &compute.Scheduling{},
+		},
+		{
+			name:     "preemptible",
+			config:   &config{machineType: "n1-standard-2", preemptible: true},
+			expected: &compute.Scheduling{Preemptible: true},
+		},
+		{
+			name:     "live migration with automatic restart",
+			config:   &config{machineType: "n1-standard-2", onHostMaintenance: "MIGRATE", automaticRestart: &enabled},
+			expected: &compute.Scheduling{OnHostMaintenance: "MIGRATE", AutomaticRestart: &enabled},
+		},
+		{
+			name:     "terminate without automatic restart",
+			config:   &config{machineType: "n1-standard-2", onHostMaintenance: "TERMINATE", automaticRestart: &disabled},
+			expected: &compute.Scheduling{OnHostMaintenance: "TERMINATE", AutomaticRestart: &disabled},
+		},
+		{
+			name:     "GPUs get terminated by default",
+			config:   &config{machineType: "a2-highgpu-1g"},
+			expected: &compute.Scheduling{OnHostMaintenance: "TERMINATE"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := deep.Equal(test.config.scheduling(), test.expected); diff != nil {
+				t.Errorf("unexpected scheduling, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestValidateScheduling(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name    string
+		config  *config
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			config: &config{machineType: "n1-standard-2"},
+		},
+		{
+			name:   "live migration",
+			config: &config{machineType: "n1-standard-2", onHostMaintenance: "MIGRATE", automaticRestart: &enabled},
+		},
+		{
+			name:    "invalid host maintenance behavior",
+			config:  &config{machineType: "n1-standard-2", onHostMaintenance: "migrate"},
+			wantErr: true,
+		},
+		{
+			name:   "preemptible terminated without automatic restart",
+			config: &config{machineType: "n1-standard-2", preemptible: true, onHostMaintenance: "TERMINATE", automaticRestart: &disabled},
+		},
+		{
+			name:    "preemptible live migration",
+			config:  &config{machineType: "n1-standard-2", preemptible: true, onHostMaintenance: "MIGRATE"},
+			wantErr: true,
+		},
+		{
+			name:    "preemptible automatic restart",
+			config:  &config{machineType: "n1-standard-2", preemptible: true, automaticRestart: &enabled},
+			wantErr: true,
+		},
+		{
+			name:   "GPUs terminated",
+			config: &config{machineType: "a2-highgpu-1g", onHostMaintenance: "TERMINATE"},
+		},
+		{
+			name:    "GPUs live migration",
+			config:  &config{machineType: "g2-standard-4", onHostMaintenance: "MIGRATE"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.validateScheduling()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateMachineTypeInZone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/my-project/zones/europe-west3-a/machineTypes/n1-standard-2" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`)) // nolint: errcheck
+			return
+		}
+		w.Write([]byte(`{"name": "n1-standard-2", "zone": "europe-west3-a"}`)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	computeSvc, err := compute.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	computeSvc.BasePath = server.URL + "/"
+	svc := &service{computeSvc, server.Client()}
+
+	tests := []struct {
+		name          string
+		zone          string
+		machineType   string
+		expectedError bool
+	}{
+		{
+			name:        "machine type available in zone",
+			zone:        "europe-west3-a",
+			machineType: "n1-standard-2",
+		},
+		{
+			name:          "machine type not available in zone",
+			zone:          "europe-west3-b",
+			machineType:   "n1-standard-2",
+			expectedError: true,
+		},
+		{
+			name:          "unknown machine type",
+			zone:          "europe-west3-a",
+			machineType:   "n9-standard-2",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config{projectID: "my-project", zone: test.zone, machineType: test.machineType}
+			err := svc.validateMachineTypeInZone(cfg)
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestInsertInstanceFromBootVolumeSnapshot(t *testing.T) {
+	var received struct {
+		Disks []struct {
+			Boot             bool                   `json:"boot"`
+			InitializeParams map[string]interface{} `json:"initializeParams"`
+		} `json:"disks"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/my-project/zones/europe-west3-a/instances" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode instance: %v", err)
+		}
+		w.Write([]byte(`{"name": "operation-1"}`)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	computeSvc, err := compute.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	computeSvc.BasePath = server.URL + "/"
+	svc := &service{computeSvc, server.Client()}
+
+	cfg := &config{
+		projectID:            "my-project",
+		zone:                 "europe-west3-a",
+		diskSize:             25,
+		diskType:             "pd-ssd",
+		bootVolumeSnapshotID: "my-snapshot",
+		providerConfig:       &providerconfig.Config{OperatingSystem: providerconfig.OperatingSystemUbuntu},
+	}
+	disks, err := svc.attachedDisks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.insertInstance(cfg, &compute.Instance{Name: "my-instance", Disks: disks}); err != nil {
+		t.Fatalf("failed to insert instance: %v", err)
+	}
+
+	if len(received.Disks) != 1 || !received.Disks[0].Boot {
+		t.Fatalf("expected a single boot disk, got %+v", received.Disks)
+	}
+	expected := map[string]interface{}{
+		"diskSizeGb":     "25",
+		"diskType":       "zones/europe-west3-a/diskTypes/pd-ssd",
+		"sourceSnapshot": "global/snapshots/my-snapshot",
+	}
+	if diff := deep.Equal(received.Disks[0].InitializeParams, expected); diff != nil {
+		t.Errorf("expected the boot disk to be created from the snapshot, diff: %v", diff)
+	}
+}
+
+func TestValidateBootVolumeSnapshot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/my-project/global/snapshots/ready":
+			w.Write([]byte(`{"name": "ready", "status": "READY", "diskSizeGb": "20"}`)) // nolint: errcheck
+		case "/my-project/global/snapshots/creating":
+			w.Write([]byte(`{"name": "creating", "status": "CREATING", "diskSizeGb": "20"}`)) // nolint: errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`)) // nolint: errcheck
+		}
+	}))
+	defer server.Close()
+
+	computeSvc, err := compute.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	computeSvc.BasePath = server.URL + "/"
+	svc := &service{computeSvc, server.Client()}
+
+	tests := []struct {
+		name          string
+		snapshot      string
+		diskSize      int64
+		expectedError bool
+	}{
+		{
+			name:     "no snapshot",
+			diskSize: 10,
+		},
+		{
+			name:     "ready snapshot",
+			snapshot: "ready",
+			diskSize: 20,
+		},
+		{
+			name:          "snapshot larger than the disk",
+			snapshot:      "ready",
+			diskSize:      10,
+			expectedError: true,
+		},
+		{
+			name:          "snapshot not ready",
+			snapshot:      "creating",
+			diskSize:      25,
+			expectedError: true,
+		},
+		{
+			name:          "snapshot not found",
+			snapshot:      "missing",
+			diskSize:      25,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config{projectID: "my-project", bootVolumeSnapshotID: test.snapshot, diskSize: test.diskSize}
+			err := svc.validateBootVolumeSnapshot(cfg)
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestInstanceServiceAccounts(t *testing.T) {
+	accounts := (&config{instanceServiceAccount: "nodes@my-project.iam.gserviceaccount.com"}).instanceServiceAccounts()
+	if len(accounts) != 1 {
+		t.Fatalf("expected the instance to run as one service account, got %d", len(accounts))
+	}
+	if accounts[0].Email != "nodes@my-project.iam.gserviceaccount.com" {
+		t.Errorf("expected the instance to run as nodes@my-project.iam.gserviceaccount.com, got %q", accounts[0].Email)
+	}
+	if len(accounts[0].Scopes) == 0 {
+		t.Error("expected the service account to have scopes")
+	}
+}
+
+func TestValidateInstanceServiceAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/projects/-/serviceAccounts/nodes@my-project.iam.gserviceaccount.com":
+			w.Write([]byte(`{"email": "nodes@my-project.iam.gserviceaccount.com"}`)) // nolint: errcheck
+		case "/projects/-/serviceAccounts/disabled@my-project.iam.gserviceaccount.com":
+			w.Write([]byte(`{"email": "disabled@my-project.iam.gserviceaccount.com", "disabled": true}`)) // nolint: errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`)) // nolint: errcheck
+		}
+	}))
+	defer server.Close()
+
+	defaultIAMBasePath := iamBasePath
+	iamBasePath = server.URL + "/"
+	defer func() { iamBasePath = defaultIAMBasePath }()
+	svc := &service{nil, server.Client()}
+
+	tests := []struct {
+		name           string
+		serviceAccount string
+		expectedError  bool
+	}{
+		{
+			name:           "existing service account",
+			serviceAccount: "nodes@my-project.iam.gserviceaccount.com",
+		},
+		{
+			name:           "disabled service account",
+			serviceAccount: "disabled@my-project.iam.gserviceaccount.com",
+			expectedError:  true,
+		},
+		{
+			name:           "missing service account",
+			serviceAccount: "missing@my-project.iam.gserviceaccount.com",
+			expectedError:  true,
+		},
+		{
+			name:          "no service account",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := svc.validateInstanceServiceAccount(&config{instanceServiceAccount: test.serviceAccount})
+			if test.expectedError != (err != nil) {
+				t.Errorf("expected error: %v, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hetzner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
+)
+
+// The vendored hcloud-go has no support for firewalls yet, so the API gets called directly
+
+type hzFirewall struct {
+	ID        int                  `json:"id"`
+	Name      string               `json:"name"`
+	Rules     []hzFirewallRule     `json:"rules"`
+	AppliedTo []hzFirewallResource `json:"applied_to"`
+}
+
+type hzFirewallRule struct {
+	Direction      string   `json:"direction"`
+	Protocol       string   `json:"protocol"`
+	Port           string   `json:"port,omitempty"`
+	SourceIPs      []string `json:"source_ips,omitempty"`
+	DestinationIPs []string `json:"destination_ips,omitempty"`
+}
+
+type hzFirewallResource struct {
+	Type   string                    `json:"type"`
+	Server *hzFirewallResourceServer `json:"server,omitempty"`
+}
+
+type hzFirewallResourceServer struct {
+	ID int `json:"id"`
+}
+
+// firewallClient is the subset of the Hetzner API needed to manage firewalls
+type firewallClient interface {
+	// GetFirewallByName returns nil if the firewall does not exist
+	GetFirewallByName(ctx context.Context, name string) (*hzFirewall, error)
+	CreateFirewall(ctx context.Context, name string, rules []hzFirewallRule) (*hzFirewall, error)
+	ApplyFirewallToServer(ctx context.Context, firewallID, serverID int) error
+	DeleteFirewall(ctx context.Context, firewallID int) error
+}
+
+type apiFirewallClient struct {
+	client *hcloud.Client
+}
+
+func (c *apiFirewallClient) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := c.client.NewRequest(ctx, method, path, reqBody)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Do(req, v)
+	return err
+}
+
+func (c *apiFirewallClient) GetFirewallByName(ctx context.Context, name string) (*hzFirewall, error) {
+	var resp struct {
+		Firewalls []hzFirewall `json:"firewalls"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/firewalls?name="+url.QueryEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.Firewalls {
+		if resp.Firewalls[i].Name == name {
+			return &resp.Firewalls[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *apiFirewallClient) CreateFirewall(ctx context.Context, name string, rules []hzFirewallRule) (*hzFirewall, error) {
+	req := struct {
+		Name  string           `json:"name"`
+		Rules []hzFirewallRule `json:"rules"`
+	}{Name: name, Rules: rules}
+	var resp struct {
+		Firewall hzFirewall `json:"firewall"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/firewalls", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Firewall, nil
+}
+
+func (c *apiFirewallClient) ApplyFirewallToServer(ctx context.Context, firewallID, serverID int) error {
+	req := struct {
+		ApplyTo []hzFirewallResource `json:"apply_to"`
+	}{ApplyTo: []hzFirewallResource{serverResource(serverID)}}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/firewalls/%d/actions/apply_to_resources", firewallID), req, nil)
+}
+
+func (c *apiFirewallClient) DeleteFirewall(ctx context.Context, firewallID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/firewalls/%d", firewallID), nil, nil)
+}
+
+func serverResource(serverID int) hzFirewallResource {
+	return hzFirewallResource{Type: "server", Server: &hzFirewallResourceServer{ID: serverID}}
+}
+
+// firewallRules converts the configured rules to the ones of the Hetzner API
+func firewallRules(config *firewall.Config) []hzFirewallRule {
+	var rules []hzFirewallRule
+	for _, rule := range config.Rules {
+		hzRule := hzFirewallRule{
+			Direction: rule.Direction,
+			Protocol:  rule.Protocol,
+			Port:      rule.Port,
+		}
+		if rule.Direction == firewall.DirectionIn {
+			hzRule.SourceIPs = rule.CIDRs
+		} else {
+			hzRule.DestinationIPs = rule.CIDRs
+		}
+		rules = append(rules, hzRule)
+	}
+	return rules
+}
+
+// ensureFirewall creates the firewall unless it already exists and applies it to the server
+func ensureFirewall(ctx context.Context, client firewallClient, name string, config *firewall.Config, serverID int) error {
+	fw, err := client.GetFirewallByName(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get firewall %s: %v", name, err)
+	}
+	if fw == nil {
+		fw, err = client.CreateFirewall(ctx, name, firewallRules(config))
+		// Another machine of the MachineDeployment created it in the meantime
+		if hcloud.IsError(err, hcloud.ErrorCode("uniqueness_error")) {
+			fw, err = client.GetFirewallByName(ctx, name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create firewall %s: %v", name, err)
+		}
+		if fw == nil {
+			return fmt.Errorf("firewall %s got created but can not be found", name)
+		}
+	}
+
+	for _, resource := range fw.AppliedTo {
+		if resource.Server != nil && resource.Server.ID == serverID {
+			return nil
+		}
+	}
+	if err := client.ApplyFirewallToServer(ctx, fw.ID, serverID); err != nil {
+		return fmt.Errorf("failed to apply firewall %s to server %d: %v", name, serverID, err)
+	}
+	return nil
+}
+
+// deleteFirewallIfEmpty deletes the firewall once it is not applied to any server anymore. Hetzner removes
+// deleted servers from the firewall.
+func deleteFirewallIfEmpty(ctx context.Context, client firewallClient, name string) error {
+	fw, err := client.GetFirewallByName(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get firewall %s: %v", name, err)
+	}
+	if fw == nil || len(fw.AppliedTo) > 0 {
+		return nil
+	}
+
+	err = client.DeleteFirewall(ctx, fw.ID)
+	// Already deleted or got applied to another server in the meantime
+	if hcloud.IsError(err, hcloud.ErrorCodeNotFound) || hcloud.IsError(err, hcloud.ErrorCode("resource_in_use")) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete firewall %s: %v", name, err)
+	}
+	return nil
+}
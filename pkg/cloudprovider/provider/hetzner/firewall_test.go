@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hetzner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
+)
+
+// fakeFirewallClient keeps the firewalls in memory
+type fakeFirewallClient struct {
+	firewalls map[string]*hzFirewall
+	nextID    int
+}
+
+func (f *fakeFirewallClient) GetFirewallByName(_ context.Context, name string) (*hzFirewall, error) {
+	return f.firewalls[name], nil
+}
+
+func (f *fakeFirewallClient) CreateFirewall(_ context.Context, name string, rules []hzFirewallRule) (*hzFirewall, error) {
+	if _, exists := f.firewalls[name]; exists {
+		return nil, hcloud.Error{Code: hcloud.ErrorCode("uniqueness_error"), Message: "name is already used"}
+	}
+	f.nextID++
+	f.firewalls[name] = &hzFirewall{ID: f.nextID, Name: name, Rules: rules}
+	return f.firewalls[name], nil
+}
+
+func (f *fakeFirewallClient) ApplyFirewallToServer(_ context.Context, firewallID, serverID int) error {
+	for _, fw := range f.firewalls {
+		if fw.ID == firewallID {
+			fw.AppliedTo = append(fw.AppliedTo, serverResource(serverID))
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirewallClient) DeleteFirewall(_ context.Context, firewallID int) error {
+	for name, fw := range f.firewalls {
+		if fw.ID == firewallID {
+			delete(f.firewalls, name)
+		}
+	}
+	return nil
+}
+
+func TestManagedFirewall(t *testing.T) {
+	const firewallName = "machine-controller-kube-system-my-workers"
+	ctx := context.Background()
+	client := &fakeFirewallClient{firewalls: map[string]*hzFirewall{}}
+	config := &firewall.Config{Rules: []firewall.Rule{
+		{Direction: firewall.DirectionIn, Protocol: firewall.ProtocolTCP, Port: "22", CIDRs: []string{"10.0.0.0/8"}},
+		{Direction: firewall.DirectionOut, Protocol: firewall.ProtocolICMP, CIDRs: []string{"0.0.0.0/0"}},
+	}}
+
+	// Every server of the MachineDeployment ensures the firewall, applying it twice must not fail
+	for _, serverID := range []int{1, 2, 2} {
+		if err := ensureFirewall(ctx, client, firewallName, config, serverID); err != nil {
+			t.Fatalf("failed to ensure firewall: %v", err)
+		}
+	}
+	fw := client.firewalls[firewallName]
+	if fw == nil {
+		t.Fatalf("expected firewall %s to be created", firewallName)
+	}
+	expectedRules := []hzFirewallRule{
+		{Direction: "in", Protocol: "tcp", Port: "22", SourceIPs: []string{"10.0.0.0/8"}},
+		{Direction: "out", Protocol: "icmp", DestinationIPs: []string{"0.0.0.0/0"}},
+	}
+	if !reflect.DeepEqual(fw.Rules, expectedRules) {
+		t.Errorf("expected rules %+v, got %+v", expectedRules, fw.Rules)
+	}
+	if len(fw.AppliedTo) != 2 {
+		t.Errorf("expected the firewall to be applied to 2 servers, got %d", len(fw.AppliedTo))
+	}
+
+	if err := deleteFirewallIfEmpty(ctx, client, firewallName); err != nil {
+		t.Fatalf("failed to clean up firewall: %v", err)
+	}
+	if _, exists := client.firewalls[firewallName]; !exists {
+		t.Errorf("expected firewall with servers to be kept")
+	}
+
+	fw.AppliedTo = nil
+	if err := deleteFirewallIfEmpty(ctx, client, firewallName); err != nil {
+		t.Fatalf("failed to clean up firewall: %v", err)
+	}
+	if _, exists := client.firewalls[firewallName]; exists {
+		t.Errorf("expected empty firewall to be deleted")
+	}
+
+	// The firewall is gone already
+	if err := deleteFirewallIfEmpty(ctx, client, firewallName); err != nil {
+		t.Errorf("failed to clean up deleted firewall: %v", err)
+	}
+}
@@ -23,16 +23,20 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/hetznercloud/hcloud-go/hcloud"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/firewall"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/ssh"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
@@ -40,7 +44,8 @@ import (
 )
 
 const (
-	machineUIDLabelKey = "machine-uid"
+	machineUIDLabelKey          = "machine-uid"
+	machineTemplateHashLabelKey = "machine-template-hash"
 )
 
 type provider struct {
@@ -57,6 +62,10 @@ type RawConfig struct {
 	ServerType providerconfig.ConfigVarString `json:"serverType"`
 	Datacenter providerconfig.ConfigVarString `json:"datacenter"`
 	Location   providerconfig.ConfigVarString `json:"location"`
+
+	// Firewall gets created for the MachineDeployment and applied to all of its servers. It gets deleted
+	// once the last server is gone.
+	Firewall *firewall.Config `json:"firewall,omitempty"`
 }
 
 type Config struct {
@@ -64,6 +73,7 @@ type Config struct {
 	ServerType string
 	Datacenter string
 	Location   string
+	Firewall   *firewall.Config
 }
 
 func getNameForOS(os providerconfig.OperatingSystem) (string, error) {
@@ -112,6 +122,7 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 	if err != nil {
 		return nil, nil, err
 	}
+	c.Firewall = rawConfig.Firewall
 	return &c, &pconfig, err
 }
 
@@ -130,6 +141,10 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("invalid/not supported operating system specified %q: %v", pc.OperatingSystem, err)
 	}
 
+	if err := c.Firewall.Validate(); err != nil {
+		return fmt.Errorf("invalid firewall config: %v", err)
+	}
+
 	ctx := context.TODO()
 	client := getClient(c.Token)
 
@@ -176,6 +191,16 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		}
 	}
 
+	var firewallName string
+	if c.Firewall != nil {
+		if firewallName, err = firewall.Name(machine); err != nil {
+			return nil, cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: fmt.Sprintf("Failed to determine the firewall: %v", err),
+			}
+		}
+	}
+
 	serverCreateOpts := hcloud.ServerCreateOpts{
 		Name:     machine.Spec.Name,
 		UserData: userdata,
@@ -183,6 +208,9 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 			machineUIDLabelKey: string(machine.UID),
 		},
 	}
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		serverCreateOpts.Labels[machineTemplateHashLabelKey] = hash
+	}
 
 	if c.Datacenter != "" {
 		serverCreateOpts.Datacenter, _, err = client.Datacenter.Get(ctx, c.Datacenter)
@@ -242,6 +270,17 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		return nil, fmt.Errorf("failed to create server invalid status code returned. expected=%d got %d", http.StatusCreated, res.StatusCode)
 	}
 
+	if c.Firewall != nil {
+		// The server gets deleted again as the firewall would never get applied to an existing server
+		fwClient := &apiFirewallClient{client: client}
+		if fwErr := ensureFirewall(ctx, fwClient, firewallName, c.Firewall, serverCreateRes.Server.ID); fwErr != nil {
+			if _, err := client.Server.Delete(ctx, serverCreateRes.Server); err != nil {
+				return nil, fmt.Errorf("failed to delete server %d due to %v after failing to apply its firewall: %v", serverCreateRes.Server.ID, err, fwErr)
+			}
+			return nil, fwErr
+		}
+	}
+
 	return &hetznerServer{server: serverCreateRes.Server}, nil
 }
 
@@ -249,6 +288,9 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	instance, err := p.Get(machine)
 	if err != nil {
 		if err == cloudprovidererrors.ErrInstanceNotFound {
+			if err := p.cleanupFirewall(machine); err != nil {
+				return false, err
+			}
 			return true, nil
 		}
 		return false, err
@@ -275,10 +317,46 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.Mach
 	return false, nil
 }
 
+// cleanupFirewall deletes the firewall once the last server of the MachineDeployment is gone
+func (p *provider) cleanupFirewall(machine *v1alpha1.Machine) error {
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+	if c.Firewall == nil {
+		return nil
+	}
+	firewallName, err := firewall.Name(machine)
+	if err != nil {
+		// Creating the server would have failed already
+		return nil
+	}
+
+	fwClient := &apiFirewallClient{client: getClient(c.Token)}
+	return deleteFirewallIfEmpty(context.TODO(), fwClient, firewallName)
+}
+
 func (p *provider) AddDefaults(spec v1alpha1.MachineSpec) (v1alpha1.MachineSpec, error) {
 	return spec, nil
 }
 
+// ValidateCredentials checks the token by listing a single server
+func (p *provider) ValidateCredentials(spec v1alpha1.MachineSpec) error {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	opts := hcloud.ServerListOpts{ListOpts: hcloud.ListOpts{PerPage: 1}}
+	if _, _, err := getClient(c.Token).Server.List(context.TODO(), opts); err != nil {
+		return fmt.Errorf("failed to list servers: %v", err)
+	}
+	return nil
+}
+
 func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -351,6 +429,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 	return "", "", nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.ServerType, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -376,13 +463,18 @@ func (s *hetznerServer) ID() string {
 	return strconv.Itoa(s.server.ID)
 }
 
-func (s *hetznerServer) Addresses() []string {
-	var addresses []string
+func (s *hetznerServer) CreationTime() time.Time {
+	return s.server.Created
+}
+
+func (s *hetznerServer) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, fips := range s.server.PublicNet.FloatingIPs {
-		addresses = append(addresses, fips.IP.String())
+		addresses[fips.IP.String()] = corev1.NodeExternalIP
 	}
-
-	return append(addresses, s.server.PublicNet.IPv4.IP.String(), s.server.PublicNet.IPv6.IP.String())
+	addresses[s.server.PublicNet.IPv4.IP.String()] = corev1.NodeExternalIP
+	addresses[s.server.PublicNet.IPv6.IP.String()] = corev1.NodeExternalIP
+	return addresses
 }
 
 func (s *hetznerServer) Status() instance.Status {
@@ -424,3 +516,7 @@ func hzErrorToTerminalError(err error, msg string) error {
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
@@ -80,10 +80,10 @@ func (k *kubeVirtServer) ID() string {
 	return string(k.vmi.UID)
 }
 
-func (k *kubeVirtServer) Addresses() []string {
-	var addresses []string
+func (k *kubeVirtServer) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, kvInterface := range k.vmi.Status.Interfaces {
-		addresses = append(addresses, kvInterface.IP)
+		addresses[kvInterface.IP] = corev1.NodeInternalIP
 	}
 	return addresses
 }
@@ -375,3 +375,18 @@ func parseResources(cpus, memory string) (*corev1.ResourceList, error) {
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(spec v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	resources, err := parseResources(c.CPUs, c.Memory)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudprovidertypes.MachineCapacity{
+		CPU:    (*resources)[corev1.ResourceCPU],
+		Memory: (*resources)[corev1.ResourceMemory],
+	}, nil
+}
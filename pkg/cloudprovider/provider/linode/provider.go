@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -37,6 +38,7 @@ import (
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 
@@ -397,6 +399,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 	return "", "", nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.Type, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -421,12 +432,19 @@ func (d *linodeInstance) ID() string {
 	return strconv.Itoa(d.linode.ID)
 }
 
-func (d *linodeInstance) Addresses() []string {
-	var addresses []string
+// linodePrivateNetwork is the range private IPv4 addresses of Linodes get allocated from
+var _, linodePrivateNetwork, _ = net.ParseCIDR("192.168.128.0/17")
+
+func (d *linodeInstance) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, n := range d.linode.IPv4 {
-		addresses = append(addresses, n.String())
+		if linodePrivateNetwork.Contains(*n) {
+			addresses[n.String()] = corev1.NodeInternalIP
+		} else {
+			addresses[n.String()] = corev1.NodeExternalIP
+		}
 	}
-	addresses = append(addresses, d.linode.IPv6)
+	addresses[d.linode.IPv6] = corev1.NodeExternalIP
 	return addresses
 }
 
@@ -472,3 +490,7 @@ func linodeStatusAndErrToTerminalError(err error) error {
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Minimal client for the OCI core services API.
+// Requests are signed as described in https://docs.cloud.oracle.com/iaas/Content/API/Concepts/signingrequests.htm
+//
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	coreAPIVersion     = "20160918"
+	identityAPIVersion = "20160918"
+
+	instanceStateProvisioning = "PROVISIONING"
+	instanceStateRunning      = "RUNNING"
+	instanceStateStarting     = "STARTING"
+	instanceStateStopping     = "STOPPING"
+	instanceStateStopped      = "STOPPED"
+	instanceStateTerminating  = "TERMINATING"
+	instanceStateTerminated   = "TERMINATED"
+)
+
+// ociClient contains the subset of the OCI core services API used by the provider
+type ociClient interface {
+	LaunchInstance(ctx context.Context, details launchInstanceDetails) (*ociInstance, error)
+	ListInstances(ctx context.Context, compartmentID string) ([]ociInstance, error)
+	UpdateInstanceTags(ctx context.Context, id string, freeformTags map[string]string) error
+	TerminateInstance(ctx context.Context, id string) error
+	ListInstanceVnics(ctx context.Context, compartmentID, instanceID string) ([]ociVnic, error)
+	GetImage(ctx context.Context, id string) (*ociImage, error)
+	GetSubnet(ctx context.Context, id string) (*ociSubnet, error)
+	ListShapes(ctx context.Context, compartmentID, availabilityDomain string) ([]ociShape, error)
+	ListFaultDomains(ctx context.Context, compartmentID, availabilityDomain string) ([]ociFaultDomain, error)
+}
+
+type ociInstance struct {
+	ID                 string            `json:"id"`
+	DisplayName        string            `json:"displayName"`
+	CompartmentID      string            `json:"compartmentId"`
+	AvailabilityDomain string            `json:"availabilityDomain"`
+	FaultDomain        string            `json:"faultDomain"`
+	Shape              string            `json:"shape"`
+	LifecycleState     string            `json:"lifecycleState"`
+	FreeformTags       map[string]string `json:"freeformTags"`
+}
+
+type ociVnic struct {
+	ID        string `json:"id"`
+	PrivateIP string `json:"privateIp"`
+	PublicIP  string `json:"publicIp"`
+}
+
+type ociImage struct {
+	ID              string `json:"id"`
+	OperatingSystem string `json:"operatingSystem"`
+	LifecycleState  string `json:"lifecycleState"`
+}
+
+type ociSubnet struct {
+	ID                 string `json:"id"`
+	AvailabilityDomain string `json:"availabilityDomain"`
+}
+
+type ociShape struct {
+	Shape string `json:"shape"`
+}
+
+type ociFaultDomain struct {
+	Name               string `json:"name"`
+	AvailabilityDomain string `json:"availabilityDomain"`
+}
+
+type instanceSourceDetails struct {
+	SourceType          string `json:"sourceType"`
+	ImageID             string `json:"imageId"`
+	BootVolumeSizeInGBs int64  `json:"bootVolumeSizeInGBs,omitempty"`
+}
+
+type createVnicDetails struct {
+	SubnetID       string `json:"subnetId"`
+	AssignPublicIP bool   `json:"assignPublicIp"`
+}
+
+type launchInstanceDetails struct {
+	AvailabilityDomain string                `json:"availabilityDomain"`
+	FaultDomain        string                `json:"faultDomain,omitempty"`
+	CompartmentID      string                `json:"compartmentId"`
+	DisplayName        string                `json:"displayName"`
+	Shape              string                `json:"shape"`
+	SourceDetails      instanceSourceDetails `json:"sourceDetails"`
+	CreateVnicDetails  createVnicDetails     `json:"createVnicDetails"`
+	Metadata           map[string]string     `json:"metadata"`
+	FreeformTags       map[string]string     `json:"freeformTags"`
+}
+
+// serviceError is the error returned by the OCI API for non 2xx responses
+type serviceError struct {
+	StatusCode int
+	ErrCode    string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("oci api returned status %d (%s): %s", e.StatusCode, e.ErrCode, e.Message)
+}
+
+// Code returns the OCI error code, e.g. NotAuthenticated
+func (e *serviceError) Code() string {
+	return e.ErrCode
+}
+
+func isNotFound(err error) bool {
+	serr, ok := err.(*serviceError)
+	return ok && serr.StatusCode == http.StatusNotFound
+}
+
+type credentials struct {
+	TenancyID   string
+	UserID      string
+	Fingerprint string
+	PrivateKey  string
+	Region      string
+}
+
+type httpClient struct {
+	endpoint string
+	// fault domains are listed by the identity service, which has its own endpoint
+	identityEndpoint string
+	keyID            string
+	privateKey       *rsa.PrivateKey
+	client           *http.Client
+}
+
+func newHTTPClient(creds credentials) (ociClient, error) {
+	key, err := parsePrivateKey(creds.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &httpClient{
+		endpoint:         fmt.Sprintf("https://iaas.%s.oraclecloud.com/%s", creds.Region, coreAPIVersion),
+		identityEndpoint: fmt.Sprintf("https://identity.%s.oraclecloud.com/%s", creds.Region, identityAPIVersion),
+		keyID:            fmt.Sprintf("%s/%s/%s", creds.TenancyID, creds.UserID, creds.Fingerprint),
+		privateKey:       key,
+		client:           &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	rsaKey, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
+
+func (c *httpClient) LaunchInstance(ctx context.Context, details launchInstanceDetails) (*ociInstance, error) {
+	instance := &ociInstance{}
+	if err := c.do(ctx, http.MethodPost, "/instances", nil, details, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (c *httpClient) ListInstances(ctx context.Context, compartmentID string) ([]ociInstance, error) {
+	var instances []ociInstance
+	query := url.Values{"compartmentId": []string{compartmentID}}
+	if err := c.list(ctx, "/instances", query, func(page []byte) error {
+		var pageInstances []ociInstance
+		if err := json.Unmarshal(page, &pageInstances); err != nil {
+			return err
+		}
+		instances = append(instances, pageInstances...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (c *httpClient) UpdateInstanceTags(ctx context.Context, id string, freeformTags map[string]string) error {
+	body := map[string]interface{}{"freeformTags": freeformTags}
+	return c.do(ctx, http.MethodPut, "/instances/"+url.PathEscape(id), nil, body, nil)
+}
+
+func (c *httpClient) TerminateInstance(ctx context.Context, id string) error {
+	query := url.Values{"preserveBootVolume": []string{"false"}}
+	return c.do(ctx, http.MethodDelete, "/instances/"+url.PathEscape(id), query, nil, nil)
+}
+
+func (c *httpClient) ListInstanceVnics(ctx context.Context, compartmentID, instanceID string) ([]ociVnic, error) {
+	var attachments []struct {
+		VnicID         string `json:"vnicId"`
+		LifecycleState string `json:"lifecycleState"`
+	}
+	query := url.Values{"compartmentId": []string{compartmentID}, "instanceId": []string{instanceID}}
+	if err := c.do(ctx, http.MethodGet, "/vnicAttachments", query, nil, &attachments); err != nil {
+		return nil, err
+	}
+
+	var vnics []ociVnic
+	for _, attachment := range attachments {
+		if attachment.VnicID == "" || attachment.LifecycleState != "ATTACHED" {
+			continue
+		}
+		vnic := ociVnic{}
+		if err := c.do(ctx, http.MethodGet, "/vnics/"+url.PathEscape(attachment.VnicID), nil, nil, &vnic); err != nil {
+			return nil, err
+		}
+		vnics = append(vnics, vnic)
+	}
+	return vnics, nil
+}
+
+func (c *httpClient) GetImage(ctx context.Context, id string) (*ociImage, error) {
+	image := &ociImage{}
+	if err := c.do(ctx, http.MethodGet, "/images/"+url.PathEscape(id), nil, nil, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (c *httpClient) GetSubnet(ctx context.Context, id string) (*ociSubnet, error) {
+	subnet := &ociSubnet{}
+	if err := c.do(ctx, http.MethodGet, "/subnets/"+url.PathEscape(id), nil, nil, subnet); err != nil {
+		return nil, err
+	}
+	return subnet, nil
+}
+
+func (c *httpClient) ListShapes(ctx context.Context, compartmentID, availabilityDomain string) ([]ociShape, error) {
+	var shapes []ociShape
+	query := url.Values{"compartmentId": []string{compartmentID}, "availabilityDomain": []string{availabilityDomain}}
+	if err := c.list(ctx, "/shapes", query, func(page []byte) error {
+		var pageShapes []ociShape
+		if err := json.Unmarshal(page, &pageShapes); err != nil {
+			return err
+		}
+		shapes = append(shapes, pageShapes...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return shapes, nil
+}
+
+func (c *httpClient) ListFaultDomains(ctx context.Context, compartmentID, availabilityDomain string) ([]ociFaultDomain, error) {
+	identity := *c
+	identity.endpoint = c.identityEndpoint
+
+	var faultDomains []ociFaultDomain
+	query := url.Values{"compartmentId": []string{compartmentID}, "availabilityDomain": []string{availabilityDomain}}
+	if err := identity.do(ctx, http.MethodGet, "/faultDomains", query, nil, &faultDomains); err != nil {
+		return nil, err
+	}
+	return faultDomains, nil
+}
+
+// list calls the given list operation until all pages have been retrieved
+func (c *httpClient) list(ctx context.Context, path string, query url.Values, handlePage func([]byte) error) error {
+	for {
+		var page json.RawMessage
+		resp, err := c.request(ctx, http.MethodGet, path, query, nil, &page)
+		if err != nil {
+			return err
+		}
+		if err := handlePage(page); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+		next := resp.Header.Get("opc-next-page")
+		if next == "" {
+			return nil
+		}
+		query.Set("page", next)
+	}
+}
+
+func (c *httpClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	_, err := c.request(ctx, method, path, query, body, out)
+	return err
+}
+
+func (c *httpClient) request(ctx context.Context, method, path string, query url.Values, body, out interface{}) (*http.Response, error) {
+	target := c.endpoint + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.sign(req, payload); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		serr := &serviceError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(respBody, serr); err != nil {
+			serr.Message = string(respBody)
+		}
+		return nil, serr
+	}
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// sign adds the authorization header for the given request.
+// Requests with a body additionally have to sign the content headers.
+func (c *httpClient) sign(req *http.Request, payload []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"date", "(request-target)", "host"}
+	if req.Method == http.MethodPost || req.Method == http.MethodPut {
+		hash := sha256.Sum256(payload)
+		req.Header.Set("Content-Length", fmt.Sprintf("%d", len(payload)))
+		req.Header.Set("X-Content-Sha256", base64.StdEncoding.EncodeToString(hash[:]))
+		headers = append(headers, "content-length", "content-type", "x-content-sha256")
+	}
+
+	var signingString []string
+	for _, header := range headers {
+		switch header {
+		case "(request-target)":
+			signingString = append(signingString, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+		case "host":
+			signingString = append(signingString, fmt.Sprintf("host: %s", req.URL.Host))
+		default:
+			signingString = append(signingString, fmt.Sprintf("%s: %s", header, req.Header.Get(header)))
+		}
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(signingString, "\n")))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(`Signature version="1",keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		c.keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
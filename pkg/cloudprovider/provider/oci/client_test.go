@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var signatureRegexp = regexp.MustCompile(`^Signature version="1",keyId="([^"]+)",algorithm="rsa-sha256",headers="([^"]+)",signature="([^"]+)"$`)
+
+// verifySignature checks the authorization header of the request the same way the OCI API does
+func verifySignature(r *http.Request, key *rsa.PublicKey) error {
+	matches := signatureRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+	if matches == nil {
+		return fmt.Errorf("invalid authorization header %q", r.Header.Get("Authorization"))
+	}
+	if matches[1] != "tenancy/user/fingerprint" {
+		return fmt.Errorf("unexpected key id %q", matches[1])
+	}
+
+	var signingString []string
+	for _, header := range strings.Split(matches[2], " ") {
+		switch header {
+		case "(request-target)":
+			signingString = append(signingString, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.RequestURI()))
+		case "host":
+			signingString = append(signingString, fmt.Sprintf("host: %s", r.Host))
+		default:
+			signingString = append(signingString, fmt.Sprintf("%s: %s", header, r.Header.Get(header)))
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(matches[3])
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(strings.Join(signingString, "\n")))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+}
+
+func TestHTTPClientSignsRequests(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifySignature(r, &key.PublicKey); err != nil {
+			t.Errorf("invalid signature for %s %s: %v", r.Method, r.URL, err)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method == http.MethodPost {
+			hash := sha256.Sum256(body)
+			if r.Header.Get("X-Content-Sha256") != base64.StdEncoding.EncodeToString(hash[:]) {
+				t.Errorf("content hash does not match the body")
+			}
+		}
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+
+		switch {
+		case r.Method == http.MethodPost:
+			fmt.Fprint(w, `{"id": "ocid1.instance.oc1..new", "lifecycleState": "PROVISIONING"}`)
+		case r.URL.Query().Get("page") == "":
+			w.Header().Set("opc-next-page", "2")
+			fmt.Fprint(w, `[{"id": "one"}]`)
+		default:
+			fmt.Fprint(w, `[{"id": "two"}]`)
+		}
+	}))
+	defer server.Close()
+
+	client := &httpClient{
+		endpoint:   server.URL + "/" + coreAPIVersion,
+		keyID:      "tenancy/user/fingerprint",
+		privateKey: key,
+		client:     server.Client(),
+	}
+
+	ctx := context.Background()
+	if _, err := client.LaunchInstance(ctx, launchInstanceDetails{DisplayName: "node-1"}); err != nil {
+		t.Fatalf("failed to launch instance: %v", err)
+	}
+	instances, err := client.ListInstances(ctx, "compartment")
+	if err != nil {
+		t.Fatalf("failed to list instances: %v", err)
+	}
+	if len(instances) != 2 {
+		t.Errorf("expected instances of both pages, got %v", instances)
+	}
+
+	expected := []string{
+		"POST /20160918/instances",
+		"GET /20160918/instances?compartmentId=compartment",
+		"GET /20160918/instances?compartmentId=compartment&page=2",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests, expected %v, got %v", expected, requests)
+	}
+}
+
+func TestHTTPClientReturnsServiceErrors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code": "NotAuthorizedOrNotFound", "message": "image not found"}`)
+	}))
+	defer server.Close()
+
+	client := &httpClient{endpoint: server.URL, keyID: "tenancy/user/fingerprint", privateKey: key, client: server.Client()}
+	_, err = client.GetImage(context.Background(), "ocid1.image.oc1..missing")
+	if !isNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if code := err.(*serviceError).Code(); code != "NotAuthorizedOrNotFound" {
+		t.Errorf("expected error code %q, got %q", "NotAuthorizedOrNotFound", code)
+	}
+}
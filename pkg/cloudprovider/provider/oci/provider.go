@@ -0,0 +1,655 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/placementgroup"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// OCI does not allow periods in freeform tag keys
+	machineUIDTag          = "Machine-UID"
+	machineNameTag         = "Machine-Name"
+	clusterNameTag         = "Cluster-Name"
+	machineDeploymentTag   = "Machine-Deployment"
+	machineTemplateHashTag = "Machine-Template-Hash"
+	userDataMetaKey        = "user_data"
+
+	minBootVolumeSizeInGBs = 50
+	maxBootVolumeSizeInGBs = 32768
+)
+
+type provider struct {
+	configVarResolver *providerconfig.ConfigVarResolver
+	newClient         func(credentials) (ociClient, error)
+}
+
+// New returns a OCI provider
+func New(configVarResolver *providerconfig.ConfigVarResolver) cloudprovidertypes.Provider {
+	return &provider{configVarResolver: configVarResolver, newClient: newHTTPClient}
+}
+
+type RawConfig struct {
+	TenancyID   providerconfig.ConfigVarString `json:"tenancyID"`
+	UserID      providerconfig.ConfigVarString `json:"userID"`
+	Fingerprint providerconfig.ConfigVarString `json:"fingerprint"`
+	PrivateKey  providerconfig.ConfigVarString `json:"privateKey"`
+	Region      providerconfig.ConfigVarString `json:"region"`
+
+	CompartmentID       providerconfig.ConfigVarString `json:"compartmentID"`
+	AvailabilityDomain  providerconfig.ConfigVarString `json:"availabilityDomain"`
+	FaultDomain         providerconfig.ConfigVarString `json:"faultDomain"`
+	SpreadFaultDomains  providerconfig.ConfigVarBool   `json:"spreadFaultDomains"`
+	Shape               providerconfig.ConfigVarString `json:"shape"`
+	ImageID             providerconfig.ConfigVarString `json:"imageID"`
+	SubnetID            providerconfig.ConfigVarString `json:"subnetID"`
+	BootVolumeSizeInGBs *int64                         `json:"bootVolumeSizeInGBs,omitempty"`
+	AssignPublicIP      providerconfig.ConfigVarBool   `json:"assignPublicIP"`
+	ClusterName         providerconfig.ConfigVarString `json:"clusterName"`
+	Tags                map[string]string              `json:"tags"`
+}
+
+type Config struct {
+	Credentials credentials
+
+	CompartmentID       string
+	AvailabilityDomain  string
+	FaultDomain         string
+	SpreadFaultDomains  bool
+	Shape               string
+	ImageID             string
+	SubnetID            string
+	BootVolumeSizeInGBs int64
+	AssignPublicIP      bool
+	ClusterName         string
+	Tags                map[string]string
+}
+
+func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.Config, error) {
+	if s.Value == nil {
+		return nil, nil, fmt.Errorf("machine.spec.providerconfig.value is nil")
+	}
+	pconfig := providerconfig.Config{}
+	err := json.Unmarshal(s.Value.Raw, &pconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawConfig := RawConfig{}
+	if err = json.Unmarshal(pconfig.CloudProviderSpec.Raw, &rawConfig); err != nil {
+		return nil, nil, err
+	}
+
+	c := Config{}
+	c.Credentials.TenancyID, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.TenancyID, "OCI_TENANCY_ID")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"tenancyID\" field, error = %v", err)
+	}
+	c.Credentials.UserID, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.UserID, "OCI_USER_ID")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"userID\" field, error = %v", err)
+	}
+	c.Credentials.Fingerprint, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.Fingerprint, "OCI_FINGERPRINT")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"fingerprint\" field, error = %v", err)
+	}
+	c.Credentials.PrivateKey, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.PrivateKey, "OCI_PRIVATE_KEY")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"privateKey\" field, error = %v", err)
+	}
+	c.Credentials.Region, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.Region, "OCI_REGION")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the value of \"region\" field, error = %v", err)
+	}
+	c.CompartmentID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CompartmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.AvailabilityDomain, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.AvailabilityDomain)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.FaultDomain, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.FaultDomain)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SpreadFaultDomains, err = p.configVarResolver.GetConfigVarBoolValue(rawConfig.SpreadFaultDomains)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Shape, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.Shape)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.ImageID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ImageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SubnetID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.SubnetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rawConfig.BootVolumeSizeInGBs != nil {
+		c.BootVolumeSizeInGBs = *rawConfig.BootVolumeSizeInGBs
+	}
+	c.AssignPublicIP, err = p.configVarResolver.GetConfigVarBoolValue(rawConfig.AssignPublicIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.ClusterName, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ClusterName)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Tags = rawConfig.Tags
+	return &c, &pconfig, err
+}
+
+func (p *provider) getClient(c *Config) (ociClient, error) {
+	client, err := p.newClient(c.Credentials)
+	if err != nil {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to create OCI client, due to %v", err),
+		}
+	}
+	return client, nil
+}
+
+func validateOS(os providerconfig.OperatingSystem) error {
+	switch os {
+	case providerconfig.OperatingSystemUbuntu, providerconfig.OperatingSystemCentOS:
+		return nil
+	}
+	return providerconfig.ErrOSNotSupported
+}
+
+func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
+	c, pc, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if c.Credentials.TenancyID == "" {
+		return errors.New("tenancyID is missing")
+	}
+	if c.Credentials.UserID == "" {
+		return errors.New("userID is missing")
+	}
+	if c.Credentials.Fingerprint == "" {
+		return errors.New("fingerprint is missing")
+	}
+	if c.Credentials.PrivateKey == "" {
+		return errors.New("privateKey is missing")
+	}
+	if c.Credentials.Region == "" {
+		return errors.New("region is missing")
+	}
+	if c.CompartmentID == "" {
+		return errors.New("compartmentID is missing")
+	}
+	if c.AvailabilityDomain == "" {
+		return errors.New("availabilityDomain is missing")
+	}
+	if c.FaultDomain != "" && c.SpreadFaultDomains {
+		return errors.New("faultDomain and spreadFaultDomains are mutually exclusive")
+	}
+	if c.Shape == "" {
+		return errors.New("shape is missing")
+	}
+	if c.ImageID == "" {
+		return errors.New("imageID is missing")
+	}
+	if c.SubnetID == "" {
+		return errors.New("subnetID is missing")
+	}
+	if c.BootVolumeSizeInGBs != 0 && (c.BootVolumeSizeInGBs < minBootVolumeSizeInGBs || c.BootVolumeSizeInGBs > maxBootVolumeSizeInGBs) {
+		return fmt.Errorf("bootVolumeSizeInGBs must be between %d and %d", minBootVolumeSizeInGBs, maxBootVolumeSizeInGBs)
+	}
+	for _, key := range []string{machineUIDTag, machineNameTag, clusterNameTag, machineDeploymentTag, machineTemplateHashTag} {
+		if _, exists := c.Tags[key]; exists {
+			return fmt.Errorf("tag %q is reserved and can not be set", key)
+		}
+	}
+
+	if err := validateOS(pc.OperatingSystem); err != nil {
+		return fmt.Errorf("invalid/not supported operating system specified %q: %v", pc.OperatingSystem, err)
+	}
+
+	ctx := context.TODO()
+	client, err := p.getClient(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.GetImage(ctx, c.ImageID); err != nil {
+		return fmt.Errorf("failed to get image %q: %v", c.ImageID, err)
+	}
+
+	subnet, err := client.GetSubnet(ctx, c.SubnetID)
+	if err != nil {
+		return fmt.Errorf("failed to get subnet %q: %v", c.SubnetID, err)
+	}
+	// Regional subnets don't have an availability domain
+	if subnet.AvailabilityDomain != "" && subnet.AvailabilityDomain != c.AvailabilityDomain {
+		return fmt.Errorf("subnet %q is in availability domain %q, not in %q", c.SubnetID, subnet.AvailabilityDomain, c.AvailabilityDomain)
+	}
+
+	if c.FaultDomain != "" || c.SpreadFaultDomains {
+		faultDomains, err := client.ListFaultDomains(ctx, c.CompartmentID, c.AvailabilityDomain)
+		if err != nil {
+			return fmt.Errorf("failed to list fault domains: %v", err)
+		}
+		if len(faultDomains) == 0 {
+			return fmt.Errorf("availability domain %q has no fault domains", c.AvailabilityDomain)
+		}
+		if c.FaultDomain != "" && !hasFaultDomain(faultDomains, c.FaultDomain) {
+			return fmt.Errorf("fault domain %q does not exist in availability domain %q", c.FaultDomain, c.AvailabilityDomain)
+		}
+	}
+
+	shapes, err := client.ListShapes(ctx, c.CompartmentID, c.AvailabilityDomain)
+	if err != nil {
+		return fmt.Errorf("failed to list shapes: %v", err)
+	}
+	for _, shape := range shapes {
+		if shape.Shape == c.Shape {
+			return nil
+		}
+	}
+	return fmt.Errorf("shape %q is not available in availability domain %q", c.Shape, c.AvailabilityDomain)
+}
+
+func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData, userdata string) (instance.Instance, error) {
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+
+	ctx := context.TODO()
+	client, err := p.getClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	faultDomain := c.FaultDomain
+	if c.SpreadFaultDomains {
+		if faultDomain, err = selectFaultDomain(ctx, client, c, machine); err != nil {
+			return nil, ociErrorToTerminalError(err, "failed to select fault domain")
+		}
+	}
+
+	details := launchInstanceDetails{
+		AvailabilityDomain: c.AvailabilityDomain,
+		FaultDomain:        faultDomain,
+		CompartmentID:      c.CompartmentID,
+		DisplayName:        machine.Spec.Name,
+		Shape:              c.Shape,
+		SourceDetails: instanceSourceDetails{
+			SourceType:          "image",
+			ImageID:             c.ImageID,
+			BootVolumeSizeInGBs: c.BootVolumeSizeInGBs,
+		},
+		CreateVnicDetails: createVnicDetails{
+			SubnetID:       c.SubnetID,
+			AssignPublicIP: c.AssignPublicIP,
+		},
+		Metadata: map[string]string{
+			userDataMetaKey: base64.StdEncoding.EncodeToString([]byte(userdata)),
+		},
+		FreeformTags: instanceTags(c, machine),
+	}
+
+	ociInstance, err := client.LaunchInstance(ctx, details)
+	if err != nil {
+		return nil, ociErrorToTerminalError(err, "failed to launch instance")
+	}
+
+	return &ociServer{instance: ociInstance}, nil
+}
+
+func instanceTags(c *Config, machine *v1alpha1.Machine) map[string]string {
+	tags := map[string]string{}
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+	tags[machineUIDTag] = string(machine.UID)
+	tags[machineNameTag] = machine.Spec.Name
+	if c.ClusterName != "" {
+		tags[clusterNameTag] = c.ClusterName
+	}
+	if deployment, err := placementgroup.Name(machine); err == nil {
+		tags[machineDeploymentTag] = deployment
+	}
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		tags[machineTemplateHashTag] = hash
+	}
+	return tags
+}
+
+func hasFaultDomain(faultDomains []ociFaultDomain, name string) bool {
+	for _, faultDomain := range faultDomains {
+		if faultDomain.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFaultDomain returns the fault domain of the availability domain which has the fewest instances of the
+// MachineDeployment the machine belongs to. Machines which don't belong to a MachineDeployment get no fault domain,
+// so OCI picks one
+func selectFaultDomain(ctx context.Context, client ociClient, c *Config, machine *v1alpha1.Machine) (string, error) {
+	deployment, err := placementgroup.Name(machine)
+	if err != nil {
+		glog.V(4).Infof("Not spreading machine %s across fault domains: %v", machine.Name, err)
+		return "", nil
+	}
+
+	faultDomains, err := client.ListFaultDomains(ctx, c.CompartmentID, c.AvailabilityDomain)
+	if err != nil {
+		return "", err
+	}
+	if len(faultDomains) == 0 {
+		return "", fmt.Errorf("availability domain %q has no fault domains", c.AvailabilityDomain)
+	}
+	instances, err := client.ListInstances(ctx, c.CompartmentID)
+	if err != nil {
+		return "", err
+	}
+
+	usage := map[string]int{}
+	for _, inst := range instances {
+		if inst.LifecycleState == instanceStateTerminating || inst.LifecycleState == instanceStateTerminated {
+			continue
+		}
+		if inst.AvailabilityDomain == c.AvailabilityDomain && inst.FreeformTags[machineDeploymentTag] == deployment {
+			usage[inst.FaultDomain]++
+		}
+	}
+
+	names := make([]string, 0, len(faultDomains))
+	for _, faultDomain := range faultDomains {
+		names = append(names, faultDomain.Name)
+	}
+	sort.Strings(names)
+	selected := names[0]
+	for _, name := range names[1:] {
+		if usage[name] < usage[selected] {
+			selected = name
+		}
+	}
+	return selected, nil
+}
+
+func (p *provider) Cleanup(machine *v1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData) (bool, error) {
+	inst, err := p.Get(machine)
+	if err != nil {
+		if err == cloudprovidererrors.ErrInstanceNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+
+	server := inst.(*ociServer)
+	if server.instance.LifecycleState == instanceStateTerminating {
+		return false, nil
+	}
+
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+
+	client, err := p.getClient(c)
+	if err != nil {
+		return false, err
+	}
+
+	if err := client.TerminateInstance(context.TODO(), server.instance.ID); err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, ociErrorToTerminalError(err, "failed to terminate instance")
+	}
+	return false, nil
+}
+
+func (p *provider) AddDefaults(spec v1alpha1.MachineSpec) (v1alpha1.MachineSpec, error) {
+	return spec, nil
+}
+
+func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+
+	ctx := context.TODO()
+	client, err := p.getClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ociInstance, err := getInstance(ctx, client, c.CompartmentID, machine.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	vnics, err := client.ListInstanceVnics(ctx, c.CompartmentID, ociInstance.ID)
+	if err != nil {
+		return nil, ociErrorToTerminalError(err, "failed to list vnics")
+	}
+
+	return &ociServer{instance: ociInstance, vnics: vnics}, nil
+}
+
+// getInstance returns the instance which is tagged with the given machine UID.
+// Terminated instances stay visible for a while and are ignored.
+func getInstance(ctx context.Context, client ociClient, compartmentID string, uid types.UID) (*ociInstance, error) {
+	instances, err := client.ListInstances(ctx, compartmentID)
+	if err != nil {
+		return nil, ociErrorToTerminalError(err, "failed to list instances")
+	}
+
+	for i := range instances {
+		if instances[i].LifecycleState == instanceStateTerminated {
+			continue
+		}
+		if instances[i].FreeformTags[machineUIDTag] == string(uid) {
+			return &instances[i], nil
+		}
+	}
+
+	return nil, cloudprovidererrors.ErrInstanceNotFound
+}
+
+func (p *provider) MigrateUID(machine *v1alpha1.Machine, new types.UID) error {
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+		}
+	}
+
+	ctx := context.TODO()
+	client, err := p.getClient(c)
+	if err != nil {
+		return err
+	}
+
+	ociInstance, err := getInstance(ctx, client, c.CompartmentID, machine.UID)
+	if err != nil {
+		if err == cloudprovidererrors.ErrInstanceNotFound {
+			glog.Infof("No instance exists for machine %s", machine.Name)
+			return nil
+		}
+		return err
+	}
+
+	// Updating the freeform tags replaces all of them
+	tags := map[string]string{}
+	for k, v := range ociInstance.FreeformTags {
+		tags[k] = v
+	}
+	tags[machineUIDTag] = string(new)
+	if err := client.UpdateInstanceTags(ctx, ociInstance.ID, tags); err != nil {
+		return fmt.Errorf("failed to update instance with new machineUIDTag: %v", err)
+	}
+
+	return nil
+}
+
+func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, name string, err error) {
+	return "", "", nil
+}
+
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.Shape, nil
+}
+
+func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err == nil {
+		labels["size"] = c.Shape
+		labels["region"] = c.Credentials.Region
+		labels["availabilityDomain"] = c.AvailabilityDomain
+	}
+
+	return labels, err
+}
+
+func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
+	return nil
+}
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
+
+type ociServer struct {
+	instance *ociInstance
+	vnics    []ociVnic
+}
+
+func (s *ociServer) Name() string {
+	return s.instance.DisplayName
+}
+
+func (s *ociServer) ID() string {
+	return s.instance.ID
+}
+
+func (s *ociServer) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
+	for _, vnic := range s.vnics {
+		if vnic.PublicIP != "" {
+			addresses[vnic.PublicIP] = corev1.NodeExternalIP
+		}
+		if vnic.PrivateIP != "" {
+			addresses[vnic.PrivateIP] = corev1.NodeInternalIP
+		}
+	}
+	return addresses
+}
+
+func (s *ociServer) Status() instance.Status {
+	switch s.instance.LifecycleState {
+	case instanceStateProvisioning, instanceStateStarting:
+		return instance.StatusCreating
+	case instanceStateRunning:
+		return instance.StatusRunning
+	case instanceStateStopping, instanceStateTerminating:
+		return instance.StatusDeleting
+	case instanceStateTerminated:
+		return instance.StatusDeleted
+	default:
+		return instance.StatusUnknown
+	}
+}
+
+// ociErrorToTerminalError judges if the given error
+// can be qualified as a "terminal" error, for more info see v1alpha1.MachineStatus
+//
+// if the given error doesn't qualify the error passed as an argument will be returned
+func ociErrorToTerminalError(err error, msg string) error {
+	prepareAndReturnError := func() error {
+		return fmt.Errorf("%s, due to %s", msg, err)
+	}
+
+	if err != nil {
+		serr, ok := err.(*serviceError)
+		if !ok {
+			return prepareAndReturnError()
+		}
+		switch {
+		case serr.StatusCode == http.StatusUnauthorized:
+			// authorization primitives come from MachineSpec
+			// thus we are setting InvalidConfigurationMachineError
+			return cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: "A request has been rejected due to invalid credentials which were taken from the MachineSpec",
+			}
+		case serr.ErrCode == "LimitExceeded":
+			return cloudprovidererrors.TerminalError{
+				Reason:  common.InsufficientResourcesMachineError,
+				Message: "You've reached the OCI service limit for instances of this shape",
+			}
+		default:
+			return cloudprovidererrors.WithCode(prepareAndReturnError(), serr.Code())
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,557 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+type fakeClient struct {
+	instances    []ociInstance
+	vnics        map[string][]ociVnic
+	images       map[string]*ociImage
+	subnets      map[string]*ociSubnet
+	shapes       []ociShape
+	faultDomains []ociFaultDomain
+	launched     []launchInstanceDetails
+	terminated   []string
+	updatedTags  map[string]map[string]string
+	terminateErr error
+}
+
+func (f *fakeClient) LaunchInstance(_ context.Context, details launchInstanceDetails) (*ociInstance, error) {
+	f.launched = append(f.launched, details)
+	inst := ociInstance{
+		ID:                 "ocid1.instance.oc1..new",
+		DisplayName:        details.DisplayName,
+		AvailabilityDomain: details.AvailabilityDomain,
+		FaultDomain:        details.FaultDomain,
+		LifecycleState:     instanceStateProvisioning,
+		FreeformTags:       details.FreeformTags,
+	}
+	f.instances = append(f.instances, inst)
+	return &inst, nil
+}
+
+func (f *fakeClient) ListInstances(_ context.Context, _ string) ([]ociInstance, error) {
+	return f.instances, nil
+}
+
+func (f *fakeClient) UpdateInstanceTags(_ context.Context, id string, freeformTags map[string]string) error {
+	if f.updatedTags == nil {
+		f.updatedTags = map[string]map[string]string{}
+	}
+	f.updatedTags[id] = freeformTags
+	return nil
+}
+
+func (f *fakeClient) TerminateInstance(_ context.Context, id string) error {
+	if f.terminateErr != nil {
+		return f.terminateErr
+	}
+	f.terminated = append(f.terminated, id)
+	return nil
+}
+
+func (f *fakeClient) ListInstanceVnics(_ context.Context, _, instanceID string) ([]ociVnic, error) {
+	return f.vnics[instanceID], nil
+}
+
+func (f *fakeClient) GetImage(_ context.Context, id string) (*ociImage, error) {
+	if image, ok := f.images[id]; ok {
+		return image, nil
+	}
+	return nil, &serviceError{StatusCode: http.StatusNotFound, ErrCode: "NotAuthorizedOrNotFound"}
+}
+
+func (f *fakeClient) GetSubnet(_ context.Context, id string) (*ociSubnet, error) {
+	if subnet, ok := f.subnets[id]; ok {
+		return subnet, nil
+	}
+	return nil, &serviceError{StatusCode: http.StatusNotFound, ErrCode: "NotAuthorizedOrNotFound"}
+}
+
+func (f *fakeClient) ListShapes(_ context.Context, _, _ string) ([]ociShape, error) {
+	return f.shapes, nil
+}
+
+func (f *fakeClient) ListFaultDomains(_ context.Context, _, _ string) ([]ociFaultDomain, error) {
+	return f.faultDomains, nil
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		images:  map[string]*ociImage{"ocid1.image.oc1..ubuntu": {ID: "ocid1.image.oc1..ubuntu"}},
+		subnets: map[string]*ociSubnet{"ocid1.subnet.oc1..one": {ID: "ocid1.subnet.oc1..one"}},
+		shapes:  []ociShape{{Shape: "VM.Standard2.1"}, {Shape: "VM.Standard2.2"}},
+		faultDomains: []ociFaultDomain{
+			{Name: "FAULT-DOMAIN-1", AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1"},
+			{Name: "FAULT-DOMAIN-2", AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1"},
+			{Name: "FAULT-DOMAIN-3", AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1"},
+		},
+	}
+}
+
+func newTestProvider(client *fakeClient) *provider {
+	return &provider{
+		configVarResolver: providerconfig.NewConfigVarResolver(nil),
+		newClient: func(credentials) (ociClient, error) {
+			return client, nil
+		},
+	}
+}
+
+func testMachine(t *testing.T, uid types.UID, os providerconfig.OperatingSystem, modify func(map[string]interface{})) *v1alpha1.Machine {
+	spec := map[string]interface{}{
+		"tenancyID":          "ocid1.tenancy.oc1..tenancy",
+		"userID":             "ocid1.user.oc1..user",
+		"fingerprint":        "20:3b:97:13:55:1c:5b:0d:d3:37:d8:50:4e:c5:3a:34",
+		"privateKey":         "key",
+		"region":             "eu-frankfurt-1",
+		"compartmentID":      "ocid1.compartment.oc1..compartment",
+		"availabilityDomain": "Uocm:EU-FRANKFURT-1-AD-1",
+		"shape":              "VM.Standard2.1",
+		"imageID":            "ocid1.image.oc1..ubuntu",
+		"subnetID":           "ocid1.subnet.oc1..one",
+		"clusterName":        "my-cluster",
+	}
+	if modify != nil {
+		modify(spec)
+	}
+	rawSpec, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawConfig, err := json.Marshal(providerconfig.Config{
+		CloudProvider:     providerconfig.CloudProviderOCI,
+		CloudProviderSpec: runtime.RawExtension{Raw: rawSpec},
+		OperatingSystem:   os,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", UID: uid},
+		Spec: v1alpha1.MachineSpec{
+			ObjectMeta:   metav1.ObjectMeta{Name: "node-1"},
+			ProviderSpec: v1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: rawConfig}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		os          providerconfig.OperatingSystem
+		modify      func(map[string]interface{})
+		modifyFake  func(*fakeClient)
+		expectedErr bool
+	}{
+		{
+			name: "valid config",
+			os:   providerconfig.OperatingSystemUbuntu,
+		},
+		{
+			name: "valid config with boot volume size",
+			os:   providerconfig.OperatingSystemCentOS,
+			modify: func(spec map[string]interface{}) {
+				spec["bootVolumeSizeInGBs"] = 100
+			},
+		},
+		{
+			name:        "unsupported operating system",
+			os:          providerconfig.OperatingSystemCoreos,
+			expectedErr: true,
+		},
+		{
+			name: "missing shape",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				delete(spec, "shape")
+			},
+			expectedErr: true,
+		},
+		{
+			name: "boot volume too small",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["bootVolumeSizeInGBs"] = 20
+			},
+			expectedErr: true,
+		},
+		{
+			name: "reserved tag",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["tags"] = map[string]string{machineUIDTag: "foo"}
+			},
+			expectedErr: true,
+		},
+		{
+			name: "image not found",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["imageID"] = "ocid1.image.oc1..missing"
+			},
+			expectedErr: true,
+		},
+		{
+			name: "subnet in other availability domain",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modifyFake: func(f *fakeClient) {
+				f.subnets["ocid1.subnet.oc1..one"].AvailabilityDomain = "Uocm:EU-FRANKFURT-1-AD-2"
+			},
+			expectedErr: true,
+		},
+		{
+			name: "valid config with fault domain",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["faultDomain"] = "FAULT-DOMAIN-2"
+			},
+		},
+		{
+			name: "unknown fault domain",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["faultDomain"] = "FAULT-DOMAIN-4"
+			},
+			expectedErr: true,
+		},
+		{
+			name: "fault domain and spreading",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["faultDomain"] = "FAULT-DOMAIN-2"
+				spec["spreadFaultDomains"] = true
+			},
+			expectedErr: true,
+		},
+		{
+			name: "spreading without fault domains",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["spreadFaultDomains"] = true
+			},
+			modifyFake: func(f *fakeClient) {
+				f.faultDomains = nil
+			},
+			expectedErr: true,
+		},
+		{
+			name: "shape not available",
+			os:   providerconfig.OperatingSystemUbuntu,
+			modify: func(spec map[string]interface{}) {
+				spec["shape"] = "BM.GPU3.8"
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient()
+			if test.modifyFake != nil {
+				test.modifyFake(client)
+			}
+			machine := testMachine(t, "uid-1", test.os, test.modify)
+
+			err := newTestProvider(client).Validate(machine.Spec)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	client := newFakeClient()
+	machine := testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, func(spec map[string]interface{}) {
+		spec["bootVolumeSizeInGBs"] = 100
+		spec["assignPublicIP"] = true
+		spec["tags"] = map[string]string{"team": "infra"}
+	})
+
+	inst, err := newTestProvider(client).Create(machine, nil, "#cloud-config")
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	if inst.Status() != instance.StatusCreating {
+		t.Errorf("expected status %q, got %q", instance.StatusCreating, inst.Status())
+	}
+
+	expected := []launchInstanceDetails{{
+		AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1",
+		CompartmentID:      "ocid1.compartment.oc1..compartment",
+		DisplayName:        "node-1",
+		Shape:              "VM.Standard2.1",
+		SourceDetails: instanceSourceDetails{
+			SourceType:          "image",
+			ImageID:             "ocid1.image.oc1..ubuntu",
+			BootVolumeSizeInGBs: 100,
+		},
+		CreateVnicDetails: createVnicDetails{
+			SubnetID:       "ocid1.subnet.oc1..one",
+			AssignPublicIP: true,
+		},
+		Metadata: map[string]string{
+			"user_data": base64.StdEncoding.EncodeToString([]byte("#cloud-config")),
+		},
+		FreeformTags: map[string]string{
+			"team":         "infra",
+			machineUIDTag:  "uid-1",
+			machineNameTag: "node-1",
+			clusterNameTag: "my-cluster",
+		},
+	}}
+	if diff := deep.Equal(client.launched, expected); diff != nil {
+		t.Errorf("unexpected launch details, diff: %v", diff)
+	}
+}
+
+func TestCreateTagsTemplateHash(t *testing.T) {
+	client := newFakeClient()
+	machine := testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, nil)
+	machine.Labels = map[string]string{"machine-template-hash": "1871258541"}
+
+	if _, err := newTestProvider(client).Create(machine, nil, "#cloud-config"); err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	if len(client.launched) != 1 {
+		t.Fatalf("expected one launched instance, got %d", len(client.launched))
+	}
+	if hash := client.launched[0].FreeformTags[machineTemplateHashTag]; hash != "1871258541" {
+		t.Errorf("expected tag %s with the template hash 1871258541, got %q", machineTemplateHashTag, hash)
+	}
+}
+
+func TestCreateWithFaultDomain(t *testing.T) {
+	deploymentMachine := func(t *testing.T, uid types.UID, modify func(map[string]interface{})) *v1alpha1.Machine {
+		machine := testMachine(t, uid, providerconfig.OperatingSystemUbuntu, modify)
+		machine.Namespace = "kube-system"
+		machine.Labels = map[string]string{"machine-template-hash": "12345"}
+		machine.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineSet", Name: "my-workers-" + rand.SafeEncodeString("12345")}}
+		return machine
+	}
+	deploymentInstance := func(faultDomain, state string) ociInstance {
+		return ociInstance{
+			AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1",
+			FaultDomain:        faultDomain,
+			LifecycleState:     state,
+			FreeformTags:       map[string]string{machineDeploymentTag: "machine-controller-kube-system-my-workers"},
+		}
+	}
+
+	tests := []struct {
+		name                string
+		machine             func(t *testing.T) *v1alpha1.Machine
+		instances           []ociInstance
+		expectedFaultDomain string
+	}{
+		{
+			name: "configured fault domain",
+			machine: func(t *testing.T) *v1alpha1.Machine {
+				return deploymentMachine(t, "uid-1", func(spec map[string]interface{}) {
+					spec["faultDomain"] = "FAULT-DOMAIN-2"
+				})
+			},
+			expectedFaultDomain: "FAULT-DOMAIN-2",
+		},
+		{
+			name: "spread to the least used fault domain",
+			machine: func(t *testing.T) *v1alpha1.Machine {
+				return deploymentMachine(t, "uid-1", func(spec map[string]interface{}) {
+					spec["spreadFaultDomains"] = true
+				})
+			},
+			instances: []ociInstance{
+				deploymentInstance("FAULT-DOMAIN-1", instanceStateRunning),
+				deploymentInstance("FAULT-DOMAIN-2", instanceStateRunning),
+				deploymentInstance("FAULT-DOMAIN-3", instanceStateRunning),
+				deploymentInstance("FAULT-DOMAIN-3", instanceStateTerminated),
+				deploymentInstance("FAULT-DOMAIN-1", instanceStateProvisioning),
+			},
+			expectedFaultDomain: "FAULT-DOMAIN-2",
+		},
+		{
+			name: "spreading ignores instances of other deployments",
+			machine: func(t *testing.T) *v1alpha1.Machine {
+				return deploymentMachine(t, "uid-1", func(spec map[string]interface{}) {
+					spec["spreadFaultDomains"] = true
+				})
+			},
+			instances: []ociInstance{
+				{AvailabilityDomain: "Uocm:EU-FRANKFURT-1-AD-1", FaultDomain: "FAULT-DOMAIN-1", LifecycleState: instanceStateRunning},
+			},
+			expectedFaultDomain: "FAULT-DOMAIN-1",
+		},
+		{
+			name: "machine without deployment is not spread",
+			machine: func(t *testing.T) *v1alpha1.Machine {
+				return testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, func(spec map[string]interface{}) {
+					spec["spreadFaultDomains"] = true
+				})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient()
+			client.instances = test.instances
+
+			if _, err := newTestProvider(client).Create(test.machine(t), nil, "#cloud-config"); err != nil {
+				t.Fatalf("failed to create instance: %v", err)
+			}
+			if len(client.launched) != 1 {
+				t.Fatalf("expected one launched instance, got %d", len(client.launched))
+			}
+			if faultDomain := client.launched[0].FaultDomain; faultDomain != test.expectedFaultDomain {
+				t.Errorf("expected fault domain %q, got %q", test.expectedFaultDomain, faultDomain)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	client := newFakeClient()
+	client.instances = []ociInstance{
+		{ID: "old", DisplayName: "node-1", LifecycleState: instanceStateTerminated, FreeformTags: map[string]string{machineUIDTag: "uid-1"}},
+		{ID: "other", DisplayName: "node-2", LifecycleState: instanceStateRunning, FreeformTags: map[string]string{machineUIDTag: "uid-2"}},
+		{ID: "current", DisplayName: "node-1", LifecycleState: instanceStateRunning, FreeformTags: map[string]string{machineUIDTag: "uid-1"}},
+	}
+	client.vnics = map[string][]ociVnic{
+		"current": {{ID: "vnic", PrivateIP: "10.0.0.2", PublicIP: "192.0.2.10"}},
+	}
+	p := newTestProvider(client)
+
+	inst, err := p.Get(testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, nil))
+	if err != nil {
+		t.Fatalf("failed to get instance: %v", err)
+	}
+	if inst.ID() != "current" {
+		t.Errorf("expected instance %q, got %q", "current", inst.ID())
+	}
+	if inst.Status() != instance.StatusRunning {
+		t.Errorf("expected status %q, got %q", instance.StatusRunning, inst.Status())
+	}
+	if diff := deep.Equal(inst.Addresses(), map[string]corev1.NodeAddressType{"192.0.2.10": corev1.NodeExternalIP, "10.0.0.2": corev1.NodeInternalIP}); diff != nil {
+		t.Errorf("unexpected addresses, diff: %v", diff)
+	}
+
+	if _, err := p.Get(testMachine(t, "uid-3", providerconfig.OperatingSystemUbuntu, nil)); err != cloudprovidererrors.ErrInstanceNotFound {
+		t.Errorf("expected ErrInstanceNotFound, got %v", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name               string
+		instances          []ociInstance
+		terminateErr       error
+		expectedDone       bool
+		expectedTerminated []string
+	}{
+		{
+			name:         "no instance",
+			expectedDone: true,
+		},
+		{
+			name:               "running instance gets terminated",
+			instances:          []ociInstance{{ID: "current", LifecycleState: instanceStateRunning, FreeformTags: map[string]string{machineUIDTag: "uid-1"}}},
+			expectedTerminated: []string{"current"},
+		},
+		{
+			name:      "terminating instance is not terminated again",
+			instances: []ociInstance{{ID: "current", LifecycleState: instanceStateTerminating, FreeformTags: map[string]string{machineUIDTag: "uid-1"}}},
+		},
+		{
+			name:         "instance vanished while terminating",
+			instances:    []ociInstance{{ID: "current", LifecycleState: instanceStateRunning, FreeformTags: map[string]string{machineUIDTag: "uid-1"}}},
+			terminateErr: &serviceError{StatusCode: http.StatusNotFound},
+			expectedDone: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient()
+			client.instances = test.instances
+			client.terminateErr = test.terminateErr
+
+			done, err := newTestProvider(client).Cleanup(testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, nil), nil)
+			if err != nil {
+				t.Fatalf("cleanup failed: %v", err)
+			}
+			if done != test.expectedDone {
+				t.Errorf("expected done to be %v, got %v", test.expectedDone, done)
+			}
+			if diff := deep.Equal(client.terminated, test.expectedTerminated); diff != nil {
+				t.Errorf("unexpected terminated instances, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestMigrateUID(t *testing.T) {
+	client := newFakeClient()
+	client.instances = []ociInstance{
+		{ID: "current", LifecycleState: instanceStateRunning, FreeformTags: map[string]string{machineUIDTag: "uid-1", "team": "infra"}},
+	}
+
+	if err := newTestProvider(client).MigrateUID(testMachine(t, "uid-1", providerconfig.OperatingSystemUbuntu, nil), "uid-2"); err != nil {
+		t.Fatalf("failed to migrate uid: %v", err)
+	}
+
+	expected := map[string]map[string]string{"current": {machineUIDTag: "uid-2", "team": "infra"}}
+	if diff := deep.Equal(client.updatedTags, expected); diff != nil {
+		t.Errorf("unexpected tag update, diff: %v", diff)
+	}
+}
+
+func TestOCIErrorToTerminalError(t *testing.T) {
+	err := ociErrorToTerminalError(&serviceError{StatusCode: http.StatusUnauthorized, ErrCode: "NotAuthenticated"}, "failed")
+	if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); !isTerminal {
+		t.Errorf("expected authentication error to be terminal, got %v", err)
+	}
+
+	err = ociErrorToTerminalError(&serviceError{StatusCode: http.StatusTooManyRequests, ErrCode: "TooManyRequests"}, "failed")
+	if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); isTerminal {
+		t.Errorf("expected throttling error to not be terminal, got %v", err)
+	}
+	if code := cloudprovidererrors.ErrorCode(err); code != "TooManyRequests" {
+		t.Errorf("expected error code %q, got %q", "TooManyRequests", code)
+	}
+}
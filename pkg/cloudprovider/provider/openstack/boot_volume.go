@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	osservers "github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	volumeReadyCheckPeriod  = 2 * time.Second
+	volumeReadyCheckTimeout = 5 * time.Minute
+)
+
+// The vendored gophercloud has neither the block storage API nor the boot-from-volume extension, so the few calls
+// needed to boot from a Cinder volume are made directly
+
+type volumeAvailabilityZoneInfo struct {
+	ZoneName  string `json:"zoneName"`
+	ZoneState struct {
+		Available bool `json:"available"`
+	} `json:"zoneState"`
+}
+
+type volume struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	AvailabilityZone string `json:"availability_zone"`
+}
+
+// getVolumeAvailabilityZones returns the available availability zones of the block storage
+func getVolumeAvailabilityZones(blockStorageClient *gophercloud.ServiceClient) (sets.String, error) {
+	var result struct {
+		AvailabilityZoneInfo []volumeAvailabilityZoneInfo `json:"availabilityZoneInfo"`
+	}
+	if _, err := blockStorageClient.Get(blockStorageClient.ServiceURL("os-availability-zone"), &result, nil); err != nil {
+		return nil, err
+	}
+	zones := sets.NewString()
+	for _, zone := range result.AvailabilityZoneInfo {
+		if zone.ZoneState.Available {
+			zones.Insert(zone.ZoneName)
+		}
+	}
+	return zones, nil
+}
+
+// bootVolumeAvailabilityZone returns the availability zone the boot volume gets created in. Nova can only attach the
+// volume to the instance if both are in the same availability zone, unless the block storage is regional, i.e. has
+// availability zones which differ from the ones of the compute service.
+// Without an explicit volumeAvailabilityZone, the availability zone of the instance is used if the block storage has
+// it, otherwise the only availability zone of the block storage.
+func bootVolumeAvailabilityZone(c *Config, volumeZones sets.String) (string, error) {
+	if c.VolumeAvailabilityZone != "" {
+		if !volumeZones.Has(c.VolumeAvailabilityZone) {
+			return "", fmt.Errorf("volume availability zone %q does not exist, available: %v", c.VolumeAvailabilityZone, volumeZones.List())
+		}
+		if c.AvailabilityZone != "" && c.VolumeAvailabilityZone != c.AvailabilityZone && volumeZones.Has(c.AvailabilityZone) {
+			return "", fmt.Errorf("volume availability zone %q does not match the availability zone %q of the instance, the volume could not be attached", c.VolumeAvailabilityZone, c.AvailabilityZone)
+		}
+		return c.VolumeAvailabilityZone, nil
+	}
+
+	switch {
+	case c.AvailabilityZone != "" && volumeZones.Has(c.AvailabilityZone):
+		return c.AvailabilityZone, nil
+	case volumeZones.Len() == 1:
+		return volumeZones.List()[0], nil
+	case c.AvailabilityZone == "":
+		// Both Nova and Cinder use their default availability zone
+		return "", nil
+	default:
+		return "", fmt.Errorf("availability zone %q of the instance does not exist in the block storage, volumeAvailabilityZone must be set to one of %v", c.AvailabilityZone, volumeZones.List())
+	}
+}
+
+// createBootVolume creates the volume the instance boots from out of the image and waits until it is available
+func createBootVolume(blockStorageClient *gophercloud.ServiceClient, c *Config, name, machineUID, imageID string) (string, error) {
+	volumeZones, err := getVolumeAvailabilityZones(blockStorageClient)
+	if err != nil {
+		return "", osErrorToTerminalError(err, "failed to get volume availability zones")
+	}
+	zone, err := bootVolumeAvailabilityZone(c, volumeZones)
+	if err != nil {
+		return "", err
+	}
+
+	body := map[string]interface{}{
+		"volume": map[string]interface{}{
+			"name":              name,
+			"size":              c.RootDiskSizeGB,
+			"imageRef":          imageID,
+			"availability_zone": zone,
+			"metadata":          map[string]string{machineUIDMetaKey: machineUID},
+		},
+	}
+	var result struct {
+		Volume volume `json:"volume"`
+	}
+	if _, err := blockStorageClient.Post(blockStorageClient.ServiceURL("volumes"), body, &result, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	}); err != nil {
+		return "", osErrorToTerminalError(err, "failed to create boot volume")
+	}
+	glog.V(2).Infof("Created boot volume %s in availability zone %q", result.Volume.ID, zone)
+
+	if err := waitUntilVolumeIsAvailable(blockStorageClient, result.Volume.ID); err != nil {
+		defer deleteVolumeLogged(blockStorageClient, result.Volume.ID)
+		return "", err
+	}
+	return result.Volume.ID, nil
+}
+
+func waitUntilVolumeIsAvailable(blockStorageClient *gophercloud.ServiceClient, volumeID string) error {
+	volumeIsAvailable := func() (bool, error) {
+		var result struct {
+			Volume volume `json:"volume"`
+		}
+		if _, err := blockStorageClient.Get(blockStorageClient.ServiceURL("volumes", volumeID), &result, nil); err != nil {
+			// Only log the error but don't exit. in case of a network failure we want to retry
+			glog.V(2).Infof("failed to get boot volume %s: %v", volumeID, err)
+			return false, nil
+		}
+		switch result.Volume.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("boot volume %s failed to get created", volumeID)
+		}
+		return false, nil
+	}
+
+	if err := wait.PollImmediate(volumeReadyCheckPeriod, volumeReadyCheckTimeout, volumeIsAvailable); err != nil {
+		if err == wait.ErrWaitTimeout {
+			return fmt.Errorf("boot volume %s became not available after %f seconds", volumeID, volumeReadyCheckTimeout.Seconds())
+		}
+		return err
+	}
+	return nil
+}
+
+func deleteVolumeLogged(blockStorageClient *gophercloud.ServiceClient, volumeID string) {
+	glog.V(0).Infof("Deleting boot volume %s due to fatal error during machine creation...", volumeID)
+	if _, err := blockStorageClient.Delete(blockStorageClient.ServiceURL("volumes", volumeID), nil); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to delete the boot volume %s. Please take care of manually deleting the volume: %v", volumeID, err))
+		return
+	}
+	glog.V(0).Infof("Boot volume %s got deleted", volumeID)
+}
+
+// bootFromVolumeCreateOpts boots the server from the given volume, which gets deleted together with the server
+type bootFromVolumeCreateOpts struct {
+	osservers.CreateOptsBuilder
+	volumeID string
+}
+
+func (opts bootFromVolumeCreateOpts) ToServerCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOptsBuilder.ToServerCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	server := b["server"].(map[string]interface{})
+	server["imageRef"] = ""
+	server["block_device_mapping_v2"] = []map[string]interface{}{
+		{
+			"boot_index":            0,
+			"uuid":                  opts.volumeID,
+			"source_type":           "volume",
+			"destination_type":      "volume",
+			"delete_on_termination": true,
+		},
+	}
+	return b, nil
+}
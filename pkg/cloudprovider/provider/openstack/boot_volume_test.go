@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	goopenstack "github.com/gophercloud/gophercloud/openstack"
+	osservers "github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestBootVolumeAvailabilityZone(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       *Config
+		volumeZones  sets.String
+		expectedZone string
+		expectedErr  bool
+	}{
+		{
+			name:         "derived from the zonal instance",
+			config:       &Config{AvailabilityZone: "az2"},
+			volumeZones:  sets.NewString("az1", "az2"),
+			expectedZone: "az2",
+		},
+		{
+			name:         "regional block storage",
+			config:       &Config{AvailabilityZone: "az2"},
+			volumeZones:  sets.NewString("nova"),
+			expectedZone: "nova",
+		},
+		{
+			name:         "explicit zone matching the instance",
+			config:       &Config{AvailabilityZone: "az1", VolumeAvailabilityZone: "az1"},
+			volumeZones:  sets.NewString("az1", "az2"),
+			expectedZone: "az1",
+		},
+		{
+			name:         "explicit regional zone",
+			config:       &Config{AvailabilityZone: "az1", VolumeAvailabilityZone: "storage"},
+			volumeZones:  sets.NewString("storage", "backup"),
+			expectedZone: "storage",
+		},
+		{
+			name:        "explicit zone not matching the instance",
+			config:      &Config{AvailabilityZone: "az1", VolumeAvailabilityZone: "az2"},
+			volumeZones: sets.NewString("az1", "az2"),
+			expectedErr: true,
+		},
+		{
+			name:        "unknown explicit zone",
+			config:      &Config{AvailabilityZone: "az1", VolumeAvailabilityZone: "az3"},
+			volumeZones: sets.NewString("az1", "az2"),
+			expectedErr: true,
+		},
+		{
+			name:        "instance zone not in the block storage",
+			config:      &Config{AvailabilityZone: "az3"},
+			volumeZones: sets.NewString("az1", "az2"),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zone, err := bootVolumeAvailabilityZone(test.config, test.volumeZones)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+			if zone != test.expectedZone {
+				t.Errorf("expected zone %q, got %q", test.expectedZone, zone)
+			}
+		})
+	}
+}
+
+func TestCreateBootVolumeInInstanceZone(t *testing.T) {
+	var createRequest map[string]map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/os-availability-zone", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"availabilityZoneInfo": [
+  {"zoneName": "az1", "zoneState": {"available": true}},
+  {"zoneName": "az2", "zoneState": {"available": true}}
+]}`)
+	})
+	mux.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"volume": {"id": "volume-1", "status": "creating"}}`)
+	})
+	mux.HandleFunc("/volumes/volume-1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"volume": {"id": "volume-1", "status": "available", "availability_zone": "az2"}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	providerClient, err := goopenstack.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockStorageClient := &gophercloud.ServiceClient{ProviderClient: providerClient, Endpoint: server.URL + "/"}
+
+	config := &Config{AvailabilityZone: "az2", RootDiskSizeGB: 50}
+	volumeID, err := createBootVolume(blockStorageClient, config, "machine-1", "machine-uid", "image-1")
+	if err != nil {
+		t.Fatalf("failed to create boot volume: %v", err)
+	}
+	if volumeID != "volume-1" {
+		t.Errorf("expected volume-1, got %q", volumeID)
+	}
+	request := createRequest["volume"]
+	if request["availability_zone"] != "az2" {
+		t.Errorf("expected the volume in the availability zone az2 of the instance, got %v", request["availability_zone"])
+	}
+	if request["size"] != float64(50) || request["imageRef"] != "image-1" {
+		t.Errorf("expected a 50GB volume from image-1, got %v", request)
+	}
+
+	opts, err := bootFromVolumeCreateOpts{
+		CreateOptsBuilder: osservers.CreateOpts{Name: "machine-1", FlavorRef: "flavor-1", ImageRef: "image-1", AvailabilityZone: "az2"},
+		volumeID:          volumeID,
+	}.ToServerCreateMap()
+	if err != nil {
+		t.Fatalf("failed to build server create opts: %v", err)
+	}
+	serverOpts := opts["server"].(map[string]interface{})
+	if serverOpts["imageRef"] != "" {
+		t.Errorf("expected no image for a server booting from a volume, got %v", serverOpts["imageRef"])
+	}
+	blockDevices := serverOpts["block_device_mapping_v2"].([]map[string]interface{})
+	if len(blockDevices) != 1 || blockDevices[0]["uuid"] != "volume-1" || blockDevices[0]["boot_index"] != 0 {
+		t.Errorf("expected the server to boot from volume-1, got %v", blockDevices)
+	}
+}
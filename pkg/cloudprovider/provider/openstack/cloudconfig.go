@@ -97,6 +97,41 @@ type CloudConfig struct {
 	Version      string
 }
 
+// applyCloudConfigOverrides sets all fields of the cloud-config which got explicitly configured in the provider spec
+func applyCloudConfigOverrides(cc *CloudConfig, overrides *RawCloudConfig) {
+	if overrides == nil {
+		return
+	}
+	if lb := overrides.LoadBalancer; lb != nil {
+		if lb.LBVersion != "" {
+			cc.LoadBalancer.LBVersion = lb.LBVersion
+		}
+		if lb.SubnetID != "" {
+			cc.LoadBalancer.SubnetID = lb.SubnetID
+		}
+		if lb.FloatingNetworkID != "" {
+			cc.LoadBalancer.FloatingNetworkID = lb.FloatingNetworkID
+		}
+		if lb.LBMethod != "" {
+			cc.LoadBalancer.LBMethod = lb.LBMethod
+		}
+		if lb.LBProvider != "" {
+			cc.LoadBalancer.LBProvider = lb.LBProvider
+		}
+		if lb.ManageSecurityGroups != nil {
+			cc.LoadBalancer.ManageSecurityGroups = *lb.ManageSecurityGroups
+		}
+	}
+	if bs := overrides.BlockStorage; bs != nil {
+		if bs.BSVersion != "" {
+			cc.BlockStorage.BSVersion = bs.BSVersion
+		}
+		if bs.IgnoreVolumeAZ != nil {
+			cc.BlockStorage.IgnoreVolumeAZ = *bs.IgnoreVolumeAZ
+		}
+	}
+}
+
 func CloudConfigToString(c *CloudConfig) (string, error) {
 	funcMap := sprig.TxtFuncMap()
 	funcMap["iniEscape"] = ini.Escape
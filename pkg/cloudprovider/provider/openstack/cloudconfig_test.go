@@ -18,13 +18,20 @@ package openstack
 
 import (
 	"flag"
+	"fmt"
 	"testing"
 	"time"
 
 	"gopkg.in/gcfg.v1"
 
 	"github.com/kubermatic/machine-controller/pkg/ini"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	testhelper "github.com/kubermatic/machine-controller/pkg/test"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
 var update = flag.Bool("update", false, "update testdata files")
@@ -117,3 +124,50 @@ func TestCloudConfigToString(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCloudConfigPerMachineDeployment(t *testing.T) {
+	specForSubnet := func(subnetID string) v1alpha1.MachineSpec {
+		return v1alpha1.MachineSpec{
+			ProviderSpec: v1alpha1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{
+  "cloudProvider": "openstack",
+  "cloudProviderSpec": {
+    "identityEndpoint": "https://127.0.0.1:8443",
+    "username": "admin",
+    "password": "password",
+    "tenantName": "Test",
+    "region": "eu-central1",
+    "cloudConfig": {"loadBalancer": {"subnetID": %q, "lbMethod": "LEAST_CONNECTIONS"}}
+  }
+}`, subnetID))},
+			},
+			Versions: v1alpha1.MachineVersionInfo{Kubelet: "1.12.0"},
+		}
+	}
+
+	p := New(providerconfig.NewConfigVarResolver(fake.NewSimpleClientset()))
+	firstConfig, _, err := p.GetCloudConfig(specForSubnet("first-subnet"))
+	if err != nil {
+		t.Fatalf("failed to get cloud-config for the first deployment: %v", err)
+	}
+	secondConfig, _, err := p.GetCloudConfig(specForSubnet("second-subnet"))
+	if err != nil {
+		t.Fatalf("failed to get cloud-config for the second deployment: %v", err)
+	}
+
+	if firstConfig == secondConfig {
+		t.Fatal("expected the deployments to render different cloud-configs")
+	}
+	for subnetID, config := range map[string]string{"first-subnet": firstConfig, "second-subnet": secondConfig} {
+		cc := &CloudConfig{}
+		if err := gcfg.ReadStringInto(cc, config); err != nil {
+			t.Fatalf("failed to load string into config object: %v", err)
+		}
+		if cc.LoadBalancer.SubnetID != subnetID {
+			t.Errorf("expected subnet-id %q, got %q", subnetID, cc.LoadBalancer.SubnetID)
+		}
+		if cc.LoadBalancer.LBMethod != "LEAST_CONNECTIONS" {
+			t.Errorf("expected lb-method LEAST_CONNECTIONS, got %q", cc.LoadBalancer.LBMethod)
+		}
+	}
+}
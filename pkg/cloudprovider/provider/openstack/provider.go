@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -35,11 +36,14 @@ import (
 	osnetworks "github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/pagination"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/clientcert"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -73,6 +77,10 @@ type RawConfig struct {
 	TenantName       providerconfig.ConfigVarString `json:"tenantName"`
 	TokenID          providerconfig.ConfigVarString `json:"tokenId"`
 	Region           providerconfig.ConfigVarString `json:"region"`
+	// PEM encoded CA bundle and client certificate for identity and service endpoints which require mutual TLS
+	CACert     providerconfig.ConfigVarString `json:"caCert,omitempty"`
+	ClientCert providerconfig.ConfigVarString `json:"clientCert,omitempty"`
+	ClientKey  providerconfig.ConfigVarString `json:"clientKey,omitempty"`
 
 	// Machine details
 	Image            providerconfig.ConfigVarString   `json:"image"`
@@ -83,8 +91,37 @@ type RawConfig struct {
 	FloatingIPPool   providerconfig.ConfigVarString   `json:"floatingIpPool"`
 	AvailabilityZone providerconfig.ConfigVarString   `json:"availabilityZone"`
 	TrustDevicePath  providerconfig.ConfigVarBool     `json:"trustDevicePath"`
+	// StorageNetwork is an optional network the instance gets an additional NIC in, to carry the storage traffic
+	StorageNetwork providerconfig.ConfigVarString `json:"storageNetwork,omitempty"`
+	// RootDiskSizeGB boots the instance from a Cinder volume of this size instead of the ephemeral disk of the flavor
+	RootDiskSizeGB *int `json:"rootDiskSizeGB,omitempty"`
+	// VolumeAvailabilityZone is the availability zone of the boot volume. Defaults to the availability zone of the instance
+	VolumeAvailabilityZone providerconfig.ConfigVarString `json:"volumeAvailabilityZone,omitempty"`
 	// This tag is related to server metadata, not compute server's tag
 	Tags map[string]string `json:"tags"`
+
+	// Overrides for the generated cloud-config, allows node pools to use different settings
+	CloudConfig *RawCloudConfig `json:"cloudConfig,omitempty"`
+}
+
+// RawCloudConfig contains the sections of the cloud-config which can be set per MachineDeployment
+type RawCloudConfig struct {
+	LoadBalancer *RawLoadBalancerOpts `json:"loadBalancer,omitempty"`
+	BlockStorage *RawBlockStorageOpts `json:"blockStorage,omitempty"`
+}
+
+type RawLoadBalancerOpts struct {
+	LBVersion            string `json:"lbVersion,omitempty"`
+	SubnetID             string `json:"subnetID,omitempty"`
+	FloatingNetworkID    string `json:"floatingNetworkID,omitempty"`
+	LBMethod             string `json:"lbMethod,omitempty"`
+	LBProvider           string `json:"lbProvider,omitempty"`
+	ManageSecurityGroups *bool  `json:"manageSecurityGroups,omitempty"`
+}
+
+type RawBlockStorageOpts struct {
+	BSVersion      string `json:"bsVersion,omitempty"`
+	IgnoreVolumeAZ *bool  `json:"ignoreVolumeAZ,omitempty"`
 }
 
 type Config struct {
@@ -95,6 +132,7 @@ type Config struct {
 	TenantName       string
 	TokenID          string
 	Region           string
+	TLS              clientcert.Config
 
 	// Machine details
 	Image            string
@@ -105,13 +143,20 @@ type Config struct {
 	FloatingIPPool   string
 	AvailabilityZone string
 	TrustDevicePath  bool
+	StorageNetwork   string
+
+	RootDiskSizeGB         int
+	VolumeAvailabilityZone string
 
 	Tags map[string]string
+
+	CloudConfig *RawCloudConfig
 }
 
 const (
-	machineUIDMetaKey = "machine-uid"
-	securityGroupName = "kubernetes-v1"
+	machineUIDMetaKey          = "machine-uid"
+	machineTemplateHashMetaKey = "machine-template-hash"
+	securityGroupName          = "kubernetes-v1"
 
 	instanceReadyCheckPeriod  = 2 * time.Second
 	instanceReadyCheckTimeout = 2 * time.Minute
@@ -163,6 +208,18 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	c.TLS.CACert, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CACert)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"caCert\" field, error = %v", err)
+	}
+	c.TLS.ClientCert, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ClientCert)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"clientCert\" field, error = %v", err)
+	}
+	c.TLS.ClientKey, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ClientKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"clientKey\" field, error = %v", err)
+	}
 	c.Image, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.Image)
 	if err != nil {
 		return nil, nil, nil, err
@@ -186,6 +243,10 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	c.StorageNetwork, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.StorageNetwork)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	c.FloatingIPPool, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.FloatingIPPool)
 	if err != nil {
 		return nil, nil, nil, err
@@ -198,10 +259,18 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if rawConfig.RootDiskSizeGB != nil {
+		c.RootDiskSizeGB = *rawConfig.RootDiskSizeGB
+	}
+	c.VolumeAvailabilityZone, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VolumeAvailabilityZone)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	c.Tags = rawConfig.Tags
 	if c.Tags == nil {
 		c.Tags = map[string]string{}
 	}
+	c.CloudConfig = rawConfig.CloudConfig
 
 	return &c, &pconfig, &rawConfig, err
 }
@@ -238,7 +307,37 @@ func getClient(c *Config) (*gophercloud.ProviderClient, error) {
 		TokenID:          c.TokenID,
 	}
 
-	return goopenstack.AuthenticatedClient(opts)
+	client, err := newProviderClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := goopenstack.Authenticate(client, opts); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// newProviderClient returns an unauthenticated client which uses the configured CA and client certificate
+func newProviderClient(c *Config) (*gophercloud.ProviderClient, error) {
+	client, err := goopenstack.NewClient(c.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !c.TLS.IsSet() {
+		return client, nil
+	}
+
+	tlsConfig, err := c.TLS.TLSConfig(false)
+	if err != nil {
+		return nil, err
+	}
+	client.HTTPClient = http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return client, nil
 }
 
 func (p *provider) AddDefaults(spec v1alpha1.MachineSpec) (v1alpha1.MachineSpec, error) {
@@ -335,6 +434,10 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return errors.New("flavor must be configured")
 	}
 
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+
 	client, err := getClient(c)
 	if err != nil {
 		return fmt.Errorf("failed to get a openstack client: %v", err)
@@ -367,10 +470,39 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		}
 	}
 
+	if c.StorageNetwork != "" {
+		if c.StorageNetwork == c.Network {
+			return fmt.Errorf("storage network %q must differ from the network of the instance", c.StorageNetwork)
+		}
+		if _, err := getNetwork(client, c.Region, c.StorageNetwork); err != nil {
+			return fmt.Errorf("failed to get storage network %q: %v", c.StorageNetwork, err)
+		}
+	}
+
 	if _, err := getAvailabilityZone(client, c.Region, c.AvailabilityZone); err != nil {
 		return fmt.Errorf("failed to get availability zone %q: %v", c.AvailabilityZone, err)
 	}
 
+	if c.RootDiskSizeGB < 0 {
+		return fmt.Errorf("invalid rootDiskSizeGB %d, must not be negative", c.RootDiskSizeGB)
+	}
+	if c.VolumeAvailabilityZone != "" && c.RootDiskSizeGB == 0 {
+		return errors.New("volumeAvailabilityZone requires rootDiskSizeGB")
+	}
+	if c.RootDiskSizeGB > 0 {
+		blockStorageClient, err := goopenstack.NewBlockStorageV3(client, gophercloud.EndpointOpts{Availability: gophercloud.AvailabilityPublic, Region: c.Region})
+		if err != nil {
+			return fmt.Errorf("failed to get block storage client: %v", err)
+		}
+		volumeZones, err := getVolumeAvailabilityZones(blockStorageClient)
+		if err != nil {
+			return fmt.Errorf("failed to get volume availability zones: %v", err)
+		}
+		if _, err := bootVolumeAvailabilityZone(c, volumeZones); err != nil {
+			return err
+		}
+	}
+
 	// Optional fields
 	if len(c.SecurityGroups) != 0 {
 		for _, s := range c.SecurityGroups {
@@ -381,8 +513,10 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 	}
 
 	// validate reserved tags
-	if _, ok := c.Tags[machineUIDMetaKey]; ok {
-		return fmt.Errorf("the tag with the given name =%s is reserved, choose a different one", machineUIDMetaKey)
+	for _, key := range []string{machineUIDMetaKey, machineTemplateHashMetaKey} {
+		if _, ok := c.Tags[key]; ok {
+			return fmt.Errorf("the tag with the given name =%s is reserved, choose a different one", key)
+		}
 	}
 
 	return nil
@@ -417,6 +551,15 @@ func (p *provider) Create(machine *v1alpha1.Machine, machineCreateDeleteData *cl
 		return nil, osErrorToTerminalError(err, fmt.Sprintf("failed to get network %s", c.Network))
 	}
 
+	networks := []osservers.Network{{UUID: network.ID}}
+	if c.StorageNetwork != "" {
+		storageNetwork, err := getNetwork(client, c.Region, c.StorageNetwork)
+		if err != nil {
+			return nil, osErrorToTerminalError(err, fmt.Sprintf("failed to get storage network %s", c.StorageNetwork))
+		}
+		networks = append(networks, osservers.Network{UUID: storageNetwork.ID})
+	}
+
 	securityGroups := c.SecurityGroups
 	if len(securityGroups) == 0 {
 		glog.V(2).Infof("creating security group %s for worker nodes", securityGroupName)
@@ -430,6 +573,9 @@ func (p *provider) Create(machine *v1alpha1.Machine, machineCreateDeleteData *cl
 	// we check against reserved tags in Validation method
 	allTags := c.Tags
 	allTags[machineUIDMetaKey] = string(machine.UID)
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		allTags[machineTemplateHashMetaKey] = hash
+	}
 
 	serverOpts := osservers.CreateOpts{
 		Name:             machine.Spec.Name,
@@ -438,7 +584,7 @@ func (p *provider) Create(machine *v1alpha1.Machine, machineCreateDeleteData *cl
 		UserData:         []byte(userdata),
 		SecurityGroups:   securityGroups,
 		AvailabilityZone: c.AvailabilityZone,
-		Networks:         []osservers.Network{{UUID: network.ID}},
+		Networks:         networks,
 		Metadata:         allTags,
 	}
 	computeClient, err := goopenstack.NewComputeV2(client, gophercloud.EndpointOpts{Availability: gophercloud.AvailabilityPublic, Region: c.Region})
@@ -446,12 +592,31 @@ func (p *provider) Create(machine *v1alpha1.Machine, machineCreateDeleteData *cl
 		return nil, osErrorToTerminalError(err, "failed to get compute client")
 	}
 
+	var createOpts osservers.CreateOptsBuilder = serverOpts
+	var blockStorageClient *gophercloud.ServiceClient
+	var bootVolumeID string
+	if c.RootDiskSizeGB > 0 {
+		blockStorageClient, err = goopenstack.NewBlockStorageV3(client, gophercloud.EndpointOpts{Availability: gophercloud.AvailabilityPublic, Region: c.Region})
+		if err != nil {
+			return nil, osErrorToTerminalError(err, "failed to get block storage client")
+		}
+		bootVolumeID, err = createBootVolume(blockStorageClient, c, machine.Spec.Name, string(machine.UID), image.ID)
+		if err != nil {
+			return nil, err
+		}
+		createOpts = bootFromVolumeCreateOpts{CreateOptsBuilder: serverOpts, volumeID: bootVolumeID}
+	}
+
 	var server serverWithExt
 	err = osservers.Create(computeClient, keypairs.CreateOptsExt{
-		CreateOptsBuilder: serverOpts,
+		CreateOptsBuilder: createOpts,
 		KeyName:           "",
 	}).ExtractInto(&server)
 	if err != nil {
+		// Once the server exists, the boot volume gets deleted together with it
+		if bootVolumeID != "" {
+			defer deleteVolumeLogged(blockStorageClient, bootVolumeID)
+		}
 		return nil, osErrorToTerminalError(err, "failed to create server")
 	}
 
@@ -678,6 +843,7 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 		},
 		Version: spec.Versions.Kubelet,
 	}
+	applyCloudConfigOverrides(cc, c.CloudConfig)
 
 	s, err := CloudConfigToString(cc)
 	if err != nil {
@@ -686,6 +852,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 	return s, "openstack", nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.Flavor, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -716,18 +891,32 @@ func (d *osInstance) ID() string {
 	return d.server.ID
 }
 
-func (d *osInstance) Addresses() []string {
-	var addresses []string
+func (d *osInstance) CreationTime() time.Time {
+	return d.server.Created
+}
+
+func (d *osInstance) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, networkAddresses := range d.server.Addresses {
 		for _, element := range networkAddresses.([]interface{}) {
 			address := element.(map[string]interface{})
-			addresses = append(addresses, address["addr"].(string))
+			// Floating IPs are reachable from outside, fixed IPs only within their network
+			if address["OS-EXT-IPS:type"] == "floating" {
+				addresses[address["addr"].(string)] = corev1.NodeExternalIP
+			} else {
+				addresses[address["addr"].(string)] = corev1.NodeInternalIP
+			}
 		}
 	}
 
 	return addresses
 }
 
+// Tags returns the metadata of the server, the tags of the provider config end up in it
+func (d *osInstance) Tags() map[string]string {
+	return d.server.Metadata
+}
+
 func (d *osInstance) Status() instance.Status {
 	switch d.server.Status {
 	case "IN_PROGRESS":
@@ -896,3 +1085,7 @@ func assignFloatingIPToInstance(machineUpdater cloudprovidertypes.MachineUpdater
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestGetCloudConfigUsesRotatedSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "openstack", ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte("old-password")},
+	}
+	kubeClient := fake.NewSimpleClientset(secret)
+	p := New(providerconfig.NewConfigVarResolver(kubeClient))
+
+	spec := v1alpha1.MachineSpec{}
+	spec.Versions.Kubelet = "1.13.0"
+	spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(`{
+  "cloudProvider": "openstack",
+  "cloudProviderSpec": {
+    "identityEndpoint": "https://keystone.example.com:5000/v3",
+    "username": "admin",
+    "password": {"secretKeyRef": {"namespace": "kube-system", "name": "openstack", "key": "password"}},
+    "tenantName": "tenant",
+    "domainName": "default"
+  },
+  "operatingSystem": "ubuntu"
+}`)}
+
+	cloudConfig, _, err := p.GetCloudConfig(spec)
+	if err != nil {
+		t.Fatalf("failed to render cloud config: %v", err)
+	}
+	if !strings.Contains(cloudConfig, "old-password") {
+		t.Fatalf("expected the cloud config to contain the password from the secret, got:\n%s", cloudConfig)
+	}
+
+	rotatedSecret := secret.DeepCopy()
+	rotatedSecret.ResourceVersion = "2"
+	rotatedSecret.Data["password"] = []byte("new-password")
+	if _, err := kubeClient.CoreV1().Secrets("kube-system").Update(rotatedSecret); err != nil {
+		t.Fatalf("failed to rotate secret: %v", err)
+	}
+
+	// The next machine of the same MachineDeployment gets its userdata rendered from the same spec
+	cloudConfig, _, err = p.GetCloudConfig(spec)
+	if err != nil {
+		t.Fatalf("failed to render cloud config after the rotation: %v", err)
+	}
+	if strings.Contains(cloudConfig, "old-password") || !strings.Contains(cloudConfig, "new-password") {
+		t.Errorf("expected the cloud config to contain the rotated password, got:\n%s", cloudConfig)
+	}
+	versions, err := providerconfig.NewConfigVarResolver(kubeClient).GetSecretVersions(spec.ProviderSpec)
+	if err != nil {
+		t.Fatalf("failed to get secret versions: %v", err)
+	}
+	if versions["kube-system/openstack"] != "2" {
+		t.Errorf("expected the userdata to be tracked with secret version 2, got %q", versions["kube-system/openstack"])
+	}
+}
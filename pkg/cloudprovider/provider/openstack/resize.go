@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	goopenstack "github.com/gophercloud/gophercloud/openstack"
+	osflavors "github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	osservers "github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/resize"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func (p *provider) OnlyInstanceTypeChanged(old, new v1alpha1.MachineSpec) (bool, error) {
+	return resize.OnlyCloudProviderSpecFieldChanged(old, new, "flavor")
+}
+
+func (p *provider) InstanceTypes(machine *v1alpha1.Machine) (string, string, error) {
+	c, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse config: %v", err)
+	}
+	inst, err := p.Get(machine)
+	if err != nil {
+		return "", "", err
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return "", "", osErrorToTerminalError(err, "failed to get a openstack client")
+	}
+	computeClient, err := goopenstack.NewComputeV2(client, gophercloud.EndpointOpts{Availability: gophercloud.AvailabilityPublic, Region: c.Region})
+	if err != nil {
+		return "", "", osErrorToTerminalError(err, "failed to get compute client")
+	}
+	flavorID, _ := inst.(*osInstance).server.Flavor["id"].(string)
+	flavor, err := osflavors.Get(computeClient, flavorID).Extract()
+	if err != nil {
+		return "", "", osErrorToTerminalError(err, fmt.Sprintf("failed to get flavor %s of instance", flavorID))
+	}
+	return flavor.Name, c.Flavor, nil
+}
+
+// Resize takes the next step to run the instance of the machine with the requested flavor. The resize
+// of an instance needs to be confirmed before it is done.
+func (p *provider) Resize(machine *v1alpha1.Machine) (bool, error) {
+	c, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse config: %v", err)
+	}
+	client, err := getClient(c)
+	if err != nil {
+		return false, osErrorToTerminalError(err, "failed to get a openstack client")
+	}
+	computeClient, err := goopenstack.NewComputeV2(client, gophercloud.EndpointOpts{Availability: gophercloud.AvailabilityPublic, Region: c.Region})
+	if err != nil {
+		return false, osErrorToTerminalError(err, "failed to get compute client")
+	}
+	flavor, err := getFlavor(client, c.Region, c.Flavor)
+	if err != nil {
+		return false, osErrorToTerminalError(err, fmt.Sprintf("failed to get flavor %s", c.Flavor))
+	}
+	inst, err := p.Get(machine)
+	if err != nil {
+		return false, err
+	}
+	server := inst.(*osInstance).server
+
+	switch server.Status {
+	case "ACTIVE":
+		if server.Flavor["id"] == flavor.ID {
+			return true, nil
+		}
+		if err := osservers.Resize(computeClient, server.ID, osservers.ResizeOpts{FlavorRef: flavor.ID}).ExtractErr(); err != nil {
+			return false, osErrorToTerminalError(err, "failed to resize instance")
+		}
+	case "VERIFY_RESIZE":
+		if err := osservers.ConfirmResize(computeClient, server.ID).ExtractErr(); err != nil {
+			return false, osErrorToTerminalError(err, "failed to confirm resize of instance")
+		}
+	}
+	// The instance is being resized
+	return false, nil
+}
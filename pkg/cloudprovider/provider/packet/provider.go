@@ -29,8 +29,10 @@ import (
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -39,8 +41,9 @@ import (
 )
 
 const (
-	machineUIDTag       = "kubermatic-machine-controller:machine-uid"
-	defaultBillingCycle = "hourly"
+	machineUIDTag          = "kubermatic-machine-controller:machine-uid"
+	machineTemplateHashTag = "kubermatic-machine-controller:machine-template-hash"
+	defaultBillingCycle    = "hourly"
 )
 
 // New returns a Packet provider
@@ -236,6 +239,9 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 			generateTag(string(machine.UID)),
 		},
 	}
+	if hash, ok := kuberneteshelper.MachineTemplateHash(machine); ok {
+		serverCreateOpts.Tags = append(serverCreateOpts.Tags, fmt.Sprintf("%s:%s", machineTemplateHashTag, hash))
+	}
 
 	device, res, err := client.Devices.Create(serverCreateOpts)
 	if err != nil {
@@ -336,6 +342,15 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 	return "", "", nil
 }
 
+// InstanceType returns the instance type the provider config of the spec requests
+func (p *provider) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	c, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	return c.InstanceType, nil
+}
+
 func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]string, error) {
 	labels := make(map[string]string)
 
@@ -364,13 +379,15 @@ func (s *packetDevice) ID() string {
 	return s.device.ID
 }
 
-func (s *packetDevice) Addresses() []string {
-	// returns addresses in CIDR format
-	var addresses []string
+func (s *packetDevice) Addresses() map[string]corev1.NodeAddressType {
+	addresses := map[string]corev1.NodeAddressType{}
 	for _, ip := range s.device.Network {
-		addresses = append(addresses, ip.Address)
+		if ip.Public {
+			addresses[ip.Address] = corev1.NodeExternalIP
+		} else {
+			addresses[ip.Address] = corev1.NodeInternalIP
+		}
 	}
-
 	return addresses
 }
 
@@ -516,3 +533,7 @@ func planProp(vs []packngo.Plan, field string) []string {
 	}
 	return vsm
 }
+
+func (p *provider) MachineCapacity(_ v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return nil, nil
+}
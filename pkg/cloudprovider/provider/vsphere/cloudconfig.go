@@ -96,6 +96,24 @@ type CloudConfig struct {
 	VirtualCenter map[string]*VirtualCenterConfig
 }
 
+// applyCloudConfigOverrides sets all fields of the cloud-config which got explicitly configured in the provider spec
+func applyCloudConfigOverrides(cc *CloudConfig, overrides *RawCloudConfig) {
+	if overrides == nil {
+		return
+	}
+	if ws := overrides.Workspace; ws != nil {
+		if ws.DefaultDatastore != "" {
+			cc.Workspace.DefaultDatastore = ws.DefaultDatastore
+		}
+		if ws.ResourcePoolPath != "" {
+			cc.Workspace.ResourcePoolPath = ws.ResourcePoolPath
+		}
+	}
+	if disk := overrides.Disk; disk != nil && disk.SCSIControllerType != "" {
+		cc.Disk.SCSIControllerType = disk.SCSIControllerType
+	}
+}
+
 func CloudConfigToString(c *CloudConfig) (string, error) {
 	funcMap := sprig.TxtFuncMap()
 	funcMap["iniEscape"] = ini.Escape
@@ -38,6 +38,8 @@ import (
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 )
 
 const (
@@ -46,7 +48,7 @@ const (
 	local-hostname: {{ .Hostname }}`
 )
 
-func createClonedVM(ctx context.Context, vmName string, config *Config, dc *object.Datacenter, f *find.Finder, containerLinuxUserdata string) (*object.VirtualMachine, error) {
+func createClonedVM(ctx context.Context, vmName string, config *Config, dc *object.Datacenter, f *find.Finder, containerLinuxUserdata, bottlerocketUserdata string) (*object.VirtualMachine, error) {
 	templateVM, err := f.VirtualMachine(ctx, config.TemplateVMName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get template vm: %v", err)
@@ -152,6 +154,14 @@ func createClonedVM(ctx context.Context, vmName string, config *Config, dc *obje
 		VAppConfig: vAppAconfig,
 	}
 
+	// Bottlerocket reads its settings from the guestinfo, the plugin already encoded them
+	if bottlerocketUserdata != "" {
+		desiredConfig.ExtraConfig = []types.BaseOptionValue{
+			&types.OptionValue{Key: "guestinfo.userdata", Value: bottlerocketUserdata},
+			&types.OptionValue{Key: "guestinfo.userdata.encoding", Value: "base64"},
+		}
+	}
+
 	// Create a cloned VM from the template VM's snapshot
 	clonedVMTask, err := templateVM.Clone(ctx, targetVMFolder, vmName, types.VirtualMachineCloneSpec{
 		Config: &types.VirtualMachineConfigSpec{DeviceChange: deviceSpecs}})
@@ -184,6 +194,12 @@ func createClonedVM(ctx context.Context, vmName string, config *Config, dc *obje
 		}
 	}
 
+	if config.StorageNetName != "" {
+		if err := addNetworkToVM(ctx, virtualMachine, config.StorageNetName); err != nil {
+			return nil, fmt.Errorf("couldn't add storage network to vm: %v", err)
+		}
+	}
+
 	// Ubuntu wont boot with attached floppy device, because it tries to write to it
 	// which fails, because the floppy device does not contain a floppy disk
 	// Upstream issue: https://bugs.launchpad.net/cloud-images/+bug/1573095
@@ -218,6 +234,30 @@ func updateNetworkForVM(ctx context.Context, vm *object.VirtualMachine, currentN
 	return vm.EditDevice(ctx, *netDev)
 }
 
+// addNetworkToVM adds a vmxnet3 network adapter in the given network to the vm
+func addNetworkToVM(ctx context.Context, vm *object.VirtualMachine, netName string) error {
+	net, err := getNetworkFromVM(ctx, vm, netName)
+	if err != nil {
+		return fmt.Errorf("failed to get network from vm: %v", err)
+	}
+
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices for vm: %v", err)
+	}
+	backing := &types.VirtualEthernetCardNetworkBackingInfo{
+		VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{DeviceName: netName},
+		Network:                        net,
+	}
+	netDev, err := devices.CreateEthernetCard("vmxnet3", backing)
+	if err != nil {
+		return fmt.Errorf("failed to create network adapter: %v", err)
+	}
+
+	glog.V(6).Infof("adding network adapter in `%s` to vm `%s`", netName, vm.Name())
+	return vm.AddDevice(ctx, netDev)
+}
+
 func getNetworkDevicesAndBackingsFromVM(ctx context.Context, vm *object.VirtualMachine, netNameFilter string) ([]netDeviceAndBackingInfo, error) {
 	devices, err := vm.Device(ctx)
 	if err != nil {
@@ -291,6 +331,91 @@ func uploadAndAttachISO(ctx context.Context, f *find.Finder, vmRef *object.Virtu
 	return vmRef.EditDevice(ctx, devices.InsertIso(cdrom, iso))
 }
 
+// attachISOImagesAndSetBootOrder adds a cd-rom drive for each of the given datastore ISO images
+// and sets the boot order of the vm
+func attachISOImagesAndSetBootOrder(ctx context.Context, vm *object.VirtualMachine, isoImages, bootOrder []string) error {
+	if len(isoImages) == 0 && len(bootOrder) == 0 {
+		return nil
+	}
+
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %v", err)
+	}
+
+	spec, err := isoImagesAndBootOrderConfigSpec(devices, isoImages, bootOrder)
+	if err != nil {
+		return err
+	}
+
+	task, err := vm.Reconfigure(ctx, *spec)
+	if err != nil {
+		return fmt.Errorf("failed to reconfigure vm: %v", err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		return fmt.Errorf("error waiting for reconfigure task to finish: %v", err)
+	}
+	return nil
+}
+
+func isoImagesAndBootOrderConfigSpec(devices object.VirtualDeviceList, isoImages, bootOrder []string) (*types.VirtualMachineConfigSpec, error) {
+	spec := &types.VirtualMachineConfigSpec{}
+
+	for _, iso := range isoImages {
+		ide, err := devices.FindIDEController("")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find an IDE controller for the cdrom with %s: %v", iso, err)
+		}
+		cdrom, err := devices.CreateCdrom(ide)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cdrom device: %v", err)
+		}
+		// Every new device needs a distinct temporary key, vSphere replaces it on reconfiguration
+		cdrom.Key = devices.NewKey()
+		ide.Device = append(ide.Device, cdrom.Key)
+		devices = append(devices, devices.InsertIso(cdrom, iso))
+
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    cdrom,
+		})
+	}
+
+	if len(bootOrder) > 0 {
+		spec.BootOptions = &types.VirtualMachineBootOptions{}
+		// A cdrom boot entry does not reference a drive, the vm boots from the first one with bootable media
+		// so it must only be listed once
+		var cdromListed bool
+		for _, bootableDevice := range devices.BootOrder(bootOrder) {
+			if _, isCdrom := bootableDevice.(*types.VirtualMachineBootOptionsBootableCdromDevice); isCdrom {
+				if cdromListed {
+					continue
+				}
+				cdromListed = true
+			}
+			spec.BootOptions.BootOrder = append(spec.BootOptions.BootOrder, bootableDevice)
+		}
+	}
+
+	return spec, nil
+}
+
+func validateBootOrder(bootOrder []string) error {
+	seen := map[string]bool{}
+	for _, deviceType := range bootOrder {
+		switch deviceType {
+		case object.DeviceTypeCdrom, object.DeviceTypeDisk, object.DeviceTypeEthernet:
+		default:
+			return fmt.Errorf("invalid boot device %q, must be one of %s, %s or %s", deviceType, object.DeviceTypeCdrom, object.DeviceTypeDisk, object.DeviceTypeEthernet)
+		}
+		if seen[deviceType] {
+			return fmt.Errorf("boot device %q is listed more than once", deviceType)
+		}
+		seen[deviceType] = true
+	}
+	return nil
+}
+
 func getDatacenterFinder(datacenter string, client *govmomi.Client) (*find.Finder, error) {
 	finder := find.NewFinder(client.Client, true)
 	dc, err := finder.Datacenter(context.TODO(), datacenter)
@@ -363,6 +488,11 @@ func generateLocalUserdataISO(userdata, name string) (string, error) {
 	return isoFilePath, nil
 }
 
+// usesUserdataISO returns true if the userdata gets passed via a cloud-init ISO rather than the guestinfo
+func usesUserdataISO(operatingSystem providerconfig.OperatingSystem) bool {
+	return operatingSystem != providerconfig.OperatingSystemCoreos && operatingSystem != providerconfig.OperatingSystemBottlerocket
+}
+
 func removeFloppyDevice(ctx context.Context, virtualMachine *object.VirtualMachine) error {
 	vmDevices, err := virtualMachine.Device(ctx)
 	if err != nil {
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestISOImagesAndBootOrderConfigSpec(t *testing.T) {
+	devices := object.VirtualDeviceList{
+		&types.VirtualIDEController{VirtualController: types.VirtualController{
+			VirtualDevice: types.VirtualDevice{Key: 200},
+			Device:        []int32{3000},
+		}},
+		&types.VirtualIDEController{VirtualController: types.VirtualController{
+			VirtualDevice: types.VirtualDevice{Key: 201},
+			BusNumber:     1,
+		}},
+		&types.VirtualDisk{VirtualDevice: types.VirtualDevice{Key: 2000}},
+		&types.VirtualCdrom{VirtualDevice: types.VirtualDevice{Key: 3000, ControllerKey: 200, UnitNumber: int32Ptr(0)}},
+		&types.VirtualVmxnet3{VirtualVmxnet: types.VirtualVmxnet{VirtualEthernetCard: types.VirtualEthernetCard{
+			VirtualDevice: types.VirtualDevice{Key: 4000},
+		}}},
+	}
+
+	spec, err := isoImagesAndBootOrderConfigSpec(devices,
+		[]string{"[datastore1] iso/config.iso", "[datastore2] installer.iso"},
+		[]string{"cdrom", "disk", "ethernet"})
+	if err != nil {
+		t.Fatalf("failed to create config spec: %v", err)
+	}
+
+	if len(spec.DeviceChange) != 2 {
+		t.Fatalf("expected a cdrom to be added for each iso image, got %d device changes", len(spec.DeviceChange))
+	}
+	expectedCdroms := []struct {
+		iso           string
+		controllerKey int32
+		unitNumber    int32
+	}{
+		// The first IDE controller has one free slot left
+		{iso: "[datastore1] iso/config.iso", controllerKey: 200, unitNumber: 1},
+		{iso: "[datastore2] installer.iso", controllerKey: 201, unitNumber: 0},
+	}
+	keys := map[int32]bool{}
+	for i, expected := range expectedCdroms {
+		deviceSpec := spec.DeviceChange[i].GetVirtualDeviceConfigSpec()
+		if deviceSpec.Operation != types.VirtualDeviceConfigSpecOperationAdd {
+			t.Errorf("expected cdrom %d to be added, got operation %q", i, deviceSpec.Operation)
+		}
+		cdrom, ok := deviceSpec.Device.(*types.VirtualCdrom)
+		if !ok {
+			t.Fatalf("expected device %d to be a cdrom, got %T", i, deviceSpec.Device)
+		}
+		backing, ok := cdrom.Backing.(*types.VirtualCdromIsoBackingInfo)
+		if !ok {
+			t.Fatalf("expected cdrom %d to be backed by an iso, got %T", i, cdrom.Backing)
+		}
+		if backing.FileName != expected.iso {
+			t.Errorf("expected cdrom %d to be backed by %q, got %q", i, expected.iso, backing.FileName)
+		}
+		if cdrom.ControllerKey != expected.controllerKey || *cdrom.UnitNumber != expected.unitNumber {
+			t.Errorf("expected cdrom %d at controller %d unit %d, got controller %d unit %d",
+				i, expected.controllerKey, expected.unitNumber, cdrom.ControllerKey, *cdrom.UnitNumber)
+		}
+		if !cdrom.Connectable.StartConnected {
+			t.Errorf("expected cdrom %d to be connected on power on", i)
+		}
+		if cdrom.Key >= 0 || keys[cdrom.Key] {
+			t.Errorf("expected cdrom %d to have a unique temporary key, got %d", i, cdrom.Key)
+		}
+		keys[cdrom.Key] = true
+	}
+
+	if spec.BootOptions == nil {
+		t.Fatal("expected boot options to be set")
+	}
+	expectedBootOrder := []types.BaseVirtualMachineBootOptionsBootableDevice{
+		&types.VirtualMachineBootOptionsBootableCdromDevice{},
+		&types.VirtualMachineBootOptionsBootableDiskDevice{DeviceKey: 2000},
+		&types.VirtualMachineBootOptionsBootableEthernetDevice{DeviceKey: 4000},
+	}
+	if diff := deep.Equal(spec.BootOptions.BootOrder, expectedBootOrder); diff != nil {
+		t.Errorf("unexpected boot order, diff: %v", diff)
+	}
+}
+
+func TestISOImagesAndBootOrderConfigSpecWithoutFreeIDESlot(t *testing.T) {
+	devices := object.VirtualDeviceList{
+		&types.VirtualIDEController{VirtualController: types.VirtualController{
+			VirtualDevice: types.VirtualDevice{Key: 200},
+			Device:        []int32{3000, 3001},
+		}},
+	}
+
+	if _, err := isoImagesAndBootOrderConfigSpec(devices, []string{"[datastore1] config.iso"}, nil); err == nil {
+		t.Error("expected an error when no IDE controller has a free slot")
+	}
+}
+
+func TestValidateBootOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		bootOrder []string
+		wantErr   bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:      "all device types",
+			bootOrder: []string{"ethernet", "cdrom", "disk"},
+		},
+		{
+			name:      "floppy",
+			bootOrder: []string{"floppy", "disk"},
+			wantErr:   true,
+		},
+		{
+			name:      "duplicate",
+			bootOrder: []string{"disk", "disk"},
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateBootOrder(test.bootOrder)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -21,12 +21,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
 
 	"github.com/golang/glog"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -35,9 +38,13 @@ import (
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/common/clientcert"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
@@ -84,12 +91,49 @@ type RawConfig struct {
 	MemoryMB        int64                          `json:"memoryMB"`
 	DiskSizeGB      *int64                         `json:"diskSizeGB"`
 	AllowInsecure   providerconfig.ConfigVarBool   `json:"allowInsecure"`
+	// StorageNetName is an optional network the vm gets an additional NIC in, to carry the storage traffic
+	StorageNetName providerconfig.ConfigVarString `json:"storageNetName,omitempty"`
+	// PEM encoded CA bundle and client certificate for vCenter endpoints which require mutual TLS
+	CACert     providerconfig.ConfigVarString `json:"caCert,omitempty"`
+	ClientCert providerconfig.ConfigVarString `json:"clientCert,omitempty"`
+	ClientKey  providerconfig.ConfigVarString `json:"clientKey,omitempty"`
+
+	// Overrides for the generated cloud-config, allows node pools to use different settings
+	CloudConfig *RawCloudConfig `json:"cloudConfig,omitempty"`
+
+	// ISO images from a datastore which get attached as additional cd-rom drives
+	ISOImages []RawISOImage `json:"isoImages,omitempty"`
+	// Device types the vm boots from in the given order, valid are cdrom, disk and ethernet
+	BootOrder []string `json:"bootOrder,omitempty"`
+}
+
+// RawISOImage references an ISO image in a datastore
+type RawISOImage struct {
+	// Datastore defaults to the datastore of the machine
+	Datastore string `json:"datastore,omitempty"`
+	Path      string `json:"path"`
+}
+
+// RawCloudConfig contains the sections of the cloud-config which can be set per MachineDeployment
+type RawCloudConfig struct {
+	Workspace *RawWorkspaceOpts `json:"workspace,omitempty"`
+	Disk      *RawDiskOpts      `json:"disk,omitempty"`
+}
+
+type RawWorkspaceOpts struct {
+	DefaultDatastore string `json:"defaultDatastore,omitempty"`
+	ResourcePoolPath string `json:"resourcePoolPath,omitempty"`
+}
+
+type RawDiskOpts struct {
+	SCSIControllerType string `json:"scsiControllerType,omitempty"`
 }
 
 type Config struct {
 	TemplateVMName  string
 	TemplateNetName string
 	VMNetName       string
+	StorageNetName  string
 	Username        string
 	Password        string
 	VSphereURL      string
@@ -98,16 +142,31 @@ type Config struct {
 	Folder          string
 	Datastore       string
 	AllowInsecure   bool
+	TLS             clientcert.Config
 	CPUs            int32
 	MemoryMB        int64
 	DiskSizeGB      *int64
+	CloudConfig     *RawCloudConfig
+	ISOImages       []RawISOImage
+	BootOrder       []string
+}
+
+// isoImagePaths returns the datastore paths of the configured ISO images
+func (c *Config) isoImagePaths() []string {
+	var paths []string
+	for _, iso := range c.ISOImages {
+		path := object.DatastorePath{Datastore: iso.Datastore, Path: iso.Path}
+		paths = append(paths, path.String())
+	}
+	return paths
 }
 
 type Server struct {
 	name      string
 	id        string
+	uuid      string
 	status    instance.Status
-	addresses []string
+	addresses map[string]corev1.NodeAddressType
 }
 
 func (vsphereServer Server) Name() string {
@@ -118,7 +177,12 @@ func (vsphereServer Server) ID() string {
 	return vsphereServer.id
 }
 
-func (vsphereServer Server) Addresses() []string {
+// ProviderID returns the BIOS UUID of the VM, the vSphere cloud provider uses it instead of the managed object ID
+func (vsphereServer Server) ProviderID() string {
+	return vsphereServer.uuid
+}
+
+func (vsphereServer Server) Addresses() map[string]corev1.NodeAddressType {
 	return vsphereServer.addresses
 }
 
@@ -138,7 +202,7 @@ func (p *provider) AddDefaults(spec v1alpha1.MachineSpec) (v1alpha1.MachineSpec,
 	// default templatenetname to network of template if none specific was given and only one adapter exists.
 	if cfg.TemplateNetName == "" && cfg.VMNetName != "" {
 		ctx := context.TODO()
-		client, err := getClient(cfg.Username, cfg.Password, cfg.VSphereURL, cfg.AllowInsecure)
+		client, err := getClient(cfg)
 		if err != nil {
 			return spec, fmt.Errorf("failed to get vsphere client: '%v'", err)
 		}
@@ -202,14 +266,49 @@ func setProviderSpec(rawConfig RawConfig, s v1alpha1.ProviderSpec) (*runtime.Raw
 	return &runtime.RawExtension{Raw: rawPconfig}, nil
 }
 
-func getClient(username, password, address string, allowInsecure bool) (*govmomi.Client, error) {
-	clientURL, err := url.Parse(fmt.Sprintf("%s/sdk", address))
+func getClient(c *Config) (*govmomi.Client, error) {
+	clientURL, err := url.Parse(fmt.Sprintf("%s/sdk", c.VSphereURL))
 	if err != nil {
 		return nil, err
 	}
-	clientURL.User = url.UserPassword(username, password)
+	clientURL.User = url.UserPassword(c.Username, c.Password)
+
+	if !c.TLS.IsSet() {
+		return govmomi.NewClient(context.TODO(), clientURL, c.AllowInsecure)
+	}
 
-	return govmomi.NewClient(context.TODO(), clientURL, allowInsecure)
+	soapClient, err := newSoapClient(clientURL, c)
+	if err != nil {
+		return nil, err
+	}
+	vimClient, err := vim25.NewClient(context.TODO(), soapClient)
+	if err != nil {
+		return nil, err
+	}
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err := client.Login(context.TODO(), clientURL.User); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// newSoapClient returns a soap client which uses the configured CA and client certificate
+func newSoapClient(clientURL *url.URL, c *Config) (*soap.Client, error) {
+	tlsConfig, err := c.TLS.TLSConfig(c.AllowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	soapClient := soap.NewClient(clientURL, c.AllowInsecure)
+	transport, ok := soapClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transport %T of soap client", soapClient.Transport)
+	}
+	transport.TLSClientConfig.RootCAs = tlsConfig.RootCAs
+	transport.TLSClientConfig.Certificates = tlsConfig.Certificates
+	return soapClient, nil
 }
 
 func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.Config, *RawConfig, error) {
@@ -244,6 +343,11 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 		return nil, nil, nil, err
 	}
 
+	c.StorageNetName, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.StorageNetName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	c.Username, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawConfig.Username, "VSPHERE_USERNAME")
 	if err != nil {
 		return nil, nil, nil, err
@@ -284,9 +388,30 @@ func (p *provider) getConfig(s v1alpha1.ProviderSpec) (*Config, *providerconfig.
 		return nil, nil, nil, err
 	}
 
+	c.TLS.CACert, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.CACert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.TLS.ClientCert, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ClientCert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.TLS.ClientKey, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ClientKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	c.CPUs = rawConfig.CPUs
 	c.MemoryMB = rawConfig.MemoryMB
 	c.DiskSizeGB = rawConfig.DiskSizeGB
+	c.CloudConfig = rawConfig.CloudConfig
+	c.BootOrder = rawConfig.BootOrder
+	for _, iso := range rawConfig.ISOImages {
+		if iso.Datastore == "" {
+			iso.Datastore = c.Datastore
+		}
+		c.ISOImages = append(c.ISOImages, iso)
+	}
 
 	return &c, &pconfig, &rawConfig, nil
 }
@@ -303,11 +428,29 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return errors.New("specified target network (VMNetName) in cluster, but no source network (TemplateNetName) in machine")
 	}
 
+	if config.StorageNetName != "" && (config.StorageNetName == config.VMNetName || config.StorageNetName == config.TemplateNetName) {
+		return fmt.Errorf("storage network %q must differ from the network of the vm", config.StorageNetName)
+	}
+
 	if config.CPUs > 8 {
 		return errors.New("number of CPUs must not be greater than 8")
 	}
 
-	client, err := getClient(config.Username, config.Password, config.VSphereURL, config.AllowInsecure)
+	if err := config.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+
+	if err := validateBootOrder(config.BootOrder); err != nil {
+		return err
+	}
+
+	for _, iso := range config.ISOImages {
+		if iso.Path == "" {
+			return errors.New("the path of an iso image must not be empty")
+		}
+	}
+
+	client, err := getClient(config)
 	if err != nil {
 		return fmt.Errorf("failed to get vsphere client: '%v'", err)
 	}
@@ -326,6 +469,16 @@ func (p *provider) Validate(spec v1alpha1.MachineSpec) error {
 		return fmt.Errorf("failed to get datastore %s: %v", config.Datastore, err)
 	}
 
+	for _, iso := range config.ISOImages {
+		datastore, err := finder.Datastore(ctx, iso.Datastore)
+		if err != nil {
+			return fmt.Errorf("failed to get datastore %s of iso image %s: %v", iso.Datastore, iso.Path, err)
+		}
+		if _, err := datastore.Stat(ctx, iso.Path); err != nil {
+			return fmt.Errorf("failed to find iso image %s in datastore %s: %v", iso.Path, iso.Datastore, err)
+		}
+	}
+
 	if _, err := finder.ClusterComputeResource(ctx, config.Cluster); err != nil {
 		return fmt.Errorf("failed to get cluster: %s: %v", config.Cluster, err)
 	}
@@ -368,7 +521,7 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		return nil, fmt.Errorf("failed to parse config: %v", err)
 	}
 
-	client, err := getClient(config.Username, config.Password, config.VSphereURL, config.AllowInsecure)
+	client, err := getClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get vsphere client: '%v'", err)
 	}
@@ -378,9 +531,12 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		}
 	}()
 
-	var containerLinuxUserdata string
-	if pc.OperatingSystem == providerconfig.OperatingSystemCoreos {
+	var containerLinuxUserdata, bottlerocketUserdata string
+	switch pc.OperatingSystem {
+	case providerconfig.OperatingSystemCoreos:
 		containerLinuxUserdata = userdata
+	case providerconfig.OperatingSystemBottlerocket:
+		bottlerocketUserdata = userdata
 	}
 
 	finder := find.NewFinder(client.Client, true)
@@ -395,12 +551,13 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		config,
 		dc,
 		finder,
-		containerLinuxUserdata)
+		containerLinuxUserdata,
+		bottlerocketUserdata)
 	if err != nil {
 		return nil, machineInvalidConfigurationTerminalError(fmt.Errorf("failed to create cloned vm: '%v'", err))
 	}
 
-	if pc.OperatingSystem != providerconfig.OperatingSystemCoreos {
+	if usesUserdataISO(pc.OperatingSystem) {
 		localUserdataIsoFilePath, err := generateLocalUserdataISO(userdata, machine.Spec.Name)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate local userdadata iso: %v", err)
@@ -426,6 +583,18 @@ func (p *provider) Create(machine *v1alpha1.Machine, _ *cloudprovidertypes.Machi
 		}
 	}
 
+	if err := attachISOImagesAndSetBootOrder(ctx, virtualMachine, config.isoImagePaths(), config.BootOrder); err != nil {
+		// Destroy VM to avoid a leftover.
+		destroyTask, vmErr := virtualMachine.Destroy(ctx)
+		if vmErr != nil {
+			return nil, fmt.Errorf("failed to destroy vm %s after failing to attach iso images: %v / %v", virtualMachine.Name(), err, vmErr)
+		}
+		if vmErr := destroyTask.Wait(ctx); vmErr != nil {
+			return nil, fmt.Errorf("failed to destroy vm %s after failing to attach iso images: %v / %v", virtualMachine.Name(), err, vmErr)
+		}
+		return nil, fmt.Errorf("failed to attach iso images and set boot order: %v", err)
+	}
+
 	powerOnTask, err := virtualMachine.PowerOn(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to power on machine: %v", err)
@@ -472,7 +641,7 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, data *cloudprovidertypes.M
 		return false, fmt.Errorf("failed to parse config: %v", err)
 	}
 
-	client, err := getClient(config.Username, config.Password, config.VSphereURL, config.AllowInsecure)
+	client, err := getClient(config)
 	if err != nil {
 		return false, fmt.Errorf("failed to get vsphere client: '%v'", err)
 	}
@@ -549,7 +718,7 @@ func (p *provider) Cleanup(machine *v1alpha1.Machine, data *cloudprovidertypes.M
 		return false, fmt.Errorf("failed to destroy vm %s: %v", virtualMachine.Name(), err)
 	}
 
-	if pc.OperatingSystem != providerconfig.OperatingSystemCoreos {
+	if usesUserdataISO(pc.OperatingSystem) {
 		datastore, err := finder.Datastore(ctx, config.Datastore)
 		if err != nil {
 			return false, fmt.Errorf("failed to get datastore %s: %v", config.Datastore, err)
@@ -576,7 +745,7 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 		return nil, fmt.Errorf("failed to parse config: %v", err)
 	}
 
-	client, err := getClient(config.Username, config.Password, config.VSphereURL, config.AllowInsecure)
+	client, err := getClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get vsphere client: '%v'", err)
 	}
@@ -598,10 +767,15 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 		return nil, fmt.Errorf("failed to get server: %v", err)
 	}
 
-	powerState, err := virtualMachine.PowerState(ctx)
-	if err != nil {
+	var vmProperties mo.VirtualMachine
+	if err := property.DefaultCollector(client.Client).RetrieveOne(ctx, virtualMachine.Reference(), []string{"runtime.powerState", "config.uuid"}, &vmProperties); err != nil {
 		return nil, fmt.Errorf("failed to get powerstate: %v", err)
 	}
+	powerState := vmProperties.Runtime.PowerState
+	var uuid string
+	if vmProperties.Config != nil {
+		uuid = vmProperties.Config.Uuid
+	}
 
 	// Check if the creationCompleteFieldName is set to machine.Spec.Name.
 	// If that is not the case, the creation didn't complete successfully and
@@ -633,7 +807,7 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 			return nil, fmt.Errorf("failed to wait for deletion of instance %q whose creation didn't complete: %v",
 				virtualMachine.Name(), err)
 		}
-		if pc.OperatingSystem != providerconfig.OperatingSystemCoreos {
+		if usesUserdataISO(pc.OperatingSystem) {
 			datastore, err := finder.Datastore(ctx, config.Datastore)
 			if err != nil {
 				return nil, fmt.Errorf("failed to get datastore %s for instance %q whose creation didn't complete: %v",
@@ -667,7 +841,7 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 	}
 
 	// virtualMachine.IsToolsRunning panics when executed on a VM that is not powered on
-	addresses := []string{}
+	addresses := map[string]corev1.NodeAddressType{}
 	if powerState == types.VirtualMachinePowerStatePoweredOn {
 		isGuestToolsRunning, err := virtualMachine.IsToolsRunning(context.TODO())
 		if err != nil {
@@ -684,7 +858,7 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 				for _, address := range nic.IpAddress {
 					// Exclude ipv6 link-local addresses and default Docker bridge
 					if !strings.HasPrefix(address, "fe80:") && !strings.HasPrefix(address, "172.17.") {
-						addresses = append(addresses, address)
+						addresses[address] = corev1.NodeInternalIP
 					}
 				}
 			}
@@ -693,7 +867,7 @@ func (p *provider) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 		}
 	}
 
-	return Server{name: virtualMachine.Name(), status: status, addresses: addresses, id: virtualMachine.Reference().Value}, nil
+	return Server{name: virtualMachine.Name(), status: status, addresses: addresses, id: virtualMachine.Reference().Value, uuid: uuid}, nil
 }
 
 func (p *provider) MigrateUID(machine *v1alpha1.Machine, new ktypes.UID) error {
@@ -748,6 +922,7 @@ func (p *provider) GetCloudConfig(spec v1alpha1.MachineSpec) (config string, nam
 			},
 		},
 	}
+	applyCloudConfigOverrides(cc, c.CloudConfig)
 
 	s, err := CloudConfigToString(cc)
 	if err != nil {
@@ -773,3 +948,14 @@ func (p *provider) MachineMetricsLabels(machine *v1alpha1.Machine) (map[string]s
 func (p *provider) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return nil
 }
+
+func (p *provider) MachineCapacity(spec v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	c, _, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	return &cloudprovidertypes.MachineCapacity{
+		CPU:    *resource.NewQuantity(int64(c.CPUs), resource.DecimalSI),
+		Memory: *resource.NewQuantity(c.MemoryMB*1024*1024, resource.BinarySI),
+	}, nil
+}
@@ -19,6 +19,7 @@ package types
 import (
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 	listerscorev1 "k8s.io/client-go/listers/core/v1"
 
@@ -71,6 +72,64 @@ type Provider interface {
 	// SetMetricsForMachines allows providers to provide provider-specific metrics. This may be implemented
 	// as no-op
 	SetMetricsForMachines(machines clusterv1alpha1.MachineList) error
+
+	// MachineCapacity returns the resources of the instance the given spec results in.
+	// If the capacity is unknown, nil is returned.
+	// This should not do any api calls to the cloud provider
+	MachineCapacity(spec clusterv1alpha1.MachineSpec) (*MachineCapacity, error)
+}
+
+// CredentialsValidator is implemented by providers which can check the credentials of a machine spec
+type CredentialsValidator interface {
+	// ValidateCredentials does a cheap, read-only API call with the credentials of the given spec and
+	// returns an error if they are invalid or lack permissions
+	ValidateCredentials(spec clusterv1alpha1.MachineSpec) error
+}
+
+// InstanceResizer is implemented by providers which can change the instance type of an existing instance
+type InstanceResizer interface {
+	// OnlyInstanceTypeChanged returns true if the new spec only changes the instance type of the old spec
+	OnlyInstanceTypeChanged(old, new clusterv1alpha1.MachineSpec) (bool, error)
+
+	// InstanceTypes returns the instance type of the existing instance of the machine and the one its spec requests.
+	//
+	// In case the instance cannot be found, github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceNotFound will be returned
+	InstanceTypes(machine *clusterv1alpha1.Machine) (current string, desired string, err error)
+
+	// Resize changes the instance type of the existing instance of the machine to the one its spec requests,
+	// stopping and starting the instance if the cloud provider requires it.
+	// It gets called again until it returns true, which means the instance runs with the requested instance type
+	Resize(machine *clusterv1alpha1.Machine) (bool, error)
+}
+
+// InstanceTyper is implemented by providers whose instances have an instance type
+type InstanceTyper interface {
+	// InstanceType returns the instance type the given spec requests, e.g. m5.large on AWS.
+	// This should not do any api calls to the cloud provider
+	InstanceType(spec clusterv1alpha1.MachineSpec) (string, error)
+}
+
+// InstanceStarter is implemented by providers which can stop the instance of a machine and start it again later,
+// which is needed to keep stopped instances in a warm pool
+type InstanceStarter interface {
+	// StopInstance stops the instance of the machine. It gets called again until it returns true, which means the
+	// instance is stopped.
+	//
+	// In case the instance cannot be found, github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceNotFound will be returned
+	StopInstance(machine *clusterv1alpha1.Machine) (bool, error)
+
+	// StartInstance starts the stopped instance of the machine. It gets called again until it returns true, which
+	// means the instance is running.
+	//
+	// In case the instance cannot be found, github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceNotFound will be returned
+	StartInstance(machine *clusterv1alpha1.Machine) (bool, error)
+}
+
+// MachineCapacity describes the resources of a cloud provider instance
+type MachineCapacity struct {
+	CPU    resource.Quantity
+	Memory resource.Quantity
+	GPUs   int
 }
 
 // MachineUpdater defines a function to persist an update to a machine
@@ -23,18 +23,32 @@ import (
 
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	"k8s.io/apimachinery/pkg/types"
 
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
 type cachingValidationWrapper struct {
-	actualProvider cloudprovidertypes.Provider
+	actualProvider    cloudprovidertypes.Provider
+	configVarResolver *providerconfig.ConfigVarResolver
 }
 
-// NewValidationCacheWrappingCloudProvider returns a wrapped cloudprovider
-func NewValidationCacheWrappingCloudProvider(actualProvider cloudprovidertypes.Provider) cloudprovidertypes.Provider {
-	return &cachingValidationWrapper{actualProvider: actualProvider}
+// NewValidationCacheWrappingCloudProvider returns a wrapped cloudprovider which implements the same optional
+// interfaces as actualProvider. The configVarResolver is used to look up the versions of the secrets a spec
+// references, so cached results do not outlive a secret rotation
+func NewValidationCacheWrappingCloudProvider(actualProvider cloudprovidertypes.Provider, configVarResolver *providerconfig.ConfigVarResolver) cloudprovidertypes.Provider {
+	wrapper := &cachingValidationWrapper{actualProvider: actualProvider, configVarResolver: configVarResolver}
+	return withCapabilitiesOf(wrapper, actualProvider)
+}
+
+// secretVersions returns the fingerprint of the secrets the spec references
+func (w *cachingValidationWrapper) secretVersions(spec v1alpha1.MachineSpec) (string, error) {
+	versions, err := w.configVarResolver.GetSecretVersions(spec.ProviderSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the versions of the referenced secrets: %v", err)
+	}
+	return providerconfig.SecretVersionsFingerprint(versions), nil
 }
 
 // AddDefaults just calls the underlying cloudproviders AddDefaults
@@ -45,7 +59,11 @@ func (w *cachingValidationWrapper) AddDefaults(spec v1alpha1.MachineSpec) (v1alp
 // Validate tries to get the validation result from the cache and if not found, calls the
 // cloudproviders Validate and saves that to the cache
 func (w *cachingValidationWrapper) Validate(spec v1alpha1.MachineSpec) error {
-	result, exists, err := cache.Get(spec)
+	secretVersions, err := w.secretVersions(spec)
+	if err != nil {
+		return err
+	}
+	result, exists, err := cache.Get(spec, secretVersions)
 	if err != nil {
 		return fmt.Errorf("error getting validation result from cache: %v", err)
 	}
@@ -56,13 +74,41 @@ func (w *cachingValidationWrapper) Validate(spec v1alpha1.MachineSpec) error {
 
 	glog.V(6).Infof("Got cache miss for validation")
 	err = w.actualProvider.Validate(spec)
-	if err := cache.Set(spec, err); err != nil {
+	if err := cache.Set(spec, secretVersions, err); err != nil {
 		return fmt.Errorf("failed to set cache after validation: %v", err)
 	}
 
 	return err
 }
 
+// ValidateCredentials tries to get the credentials validation result from the cache and if not found, calls the
+// cloudproviders ValidateCredentials and saves that to the cache
+func (w *cachingValidationWrapper) ValidateCredentials(spec v1alpha1.MachineSpec) error {
+	validator, ok := w.actualProvider.(cloudprovidertypes.CredentialsValidator)
+	if !ok {
+		return nil
+	}
+
+	secretVersions, err := w.secretVersions(spec)
+	if err != nil {
+		return err
+	}
+	result, exists, err := credentialsCache.Get(spec, secretVersions)
+	if err != nil {
+		return fmt.Errorf("error getting credentials validation result from cache: %v", err)
+	}
+	if exists {
+		return result
+	}
+
+	err = validator.ValidateCredentials(spec)
+	if err := credentialsCache.Set(spec, secretVersions, err); err != nil {
+		return fmt.Errorf("failed to set cache after credentials validation: %v", err)
+	}
+
+	return err
+}
+
 // Get just calls the underlying cloudproviders Get
 func (w *cachingValidationWrapper) Get(machine *v1alpha1.Machine) (instance.Instance, error) {
 	return w.actualProvider.Get(machine)
@@ -96,3 +142,62 @@ func (w *cachingValidationWrapper) MachineMetricsLabels(machine *v1alpha1.Machin
 func (w *cachingValidationWrapper) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return w.actualProvider.SetMetricsForMachines(machines)
 }
+
+// MachineCapacity just calls the underlying cloudproviders MachineCapacity
+func (w *cachingValidationWrapper) MachineCapacity(spec v1alpha1.MachineSpec) (*cloudprovidertypes.MachineCapacity, error) {
+	return w.actualProvider.MachineCapacity(spec)
+}
+
+// InstanceType just calls the underlying cloudproviders InstanceType
+func (w *cachingValidationWrapper) InstanceType(spec v1alpha1.MachineSpec) (string, error) {
+	typer, ok := w.actualProvider.(cloudprovidertypes.InstanceTyper)
+	if !ok {
+		return "", nil
+	}
+	return typer.InstanceType(spec)
+}
+
+// OnlyInstanceTypeChanged just calls the underlying cloudproviders OnlyInstanceTypeChanged
+func (w *cachingValidationWrapper) OnlyInstanceTypeChanged(old, new v1alpha1.MachineSpec) (bool, error) {
+	resizer, ok := w.actualProvider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return false, nil
+	}
+	return resizer.OnlyInstanceTypeChanged(old, new)
+}
+
+// InstanceTypes just calls the underlying cloudproviders InstanceTypes
+func (w *cachingValidationWrapper) InstanceTypes(machine *v1alpha1.Machine) (string, string, error) {
+	resizer, ok := w.actualProvider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return "", "", nil
+	}
+	return resizer.InstanceTypes(machine)
+}
+
+// Resize calls the underlying cloudproviders Resize
+func (w *cachingValidationWrapper) Resize(machine *v1alpha1.Machine) (bool, error) {
+	resizer, ok := w.actualProvider.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return false, fmt.Errorf("resizing instances is not supported")
+	}
+	return resizer.Resize(machine)
+}
+
+// StopInstance calls the underlying cloudproviders StopInstance
+func (w *cachingValidationWrapper) StopInstance(machine *v1alpha1.Machine) (bool, error) {
+	starter, ok := w.actualProvider.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("stopping instances is not supported")
+	}
+	return starter.StopInstance(machine)
+}
+
+// StartInstance calls the underlying cloudproviders StartInstance
+func (w *cachingValidationWrapper) StartInstance(machine *v1alpha1.Machine) (bool, error) {
+	starter, ok := w.actualProvider.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("starting instances is not supported")
+	}
+	return starter.StartInstance(machine)
+}
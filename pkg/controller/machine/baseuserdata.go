@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// BaseUserData is an org-wide cloud-config which gets merged into the rendered cloud-config of every machine.
+// Its files get written before the files of the machine, so the machine wins if both write the same path.
+// Its commands run before the commands of the machine.
+type BaseUserData struct {
+	WriteFiles []interface{} `json:"write_files,omitempty"`
+	RunCmd     []interface{} `json:"runcmd,omitempty"`
+}
+
+// NewBaseUserData loads the BaseUserData from the given cloud-config file. Only write_files and runcmd
+// are supported. nil is returned if no file is given
+func NewBaseUserData(file string) (*BaseUserData, error) {
+	if file == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	jsonContent, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	b := &BaseUserData{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonContent))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(b); err != nil {
+		return nil, fmt.Errorf("invalid base userdata in %s, only write_files and runcmd are supported: %v", file, err)
+	}
+	return b, nil
+}
+
+// merge merges the base userdata into the given userdata. Userdata which is not a cloud-config,
+// e.g. Ignition, is returned unchanged
+func (b *BaseUserData) merge(userdata string) (string, error) {
+	if !strings.HasPrefix(userdata, cloudConfigHeader) {
+		return userdata, nil
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(userdata), &config); err != nil {
+		return "", fmt.Errorf("failed to parse cloud-config: %v", err)
+	}
+	if err := mergeCloudConfigList(config, "write_files", b.WriteFiles); err != nil {
+		return "", err
+	}
+	if err := mergeCloudConfigList(config, "runcmd", b.RunCmd); err != nil {
+		return "", err
+	}
+
+	merged, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cloud-config: %v", err)
+	}
+	return cloudConfigHeader + "\n" + string(merged), nil
+}
+
+// mergeCloudConfigList prepends the base entries to the list with the given key
+func mergeCloudConfigList(config map[string]interface{}, key string, base []interface{}) error {
+	if len(base) == 0 {
+		return nil
+	}
+	var entries []interface{}
+	if value, exists := config[key]; exists && value != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected %s of the cloud-config to be a list, got %T", key, value)
+		}
+		entries = list
+	}
+	config[key] = append(append([]interface{}{}, base...), entries...)
+	return nil
+}
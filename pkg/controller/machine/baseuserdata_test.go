@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeBaseUserData(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "base-userdata")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("failed to write base userdata: %v", err)
+	}
+	return file.Name()
+}
+
+func TestBaseUserDataMerge(t *testing.T) {
+	file := writeBaseUserData(t, `#cloud-config
+write_files:
+- path: /etc/org/motd
+  content: managed by the platform team
+runcmd:
+- echo base
+`)
+	defer os.Remove(file)
+
+	base, err := NewBaseUserData(file)
+	if err != nil {
+		t.Fatalf("failed to load base userdata: %v", err)
+	}
+
+	merged, err := base.merge(`#cloud-config
+hostname: node1
+write_files:
+- path: /etc/kubernetes/kubelet.conf
+  content: kubelet
+runcmd:
+- systemctl enable --now kubelet
+`)
+	if err != nil {
+		t.Fatalf("failed to merge base userdata: %v", err)
+	}
+
+	if !strings.HasPrefix(merged, "#cloud-config\n") {
+		t.Errorf("expected the merged userdata to be a cloud-config, got:\n%s", merged)
+	}
+	if !strings.Contains(merged, "hostname: node1") {
+		t.Errorf("expected the merged userdata to keep the hostname, got:\n%s", merged)
+	}
+	for _, ordered := range [][]string{
+		{"path: /etc/org/motd", "path: /etc/kubernetes/kubelet.conf"},
+		{"- echo base", "- systemctl enable --now kubelet"},
+	} {
+		first, second := strings.Index(merged, ordered[0]), strings.Index(merged, ordered[1])
+		if first == -1 || second == -1 || first > second {
+			t.Errorf("expected %q before %q in the merged userdata, got:\n%s", ordered[0], ordered[1], merged)
+		}
+	}
+}
+
+func TestBaseUserDataMergeSkipsNonCloudConfig(t *testing.T) {
+	base := &BaseUserData{RunCmd: []interface{}{"echo base"}}
+	ignition := `{"ignition":{"version":"2.2.0"}}`
+	merged, err := base.merge(ignition)
+	if err != nil {
+		t.Fatalf("failed to merge base userdata: %v", err)
+	}
+	if merged != ignition {
+		t.Errorf("expected userdata which is not a cloud-config to be unchanged, got:\n%s", merged)
+	}
+}
+
+func TestNewBaseUserDataRejectsUnsupportedKeys(t *testing.T) {
+	file := writeBaseUserData(t, "#cloud-config\npackages:\n- vim\n")
+	defer os.Remove(file)
+
+	if _, err := NewBaseUserData(file); err == nil {
+		t.Error("expected an error for a base userdata with packages")
+	}
+}
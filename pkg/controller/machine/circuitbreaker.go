@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const apiServerProbePeriod = 5 * time.Second
+
+// APIServerCircuitBreaker pauses the reconciliation of all machines while the apiserver is unreachable.
+// The listers keep serving their last state during an outage, acting on it could e.g. delete the instances
+// and remove the finalizers of machines based on nodes which are long gone from the cache.
+type APIServerCircuitBreaker struct {
+	probe     func() error
+	threshold time.Duration
+
+	lock             sync.Mutex
+	unreachableSince time.Time
+	open             bool
+}
+
+// NewAPIServerCircuitBreaker returns an APIServerCircuitBreaker which opens once the apiserver is unreachable
+// for the threshold and closes as soon as it is reachable again. nil is returned if the threshold is not set
+func NewAPIServerCircuitBreaker(kubeClient kubernetes.Interface, threshold time.Duration) *APIServerCircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &APIServerCircuitBreaker{
+		probe: func() error {
+			_, err := kubeClient.Discovery().ServerVersion()
+			return err
+		},
+		threshold: threshold,
+	}
+}
+
+// run probes the apiserver until the stop channel gets closed
+func (b *APIServerCircuitBreaker) run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		b.record(b.probe(), time.Now())
+	}, apiServerProbePeriod, stopCh)
+}
+
+// record updates the state with the result of a probe at the given time
+func (b *APIServerCircuitBreaker) record(err error, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		if b.open {
+			glog.Infof("The apiserver is reachable again, resuming the reconciliation of machines")
+		}
+		b.unreachableSince = time.Time{}
+		b.open = false
+		return
+	}
+
+	if b.unreachableSince.IsZero() {
+		b.unreachableSince = now
+	}
+	if !b.open && now.Sub(b.unreachableSince) >= b.threshold {
+		glog.Errorf("The apiserver is unreachable since %s, pausing the reconciliation of machines: %v", b.unreachableSince.Format(time.RFC3339), err)
+		b.open = true
+	}
+}
+
+// isOpen returns true while the reconciliation must be paused
+func (b *APIServerCircuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.open
+}
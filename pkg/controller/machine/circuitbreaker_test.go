@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	machinefake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+)
+
+var errAPIServerUnreachable = errors.New("dial tcp 10.96.0.1:443: connect: connection refused")
+
+func TestAPIServerCircuitBreaker(t *testing.T) {
+	breaker := &APIServerCircuitBreaker{threshold: time.Minute}
+	now := time.Now()
+
+	breaker.record(errAPIServerUnreachable, now)
+	breaker.record(errAPIServerUnreachable, now.Add(30*time.Second))
+	if breaker.isOpen() {
+		t.Fatal("expected the circuit breaker to stay closed while the apiserver is unreachable for less than the threshold")
+	}
+	breaker.record(errAPIServerUnreachable, now.Add(time.Minute))
+	if !breaker.isOpen() {
+		t.Fatal("expected the circuit breaker to open once the apiserver is unreachable for the threshold")
+	}
+	breaker.record(nil, now.Add(90*time.Second))
+	if breaker.isOpen() {
+		t.Fatal("expected the circuit breaker to close once the apiserver is reachable again")
+	}
+	// The unreachability must be sustained again
+	breaker.record(errAPIServerUnreachable, now.Add(2*time.Minute))
+	if breaker.isOpen() {
+		t.Fatal("expected the circuit breaker to stay closed after a single failed probe")
+	}
+
+	var nilBreaker *APIServerCircuitBreaker
+	if nilBreaker.isOpen() {
+		t.Fatal("expected an unconfigured circuit breaker to never open")
+	}
+}
+
+func TestControllerPausesDeletionWhileAPIServerUnreachable(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "machine-1",
+			Namespace:         "kube-system",
+			Finalizers:        []string{FinalizerDeleteInstance, FinalizerDeleteNode},
+			DeletionTimestamp: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+		},
+		Spec: clusterv1alpha1.MachineSpec{
+			ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{"cloudProvider":"fake"}`)}},
+		},
+	}
+	breaker := &APIServerCircuitBreaker{threshold: time.Minute}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+	controller.skipEvictionAfter = time.Minute
+	controller.apiServerCircuitBreaker = breaker
+	defer controller.workqueue.ShutDown()
+
+	now := time.Now()
+	breaker.record(errAPIServerUnreachable, now.Add(-time.Minute))
+	breaker.record(errAPIServerUnreachable, now)
+
+	controller.enqueueMachine(machine)
+	controller.processNextWorkItem()
+	if actions := controller.machineClient.(*machinefake.Clientset).Actions(); len(actions) > 0 {
+		t.Fatalf("expected no changes of machines while the apiserver is unreachable, got %v", actions)
+	}
+	listerMachine, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listerMachine.Finalizers) != 2 {
+		t.Fatalf("expected the finalizers to be kept while the apiserver is unreachable, got %v", listerMachine.Finalizers)
+	}
+
+	if controller.workqueue.Len() != 0 {
+		t.Fatal("expected the machine to be requeued with a delay")
+	}
+
+	// The deletion continues once the apiserver is reachable again
+	breaker.record(nil, now.Add(apiServerProbePeriod))
+	controller.enqueueMachine(machine)
+	controller.processNextWorkItem()
+	listerMachine, err = controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sets.NewString(listerMachine.Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Errorf("expected the instance to be deleted once the apiserver is reachable again, got finalizers %v", listerMachine.Finalizers)
+	}
+}
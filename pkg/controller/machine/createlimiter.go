@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+)
+
+const (
+	// AnnotationMaxConcurrentCreates caps the number of instances which get created simultaneously for a
+	// MachineDeployment. It has to be set on the machine template, so all machines of the deployment carry it.
+	AnnotationMaxConcurrentCreates = "machine-controller.kubermatic.io/max-concurrent-creates"
+
+	// createLimitRequeuePeriod is the time after which a machine which had to wait for a free create slot gets retried
+	createLimitRequeuePeriod = 10 * time.Second
+)
+
+// createLimiter tracks the instance creations in flight per MachineDeployment
+type createLimiter struct {
+	lock     sync.Mutex
+	inFlight map[string]int
+}
+
+func newCreateLimiter() *createLimiter {
+	return &createLimiter{inFlight: map[string]int{}}
+}
+
+// tryAcquire returns false if max creates are already in flight for the deployment
+func (l *createLimiter) tryAcquire(deployment string, max int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inFlight[deployment] >= max {
+		return false
+	}
+	l.inFlight[deployment]++
+	return true
+}
+
+func (l *createLimiter) release(deployment string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inFlight[deployment]--; l.inFlight[deployment] <= 0 {
+		delete(l.inFlight, deployment)
+	}
+}
+
+// acquireCreateSlot returns false if the machine has to wait for other creations of its MachineDeployment to finish.
+// The returned func must be called once the creation finished.
+func (c *Controller) acquireCreateSlot(machine *clusterv1alpha1.Machine) (func(), bool) {
+	value, exists := machine.Annotations[AnnotationMaxConcurrentCreates]
+	if !exists || c.createLimiter == nil {
+		return func() {}, true
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 1 {
+		c.recorder.Event(machine, corev1.EventTypeWarning, "InvalidMaxConcurrentCreates",
+			fmt.Sprintf("Ignoring %s annotation, it must be a positive number but is %q", AnnotationMaxConcurrentCreates, value))
+		return func() {}, true
+	}
+	deploymentName, ok := kuberneteshelper.MachineDeploymentName(machine)
+	if !ok {
+		return func() {}, true
+	}
+
+	deployment := machine.Namespace + "/" + deploymentName
+	if !c.createLimiter.tryAcquire(deployment, max) {
+		glog.V(3).Infof("Delaying the creation of machine %s, %d creations of MachineDeployment %s are in flight", machine.Name, max, deployment)
+		return nil, false
+	}
+	return func() { c.createLimiter.release(deployment) }, true
+}
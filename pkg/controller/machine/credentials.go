@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// validateAllCredentials validates the credentials of all machines, so invalid credentials get reported right
+// after the start instead of once a machine gets provisioned. The results are cached per provider spec,
+// so machines of the same MachineSet only cause one API call.
+func (c *Controller) validateAllCredentials() error {
+	machines, err := c.machinesLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list machines: %v", err)
+	}
+
+	var errs []error
+	for _, machine := range machines {
+		if machine.DeletionTimestamp != nil {
+			continue
+		}
+		machine = machine.DeepCopy()
+		if err := c.resolveProviderConfigTemplate(machine); err != nil {
+			errs = append(errs, fmt.Errorf("machine %s/%s: %v", machine.Namespace, machine.Name, err))
+			continue
+		}
+		providerConfig, err := providerconfig.GetConfig(machine.Spec.ProviderSpec)
+		if err != nil {
+			continue
+		}
+		prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, providerconfig.NewConfigVarResolver(c.kubeClient))
+		if err != nil {
+			continue
+		}
+		if err := c.ensureCredentialsValid(prov, machine); err != nil {
+			errs = append(errs, fmt.Errorf("machine %s/%s: %v", machine.Namespace, machine.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ensureCredentialsValid validates the cloud provider credentials of the machine if the provider supports it.
+// The result gets recorded in the CredentialsValid condition of the machine.
+func (c *Controller) ensureCredentialsValid(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) error {
+	if !c.validateCredentials {
+		return nil
+	}
+	validator, ok := prov.(cloudprovidertypes.CredentialsValidator)
+	if !ok {
+		return nil
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status: %v", err)
+	}
+	existing := providerStatus.GetCondition(providerconfig.CredentialsValidConditionType)
+
+	validationErr := validator.ValidateCredentials(machine.Spec)
+	condition := providerconfig.Condition{
+		Type:   providerconfig.CredentialsValidConditionType,
+		Status: corev1.ConditionTrue,
+		Reason: "CredentialsValid",
+	}
+	if validationErr != nil {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "InvalidCredentials"
+		condition.Message = validationErr.Error()
+		if existing == nil || existing.Status != corev1.ConditionFalse {
+			c.recorder.Eventf(machine, corev1.EventTypeWarning, "InvalidCredentials", "Cloud provider rejected the credentials: %v", validationErr)
+		}
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return err
+	}
+
+	if validationErr != nil {
+		return fmt.Errorf("invalid cloud provider credentials: %v", validationErr)
+	}
+	return nil
+}
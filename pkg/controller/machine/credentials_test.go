@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func fakeProviderMachine(name string, failCredentialsValidation bool) *clusterv1alpha1.Machine {
+	spec := fmt.Sprintf(`{"cloudProvider": "fake", "cloudProviderSpec": {"passValidation": true, "failCredentialsValidation": %t}}`, failCredentialsValidation)
+	return &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Spec: clusterv1alpha1.MachineSpec{
+			ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(spec)}},
+		},
+	}
+}
+
+func TestControllerValidatesCredentialsAtStartup(t *testing.T) {
+	validMachine := fakeProviderMachine("machine-valid", false)
+	invalidMachine := fakeProviderMachine("machine-invalid", true)
+
+	recorder := record.NewFakeRecorder(10)
+	controller := newTestController(t, []*clusterv1alpha1.Machine{validMachine, invalidMachine})
+	controller.recorder = recorder
+	controller.validateCredentials = true
+
+	err := controller.validateAllCredentials()
+	expectedErr := "machine kube-system/machine-invalid: invalid cloud provider credentials: failing credentials validation as requested"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+
+	for _, test := range []struct {
+		machine        *clusterv1alpha1.Machine
+		expectedStatus corev1.ConditionStatus
+	}{
+		{machine: validMachine, expectedStatus: corev1.ConditionTrue},
+		{machine: invalidMachine, expectedStatus: corev1.ConditionFalse},
+	} {
+		machine, err := controller.machineClient.ClusterV1alpha1().Machines(test.machine.Namespace).Get(test.machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := providerStatus.GetCondition(providerconfig.CredentialsValidConditionType)
+		if condition == nil || condition.Status != test.expectedStatus {
+			t.Errorf("expected credentials condition of machine %s with status %s, got %v", machine.Name, test.expectedStatus, condition)
+		}
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "InvalidCredentials") {
+			t.Errorf("expected an InvalidCredentials event, got %q", event)
+		}
+	default:
+		t.Error("expected an event for the invalid credentials")
+	}
+
+	// The event only gets recorded once the credentials became invalid
+	if err := controller.validateAllCredentials(); err == nil {
+		t.Fatal("expected the credentials to be invalid")
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no further event, got %q", <-recorder.Events)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// DaemonSetReadinessGate makes machines wait until the pods of all DaemonSets in the given namespaces, e.g. the CNI,
+// kube-proxy and CSI DaemonSets in kube-system, are ready on their node before they are considered provisioned.
+type DaemonSetReadinessGate struct {
+	namespaces []string
+}
+
+// NewDaemonSetReadinessGate returns the DaemonSetReadinessGate for the given comma separated list of namespaces.
+// nil is returned if the list is empty
+func NewDaemonSetReadinessGate(namespaces string) *DaemonSetReadinessGate {
+	g := &DaemonSetReadinessGate{}
+	for _, namespace := range strings.Split(namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			g.namespaces = append(g.namespaces, namespace)
+		}
+	}
+	if len(g.namespaces) == 0 {
+		return nil
+	}
+	return g
+}
+
+// daemonSetReadinessGatePassed tells if the pods of all DaemonSets of the gate which target the node are ready on it.
+// The result gets recorded in the DaemonSetsReady condition of the machine.
+func (c *Controller) daemonSetReadinessGatePassed(machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	if c.daemonSetReadinessGate == nil {
+		return true, nil
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status: %v", err)
+	}
+	// A passed gate is not checked again, a restart of a DaemonSet pod must not make a provisioned machine unready
+	if condition := providerStatus.GetCondition(providerconfig.DaemonSetsReadyConditionType); condition != nil && condition.Status == corev1.ConditionTrue {
+		return true, nil
+	}
+
+	pending, err := c.pendingDaemonSets(node)
+	if err != nil {
+		return false, err
+	}
+
+	condition := providerconfig.Condition{
+		Type:    providerconfig.DaemonSetsReadyConditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DaemonSetPodsReady",
+		Message: fmt.Sprintf("The pods of all DaemonSets in %s are ready on node %s", strings.Join(c.daemonSetReadinessGate.namespaces, ", "), node.Name),
+	}
+	if len(pending) > 0 {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "DaemonSetPodsNotReady"
+		condition.Message = fmt.Sprintf("Waiting for the pods of the DaemonSets %s to be ready on node %s", strings.Join(pending, ", "), node.Name)
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return false, err
+	}
+
+	if len(pending) > 0 {
+		c.enqueueMachineAfter(machine, readinessGateRecheckPeriod)
+		return false, nil
+	}
+	return true, nil
+}
+
+// pendingDaemonSets returns the namespace/name of all DaemonSets of the gate which target the node but have
+// no ready pod on it yet
+func (c *Controller) pendingDaemonSets(node *corev1.Node) ([]string, error) {
+	var pending []string
+	for _, namespace := range c.daemonSetReadinessGate.namespaces {
+		daemonSets, err := c.kubeClient.AppsV1().DaemonSets(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list DaemonSets in namespace %s: %v", namespace, err)
+		}
+		pods, err := c.kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %v", namespace, err)
+		}
+
+		for i := range daemonSets.Items {
+			daemonSet := &daemonSets.Items[i]
+			if !daemonSetTargetsNode(daemonSet, node) || hasReadyDaemonSetPod(daemonSet, pods.Items, node.Name) {
+				continue
+			}
+			pending = append(pending, daemonSet.Namespace+"/"+daemonSet.Name)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+func hasReadyDaemonSetPod(daemonSet *appsv1.DaemonSet, pods []corev1.Pod, nodeName string) bool {
+	for _, pod := range pods {
+		if pod.Spec.NodeName != nodeName || !metav1.IsControlledBy(&pod, daemonSet) {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// daemonSetTargetsNode tells if the DaemonSet schedules a pod on the node, based on its node selector, its required
+// node affinity and the taints of the node it tolerates. Taints in the node.kubernetes.io namespace are ignored, the
+// DaemonSet controller adds tolerations for them to all DaemonSet pods
+func daemonSetTargetsNode(daemonSet *appsv1.DaemonSet, node *corev1.Node) bool {
+	podSpec := daemonSet.Spec.Template.Spec
+	if !labels.SelectorFromSet(podSpec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	if podSpec.Affinity != nil && podSpec.Affinity.NodeAffinity != nil && podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		matches := false
+		for _, term := range podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			if nodeSelectorTermMatches(term, node) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule || strings.HasPrefix(taint.Key, "node.kubernetes.io/") {
+			continue
+		}
+		tolerated := false
+		for j := range podSpec.Tolerations {
+			if podSpec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// nodeSelectorTermMatches tells if the node matches all label and field expressions of the term.
+// Terms without expressions match no node, like in the scheduler
+func nodeSelectorTermMatches(term corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, expression := range term.MatchExpressions {
+		operator, ok := nodeSelectorOperators[expression.Operator]
+		if !ok {
+			return false
+		}
+		requirement, err := labels.NewRequirement(expression.Key, operator, expression.Values)
+		if err != nil || !requirement.Matches(labels.Set(node.Labels)) {
+			return false
+		}
+	}
+	for _, field := range term.MatchFields {
+		// metadata.name is the only supported field
+		if field.Key != "metadata.name" {
+			return false
+		}
+		nameMatches := false
+		for _, value := range field.Values {
+			nameMatches = nameMatches || value == node.Name
+		}
+		if (field.Operator == corev1.NodeSelectorOpIn) != nameMatches {
+			return false
+		}
+	}
+	return true
+}
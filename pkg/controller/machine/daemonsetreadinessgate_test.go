@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func newDaemonSet(namespace, name string, nodeSelector map[string]string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name + "-uid")},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{NodeSelector: nodeSelector}},
+		},
+	}
+}
+
+func newDaemonSetPod(daemonSet *appsv1.DaemonSet, nodeName string, ready bool) *corev1.Pod {
+	isController := true
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      daemonSet.Name + "-" + nodeName,
+			Namespace: daemonSet.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "DaemonSet",
+				Name:       daemonSet.Name,
+				UID:        daemonSet.UID,
+				Controller: &isController,
+			}},
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestControllerWaitsForDaemonSetPods(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"pool": "workers"}}}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+		Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+	}
+
+	canal := newDaemonSet("kube-system", "canal", nil)
+	kubeProxy := newDaemonSet("kube-system", "kube-proxy", nil)
+	csi := newDaemonSet("csi", "csi-node", map[string]string{"pool": "workers"})
+	// DaemonSets which do not target the node or are in other namespaces must not block the machine
+	gpuPlugin := newDaemonSet("kube-system", "gpu-plugin", map[string]string{"pool": "gpu"})
+	monitoring := newDaemonSet("monitoring", "node-exporter", nil)
+
+	canalPod := newDaemonSetPod(canal, node.Name, true)
+	kubeProxyPod := newDaemonSetPod(kubeProxy, node.Name, false)
+	// A ready pod on another node must not pass the gate
+	otherCSIPod := newDaemonSetPod(csi, "node-2", true)
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node, canal, kubeProxy, csi, gpuPlugin, monitoring, canalPod, kubeProxyPod, otherCSIPod)
+	controller.daemonSetReadinessGate = NewDaemonSetReadinessGate("kube-system, csi")
+	defer controller.workqueue.ShutDown()
+
+	verify := func(expectedProvisioned bool, expectedStatus corev1.ConditionStatus, expectedPending []string) {
+		t.Helper()
+		if _, err := controller.ensureMachineProvisioned(machine, node); err != nil {
+			t.Fatalf("failed to provision machine: %v", err)
+		}
+		machine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		provisioned := false
+		for _, condition := range machine.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				provisioned = true
+			}
+		}
+		if provisioned != expectedProvisioned {
+			t.Fatalf("expected machine to be provisioned to be %v, got %v", expectedProvisioned, provisioned)
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := providerStatus.GetCondition(providerconfig.DaemonSetsReadyConditionType)
+		if condition == nil || condition.Status != expectedStatus {
+			t.Fatalf("expected DaemonSets ready condition with status %s, got %v", expectedStatus, condition)
+		}
+		if expectedPending != nil && !strings.Contains(condition.Message, strings.Join(expectedPending, ", ")) {
+			t.Errorf("expected the condition to wait for %v, got %q", expectedPending, condition.Message)
+		}
+	}
+
+	// The machine stays provisioning while kube-proxy is not ready and the csi DaemonSet has no pod on the node
+	verify(false, corev1.ConditionFalse, []string{"csi/csi-node", "kube-system/kube-proxy"})
+	if controller.workqueue.Len() != 0 {
+		t.Errorf("expected the recheck to be delayed, got %d queued machines", controller.workqueue.Len())
+	}
+
+	if _, err := controller.kubeClient.CoreV1().Pods(csi.Namespace).Create(newDaemonSetPod(csi, node.Name, true)); err != nil {
+		t.Fatal(err)
+	}
+	verify(false, corev1.ConditionFalse, []string{"kube-system/kube-proxy"})
+
+	if _, err := controller.kubeClient.CoreV1().Pods(kubeProxy.Namespace).Update(newDaemonSetPod(kubeProxy, node.Name, true)); err != nil {
+		t.Fatal(err)
+	}
+	verify(true, corev1.ConditionTrue, nil)
+}
+
+func TestDaemonSetTargetsNode(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"pool": "workers", "cpus": "8"}},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule},
+			{Key: "node.kubernetes.io/not-ready", Effect: corev1.TaintEffectNoExecute},
+			{Key: "preferred", Effect: corev1.TaintEffectPreferNoSchedule},
+		}},
+	}
+	dbToleration := []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "db", Effect: corev1.TaintEffectNoSchedule}}
+	affinity := func(expressions ...corev1.NodeSelectorRequirement) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: expressions}},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		podSpec  corev1.PodSpec
+		expected bool
+	}{
+		{
+			name:     "untolerated taint",
+			podSpec:  corev1.PodSpec{},
+			expected: false,
+		},
+		{
+			name:     "tolerated taint",
+			podSpec:  corev1.PodSpec{Tolerations: dbToleration},
+			expected: true,
+		},
+		{
+			name:     "matching node selector",
+			podSpec:  corev1.PodSpec{Tolerations: dbToleration, NodeSelector: map[string]string{"pool": "workers"}},
+			expected: true,
+		},
+		{
+			name:     "other node selector",
+			podSpec:  corev1.PodSpec{Tolerations: dbToleration, NodeSelector: map[string]string{"pool": "gpu"}},
+			expected: false,
+		},
+		{
+			name: "matching node affinity",
+			podSpec: corev1.PodSpec{Tolerations: dbToleration, Affinity: affinity(
+				corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"workers", "db"}},
+				corev1.NodeSelectorRequirement{Key: "cpus", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}},
+			)},
+			expected: true,
+		},
+		{
+			name: "other node affinity",
+			podSpec: corev1.PodSpec{Tolerations: dbToleration, Affinity: affinity(
+				corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"workers"}},
+			)},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			daemonSet := &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: test.podSpec}}}
+			if targets := daemonSetTargetsNode(daemonSet, node); targets != test.expected {
+				t.Errorf("expected DaemonSet to target the node to be %v, got %v", test.expected, targets)
+			}
+		})
+	}
+}
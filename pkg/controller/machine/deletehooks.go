@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// PreDrainDeleteHookAnnotationPrefix is the prefix of annotations which keep the node of a deleted machine from
+	// being drained, e.g. pre-drain.delete.hook.machine-controller.kubermatic.io/backup. The controller which set the
+	// annotation removes it once it is done
+	PreDrainDeleteHookAnnotationPrefix = "pre-drain.delete.hook.machine-controller.kubermatic.io"
+	// PreTerminateDeleteHookAnnotationPrefix is the prefix of annotations which keep the instance of a deleted machine
+	// from being terminated after its node got drained
+	PreTerminateDeleteHookAnnotationPrefix = "pre-terminate.delete.hook.machine-controller.kubermatic.io"
+
+	deleteHooksWaitingReason = "WaitingForHooks"
+)
+
+// deleteHooks returns the sorted names of the hooks of the machine with the given annotation prefix
+func deleteHooks(machine *clusterv1alpha1.Machine, prefix string) []string {
+	var hooks []string
+	for key := range machine.Annotations {
+		if strings.HasPrefix(key, prefix+"/") {
+			hooks = append(hooks, strings.TrimPrefix(key, prefix+"/"))
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
+// waitForDeleteHooks returns true once the machine has no hook annotations with the given prefix anymore. The given
+// condition of the machine is false while it waits. No requeue is needed, removing an annotation triggers a sync.
+func (c *Controller) waitForDeleteHooks(machine *clusterv1alpha1.Machine, prefix string, conditionType providerconfig.ConditionType, step string) (bool, error) {
+	hooks := deleteHooks(machine, prefix)
+	if len(hooks) == 0 {
+		providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+		if err != nil {
+			return false, fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+		}
+		// Machines which never had a hook do not get the condition
+		if providerStatus.GetCondition(conditionType) == nil {
+			return true, nil
+		}
+		if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.SetCondition(providerconfig.Condition{
+				Type:    conditionType,
+				Status:  corev1.ConditionTrue,
+				Reason:  "HooksRemoved",
+				Message: fmt.Sprintf("All %s hooks got removed", prefix),
+			})
+		}); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	glog.V(4).Infof("Waiting for the hooks %s of machine %s to be removed before the %s", strings.Join(hooks, ", "), machine.Name, step)
+	return false, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(providerconfig.Condition{
+			Type:    conditionType,
+			Status:  corev1.ConditionFalse,
+			Reason:  deleteHooksWaitingReason,
+			Message: fmt.Sprintf("Waiting for the hooks %s to be removed before the %s", strings.Join(hooks, ", "), step),
+		})
+	})
+}
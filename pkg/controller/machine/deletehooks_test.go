@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func getCondition(t *testing.T, machine *clusterv1alpha1.Machine, conditionType providerconfig.ConditionType) *providerconfig.Condition {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		t.Fatalf("failed to get provider status: %v", err)
+	}
+	return providerStatus.GetCondition(conditionType)
+}
+
+func TestDeletionWaitsForPreTerminateHook(t *testing.T) {
+	hook := PreTerminateDeleteHookAnnotationPrefix + "/backup"
+	machine := deletingMachine("machine-with-hook", false)
+	machine.Annotations = map[string]string{hook: "backup-controller"}
+	controller := newVolumeDetachTestController(t, machine)
+	defer controller.workqueue.ShutDown()
+	prov := fakecloudprovider.New(nil)
+
+	getMachine := func() *clusterv1alpha1.Machine {
+		m, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// The instance must not get terminated while the hook is set
+	for i := 0; i < 2; i++ {
+		if err := controller.deleteMachine(prov, getMachine()); err != nil {
+			t.Fatalf("failed to delete machine: %v", err)
+		}
+	}
+	if !sets.NewString(getMachine().Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Fatal("expected the instance to not be terminated while the pre-terminate hook is set")
+	}
+	condition := getCondition(t, getMachine(), providerconfig.PreTerminateDeleteHookSucceededConditionType)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != deleteHooksWaitingReason {
+		t.Errorf("expected the condition %s to be false with reason %s, got %+v", providerconfig.PreTerminateDeleteHookSucceededConditionType, deleteHooksWaitingReason, condition)
+	}
+
+	// Once the hook got removed the instance gets terminated
+	if _, err := controller.updateMachine(getMachine(), func(m *clusterv1alpha1.Machine) {
+		delete(m.Annotations, hook)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.deleteMachine(prov, getMachine()); err != nil {
+		t.Fatalf("failed to delete machine: %v", err)
+	}
+	if sets.NewString(getMachine().Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Error("expected the instance to be terminated once the pre-terminate hook got removed")
+	}
+	condition = getCondition(t, getMachine(), providerconfig.PreTerminateDeleteHookSucceededConditionType)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		t.Errorf("expected the condition %s to be true, got %+v", providerconfig.PreTerminateDeleteHookSucceededConditionType, condition)
+	}
+}
+
+func TestDeletionWaitsForPreDrainHook(t *testing.T) {
+	machine := deletingMachine("machine-with-hook", false)
+	machine.Annotations = map[string]string{PreDrainDeleteHookAnnotationPrefix + "/migrate": ""}
+	controller := newVolumeDetachTestController(t, machine)
+	defer controller.workqueue.ShutDown()
+
+	if err := controller.deleteMachine(fakecloudprovider.New(nil), machine); err != nil {
+		t.Fatalf("failed to delete machine: %v", err)
+	}
+	updated, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sets.NewString(updated.Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Error("expected the deletion to not continue while the pre-drain hook is set")
+	}
+	condition := getCondition(t, updated, providerconfig.PreDrainDeleteHookSucceededConditionType)
+	if condition == nil || condition.Status != corev1.ConditionFalse {
+		t.Errorf("expected the condition %s to be false, got %+v", providerconfig.PreDrainDeleteHookSucceededConditionType, condition)
+	}
+	if condition := getCondition(t, updated, providerconfig.PreTerminateDeleteHookSucceededConditionType); condition != nil {
+		t.Errorf("expected no condition %s without pre-terminate hooks, got %+v", providerconfig.PreTerminateDeleteHookSucceededConditionType, condition)
+	}
+}
+
+func TestDeletionDrainsNodeAfterWaitingForPreDrainHook(t *testing.T) {
+	hook := PreDrainDeleteHookAnnotationPrefix + "/migrate"
+	machine := deletingMachine("machine-with-hook", false)
+	// The hook is set for longer than the eviction gets skipped after
+	machine.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-3 * time.Hour)}
+	machine.Annotations = map[string]string{hook: ""}
+	machine.Status.NodeRef = &corev1.ObjectReference{Name: "node-1"}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+	controller.skipEvictionAfter = 2 * time.Hour
+	defer controller.workqueue.ShutDown()
+
+	getMachine := func() *clusterv1alpha1.Machine {
+		m, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	waitForPreDrainHooks := func() bool {
+		done, err := controller.waitForDeleteHooks(getMachine(), PreDrainDeleteHookAnnotationPrefix, providerconfig.PreDrainDeleteHookSucceededConditionType, "drain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+
+	if waitForPreDrainHooks() {
+		t.Fatal("expected the drain to wait while the pre-drain hook is set")
+	}
+	if shouldEvict, err := controller.shouldEvict(getMachine()); err != nil || !shouldEvict {
+		t.Fatalf("expected the time waiting for the pre-drain hook to not count towards skipping the eviction, got %v, err %v", shouldEvict, err)
+	}
+
+	if _, err := controller.updateMachine(getMachine(), func(m *clusterv1alpha1.Machine) {
+		delete(m.Annotations, hook)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForPreDrainHooks() {
+		t.Fatal("expected the drain to continue once the pre-drain hook got removed")
+	}
+	if shouldEvict, err := controller.shouldEvict(getMachine()); err != nil || !shouldEvict {
+		t.Errorf("expected the node to be drained once the pre-drain hook got removed, got %v, err %v", shouldEvict, err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// recoverAllDeletingMachines picks up the machines which were being deleted when the controller stopped, e.g. because
+// it crashed between terminating an instance and removing the finalizers of its machine. Machines whose instance is
+// gone get their node deleted and their finalizers removed right away, machines whose instance still exists get
+// queued so their deletion resumes like any other.
+func (c *Controller) recoverAllDeletingMachines() error {
+	machines, err := c.machinesLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list machines: %v", err)
+	}
+
+	var errs []error
+	for _, machine := range machines {
+		if machine.DeletionTimestamp == nil || !sets.NewString(machine.Finalizers...).HasAny(c.finalizerDeleteInstance, c.finalizerDeleteNode) {
+			continue
+		}
+		machine = machine.DeepCopy()
+		if err := c.resolveProviderConfigTemplate(machine); err != nil {
+			errs = append(errs, fmt.Errorf("machine %s/%s: %v", machine.Namespace, machine.Name, err))
+			continue
+		}
+		providerConfig, err := providerconfig.GetConfig(machine.Spec.ProviderSpec)
+		if err != nil {
+			continue
+		}
+		prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, providerconfig.NewConfigVarResolver(c.kubeClient))
+		if err != nil {
+			continue
+		}
+		if err := c.recoverDeletingMachine(prov, machine); err != nil {
+			errs = append(errs, fmt.Errorf("machine %s/%s: %v", machine.Namespace, machine.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// recoverDeletingMachine completes the deletion of the machine if its instance is gone and queues it otherwise
+func (c *Controller) recoverDeletingMachine(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) error {
+	// Machines which keep their instance and node never wait for the cloud provider
+	if machine.Annotations[AnnotationSkipNodeDeletion] == "true" {
+		c.enqueueMachine(machine)
+		return nil
+	}
+
+	if sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
+		if _, err := prov.Get(machine); err != cloudprovidererrors.ErrInstanceNotFound {
+			if err != nil {
+				return fmt.Errorf("failed to get instance: %v", err)
+			}
+			glog.V(3).Infof("Resuming the deletion of machine %s, its instance still exists", machine.Name)
+			c.recorder.Event(machine, corev1.EventTypeNormal, "DeletionResumed", "Resuming the deletion after the controller restarted, the instance still exists")
+			c.enqueueMachine(machine)
+			return nil
+		}
+
+		glog.V(3).Infof("Completing the deletion of machine %s, its instance is gone", machine.Name)
+		var err error
+		if machine, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+			finalizers := sets.NewString(m.Finalizers...)
+			finalizers.Delete(c.finalizerDeleteInstance)
+			m.Finalizers = finalizers.List()
+		}); err != nil {
+			return fmt.Errorf("failed to remove the %s finalizer: %v", c.finalizerDeleteInstance, err)
+		}
+	}
+
+	// Without an instance the node can not come back, so it gets deleted without draining it
+	return c.deleteNodeForMachine(machine)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func deletingMachine(name string, instanceNotFound bool) *clusterv1alpha1.Machine {
+	spec := fmt.Sprintf(`{"cloudProvider": "fake", "cloudProviderSpec": {"passValidation": true, "instanceNotFound": %t}}`, instanceNotFound)
+	return &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "kube-system",
+			UID:               types.UID(name + "-uid"),
+			Finalizers:        []string{FinalizerDeleteInstance, FinalizerDeleteNode},
+			DeletionTimestamp: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+		},
+		Spec: clusterv1alpha1.MachineSpec{
+			ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(spec)}},
+		},
+	}
+}
+
+func TestControllerRecoversDeletingMachinesAtStartup(t *testing.T) {
+	goneMachine := deletingMachine("machine-gone", true)
+	runningMachine := deletingMachine("machine-running", false)
+	goneNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-gone",
+		Labels: map[string]string{NodeOwnerLabelName: string(goneMachine.UID)},
+	}}
+	runningNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-running",
+		Labels: map[string]string{NodeOwnerLabelName: string(runningMachine.UID)},
+	}}
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{goneMachine, runningMachine}, goneNode, runningNode)
+	defer controller.workqueue.ShutDown()
+
+	if err := controller.recoverAllDeletingMachines(); err != nil {
+		t.Fatalf("failed to recover deleting machines: %v", err)
+	}
+
+	// The machine without an instance gets its node deleted and its finalizers removed
+	machine, err := controller.machineClient.ClusterV1alpha1().Machines(goneMachine.Namespace).Get(goneMachine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machine.Finalizers) != 0 {
+		t.Errorf("expected the finalizers of machine %s to be removed, got %v", machine.Name, machine.Finalizers)
+	}
+	if _, err := controller.kubeClient.CoreV1().Nodes().Get(goneNode.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected node %s to be deleted", goneNode.Name)
+	}
+
+	// The machine with a running instance keeps its finalizers and node and gets queued to resume its deletion
+	machine, err = controller.machineClient.ClusterV1alpha1().Machines(runningMachine.Namespace).Get(runningMachine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machine.Finalizers) != 2 {
+		t.Errorf("expected machine %s to keep its finalizers, got %v", machine.Name, machine.Finalizers)
+	}
+	if _, err := controller.kubeClient.CoreV1().Nodes().Get(runningNode.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected node %s to be kept: %v", runningNode.Name, err)
+	}
+	if controller.workqueue.Len() != 1 {
+		t.Fatalf("expected exactly one queued machine, got %d", controller.workqueue.Len())
+	}
+	key, _ := controller.workqueue.Get()
+	if key != "kube-system/machine-running" {
+		t.Errorf("expected machine kube-system/machine-running to be queued, got %v", key)
+	}
+}
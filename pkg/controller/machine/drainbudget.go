@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// drainBudgetRequeuePeriod is the time after which a machine which had to wait for a free drain slot gets retried
+	drainBudgetRequeuePeriod = 15 * time.Second
+	// drainAdmissionTimeout is the time a machine keeps its drain slot without its node showing up as cordoned, e.g.
+	// while the pre-drain hook runs. Every sync of the machine renews it
+	drainAdmissionTimeout = 5 * time.Minute
+
+	drainSlotWaitingReason = "WaitingForDrainSlot"
+)
+
+// DrainBudget limits the number of nodes which are drained at the same time across all MachineDeployments, so many
+// independent rollouts or deletions do not take down too much capacity at once. A node counts as unavailable from
+// the start of its drain until it is gone, or until its instance got resized.
+type DrainBudget struct {
+	maxUnavailable int
+
+	lock sync.Mutex
+	// admitted holds the machines which got a drain slot but whose node is not cordoned yet
+	admitted map[string]time.Time
+}
+
+// NewDrainBudget returns the DrainBudget which allows maxUnavailable nodes to be drained at the same time.
+// nil is returned if maxUnavailable is not positive
+func NewDrainBudget(maxUnavailable int) *DrainBudget {
+	if maxUnavailable <= 0 {
+		return nil
+	}
+	return &DrainBudget{maxUnavailable: maxUnavailable, admitted: map[string]time.Time{}}
+}
+
+// tryAcquire returns true if the machine may drain its node. unavailable holds the machines whose nodes are drained
+// according to the caches.
+func (b *DrainBudget) tryAcquire(machine string, unavailable sets.String, now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for admitted, t := range b.admitted {
+		if unavailable.Has(admitted) || now.Sub(t) > drainAdmissionTimeout {
+			delete(b.admitted, admitted)
+		}
+	}
+	if unavailable.Has(machine) {
+		return true
+	}
+	if _, admitted := b.admitted[machine]; !admitted && unavailable.Len()+len(b.admitted) >= b.maxUnavailable {
+		return false
+	}
+	b.admitted[machine] = now
+	return true
+}
+
+// acquireDrainSlot returns false if the node of the machine must not be drained yet, because the drain budget is
+// used up by the nodes of other machines. The machine gets re-enqueued then and its DrainSlotAcquired condition is
+// false.
+func (c *Controller) acquireDrainSlot(machine *clusterv1alpha1.Machine) (bool, error) {
+	if c.drainBudget == nil {
+		return true, nil
+	}
+	key, err := cache.MetaNamespaceKeyFunc(machine)
+	if err != nil {
+		return false, err
+	}
+
+	machines, err := c.machinesLister.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("failed to list machines: %v", err)
+	}
+	unavailable := sets.NewString()
+	for _, m := range machines {
+		if !c.isDraining(m) {
+			continue
+		}
+		k, err := cache.MetaNamespaceKeyFunc(m)
+		if err != nil {
+			return false, err
+		}
+		unavailable.Insert(k)
+	}
+
+	if !c.drainBudget.tryAcquire(key, unavailable, time.Now()) {
+		message := fmt.Sprintf("Waiting for a free drain slot, %d nodes are drained already", c.drainBudget.maxUnavailable)
+		glog.V(3).Infof("Delaying the drain of machine %s, %d nodes are drained already", machine.Name, c.drainBudget.maxUnavailable)
+		c.recorder.Event(machine, corev1.EventTypeNormal, "DrainDelayed", message)
+		c.enqueueMachineAfter(machine, drainBudgetRequeuePeriod)
+		return false, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.SetCondition(providerconfig.Condition{
+				Type:    providerconfig.DrainSlotAcquiredConditionType,
+				Status:  corev1.ConditionFalse,
+				Reason:  drainSlotWaitingReason,
+				Message: message,
+			})
+		})
+	}
+	return true, c.openDrainGate(machine, providerconfig.DrainSlotAcquiredConditionType, "DrainSlotAcquired", "The machine got a drain slot")
+}
+
+// isDraining tells if the machine gets deleted or resized and its node got cordoned for that
+func (c *Controller) isDraining(machine *clusterv1alpha1.Machine) bool {
+	if _, resizing := machine.Annotations[AnnotationResizing]; machine.DeletionTimestamp == nil && !resizing {
+		return false
+	}
+	if machine.Status.NodeRef == nil {
+		return false
+	}
+	node, err := c.nodesLister.Get(machine.Status.NodeRef.Name)
+	if err != nil {
+		return false
+	}
+	return node.Spec.Unschedulable
+}
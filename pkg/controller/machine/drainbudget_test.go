@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestControllerDrainBudgetAcrossDeployments(t *testing.T) {
+	deletionTimestamp := metav1.Now()
+	var machines []*clusterv1alpha1.Machine
+	var nodes []runtime.Object
+	for _, deployment := range []string{"a", "b", "c"} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-" + deployment}}
+		machine := &clusterv1alpha1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "machine-" + deployment,
+				Namespace:         "kube-system",
+				DeletionTimestamp: &deletionTimestamp,
+				OwnerReferences:   []metav1.OwnerReference{{Kind: "MachineSet", Name: "deployment-" + deployment + "-12345"}},
+			},
+			Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+		}
+		machines = append(machines, machine)
+		nodes = append(nodes, node)
+	}
+
+	tests := []struct {
+		name           string
+		maxUnavailable int
+		expected       []bool
+	}{
+		{
+			name:           "one drain at a time",
+			maxUnavailable: 1,
+			expected:       []bool{true, false, false},
+		},
+		{
+			name:           "two drains at a time",
+			maxUnavailable: 2,
+			expected:       []bool{true, true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := newTestController(t, machines, nodes...)
+			controller.drainBudget = NewDrainBudget(test.maxUnavailable)
+			defer controller.workqueue.ShutDown()
+
+			for i, machine := range machines {
+				acquired, err := controller.acquireDrainSlot(machine)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if acquired != test.expected[i] {
+					t.Errorf("expected drain of machine %s to be allowed: %v, got %v", machine.Name, test.expected[i], acquired)
+				}
+			}
+		})
+	}
+
+	t.Run("drain proceeds once a drained node is gone", func(t *testing.T) {
+		controller := newTestController(t, machines, nodes...)
+		controller.drainBudget = NewDrainBudget(1)
+		// The deletion of node-a must show up in the lister, so the test manages the nodes in it
+		nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for _, node := range nodes {
+			if err := nodeIndexer.Add(node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		controller.nodesLister = corev1listers.NewNodeLister(nodeIndexer)
+		defer controller.workqueue.ShutDown()
+
+		acquire := func(machine *clusterv1alpha1.Machine) bool {
+			acquired, err := controller.acquireDrainSlot(machine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return acquired
+		}
+		if !acquire(machines[0]) {
+			t.Fatalf("expected the first drain to be allowed")
+		}
+
+		// The drain cordons the node, it stays unavailable until it is gone
+		if err := nodeIndexer.Update(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{Unschedulable: true}}); err != nil {
+			t.Fatal(err)
+		}
+		if !acquire(machines[0]) {
+			t.Errorf("expected the machine to keep its drain slot")
+		}
+		if acquire(machines[1]) || acquire(machines[2]) {
+			t.Errorf("expected the other drains to wait while node-a is drained")
+		}
+
+		if err := nodeIndexer.Delete(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}); err != nil {
+			t.Fatal(err)
+		}
+		if !acquire(machines[1]) {
+			t.Errorf("expected the drain of machine-b to be allowed once node-a is gone")
+		}
+		if acquire(machines[2]) {
+			t.Errorf("expected the drain of machine-c to wait for machine-b")
+		}
+	})
+}
+
+func TestControllerDrainsNodeAfterWaitingForDrainSlot(t *testing.T) {
+	draining := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-a", Namespace: "kube-system", DeletionTimestamp: &metav1.Time{Time: time.Now()}},
+		Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-a"}},
+	}
+	// The machine queued for a drain slot for longer than the eviction gets skipped after
+	queuedSince := metav1.NewTime(time.Now().Add(-3 * time.Hour))
+	providerStatus := &providerconfig.ProviderStatus{Conditions: []providerconfig.Condition{{
+		Type:               providerconfig.DrainSlotAcquiredConditionType,
+		Status:             corev1.ConditionFalse,
+		Reason:             drainSlotWaitingReason,
+		LastTransitionTime: queuedSince,
+	}}}
+	rawProviderStatus, err := providerStatus.RawExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-b", Namespace: "kube-system", DeletionTimestamp: &queuedSince},
+		Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-b"}, ProviderStatus: rawProviderStatus},
+	}
+	nodeA := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{Unschedulable: true}}
+	nodeB := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{draining, queued}, nodeA, nodeB)
+	controller.drainBudget = NewDrainBudget(1)
+	controller.skipEvictionAfter = 2 * time.Hour
+	defer controller.workqueue.ShutDown()
+	getMachine := func() *clusterv1alpha1.Machine {
+		m, err := controller.machinesLister.Machines(queued.Namespace).Get(queued.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	if acquired, err := controller.acquireDrainSlot(getMachine()); err != nil || acquired {
+		t.Fatalf("expected the drain to wait while node-a is drained, got acquired %v, err %v", acquired, err)
+	}
+	if shouldEvict, err := controller.shouldEvict(getMachine()); err != nil || !shouldEvict {
+		t.Fatalf("expected the time waiting for a drain slot to not count towards skipping the eviction, got %v, err %v", shouldEvict, err)
+	}
+
+	// node-a is gone, so machine-b gets the slot
+	draining.Status.NodeRef = nil
+	if _, err := controller.machineClient.ClusterV1alpha1().Machines(draining.Namespace).Update(draining); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := controller.acquireDrainSlot(getMachine()); err != nil || !acquired {
+		t.Fatalf("expected the drain to be allowed, got acquired %v, err %v", acquired, err)
+	}
+	condition := getCondition(t, getMachine(), providerconfig.DrainSlotAcquiredConditionType)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		t.Fatalf("expected the condition %s to be true, got %+v", providerconfig.DrainSlotAcquiredConditionType, condition)
+	}
+	if shouldEvict, err := controller.shouldEvict(getMachine()); err != nil || !shouldEvict {
+		t.Errorf("expected the node to be drained once the machine got a drain slot, got %v, err %v", shouldEvict, err)
+	}
+}
+
+func TestDrainBudgetAdmissionTimesOut(t *testing.T) {
+	budget := NewDrainBudget(1)
+	now := time.Now()
+	if !budget.tryAcquire("kube-system/machine-a", sets.NewString(), now) {
+		t.Fatalf("expected the first drain to be allowed")
+	}
+	if budget.tryAcquire("kube-system/machine-b", sets.NewString(), now.Add(time.Minute)) {
+		t.Errorf("expected the second drain to wait while the first one is admitted")
+	}
+	// machine-a never cordoned its node
+	if !budget.tryAcquire("kube-system/machine-b", sets.NewString(), now.Add(drainAdmissionTimeout+time.Second)) {
+		t.Errorf("expected the second drain to be allowed after the admission of the first one timed out")
+	}
+}
+
+func TestNewDrainBudgetDisabled(t *testing.T) {
+	if NewDrainBudget(0) != nil {
+		t.Errorf("expected no drain budget for a max unavailable of 0")
+	}
+}
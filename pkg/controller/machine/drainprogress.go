@@ -0,0 +1,59 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// drainProgressReporter returns the func which records the progress of the drain of the node of the machine in its
+// providerStatus. Apart from the first and the final progress, it gets recorded at most once per
+// drainProgressUpdateInterval. nil if disabled
+func (c *Controller) drainProgressReporter(machine *clusterv1alpha1.Machine) eviction.ProgressFunc {
+	if c.drainProgressUpdateInterval == 0 {
+		return nil
+	}
+
+	var lastUpdate time.Time
+	return func(progress eviction.Progress) {
+		if !lastUpdate.IsZero() && progress.PodsRemaining > 0 && time.Since(lastUpdate) < c.drainProgressUpdateInterval {
+			return
+		}
+		lastUpdate = time.Now()
+
+		if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.DrainStatus = &providerconfig.DrainStatus{
+				PodsEvicted:    progress.PodsEvicted,
+				PodsRemaining:  progress.PodsRemaining,
+				BlockingPod:    progress.BlockingPod,
+				LastUpdateTime: metav1.Now(),
+			}
+		}); err != nil {
+			// The drain must not fail because its progress could not be recorded
+			glog.Errorf("Failed to record the drain progress of machine %s: %v", machine.Name, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestDrainProgressReporter(t *testing.T) {
+	tests := []struct {
+		name                    string
+		updateInterval          time.Duration
+		progress                []eviction.Progress
+		expectedRemainingCounts []int
+	}{
+		{
+			name:           "every change gets recorded",
+			updateInterval: time.Nanosecond,
+			progress: []eviction.Progress{
+				{PodsRemaining: 3},
+				{PodsEvicted: 1, PodsRemaining: 2, BlockingPod: "default/web"},
+				{PodsEvicted: 2, PodsRemaining: 1, BlockingPod: "default/db"},
+				{PodsEvicted: 3},
+			},
+			expectedRemainingCounts: []int{3, 2, 1, 0},
+		},
+		{
+			name:           "updates get throttled apart from the final one",
+			updateInterval: time.Hour,
+			progress: []eviction.Progress{
+				{PodsRemaining: 3},
+				{PodsEvicted: 1, PodsRemaining: 2, BlockingPod: "default/web"},
+				{PodsEvicted: 2, PodsRemaining: 1, BlockingPod: "default/db"},
+				{PodsEvicted: 3},
+			},
+			expectedRemainingCounts: []int{3, 3, 3, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := fakeProviderMachine("machine-1", false)
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+			controller.drainProgressUpdateInterval = test.updateInterval
+
+			report := controller.drainProgressReporter(machine)
+			for i, progress := range test.progress {
+				report(progress)
+
+				current, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				providerStatus, err := providerconfig.GetProviderStatus(current.Status.ProviderStatus)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if providerStatus.DrainStatus == nil {
+					t.Fatalf("expected a drain status after progress %d", i)
+				}
+				if providerStatus.DrainStatus.PodsRemaining != test.expectedRemainingCounts[i] {
+					t.Errorf("expected %d remaining pods after progress %d, got %d", test.expectedRemainingCounts[i], i, providerStatus.DrainStatus.PodsRemaining)
+				}
+				if test.updateInterval == time.Nanosecond && providerStatus.DrainStatus.BlockingPod != progress.BlockingPod {
+					t.Errorf("expected blocking pod %q after progress %d, got %q", progress.BlockingPod, i, providerStatus.DrainStatus.BlockingPod)
+				}
+			}
+		})
+	}
+
+	if report := (&Controller{}).drainProgressReporter(fakeProviderMachine("machine-1", false)); report != nil {
+		t.Error("expected no reporter if the drain progress is disabled")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationReadinessGateGPUs is the number of GPUs the node must report in its capacity before the machine is
+	// considered provisioned. With time-slicing, the device plugin reports every GPU once per replica, so this is
+	// the number of GPUs times the replicas of its config.
+	AnnotationReadinessGateGPUs = "machine-controller.kubermatic.io/readiness-gate-gpus"
+
+	gpuResourceName corev1.ResourceName = "nvidia.com/gpu"
+)
+
+// gpuReadinessGatePassed tells if the node reports at least the number of GPUs of the gate of the machine. Machines
+// without the gate always pass. The result gets recorded in the GPUCapacityReported condition of the machine.
+func (c *Controller) gpuReadinessGatePassed(machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	rawGPUs, gated := machine.Annotations[AnnotationReadinessGateGPUs]
+	if !gated {
+		return true, nil
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status: %v", err)
+	}
+	// A passed gate is not checked again, a restart of the device plugin must not make a provisioned machine unready
+	if condition := providerStatus.GetCondition(providerconfig.GPUCapacityReportedConditionType); condition != nil && condition.Status == corev1.ConditionTrue {
+		return true, nil
+	}
+
+	gpus, err := strconv.ParseInt(rawGPUs, 10, 64)
+	if err != nil || gpus < 1 {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "InvalidReadinessGate", "Invalid number of GPUs %q for the readiness gate, must be a positive number", rawGPUs)
+		return false, fmt.Errorf("invalid number of GPUs %q for the readiness gate, must be a positive number", rawGPUs)
+	}
+
+	var reported int64
+	if quantity, ok := node.Status.Capacity[gpuResourceName]; ok {
+		reported = quantity.Value()
+	}
+	condition := providerconfig.Condition{
+		Type:    providerconfig.GPUCapacityReportedConditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  "GPUsReported",
+		Message: fmt.Sprintf("Node %s reports %d %s", node.Name, reported, gpuResourceName),
+	}
+	if reported < gpus {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "GPUsNotReported"
+		condition.Message = fmt.Sprintf("Waiting for node %s to report %d %s, got %d", node.Name, gpus, gpuResourceName, reported)
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return false, err
+	}
+
+	passed := condition.Status == corev1.ConditionTrue
+	if !passed {
+		// Nodes only get reconciled on changes of their ready condition, so the capacity gets checked periodically
+		c.enqueueMachineAfter(machine, readinessGateRecheckPeriod)
+	}
+	return passed, nil
+}
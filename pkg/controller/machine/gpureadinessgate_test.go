@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestControllerWaitsForGPUCapacity(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "machine-1",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{AnnotationReadinessGateGPUs: "4"},
+		},
+		Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+	}
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+	defer controller.workqueue.ShutDown()
+
+	verify := func(expectedProvisioned bool, expectedStatus corev1.ConditionStatus) {
+		t.Helper()
+		current, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := controller.ensureMachineProvisioned(current, node); err != nil {
+			t.Fatalf("failed to provision machine: %v", err)
+		}
+		updated, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		provisioned := false
+		for _, condition := range updated.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				provisioned = true
+			}
+		}
+		if provisioned != expectedProvisioned {
+			t.Fatalf("expected machine to be provisioned to be %v, got %v", expectedProvisioned, provisioned)
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(updated.Status.ProviderStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := providerStatus.GetCondition(providerconfig.GPUCapacityReportedConditionType)
+		if condition == nil || condition.Status != expectedStatus {
+			t.Fatalf("expected GPU readiness gate condition with status %s, got %v", expectedStatus, condition)
+		}
+	}
+
+	// The machine stays provisioning while the device plugin did not report any GPUs
+	verify(false, corev1.ConditionFalse)
+	if controller.workqueue.Len() != 0 {
+		t.Errorf("expected the recheck to be delayed, got %d queued machines", controller.workqueue.Len())
+	}
+
+	// Before the time-slicing config got applied, only the physical GPU is reported
+	node.Status.Capacity = corev1.ResourceList{gpuResourceName: resource.MustParse("1")}
+	verify(false, corev1.ConditionFalse)
+
+	node.Status.Capacity = corev1.ResourceList{gpuResourceName: resource.MustParse("4")}
+	verify(true, corev1.ConditionTrue)
+}
+
+func TestControllerRejectsInvalidGPUReadinessGate(t *testing.T) {
+	controller := Controller{recorder: &record.FakeRecorder{}}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AnnotationReadinessGateGPUs: "0"}},
+	}
+	if _, err := controller.gpuReadinessGatePassed(machine, &corev1.Node{}); err == nil {
+		t.Error("expected an error for a non-positive number of GPUs")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// instanceRefreshPeriod is the time after which the instance of a provisioned machine gets fetched from the cloud
+// provider again, to pick up changes of its addresses
+const instanceRefreshPeriod = 5 * time.Minute
+
+// instanceRefresher tracks when the instances of provisioned machines got fetched the last time, so not every sync
+// of a machine calls the cloud provider
+type instanceRefresher struct {
+	lock        sync.Mutex
+	lastRefresh map[types.UID]time.Time
+}
+
+func newInstanceRefresher() *instanceRefresher {
+	return &instanceRefresher{lastRefresh: map[types.UID]time.Time{}}
+}
+
+// tryRefresh returns true and records the refresh if the instance of the machine was not refreshed within the
+// instanceRefreshPeriod. A nil instanceRefresher refreshes on every call
+func (r *instanceRefresher) tryRefresh(machine types.UID, now time.Time) bool {
+	if r == nil {
+		return true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for uid, t := range r.lastRefresh {
+		if now.Sub(t) >= instanceRefreshPeriod {
+			delete(r.lastRefresh, uid)
+		}
+	}
+	if _, refreshed := r.lastRefresh[machine]; refreshed {
+		return false
+	}
+	r.lastRefresh[machine] = now
+	return true
+}
+
+// invalidate makes the next sync of the machine refresh its instance, e.g. after an event for the instance
+func (r *instanceRefresher) invalidate(machine types.UID) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.lastRefresh, machine)
+}
+
+// refreshInstance returns the instance of a provisioned machine when it is due for a refresh. nil is returned if
+// it is not due yet or if the instance is missing
+func (c *Controller) refreshInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) (instance.Instance, error) {
+	if !c.instanceRefresher.tryRefresh(machine.UID, time.Now()) {
+		return nil, nil
+	}
+	providerInstance, err := prov.Get(machine)
+	if err != nil {
+		c.instanceRefresher.invalidate(machine.UID)
+		// A missing instance gets handled once its node is not ready anymore
+		if err == cloudprovidererrors.ErrInstanceNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get instance from provider: %v", err)
+	}
+	return providerInstance, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestInstanceRefresherTryRefresh(t *testing.T) {
+	refresher := newInstanceRefresher()
+	now := time.Now()
+
+	if !refresher.tryRefresh("machine-1", now) {
+		t.Fatal("expected the first refresh of a machine to be due")
+	}
+	if refresher.tryRefresh("machine-1", now.Add(instanceRefreshPeriod/2)) {
+		t.Error("expected no refresh within the refresh period")
+	}
+	if !refresher.tryRefresh("machine-2", now.Add(instanceRefreshPeriod/2)) {
+		t.Error("expected the refresh of another machine to be due")
+	}
+	if !refresher.tryRefresh("machine-1", now.Add(instanceRefreshPeriod)) {
+		t.Error("expected a refresh after the refresh period")
+	}
+
+	refresher.invalidate("machine-2")
+	if !refresher.tryRefresh("machine-2", now.Add(instanceRefreshPeriod/2)) {
+		t.Error("expected a refresh after the machine got invalidated")
+	}
+}
+
+func TestControllerRefreshInstance(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "machine-1-uid"},
+	}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+	controller.instanceRefresher = newInstanceRefresher()
+	prov := &addressesTestProvider{instance: &fakeInstance{id: "1"}}
+
+	if providerInstance, err := controller.refreshInstance(prov, machine); err != nil || providerInstance == nil {
+		t.Fatalf("expected the instance on the first refresh, got %v, error: %v", providerInstance, err)
+	}
+	if providerInstance, err := controller.refreshInstance(prov, machine); err != nil || providerInstance != nil {
+		t.Fatalf("expected no instance before the refresh period passed, got %v, error: %v", providerInstance, err)
+	}
+	if prov.gets != 1 {
+		t.Errorf("expected the instance to be fetched once, got %d calls", prov.gets)
+	}
+
+	// An event for the instance refreshes it right away, failed refreshes get retried on the next sync
+	controller.instanceRefresher.invalidate(machine.UID)
+	prov.err = errors.New("rate limit exceeded")
+	if _, err := controller.refreshInstance(prov, machine); err == nil {
+		t.Fatal("expected the error of the provider")
+	}
+	prov.err = cloudprovidererrors.ErrInstanceNotFound
+	if providerInstance, err := controller.refreshInstance(prov, machine); err != nil || providerInstance != nil {
+		t.Fatalf("expected no instance and no error for a missing instance, got %v, error: %v", providerInstance, err)
+	}
+	prov.err = nil
+	if providerInstance, err := controller.refreshInstance(prov, machine); err != nil || providerInstance == nil {
+		t.Fatalf("expected the instance after the failed refreshes, got %v, error: %v", providerInstance, err)
+	}
+	if prov.gets != 4 {
+		t.Errorf("expected the instance to be fetched 4 times, got %d calls", prov.gets)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// DefaultNodeInstanceTypeLabelName is the default label which gets set to the instance type of the machine on its node
+const DefaultNodeInstanceTypeLabelName = "node.machine.k8s.io/instance-type"
+
+// ensureNodeInstanceTypeLabel sets the instance type the provider config of the machine requests as label on its node,
+// so pods can be scheduled onto specific instance types. The label gets updated once the instance got resized in place.
+func (c *Controller) ensureNodeInstanceTypeLabel(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, node *corev1.Node) error {
+	if c.nodeInstanceTypeLabel == "" {
+		return nil
+	}
+	typer, ok := prov.(cloudprovidertypes.InstanceTyper)
+	if !ok {
+		return nil
+	}
+
+	instanceType, err := typer.InstanceType(machine.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to get instance type of machine %s: %v", machine.Name, err)
+	}
+	current, labeled := node.Labels[c.nodeInstanceTypeLabel]
+	if instanceType == "" || current == instanceType {
+		return nil
+	}
+	// Without in-place resizes, a changed instance type in the spec does not change the existing instance
+	if labeled && !c.inPlaceResize {
+		return nil
+	}
+	if errs := validation.IsValidLabelValue(instanceType); len(errs) > 0 {
+		glog.V(4).Infof("Not labeling node %s with instance type %q, it is no valid label value: %v", node.Name, instanceType, errs)
+		return nil
+	}
+
+	if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+		if n.Labels == nil {
+			n.Labels = map[string]string{}
+		}
+		n.Labels[c.nodeInstanceTypeLabel] = instanceType
+	}); err != nil {
+		return fmt.Errorf("failed to update node %s after setting the instance type label: %v", node.Name, err)
+	}
+	glog.V(3).Infof("Set instance type label %s=%s on node %s (machine %s)", c.nodeInstanceTypeLabel, instanceType, node.Name, machine.Name)
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// instanceTypeTestProvider returns the instance type of its provider config
+type instanceTypeTestProvider struct {
+	cloudprovidertypes.Provider
+	instanceType string
+}
+
+func (p *instanceTypeTestProvider) InstanceType(_ clusterv1alpha1.MachineSpec) (string, error) {
+	return p.instanceType, nil
+}
+
+func TestControllerEnsureNodeInstanceTypeLabel(t *testing.T) {
+	tests := []struct {
+		name          string
+		label         string
+		inPlaceResize bool
+		prov          cloudprovidertypes.Provider
+		nodeLabels    map[string]string
+		expectedLabel string
+		expectUpdate  bool
+	}{
+		{
+			name:          "label gets set on join",
+			label:         DefaultNodeInstanceTypeLabelName,
+			prov:          &instanceTypeTestProvider{instanceType: "t3.large"},
+			expectedLabel: "t3.large",
+			expectUpdate:  true,
+		},
+		{
+			name:          "label gets updated after a resize",
+			label:         DefaultNodeInstanceTypeLabelName,
+			inPlaceResize: true,
+			prov:          &instanceTypeTestProvider{instanceType: "t3.large"},
+			nodeLabels:    map[string]string{DefaultNodeInstanceTypeLabelName: "t3.medium"},
+			expectedLabel: "t3.large",
+			expectUpdate:  true,
+		},
+		{
+			name:          "label is kept without in-place resizes",
+			label:         DefaultNodeInstanceTypeLabelName,
+			prov:          &instanceTypeTestProvider{instanceType: "t3.large"},
+			nodeLabels:    map[string]string{DefaultNodeInstanceTypeLabelName: "t3.medium"},
+			expectedLabel: "t3.medium",
+		},
+		{
+			name:          "up to date label is kept",
+			label:         DefaultNodeInstanceTypeLabelName,
+			prov:          &instanceTypeTestProvider{instanceType: "t3.large"},
+			nodeLabels:    map[string]string{DefaultNodeInstanceTypeLabelName: "t3.large"},
+			expectedLabel: "t3.large",
+		},
+		{
+			name:  "invalid label value is skipped",
+			label: DefaultNodeInstanceTypeLabelName,
+			prov:  &instanceTypeTestProvider{instanceType: "2-cpus 4096-mb"},
+		},
+		{
+			name:  "provider without instance types",
+			label: DefaultNodeInstanceTypeLabelName,
+			prov:  &resizeTestProvider{},
+		},
+		{
+			name: "disabled",
+			prov: &instanceTypeTestProvider{instanceType: "t3.large"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: test.nodeLabels}}
+			machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"}}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.nodeInstanceTypeLabel = test.label
+			controller.inPlaceResize = test.inPlaceResize
+			kubeClient := controller.kubeClient.(*fake.Clientset)
+
+			if err := controller.ensureNodeInstanceTypeLabel(test.prov, machine, node); err != nil {
+				t.Fatalf("failed to ensure instance type label: %v", err)
+			}
+
+			var updated bool
+			for _, action := range kubeClient.Actions() {
+				if action.GetVerb() == "update" {
+					updated = true
+				}
+			}
+			if updated != test.expectUpdate {
+				t.Errorf("expected node update: %v, got %v", test.expectUpdate, updated)
+			}
+			updatedNode, err := kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if label := updatedNode.Labels[DefaultNodeInstanceTypeLabelName]; label != test.expectedLabel {
+				t.Errorf("expected instance type label %q, got %q", test.expectedLabel, label)
+			}
+		})
+	}
+}
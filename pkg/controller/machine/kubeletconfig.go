@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationKubeletConfig holds the kubelet flags of the kubeletConfig of the machine on its node. A node agent
+	// writes them to /etc/kubernetes/kubelet-config.env and restarts the kubelet
+	AnnotationKubeletConfig = "machine-controller.kubermatic.io/kubelet-config"
+	// AnnotationKubeletConfigApplied is set by the node agent to the kubelet flags the kubelet runs with
+	AnnotationKubeletConfigApplied = "machine-controller.kubermatic.io/kubelet-config-applied"
+)
+
+// ensureKubeletConfigReconciled pushes the kubelet config of the machine to its node, so a changed kubeletConfig gets
+// applied by restarting the kubelet instead of re-creating the machine. Nodes of machines which never had a kubelet
+// config are left alone. The KubeletConfigApplied condition of the machine tells if the kubelet runs with it.
+func (c *Controller) ensureKubeletConfigReconciled(machine *clusterv1alpha1.Machine, node *corev1.Node, providerConfig *providerconfig.Config) error {
+	if c.reconcileKubeletConfig {
+		if err := c.pushKubeletConfig(machine, node, providerConfig); err != nil {
+			return err
+		}
+	}
+	return c.ensureKubeletConfigAppliedCondition(machine, node, providerConfig)
+}
+
+func (c *Controller) pushKubeletConfig(machine *clusterv1alpha1.Machine, node *corev1.Node, providerConfig *providerconfig.Config) error {
+	args := providerConfig.KubeletConfig.Args()
+	current, exists := node.Annotations[AnnotationKubeletConfig]
+	if current == args && (exists || providerConfig.KubeletConfig == nil) {
+		return nil
+	}
+
+	if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+		if n.Annotations == nil {
+			n.Annotations = map[string]string{}
+		}
+		n.Annotations[AnnotationKubeletConfig] = args
+	}); err != nil {
+		return fmt.Errorf("failed to update node %s after setting the kubelet config annotation: %v", node.Name, err)
+	}
+	// The first push to a node matches the flags its kubelet got started with
+	if exists {
+		glog.V(2).Infof("Updated the kubelet config of node %s (machine %s) to %q", node.Name, machine.Name, args)
+		c.recorder.Eventf(machine, corev1.EventTypeNormal, "KubeletConfigUpdated", "Updated the kubelet config of node %s in place", node.Name)
+	}
+	return nil
+}
+
+// ensureKubeletConfigAppliedCondition compares the kubelet flags of the machine with the ones its node runs with,
+// which the node agent reports or the userdata of the instance got rendered with. Nothing is recorded if neither
+// is known
+func (c *Controller) ensureKubeletConfigAppliedCondition(machine *clusterv1alpha1.Machine, node *corev1.Node, providerConfig *providerconfig.Config) error {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+	}
+	applied, known := node.Annotations[AnnotationKubeletConfigApplied]
+	if !known && providerStatus.UserDataKubeletConfig != nil {
+		applied, known = *providerStatus.UserDataKubeletConfig, true
+	}
+	if !known {
+		return nil
+	}
+
+	condition := providerconfig.Condition{
+		Type:    providerconfig.KubeletConfigAppliedConditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  "KubeletConfigApplied",
+		Message: "The kubelet runs with the kubelet config of the machine",
+	}
+	switch {
+	case applied == providerConfig.KubeletConfig.Args():
+		// Machines whose kubelet config never changed do not get the condition
+		if existing := providerStatus.GetCondition(condition.Type); existing == nil || existing.Status == corev1.ConditionTrue {
+			return nil
+		}
+	case !providerconfig.KubeletConfigSupported(providerConfig.OperatingSystem):
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "NotSupported"
+		condition.Message = fmt.Sprintf("The kubelet config is not supported on %s", providerConfig.OperatingSystem)
+	case !c.reconcileKubeletConfig:
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "ReconcileDisabled"
+		condition.Message = "The kubelet config changed, but the machine-controller does not reconcile it. Re-create the machine to apply it"
+	default:
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "WaitingForNodeAgent"
+		condition.Message = fmt.Sprintf("Waiting for the node agent to apply the kubelet config on node %s", node.Name)
+	}
+	return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	})
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestControllerEnsureKubeletConfigReconciled(t *testing.T) {
+	maxPods := int32(60)
+	kubeletConfig := &providerconfig.KubeletConfig{EvictionHard: "memory.available<200Mi", MaxPods: &maxPods}
+	const args = "--eviction-hard=memory.available<200Mi --max-pods=60"
+
+	tests := []struct {
+		name            string
+		disabled        bool
+		kubeletConfig   *providerconfig.KubeletConfig
+		nodeAnnotations map[string]string
+		expectedArgs    *string
+		expectEvent     bool
+	}{
+		{
+			name:          "config gets pushed on join",
+			kubeletConfig: kubeletConfig,
+			expectedArgs:  strPtr(args),
+		},
+		{
+			name:            "changed config gets pushed and triggers a restart",
+			kubeletConfig:   kubeletConfig,
+			nodeAnnotations: map[string]string{AnnotationKubeletConfig: "--max-pods=110"},
+			expectedArgs:    strPtr(args),
+			expectEvent:     true,
+		},
+		{
+			name:            "removed config gets pushed",
+			nodeAnnotations: map[string]string{AnnotationKubeletConfig: args},
+			expectedArgs:    strPtr(""),
+			expectEvent:     true,
+		},
+		{
+			name:            "up to date config is kept",
+			kubeletConfig:   kubeletConfig,
+			nodeAnnotations: map[string]string{AnnotationKubeletConfig: args, AnnotationKubeletConfigApplied: args},
+			expectedArgs:    strPtr(args),
+		},
+		{
+			name: "node without config is left alone",
+		},
+		{
+			name:          "disabled",
+			disabled:      true,
+			kubeletConfig: kubeletConfig,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: test.nodeAnnotations}}
+			machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"}}
+			recorder := record.NewFakeRecorder(10)
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.recorder = recorder
+			controller.reconcileKubeletConfig = !test.disabled
+
+			providerConfig := &providerconfig.Config{KubeletConfig: test.kubeletConfig}
+			if err := controller.ensureKubeletConfigReconciled(machine, node, providerConfig); err != nil {
+				t.Fatalf("failed to reconcile kubelet config: %v", err)
+			}
+
+			updatedNode, err := controller.kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			annotation, annotated := updatedNode.Annotations[AnnotationKubeletConfig]
+			if test.expectedArgs == nil {
+				if annotated {
+					t.Errorf("expected no kubelet config annotation, got %q", annotation)
+				}
+			} else if annotation != *test.expectedArgs {
+				t.Errorf("expected kubelet config annotation %q, got %q", *test.expectedArgs, annotation)
+			}
+			if emitted := len(recorder.Events) > 0; emitted != test.expectEvent {
+				t.Errorf("expected event: %v, got %v", test.expectEvent, emitted)
+			}
+		})
+	}
+}
+
+func TestControllerRecordsKubeletConfigAppliedCondition(t *testing.T) {
+	maxPods := int32(110)
+	kubeletConfig := &providerconfig.KubeletConfig{MaxPods: &maxPods}
+	const (
+		args         = "--max-pods=110"
+		renderedArgs = "--max-pods=60"
+	)
+
+	tests := []struct {
+		name               string
+		disabled           bool
+		operatingSystem    providerconfig.OperatingSystem
+		rendered           *string
+		existingCondition  *providerconfig.Condition
+		nodeAnnotations    map[string]string
+		expectedConditions []providerconfig.Condition
+	}{
+		{
+			name:            "unchanged config",
+			operatingSystem: providerconfig.OperatingSystemUbuntu,
+			rendered:        strPtr(args),
+		},
+		{
+			name:            "unknown rendered config",
+			disabled:        true,
+			operatingSystem: providerconfig.OperatingSystemUbuntu,
+		},
+		{
+			name:            "changed config without reconciling",
+			disabled:        true,
+			operatingSystem: providerconfig.OperatingSystemUbuntu,
+			rendered:        strPtr(renderedArgs),
+			expectedConditions: []providerconfig.Condition{{
+				Type:   providerconfig.KubeletConfigAppliedConditionType,
+				Status: corev1.ConditionFalse,
+				Reason: "ReconcileDisabled",
+			}},
+		},
+		{
+			name:            "changed config waits for the node agent",
+			operatingSystem: providerconfig.OperatingSystemUbuntu,
+			rendered:        strPtr(renderedArgs),
+			expectedConditions: []providerconfig.Condition{{
+				Type:   providerconfig.KubeletConfigAppliedConditionType,
+				Status: corev1.ConditionFalse,
+				Reason: "WaitingForNodeAgent",
+			}},
+		},
+		{
+			name:              "changed config got applied by the node agent",
+			operatingSystem:   providerconfig.OperatingSystemUbuntu,
+			rendered:          strPtr(renderedArgs),
+			existingCondition: &providerconfig.Condition{Type: providerconfig.KubeletConfigAppliedConditionType, Status: corev1.ConditionFalse},
+			nodeAnnotations:   map[string]string{AnnotationKubeletConfig: args, AnnotationKubeletConfigApplied: args},
+			expectedConditions: []providerconfig.Condition{{
+				Type:   providerconfig.KubeletConfigAppliedConditionType,
+				Status: corev1.ConditionTrue,
+				Reason: "KubeletConfigApplied",
+			}},
+		},
+		{
+			name:            "operating system without support",
+			operatingSystem: providerconfig.OperatingSystemCoreos,
+			rendered:        strPtr(""),
+			expectedConditions: []providerconfig.Condition{{
+				Type:   providerconfig.KubeletConfigAppliedConditionType,
+				Status: corev1.ConditionFalse,
+				Reason: "NotSupported",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerStatus := &providerconfig.ProviderStatus{UserDataKubeletConfig: test.rendered}
+			if test.existingCondition != nil {
+				providerStatus.Conditions = []providerconfig.Condition{*test.existingCondition}
+			}
+			rawProviderStatus, err := providerStatus.RawExtension()
+			if err != nil {
+				t.Fatal(err)
+			}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: test.nodeAnnotations}}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+				Status:     clusterv1alpha1.MachineStatus{ProviderStatus: rawProviderStatus},
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.recorder = record.NewFakeRecorder(10)
+			controller.reconcileKubeletConfig = !test.disabled
+
+			providerConfig := &providerconfig.Config{OperatingSystem: test.operatingSystem, KubeletConfig: kubeletConfig}
+			if err := controller.ensureKubeletConfigReconciled(machine, node, providerConfig); err != nil {
+				t.Fatalf("failed to reconcile kubelet config: %v", err)
+			}
+
+			updatedMachine, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			updatedStatus, err := providerconfig.GetProviderStatus(updatedMachine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(updatedStatus.Conditions) != len(test.expectedConditions) {
+				t.Fatalf("expected conditions %v, got %v", test.expectedConditions, updatedStatus.Conditions)
+			}
+			for i, expected := range test.expectedConditions {
+				if actual := updatedStatus.Conditions[i]; actual.Type != expected.Type || actual.Status != expected.Status || actual.Reason != expected.Reason {
+					t.Errorf("expected condition %s=%s with reason %s, got %s=%s with reason %s",
+						expected.Type, expected.Status, expected.Reason, actual.Type, actual.Status, actual.Reason)
+				}
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
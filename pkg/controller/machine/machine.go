@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,9 +33,11 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -54,17 +57,22 @@ import (
 
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
 	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/events"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+	"github.com/kubermatic/machine-controller/pkg/phonehome"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatamanager "github.com/kubermatic/machine-controller/pkg/userdata/manager"
 	userdataplugin "github.com/kubermatic/machine-controller/pkg/userdata/plugin"
+	"github.com/kubermatic/machine-controller/pkg/version"
 )
 
 const (
+	// FinalizerDeleteInstance is the default name of the finalizer which protects the instance of a machine
 	FinalizerDeleteInstance = "machine-delete-finalizer"
-	FinalizerDeleteNode     = "machine-node-delete-finalizer"
+	// FinalizerDeleteNode is the default name of the finalizer which protects the node of a machine
+	FinalizerDeleteNode = "machine-node-delete-finalizer"
 
 	// AnnotationMachineUninitialized indicates that a machine is not yet
 	// ready to be worked on by the machine-controller. The machine-controller
@@ -72,11 +80,33 @@ const (
 	// Its value should consist of one or more initializers, separated by a comma
 	AnnotationMachineUninitialized = "machine-controller.kubermatic.io/initializers"
 
+	// AnnotationSkipNodeDeletion makes the machine-controller delete a machine without draining and deleting
+	// its node and without terminating its instance, e.g. to hand the node over to another management system
+	AnnotationSkipNodeDeletion = "machine.k8s.io/skip-node-deletion"
+
+	// AnnotationResolvedProviderConfig holds the provider config the ProviderConfigTemplate of a machine got resolved to
+	// the last time. A deleted machine falls back to it when its template is gone, so it can still be deleted
+	AnnotationResolvedProviderConfig = "machine-controller.kubermatic.io/resolved-provider-config"
+
 	deletionRetryWaitPeriod = 10 * time.Second
 
 	NodeOwnerLabelName = "machine-controller/owned-by"
+
+	// NodeControllerVersionLabelName is the label on the node containing the version of the machine-controller which provisioned it
+	NodeControllerVersionLabelName = "machine.k8s.io/controller-version"
+	// NodeUserDataPluginVersionAnnotationName is the annotation on the node containing the version of the userdata plugin
+	// which rendered the userdata of its instance
+	NodeUserDataPluginVersionAnnotationName = "machine.k8s.io/userdata-plugin-version"
 )
 
+// nodeDeletionBackoff is used to retry transient errors when deleting the node of a machine
+var nodeDeletionBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 500 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
 // Controller is the controller implementation for machine resources
 type Controller struct {
 	kubeClient    kubernetes.Interface
@@ -99,6 +129,38 @@ type Controller struct {
 	name                             string
 	bootstrapTokenServiceAccountName *types.NamespacedName
 	skipEvictionAfter                time.Duration
+	instanceEvents                   <-chan events.InstanceStateEvent
+	preCreateHook                    *PreCreateHook
+	deleteOnInstanceInterruption     bool
+	deleteStaleNodes                 bool
+	finalizerDeleteInstance          string
+	finalizerDeleteNode              string
+	drainNamespacePriorities         eviction.NamespacePriorities
+	phoneHome                        *phonehome.Receiver
+	startupTaints                    *StartupTaints
+	createLimiter                    *createLimiter
+	instanceRefresher                *instanceRefresher
+	nodeCredentialsRecovery          *NodeCredentialsRecovery
+	validateCredentials              bool
+	preDrainHook                     *PreDrainHook
+	drainExcludePodSelector          labels.Selector
+	baseUserData                     *BaseUserData
+	inPlaceResize                    bool
+	apiServerCircuitBreaker          *APIServerCircuitBreaker
+	daemonSetReadinessGate           *DaemonSetReadinessGate
+	nodeInstanceTypeLabel            string
+	drainBudget                      *DrainBudget
+	providerConfigTemplates          *providerconfig.TemplateResolver
+	recoverDeletingMachines          bool
+	volumeDetachTimeout              time.Duration
+	auditor                          *cloudprovider.Auditor
+	topologySpreadCheck              *TopologySpreadCheck
+	regionCircuitBreaker             *RegionCircuitBreaker
+	nodeTagLabels                    *NodeTagLabels
+	machineDeploymentLister          clusterlistersv1alpha1.MachineDeploymentLister
+	drainProgressUpdateInterval      time.Duration
+	reconcileKubeletConfig           bool
+	drainMaintenanceWindow           MaintenanceWindows
 }
 
 type KubeconfigProvider interface {
@@ -112,62 +174,228 @@ type MetricsCollection struct {
 	Errors  prometheus.Counter
 }
 
+// Options holds the dependencies and settings of the machine controller. Optional features are disabled
+// while their field is empty.
+type Options struct {
+	// KubeClient a client that knows how to consume kubernetes API
+	KubeClient kubernetes.Interface
+
+	// MachineClient a client that knows how to consume Machine resources
+	MachineClient clusterv1alpha1clientset.Interface
+
+	// ClusterDNSIPs essentially sets the cluster DNS IP addresses. The list is passed to kubelet and then down to pods.
+	ClusterDNSIPs []net.IP
+
+	// Metrics a struct that holds all metrics we want to collect
+	Metrics *MetricsCollection
+
+	// NodeInformer holds a shared informer for Nodes
+	NodeInformer cache.SharedIndexInformer
+
+	// NodeLister holds a lister that knows how to list Nodes from a cache
+	NodeLister listerscorev1.NodeLister
+
+	// SecretSystemNsLister knows hot to list Secrects that are inside kube-system namespace from a cache
+	SecretSystemNsLister listerscorev1.SecretLister
+
+	// PVLister knows how to list PersistentVolumes
+	PVLister listerscorev1.PersistentVolumeLister
+
+	// MachineInformer holds a shared informer for Machines
+	MachineInformer cache.SharedIndexInformer
+
+	// MachineLister holds a lister that knows how to list Machines from a cache
+	MachineLister clusterlistersv1alpha1.MachineLister
+
+	// MachineDeploymentLister holds a lister that knows how to list MachineDeployments from a cache
+	MachineDeploymentLister clusterlistersv1alpha1.MachineDeploymentLister
+
+	// KubeconfigProvider knows how to get cluster information stored under a ConfigMap
+	KubeconfigProvider KubeconfigProvider
+
+	// Name of the controller. When set the controller will only process machines with the label "machine.k8s.io/controller": name
+	Name string
+
+	// Name of the ServiceAccount from which the bootstrap token secret will be fetched. A bootstrap token will be created
+	// if this is nil
+	BootstrapTokenServiceAccountName *types.NamespacedName
+
+	// PrometheusRegisterer is used by the MachineController instance to register its metrics
+	PrometheusRegisterer prometheus.Registerer
+
+	// The timeout in which machines owned by a MachineSet must join the cluster to avoid being
+	// deleted by the machine-controller
+	JoinClusterTimeout *time.Duration
+
+	// Flag to initialize kubelets with --cloud-provider=external
+	ExternalCloudProvider bool
+
+	// Will instruct the machine-controller to skip the eviction if the machine deletion is older than SkipEvictionAfter
+	SkipEvictionAfter time.Duration
+
+	// InstanceEvents delivers instance state change events from the cloud provider. nil if not configured
+	InstanceEvents <-chan events.InstanceStateEvent
+
+	// PreCreateHook must allow the creation of instances. nil if not configured
+	PreCreateHook *PreCreateHook
+
+	// Delete machines as soon as a spot interruption warning for their instance is received
+	DeleteOnInstanceInterruption bool
+
+	// Delete nodes which have the name of the node of a new instance but belong to an instance which is gone
+	DeleteStaleNodes bool
+
+	// Names of the finalizers the controller adds to and removes from machines. Default to FinalizerDeleteInstance
+	// and FinalizerDeleteNode
+	FinalizerDeleteInstance string
+	FinalizerDeleteNode     string
+
+	// Eviction priorities of namespaces, pods in namespaces with a lower priority get evicted first
+	DrainNamespacePriorities eviction.NamespacePriorities
+
+	// PhoneHome receives the bootstrap status reported by nodes. nil if not configured
+	PhoneHome *phonehome.Receiver
+
+	// Taints of new nodes which get removed by external controllers. nil if not configured
+	StartupTaints *StartupTaints
+
+	// Re-provisions nodes whose client certificate expired. nil if not configured
+	NodeCredentialsRecovery *NodeCredentialsRecovery
+
+	// Validates the cloud provider credentials at startup and before provisioning machines
+	ValidateCredentials bool
+
+	// Asks the pods of node-local DaemonSets to shut down before draining their node. nil if not configured
+	PreDrainHook *PreDrainHook
+
+	// Pods matching this selector do not get evicted when draining a node. nil if not configured
+	DrainExcludePodSelector labels.Selector
+
+	// Org-wide cloud-config which gets merged into the userdata of all machines. nil if not configured
+	BaseUserData *BaseUserData
+
+	// Resizes the instances of machines whose instance type changed instead of keeping them
+	InPlaceResize bool
+
+	// Pauses the reconciliation of machines while the apiserver is unreachable. nil if not configured
+	APIServerCircuitBreaker *APIServerCircuitBreaker
+
+	// Makes machines wait for the pods of all DaemonSets in some namespaces to be ready on their node. nil if not configured
+	DaemonSetReadinessGate *DaemonSetReadinessGate
+
+	// Label which gets set to the instance type of the machine on its node. Empty if disabled
+	NodeInstanceTypeLabel string
+
+	// Limits the number of nodes which get drained at the same time across all MachineDeployments. nil if not configured
+	DrainBudget *DrainBudget
+
+	// Resolves the provider configs of machines which reference a ProviderConfigTemplate
+	ProviderConfigTemplates *providerconfig.TemplateResolver
+
+	// Completes or resumes the deletion of machines which were being deleted when the controller stopped
+	RecoverDeletingMachines bool
+
+	// How long the deletion of an instance waits for the VolumeAttachments of its node to be removed. 0 disables it
+	VolumeDetachTimeout time.Duration
+
+	// Records all mutations of cloud providers. nil if not configured
+	Auditor *cloudprovider.Auditor
+
+	// Delays drains until the evicted pods can be rescheduled within their topology spread constraints. nil if disabled
+	TopologySpreadCheck *TopologySpreadCheck
+
+	// Pauses the creation of instances in regions whose cloud provider calls keep failing. nil if not configured
+	RegionCircuitBreaker *RegionCircuitBreaker
+
+	// Syncs tags of the instances onto their nodes as labels. nil if not configured
+	NodeTagLabels *NodeTagLabels
+
+	// How often the progress of a drain gets recorded in the status of the machine. 0 disables it
+	DrainProgressUpdateInterval time.Duration
+
+	// Pushes the kubeletConfig of machines to their nodes to change it in place
+	ReconcileKubeletConfig bool
+
+	// The time ranges in which nodes may be drained. Empty allows drains at any time
+	DrainMaintenanceWindow MaintenanceWindows
+}
+
 // NewMachineController returns a new machine controller.
-func NewMachineController(
-	kubeClient kubernetes.Interface,
-	machineClient clusterv1alpha1clientset.Interface,
-	nodeInformer cache.SharedIndexInformer,
-	nodeLister listerscorev1.NodeLister,
-	machineInformer cache.SharedIndexInformer,
-	machineLister clusterlistersv1alpha1.MachineLister,
-	secretSystemNsLister listerscorev1.SecretLister,
-	pvLister listerscorev1.PersistentVolumeLister,
-	clusterDNSIPs []net.IP,
-	metrics *MetricsCollection,
-	prometheusRegistry prometheus.Registerer,
-	kubeconfigProvider KubeconfigProvider,
-	joinClusterTimeout *time.Duration,
-	externalCloudProvider bool,
-	name string,
-	bootstrapTokenServiceAccountName *types.NamespacedName,
-	skipEvictionAfter time.Duration,
-) (*Controller, error) {
+func NewMachineController(opts Options) (*Controller, error) {
 
 	if err := machinescheme.AddToScheme(scheme.Scheme); err != nil {
 		return nil, err
 	}
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.V(3).Infof)
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: opts.KubeClient.CoreV1().Events("")})
 
-	if prometheusRegistry != nil {
-		prometheusRegistry.MustRegister(metrics.Errors, metrics.Workers)
+	if opts.PrometheusRegisterer != nil {
+		opts.PrometheusRegisterer.MustRegister(opts.Metrics.Errors, opts.Metrics.Workers)
 	}
 
 	controller := &Controller{
-		kubeClient:  kubeClient,
-		nodesLister: nodeLister,
+		kubeClient:  opts.KubeClient,
+		nodesLister: opts.NodeLister,
 
-		machineClient:        machineClient,
-		machinesLister:       machineLister,
-		secretSystemNsLister: secretSystemNsLister,
+		machineClient:        opts.MachineClient,
+		machinesLister:       opts.MachineLister,
+		secretSystemNsLister: opts.SecretSystemNsLister,
 
 		workqueue: workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(1*time.Second, 5*time.Minute), "Machines"),
 		recorder:  eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machine-controller"}),
 
-		clusterDNSIPs:                    clusterDNSIPs,
-		metrics:                          metrics,
-		kubeconfigProvider:               kubeconfigProvider,
-		joinClusterTimeout:               joinClusterTimeout,
-		externalCloudProvider:            externalCloudProvider,
-		name:                             name,
-		bootstrapTokenServiceAccountName: bootstrapTokenServiceAccountName,
-		skipEvictionAfter:                skipEvictionAfter,
+		clusterDNSIPs:                    opts.ClusterDNSIPs,
+		metrics:                          opts.Metrics,
+		kubeconfigProvider:               opts.KubeconfigProvider,
+		joinClusterTimeout:               opts.JoinClusterTimeout,
+		externalCloudProvider:            opts.ExternalCloudProvider,
+		name:                             opts.Name,
+		bootstrapTokenServiceAccountName: opts.BootstrapTokenServiceAccountName,
+		skipEvictionAfter:                opts.SkipEvictionAfter,
+		instanceEvents:                   opts.InstanceEvents,
+		preCreateHook:                    opts.PreCreateHook,
+		deleteOnInstanceInterruption:     opts.DeleteOnInstanceInterruption,
+		deleteStaleNodes:                 opts.DeleteStaleNodes,
+		finalizerDeleteInstance:          opts.FinalizerDeleteInstance,
+		finalizerDeleteNode:              opts.FinalizerDeleteNode,
+		drainNamespacePriorities:         opts.DrainNamespacePriorities,
+		phoneHome:                        opts.PhoneHome,
+		startupTaints:                    opts.StartupTaints,
+		createLimiter:                    newCreateLimiter(),
+		instanceRefresher:                newInstanceRefresher(),
+		nodeCredentialsRecovery:          opts.NodeCredentialsRecovery,
+		validateCredentials:              opts.ValidateCredentials,
+		preDrainHook:                     opts.PreDrainHook,
+		drainExcludePodSelector:          opts.DrainExcludePodSelector,
+		baseUserData:                     opts.BaseUserData,
+		inPlaceResize:                    opts.InPlaceResize,
+		apiServerCircuitBreaker:          opts.APIServerCircuitBreaker,
+		daemonSetReadinessGate:           opts.DaemonSetReadinessGate,
+		nodeInstanceTypeLabel:            opts.NodeInstanceTypeLabel,
+		drainBudget:                      opts.DrainBudget,
+		providerConfigTemplates:          opts.ProviderConfigTemplates,
+		recoverDeletingMachines:          opts.RecoverDeletingMachines,
+		volumeDetachTimeout:              opts.VolumeDetachTimeout,
+		auditor:                          opts.Auditor,
+		topologySpreadCheck:              opts.TopologySpreadCheck,
+		regionCircuitBreaker:             opts.RegionCircuitBreaker,
+		nodeTagLabels:                    opts.NodeTagLabels,
+		machineDeploymentLister:          opts.MachineDeploymentLister,
+		drainProgressUpdateInterval:      opts.DrainProgressUpdateInterval,
+		reconcileKubeletConfig:           opts.ReconcileKubeletConfig,
+		drainMaintenanceWindow:           opts.DrainMaintenanceWindow,
+	}
+	if controller.finalizerDeleteInstance == "" {
+		controller.finalizerDeleteInstance = FinalizerDeleteInstance
+	}
+	if controller.finalizerDeleteNode == "" {
+		controller.finalizerDeleteNode = FinalizerDeleteNode
 	}
 
 	controller.machineCreateDeleteData = &cloudprovidertypes.MachineCreateDeleteData{
 		Updater:  controller.updateMachine,
-		PVLister: pvLister,
+		PVLister: opts.PVLister,
 	}
 
 	m, err := userdatamanager.New()
@@ -176,14 +404,14 @@ func NewMachineController(
 	}
 	controller.userDataManager = m
 
-	machineInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	opts.MachineInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueMachine,
 		UpdateFunc: func(old, new interface{}) {
 			controller.enqueueMachine(new)
 		},
 	})
 
-	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	opts.NodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.handleObject,
 		UpdateFunc: func(old, new interface{}) {
 			newNode := new.(*corev1.Node)
@@ -222,12 +450,39 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
+	// Runs before the workers start, so they do not process the recovered machines at the same time
+	if c.recoverDeletingMachines {
+		if err := c.recoverAllDeletingMachines(); err != nil {
+			glog.Errorf("Failed to recover machines which were being deleted, they get deleted on their next sync: %v", err)
+		}
+	}
+
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
+	// Without an event source we solely rely on the periodic resync of the informers
+	if c.instanceEvents != nil {
+		go c.watchInstanceEvents(stopCh)
+	}
+	if c.phoneHome != nil {
+		go c.watchPhoneHomeReports(stopCh)
+	}
+	if c.apiServerCircuitBreaker != nil {
+		go c.apiServerCircuitBreaker.run(stopCh)
+	}
+	if c.machineDeploymentLister != nil {
+		go wait.Until(c.reconcileWarmPools, warmPoolResyncPeriod, stopCh)
+	}
+
 	c.metrics.Workers.Set(float64(threadiness))
 
+	if c.validateCredentials {
+		if err := c.validateAllCredentials(); err != nil {
+			glog.Errorf("Cloud provider credentials validation failed, affected machines will not be provisioned: %v", err)
+		}
+	}
+
 	<-stopCh
 	return nil
 }
@@ -272,6 +527,12 @@ func (c *Controller) processNextWorkItem() bool {
 
 	defer c.workqueue.Done(key)
 
+	// Do not act on the possibly stale listers while the apiserver is unreachable
+	if c.apiServerCircuitBreaker.isOpen() {
+		c.workqueue.AddAfter(key, apiServerProbePeriod)
+		return true
+	}
+
 	glog.V(6).Infof("Processing machine: %s", key)
 	err := c.syncHandler(key.(string))
 	glog.V(6).Infof("Finished processing machine %s", key)
@@ -335,8 +596,44 @@ func (c *Controller) updateMachine(machine *clusterv1alpha1.Machine, modify func
 
 		return nil
 	})
+	if err != nil {
+		return machine, err
+	}
+
+	// The machine got read from the lister, so its provider config must be resolved again
+	return machine, c.resolveProviderConfigTemplate(machine)
+}
 
-	return machine, err
+// resolveProviderConfigTemplate merges the provider config of the machine on top of the ProviderConfigTemplate
+// it references. The resolved config must never be written back, the machine has to keep referencing the template
+func (c *Controller) resolveProviderConfigTemplate(machine *clusterv1alpha1.Machine) error {
+	providerSpec, err := c.providerConfigTemplates.Resolve(machine.Namespace, machine.Spec.ProviderSpec)
+	if err != nil {
+		resolved := machine.Annotations[AnnotationResolvedProviderConfig]
+		if machine.DeletionTimestamp == nil || resolved == "" {
+			return fmt.Errorf("failed to resolve provider config template: %v", err)
+		}
+		glog.Warningf("Failed to resolve the provider config template of the deleted machine %q, using the last resolved config: %v", machine.Name, err)
+		machine.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(resolved)}
+		return nil
+	}
+	machine.Spec.ProviderSpec = providerSpec
+	return nil
+}
+
+// ensureResolvedProviderConfigRecorded stores the resolved provider config of a machine which references a
+// ProviderConfigTemplate, so the machine can still be deleted after its template got deleted
+func (c *Controller) ensureResolvedProviderConfigRecorded(machine *clusterv1alpha1.Machine) (*clusterv1alpha1.Machine, error) {
+	resolved := string(machine.Spec.ProviderSpec.Value.Raw)
+	if machine.Annotations[AnnotationResolvedProviderConfig] == resolved {
+		return machine, nil
+	}
+	return c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[AnnotationResolvedProviderConfig] = resolved
+	})
 }
 
 // updateMachine updates machine's ErrorMessage and ErrorReason regardless if they were set or not
@@ -361,11 +658,14 @@ func (c *Controller) updateMachineErrorIfTerminalError(machine *clusterv1alpha1.
 	return fmt.Errorf("%s, due to %v", errMsg, err)
 }
 
-func (c *Controller) createProviderInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, userdata string) (instance.Instance, error) {
+func (c *Controller) createProviderInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, userdata, region string) (instance.Instance, error) {
 	instance, err := prov.Create(machine, c.machineCreateDeleteData, userdata)
+	c.regionCircuitBreaker.record(region, err, time.Now())
 	if err != nil {
+		c.setLastProviderError(machine, providerOperationCreate, err)
 		return nil, err
 	}
+	c.clearLastProviderError(machine)
 	// Ensure finalizer is there
 	_, err = c.ensureDeleteFinalizerExists(machine)
 	return instance, err
@@ -410,6 +710,16 @@ func (c *Controller) sync(machine *clusterv1alpha1.Machine) error {
 		machine.Spec.Name = machine.Name
 	}
 
+	referencesTemplate := providerconfig.HasTemplateRef(machine.Spec.ProviderSpec)
+	if err := c.resolveProviderConfigTemplate(machine); err != nil {
+		return err
+	}
+	if referencesTemplate && machine.DeletionTimestamp == nil {
+		var err error
+		if machine, err = c.ensureResolvedProviderConfigRecorded(machine); err != nil {
+			return fmt.Errorf("failed to record the resolved provider config: %v", err)
+		}
+	}
 	providerConfig, err := providerconfig.GetConfig(machine.Spec.ProviderSpec)
 	if err != nil {
 		return fmt.Errorf("failed to get provider config: %v", err)
@@ -419,6 +729,18 @@ func (c *Controller) sync(machine *clusterv1alpha1.Machine) error {
 	if err != nil {
 		return fmt.Errorf("failed to get cloud provider %q: %v", providerConfig.CloudProvider, err)
 	}
+	prov = c.auditor.Wrap(prov, providerConfig.CloudProvider)
+	// The webhook validated the merged config when the machine got created, but the template may have changed since
+	if referencesTemplate && machine.DeletionTimestamp == nil && machine.Status.NodeRef == nil {
+		if err := prov.Validate(machine.Spec); err != nil {
+			return fmt.Errorf("invalid provider config of template: %v", err)
+		}
+	}
+	if machine.DeletionTimestamp == nil {
+		if err := c.ensureProviderSpecFieldsKnown(machine); err != nil {
+			return err
+		}
+	}
 
 	// step 2: check if a user requested to delete the machine
 	if machine.DeletionTimestamp != nil {
@@ -433,6 +755,9 @@ func (c *Controller) sync(machine *clusterv1alpha1.Machine) error {
 
 	// case 3.2: creates an instance if there is no node associated with the given machine
 	if machine.Status.NodeRef == nil {
+		if err := c.ensureCredentialsValid(prov, machine); err != nil {
+			return err
+		}
 		return c.ensureInstanceExistsForMachine(prov, machine, userdataPlugin, providerConfig)
 	}
 
@@ -449,19 +774,79 @@ func (c *Controller) sync(machine *clusterv1alpha1.Machine) error {
 		return fmt.Errorf("failed to check if node for machine exists: '%s'", err)
 	}
 
+	if deleted, err := c.checkStartupTaints(machine, node); err != nil || deleted {
+		return err
+	}
+
+	// The stopped node of a warm pool machine must not be treated like a failed one
+	if handled, err := c.ensureWarmPoolInstanceStopped(prov, machine, node); err != nil || handled {
+		return err
+	}
+
+	// The node is not ready while the instance gets stopped for the resize
+	if resizing, err := c.ensureInstanceResized(prov, machine, node); err != nil || resizing {
+		return err
+	}
+
+	// The instance is fetched at most once per instanceRefreshPeriod, its addresses and tags rarely change
+	var providerInstance instance.Instance
+	uid := machine.UID
 	if c.nodeIsReady(node) {
-		// We must do this to ensure the informers in the machineSet and machineDeployment controller
-		// get triggered as soon as a ready node exists for a machine
-		if machine, err = c.ensureMachineHasNodeReadyCondition(machine); err != nil {
+		if machine, err = c.ensureMachineProvisioned(machine, node); err != nil {
 			return fmt.Errorf("failed to set nodeReady condition on machine: %v", err)
 		}
+		if providerInstance, err = c.refreshInstance(prov, machine); err != nil {
+			return err
+		}
+		if machine, err = c.ensureMachineAddresses(providerInstance, machine); err != nil {
+			c.instanceRefresher.invalidate(uid)
+			return fmt.Errorf("failed to update addresses of machine: %v", err)
+		}
+		if err := c.ensureNodeClientCertExpirationRecorded(machine, node); err != nil {
+			return fmt.Errorf("failed to record client certificate expiration of node %s: %v", node.Name, err)
+		}
 	} else {
+		if recovering, err := c.recoverExpiredNodeCredentials(prov, machine, node); err != nil || recovering {
+			return err
+		}
 		// Node is not ready anymore? Maybe it got deleted
 		return c.ensureInstanceExistsForMachine(prov, machine, userdataPlugin, providerConfig)
 	}
 
 	// case 3.3: if the node exists make sure if it has labels and taints attached to it.
-	return c.ensureNodeLabelsAnnotationsAndTaints(node, machine)
+	if err := c.ensureNodeLabelsAnnotationsAndTaints(node, machine); err != nil {
+		return err
+	}
+	if err := c.ensureNodeInstanceTypeLabel(prov, machine, node); err != nil {
+		return err
+	}
+	if err := c.ensureNodeTagLabels(providerInstance, machine, node); err != nil {
+		c.instanceRefresher.invalidate(uid)
+		return err
+	}
+	if err := c.ensureKubeletConfigReconciled(machine, node, providerConfig); err != nil {
+		return err
+	}
+	return c.ensureWarmPoolTaintRemoved(machine, node)
+}
+
+// ensureMachineProvisioned marks the machine of a ready node as provisioned once the node passed the readiness gates
+func (c *Controller) ensureMachineProvisioned(machine *clusterv1alpha1.Machine, node *corev1.Node) (*clusterv1alpha1.Machine, error) {
+	passed, err := c.readinessGatePassed(machine, node)
+	if err != nil || !passed {
+		return machine, err
+	}
+	passed, err = c.daemonSetReadinessGatePassed(machine, node)
+	if err != nil || !passed {
+		return machine, err
+	}
+	passed, err = c.gpuReadinessGatePassed(machine, node)
+	if err != nil || !passed {
+		return machine, err
+	}
+	// We must do this to ensure the informers in the machineSet and machineDeployment controller
+	// get triggered as soon as a ready node exists for a machine
+	return c.ensureMachineHasNodeReadyCondition(machine)
 }
 
 func (c *Controller) ensureMachineHasNodeReadyCondition(machine *clusterv1alpha1.Machine) (*clusterv1alpha1.Machine, error) {
@@ -481,7 +866,7 @@ func (c *Controller) ensureMachineHasNodeReadyCondition(machine *clusterv1alpha1
 func (c *Controller) shouldEvict(machine *clusterv1alpha1.Machine) (bool, error) {
 	// If the deletion got triggered a few hours ago, skip eviction.
 	// We assume here that the eviction is blocked by misconfiguration or a misbehaving kubelet and/or controller-runtime
-	if time.Since(machine.DeletionTimestamp.Time) > c.skipEvictionAfter {
+	if time.Since(evictionStart(machine)) > c.skipEvictionAfter {
 		glog.V(0).Infof("Skipping eviction for machine %q since the deletion got triggered %.2f minutes ago", machine.Name, c.skipEvictionAfter.Minutes())
 		return false, nil
 	}
@@ -504,19 +889,100 @@ func (c *Controller) shouldEvict(machine *clusterv1alpha1.Machine) (bool, error)
 	return true, nil
 }
 
+// drainGateConditionTypes are the conditions of the steps the drain of a deleted machine waits for
+var drainGateConditionTypes = []providerconfig.ConditionType{
+	providerconfig.PreDrainDeleteHookSucceededConditionType,
+	providerconfig.DrainMaintenanceWindowOpenConditionType,
+	providerconfig.DrainSlotAcquiredConditionType,
+	providerconfig.TopologySpreadSatisfiedConditionType,
+}
+
+// evictionStart returns since when the node of the deleted machine may be evicted. Time spent waiting for the drain
+// gates does not count, so machines which waited long for them still get drained.
+func evictionStart(machine *clusterv1alpha1.Machine) time.Time {
+	start := machine.DeletionTimestamp.Time
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return start
+	}
+	for _, conditionType := range drainGateConditionTypes {
+		condition := providerStatus.GetCondition(conditionType)
+		if condition == nil {
+			continue
+		}
+		if condition.Status != corev1.ConditionTrue {
+			return time.Now()
+		}
+		if condition.LastTransitionTime.After(start) {
+			start = condition.LastTransitionTime.Time
+		}
+	}
+	return start
+}
+
+// openDrainGate sets the condition of a drain gate to true once the machine does not need to wait for it anymore.
+// Machines which never waited for the gate do not get the condition.
+func (c *Controller) openDrainGate(machine *clusterv1alpha1.Machine, conditionType providerconfig.ConditionType, reason, message string) error {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+	}
+	if condition := providerStatus.GetCondition(conditionType); condition == nil || condition.Status == corev1.ConditionTrue {
+		return nil
+	}
+	return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(providerconfig.Condition{
+			Type:    conditionType,
+			Status:  corev1.ConditionTrue,
+			Reason:  reason,
+			Message: message,
+		})
+	})
+}
+
 // deleteMachine makes sure that an instance has gone in a series of steps.
 func (c *Controller) deleteMachine(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) error {
+	if machine.Annotations[AnnotationSkipNodeDeletion] == "true" {
+		return c.releaseMachine(machine)
+	}
+
+	if done, err := c.waitForDeleteHooks(machine, PreDrainDeleteHookAnnotationPrefix, providerconfig.PreDrainDeleteHookSucceededConditionType, "drain"); err != nil || !done {
+		return err
+	}
+
 	shouldEvict, err := c.shouldEvict(machine)
 	if err != nil {
 		return err
 	}
 
 	if shouldEvict {
-		if err := eviction.New(machine.Status.NodeRef.Name, c.nodesLister, c.kubeClient).Run(); err != nil {
+		if satisfiable, err := c.checkTopologySpread(machine); err != nil || !satisfiable {
+			return err
+		}
+		if open, err := c.waitForDrainMaintenanceWindow(machine, time.Now()); err != nil || !open {
+			return err
+		}
+		if acquired, err := c.acquireDrainSlot(machine); err != nil || !acquired {
+			return err
+		}
+		if done, err := c.runPreDrainHook(machine, machine.Status.NodeRef.Name); err != nil || !done {
+			return err
+		}
+		if err := eviction.New(machine.Status.NodeRef.Name, c.nodesLister, c.kubeClient, c.drainNamespacePriorities, c.drainExcludePodSelector, c.drainProgressReporter(machine)).Run(); err != nil {
 			return fmt.Errorf("failed to evict node %s: %v", machine.Status.NodeRef.Name, err)
 		}
 	}
 
+	if done, err := c.waitForVolumeDetach(machine); err != nil || !done {
+		return err
+	}
+
+	if sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
+		if done, err := c.waitForDeleteHooks(machine, PreTerminateDeleteHookAnnotationPrefix, providerconfig.PreTerminateDeleteHookSucceededConditionType, "termination of the instance"); err != nil || !done {
+			return err
+		}
+	}
+
 	if err := c.deleteCloudProviderInstance(prov, machine); err != nil {
 		return err
 	}
@@ -524,9 +990,9 @@ func (c *Controller) deleteMachine(prov cloudprovidertypes.Provider, machine *cl
 	// Delete the node object only after the instance is gone, `deleteCloudProviderInstance`
 	// returns with a nil-error after it triggers the instance deletion but it is async for
 	// some providers hence the instance deletion may not been executed yet
-	// `FinalizerDeleteInstance` stays until the instance is really gone thought, so we check
+	// The delete instance finalizer stays until the instance is really gone thought, so we check
 	// for that here
-	if sets.NewString(machine.Finalizers...).Has(FinalizerDeleteInstance) {
+	if sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
 		return nil
 	}
 
@@ -539,17 +1005,20 @@ func (c *Controller) deleteMachine(prov cloudprovidertypes.Provider, machine *cl
 
 func (c *Controller) deleteCloudProviderInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) error {
 	finalizers := sets.NewString(machine.Finalizers...)
-	if !finalizers.Has(FinalizerDeleteInstance) {
+	if !finalizers.Has(c.finalizerDeleteInstance) {
 		return nil
 	}
 
 	// Delete the instance
 	completelyGone, err := prov.Cleanup(machine, c.machineCreateDeleteData)
 	if err != nil {
-		message := fmt.Sprintf("%v. Please manually delete %s finalizer from the machine object.", err, FinalizerDeleteInstance)
+		c.setLastProviderError(machine, providerOperationDelete, err)
+		message := fmt.Sprintf("%v. Please manually delete %s finalizer from the machine object.", err, c.finalizerDeleteInstance)
 		return c.updateMachineErrorIfTerminalError(machine, common.DeleteMachineError, message, err, "failed to delete machine at cloud provider")
 	}
 
+	c.clearLastProviderError(machine)
+
 	if !completelyGone {
 		// As the instance is not completely gone yet, we need to recheck in a few seconds.
 		c.enqueueMachineAfter(machine, deletionRetryWaitPeriod)
@@ -558,13 +1027,32 @@ func (c *Controller) deleteCloudProviderInstance(prov cloudprovidertypes.Provide
 
 	machine, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
 		finalizers := sets.NewString(m.Finalizers...)
-		finalizers.Delete(FinalizerDeleteInstance)
+		finalizers.Delete(c.finalizerDeleteInstance)
 		m.Finalizers = finalizers.List()
 	})
 
 	return err
 }
 
+// releaseMachine removes the finalizers of the machine without touching its instance and node
+func (c *Controller) releaseMachine(machine *clusterv1alpha1.Machine) error {
+	finalizers := sets.NewString(machine.Finalizers...)
+	if !finalizers.HasAny(c.finalizerDeleteInstance, c.finalizerDeleteNode) {
+		return nil
+	}
+
+	glog.V(3).Infof("Skipping instance and node deletion for machine %q as it has the %s annotation", machine.Name, AnnotationSkipNodeDeletion)
+	if machine.Status.NodeRef != nil {
+		c.recorder.Eventf(machine, corev1.EventTypeNormal, "NodeDeletionSkipped", "Node %s and its instance are kept", machine.Status.NodeRef.Name)
+	}
+	_, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		finalizers := sets.NewString(m.Finalizers...)
+		finalizers.Delete(c.finalizerDeleteInstance, c.finalizerDeleteNode)
+		m.Finalizers = finalizers.List()
+	})
+	return err
+}
+
 func ownedNodesPredicateFactory(machine *clusterv1alpha1.Machine) func(*corev1.Node) bool {
 	return func(node *corev1.Node) bool {
 		labels := node.GetLabels()
@@ -585,16 +1073,16 @@ func (c *Controller) deleteNodeForMachine(machine *clusterv1alpha1.Machine) erro
 	}
 
 	for _, node := range nodesList {
-		if err := c.kubeClient.CoreV1().Nodes().Delete(node.Name, nil); err != nil {
-			return err
+		if err := c.deleteNode(node.Name); err != nil {
+			return fmt.Errorf("failed to delete node %s: %v", node.Name, err)
 		}
 	}
 
 	finalizers := sets.NewString(machine.Finalizers...)
-	if finalizers.Has(FinalizerDeleteNode) {
+	if finalizers.Has(c.finalizerDeleteNode) {
 		_, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
 			finalizers := sets.NewString(m.Finalizers...)
-			finalizers.Delete(FinalizerDeleteNode)
+			finalizers.Delete(c.finalizerDeleteNode)
 			m.Finalizers = finalizers.List()
 		})
 	}
@@ -602,10 +1090,44 @@ func (c *Controller) deleteNodeForMachine(machine *clusterv1alpha1.Machine) erro
 	return err
 }
 
+// deleteNode deletes the node and retries transient errors with nodeDeletionBackoff. A node which is
+// already gone, e.g. because another controller deleted it in the meantime, counts as deleted
+func (c *Controller) deleteNode(name string) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(nodeDeletionBackoff, func() (bool, error) {
+		lastErr = c.kubeClient.CoreV1().Nodes().Delete(name, nil)
+		if lastErr == nil || kerrors.IsNotFound(lastErr) {
+			return true, nil
+		}
+		if isTransientAPIError(lastErr) {
+			glog.V(4).Infof("Retrying deletion of node %s after transient error: %v", name, lastErr)
+			return false, nil
+		}
+		return false, lastErr
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+// isTransientAPIError returns true for errors of the API server which are worth retrying
+func isTransientAPIError(err error) bool {
+	return kerrors.IsServerTimeout(err) ||
+		kerrors.IsTimeout(err) ||
+		kerrors.IsTooManyRequests(err) ||
+		kerrors.IsServiceUnavailable(err) ||
+		kerrors.IsInternalError(err)
+}
+
 func (c *Controller) ensureInstanceExistsForMachine(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, userdataPlugin userdataplugin.Provider, providerConfig *providerconfig.Config) error {
 	glog.V(6).Infof("Requesting instance for machine '%s' from cloudprovider because no associated node with status ready found...", machine.Name)
 
 	providerInstance, err := prov.Get(machine)
+	region := c.machineRegion(prov, machine, providerConfig)
+	if err != cloudprovidererrors.ErrInstanceNotFound {
+		c.regionCircuitBreaker.record(region, err, time.Now())
+	}
 
 	// case 2: retrieving instance from provider was not successful
 	if err != nil {
@@ -614,32 +1136,72 @@ func (c *Controller) ensureInstanceExistsForMachine(prov cloudprovidertypes.Prov
 		if err == cloudprovidererrors.ErrInstanceNotFound {
 			glog.V(3).Infof("Validated machine spec of %s", machine.Name)
 
+			allowed, err := c.checkPreCreateHook(machine)
+			if err != nil {
+				return fmt.Errorf("failed to check pre-create hook: %v", err)
+			}
+			if !allowed {
+				glog.V(3).Infof("Pre-create hook did not allow the creation of an instance for machine %s yet", machine.Name)
+				return nil
+			}
+
+			if claimed, err := c.claimWarmPoolInstance(prov, machine, providerConfig); err != nil || claimed {
+				return err
+			}
+
+			if !c.checkRegionCircuitBreaker(machine, region) {
+				return nil
+			}
+
+			releaseCreateSlot, acquired := c.acquireCreateSlot(machine)
+			if !acquired {
+				c.enqueueMachineAfter(machine, createLimitRequeuePeriod)
+				return nil
+			}
+			defer releaseCreateSlot()
+
 			kubeconfig, err := c.createBootstrapKubeconfig(machine.Name)
 			if err != nil {
 				return fmt.Errorf("failed to create bootstrap kubeconfig: %v", err)
 			}
 
+			// The versions get looked up before rendering, so they never claim a newer secret than the one
+			// the userdata got rendered with
+			secretVersions, err := providerconfig.NewConfigVarResolver(c.kubeClient).GetSecretVersions(machine.Spec.ProviderSpec)
+			if err != nil {
+				return fmt.Errorf("failed to get the versions of the secrets referenced by the userdata: %v", err)
+			}
 			cloudConfig, cloudProviderName, err := prov.GetCloudConfig(machine.Spec)
 			if err != nil {
 				return fmt.Errorf("failed to render cloud config: %v", err)
 			}
-			userdata, err := userdataPlugin.UserData(machine.Spec, kubeconfig, cloudConfig, cloudProviderName, c.clusterDNSIPs, c.externalCloudProvider)
+			userdata, err := userdataPlugin.UserData(machine.Spec, kubeconfig, cloudConfig, cloudProviderName, c.clusterDNSIPs, c.externalCloudProvider, c.phoneHomeFor(machine))
 			if err != nil {
 				return fmt.Errorf("failed get userdata: %v", err)
 			}
+			if c.baseUserData != nil {
+				if userdata, err = c.baseUserData.merge(userdata); err != nil {
+					return fmt.Errorf("failed to merge base userdata: %v", err)
+				}
+			}
 
 			// Create the instance
-			if _, err = c.createProviderInstance(prov, machine, userdata); err != nil {
+			if _, err = c.createProviderInstance(prov, machine, userdata, region); err != nil {
 				message := fmt.Sprintf("%v. Unable to create a machine.", err)
 				return c.updateMachineErrorIfTerminalError(machine, common.CreateMachineError, message, err, "failed to create machine at cloudprover")
 			}
 			c.recorder.Event(machine, corev1.EventTypeNormal, "Created", "Successfully created instance")
 			glog.V(3).Infof("Created machine %s at cloud provider", machine.Name)
+			if err := c.setUserDataProvenance(machine, userdataPlugin, secretVersions, providerConfig); err != nil {
+				return err
+			}
 			// Reqeue the machine to make sure we notice if creation failed silently
 			c.enqueueMachineAfter(machine, 30*time.Second)
 			return nil
 		}
 
+		c.setLastProviderError(machine, providerOperationGet, err)
+
 		// case 2.2: terminal error was returned and manual interaction is required to recover
 		if ok, _, _ := cloudprovidererrors.IsTerminalError(err); ok {
 			message := fmt.Sprintf("%v. Unable to create a machine.", err)
@@ -654,18 +1216,19 @@ func (c *Controller) ensureInstanceExistsForMachine(prov cloudprovidertypes.Prov
 	if err != nil {
 		return err
 	}
+	// The instance taken over from a warm pool machine is still stopped
+	if starting, err := c.ensureWarmPoolInstanceStarted(prov, machine); err != nil || starting {
+		return err
+	}
 
 	// case 3: retrieving the instance from cloudprovider was successful
 	// Emit an event and update .Status.Addresses
-	addresses := providerInstance.Addresses()
-	eventMessage := fmt.Sprintf("Found instance at cloud provider, addresses: %v", addresses)
+	c.clearLastProviderError(machine)
+	addresses := machineAddresses(providerInstance)
+	eventMessage := fmt.Sprintf("Found instance at cloud provider, addresses: %v", providerInstance.Addresses())
 	c.recorder.Event(machine, corev1.EventTypeNormal, "InstanceFound", eventMessage)
-	machineAddresses := []corev1.NodeAddress{}
-	for _, address := range addresses {
-		machineAddresses = append(machineAddresses, corev1.NodeAddress{Address: address})
-	}
 	machine, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
-		m.Status.Addresses = machineAddresses
+		m.Status.Addresses = addresses
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update machine after setting .status.addresses: %v", err)
@@ -673,6 +1236,31 @@ func (c *Controller) ensureInstanceExistsForMachine(prov cloudprovidertypes.Prov
 	return c.ensureNodeOwnerRefAndConfigSource(providerInstance, machine, providerConfig)
 }
 
+// machineAddresses returns the addresses of the instance for the machine status. They are sorted, so the
+// status only changes when the addresses do
+func machineAddresses(providerInstance instance.Instance) []corev1.NodeAddress {
+	addresses := []corev1.NodeAddress{}
+	for address, addressType := range providerInstance.Addresses() {
+		addresses = append(addresses, corev1.NodeAddress{Address: address, Type: addressType})
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].Address < addresses[j].Address
+	})
+	return addresses
+}
+
+// ensureMachineAddresses updates .status.addresses of a provisioned machine with the addresses the cloud
+// provider reports for its instance, e.g. after an IP got attached to it. Nothing is done without an instance
+func (c *Controller) ensureMachineAddresses(providerInstance instance.Instance, machine *clusterv1alpha1.Machine) (*clusterv1alpha1.Machine, error) {
+	if providerInstance == nil {
+		return machine, nil
+	}
+	addresses := machineAddresses(providerInstance)
+	return c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		m.Status.Addresses = addresses
+	})
+}
+
 func (c *Controller) ensureNodeOwnerRefAndConfigSource(providerInstance instance.Instance, machine *clusterv1alpha1.Machine, providerConfig *providerconfig.Config) error {
 	node, exists, err := c.getNode(providerInstance, providerConfig.CloudProvider)
 	if err != nil {
@@ -695,11 +1283,18 @@ func (c *Controller) ensureNodeOwnerRefAndConfigSource(providerInstance instance
 			}
 			glog.V(3).Infof("Added config source to node %s (machine %s)", node.Name, machine.Name)
 		}
+		if err := c.ensureNodeVersionProvenance(node, machine); err != nil {
+			return err
+		}
 		err = c.updateMachineStatus(machine, node)
 		if err != nil {
 			return fmt.Errorf("failed to update machine status: %v", err)
 		}
 	} else {
+		if err := c.deleteStaleNode(providerInstance, machine, providerConfig.CloudProvider); err != nil {
+			return err
+		}
+
 		// If the machine has an owner Ref and joinClusterTimeout is configured and reached, delete it to have it re-created by the MachineSet controller
 		// Check if the machine is a potential candidate for triggering deletion
 		if c.joinClusterTimeout != nil && ownerReferencesHasMachineSetKind(machine.OwnerReferences) {
@@ -717,6 +1312,90 @@ func (c *Controller) ensureNodeOwnerRefAndConfigSource(providerInstance instance
 	return nil
 }
 
+// setUserDataProvenance records the version of the userdata plugin which rendered the userdata of the machine
+// and the versions of the secrets and the kubelet flags the userdata got rendered with
+func (c *Controller) setUserDataProvenance(machine *clusterv1alpha1.Machine, userdataPlugin userdataplugin.Provider, secretVersions map[string]string, providerConfig *providerconfig.Config) error {
+	var kubeletConfig string
+	if providerconfig.KubeletConfigSupported(providerConfig.OperatingSystem) {
+		kubeletConfig = providerConfig.KubeletConfig.Args()
+	}
+	versionedPlugin, ok := userdataPlugin.(interface{ Version() string })
+	return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		if ok {
+			s.UserDataPluginVersion = versionedPlugin.Version()
+		}
+		s.UserDataSecretVersions = secretVersions
+		s.UserDataKubeletConfig = &kubeletConfig
+	})
+}
+
+// ensureNodeVersionProvenance stamps the versions of the machine-controller and the userdata plugin
+// which provisioned the node onto the node and the machine.
+func (c *Controller) ensureNodeVersionProvenance(node *corev1.Node, machine *clusterv1alpha1.Machine) error {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+	}
+
+	// Versions with build metadata are no valid label values
+	setLabel := len(validation.IsValidLabelValue(version.Version)) == 0
+	labelUpToDate := !setLabel || node.Labels[NodeControllerVersionLabelName] == version.Version
+	annotationUpToDate := providerStatus.UserDataPluginVersion == "" ||
+		node.Annotations[NodeUserDataPluginVersionAnnotationName] == providerStatus.UserDataPluginVersion
+	if !labelUpToDate || !annotationUpToDate {
+		if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+			if setLabel {
+				if n.Labels == nil {
+					n.Labels = map[string]string{}
+				}
+				n.Labels[NodeControllerVersionLabelName] = version.Version
+			}
+			if providerStatus.UserDataPluginVersion != "" {
+				if n.Annotations == nil {
+					n.Annotations = map[string]string{}
+				}
+				n.Annotations[NodeUserDataPluginVersionAnnotationName] = providerStatus.UserDataPluginVersion
+			}
+		}); err != nil {
+			return fmt.Errorf("failed to update node %s after setting the version labels: %v", node.Name, err)
+		}
+		glog.V(3).Infof("Added version labels to node %s (machine %s)", node.Name, machine.Name)
+	}
+
+	if providerStatus.ControllerVersion != version.Version {
+		return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.ControllerVersion = version.Version
+		})
+	}
+	return nil
+}
+
+// updateProviderStatus applies the given modification to the providerStatus of the machine
+func (c *Controller) updateProviderStatus(machine *clusterv1alpha1.Machine, modify func(*providerconfig.ProviderStatus)) error {
+	var modifyErr error
+	_, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		providerStatus, err := providerconfig.GetProviderStatus(m.Status.ProviderStatus)
+		if err != nil {
+			modifyErr = fmt.Errorf("failed to get provider status: %v", err)
+			return
+		}
+		modify(providerStatus)
+		rawProviderStatus, err := providerStatus.RawExtension()
+		if err != nil {
+			modifyErr = fmt.Errorf("failed to marshal provider status: %v", err)
+			return
+		}
+		m.Status.ProviderStatus = rawProviderStatus
+	})
+	if modifyErr != nil {
+		return modifyErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update provider status of machine %s: %v", machine.Name, err)
+	}
+	return nil
+}
+
 func ownerReferencesHasMachineSetKind(ownerReferences []metav1.OwnerReference) bool {
 	for _, ownerReference := range ownerReferences {
 		if ownerReference.Kind == "MachineSet" {
@@ -803,30 +1482,106 @@ func (c *Controller) getNode(instance instance.Instance, provider providerconfig
 		return nil, false, err
 	}
 
-	// We trim leading slashes in raw ID, since we always want three slashes in full ID
-	providerID := fmt.Sprintf("%s:///%s", provider, strings.TrimLeft(instance.ID(), "/"))
 	for _, node := range nodes {
-		if provider == providerconfig.CloudProviderAzure {
-			// Azure IDs are case-insensitive
-			if strings.EqualFold(node.Spec.ProviderID, providerID) {
-				return node.DeepCopy(), true, nil
-			}
-		} else {
-			if node.Spec.ProviderID == providerID {
-				return node.DeepCopy(), true, nil
-			}
+		if providerIDMatches(node, instance, provider) {
+			return node.DeepCopy(), true, nil
 		}
 		for _, nodeAddress := range node.Status.Addresses {
-			for _, instanceAddress := range instance.Addresses() {
-				if nodeAddress.Address == instanceAddress {
-					return node.DeepCopy(), true, nil
-				}
+			if _, exists := instance.Addresses()[nodeAddress.Address]; exists {
+				return node.DeepCopy(), true, nil
 			}
 		}
 	}
 	return nil, false, nil
 }
 
+// ccmProviderIDSchemes are the schemes of the providerIDs the external cloud controller managers set, where they
+// differ from the name of the cloud provider
+var ccmProviderIDSchemes = map[providerconfig.CloudProvider]string{
+	providerconfig.CloudProviderHetzner: "hcloud",
+}
+
+func providerIDMatches(node *corev1.Node, providerInstance instance.Instance, provider providerconfig.CloudProvider) bool {
+	parts := strings.SplitN(node.Spec.ProviderID, "://", 2)
+	if len(parts) != 2 || (parts[0] != string(provider) && parts[0] != ccmProviderIDSchemes[provider]) {
+		return false
+	}
+	// The in-tree cloud providers use three slashes, most external cloud controller managers two
+	nodeID := strings.TrimLeft(parts[1], "/")
+	instanceID := strings.TrimLeft(providerInstance.ID(), "/")
+	if providerIDer, ok := providerInstance.(instance.ProviderIDer); ok {
+		instanceID = providerIDer.ProviderID()
+	}
+	if provider == providerconfig.CloudProviderAzure || provider == providerconfig.CloudProviderVsphere {
+		// Azure IDs and vSphere UUIDs are case-insensitive
+		return strings.EqualFold(nodeID, instanceID)
+	}
+	return nodeID == instanceID
+}
+
+// deleteStaleNode deletes a node which has the name of the node the machine's instance will register as, but
+// belongs to a different instance. This happens when an instance got recreated and the node of
+// the old instance was not cleaned up. A stale node blocks the new instance from registering.
+func (c *Controller) deleteStaleNode(providerInstance instance.Instance, machine *clusterv1alpha1.Machine, provider providerconfig.CloudProvider) error {
+	if !c.deleteStaleNodes {
+		return nil
+	}
+	// On AWS the node name is the private DNS name of the instance, so recreated instances never share a node name
+	if provider == providerconfig.CloudProviderAWS {
+		return nil
+	}
+
+	node, err := c.nodesLister.Get(machine.Spec.Name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get node %s: %v", machine.Spec.Name, err)
+	}
+
+	// Without a providerID we can not tell whether the node belongs to the instance
+	if node.Spec.ProviderID == "" || providerIDMatches(node, providerInstance, provider) {
+		return nil
+	}
+
+	// Never delete a node which belongs to another existing machine
+	if ownerUID := node.Labels[NodeOwnerLabelName]; ownerUID != "" && ownerUID != string(machine.UID) {
+		machines, err := c.machinesLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("failed to list machines: %v", err)
+		}
+		for _, m := range machines {
+			if string(m.UID) == ownerUID {
+				glog.V(3).Infof("Not deleting node %s with mismatching providerID for machine %s as it belongs to machine %s", node.Name, machine.Name, m.Name)
+				return nil
+			}
+		}
+	}
+
+	// A ready node which is newer than the instance may belong to an instance we do not know about
+	if isNodeReady(node) && !nodeCreatedBeforeInstance(node, providerInstance) {
+		glog.V(3).Infof("Not deleting ready node %s with mismatching providerID %s for machine %s", node.Name, node.Spec.ProviderID, machine.Name)
+		return nil
+	}
+
+	if err := c.kubeClient.CoreV1().Nodes().Delete(node.Name, nil); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete stale node %s: %v", node.Name, err)
+	}
+	c.recorder.Eventf(machine, corev1.EventTypeNormal, "StaleNodeDeleted", "Deleted stale node %s with providerID %s", node.Name, node.Spec.ProviderID)
+	glog.V(3).Infof("Deleted stale node %s with providerID %s (machine %s)", node.Name, node.Spec.ProviderID, machine.Name)
+	return nil
+}
+
+// nodeCreatedBeforeInstance returns true if the node is older than the instance. False if the instance does not
+// know when it got created.
+func nodeCreatedBeforeInstance(node *corev1.Node, providerInstance instance.Instance) bool {
+	creationTimer, ok := providerInstance.(instance.CreationTimer)
+	if !ok || creationTimer.CreationTime().IsZero() {
+		return false
+	}
+	return node.CreationTimestamp.Time.Before(creationTimer.CreationTime())
+}
+
 func (c *Controller) enqueueMachine(obj interface{}) {
 	var key string
 	var err error
@@ -895,6 +1650,75 @@ func (c *Controller) handleObject(obj interface{}) {
 
 }
 
+func (c *Controller) watchInstanceEvents(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event := <-c.instanceEvents:
+			c.handleInstanceStateEvent(event)
+		}
+	}
+}
+
+// handleInstanceStateEvent enqueues the machine which belongs to the instance of the given event.
+// As machines do not store the ID of their instance, the machine is found via the node.
+func (c *Controller) handleInstanceStateEvent(event events.InstanceStateEvent) {
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list nodes in lister: %v", err))
+		return
+	}
+
+	// Azure IDs are case-insensitive
+	instanceIDSuffix := "/" + strings.ToLower(strings.TrimLeft(event.InstanceID, "/"))
+	var ownerUIDString string
+	for _, node := range nodes {
+		if strings.HasSuffix(strings.ToLower(node.Spec.ProviderID), instanceIDSuffix) {
+			ownerUIDString = node.Labels[NodeOwnerLabelName]
+			break
+		}
+	}
+
+	machinesList, err := c.machinesLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list machines in lister: %v", err))
+		return
+	}
+
+	for _, machine := range machinesList {
+		// The instance has no node yet, so it can only belong to one of the machines without a nodeRef
+		if ownerUIDString == "" && machine.Status.NodeRef == nil {
+			c.enqueueMachine(machine)
+			continue
+		}
+		if ownerUIDString != "" && string(machine.UID) == ownerUIDString {
+			glog.V(4).Infof("Processing state change event for instance %s: %s (machine=%s)", event.InstanceID, event.State, machine.Name)
+			c.instanceRefresher.invalidate(machine.UID)
+			if event.Interruption && c.deleteOnInstanceInterruption {
+				c.deleteInterruptedMachine(machine, event)
+			}
+			c.enqueueMachine(machine)
+			return
+		}
+	}
+}
+
+// deleteInterruptedMachine deletes the machine of an instance which is about to be reclaimed by the cloud provider.
+// The deletion drains the node right away, so its pods get rescheduled before the instance vanishes.
+func (c *Controller) deleteInterruptedMachine(machine *clusterv1alpha1.Machine, event events.InstanceStateEvent) {
+	if machine.DeletionTimestamp != nil {
+		return
+	}
+
+	glog.V(2).Infof("Deleting machine %s because its instance %s is about to be interrupted", machine.Name, event.InstanceID)
+	if err := c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Delete(machine.Name, nil); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to delete interrupted machine %s: %v", machine.Name, err))
+		return
+	}
+	c.recorder.Eventf(machine, corev1.EventTypeWarning, "InstanceInterrupted", "Deleting machine because the instance is about to be interrupted: %s", event.State)
+}
+
 func (c *Controller) ReadinessChecks() map[string]healthcheck.Check {
 	return map[string]healthcheck.Check{
 		"valid-info-kubeconfig": func() error {
@@ -927,12 +1751,12 @@ func (c *Controller) ReadinessChecks() map[string]healthcheck.Check {
 }
 
 func (c *Controller) ensureDeleteFinalizerExists(machine *clusterv1alpha1.Machine) (*clusterv1alpha1.Machine, error) {
-	if !sets.NewString(machine.Finalizers...).Has(FinalizerDeleteInstance) {
+	if !sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
 		var err error
 		if machine, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
 			finalizers := sets.NewString(m.Finalizers...)
-			finalizers.Insert(FinalizerDeleteInstance)
-			finalizers.Insert(FinalizerDeleteNode)
+			finalizers.Insert(c.finalizerDeleteInstance)
+			finalizers.Insert(c.finalizerDeleteNode)
 			m.Finalizers = finalizers.List()
 		}); err != nil {
 			return nil, fmt.Errorf("failed to update machine after adding the delete instance finalizer: %v", err)
@@ -18,17 +18,32 @@ package controller
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
 
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/events"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/phonehome"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	"github.com/kubermatic/machine-controller/pkg/version"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,13 +54,57 @@ import (
 
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	machinefake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+	clusterlistersv1alpha1 "sigs.k8s.io/cluster-api/pkg/client/listers_generated/cluster/v1alpha1"
 )
 
+// newTestController returns a Controller whose fake clients contain the given machines and objects. Updates are
+// visible to the listers right away, like the informers would do eventually. Tests set the fields they exercise
+// on the returned Controller.
+func newTestController(t *testing.T, machines []*clusterv1alpha1.Machine, objects ...runtime.Object) *Controller {
+	machineIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	var machineObjects []runtime.Object
+	for _, machine := range machines {
+		if err := machineIndexer.Add(machine); err != nil {
+			t.Fatalf("failed to add machine to machineIndexer: %v", err)
+		}
+		machineObjects = append(machineObjects, machine)
+	}
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, object := range objects {
+		if node, ok := object.(*corev1.Node); ok {
+			if err := nodeIndexer.Add(node); err != nil {
+				t.Fatalf("failed to add node to nodeIndexer: %v", err)
+			}
+		}
+	}
+
+	kubeClient := fake.NewSimpleClientset(objects...)
+	kubeClient.PrependReactor("update", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return false, nil, nodeIndexer.Update(action.(clienttesting.UpdateAction).GetObject())
+	})
+	machineClient := machinefake.NewSimpleClientset(machineObjects...)
+	machineClient.PrependReactor("update", "machines", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return false, nil, machineIndexer.Update(action.(clienttesting.UpdateAction).GetObject())
+	})
+
+	return &Controller{
+		kubeClient:              kubeClient,
+		machineClient:           machineClient,
+		nodesLister:             corev1listers.NewNodeLister(nodeIndexer),
+		machinesLister:          clusterlistersv1alpha1.NewMachineLister(machineIndexer),
+		workqueue:               workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0), "Machines"),
+		recorder:                &record.FakeRecorder{},
+		finalizerDeleteInstance: FinalizerDeleteInstance,
+		finalizerDeleteNode:     FinalizerDeleteNode,
+	}
+}
+
 type fakeInstance struct {
 	name      string
 	id        string
-	addresses []string
+	addresses map[string]corev1.NodeAddressType
 	status    instance.Status
+	created   time.Time
 }
 
 func (i *fakeInstance) Name() string {
@@ -60,10 +119,24 @@ func (i *fakeInstance) Status() instance.Status {
 	return i.status
 }
 
-func (i *fakeInstance) Addresses() []string {
+func (i *fakeInstance) Addresses() map[string]corev1.NodeAddressType {
 	return i.addresses
 }
 
+func (i *fakeInstance) CreationTime() time.Time {
+	return i.created
+}
+
+// providerIDTestInstance is an instance whose node does not get its ID in the providerID, like on vSphere
+type providerIDTestInstance struct {
+	*fakeInstance
+	providerID string
+}
+
+func (i *providerIDTestInstance) ProviderID() string {
+	return i.providerID
+}
+
 func getTestNode(id, provider string) corev1.Node {
 	providerID := ""
 	if provider != "" {
@@ -95,7 +168,6 @@ func TestController_GetNode(t *testing.T) {
 	node1 := getTestNode("1", "aws")
 	node2 := getTestNode("2", "openstack")
 	node3 := getTestNode("3", "")
-	nodeList := []*corev1.Node{&node1, &node2, &node3}
 
 	tests := []struct {
 		name     string
@@ -111,7 +183,7 @@ func TestController_GetNode(t *testing.T) {
 			resNode:  nil,
 			exists:   false,
 			err:      nil,
-			instance: &fakeInstance{id: "99", addresses: []string{"192.168.1.99"}},
+			instance: &fakeInstance{id: "99", addresses: map[string]corev1.NodeAddressType{"192.168.1.99": corev1.NodeInternalIP}},
 		},
 		{
 			name:     "node not found - no suitable node",
@@ -119,7 +191,7 @@ func TestController_GetNode(t *testing.T) {
 			resNode:  nil,
 			exists:   false,
 			err:      nil,
-			instance: &fakeInstance{id: "99", addresses: []string{"192.168.1.99"}},
+			instance: &fakeInstance{id: "99", addresses: map[string]corev1.NodeAddressType{"192.168.1.99": corev1.NodeInternalIP}},
 		},
 		{
 			name:     "node found by provider id",
@@ -127,7 +199,7 @@ func TestController_GetNode(t *testing.T) {
 			resNode:  &node1,
 			exists:   true,
 			err:      nil,
-			instance: &fakeInstance{id: "1", addresses: []string{""}},
+			instance: &fakeInstance{id: "1", addresses: map[string]corev1.NodeAddressType{"": corev1.NodeInternalIP}},
 		},
 		{
 			name:     "node found by internal ip",
@@ -135,7 +207,7 @@ func TestController_GetNode(t *testing.T) {
 			resNode:  &node3,
 			exists:   true,
 			err:      nil,
-			instance: &fakeInstance{id: "3", addresses: []string{"192.168.1.3"}},
+			instance: &fakeInstance{id: "3", addresses: map[string]corev1.NodeAddressType{"192.168.1.3": corev1.NodeInternalIP}},
 		},
 		{
 			name:     "node found by external ip",
@@ -143,19 +215,13 @@ func TestController_GetNode(t *testing.T) {
 			resNode:  &node3,
 			exists:   true,
 			err:      nil,
-			instance: &fakeInstance{id: "3", addresses: []string{"172.16.1.3"}},
+			instance: &fakeInstance{id: "3", addresses: map[string]corev1.NodeAddressType{"172.16.1.3": corev1.NodeExternalIP}},
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
-			for _, node := range nodeList {
-				if err := nodeIndexer.Add(node); err != nil {
-					t.Fatalf("failed to add node to nodeIndexer: %v", err)
-				}
-			}
-			controller := Controller{nodesLister: corev1listers.NewNodeLister(nodeIndexer)}
+			controller := newTestController(t, nil, &node1, &node2, &node3)
 
 			node, exists, err := controller.getNode(test.instance, test.provider)
 			if diff := deep.Equal(err, test.err); diff != nil {
@@ -255,26 +321,15 @@ func TestControllerDeletesMachinesOnJoinTimeout(t *testing.T) {
 
 			providerConfig := &providerconfig.Config{CloudProvider: providerconfig.CloudProviderFake}
 
-			machineClient := machinefake.NewSimpleClientset(machine)
-
-			nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
-			if err := nodeIndexer.Add(node); err != nil {
-				t.Fatalf("failed to add node to nodeIndexer: %v", err)
-			}
-
-			controller := Controller{nodesLister: corev1listers.NewNodeLister(nodeIndexer),
-				recorder:           &record.FakeRecorder{},
-				machineClient:      machineClient,
-				joinClusterTimeout: test.joinTimeoutConfig,
-				workqueue:          workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(1*time.Second, 5*time.Minute), "Machines"),
-			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.joinClusterTimeout = test.joinTimeoutConfig
 
 			if err := controller.ensureNodeOwnerRefAndConfigSource(instance, machine, providerConfig); err != nil {
 				t.Fatalf("failed to call ensureNodeOwnerRefAndConfigSource: %v", err)
 			}
 
 			var wasDeleted bool
-			for _, action := range machineClient.Actions() {
+			for _, action := range controller.machineClient.(*machinefake.Clientset).Actions() {
 				if action.GetVerb() == "delete" {
 					wasDeleted = true
 					break
@@ -370,25 +425,1192 @@ func TestControllerShouldEvict(t *testing.T) {
 				objects = append(objects, test.existingNode)
 			}
 
-			kubeClient := fake.NewSimpleClientset(objects...)
-			informerFactory := informers.NewSharedInformerFactory(kubeClient, 5*time.Minute)
+			controller := newTestController(t, nil, objects...)
+			controller.skipEvictionAfter = 2 * time.Hour
+
+			shouldEvict, err := controller.shouldEvict(test.machine)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if shouldEvict != test.shouldEvict {
+				t.Errorf("Expected shouldEvict to be %v but got %v instead", test.shouldEvict, shouldEvict)
+			}
+		})
+	}
+}
+
+func TestControllerHandleInstanceStateEvent(t *testing.T) {
+	tests := []struct {
+		name         string
+		event        events.InstanceStateEvent
+		nodes        []*corev1.Node
+		machines     []*clusterv1alpha1.Machine
+		expectedKeys []string
+	}{
+		{
+			name:  "machine of the node with the instance gets enqueued",
+			event: events.InstanceStateEvent{InstanceID: "i-123", State: "stopped"},
+			nodes: []*corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}},
+					Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/i-123"},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{NodeOwnerLabelName: "uid-2"}},
+					Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/i-456"},
+				},
+			},
+			machines: []*clusterv1alpha1.Machine{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1"},
+					Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-2", Namespace: "kube-system", UID: "uid-2"},
+					Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-2"}},
+				},
+			},
+			expectedKeys: []string{"kube-system/machine-1"},
+		},
+		{
+			name:  "case-insensitive azure ids",
+			event: events.InstanceStateEvent{InstanceID: "/subscriptions/abc/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/VM-1"},
+			nodes: []*corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "vm-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}},
+					Spec:       corev1.NodeSpec{ProviderID: "azure:///subscriptions/abc/resourcegroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"},
+				},
+			},
+			machines: []*clusterv1alpha1.Machine{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1"},
+					Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "vm-1"}},
+				},
+			},
+			expectedKeys: []string{"kube-system/machine-1"},
+		},
+		{
+			name:  "machines without node get enqueued for unknown instances",
+			event: events.InstanceStateEvent{InstanceID: "i-789", State: "running"},
+			nodes: []*corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}},
+					Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/i-123"},
+				},
+			},
+			machines: []*clusterv1alpha1.Machine{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1"},
+					Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-2", Namespace: "kube-system", UID: "uid-2"},
+				},
+			},
+			expectedKeys: []string{"kube-system/machine-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, node := range test.nodes {
+				objects = append(objects, node)
+			}
+			controller := newTestController(t, test.machines, objects...)
+
+			controller.handleInstanceStateEvent(test.event)
+
+			var keys []string
+			for controller.workqueue.Len() > 0 {
+				key, _ := controller.workqueue.Get()
+				keys = append(keys, key.(string))
+				controller.workqueue.Done(key)
+			}
+			if diff := deep.Equal(keys, test.expectedKeys); diff != nil {
+				t.Errorf("unexpected machines enqueued, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestControllerEnsureNodeVersionProvenance(t *testing.T) {
+	oldVersion := version.Version
+	version.Version = "v1.2.3"
+	defer func() { version.Version = oldVersion }()
+
+	tests := []struct {
+		name                      string
+		node                      *corev1.Node
+		userDataPluginVersion     string
+		expectedLabels            map[string]string
+		expectedAnnotations       map[string]string
+		expectedControllerVersion string
+		expectedPluginVersion     string
+	}{
+		{
+			name: "versions get applied on join",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			},
+			userDataPluginVersion:     "v1.2.0",
+			expectedLabels:            map[string]string{NodeControllerVersionLabelName: "v1.2.3"},
+			expectedAnnotations:       map[string]string{NodeUserDataPluginVersionAnnotationName: "v1.2.0"},
+			expectedControllerVersion: "v1.2.3",
+			expectedPluginVersion:     "v1.2.0",
+		},
+		{
+			name: "outdated version label gets updated",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-1",
+					Labels: map[string]string{NodeControllerVersionLabelName: "v1.0.0", "foo": "bar"},
+				},
+			},
+			expectedLabels:            map[string]string{NodeControllerVersionLabelName: "v1.2.3", "foo": "bar"},
+			expectedControllerVersion: "v1.2.3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerStatus := &providerconfig.ProviderStatus{UserDataPluginVersion: test.userDataPluginVersion}
+			rawProviderStatus, err := providerStatus.RawExtension()
+			if err != nil {
+				t.Fatal(err)
+			}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+				Status:     clusterv1alpha1.MachineStatus{ProviderStatus: rawProviderStatus},
+			}
+
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, test.node)
 
-			ctrl := &Controller{
-				nodesLister:       informerFactory.Core().V1().Nodes().Lister(),
-				skipEvictionAfter: 2 * time.Hour,
+			if err := controller.ensureNodeVersionProvenance(test.node, machine); err != nil {
+				t.Fatalf("failed to ensure version provenance: %v", err)
 			}
 
-			informerFactory.Start(wait.NeverStop)
-			informerFactory.WaitForCacheSync(wait.NeverStop)
+			node, err := controller.kubeClient.CoreV1().Nodes().Get(test.node.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(node.Labels, test.expectedLabels); diff != nil {
+				t.Errorf("unexpected node labels, diff: %v", diff)
+			}
+			if diff := deep.Equal(node.Annotations, test.expectedAnnotations); diff != nil {
+				t.Errorf("unexpected node annotations, diff: %v", diff)
+			}
 
-			shouldEvict, err := ctrl.shouldEvict(test.machine)
+			updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
 			if err != nil {
 				t.Fatal(err)
 			}
+			updatedProviderStatus, err := providerconfig.GetProviderStatus(updatedMachine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updatedProviderStatus.ControllerVersion != test.expectedControllerVersion {
+				t.Errorf("expected controller version %q in provider status, got %q", test.expectedControllerVersion, updatedProviderStatus.ControllerVersion)
+			}
+			if updatedProviderStatus.UserDataPluginVersion != test.expectedPluginVersion {
+				t.Errorf("expected userdata plugin version %q in provider status, got %q", test.expectedPluginVersion, updatedProviderStatus.UserDataPluginVersion)
+			}
+		})
+	}
+}
 
-			if shouldEvict != test.shouldEvict {
-				t.Errorf("Expected shouldEvict to be %v but got %v instead", test.shouldEvict, shouldEvict)
+func TestControllerDeletesStaleNode(t *testing.T) {
+	instanceCreated := time.Now().Add(-time.Hour)
+	readyStatus := corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}}
+
+	tests := []struct {
+		name          string
+		node          *corev1.Node
+		otherMachines []*clusterv1alpha1.Machine
+		provider      providerconfig.CloudProvider
+		instance      instance.Instance
+		disabled      bool
+		getsDeleted   bool
+	}{
+		{
+			name: "node with same name and different provider id gets deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Labels: map[string]string{NodeOwnerLabelName: "old-uid"}},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///old-instance"},
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			getsDeleted: true,
+		},
+		{
+			name: "node of the instance does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///new-instance"},
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			getsDeleted: false,
+		},
+		{
+			name: "node without provider id does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			getsDeleted: false,
+		},
+		{
+			name: "node owned by another existing machine does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Labels: map[string]string{NodeOwnerLabelName: "other-uid"}},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///other-instance"},
+			},
+			otherMachines: []*clusterv1alpha1.Machine{
+				{ObjectMeta: metav1.ObjectMeta{Name: "machine-2", Namespace: "kube-system", UID: "other-uid"}},
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			getsDeleted: false,
+		},
+		{
+			name: "node names on aws are never shared",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/i-old"},
+			},
+			provider:    providerconfig.CloudProviderAWS,
+			getsDeleted: false,
+		},
+		{
+			name: "stale node does not get deleted when disabled",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Labels: map[string]string{NodeOwnerLabelName: "old-uid"}},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///old-instance"},
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			disabled:    true,
+			getsDeleted: false,
+		},
+		{
+			name: "ready node older than the instance gets deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", CreationTimestamp: metav1.NewTime(instanceCreated.Add(-time.Hour))},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///old-instance"},
+				Status:     readyStatus,
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			instance:    &fakeInstance{id: "new-instance", created: instanceCreated},
+			getsDeleted: true,
+		},
+		{
+			name: "ready node newer than the instance does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", CreationTimestamp: metav1.NewTime(instanceCreated.Add(time.Minute))},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///other-instance"},
+				Status:     readyStatus,
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			instance:    &fakeInstance{id: "new-instance", created: instanceCreated},
+			getsDeleted: false,
+		},
+		{
+			name: "ready node of an instance without creation time does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "openstack:///old-instance"},
+				Status:     readyStatus,
+			},
+			provider:    providerconfig.CloudProviderOpenstack,
+			getsDeleted: false,
+		},
+		{
+			name: "node of the instance with providerID of the hetzner cloud controller manager does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "hcloud://123"},
+			},
+			provider:    providerconfig.CloudProviderHetzner,
+			instance:    &fakeInstance{id: "123"},
+			getsDeleted: false,
+		},
+		{
+			name: "node of the instance with providerID of the digitalocean cloud controller manager does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "digitalocean://123"},
+			},
+			provider:    providerconfig.CloudProviderDigitalocean,
+			instance:    &fakeInstance{id: "123"},
+			getsDeleted: false,
+		},
+		{
+			name: "node of the vsphere instance does not get deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "vsphere://4230C2A4-1B9E-4B6F-8E2D-0A1B2C3D4E5F"},
+			},
+			provider:    providerconfig.CloudProviderVsphere,
+			instance:    &providerIDTestInstance{fakeInstance: &fakeInstance{id: "vm-42"}, providerID: "4230c2a4-1b9e-4b6f-8e2d-0a1b2c3d4e5f"},
+			getsDeleted: false,
+		},
+		{
+			name: "node of another vsphere instance gets deleted",
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+				Spec:       corev1.NodeSpec{ProviderID: "vsphere://4230c2a4-0000-0000-0000-000000000000"},
+			},
+			provider:    providerconfig.CloudProviderVsphere,
+			instance:    &providerIDTestInstance{fakeInstance: &fakeInstance{id: "vm-42"}, providerID: "4230c2a4-1b9e-4b6f-8e2d-0a1b2c3d4e5f"},
+			getsDeleted: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "new-uid"},
+				Spec:       clusterv1alpha1.MachineSpec{ObjectMeta: metav1.ObjectMeta{Name: "machine-1"}},
+			}
+
+			controller := newTestController(t, append(test.otherMachines, machine), test.node)
+			controller.deleteStaleNodes = !test.disabled
+
+			providerInstance := test.instance
+			if providerInstance == nil {
+				providerInstance = &fakeInstance{id: "new-instance"}
+			}
+			if err := controller.deleteStaleNode(providerInstance, machine, test.provider); err != nil {
+				t.Fatalf("failed to delete stale node: %v", err)
+			}
+
+			var wasDeleted bool
+			for _, action := range controller.kubeClient.(*fake.Clientset).Actions() {
+				if action.GetVerb() == "delete" && action.GetResource().Resource == "nodes" {
+					wasDeleted = true
+					break
+				}
+			}
+			if wasDeleted != test.getsDeleted {
+				t.Errorf("Node was deleted: %v, but expectedDeletion: %v", wasDeleted, test.getsDeleted)
+			}
+		})
+	}
+}
+
+type preCreateHookTestProvider struct {
+	cloudprovidertypes.Provider
+	created bool
+}
+
+func (p *preCreateHookTestProvider) Get(_ *clusterv1alpha1.Machine) (instance.Instance, error) {
+	return nil, cloudprovidererrors.ErrInstanceNotFound
+}
+
+func (p *preCreateHookTestProvider) Create(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData, _ string) (instance.Instance, error) {
+	p.created = true
+	return &fakeInstance{}, nil
+}
+
+func TestControllerPreCreateHookBlocksInstanceCreation(t *testing.T) {
+	tests := []struct {
+		name              string
+		handler           http.HandlerFunc
+		expectedCondition providerconfig.Condition
+	}{
+		{
+			name: "denying hook",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"allowed": false, "reason": "QuotaExceeded", "message": "no capacity left"}`)
+			},
+			expectedCondition: providerconfig.Condition{
+				Type:    providerconfig.PreCreateHookSucceededConditionType,
+				Status:  corev1.ConditionFalse,
+				Reason:  "QuotaExceeded",
+				Message: "no capacity left",
+			},
+		},
+		{
+			name: "failing hook",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			expectedCondition: providerconfig.Condition{
+				Type:    providerconfig.PreCreateHookSucceededConditionType,
+				Status:  corev1.ConditionUnknown,
+				Reason:  "Pending",
+				Message: "pre-create hook returned status 503: unavailable\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+			controller.preCreateHook = NewPreCreateHook(server.URL, time.Second)
+			defer controller.workqueue.ShutDown()
+
+			prov := &preCreateHookTestProvider{}
+			if err := controller.ensureInstanceExistsForMachine(prov, machine, nil, &providerconfig.Config{}); err != nil {
+				t.Fatalf("failed to ensure instance exists: %v", err)
+			}
+			if prov.created {
+				t.Error("expected the instance creation to be blocked by the pre-create hook")
+			}
+
+			updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get machine: %v", err)
+			}
+			providerStatus, err := providerconfig.GetProviderStatus(updatedMachine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatalf("failed to get provider status: %v", err)
+			}
+			condition := providerStatus.GetCondition(providerconfig.PreCreateHookSucceededConditionType)
+			if condition == nil {
+				t.Fatal("expected the PreCreateHookSucceeded condition to be set")
+			}
+			condition.LastTransitionTime = metav1.Time{}
+			if diff := deep.Equal(*condition, test.expectedCondition); diff != nil {
+				t.Errorf("unexpected condition, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestPreCreateHookDoesNotReceiveCredentials(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			t.Errorf("failed to read request body: %v", err)
+		}
+		fmt.Fprint(w, `{"allowed": true}`)
+	}))
+	defer server.Close()
+
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+		Spec: clusterv1alpha1.MachineSpec{
+			ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{
+  "cloudProvider": "aws",
+  "cloudProviderSpec": {"accessKeyId": "AKIAEXAMPLE", "secretAccessKey": "wJalrXUtnFEMI", "instanceType": "t3.large"}
+}`)}},
+		},
+	}
+	if _, err := NewPreCreateHook(server.URL, time.Second).Call(machine); err != nil {
+		t.Fatalf("failed to call the pre-create hook: %v", err)
+	}
+
+	for _, secret := range []string{"AKIAEXAMPLE", "wJalrXUtnFEMI"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("expected the secret %q to be redacted, got body %s", secret, body)
+		}
+	}
+	if !strings.Contains(string(body), "t3.large") {
+		t.Errorf("expected the instance type to be sent to the hook, got body %s", body)
+	}
+	if !strings.Contains(string(machine.Spec.ProviderSpec.Value.Raw), "wJalrXUtnFEMI") {
+		t.Error("expected the provider spec of the machine to be left unchanged")
+	}
+}
+
+func TestControllerDeletesMachineOnInstanceInterruption(t *testing.T) {
+	tests := []struct {
+		name                         string
+		body                         string
+		secret                       string
+		deleteOnInstanceInterruption bool
+		getsDeleted                  bool
+		getsEnqueued                 bool
+	}{
+		{
+			name:                         "spot interruption warning deletes the machine",
+			body:                         `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-123", "instance-action": "terminate"}}`,
+			secret:                       "s3cr3t",
+			deleteOnInstanceInterruption: true,
+			getsDeleted:                  true,
+			getsEnqueued:                 true,
+		},
+		{
+			name:                         "spot interruption warning is ignored when disabled",
+			body:                         `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-123", "instance-action": "terminate"}}`,
+			secret:                       "s3cr3t",
+			deleteOnInstanceInterruption: false,
+			getsDeleted:                  false,
+			getsEnqueued:                 true,
+		},
+		{
+			name:                         "state change does not delete the machine",
+			body:                         `{"detail-type": "EC2 Instance State-change Notification", "detail": {"instance-id": "i-123", "state": "stopping"}}`,
+			secret:                       "s3cr3t",
+			deleteOnInstanceInterruption: true,
+			getsDeleted:                  false,
+			getsEnqueued:                 true,
+		},
+		{
+			name:                         "spot interruption warning without secret is rejected",
+			body:                         `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-123", "instance-action": "terminate"}}`,
+			deleteOnInstanceInterruption: true,
+		},
+		{
+			name:                         "spot interruption warning with wrong secret is rejected",
+			body:                         `{"detail-type": "EC2 Spot Instance Interruption Warning", "detail": {"instance-id": "i-123", "instance-action": "terminate"}}`,
+			secret:                       "guessed",
+			deleteOnInstanceInterruption: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}},
+				Spec:       corev1.NodeSpec{ProviderID: "aws:///eu-central-1a/i-123"},
+			}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1"},
+				Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.deleteOnInstanceInterruption = test.deleteOnInstanceInterruption
+			defer controller.workqueue.ShutDown()
+
+			// Simulate the notice being delivered by the cloud provider
+			receiver := events.NewReceiver([]byte("s3cr3t"))
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			if test.secret != "" {
+				req.Header.Set(events.APIKeyHeader, test.secret)
+			}
+			resp := httptest.NewRecorder()
+			receiver.ServeHTTP(resp, req)
+			expectedStatus := http.StatusOK
+			if test.secret != "s3cr3t" {
+				expectedStatus = http.StatusUnauthorized
+			}
+			if resp.Code != expectedStatus {
+				t.Fatalf("expected status %d, got %d", expectedStatus, resp.Code)
+			}
+			select {
+			case event := <-receiver.Events():
+				controller.handleInstanceStateEvent(event)
+			default:
+			}
+
+			var wasDeleted bool
+			for _, action := range controller.machineClient.(*machinefake.Clientset).Actions() {
+				if action.GetVerb() == "delete" && action.GetResource().Resource == "machines" {
+					wasDeleted = true
+					break
+				}
+			}
+			if wasDeleted != test.getsDeleted {
+				t.Errorf("Machine was deleted: %v, but expectedDeletion: %v", wasDeleted, test.getsDeleted)
+			}
+			if enqueued := controller.workqueue.Len() == 1; enqueued != test.getsEnqueued {
+				t.Errorf("Machine was enqueued: %v, but expected: %v", enqueued, test.getsEnqueued)
+			}
+		})
+	}
+}
+
+func TestControllerUsesConfiguredFinalizers(t *testing.T) {
+	const (
+		instanceFinalizer = "example.com/delete-instance"
+		nodeFinalizer     = "example.com/delete-node"
+		foreignFinalizer  = "other-controller/machine-delete-finalizer"
+	)
+
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1", Finalizers: []string{foreignFinalizer}},
+	}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+	controller.finalizerDeleteInstance = instanceFinalizer
+	controller.finalizerDeleteNode = nodeFinalizer
+
+	// syncMachine returns the latest state of the machine
+	syncMachine := func() *clusterv1alpha1.Machine {
+		m, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get machine: %v", err)
+		}
+		return m
+	}
+
+	if _, err := controller.ensureDeleteFinalizerExists(machine); err != nil {
+		t.Fatalf("failed to ensure finalizer exists: %v", err)
+	}
+	machine = syncMachine()
+	if diff := deep.Equal(machine.Finalizers, []string{instanceFinalizer, nodeFinalizer, foreignFinalizer}); diff != nil {
+		t.Errorf("unexpected finalizers after create, diff: %v", diff)
+	}
+
+	prov := fakecloudprovider.New(nil)
+	if err := controller.deleteCloudProviderInstance(prov, machine); err != nil {
+		t.Fatalf("failed to delete cloud provider instance: %v", err)
+	}
+	machine = syncMachine()
+	if err := controller.deleteNodeForMachine(machine); err != nil {
+		t.Fatalf("failed to delete node for machine: %v", err)
+	}
+	machine = syncMachine()
+	if diff := deep.Equal(machine.Finalizers, []string{foreignFinalizer}); diff != nil {
+		t.Errorf("unexpected finalizers after delete, diff: %v", diff)
+	}
+}
+
+func TestControllerDeleteNodeForMachine(t *testing.T) {
+	defer func(backoff wait.Backoff) { nodeDeletionBackoff = backoff }(nodeDeletionBackoff)
+	nodeDeletionBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 1.0}
+
+	tests := []struct {
+		name string
+		// deleteErrors are returned by the node deletions in turn, the node does not exist in the API once they are used up
+		deleteErrors      []error
+		expectedDeletions int
+		expectedError     bool
+	}{
+		{
+			name:              "node already gone",
+			expectedDeletions: 1,
+		},
+		{
+			name:              "transient error gets retried",
+			deleteErrors:      []error{kerrors.NewServiceUnavailable("etcd leader changed")},
+			expectedDeletions: 2,
+		},
+		{
+			name:              "permanent error fails",
+			deleteErrors:      []error{kerrors.NewBadRequest("invalid request")},
+			expectedDeletions: 1,
+			expectedError:     true,
+		},
+		{
+			name: "transient errors exceed the retries",
+			deleteErrors: []error{
+				kerrors.NewTooManyRequests("slow down", 1),
+				kerrors.NewTooManyRequests("slow down", 1),
+				kerrors.NewTooManyRequests("slow down", 1),
+			},
+			expectedDeletions: 3,
+			expectedError:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1", Finalizers: []string{FinalizerDeleteNode}},
+			}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}}}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+
+			// The node is still in the lister but already gone from the API
+			kubeClient := controller.kubeClient.(*fake.Clientset)
+			if err := kubeClient.CoreV1().Nodes().Delete(node.Name, nil); err != nil {
+				t.Fatalf("failed to delete node from the fake client: %v", err)
+			}
+			var deletions int
+			kubeClient.PrependReactor("delete", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				deletions++
+				if deletions <= len(test.deleteErrors) {
+					return true, nil, test.deleteErrors[deletions-1]
+				}
+				return false, nil, nil
+			})
+
+			err := controller.deleteNodeForMachine(machine)
+			if test.expectedError && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.expectedError && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if deletions != test.expectedDeletions {
+				t.Errorf("expected %d node deletions, got %d", test.expectedDeletions, deletions)
+			}
+
+			updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get machine: %v", err)
+			}
+			hasFinalizer := len(updatedMachine.Finalizers) > 0
+			if hasFinalizer != test.expectedError {
+				t.Errorf("expected node finalizer to be kept: %t, got finalizers %v", test.expectedError, updatedMachine.Finalizers)
+			}
+		})
+	}
+}
+
+type cleanupTestProvider struct {
+	cloudprovidertypes.Provider
+	cleanedUp bool
+}
+
+func (p *cleanupTestProvider) Cleanup(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData) (bool, error) {
+	p.cleanedUp = true
+	return true, nil
+}
+
+func TestControllerSkipsNodeDeletion(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		getsDeleted bool
+	}{
+		{
+			name:        "node and instance get deleted",
+			getsDeleted: true,
+		},
+		{
+			name:        "node and instance survive with skip-node-deletion annotation",
+			annotations: map[string]string{AnnotationSkipNodeDeletion: "true"},
+			getsDeleted: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{NodeOwnerLabelName: "uid-1"}},
+			}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "machine-1",
+					Namespace:   "kube-system",
+					UID:         "uid-1",
+					Annotations: test.annotations,
+					Finalizers:  []string{FinalizerDeleteInstance, FinalizerDeleteNode},
+					// Long enough ago to skip the eviction
+					DeletionTimestamp: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+				},
+				Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+
+			// The node only gets deleted in the sync after the instance is gone
+			prov := &cleanupTestProvider{}
+			for i := 0; i < 2; i++ {
+				if err := controller.deleteMachine(prov, machine); err != nil {
+					t.Fatalf("failed to delete machine: %v", err)
+				}
+				m, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get machine: %v", err)
+				}
+				machine = m
+			}
+
+			if prov.cleanedUp != test.getsDeleted {
+				t.Errorf("Instance was deleted: %v, but expectedDeletion: %v", prov.cleanedUp, test.getsDeleted)
+			}
+			var nodeWasDeleted bool
+			for _, action := range controller.kubeClient.(*fake.Clientset).Actions() {
+				if action.GetVerb() == "delete" && action.GetResource().Resource == "nodes" {
+					nodeWasDeleted = true
+					break
+				}
+			}
+			if nodeWasDeleted != test.getsDeleted {
+				t.Errorf("Node was deleted: %v, but expectedDeletion: %v", nodeWasDeleted, test.getsDeleted)
+			}
+
+			if len(machine.Finalizers) != 0 {
+				t.Errorf("expected all finalizers to be removed, got %v", machine.Finalizers)
+			}
+		})
+	}
+}
+
+func TestControllerRecordsPhoneHomeReport(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            string
+		expectedCondition corev1.ConditionStatus
+		expectedEvent     bool
+	}{
+		{
+			name:              "successful bootstrap",
+			status:            "success",
+			expectedCondition: corev1.ConditionTrue,
+		},
+		{
+			name:              "failed bootstrap",
+			status:            "failure",
+			expectedCondition: corev1.ConditionFalse,
+			expectedEvent:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system", UID: "uid-1"},
+			}
+			recorder := record.NewFakeRecorder(10)
+			receiver := phonehome.NewReceiver("https://machine-controller.example.com/phone-home", []byte("secret"))
+
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+			controller.recorder = recorder
+			controller.phoneHome = receiver
+
+			// Simulate the report of the node
+			phoneHome := controller.phoneHomeFor(machine)
+			req := httptest.NewRequest(http.MethodPost, phoneHome.URL+"?status="+test.status, strings.NewReader("setup log"))
+			req.Header.Set("Authorization", "Bearer "+phoneHome.Token)
+			resp := httptest.NewRecorder()
+			receiver.ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+			}
+			if err := controller.handlePhoneHomeReport(<-receiver.Reports()); err != nil {
+				t.Fatalf("failed to handle phone-home report: %v", err)
+			}
+
+			updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get machine: %v", err)
+			}
+			providerStatus, err := providerconfig.GetProviderStatus(updatedMachine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatalf("failed to get provider status: %v", err)
+			}
+			condition := providerStatus.GetCondition(providerconfig.BootstrapSucceededConditionType)
+			if condition == nil {
+				t.Fatalf("expected the %s condition to be set", providerconfig.BootstrapSucceededConditionType)
+			}
+			if condition.Status != test.expectedCondition {
+				t.Errorf("expected condition status %s, got %s", test.expectedCondition, condition.Status)
+			}
+			if condition.Message != "setup log" {
+				t.Errorf("expected the log as condition message, got %q", condition.Message)
+			}
+			if gotEvent := len(recorder.Events) > 0; gotEvent != test.expectedEvent {
+				t.Errorf("expected event: %v, got event: %v", test.expectedEvent, gotEvent)
+			}
+		})
+	}
+}
+
+func TestControllerToleratesStartupTaints(t *testing.T) {
+	tests := []struct {
+		name        string
+		taintKey    string
+		nodeAge     time.Duration
+		getsDeleted bool
+	}{
+		{
+			name:        "node with expected taint within the grace period is kept",
+			taintKey:    "nvidia.com/gpu-uninitialized",
+			nodeAge:     time.Minute,
+			getsDeleted: false,
+		},
+		{
+			name:        "node with expected taint after the grace period gets re-created",
+			taintKey:    "nvidia.com/gpu-uninitialized",
+			nodeAge:     time.Hour,
+			getsDeleted: true,
+		},
+		{
+			name:        "node with other taint is kept",
+			taintKey:    "example.com/dedicated",
+			nodeAge:     time.Hour,
+			getsDeleted: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1", CreationTimestamp: metav1.NewTime(time.Now().Add(-test.nodeAge))},
+				Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+					{Key: test.taintKey, Effect: corev1.TaintEffectNoSchedule},
+				}},
+			}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "machine-1",
+					Namespace:       "kube-system",
+					OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: "machineset-1"}},
+				},
+				Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+			controller.startupTaints = NewStartupTaints("nvidia.com/gpu-uninitialized, example.com/other", 10*time.Minute)
+			defer controller.workqueue.ShutDown()
+
+			deleted, err := controller.checkStartupTaints(machine, node)
+			if err != nil {
+				t.Fatalf("failed to check startup taints: %v", err)
+			}
+
+			var wasDeleted bool
+			for _, action := range controller.machineClient.(*machinefake.Clientset).Actions() {
+				if action.GetVerb() == "delete" && action.GetResource().Resource == "machines" {
+					wasDeleted = true
+					break
+				}
+			}
+			if wasDeleted != test.getsDeleted || deleted != test.getsDeleted {
+				t.Errorf("Machine was deleted: %v, but expectedDeletion: %v", wasDeleted, test.getsDeleted)
 			}
 		})
 	}
 }
+
+func TestControllerLimitsConcurrentCreates(t *testing.T) {
+	const maxConcurrentCreates = 3
+
+	newMachine := func(name, deployment string) *clusterv1alpha1.Machine {
+		return &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "kube-system",
+			Labels:          map[string]string{"machine-template-hash": "1234"},
+			Annotations:     map[string]string{AnnotationMaxConcurrentCreates: fmt.Sprintf("%d", maxConcurrentCreates)},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: deployment + "-" + rand.SafeEncodeString("1234")}},
+		}}
+	}
+
+	controller := newTestController(t, nil)
+	controller.createLimiter = newCreateLimiter()
+
+	// Creations of another deployment must not count against the limit
+	releaseOther, acquired := controller.acquireCreateSlot(newMachine("other", "other-workers"))
+	if !acquired {
+		t.Fatal("expected machine of another deployment to get a create slot")
+	}
+	defer releaseOther()
+
+	var inFlight, maxInFlight, created int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(machine *clusterv1alpha1.Machine) {
+			defer wg.Done()
+			// Machines which did not get a slot get requeued, so keep trying until the machine got created
+			for {
+				release, acquired := controller.acquireCreateSlot(machine)
+				if !acquired {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				current := atomic.AddInt32(&inFlight, 1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+				atomic.AddInt32(&created, 1)
+				release()
+				return
+			}
+		}(newMachine(fmt.Sprintf("machine-%d", i), "workers"))
+	}
+	wg.Wait()
+
+	if created != 20 {
+		t.Errorf("expected all 20 machines to be created, got %d", created)
+	}
+	if maxInFlight > maxConcurrentCreates {
+		t.Errorf("expected at most %d creates in flight, got %d", maxConcurrentCreates, maxInFlight)
+	}
+}
+
+func TestControllerIgnoresInvalidMaxConcurrentCreates(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{
+		Name:            "machine-1",
+		Namespace:       "kube-system",
+		Labels:          map[string]string{"machine-template-hash": "1234"},
+		Annotations:     map[string]string{AnnotationMaxConcurrentCreates: "0"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: "workers-" + rand.SafeEncodeString("1234")}},
+	}}
+	controller := newTestController(t, nil)
+	controller.createLimiter = newCreateLimiter()
+
+	for i := 0; i < 2; i++ {
+		if _, acquired := controller.acquireCreateSlot(machine); !acquired {
+			t.Errorf("expected an invalid limit to be ignored")
+		}
+	}
+}
+
+type providerErrorTestProvider struct {
+	cloudprovidertypes.Provider
+	createErr error
+}
+
+func (p *providerErrorTestProvider) Create(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData, _ string) (instance.Instance, error) {
+	if p.createErr != nil {
+		return nil, p.createErr
+	}
+	return &fakeInstance{}, nil
+}
+
+func TestControllerRecordsLastProviderError(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+	}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+
+	getProviderError := func() *providerconfig.ProviderError {
+		updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get machine: %v", err)
+		}
+		machine = updatedMachine
+		providerStatus, err := providerconfig.GetProviderStatus(updatedMachine.Status.ProviderStatus)
+		if err != nil {
+			t.Fatalf("failed to get provider status: %v", err)
+		}
+		return providerStatus.LastProviderError
+	}
+
+	prov := &providerErrorTestProvider{
+		createErr: cloudprovidererrors.WithCode(fmt.Errorf("failed to create instance"), "InsufficientInstanceCapacity"),
+	}
+	if _, err := controller.createProviderInstance(prov, machine, "", ""); err == nil {
+		t.Fatal("expected the instance creation to fail")
+	}
+	providerError := getProviderError()
+	if providerError == nil {
+		t.Fatal("expected the provider error to be recorded")
+	}
+	if providerError.LastTransitionTime.IsZero() {
+		t.Error("expected the time of the provider error to be set")
+	}
+	providerError.LastTransitionTime = metav1.Time{}
+	expectedProviderError := providerconfig.ProviderError{
+		Operation: "create",
+		Reason:    "InsufficientInstanceCapacity",
+		Message:   "failed to create instance",
+	}
+	if diff := deep.Equal(*providerError, expectedProviderError); diff != nil {
+		t.Errorf("unexpected provider error, diff: %v", diff)
+	}
+
+	prov.createErr = nil
+	if _, err := controller.createProviderInstance(prov, machine, "", ""); err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	if providerError := getProviderError(); providerError != nil {
+		t.Errorf("expected the provider error to be cleared after a successful creation, got %+v", providerError)
+	}
+}
+
+type addressesTestProvider struct {
+	cloudprovidertypes.Provider
+	instance *fakeInstance
+	err      error
+	gets     int
+}
+
+func (p *addressesTestProvider) Get(_ *clusterv1alpha1.Machine) (instance.Instance, error) {
+	p.gets++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.instance, nil
+}
+
+func TestControllerUpdatesMachineAddresses(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+	}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+
+	prov := &addressesTestProvider{instance: &fakeInstance{id: "1", addresses: map[string]corev1.NodeAddressType{
+		"10.0.0.2":        corev1.NodeInternalIP,
+		"192.0.2.10":      corev1.NodeExternalIP,
+		"ip-10-0-0-2.ec2": corev1.NodeInternalDNS,
+	}}}
+	machine, err := controller.ensureMachineAddresses(prov.instance, machine)
+	if err != nil {
+		t.Fatalf("failed to update machine addresses: %v", err)
+	}
+	expected := []corev1.NodeAddress{
+		{Address: "10.0.0.2", Type: corev1.NodeInternalIP},
+		{Address: "192.0.2.10", Type: corev1.NodeExternalIP},
+		{Address: "ip-10-0-0-2.ec2", Type: corev1.NodeInternalDNS},
+	}
+	if diff := deep.Equal(machine.Status.Addresses, expected); diff != nil {
+		t.Errorf("unexpected machine addresses, diff: %v", diff)
+	}
+
+	// The public IP got replaced, e.g. by an Elastic IP
+	delete(prov.instance.addresses, "192.0.2.10")
+	prov.instance.addresses["198.51.100.7"] = corev1.NodeExternalIP
+	if _, err := controller.ensureMachineAddresses(prov.instance, machine); err != nil {
+		t.Fatalf("failed to update machine addresses: %v", err)
+	}
+	updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get machine: %v", err)
+	}
+	expected[1] = corev1.NodeAddress{Address: "198.51.100.7", Type: corev1.NodeExternalIP}
+	if diff := deep.Equal(updatedMachine.Status.Addresses, expected); diff != nil {
+		t.Errorf("expected the machine addresses to follow the instance, diff: %v", diff)
+	}
+}
+
+func TestControllerDeletesMachineWithDeletedProviderConfigTemplate(t *testing.T) {
+	templateInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	template := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"namespace": "kube-system", "name": "hetzner"},
+		"spec": map[string]interface{}{"value": map[string]interface{}{
+			"cloudProvider":   "hetzner",
+			"operatingSystem": "ubuntu",
+		}},
+	}}
+	if err := templateInformer.GetIndexer().Add(template); err != nil {
+		t.Fatalf("failed to add template: %v", err)
+	}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "machine-1"},
+		Spec: clusterv1alpha1.MachineSpec{ProviderSpec: clusterv1alpha1.ProviderSpec{
+			Value: &runtime.RawExtension{Raw: []byte(`{"templateRef": {"name": "hetzner"}}`)},
+		}},
+	}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+	controller.providerConfigTemplates = providerconfig.NewTemplateResolver(templateInformer)
+
+	resolved := machine.DeepCopy()
+	if err := controller.resolveProviderConfigTemplate(resolved); err != nil {
+		t.Fatalf("failed to resolve the template: %v", err)
+	}
+	if _, err := controller.ensureResolvedProviderConfigRecorded(resolved); err != nil {
+		t.Fatalf("failed to record the resolved provider config: %v", err)
+	}
+	recorded, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		t.Fatalf("failed to get machine: %v", err)
+	}
+	expected := `{"cloudProvider":"hetzner","operatingSystem":"ubuntu"}`
+	if value := recorded.Annotations[AnnotationResolvedProviderConfig]; value != expected {
+		t.Fatalf("expected the resolved provider config %s to be recorded, got %q", expected, value)
+	}
+	if string(recorded.Spec.ProviderSpec.Value.Raw) != string(machine.Spec.ProviderSpec.Value.Raw) {
+		t.Errorf("expected the machine to keep referencing the template, got %s", recorded.Spec.ProviderSpec.Value.Raw)
+	}
+
+	if err := templateInformer.GetIndexer().Delete(template); err != nil {
+		t.Fatalf("failed to delete template: %v", err)
+	}
+	if err := controller.resolveProviderConfigTemplate(recorded.DeepCopy()); err == nil {
+		t.Errorf("expected an error for a machine which is not deleted")
+	}
+
+	deleted := recorded.DeepCopy()
+	deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	if err := controller.resolveProviderConfigTemplate(deleted); err != nil {
+		t.Fatalf("expected the deleted machine to fall back to the recorded config, got: %v", err)
+	}
+	if value := string(deleted.Spec.ProviderSpec.Value.Raw); value != expected {
+		t.Errorf("expected the provider config %s, got %s", expected, value)
+	}
+}
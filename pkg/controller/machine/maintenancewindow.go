@@ -0,0 +1,253 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationDrainMaintenanceWindow on a MachineDeployment overrides the drain maintenance window of the
+	// controller for its machines, see ParseMaintenanceWindows for the format
+	AnnotationDrainMaintenanceWindow = "machine-controller.kubermatic.io/drain-maintenance-window"
+
+	maintenanceWindowWaitingReason = "WaitingForMaintenanceWindow"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// maintenanceWindow is a daily time range in UTC on the given weekdays. A window whose end is not after its start
+// ends on the next day.
+type maintenanceWindow struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+// MaintenanceWindows are the time ranges in which nodes may be drained
+type MaintenanceWindows []maintenanceWindow
+
+// ParseMaintenanceWindows parses windows separated by ';'. Each window is a time range in UTC, optionally preceded
+// by the comma separated weekdays or weekday ranges it applies to, e.g. "Sat-Sun 00:00-24:00; Mon-Fri 22:00-04:00".
+// Windows without weekdays apply to every day. An empty string returns no windows, which allows drains at any time.
+func ParseMaintenanceWindows(s string) (MaintenanceWindows, error) {
+	var windows MaintenanceWindows
+	for _, raw := range strings.Split(s, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		window, err := parseMaintenanceWindow(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %v", raw, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseMaintenanceWindow(s string) (maintenanceWindow, error) {
+	window := maintenanceWindow{}
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		for day := range window.days {
+			window.days[day] = true
+		}
+	case 2:
+		for _, days := range strings.Split(fields[0], ",") {
+			from, to := days, days
+			if i := strings.Index(days, "-"); i >= 0 {
+				from, to = days[:i], days[i+1:]
+			}
+			first, ok := weekdays[strings.ToLower(from)]
+			if !ok {
+				return window, fmt.Errorf("unknown weekday %q", from)
+			}
+			last, ok := weekdays[strings.ToLower(to)]
+			if !ok {
+				return window, fmt.Errorf("unknown weekday %q", to)
+			}
+			for day := first; ; day = (day + 1) % 7 {
+				window.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		}
+	default:
+		return window, fmt.Errorf("expected [<weekdays>] <HH:MM>-<HH:MM>")
+	}
+
+	times := strings.Split(fields[len(fields)-1], "-")
+	if len(times) != 2 {
+		return window, fmt.Errorf("expected a time range <HH:MM>-<HH:MM>, got %q", fields[len(fields)-1])
+	}
+	var err error
+	if window.start, err = parseTimeOfDay(times[0]); err != nil {
+		return window, err
+	}
+	if window.end, err = parseTimeOfDay(times[1]); err != nil {
+		return window, err
+	}
+	if window.start == window.end {
+		return window, fmt.Errorf("the window must not start and end at the same time")
+	}
+	return window, nil
+}
+
+// parseTimeOfDay parses HH:MM as the duration since midnight. 24:00 is allowed as end of the day
+func parseTimeOfDay(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid minute in %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// occurrence returns the start and end of the window on the day of the given midnight
+func (w maintenanceWindow) occurrence(midnight time.Time) (time.Time, time.Time) {
+	end := w.end
+	if end <= w.start {
+		end += 24 * time.Hour
+	}
+	return midnight.Add(w.start), midnight.Add(end)
+}
+
+// Contains tells if t is within one of the windows. No windows contain all times
+func (ws MaintenanceWindows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	t = t.UTC()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for _, w := range ws {
+		// Windows which started the day before can still be open
+		for _, midnight := range []time.Time{today.AddDate(0, 0, -1), today} {
+			if !w.days[midnight.Weekday()] {
+				continue
+			}
+			if start, end := w.occurrence(midnight); !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextStart returns the next time after t at which one of the windows opens
+func (ws MaintenanceWindows) NextStart(t time.Time) time.Time {
+	t = t.UTC()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	var next time.Time
+	for _, w := range ws {
+		for day := 0; day <= 7; day++ {
+			midnight := today.AddDate(0, 0, day)
+			if !w.days[midnight.Weekday()] {
+				continue
+			}
+			if start, _ := w.occurrence(midnight); start.After(t) {
+				if next.IsZero() || start.Before(next) {
+					next = start
+				}
+				break
+			}
+		}
+	}
+	return next
+}
+
+// drainMaintenanceWindows returns the maintenance windows which apply to the machine. An invalid annotation on its
+// MachineDeployment is ignored, so a typo does not block drains forever.
+func (c *Controller) drainMaintenanceWindows(machine *clusterv1alpha1.Machine) (MaintenanceWindows, error) {
+	deploymentName, ok := kuberneteshelper.MachineDeploymentName(machine)
+	if !ok || c.machineDeploymentLister == nil {
+		return c.drainMaintenanceWindow, nil
+	}
+	deployment, err := c.machineDeploymentLister.MachineDeployments(machine.Namespace).Get(deploymentName)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return c.drainMaintenanceWindow, nil
+		}
+		return nil, fmt.Errorf("failed to get MachineDeployment %s/%s: %v", machine.Namespace, deploymentName, err)
+	}
+	value, exists := deployment.Annotations[AnnotationDrainMaintenanceWindow]
+	if !exists {
+		return c.drainMaintenanceWindow, nil
+	}
+	windows, err := ParseMaintenanceWindows(value)
+	if err != nil {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "InvalidDrainMaintenanceWindow",
+			"Ignoring %s annotation of MachineDeployment %s: %v", AnnotationDrainMaintenanceWindow, deploymentName, err)
+		return c.drainMaintenanceWindow, nil
+	}
+	return windows, nil
+}
+
+// waitForDrainMaintenanceWindow returns false if the node of the machine must not be drained yet, because the
+// maintenance window is closed. The machine gets re-enqueued for the next opening of the window then and its
+// DrainMaintenanceWindowOpen condition is false. Drains which already started are not interrupted.
+func (c *Controller) waitForDrainMaintenanceWindow(machine *clusterv1alpha1.Machine, now time.Time) (bool, error) {
+	windows, err := c.drainMaintenanceWindows(machine)
+	if err != nil {
+		return false, err
+	}
+	if windows.Contains(now) || c.isDraining(machine) {
+		return true, c.openDrainGate(machine, providerconfig.DrainMaintenanceWindowOpenConditionType, "MaintenanceWindowOpen", "The drain maintenance window is open")
+	}
+
+	next := windows.NextStart(now)
+	glog.V(3).Infof("Delaying the drain of machine %s until the maintenance window opens at %s", machine.Name, next.Format(time.RFC3339))
+	c.enqueueMachineAfter(machine, next.Sub(now))
+	return false, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(providerconfig.Condition{
+			Type:    providerconfig.DrainMaintenanceWindowOpenConditionType,
+			Status:  corev1.ConditionFalse,
+			Reason:  maintenanceWindowWaitingReason,
+			Message: fmt.Sprintf("Waiting for the drain maintenance window to open at %s", next.Format(time.RFC3339)),
+		})
+	})
+}
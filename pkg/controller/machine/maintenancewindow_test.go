@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterlistersv1alpha1 "sigs.k8s.io/cluster-api/pkg/client/listers_generated/cluster/v1alpha1"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	// 2019-05-15 is a Wednesday
+	at := func(day int, hour, minute int) time.Time {
+		return time.Date(2019, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name          string
+		windows       string
+		expectedError bool
+		open          []time.Time
+		closed        []time.Time
+		nextStartFrom time.Time
+		nextStart     time.Time
+	}{
+		{
+			name:    "no windows",
+			windows: "",
+			open:    []time.Time{at(15, 12, 0)},
+		},
+		{
+			name:          "daily window",
+			windows:       "01:00-05:30",
+			open:          []time.Time{at(15, 1, 0), at(18, 5, 29)},
+			closed:        []time.Time{at(15, 0, 59), at(15, 5, 30)},
+			nextStartFrom: at(15, 12, 0),
+			nextStart:     at(16, 1, 0),
+		},
+		{
+			name:          "weekend and nights",
+			windows:       "Sat-Sun 00:00-24:00; Mon-Fri 22:00-04:00",
+			open:          []time.Time{at(15, 23, 0), at(16, 3, 59), at(18, 12, 0), at(19, 23, 59)},
+			closed:        []time.Time{at(15, 12, 0), at(20, 4, 0)},
+			nextStartFrom: at(15, 12, 0),
+			nextStart:     at(15, 22, 0),
+		},
+		{
+			name:          "night spanning into the weekend",
+			windows:       "fri 22:00-04:00",
+			open:          []time.Time{at(18, 3, 0)},
+			closed:        []time.Time{at(17, 3, 0), at(18, 22, 0)},
+			nextStartFrom: at(18, 3, 0),
+			nextStart:     at(24, 22, 0),
+		},
+		{
+			name:          "weekday list and range wrapping around the week",
+			windows:       "Wed,Fri-Mon 10:00-11:00",
+			open:          []time.Time{at(15, 10, 30), at(19, 10, 30), at(20, 10, 30)},
+			closed:        []time.Time{at(16, 10, 30), at(21, 10, 30)},
+			nextStartFrom: at(15, 12, 0),
+			nextStart:     at(17, 10, 0),
+		},
+		{
+			name:          "unknown weekday",
+			windows:       "Someday 10:00-11:00",
+			expectedError: true,
+		},
+		{
+			name:          "invalid time",
+			windows:       "10:00-25:00",
+			expectedError: true,
+		},
+		{
+			name:          "empty window",
+			windows:       "10:00-10:00",
+			expectedError: true,
+		},
+		{
+			name:          "missing time range",
+			windows:       "Mon-Fri",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			windows, err := ParseMaintenanceWindows(test.windows)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %v, got: %v", test.expectedError, err)
+			}
+			if test.expectedError {
+				return
+			}
+			for _, open := range test.open {
+				if !windows.Contains(open) {
+					t.Errorf("expected the window to be open at %s", open.Format(time.RFC1123))
+				}
+			}
+			for _, closed := range test.closed {
+				if windows.Contains(closed) {
+					t.Errorf("expected the window to be closed at %s", closed.Format(time.RFC1123))
+				}
+			}
+			if !test.nextStartFrom.IsZero() {
+				if next := windows.NextStart(test.nextStartFrom); !next.Equal(test.nextStart) {
+					t.Errorf("expected the window to open next at %s, got %s", test.nextStart.Format(time.RFC1123), next.Format(time.RFC1123))
+				}
+			}
+		})
+	}
+}
+
+func TestControllerDrainWaitsForMaintenanceWindow(t *testing.T) {
+	// Wednesday noon and Saturday night
+	outside := time.Date(2019, 5, 15, 12, 0, 0, 0, time.UTC)
+	inside := time.Date(2019, 5, 18, 2, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                 string
+		controllerWindow     string
+		deploymentAnnotation *string
+		expectedDeferred     bool
+	}{
+		{
+			name:             "controller window",
+			controllerWindow: "Sat-Sun 00:00-06:00",
+			expectedDeferred: true,
+		},
+		{
+			name:                 "MachineDeployment overrides the controller window",
+			deploymentAnnotation: strPtr("Wed 11:00-13:00"),
+			controllerWindow:     "Sat-Sun 00:00-06:00",
+		},
+		{
+			name:                 "MachineDeployment restricts the drains",
+			deploymentAnnotation: strPtr("Sat 00:00-06:00"),
+			expectedDeferred:     true,
+		},
+		{
+			name:                 "invalid annotation falls back to the controller window",
+			deploymentAnnotation: strPtr("Caturday 00:00-06:00"),
+			controllerWindow:     "Sat-Sun 00:00-06:00",
+			expectedDeferred:     true,
+		},
+		{
+			name: "no window",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := deletingMachine("machine-1", false)
+			machine.Labels = map[string]string{"machine-template-hash": "1234"}
+			machine.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineSet", Name: "workers-" + rand.SafeEncodeString("1234")}}
+			machine.Status.NodeRef = &corev1.ObjectReference{Name: "node-1"}
+			controller := newVolumeDetachTestController(t, machine)
+			defer controller.workqueue.ShutDown()
+
+			deployment := &clusterv1alpha1.MachineDeployment{ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: machine.Namespace}}
+			if test.deploymentAnnotation != nil {
+				deployment.Annotations = map[string]string{AnnotationDrainMaintenanceWindow: *test.deploymentAnnotation}
+			}
+			deploymentIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := deploymentIndexer.Add(deployment); err != nil {
+				t.Fatal(err)
+			}
+			controller.machineDeploymentLister = clusterlistersv1alpha1.NewMachineDeploymentLister(deploymentIndexer)
+			var err error
+			if controller.drainMaintenanceWindow, err = ParseMaintenanceWindows(test.controllerWindow); err != nil {
+				t.Fatal(err)
+			}
+
+			getMachine := func() *clusterv1alpha1.Machine {
+				m, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return m
+			}
+
+			open, err := controller.waitForDrainMaintenanceWindow(getMachine(), outside)
+			if err != nil {
+				t.Fatalf("failed to check the maintenance window: %v", err)
+			}
+			if open == test.expectedDeferred {
+				t.Fatalf("expected the drain to be deferred: %v, got %v", test.expectedDeferred, !open)
+			}
+			condition := getCondition(t, getMachine(), providerconfig.DrainMaintenanceWindowOpenConditionType)
+			if !test.expectedDeferred {
+				if condition != nil {
+					t.Errorf("expected no condition %s for a drain which did not wait, got %+v", providerconfig.DrainMaintenanceWindowOpenConditionType, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != maintenanceWindowWaitingReason {
+				t.Fatalf("expected the condition %s to be false with reason %s, got %+v", providerconfig.DrainMaintenanceWindowOpenConditionType, maintenanceWindowWaitingReason, condition)
+			}
+			if !evictionStart(getMachine()).After(machine.DeletionTimestamp.Time) {
+				t.Errorf("expected the time waiting for the window to not count towards skipping the eviction")
+			}
+
+			// The drain proceeds once the window opened
+			open, err = controller.waitForDrainMaintenanceWindow(getMachine(), inside)
+			if err != nil {
+				t.Fatalf("failed to check the maintenance window: %v", err)
+			}
+			if !open {
+				t.Fatal("expected the drain to proceed within the maintenance window")
+			}
+			condition = getCondition(t, getMachine(), providerconfig.DrainMaintenanceWindowOpenConditionType)
+			if condition == nil || condition.Status != corev1.ConditionTrue {
+				t.Errorf("expected the condition %s to be true, got %+v", providerconfig.DrainMaintenanceWindowOpenConditionType, condition)
+			}
+		})
+	}
+}
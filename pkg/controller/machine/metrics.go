@@ -61,6 +61,7 @@ func NewMachineControllerMetrics() *MetricsCollection {
 type MachineCollector struct {
 	lister     v1alpha1.MachineLister
 	kubeClient kubernetes.Interface
+	templates  *providerconfig.TemplateResolver
 
 	machines       *prometheus.Desc
 	machineCreated *prometheus.Desc
@@ -109,7 +110,7 @@ func (l *machineMetricLabels) Counter(value uint) prometheus.Counter {
 	return counter
 }
 
-func NewMachineCollector(lister v1alpha1.MachineLister, kubeClient kubernetes.Interface) *MachineCollector {
+func NewMachineCollector(lister v1alpha1.MachineLister, kubeClient kubernetes.Interface, templates *providerconfig.TemplateResolver) *MachineCollector {
 
 	// Start periodically calling the providers SetMetricsForMachines in a dedicated go routine
 	skg := providerconfig.NewConfigVarResolver(kubeClient)
@@ -130,6 +131,11 @@ func NewMachineCollector(lister v1alpha1.MachineLister, kubeClient kubernetes.In
 
 			providerMachineMap := map[providerconfig.CloudProvider]*clusterv1alpha1.MachineList{}
 			for _, machine := range machines {
+				machine, err := resolvedMachine(templates, machine)
+				if err != nil {
+					utilruntime.HandleError(fmt.Errorf("failed to resolve providerSpec for SetMetricsForMachines: %v", err))
+					continue
+				}
 				providerConfig, err := providerconfig.GetConfig(machine.Spec.ProviderSpec)
 				if err != nil {
 					utilruntime.HandleError(fmt.Errorf("failed to get providerSpec for SetMetricsForMachines: %v", err))
@@ -163,6 +169,7 @@ func NewMachineCollector(lister v1alpha1.MachineLister, kubeClient kubernetes.In
 	return &MachineCollector{
 		lister:     lister,
 		kubeClient: kubeClient,
+		templates:  templates,
 
 		machines: prometheus.NewDesc(
 			metricsPrefix+"machines",
@@ -216,6 +223,11 @@ func (mc MachineCollector) Collect(ch chan<- prometheus.Metric) {
 			)
 		}
 
+		machine, err := resolvedMachine(mc.templates, machine)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("failed to resolve providerSpec for machine %s: %v", machine.Name, err))
+			continue
+		}
 		providerConfig, err := providerconfig.GetConfig(machine.Spec.ProviderSpec)
 		if err != nil {
 			utilruntime.HandleError(fmt.Errorf("failed to determine providerSpec for machine %s: %v", machine.Name, err))
@@ -266,3 +278,15 @@ func (mc MachineCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- info.Counter(count)
 	}
 }
+
+// resolvedMachine returns a copy of the machine with its provider config merged on top of the referenced
+// ProviderConfigTemplate
+func resolvedMachine(templates *providerconfig.TemplateResolver, machine *clusterv1alpha1.Machine) (*clusterv1alpha1.Machine, error) {
+	providerSpec, err := templates.Resolve(machine.Namespace, machine.Spec.ProviderSpec)
+	if err != nil {
+		return machine, err
+	}
+	machine = machine.DeepCopy()
+	machine.Spec.ProviderSpec = providerSpec
+	return machine, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1beta1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// NodeCredentialsRecovery re-provisions nodes which lost access to the API because their kubelet
+// client certificate expired without getting rotated. Approved CSRs get garbage collected, so the
+// expiration of the newest client certificate is recorded on the machine while the node is ready.
+type NodeCredentialsRecovery struct {
+	csrLister   certificateslisters.CertificateSigningRequestLister
+	gracePeriod time.Duration
+}
+
+// NewNodeCredentialsRecovery returns a NodeCredentialsRecovery which re-provisions nodes that are
+// not ready for the grace period after their client certificate expired. nil is returned if the
+// grace period is not set
+func NewNodeCredentialsRecovery(csrLister certificateslisters.CertificateSigningRequestLister, gracePeriod time.Duration) *NodeCredentialsRecovery {
+	if gracePeriod <= 0 {
+		return nil
+	}
+	return &NodeCredentialsRecovery{csrLister: csrLister, gracePeriod: gracePeriod}
+}
+
+// clientCertExpiration returns the expiration of the newest client certificate issued to the given node.
+// nil is returned if no CSR with an issued client certificate exists for it.
+func (r *NodeCredentialsRecovery) clientCertExpiration(nodeName string) (*time.Time, error) {
+	csrs, err := r.csrLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CSRs: %v", err)
+	}
+
+	var expiration *time.Time
+	for _, csr := range csrs {
+		if csr.Spec.Username != "system:node:"+nodeName || len(csr.Status.Certificate) == 0 {
+			continue
+		}
+		if !hasKeyUsage(csr.Spec.Usages, certificatesv1beta1.UsageClientAuth) {
+			continue
+		}
+		block, _ := pem.Decode(csr.Status.Certificate)
+		if block == nil {
+			glog.V(4).Infof("Ignoring CSR %s with a certificate which is not PEM encoded", csr.Name)
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			glog.V(4).Infof("Ignoring CSR %s with an invalid certificate: %v", csr.Name, err)
+			continue
+		}
+		if expiration == nil || cert.NotAfter.After(*expiration) {
+			notAfter := cert.NotAfter
+			expiration = &notAfter
+		}
+	}
+	return expiration, nil
+}
+
+func hasKeyUsage(usages []certificatesv1beta1.KeyUsage, usage certificatesv1beta1.KeyUsage) bool {
+	for _, u := range usages {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureNodeClientCertExpirationRecorded records the expiration of the newest client certificate of the
+// ready node of the machine
+func (c *Controller) ensureNodeClientCertExpirationRecorded(machine *clusterv1alpha1.Machine, node *corev1.Node) error {
+	if c.nodeCredentialsRecovery == nil {
+		return nil
+	}
+
+	expiration, err := c.nodeCredentialsRecovery.clientCertExpiration(node.Name)
+	if err != nil || expiration == nil {
+		return err
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status: %v", err)
+	}
+	if recorded := providerStatus.NodeClientCertificateExpiration; recorded != nil && !expiration.After(recorded.Time) {
+		return nil
+	}
+
+	return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.NodeClientCertificateExpiration = &metav1.Time{Time: *expiration}
+	})
+}
+
+// nodeCredentialsExpired returns true if the node stopped reporting its status before its client
+// certificate expired and is not ready since at least the grace period. A node which reported its
+// status after the expiration must have had a newer certificate.
+func (c *Controller) nodeCredentialsExpired(machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	var readyCondition *corev1.NodeCondition
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == corev1.NodeReady {
+			readyCondition = &node.Status.Conditions[i]
+		}
+	}
+	if readyCondition == nil || readyCondition.Status == corev1.ConditionTrue {
+		return false, nil
+	}
+
+	expiration, err := c.nodeCredentialsRecovery.clientCertExpiration(node.Name)
+	if err != nil {
+		return false, err
+	}
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status: %v", err)
+	}
+	if recorded := providerStatus.NodeClientCertificateExpiration; recorded != nil && (expiration == nil || recorded.After(*expiration)) {
+		expiration = &recorded.Time
+	}
+	if expiration == nil || time.Now().Before(*expiration) {
+		return false, nil
+	}
+	if readyCondition.LastHeartbeatTime.After(*expiration) {
+		return false, nil
+	}
+
+	if remaining := c.nodeCredentialsRecovery.gracePeriod - time.Since(readyCondition.LastTransitionTime.Time); remaining > 0 {
+		// Nothing triggers a sync once the grace period is over
+		c.enqueueMachineAfter(machine, remaining)
+		return false, nil
+	}
+	return true, nil
+}
+
+// recoverExpiredNodeCredentials re-provisions the instance of a machine whose node is stuck because its
+// client certificate expired. The instance gets deleted first, afterwards the node and the bootstrap token
+// so the new instance gets created with a fresh token.
+// It returns true if the node is being recovered.
+func (c *Controller) recoverExpiredNodeCredentials(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	if c.nodeCredentialsRecovery == nil {
+		return false, nil
+	}
+
+	expired, err := c.nodeCredentialsExpired(machine, node)
+	if err != nil || !expired {
+		return false, err
+	}
+
+	if sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
+		glog.V(2).Infof("Client certificate of node %s expired, re-provisioning the instance of machine %s", node.Name, machine.Name)
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "NodeCredentialsExpired", "Node %s lost access to the cluster because its client certificate expired, re-provisioning the instance", node.Name)
+		return true, c.deleteCloudProviderInstance(prov, machine)
+	}
+
+	if err := c.kubeClient.CoreV1().Nodes().Delete(node.Name, nil); err != nil && !kerrors.IsNotFound(err) {
+		return true, fmt.Errorf("failed to delete node %s with expired credentials: %v", node.Name, err)
+	}
+
+	if c.bootstrapTokenServiceAccountName == nil {
+		secret, err := c.getSecretIfExists(machine.Name)
+		if err != nil {
+			return true, fmt.Errorf("failed to get bootstrap token of machine %s: %v", machine.Name, err)
+		}
+		if secret != nil {
+			if err := c.kubeClient.CoreV1().Secrets(secret.Namespace).Delete(secret.Name, nil); err != nil && !kerrors.IsNotFound(err) {
+				return true, fmt.Errorf("failed to delete bootstrap token of machine %s: %v", machine.Name, err)
+			}
+		}
+	}
+
+	return true, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.NodeClientCertificateExpiration = nil
+	})
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1beta1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func clientCertCSR(t *testing.T, name, nodeName string, notAfter time.Time) *certificatesv1beta1.CertificateSigningRequest {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:" + nodeName},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificatesv1beta1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: certificatesv1beta1.CertificateSigningRequestSpec{
+			Username: "system:node:" + nodeName,
+			Usages:   []certificatesv1beta1.KeyUsage{certificatesv1beta1.UsageDigitalSignature, certificatesv1beta1.UsageClientAuth},
+		},
+		Status: certificatesv1beta1.CertificateSigningRequestStatus{
+			Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		},
+	}
+}
+
+func TestControllerRecoversNodeWithExpiredCredentials(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name             string
+		certExpiration   time.Time
+		recordedExpiry   *time.Time
+		noCSR            bool
+		lastHeartbeat    time.Time
+		notReadySince    time.Time
+		expectedRecovery bool
+	}{
+		{
+			name:             "node that stopped reporting when its certificate expired gets recovered",
+			certExpiration:   now.Add(-2 * time.Hour),
+			lastHeartbeat:    now.Add(-2*time.Hour - time.Minute),
+			notReadySince:    now.Add(-2 * time.Hour),
+			expectedRecovery: true,
+		},
+		{
+			name:             "expiration recorded before the CSR got garbage collected is used",
+			recordedExpiry:   timePtr(now.Add(-2 * time.Hour)),
+			noCSR:            true,
+			lastHeartbeat:    now.Add(-2*time.Hour - time.Minute),
+			notReadySince:    now.Add(-2 * time.Hour),
+			expectedRecovery: true,
+		},
+		{
+			name:           "node within the grace period is kept",
+			certExpiration: now.Add(-2 * time.Hour),
+			lastHeartbeat:  now.Add(-2*time.Hour - time.Minute),
+			notReadySince:  now.Add(-time.Minute),
+		},
+		{
+			name:           "node with a valid certificate is kept",
+			certExpiration: now.Add(time.Hour),
+			lastHeartbeat:  now.Add(-time.Hour),
+			notReadySince:  now.Add(-time.Hour),
+		},
+		{
+			name:           "node that reported after the expiration had a newer certificate and is kept",
+			certExpiration: now.Add(-2 * time.Hour),
+			lastHeartbeat:  now.Add(-time.Hour),
+			notReadySince:  now.Add(-time.Hour),
+		},
+		{
+			name:          "node without known certificate is kept",
+			noCSR:         true,
+			lastHeartbeat: now.Add(-time.Hour),
+			notReadySince: now.Add(-time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+					Type:               corev1.NodeReady,
+					Status:             corev1.ConditionUnknown,
+					Reason:             "NodeStatusUnknown",
+					LastHeartbeatTime:  metav1.NewTime(test.lastHeartbeat),
+					LastTransitionTime: metav1.NewTime(test.notReadySince),
+				}}},
+			}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "machine-1",
+					Namespace:  "kube-system",
+					Finalizers: []string{FinalizerDeleteInstance, FinalizerDeleteNode},
+				},
+				Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+			}
+			if test.recordedExpiry != nil {
+				rawProviderStatus, err := (&providerconfig.ProviderStatus{
+					NodeClientCertificateExpiration: &metav1.Time{Time: *test.recordedExpiry},
+				}).RawExtension()
+				if err != nil {
+					t.Fatal(err)
+				}
+				machine.Status.ProviderStatus = rawProviderStatus
+			}
+			tokenSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "bootstrap-token-abcdef",
+					Namespace: metav1.NamespaceSystem,
+					Labels:    map[string]string{machineNameLabelKey: "machine-1"},
+				},
+			}
+
+			csrIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if !test.noCSR {
+				if err := csrIndexer.Add(clientCertCSR(t, "csr-1", "node-1", test.certExpiration)); err != nil {
+					t.Fatal(err)
+				}
+				// Certificates of other nodes must not be taken into account
+				if err := csrIndexer.Add(clientCertCSR(t, "csr-2", "node-2", now.Add(time.Hour))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if err := secretIndexer.Add(tokenSecret); err != nil {
+				t.Fatal(err)
+			}
+
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node, tokenSecret)
+			controller.secretSystemNsLister = corev1listers.NewSecretLister(secretIndexer)
+			controller.nodeCredentialsRecovery = NewNodeCredentialsRecovery(certificateslisters.NewCertificateSigningRequestLister(csrIndexer), 10*time.Minute)
+			defer controller.workqueue.ShutDown()
+			prov := fakecloudprovider.New(nil)
+
+			recovering, err := controller.recoverExpiredNodeCredentials(prov, machine, node)
+			if err != nil {
+				t.Fatalf("failed to recover node: %v", err)
+			}
+			if recovering != test.expectedRecovery {
+				t.Fatalf("expected recovery to be %v, got %v", test.expectedRecovery, recovering)
+			}
+
+			machine, err = controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instanceDeleted := !sets.NewString(machine.Finalizers...).Has(FinalizerDeleteInstance); instanceDeleted != test.expectedRecovery {
+				t.Fatalf("expected instance deletion to be %v, got %v", test.expectedRecovery, instanceDeleted)
+			}
+			if !test.expectedRecovery {
+				return
+			}
+
+			// The node and the bootstrap token get deleted once the instance is gone
+			if _, err := controller.recoverExpiredNodeCredentials(prov, machine, node); err != nil {
+				t.Fatalf("failed to recover node: %v", err)
+			}
+			if _, err := controller.kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+				t.Errorf("expected node to be deleted, got %v", err)
+			}
+			if _, err := controller.kubeClient.CoreV1().Secrets(tokenSecret.Namespace).Get(tokenSecret.Name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+				t.Errorf("expected bootstrap token to be deleted, got %v", err)
+			}
+			machine, err = controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if providerStatus.NodeClientCertificateExpiration != nil {
+				t.Errorf("expected the recorded certificate expiration to be removed, got %v", providerStatus.NodeClientCertificateExpiration)
+			}
+		})
+	}
+}
+
+func TestControllerRecordsNodeClientCertExpiration(t *testing.T) {
+	expiration := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	csrIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, csr := range []*certificatesv1beta1.CertificateSigningRequest{
+		clientCertCSR(t, "csr-old", "node-1", expiration.Add(-24*time.Hour)),
+		clientCertCSR(t, "csr-new", "node-1", expiration),
+	} {
+		if err := csrIndexer.Add(csr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"}}
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine})
+	controller.nodeCredentialsRecovery = NewNodeCredentialsRecovery(certificateslisters.NewCertificateSigningRequestLister(csrIndexer), 10*time.Minute)
+	if err := controller.ensureNodeClientCertExpirationRecorded(machine, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}); err != nil {
+		t.Fatalf("failed to record client certificate expiration: %v", err)
+	}
+
+	machine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded := providerStatus.NodeClientCertificateExpiration; recorded == nil || !recorded.Time.Equal(expiration) {
+		t.Errorf("expected the expiration %v to be recorded, got %v", expiration, recorded)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// NodeTagLabels syncs tags of the instances, which get set out-of-band on the cloud provider, onto their nodes as
+// labels. The labels follow the tags, they get updated when a tag changes and removed when it got removed.
+type NodeTagLabels struct {
+	// labels maps the tag keys to the label keys
+	labels map[string]string
+}
+
+// NewNodeTagLabels returns the NodeTagLabels for the given comma separated list of tag keys. A tag gets synced to the
+// label with the same key unless another one is given as tag=label, e.g. for tag keys which are no valid label keys.
+// nil is returned if the list is empty
+func NewNodeTagLabels(mapping string) (*NodeTagLabels, error) {
+	t := &NodeTagLabels{labels: map[string]string{}}
+	labelKeys := map[string]bool{}
+	for _, entry := range strings.Split(mapping, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		tagKey, labelKey := entry, entry
+		if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 {
+			tagKey, labelKey = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		}
+		if tagKey == "" {
+			return nil, fmt.Errorf("invalid entry %q, the tag key is empty", entry)
+		}
+		if errs := validation.IsQualifiedName(labelKey); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid entry %q, %q is no valid label key: %s", entry, labelKey, strings.Join(errs, ", "))
+		}
+		if _, exists := t.labels[tagKey]; exists {
+			return nil, fmt.Errorf("tag %q is listed more than once", tagKey)
+		}
+		if labelKeys[labelKey] {
+			return nil, fmt.Errorf("label %q is set from more than one tag", labelKey)
+		}
+		t.labels[tagKey] = labelKey
+		labelKeys[labelKey] = true
+	}
+	if len(t.labels) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// nodeLabels returns the labels the configured tags map to. Labels of tags which are not set are mapped to nil, so
+// they get removed from the node. Tags whose values are no valid label values are skipped, their labels are kept as
+// they are
+func (t *NodeTagLabels) nodeLabels(tags map[string]string, nodeName string) map[string]*string {
+	labels := map[string]*string{}
+	for tagKey, labelKey := range t.labels {
+		value, exists := tags[tagKey]
+		if !exists {
+			labels[labelKey] = nil
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			glog.V(4).Infof("Not syncing tag %s=%q onto node %s, it is no valid label value: %v", tagKey, value, nodeName, errs)
+			continue
+		}
+		labels[labelKey] = &value
+	}
+	return labels
+}
+
+// ensureNodeTagLabels syncs the configured tags of the instance of the machine onto its node. Providers whose
+// instances have no tags are skipped, as well as syncs without an instance
+func (c *Controller) ensureNodeTagLabels(providerInstance instance.Instance, machine *clusterv1alpha1.Machine, node *corev1.Node) error {
+	if c.nodeTagLabels == nil || providerInstance == nil {
+		return nil
+	}
+	tagger, ok := providerInstance.(instance.Tagger)
+	if !ok {
+		return nil
+	}
+
+	labels := c.nodeTagLabels.nodeLabels(tagger.Tags(), node.Name)
+	var changed []string
+	for key, value := range labels {
+		current, labeled := node.Labels[key]
+		if value == nil && labeled || value != nil && (!labeled || current != *value) {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+
+	if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+		if n.Labels == nil {
+			n.Labels = map[string]string{}
+		}
+		for _, key := range changed {
+			if labels[key] == nil {
+				delete(n.Labels, key)
+			} else {
+				n.Labels[key] = *labels[key]
+			}
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to update node %s after syncing the labels from instance tags: %v", node.Name, err)
+	}
+	glog.V(3).Infof("Synced labels %s from instance tags onto node %s (machine %s)", strings.Join(changed, ", "), node.Name, machine.Name)
+	return nil
+}
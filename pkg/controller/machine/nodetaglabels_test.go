@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// taggedInstance is an instance with tags
+type taggedInstance struct {
+	instance.Instance
+	tags map[string]string
+}
+
+func (i *taggedInstance) Tags() map[string]string {
+	return i.tags
+}
+
+func TestNewNodeTagLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		mapping        string
+		expectedLabels map[string]string
+		expectedErr    bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:           "tag keys and mappings",
+			mapping:        "team, aws:cloudformation:stack-name=example.com/stack",
+			expectedLabels: map[string]string{"team": "team", "aws:cloudformation:stack-name": "example.com/stack"},
+		},
+		{
+			name:        "tag key which is no valid label key",
+			mapping:     "aws:cloudformation:stack-name",
+			expectedErr: true,
+		},
+		{
+			name:        "empty tag key",
+			mapping:     "=team",
+			expectedErr: true,
+		},
+		{
+			name:        "label set from two tags",
+			mapping:     "team,owner=team",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tagLabels, err := NewNodeTagLabels(test.mapping)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			var labels map[string]string
+			if tagLabels != nil {
+				labels = tagLabels.labels
+			}
+			if diff := deep.Equal(labels, test.expectedLabels); diff != nil {
+				t.Errorf("unexpected tag labels, diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestControllerEnsureNodeTagLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		instance       instance.Instance
+		nodeLabels     map[string]string
+		expectedLabels map[string]string
+		expectUpdate   bool
+	}{
+		{
+			name:           "tags get mapped to labels",
+			instance:       &taggedInstance{tags: map[string]string{"team": "payments", "aws:cloudformation:stack-name": "payments-prod", "Name": "node-1"}},
+			nodeLabels:     map[string]string{"kubernetes.io/hostname": "node-1"},
+			expectedLabels: map[string]string{"kubernetes.io/hostname": "node-1", "team": "payments", "example.com/stack": "payments-prod"},
+			expectUpdate:   true,
+		},
+		{
+			name:           "changed tag gets updated and removed tag gets removed",
+			instance:       &taggedInstance{tags: map[string]string{"team": "checkout"}},
+			nodeLabels:     map[string]string{"team": "payments", "example.com/stack": "payments-prod"},
+			expectedLabels: map[string]string{"team": "checkout"},
+			expectUpdate:   true,
+		},
+		{
+			name:           "up to date labels are kept",
+			instance:       &taggedInstance{tags: map[string]string{"team": "payments"}},
+			nodeLabels:     map[string]string{"team": "payments"},
+			expectedLabels: map[string]string{"team": "payments"},
+		},
+		{
+			name:           "invalid label value is skipped",
+			instance:       &taggedInstance{tags: map[string]string{"team": "payments and checkout"}},
+			nodeLabels:     map[string]string{"team": "payments"},
+			expectedLabels: map[string]string{"team": "payments"},
+		},
+		{
+			name:           "instance without tags",
+			instance:       fakecloudprovider.CloudProviderInstance{},
+			nodeLabels:     map[string]string{"team": "payments"},
+			expectedLabels: map[string]string{"team": "payments"},
+		},
+		{
+			name:           "instance which is not due for a refresh",
+			nodeLabels:     map[string]string{"team": "payments"},
+			expectedLabels: map[string]string{"team": "payments"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: test.nodeLabels}}
+			machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"}}
+			tagLabels, err := NewNodeTagLabels("team,aws:cloudformation:stack-name=example.com/stack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.nodeTagLabels = tagLabels
+			kubeClient := controller.kubeClient.(*fake.Clientset)
+
+			if err := controller.ensureNodeTagLabels(test.instance, machine, node); err != nil {
+				t.Fatalf("failed to ensure tag labels: %v", err)
+			}
+
+			var updated bool
+			for _, action := range kubeClient.Actions() {
+				if action.GetVerb() == "update" {
+					updated = true
+				}
+			}
+			if updated != test.expectUpdate {
+				t.Errorf("expected node update: %v, got %v", test.expectUpdate, updated)
+			}
+			updatedNode, err := kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(updatedNode.Labels, test.expectedLabels); diff != nil {
+				t.Errorf("unexpected node labels, diff: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
+	"github.com/kubermatic/machine-controller/pkg/phonehome"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// phoneHomeFor returns the phone-home configuration for the userdata of the machine. nil if not configured
+func (c *Controller) phoneHomeFor(machine *clusterv1alpha1.Machine) *plugin.PhoneHome {
+	if c.phoneHome == nil {
+		return nil
+	}
+	return c.phoneHome.PhoneHome(machine.UID)
+}
+
+func (c *Controller) watchPhoneHomeReports(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case report := <-c.phoneHome.Reports():
+			if err := c.handlePhoneHomeReport(report); err != nil {
+				utilruntime.HandleError(err)
+			}
+		}
+	}
+}
+
+// handlePhoneHomeReport records the bootstrap status reported by a node as condition of its machine
+func (c *Controller) handlePhoneHomeReport(report phonehome.Report) error {
+	machines, err := c.machinesLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list machines in lister: %v", err)
+	}
+
+	var machine *clusterv1alpha1.Machine
+	for _, m := range machines {
+		if m.UID == report.MachineUID {
+			machine = m
+			break
+		}
+	}
+	if machine == nil {
+		glog.V(4).Infof("Ignoring bootstrap status for unknown machine %q", report.MachineUID)
+		return nil
+	}
+
+	condition := providerconfig.Condition{
+		Type:    providerconfig.BootstrapSucceededConditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Succeeded",
+		Message: report.Log,
+	}
+	if !report.Succeeded {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "Failed"
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return err
+	}
+
+	if report.Succeeded {
+		glog.V(3).Infof("Node of machine %s finished its bootstrap", machine.Name)
+		return nil
+	}
+	glog.V(3).Infof("Node of machine %s reported a failed bootstrap", machine.Name)
+	c.recorder.Event(machine, corev1.EventTypeWarning, "BootstrapFailed", "The node reported a failed bootstrap, see the BootstrapSucceeded condition for the log")
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	preCreateHookRetryPeriod     = 30 * time.Second
+	maxPreCreateHookResponseSize = 1 << 20
+)
+
+// PreCreateHook is an external webhook which must allow the creation of an instance before
+// the cloud provider gets called. It can be used to reserve capacity or to notify external systems.
+type PreCreateHook struct {
+	url    string
+	client *http.Client
+}
+
+// NewPreCreateHook returns a PreCreateHook which posts the machine to the given url
+func NewPreCreateHook(url string, timeout time.Duration) *PreCreateHook {
+	return &PreCreateHook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// PreCreateHookRequest gets sent to the hook. The values of sensitive provider spec fields like
+// cloud credentials are redacted
+type PreCreateHookRequest struct {
+	Machine *clusterv1alpha1.Machine `json:"machine"`
+}
+
+// PreCreateHookResponse must be returned by the hook
+type PreCreateHookResponse struct {
+	// Allowed must be true to continue with the instance creation
+	Allowed bool `json:"allowed"`
+	// Reason is a machine readable explanation of the decision
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable explanation of the decision
+	Message string `json:"message,omitempty"`
+}
+
+// Call sends the machine to the hook and returns its decision
+func (h *PreCreateHook) Call(machine *clusterv1alpha1.Machine) (*PreCreateHookResponse, error) {
+	// The hook runs outside of the cluster, so it must not get the credentials of the provider spec
+	redactedMachine := machine.DeepCopy()
+	if machine.Spec.ProviderSpec.Value != nil {
+		providerSpec, err := json.Marshal(cloudprovider.RedactedProviderSpec(machine))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal provider spec: %v", err)
+		}
+		redactedMachine.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: providerSpec}
+	}
+	body, err := json.Marshal(PreCreateHookRequest{Machine: redactedMachine})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call pre-create hook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxPreCreateHookResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of pre-create hook: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pre-create hook returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	hookResponse := &PreCreateHookResponse{}
+	if err := json.Unmarshal(respBody, hookResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode response of pre-create hook: %v", err)
+	}
+	return hookResponse, nil
+}
+
+// checkPreCreateHook calls the pre-create hook and reflects its decision in the
+// PreCreateHookSucceeded condition of the machine. It returns true if the instance may be created.
+func (c *Controller) checkPreCreateHook(machine *clusterv1alpha1.Machine) (bool, error) {
+	if c.preCreateHook == nil {
+		return true, nil
+	}
+
+	condition := providerconfig.Condition{Type: providerconfig.PreCreateHookSucceededConditionType}
+	resp, err := c.preCreateHook.Call(machine)
+	switch {
+	case err != nil:
+		glog.V(3).Infof("Pre-create hook for machine %s is pending: %v", machine.Name, err)
+		condition.Status = corev1.ConditionUnknown
+		condition.Reason = "Pending"
+		condition.Message = err.Error()
+	case !resp.Allowed:
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = resp.Reason
+		if condition.Reason == "" {
+			condition.Reason = "Denied"
+		}
+		condition.Message = resp.Message
+	default:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "Allowed"
+		condition.Message = resp.Message
+	}
+
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return false, err
+	}
+
+	if condition.Status != corev1.ConditionTrue {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "PreCreateHookNotSucceeded", "Instance creation is blocked by the pre-create hook: %s %s", condition.Reason, condition.Message)
+		// The decision of the hook might change, so check again later
+		c.enqueueMachineAfter(machine, preCreateHookRetryPeriod)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationShutdownRequested gets set on the pods of the pre-drain DaemonSets before their node gets drained.
+	// Its value is the time of the request in RFC3339 format
+	AnnotationShutdownRequested = "machine-controller.kubermatic.io/shutdown-requested"
+	// AnnotationShutdownAcknowledged must be set to "true" by the pods of the pre-drain DaemonSets once they shut down cleanly
+	AnnotationShutdownAcknowledged = "machine-controller.kubermatic.io/shutdown-acknowledged"
+
+	preDrainRecheckPeriod = 5 * time.Second
+)
+
+// PreDrainHook asks the pods of node-local DaemonSets to shut down before their node gets drained. DaemonSet
+// pods are not evicted, so they would otherwise only notice the shutdown when the instance is gone.
+type PreDrainHook struct {
+	daemonSets []types.NamespacedName
+	timeout    time.Duration
+}
+
+// NewPreDrainHook returns the PreDrainHook for the given comma separated list of namespace/name DaemonSets.
+// The drain continues once the timeout after the shutdown request is over. nil is returned if the list is empty
+func NewPreDrainHook(daemonSets string, timeout time.Duration) (*PreDrainHook, error) {
+	h := &PreDrainHook{timeout: timeout}
+	for _, daemonSet := range strings.Split(daemonSets, ",") {
+		if daemonSet = strings.TrimSpace(daemonSet); daemonSet == "" {
+			continue
+		}
+		parts := strings.Split(daemonSet, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid DaemonSet %q, expected namespace/name", daemonSet)
+		}
+		h.daemonSets = append(h.daemonSets, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+	}
+	if len(h.daemonSets) == 0 {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// preDrainPods returns the running pods of the pre-drain DaemonSets on the given node
+func (c *Controller) preDrainPods(nodeName string) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	for _, daemonSet := range c.preDrainHook.daemonSets {
+		podList, err := c.kubeClient.CoreV1().Pods(daemonSet.Namespace).List(metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods of DaemonSet %s: %v", daemonSet, err)
+		}
+		for _, pod := range podList.Items {
+			controllerRef := metav1.GetControllerOf(&pod)
+			if controllerRef == nil || controllerRef.Kind != "DaemonSet" || controllerRef.Name != daemonSet.Name {
+				continue
+			}
+			if pod.Spec.NodeName != nodeName || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// runPreDrainHook requests the shutdown of the pods of the pre-drain DaemonSets on the node of the machine.
+// It returns true once all of them acknowledged the shutdown or the timeout is over.
+func (c *Controller) runPreDrainHook(machine *clusterv1alpha1.Machine, nodeName string) (bool, error) {
+	if c.preDrainHook == nil {
+		return true, nil
+	}
+
+	pods, err := c.preDrainPods(nodeName)
+	if err != nil {
+		return false, err
+	}
+
+	var pending []string
+	requestedAt := time.Now()
+	for _, pod := range pods {
+		if pod.Annotations[AnnotationShutdownAcknowledged] == "true" {
+			continue
+		}
+		pending = append(pending, pod.Namespace+"/"+pod.Name)
+
+		if requested, err := time.Parse(time.RFC3339, pod.Annotations[AnnotationShutdownRequested]); err == nil {
+			if requested.Before(requestedAt) {
+				requestedAt = requested
+			}
+			continue
+		}
+		pod := pod.DeepCopy()
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[AnnotationShutdownRequested] = time.Now().UTC().Format(time.RFC3339)
+		if _, err := c.kubeClient.CoreV1().Pods(pod.Namespace).Update(pod); err != nil {
+			return false, fmt.Errorf("failed to request the shutdown of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		glog.V(4).Infof("Requested the shutdown of pod %s/%s before draining node %s", pod.Namespace, pod.Name, nodeName)
+	}
+	if len(pending) == 0 {
+		return true, nil
+	}
+
+	if time.Since(requestedAt) > c.preDrainHook.timeout {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "PreDrainHookTimeout", "Pods %s did not acknowledge their shutdown within %s, draining node %s", strings.Join(pending, ", "), c.preDrainHook.timeout, nodeName)
+		return true, nil
+	}
+	glog.V(4).Infof("Waiting for pods %s to acknowledge their shutdown before draining node %s", strings.Join(pending, ", "), nodeName)
+	c.enqueueMachineAfter(machine, preDrainRecheckPeriod)
+	return false, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func daemonSetPod(name, daemonSet, nodeName string) *corev1.Pod {
+	isController := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "logging",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: daemonSet, Controller: &isController}},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestNewPreDrainHook(t *testing.T) {
+	hook, err := NewPreDrainHook("", time.Minute)
+	if err != nil || hook != nil {
+		t.Errorf("expected no hook without DaemonSets, got %v, %v", hook, err)
+	}
+	hook, err = NewPreDrainHook("logging/log-shipper, monitoring/node-exporter", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.daemonSets) != 2 || hook.daemonSets[1].Namespace != "monitoring" || hook.daemonSets[1].Name != "node-exporter" {
+		t.Errorf("unexpected DaemonSets %v", hook.daemonSets)
+	}
+	if _, err := NewPreDrainHook("log-shipper", time.Minute); err == nil {
+		t.Error("expected an error for a DaemonSet without namespace")
+	}
+}
+
+func TestControllerWaitsForPreDrainHookBeforeDeletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested time.Time
+		acked     bool
+		proceeds  bool
+	}{
+		{
+			name:     "deletion waits for the acknowledgement",
+			proceeds: false,
+		},
+		{
+			name:     "deletion continues once the pods acknowledged the shutdown",
+			acked:    true,
+			proceeds: true,
+		},
+		{
+			name:      "deletion continues once the timeout is over",
+			requested: time.Now().Add(-2 * time.Minute),
+			proceeds:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "machine-1",
+					Namespace:         "kube-system",
+					Finalizers:        []string{FinalizerDeleteInstance, FinalizerDeleteNode},
+					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+				},
+				Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+			}
+			pod := daemonSetPod("log-shipper-x7k2p", "log-shipper", node.Name)
+			if !test.requested.IsZero() {
+				pod.Annotations = map[string]string{AnnotationShutdownRequested: test.requested.UTC().Format(time.RFC3339)}
+			}
+			// Pods of the DaemonSet on other nodes must not be asked to shut down
+			otherPod := daemonSetPod("log-shipper-r4m9d", "log-shipper", "node-2")
+
+			preDrainHook, err := NewPreDrainHook("logging/log-shipper", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node, pod, otherPod)
+			controller.skipEvictionAfter = time.Hour
+			controller.preDrainHook = preDrainHook
+			defer controller.workqueue.ShutDown()
+			kubeClient := controller.kubeClient
+			prov := &cleanupTestProvider{}
+
+			if err := controller.deleteMachine(prov, machine); err != nil {
+				t.Fatalf("failed to delete machine: %v", err)
+			}
+			if timedOut := !test.requested.IsZero(); prov.cleanedUp != timedOut {
+				t.Fatalf("expected the instance deletion to be %v before the pods acknowledged their shutdown, got %v", timedOut, prov.cleanedUp)
+			}
+			pod, err = kubeClient.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, requested := pod.Annotations[AnnotationShutdownRequested]; !requested {
+				t.Fatalf("expected the shutdown to be requested from pod %s, got annotations %v", pod.Name, pod.Annotations)
+			}
+			otherPod, err = kubeClient.CoreV1().Pods(otherPod.Namespace).Get(otherPod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, requested := otherPod.Annotations[AnnotationShutdownRequested]; requested {
+				t.Fatalf("expected no shutdown request for pod %s on another node", otherPod.Name)
+			}
+
+			if test.acked {
+				pod.Annotations[AnnotationShutdownAcknowledged] = "true"
+				if _, err := kubeClient.CoreV1().Pods(pod.Namespace).Update(pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !prov.cleanedUp {
+				if err := controller.deleteMachine(prov, machine); err != nil {
+					t.Fatalf("failed to delete machine: %v", err)
+				}
+			}
+			if prov.cleanedUp != test.proceeds {
+				t.Errorf("expected the instance deletion to be %v, got %v", test.proceeds, prov.cleanedUp)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	providerOperationCreate = "create"
+	providerOperationGet    = "get"
+	providerOperationDelete = "delete"
+)
+
+// setLastProviderError records the failed call to the cloud provider in the provider status of the machine.
+// Failing to do so only gets logged as the caller returns the error of the cloud provider anyway.
+func (c *Controller) setLastProviderError(machine *clusterv1alpha1.Machine, operation string, err error) {
+	providerError := providerconfig.ProviderError{
+		Operation: operation,
+		Reason:    cloudprovidererrors.ErrorCode(err),
+		Message:   err.Error(),
+	}
+	if ok, _, message := cloudprovidererrors.IsTerminalError(err); ok {
+		providerError.Message = message
+	}
+
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetProviderError(providerError)
+	}); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to record the provider error of machine %s: %v", machine.Name, err))
+	}
+}
+
+// clearLastProviderError removes the recorded provider error after a successful call to the cloud provider
+func (c *Controller) clearLastProviderError(machine *clusterv1alpha1.Machine) {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err))
+		return
+	}
+	if providerStatus.LastProviderError == nil {
+		return
+	}
+
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.LastProviderError = nil
+	}); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to clear the provider error of machine %s: %v", machine.Name, err))
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationReadinessGatePodSelector is a label selector of a pod, e.g. of the CNI DaemonSet, which must be running
+	// on the node before the machine is considered provisioned. Set it on the template of a MachineDeployment to gate
+	// all of its machines.
+	AnnotationReadinessGatePodSelector = "machine-controller.kubermatic.io/readiness-gate-pod-selector"
+
+	// Pods are not watched, so a pending readiness gate gets checked periodically
+	readinessGateRecheckPeriod = 10 * time.Second
+)
+
+// readinessGatePassed tells if a pod matching the readiness gate of the machine is running on the node. Machines without
+// a readiness gate always pass. The result gets recorded in the ReadinessGatePassed condition of the machine.
+func (c *Controller) readinessGatePassed(machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	rawSelector, gated := machine.Annotations[AnnotationReadinessGatePodSelector]
+	if !gated {
+		return true, nil
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status: %v", err)
+	}
+	// A passed gate is not checked again, a restart of the gating pod must not make a provisioned machine unready
+	if condition := providerStatus.GetCondition(providerconfig.ReadinessGatePassedConditionType); condition != nil && condition.Status == corev1.ConditionTrue {
+		return true, nil
+	}
+
+	selector, err := labels.Parse(rawSelector)
+	if err != nil {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "InvalidReadinessGate", "Invalid readiness gate pod selector %q: %v", rawSelector, err)
+		return false, fmt.Errorf("invalid readiness gate pod selector %q: %v", rawSelector, err)
+	}
+	pods, err := c.kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods of the readiness gate: %v", err)
+	}
+
+	condition := providerconfig.Condition{
+		Type:    providerconfig.ReadinessGatePassedConditionType,
+		Status:  corev1.ConditionFalse,
+		Reason:  "PodNotRunning",
+		Message: fmt.Sprintf("Waiting for a pod matching %q to run on node %s", rawSelector, node.Name),
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == node.Name && pod.Status.Phase == corev1.PodRunning {
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = "PodRunning"
+			condition.Message = fmt.Sprintf("Pod %s/%s is running on node %s", pod.Namespace, pod.Name, node.Name)
+			break
+		}
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return false, err
+	}
+
+	passed := condition.Status == corev1.ConditionTrue
+	if !passed {
+		c.enqueueMachineAfter(machine, readinessGateRecheckPeriod)
+	}
+	return passed, nil
+}
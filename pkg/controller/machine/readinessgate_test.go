@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestControllerWaitsForReadinessGatePod(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "machine-1",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{AnnotationReadinessGatePodSelector: "k8s-app=canal"},
+		},
+		Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "canal-x7k2p", Namespace: "kube-system", Labels: map[string]string{"k8s-app": "canal"}},
+		Spec:       corev1.PodSpec{NodeName: node.Name},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	// Matching pods on other nodes must not pass the gate
+	otherPod := pod.DeepCopy()
+	otherPod.Name = "canal-r4m9d"
+	otherPod.Spec.NodeName = "node-2"
+	otherPod.Status.Phase = corev1.PodRunning
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node, pod, otherPod)
+	defer controller.workqueue.ShutDown()
+
+	verify := func(expectedProvisioned bool, expectedStatus corev1.ConditionStatus) {
+		t.Helper()
+		if _, err := controller.ensureMachineProvisioned(machine, node); err != nil {
+			t.Fatalf("failed to provision machine: %v", err)
+		}
+		machine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		provisioned := false
+		for _, condition := range machine.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				provisioned = true
+			}
+		}
+		if provisioned != expectedProvisioned {
+			t.Fatalf("expected machine to be provisioned to be %v, got %v", expectedProvisioned, provisioned)
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := providerStatus.GetCondition(providerconfig.ReadinessGatePassedConditionType)
+		if condition == nil || condition.Status != expectedStatus {
+			t.Fatalf("expected readiness gate condition with status %s, got %v", expectedStatus, condition)
+		}
+	}
+
+	// The machine stays provisioning while the gating pod is not running
+	verify(false, corev1.ConditionFalse)
+	if controller.workqueue.Len() != 0 {
+		t.Errorf("expected the recheck to be delayed, got %d queued machines", controller.workqueue.Len())
+	}
+
+	pod.Status.Phase = corev1.PodRunning
+	if _, err := controller.kubeClient.CoreV1().Pods(pod.Namespace).Update(pod); err != nil {
+		t.Fatal(err)
+	}
+	verify(true, corev1.ConditionTrue)
+}
+
+func TestControllerPassesMachinesWithoutReadinessGate(t *testing.T) {
+	controller := Controller{}
+	passed, err := controller.readinessGatePassed(&clusterv1alpha1.Machine{}, &corev1.Node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passed {
+		t.Error("expected a machine without readiness gate to pass")
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+)
+
+// RegionCircuitBreaker stops the creation of instances in a region of a cloud provider which keeps failing, e.g.
+// during a partial outage of the provider. Creations in other regions and the deletion of instances continue.
+type RegionCircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lock    sync.Mutex
+	regions map[string]*regionErrors
+}
+
+// regionErrors tracks the consecutive failed calls to the cloud provider in a region
+type regionErrors struct {
+	failures  int
+	openUntil time.Time
+}
+
+// NewRegionCircuitBreaker returns a RegionCircuitBreaker which opens for a region after threshold consecutive failed
+// calls to its cloud provider. nil is returned if the threshold is not set
+func NewRegionCircuitBreaker(threshold int, cooldown time.Duration) *RegionCircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &RegionCircuitBreaker{threshold: threshold, cooldown: cooldown, regions: map[string]*regionErrors{}}
+}
+
+// record updates the state of the region with the result of a call to its cloud provider at the given time.
+// Terminal errors are caused by the machine, not by the region, so they are ignored
+func (b *RegionCircuitBreaker) record(region string, err error, now time.Time) {
+	if b == nil || region == "" {
+		return
+	}
+	if ok, _, _ := cloudprovidererrors.IsTerminalError(err); ok {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, exists := b.regions[region]
+	if err == nil {
+		if exists && state.failures >= b.threshold {
+			glog.Infof("Region %s recovered, resuming the creation of instances in it", region)
+		}
+		delete(b.regions, region)
+		return
+	}
+
+	if !exists {
+		state = &regionErrors{}
+		b.regions[region] = state
+	}
+	state.failures++
+	if state.failures >= b.threshold {
+		if state.failures == b.threshold {
+			glog.Errorf("%d consecutive calls to the cloud provider in region %s failed, pausing the creation of instances in it: %v", state.failures, region, err)
+		}
+		state.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// allowCreate returns false while the creation of instances in the region is paused. Once the cooldown is over a
+// single creation is let through to probe the region, further ones wait for its result for another cooldown
+func (b *RegionCircuitBreaker) allowCreate(region string, now time.Time) bool {
+	if b == nil || region == "" {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, exists := b.regions[region]
+	if !exists || state.failures < b.threshold {
+		return true
+	}
+	if now.Before(state.openUntil) {
+		return false
+	}
+	state.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// machineRegion returns the cloud provider and region or zone of the machine, e.g. aws/eu-central-1. It is empty if
+// the breaker is not configured or the provider does not expose the region of the machine
+func (c *Controller) machineRegion(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, providerConfig *providerconfig.Config) string {
+	if c.regionCircuitBreaker == nil {
+		return ""
+	}
+	labels, err := prov.MachineMetricsLabels(machine)
+	if err != nil {
+		return ""
+	}
+	region := labels["region"]
+	if region == "" {
+		region = labels["zone"]
+	}
+	if region == "" {
+		return ""
+	}
+	return string(providerConfig.CloudProvider) + "/" + region
+}
+
+// checkRegionCircuitBreaker returns false if no instance may be created in the region right now. The machine gets
+// re-enqueued then.
+func (c *Controller) checkRegionCircuitBreaker(machine *clusterv1alpha1.Machine, region string) bool {
+	if c.regionCircuitBreaker.allowCreate(region, time.Now()) {
+		return true
+	}
+	glog.V(3).Infof("Delaying the creation of machine %s, the calls to the cloud provider in region %s keep failing", machine.Name, region)
+	c.recorder.Eventf(machine, corev1.EventTypeWarning, "RegionUnavailable", "Delaying the instance creation, the calls to the cloud provider in region %s keep failing", region)
+	c.enqueueMachineAfter(machine, c.regionCircuitBreaker.cooldown)
+	return false
+}
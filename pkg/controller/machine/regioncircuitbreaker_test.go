@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+)
+
+var errRegionUnavailable = errors.New("RequestLimitExceeded: the service is unavailable")
+
+func TestRegionCircuitBreaker(t *testing.T) {
+	breaker := NewRegionCircuitBreaker(3, 5*time.Minute)
+	now := time.Now()
+
+	breaker.record("aws/eu-central-1", errRegionUnavailable, now)
+	breaker.record("aws/eu-central-1", errRegionUnavailable, now)
+	breaker.record("aws/eu-west-1", nil, now)
+	if !breaker.allowCreate("aws/eu-central-1", now) {
+		t.Fatal("expected the circuit breaker to stay closed below the threshold")
+	}
+	breaker.record("aws/eu-central-1", errRegionUnavailable, now)
+	if breaker.allowCreate("aws/eu-central-1", now.Add(time.Minute)) {
+		t.Fatal("expected the circuit breaker to open after sustained errors in the region")
+	}
+	if !breaker.allowCreate("aws/eu-west-1", now.Add(time.Minute)) {
+		t.Fatal("expected the creation of instances in other regions to continue")
+	}
+	if !breaker.allowCreate("", now.Add(time.Minute)) {
+		t.Fatal("expected the creation of instances without a known region to continue")
+	}
+
+	// After the cooldown a single creation probes the region
+	probeTime := now.Add(5 * time.Minute)
+	if !breaker.allowCreate("aws/eu-central-1", probeTime) {
+		t.Fatal("expected a creation to be let through after the cooldown")
+	}
+	if breaker.allowCreate("aws/eu-central-1", probeTime) {
+		t.Fatal("expected further creations to wait for the result of the probe")
+	}
+	breaker.record("aws/eu-central-1", errRegionUnavailable, probeTime)
+	if breaker.allowCreate("aws/eu-central-1", probeTime.Add(time.Minute)) {
+		t.Fatal("expected the circuit breaker to open again after a failed probe")
+	}
+
+	breaker.record("aws/eu-central-1", nil, probeTime.Add(2*time.Minute))
+	if !breaker.allowCreate("aws/eu-central-1", probeTime.Add(2*time.Minute)) {
+		t.Fatal("expected the circuit breaker to close once the region recovered")
+	}
+
+	// Terminal errors are caused by the machine, not by the region
+	terminalErr := cloudprovidererrors.TerminalError{Reason: common.InvalidConfigurationMachineError, Message: "invalid instance type"}
+	for i := 0; i < 3; i++ {
+		breaker.record("aws/eu-central-1", terminalErr, now)
+	}
+	if !breaker.allowCreate("aws/eu-central-1", now) {
+		t.Fatal("expected terminal errors to not open the circuit breaker")
+	}
+
+	var nilBreaker *RegionCircuitBreaker
+	nilBreaker.record("aws/eu-central-1", errRegionUnavailable, now)
+	if !nilBreaker.allowCreate("aws/eu-central-1", now) {
+		t.Fatal("expected an unconfigured circuit breaker to never open")
+	}
+}
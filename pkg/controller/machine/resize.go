@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// AnnotationResizing is set on machines whose instance gets resized in place. Its value is the
+	// requested instance type
+	AnnotationResizing = "machine-controller.kubermatic.io/resizing"
+	// AnnotationInstanceType is set on machines whose instance got checked for an in-place resize. Its value is
+	// the instance type the spec requested then, the cloud provider only gets asked again once the spec requests
+	// another one
+	AnnotationInstanceType = "machine-controller.kubermatic.io/instance-type"
+
+	resizeRecheckPeriod = 10 * time.Second
+)
+
+// ensureInstanceResized resizes the instance of the machine in place if the instance type in its spec
+// changed and the cloud provider supports it. The node gets cordoned and drained before and uncordoned
+// after the resize. It returns true if the machine is being resized or got updated, the sync must stop then.
+func (c *Controller) ensureInstanceResized(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	if !c.inPlaceResize {
+		return false, nil
+	}
+	resizer, ok := prov.(cloudprovidertypes.InstanceResizer)
+	if !ok {
+		return false, nil
+	}
+
+	if _, resizing := machine.Annotations[AnnotationResizing]; !resizing {
+		var requested string
+		if typer, ok := prov.(cloudprovidertypes.InstanceTyper); ok {
+			var err error
+			if requested, err = typer.InstanceType(machine.Spec); err != nil {
+				return false, fmt.Errorf("failed to get instance type of machine %s: %v", machine.Name, err)
+			}
+			if requested != "" && requested == machine.Annotations[AnnotationInstanceType] {
+				return false, nil
+			}
+		}
+
+		current, desired, err := resizer.InstanceTypes(machine)
+		if err != nil {
+			if err == cloudprovidererrors.ErrInstanceNotFound {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get instance type of machine %s: %v", machine.Name, err)
+		}
+		if current == desired {
+			if requested == "" {
+				return false, nil
+			}
+			if _, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+				if m.Annotations == nil {
+					m.Annotations = map[string]string{}
+				}
+				m.Annotations[AnnotationInstanceType] = requested
+			}); err != nil {
+				return false, fmt.Errorf("failed to record the instance type of machine %s: %v", machine.Name, err)
+			}
+			return false, nil
+		}
+
+		glog.V(2).Infof("Resizing the instance of machine %s from %s to %s", machine.Name, current, desired)
+		c.recorder.Eventf(machine, corev1.EventTypeNormal, "Resizing", "Resizing instance from %s to %s", current, desired)
+		if machine, err = c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+			if m.Annotations == nil {
+				m.Annotations = map[string]string{}
+			}
+			m.Annotations[AnnotationResizing] = desired
+		}); err != nil {
+			return false, fmt.Errorf("failed to mark machine %s as resizing: %v", machine.Name, err)
+		}
+	}
+
+	if open, err := c.waitForDrainMaintenanceWindow(machine, time.Now()); err != nil || !open {
+		return true, err
+	}
+	if acquired, err := c.acquireDrainSlot(machine); err != nil || !acquired {
+		return true, err
+	}
+	if err := eviction.New(node.Name, c.nodesLister, c.kubeClient, c.drainNamespacePriorities, c.drainExcludePodSelector, c.drainProgressReporter(machine)).Run(); err != nil {
+		return true, fmt.Errorf("failed to evict node %s: %v", node.Name, err)
+	}
+
+	resized, err := resizer.Resize(machine)
+	if err != nil {
+		return true, fmt.Errorf("failed to resize instance of machine %s: %v", machine.Name, err)
+	}
+	if !resized {
+		// The cloud provider does not trigger a sync once the instance changed its state
+		c.enqueueMachineAfter(machine, resizeRecheckPeriod)
+		return true, nil
+	}
+
+	if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+		n.Spec.Unschedulable = false
+	}); err != nil {
+		return true, fmt.Errorf("failed to uncordon node %s: %v", node.Name, err)
+	}
+	instanceType := machine.Annotations[AnnotationResizing]
+	if _, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		delete(m.Annotations, AnnotationResizing)
+		m.Annotations[AnnotationInstanceType] = instanceType
+	}); err != nil {
+		return true, fmt.Errorf("failed to remove the %s annotation from machine %s: %v", AnnotationResizing, machine.Name, err)
+	}
+	c.recorder.Eventf(machine, corev1.EventTypeNormal, "Resized", "Resized instance to %s", instanceType)
+	return true, nil
+}
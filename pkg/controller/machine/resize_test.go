@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	machinefake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+)
+
+// resizeTestProvider stops the instance on the first call of Resize and starts it with the requested
+// instance type on the second one
+type resizeTestProvider struct {
+	cloudprovidertypes.Provider
+	current            string
+	desired            string
+	instanceTypesCalls int
+	resizeCalls        int
+	cleanedUp          bool
+}
+
+func (p *resizeTestProvider) OnlyInstanceTypeChanged(_, _ clusterv1alpha1.MachineSpec) (bool, error) {
+	return true, nil
+}
+
+func (p *resizeTestProvider) InstanceType(_ clusterv1alpha1.MachineSpec) (string, error) {
+	return p.desired, nil
+}
+
+func (p *resizeTestProvider) InstanceTypes(_ *clusterv1alpha1.Machine) (string, string, error) {
+	p.instanceTypesCalls++
+	return p.current, p.desired, nil
+}
+
+func (p *resizeTestProvider) Resize(_ *clusterv1alpha1.Machine) (bool, error) {
+	p.resizeCalls++
+	if p.resizeCalls == 1 {
+		return false, nil
+	}
+	p.current = p.desired
+	return true, nil
+}
+
+func (p *resizeTestProvider) Cleanup(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData) (bool, error) {
+	p.cleanedUp = true
+	return true, nil
+}
+
+func TestControllerResizesInstanceInPlace(t *testing.T) {
+	tests := []struct {
+		name          string
+		inPlaceResize bool
+		current       string
+		resizes       bool
+	}{
+		{
+			name:          "changed instance type gets resized in place",
+			inPlaceResize: true,
+			current:       "t3.medium",
+			resizes:       true,
+		},
+		{
+			name:          "unchanged instance type is kept",
+			inPlaceResize: true,
+			current:       "t3.large",
+			resizes:       false,
+		},
+		{
+			name:          "changed instance type is kept without the policy",
+			inPlaceResize: false,
+			current:       "t3.medium",
+			resizes:       false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "kube-system"},
+				Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: node.Name}},
+			}
+
+			// The eviction waits until the lister shows the node as cordoned
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, node)
+			controller.inPlaceResize = test.inPlaceResize
+			defer controller.workqueue.ShutDown()
+			prov := &resizeTestProvider{current: test.current, desired: "t3.large"}
+
+			// Every sync takes one step of the resize and stops afterwards
+			for i := 0; i < 3; i++ {
+				listerMachine, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resizing, err := controller.ensureInstanceResized(prov, listerMachine.DeepCopy(), node)
+				if err != nil {
+					t.Fatalf("failed to resize instance: %v", err)
+				}
+				if !resizing {
+					break
+				}
+				if prov.current == prov.desired {
+					continue
+				}
+				if current, err := controller.kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{}); err != nil || !current.Spec.Unschedulable {
+					t.Fatalf("expected node %s to be cordoned during the resize, got %v", node.Name, err)
+				}
+			}
+
+			if resized := prov.resizeCalls > 0; resized != test.resizes {
+				t.Errorf("expected the instance to be resized: %v, got %v", test.resizes, resized)
+			}
+			if test.resizes && prov.current != prov.desired {
+				t.Errorf("expected the instance to run with instance type %s, got %s", prov.desired, prov.current)
+			}
+			if prov.cleanedUp {
+				t.Error("expected the instance to be kept instead of re-created")
+			}
+			for _, action := range controller.machineClient.(*machinefake.Clientset).Actions() {
+				if action.GetVerb() == "delete" {
+					t.Errorf("expected the machine to be kept, got action %v", action)
+				}
+			}
+
+			updatedMachine, err := controller.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, annotated := updatedMachine.Annotations[AnnotationResizing]; annotated {
+				t.Errorf("expected the %s annotation to be removed after the resize", AnnotationResizing)
+			}
+			updatedNode, err := controller.kubeClient.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updatedNode.Spec.Unschedulable {
+				t.Errorf("expected node %s to be uncordoned after the resize", node.Name)
+			}
+			if !test.inPlaceResize {
+				return
+			}
+			if instanceType := updatedMachine.Annotations[AnnotationInstanceType]; instanceType != prov.desired {
+				t.Errorf("expected the instance type %s to be recorded, got %q", prov.desired, instanceType)
+			}
+
+			// The cloud provider is not asked again as long as the spec requests the recorded instance type
+			instanceTypesCalls := prov.instanceTypesCalls
+			if _, err := controller.ensureInstanceResized(prov, updatedMachine, node); err != nil {
+				t.Fatalf("failed to resize instance: %v", err)
+			}
+			if prov.instanceTypesCalls != instanceTypesCalls {
+				t.Errorf("expected no instance types lookup for the recorded instance type, got %d", prov.instanceTypesCalls-instanceTypesCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// ensureProviderSpecFieldsKnown warns about provider spec fields this version of the machine-controller ignores,
+// which usually means the spec got written for a newer version. The machine still gets reconciled without them.
+// The ProviderSpecFieldsKnown condition only gets added once an unknown field shows up, so machines with a
+// known spec don't get an additional status update.
+func (c *Controller) ensureProviderSpecFieldsKnown(machine *clusterv1alpha1.Machine) error {
+	unknown, err := cloudprovider.UnknownProviderSpecFields(machine.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to check provider spec for unknown fields: %v", err)
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status: %v", err)
+	}
+	existing := providerStatus.GetCondition(providerconfig.ProviderSpecFieldsKnownConditionType)
+	if len(unknown) == 0 && existing == nil {
+		return nil
+	}
+
+	condition := providerconfig.Condition{
+		Type:   providerconfig.ProviderSpecFieldsKnownConditionType,
+		Status: corev1.ConditionTrue,
+		Reason: "AllFieldsKnown",
+	}
+	if len(unknown) > 0 {
+		fields := strings.Join(unknown, ", ")
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "UnknownFields"
+		condition.Message = fmt.Sprintf("The provider spec contains fields which get ignored: %s", fields)
+		if existing == nil || existing.Status != corev1.ConditionFalse || existing.Message != condition.Message {
+			glog.Warningf("Provider spec of machine %s/%s contains unknown fields which get ignored: %s", machine.Namespace, machine.Name, fields)
+			c.recorder.Eventf(machine, corev1.EventTypeWarning, "UnknownProviderSpecFields", "Provider spec contains fields which get ignored: %s", fields)
+		}
+	}
+	return c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestControllerWarnsAboutUnknownProviderSpecFields(t *testing.T) {
+	knownMachine := fakeProviderMachine("machine-known", false)
+	unknownMachine := fakeProviderMachine("machine-unknown", false)
+	unknownMachine.Spec.ProviderSpec.Value.Raw = []byte(`{"cloudProvider": "fake", "cloudProviderSpec": {"passValidation": true, "spotPrice": "0.1"}, "bootMode": "uefi"}`)
+
+	recorder := record.NewFakeRecorder(10)
+	controller := newTestController(t, []*clusterv1alpha1.Machine{knownMachine, unknownMachine})
+	controller.recorder = recorder
+
+	getCondition := func(name string) *providerconfig.Condition {
+		machine, err := controller.machineClient.ClusterV1alpha1().Machines("kube-system").Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return providerStatus.GetCondition(providerconfig.ProviderSpecFieldsKnownConditionType)
+	}
+
+	if err := controller.ensureProviderSpecFieldsKnown(knownMachine); err != nil {
+		t.Fatal(err)
+	}
+	if condition := getCondition(knownMachine.Name); condition != nil {
+		t.Errorf("expected no condition for a machine without unknown fields, got %v", condition)
+	}
+
+	if err := controller.ensureProviderSpecFieldsKnown(unknownMachine); err != nil {
+		t.Fatal(err)
+	}
+	condition := getCondition(unknownMachine.Name)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != "UnknownFields" {
+		t.Fatalf("expected a false UnknownFields condition, got %v", condition)
+	}
+	if !strings.Contains(condition.Message, "bootMode, cloudProviderSpec.spotPrice") {
+		t.Errorf("expected the condition to name the unknown fields, got %q", condition.Message)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "UnknownProviderSpecFields") {
+			t.Errorf("expected an UnknownProviderSpecFields event, got %q", event)
+		}
+	default:
+		t.Error("expected an event for the unknown fields")
+	}
+
+	// Once the spec got fixed the condition becomes true
+	fixedMachine, err := controller.machineClient.ClusterV1alpha1().Machines("kube-system").Get(unknownMachine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixedMachine.Spec.ProviderSpec = knownMachine.Spec.ProviderSpec
+	if err := controller.ensureProviderSpecFieldsKnown(fixedMachine); err != nil {
+		t.Fatal(err)
+	}
+	if condition := getCondition(unknownMachine.Name); condition == nil || condition.Status != corev1.ConditionTrue {
+		t.Errorf("expected a true condition after removing the unknown fields, got %v", condition)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no further event, got %q", <-recorder.Events)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// StartupTaints are taints which external controllers put onto new nodes and remove once they
+// initialized them, e.g. a GPU device plugin. Nodes carrying them are considered healthy until
+// the grace period is over.
+type StartupTaints struct {
+	keys        []string
+	gracePeriod time.Duration
+}
+
+// NewStartupTaints returns the StartupTaints for the given comma separated list of taint keys.
+// nil is returned if the list is empty
+func NewStartupTaints(keys string, gracePeriod time.Duration) *StartupTaints {
+	t := &StartupTaints{gracePeriod: gracePeriod}
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			t.keys = append(t.keys, key)
+		}
+	}
+	if len(t.keys) == 0 {
+		return nil
+	}
+	return t
+}
+
+// check returns the key of the first startup taint the node still carries after the grace period.
+// If the node carries startup taints but the grace period is not over yet, the remaining time is returned.
+func (t *StartupTaints) check(node *corev1.Node) (string, time.Duration) {
+	var remaining time.Duration
+	for _, taint := range node.Spec.Taints {
+		for _, key := range t.keys {
+			if taint.Key != key {
+				continue
+			}
+			left := t.gracePeriod - time.Since(node.CreationTimestamp.Time)
+			if left <= 0 {
+				return key, 0
+			}
+			remaining = left
+		}
+	}
+	return "", remaining
+}
+
+// checkStartupTaints tolerates the configured startup taints on the node of the machine during the
+// grace period. Afterwards the machine gets deleted so its MachineSet re-creates it.
+// It returns true if the machine got deleted.
+func (c *Controller) checkStartupTaints(machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	if c.startupTaints == nil {
+		return false, nil
+	}
+
+	key, remaining := c.startupTaints.check(node)
+	if key == "" {
+		if remaining > 0 {
+			// Nothing triggers a sync once the grace period is over
+			c.enqueueMachineAfter(machine, remaining)
+		}
+		return false, nil
+	}
+
+	message := fmt.Sprintf("Node %s still has the startup taint %s after %s", node.Name, key, c.startupTaints.gracePeriod)
+	if !ownerReferencesHasMachineSetKind(machine.OwnerReferences) {
+		glog.V(3).Infof("%s, not deleting machine %s as it is not owned by a MachineSet", message, machine.Name)
+		return false, nil
+	}
+
+	c.recorder.Event(machine, corev1.EventTypeWarning, "StartupTaintNotRemoved", message)
+	if err := c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
+		return false, fmt.Errorf("failed to delete machine %s/%s with startup taint %s: %v", machine.Namespace, machine.Name, key, err)
+	}
+	glog.V(3).Infof("%s, deleted machine %s", message, machine.Name)
+	return true, nil
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	topologySpreadRecheckPeriod = 30 * time.Second
+
+	topologySpreadSatisfiedReason  = "TopologySpreadSatisfied"
+	topologySpreadSatisfiedMessage = "The pods of the node can be rescheduled without violating their topology spread constraints"
+
+	whenUnsatisfiableDoNotSchedule = "DoNotSchedule"
+)
+
+// topologySpreadConstraint is the part of a pods topology spread constraint the check needs. The vendored pod API
+// predates the topologySpreadConstraints field, so the constraints get decoded from the raw pods
+type topologySpreadConstraint struct {
+	MaxSkew           int32                 `json:"maxSkew"`
+	TopologyKey       string                `json:"topologyKey"`
+	WhenUnsatisfiable string                `json:"whenUnsatisfiable"`
+	LabelSelector     *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// TopologySpreadCheck delays the drain of a node until the pods on it which have hard topology spread constraints
+// can be rescheduled onto the remaining nodes without violating them, e.g. until the replacement node in the same
+// zone joined during a rolling update.
+type TopologySpreadCheck struct {
+	// spreadConstraints returns the topology spread constraints of the pods on the given node
+	spreadConstraints func(nodeName string) (map[types.NamespacedName][]topologySpreadConstraint, error)
+}
+
+// NewTopologySpreadCheck returns the TopologySpreadCheck which reads the pods via the given client of the core API group
+func NewTopologySpreadCheck(client rest.Interface) *TopologySpreadCheck {
+	return &TopologySpreadCheck{spreadConstraints: func(nodeName string) (map[types.NamespacedName][]topologySpreadConstraint, error) {
+		raw, err := client.Get().
+			Resource("pods").
+			Param("fieldSelector", fields.OneTermEqualSelector("spec.nodeName", nodeName).String()).
+			DoRaw()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods of node %s: %v", nodeName, err)
+		}
+		var podList struct {
+			Items []struct {
+				Metadata struct {
+					Namespace string `json:"namespace"`
+					Name      string `json:"name"`
+				} `json:"metadata"`
+				Spec struct {
+					TopologySpreadConstraints []topologySpreadConstraint `json:"topologySpreadConstraints"`
+				} `json:"spec"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(raw, &podList); err != nil {
+			return nil, fmt.Errorf("failed to decode pods of node %s: %v", nodeName, err)
+		}
+		constraints := map[types.NamespacedName][]topologySpreadConstraint{}
+		for _, pod := range podList.Items {
+			if len(pod.Spec.TopologySpreadConstraints) > 0 {
+				constraints[types.NamespacedName{Namespace: pod.Metadata.Namespace, Name: pod.Metadata.Name}] = pod.Spec.TopologySpreadConstraints
+			}
+		}
+		return constraints, nil
+	}}
+}
+
+// spreadNode is a node the evicted pods could get scheduled on, with the resources which are not requested yet
+type spreadNode struct {
+	node   *corev1.Node
+	cpu    *resource.Quantity
+	memory *resource.Quantity
+}
+
+// checkTopologySpread returns true if the pods on the node of the machine can be rescheduled onto the other nodes
+// without violating their hard topology spread constraints. Otherwise the machine gets requeued and its
+// TopologySpreadSatisfied condition is false.
+func (c *Controller) checkTopologySpread(machine *clusterv1alpha1.Machine) (bool, error) {
+	if c.topologySpreadCheck == nil {
+		return true, nil
+	}
+	nodeName := machine.Status.NodeRef.Name
+
+	constraints, err := c.topologySpreadCheck.spreadConstraints(nodeName)
+	if err != nil {
+		return false, err
+	}
+	for key, podConstraints := range constraints {
+		var hard []topologySpreadConstraint
+		for _, constraint := range podConstraints {
+			if constraint.WhenUnsatisfiable == whenUnsatisfiableDoNotSchedule {
+				hard = append(hard, constraint)
+			}
+		}
+		if len(hard) == 0 {
+			delete(constraints, key)
+			continue
+		}
+		constraints[key] = hard
+	}
+	if len(constraints) == 0 {
+		return true, c.openDrainGate(machine, providerconfig.TopologySpreadSatisfiedConditionType, topologySpreadSatisfiedReason, topologySpreadSatisfiedMessage)
+	}
+
+	podList, err := c.kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods: %v", err)
+	}
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	// The pods which stay where they are, the evicted pods get added once a node was found for them
+	var remaining, evicted []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if pod.Spec.NodeName == nodeName {
+			if _, ok := constraints[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}]; ok && c.isEvicted(&pod) {
+				evicted = append(evicted, pod)
+				continue
+			}
+		}
+		remaining = append(remaining, pod)
+	}
+	sort.Slice(evicted, func(i, j int) bool {
+		return evicted[i].Namespace+"/"+evicted[i].Name < evicted[j].Namespace+"/"+evicted[j].Name
+	})
+
+	candidates := map[string]*spreadNode{}
+	for _, node := range nodes {
+		if node.Name == nodeName || node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		candidates[node.Name] = &spreadNode{
+			node:   node,
+			cpu:    node.Status.Allocatable.Cpu().Copy(),
+			memory: node.Status.Allocatable.Memory().Copy(),
+		}
+	}
+	for _, pod := range remaining {
+		if candidate, ok := candidates[pod.Spec.NodeName]; ok {
+			requests := podRequests(&pod)
+			candidate.cpu.Sub(*requests.Cpu())
+			candidate.memory.Sub(*requests.Memory())
+		}
+	}
+
+	for _, pod := range evicted {
+		podConstraints := constraints[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}]
+		target, err := findSpreadNode(&pod, podConstraints, nodes, candidates, remaining)
+		if err != nil {
+			return false, err
+		}
+		if target == nil {
+			message := fmt.Sprintf("Pod %s/%s can not be rescheduled without violating its topology spread constraints, delaying the drain of node %s", pod.Namespace, pod.Name, nodeName)
+			glog.V(4).Infof("%s of machine %s", message, machine.Name)
+			c.recorder.Event(machine, corev1.EventTypeNormal, "DrainDelayed", message)
+			c.enqueueMachineAfter(machine, topologySpreadRecheckPeriod)
+			return false, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+				s.SetCondition(providerconfig.Condition{
+					Type:    providerconfig.TopologySpreadSatisfiedConditionType,
+					Status:  corev1.ConditionFalse,
+					Reason:  "TopologySpreadViolated",
+					Message: message,
+				})
+			})
+		}
+
+		requests := podRequests(&pod)
+		target.cpu.Sub(*requests.Cpu())
+		target.memory.Sub(*requests.Memory())
+		pod.Spec.NodeName = target.node.Name
+		remaining = append(remaining, pod)
+	}
+	return true, c.openDrainGate(machine, providerconfig.TopologySpreadSatisfiedConditionType, topologySpreadSatisfiedReason, topologySpreadSatisfiedMessage)
+}
+
+// isEvicted returns true if the pod gets evicted when its node is drained
+func (c *Controller) isEvicted(pod *corev1.Pod) bool {
+	if controllerRef := metav1.GetControllerOf(pod); controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+		return false
+	}
+	if _, found := pod.Annotations[corev1.MirrorPodAnnotationKey]; found {
+		return false
+	}
+	return c.drainExcludePodSelector == nil || !c.drainExcludePodSelector.Matches(labels.Set(pod.Labels))
+}
+
+// findSpreadNode returns the candidate node the scheduler could place the pod on without violating its constraints
+// or nil if there is none. The nodes are tried in the order of their names.
+func findSpreadNode(pod *corev1.Pod, constraints []topologySpreadConstraint, nodes []*corev1.Node, candidates map[string]*spreadNode, pods []corev1.Pod) (*spreadNode, error) {
+	nodesByName := map[string]*corev1.Node{}
+	for _, node := range nodes {
+		nodesByName[node.Name] = node
+	}
+
+	// domainCounts holds the number of matching pods per topology domain of every constraint. All nodes which match
+	// the node selector of the pod form the domains, including the drained one
+	domainCounts := make([]map[string]int, len(constraints))
+	selectors := make([]labels.Selector, len(constraints))
+	for i, constraint := range constraints {
+		selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector of the topology spread constraint of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		selectors[i] = selector
+
+		domainCounts[i] = map[string]int{}
+		for _, node := range nodes {
+			if domain, ok := node.Labels[constraint.TopologyKey]; ok && matchesNodeSelector(pod, node) {
+				domainCounts[i][domain] = 0
+			}
+		}
+		for _, other := range pods {
+			node, ok := nodesByName[other.Spec.NodeName]
+			if !ok || other.Namespace != pod.Namespace || !selector.Matches(labels.Set(other.Labels)) {
+				continue
+			}
+			if domain, ok := node.Labels[constraint.TopologyKey]; ok {
+				if _, isDomain := domainCounts[i][domain]; isDomain {
+					domainCounts[i][domain]++
+				}
+			}
+		}
+	}
+
+	var names []string
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	requests := podRequests(pod)
+	for _, name := range names {
+		candidate := candidates[name]
+		if !matchesNodeSelector(pod, candidate.node) || !toleratesNodeTaints(pod, candidate.node) {
+			continue
+		}
+		if candidate.cpu.Cmp(*requests.Cpu()) < 0 || candidate.memory.Cmp(*requests.Memory()) < 0 {
+			continue
+		}
+		if satisfiesSpreadConstraints(pod, candidate.node, constraints, selectors, domainCounts) {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// satisfiesSpreadConstraints returns true if the skew of all constraints stays within their maxSkew when the pod gets
+// placed on the node
+func satisfiesSpreadConstraints(pod *corev1.Pod, node *corev1.Node, constraints []topologySpreadConstraint, selectors []labels.Selector, domainCounts []map[string]int) bool {
+	for i, constraint := range constraints {
+		domain, ok := node.Labels[constraint.TopologyKey]
+		if !ok {
+			return false
+		}
+		minCount := -1
+		for _, count := range domainCounts[i] {
+			if minCount == -1 || count < minCount {
+				minCount = count
+			}
+		}
+		selfMatch := 0
+		if selectors[i].Matches(labels.Set(pod.Labels)) {
+			selfMatch = 1
+		}
+		if domainCounts[i][domain]+selfMatch-minCount > int(constraint.MaxSkew) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesNodeSelector(pod *corev1.Pod, node *corev1.Node) bool {
+	return labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels))
+}
+
+func toleratesNodeTaints(pod *corev1.Pod, node *corev1.Node) bool {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podRequests returns the cpu and memory requested by the containers of the pod
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	cpu := resource.Quantity{}
+	memory := resource.Quantity{}
+	for _, container := range pod.Spec.Containers {
+		cpu.Add(*container.Resources.Requests.Cpu())
+		memory.Add(*container.Resources.Requests.Memory())
+	}
+	return corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory}
+}
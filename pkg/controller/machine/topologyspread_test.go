@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const zoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+func spreadTestNode(name, zone string, cpu string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zoneLabel: zone}},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("8Gi")},
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func spreadTestPod(name, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": "web"}},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name:      "web",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestControllerDelaysDrainWhichViolatesTopologySpread(t *testing.T) {
+	zoneSpread := []topologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       zoneLabel,
+		WhenUnsatisfiable: whenUnsatisfiableDoNotSchedule,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+	}}
+
+	tests := []struct {
+		name          string
+		nodes         []*corev1.Node
+		expectedDrain bool
+	}{
+		{
+			name: "only node of its zone",
+			nodes: []*corev1.Node{
+				spreadTestNode("node-a", "a", "4"),
+				spreadTestNode("node-b", "b", "4"),
+				spreadTestNode("node-c", "c", "4"),
+			},
+			expectedDrain: false,
+		},
+		{
+			name: "replacement node in the same zone",
+			nodes: []*corev1.Node{
+				spreadTestNode("node-a", "a", "4"),
+				spreadTestNode("node-a-replacement", "a", "4"),
+				spreadTestNode("node-b", "b", "4"),
+				spreadTestNode("node-c", "c", "4"),
+			},
+			expectedDrain: true,
+		},
+		{
+			name: "replacement node in the same zone without free capacity",
+			nodes: []*corev1.Node{
+				spreadTestNode("node-a", "a", "4"),
+				spreadTestNode("node-a-replacement", "a", "500m"),
+				spreadTestNode("node-b", "b", "4"),
+				spreadTestNode("node-c", "c", "4"),
+			},
+			expectedDrain: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{spreadTestPod("web-a", "node-a"), spreadTestPod("web-b", "node-b"), spreadTestPod("web-c", "node-c")}
+			for _, node := range test.nodes {
+				objects = append(objects, node)
+			}
+			recorder := record.NewFakeRecorder(10)
+
+			// The machine waits for longer than the eviction gets skipped after
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "machine-a", DeletionTimestamp: &metav1.Time{Time: time.Now().Add(-3 * time.Hour)}},
+				Status:     clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-a"}},
+			}
+
+			controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, objects...)
+			controller.recorder = recorder
+			controller.skipEvictionAfter = 2 * time.Hour
+			controller.topologySpreadCheck = &TopologySpreadCheck{
+				spreadConstraints: func(nodeName string) (map[types.NamespacedName][]topologySpreadConstraint, error) {
+					if nodeName != "node-a" {
+						t.Fatalf("expected the constraints of the pods on node-a to be requested, got %s", nodeName)
+					}
+					return map[types.NamespacedName][]topologySpreadConstraint{{Namespace: "default", Name: "web-a"}: zoneSpread}, nil
+				},
+			}
+
+			drain, err := controller.checkTopologySpread(machine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if drain != test.expectedDrain {
+				t.Fatalf("expected drain to be allowed %t, got %t", test.expectedDrain, drain)
+			}
+			if drain {
+				return
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, "DrainDelayed") || !strings.Contains(event, "default/web-a") {
+					t.Errorf("expected a DrainDelayed event naming pod default/web-a, got %q", event)
+				}
+			default:
+				t.Error("expected an event for the delayed drain")
+			}
+
+			machine, err = controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			condition := getCondition(t, machine, providerconfig.TopologySpreadSatisfiedConditionType)
+			if condition == nil || condition.Status != corev1.ConditionFalse {
+				t.Errorf("expected the condition %s to be false, got %+v", providerconfig.TopologySpreadSatisfiedConditionType, condition)
+			}
+			if shouldEvict, err := controller.shouldEvict(machine); err != nil || !shouldEvict {
+				t.Errorf("expected the time waiting for the topology spread constraints to not count towards skipping the eviction, got %v, err %v", shouldEvict, err)
+			}
+		})
+	}
+}
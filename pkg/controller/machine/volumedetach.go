@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	volumeDetachRecheckPeriod = 5 * time.Second
+
+	volumesDetachingReason     = "Detaching"
+	volumesDetachTimeoutReason = "DetachTimeout"
+)
+
+// volumeAttachmentsForNode returns the names of the VolumeAttachments of the given node
+func (c *Controller) volumeAttachmentsForNode(nodeName string) ([]string, error) {
+	attachmentList, err := c.kubeClient.StorageV1beta1().VolumeAttachments().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VolumeAttachments: %v", err)
+	}
+	var attachments []string
+	for _, attachment := range attachmentList.Items {
+		if attachment.Spec.NodeName == nodeName {
+			attachments = append(attachments, attachment.Name)
+		}
+	}
+	return attachments, nil
+}
+
+// waitForVolumeDetach delays the termination of the instance of the machine until all VolumeAttachments of its node
+// got removed, so CSI drivers can detach the volumes before the instance is gone. It returns true once they are
+// removed or the -volume-detach-timeout is over. The VolumesDetached condition of the machine reflects the progress,
+// its LastTransitionTime marks the start of the wait.
+func (c *Controller) waitForVolumeDetach(machine *clusterv1alpha1.Machine) (bool, error) {
+	if c.volumeDetachTimeout == 0 || machine.Status.NodeRef == nil {
+		return true, nil
+	}
+	if !sets.NewString(machine.Finalizers...).Has(c.finalizerDeleteInstance) {
+		return true, nil
+	}
+	nodeName := machine.Status.NodeRef.Name
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+	}
+	existing := providerStatus.GetCondition(providerconfig.VolumesDetachedConditionType)
+	if existing != nil && existing.Status == corev1.ConditionFalse && existing.Reason == volumesDetachTimeoutReason {
+		return true, nil
+	}
+
+	attachments, err := c.volumeAttachmentsForNode(nodeName)
+	if err != nil {
+		return false, err
+	}
+	if len(attachments) == 0 {
+		if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.SetCondition(providerconfig.Condition{
+				Type:    providerconfig.VolumesDetachedConditionType,
+				Status:  corev1.ConditionTrue,
+				Reason:  "Detached",
+				Message: fmt.Sprintf("All VolumeAttachments of node %s got removed", nodeName),
+			})
+		}); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	waitingSince := time.Now()
+	if existing != nil && existing.Status == corev1.ConditionFalse {
+		waitingSince = existing.LastTransitionTime.Time
+	}
+	condition := providerconfig.Condition{
+		Type:    providerconfig.VolumesDetachedConditionType,
+		Status:  corev1.ConditionFalse,
+		Reason:  volumesDetachingReason,
+		Message: fmt.Sprintf("Waiting for the VolumeAttachments %s of node %s to be removed", strings.Join(attachments, ", "), nodeName),
+	}
+	timedOut := time.Since(waitingSince) > c.volumeDetachTimeout
+	if timedOut {
+		condition.Reason = volumesDetachTimeoutReason
+		condition.Message = fmt.Sprintf("The VolumeAttachments %s of node %s did not get removed within %s", strings.Join(attachments, ", "), nodeName, c.volumeDetachTimeout)
+	}
+	if err := c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(condition)
+	}); err != nil {
+		return false, err
+	}
+
+	if timedOut {
+		c.recorder.Eventf(machine, corev1.EventTypeWarning, "VolumeDetachTimeout", "%s, terminating the instance anyway", condition.Message)
+		return true, nil
+	}
+	glog.V(4).Infof("Waiting for the VolumeAttachments %s of node %s to be removed before terminating the instance of machine %s", strings.Join(attachments, ", "), nodeName, machine.Name)
+	c.enqueueMachineAfter(machine, volumeDetachRecheckPeriod)
+	return false, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func newVolumeDetachTestController(t *testing.T, machine *clusterv1alpha1.Machine, objects ...runtime.Object) *Controller {
+	controller := newTestController(t, []*clusterv1alpha1.Machine{machine}, objects...)
+	controller.recorder = record.NewFakeRecorder(10)
+	controller.volumeDetachTimeout = 10 * time.Minute
+	return controller
+}
+
+func volumeAttachment(name, nodeName string) *storagev1beta1.VolumeAttachment {
+	return &storagev1beta1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       storagev1beta1.VolumeAttachmentSpec{NodeName: nodeName},
+	}
+}
+
+func getVolumesDetachedCondition(t *testing.T, machine *clusterv1alpha1.Machine) *providerconfig.Condition {
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		t.Fatalf("failed to get provider status: %v", err)
+	}
+	return providerStatus.GetCondition(providerconfig.VolumesDetachedConditionType)
+}
+
+func TestDeletionWaitsForVolumeDetach(t *testing.T) {
+	machine := deletingMachine("machine-with-volumes", false)
+	machine.Status.NodeRef = &corev1.ObjectReference{Name: "node-1"}
+	controller := newVolumeDetachTestController(t, machine,
+		volumeAttachment("attachment-1", "node-1"), volumeAttachment("attachment-other-node", "node-2"))
+	defer controller.workqueue.ShutDown()
+	prov := fakecloudprovider.New(nil)
+
+	getMachine := func() *clusterv1alpha1.Machine {
+		m, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// The instance must not get terminated while the node has a VolumeAttachment
+	for i := 0; i < 2; i++ {
+		if err := controller.deleteMachine(prov, getMachine()); err != nil {
+			t.Fatalf("failed to delete machine: %v", err)
+		}
+	}
+	if !sets.NewString(getMachine().Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Fatal("expected the instance to not be terminated while its volumes are attached")
+	}
+	condition := getVolumesDetachedCondition(t, getMachine())
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != volumesDetachingReason {
+		t.Errorf("expected the condition %s to be false with reason %s, got %+v", providerconfig.VolumesDetachedConditionType, volumesDetachingReason, condition)
+	}
+	if controller.workqueue.Len() != 0 {
+		t.Errorf("expected the recheck to be delayed, got %d queued machines", controller.workqueue.Len())
+	}
+
+	// Once the VolumeAttachment is gone the instance gets terminated
+	if err := controller.kubeClient.StorageV1beta1().VolumeAttachments().Delete("attachment-1", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.deleteMachine(prov, getMachine()); err != nil {
+		t.Fatalf("failed to delete machine: %v", err)
+	}
+	if sets.NewString(getMachine().Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Error("expected the instance to be terminated once its volumes are detached")
+	}
+	condition = getVolumesDetachedCondition(t, getMachine())
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		t.Errorf("expected the condition %s to be true, got %+v", providerconfig.VolumesDetachedConditionType, condition)
+	}
+}
+
+func TestDeletionTerminatesInstanceAfterVolumeDetachTimeout(t *testing.T) {
+	machine := deletingMachine("machine-with-stuck-volume", false)
+	machine.Status.NodeRef = &corev1.ObjectReference{Name: "node-1"}
+	providerStatus := &providerconfig.ProviderStatus{}
+	providerStatus.SetCondition(providerconfig.Condition{
+		Type:               providerconfig.VolumesDetachedConditionType,
+		Status:             corev1.ConditionFalse,
+		Reason:             volumesDetachingReason,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+	})
+	rawProviderStatus, err := providerStatus.RawExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.Status.ProviderStatus = rawProviderStatus
+	controller := newVolumeDetachTestController(t, machine, volumeAttachment("attachment-1", "node-1"))
+	defer controller.workqueue.ShutDown()
+
+	if err := controller.deleteMachine(fakecloudprovider.New(nil), machine); err != nil {
+		t.Fatalf("failed to delete machine: %v", err)
+	}
+	updated, err := controller.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sets.NewString(updated.Finalizers...).Has(FinalizerDeleteInstance) {
+		t.Error("expected the instance to be terminated once the volume detach timeout is over")
+	}
+	condition := getVolumesDetachedCondition(t, updated)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != volumesDetachTimeoutReason {
+		t.Errorf("expected the condition %s to be false with reason %s, got %+v", providerconfig.VolumesDetachedConditionType, volumesDetachTimeoutReason, condition)
+	}
+	select {
+	case event := <-controller.recorder.(*record.FakeRecorder).Events:
+		t.Logf("got event %q", event)
+	default:
+		t.Error("expected an event about the volume detach timeout")
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// WarmPoolLabelName is set on the machines which keep the stopped instances of the warm pool of a
+	// MachineDeployment. Its value is the name of the MachineDeployment
+	WarmPoolLabelName = "machine-controller.kubermatic.io/warm-pool"
+	// WarmPoolTaintKey keeps pods off the nodes of warm pool machines. It gets removed once a machine of the
+	// MachineDeployment took over the instance
+	WarmPoolTaintKey = "machine-controller.kubermatic.io/warm-pool"
+	// AnnotationWarmPoolClaimedBy is set on a warm pool machine to the UID of the machine which takes over its instance
+	AnnotationWarmPoolClaimedBy = "machine-controller.kubermatic.io/warm-pool-claimed-by"
+	// AnnotationWarmPoolInstance is set on a machine which took over the instance of the named warm pool machine
+	// until the instance got started
+	AnnotationWarmPoolInstance = "machine-controller.kubermatic.io/warm-pool-instance"
+
+	// annotationWarmPoolTemplateHash identifies the template of the MachineDeployment a warm pool machine got created
+	// from, so machines of an outdated template get replaced
+	annotationWarmPoolTemplateHash = "machine-controller.kubermatic.io/warm-pool-template-hash"
+
+	warmPoolResyncPeriod  = 30 * time.Second
+	warmPoolRequeuePeriod = 10 * time.Second
+)
+
+// reconcileWarmPools makes every MachineDeployment with a warm pool have as many unclaimed warm pool machines of
+// its current template as configured
+func (c *Controller) reconcileWarmPools() {
+	deployments, err := c.machineDeploymentLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list MachineDeployments: %v", err)
+		return
+	}
+	for _, deployment := range deployments {
+		if err := c.reconcileWarmPool(deployment); err != nil {
+			glog.Errorf("Failed to reconcile the warm pool of MachineDeployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+		}
+	}
+}
+
+func (c *Controller) reconcileWarmPool(deployment *clusterv1alpha1.MachineDeployment) error {
+	if deployment.DeletionTimestamp != nil {
+		return nil
+	}
+	providerConfig, err := providerconfig.GetConfig(deployment.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to get provider config: %v", err)
+	}
+	size := 0
+	if providerConfig.WarmPool != nil {
+		size = providerConfig.WarmPool.Size
+	}
+	templateHash, err := warmPoolTemplateHash(&deployment.Spec.Template.Spec)
+	if err != nil {
+		return err
+	}
+
+	machines, err := c.machinesLister.Machines(deployment.Namespace).List(labels.SelectorFromSet(labels.Set{WarmPoolLabelName: deployment.Name}))
+	if err != nil {
+		return fmt.Errorf("failed to list warm pool machines: %v", err)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].Name < machines[j].Name
+	})
+
+	var available []*clusterv1alpha1.Machine
+	for _, machine := range machines {
+		// Claimed machines get deleted by the machine which took over their instance
+		if machine.DeletionTimestamp != nil || machine.Annotations[AnnotationWarmPoolClaimedBy] != "" {
+			continue
+		}
+		if machine.Annotations[annotationWarmPoolTemplateHash] != templateHash {
+			glog.V(3).Infof("Replacing warm pool machine %s/%s of an outdated template", machine.Namespace, machine.Name)
+			if err := c.deleteWarmPoolMachine(machine); err != nil {
+				return err
+			}
+			continue
+		}
+		available = append(available, machine)
+	}
+
+	for len(available) > size {
+		if err := c.deleteWarmPoolMachine(available[len(available)-1]); err != nil {
+			return err
+		}
+		available = available[:len(available)-1]
+	}
+	for i := len(available); i < size; i++ {
+		if err := c.createWarmPoolMachine(deployment, templateHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmPoolTemplateHash returns a hash of the parts of the template which end up on the instance
+func warmPoolTemplateHash(spec *clusterv1alpha1.MachineSpec) (string, error) {
+	raw, err := json.Marshal([]interface{}{spec.ProviderSpec, spec.Versions})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal template: %v", err)
+	}
+	hasher := fnv.New32a()
+	if _, err := hasher.Write(raw); err != nil {
+		return "", fmt.Errorf("failed to hash template: %v", err)
+	}
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32())), nil
+}
+
+// createWarmPoolMachine creates a machine from the template of the MachineDeployment whose instance gets stopped
+// once its node joined. It is owned by the MachineDeployment itself, so no MachineSet adopts it
+func (c *Controller) createWarmPoolMachine(deployment *clusterv1alpha1.MachineDeployment, templateHash string) error {
+	template := deployment.Spec.Template.DeepCopy()
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    deployment.Name + "-warm-",
+			Namespace:       deployment.Namespace,
+			Labels:          template.Labels,
+			Annotations:     template.Annotations,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, clusterv1alpha1.SchemeGroupVersion.WithKind("MachineDeployment"))},
+		},
+		Spec: template.Spec,
+	}
+	if machine.Labels == nil {
+		machine.Labels = map[string]string{}
+	}
+	machine.Labels[WarmPoolLabelName] = deployment.Name
+	if machine.Annotations == nil {
+		machine.Annotations = map[string]string{}
+	}
+	machine.Annotations[annotationWarmPoolTemplateHash] = templateHash
+	machine.Spec.Taints = append(machine.Spec.Taints, corev1.Taint{Key: WarmPoolTaintKey, Effect: corev1.TaintEffectNoSchedule})
+
+	machine, err := c.machineClient.ClusterV1alpha1().Machines(deployment.Namespace).Create(machine)
+	if err != nil {
+		return fmt.Errorf("failed to create warm pool machine: %v", err)
+	}
+	glog.V(3).Infof("Created warm pool machine %s/%s for MachineDeployment %s", machine.Namespace, machine.Name, deployment.Name)
+	return nil
+}
+
+func (c *Controller) deleteWarmPoolMachine(machine *clusterv1alpha1.Machine) error {
+	if err := c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete warm pool machine %s: %v", machine.Name, err)
+	}
+	return nil
+}
+
+func hasWarmPoolTaint(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == WarmPoolTaintKey {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureWarmPoolInstanceStopped stops the instance of a warm pool machine once its node joined and got tainted.
+// It returns true if the machine is handled, its stopped node must not be treated like a failed one
+func (c *Controller) ensureWarmPoolInstanceStopped(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, node *corev1.Node) (bool, error) {
+	if machine.Labels[WarmPoolLabelName] == "" {
+		return false, nil
+	}
+	if machine.Annotations[AnnotationWarmPoolClaimedBy] != "" {
+		return true, nil
+	}
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to get provider status of machine %s: %v", machine.Name, err)
+	}
+	condition := providerStatus.GetCondition(providerconfig.WarmPoolInstanceStoppedConditionType)
+	if condition != nil && condition.Status == corev1.ConditionTrue {
+		return true, nil
+	}
+	if condition == nil && (!c.nodeIsReady(node) || !hasWarmPoolTaint(node)) {
+		// The taint only gets applied once the node is ready, which does not trigger another sync
+		c.enqueueMachineAfter(machine, warmPoolRequeuePeriod)
+		return false, nil
+	}
+
+	starter, ok := prov.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("cloud provider %T can not stop instances", prov)
+	}
+	stopped, err := starter.StopInstance(machine)
+	if err != nil {
+		return true, fmt.Errorf("failed to stop instance of warm pool machine %s: %v", machine.Name, err)
+	}
+	if !stopped {
+		c.enqueueMachineAfter(machine, warmPoolRequeuePeriod)
+		return true, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+			s.SetCondition(providerconfig.Condition{
+				Type:    providerconfig.WarmPoolInstanceStoppedConditionType,
+				Status:  corev1.ConditionFalse,
+				Reason:  "Stopping",
+				Message: "Waiting for the instance to stop",
+			})
+		})
+	}
+	c.recorder.Event(machine, corev1.EventTypeNormal, "WarmPoolInstanceStopped", "Stopped instance, it can be taken over by new machines")
+	return true, c.updateProviderStatus(machine, func(s *providerconfig.ProviderStatus) {
+		s.SetCondition(providerconfig.Condition{
+			Type:    providerconfig.WarmPoolInstanceStoppedConditionType,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Stopped",
+			Message: "The instance is stopped",
+		})
+	})
+}
+
+// claimWarmPoolInstance takes over the stopped instance of a warm pool machine of the same MachineDeployment and
+// template instead of creating a new one. It returns true if the machine got an instance
+func (c *Controller) claimWarmPoolInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, providerConfig *providerconfig.Config) (bool, error) {
+	if providerConfig.WarmPool == nil || machine.Labels[WarmPoolLabelName] != "" {
+		return false, nil
+	}
+	if _, ok := prov.(cloudprovidertypes.InstanceStarter); !ok {
+		return false, nil
+	}
+	deploymentName, ok := kuberneteshelper.MachineDeploymentName(machine)
+	if !ok {
+		return false, nil
+	}
+	// The spec of the machine got resolved during the sync, the one of the warm pool machines did not
+	listerMachine, err := c.machinesLister.Machines(machine.Namespace).Get(machine.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to get machine %s from lister: %v", machine.Name, err)
+	}
+	warmMachine, err := c.findWarmPoolMachine(listerMachine, deploymentName)
+	if err != nil || warmMachine == nil {
+		return false, err
+	}
+
+	// A conflicting update means another machine claimed it first
+	if warmMachine.Annotations[AnnotationWarmPoolClaimedBy] == "" {
+		warmMachine = warmMachine.DeepCopy()
+		warmMachine.Annotations[AnnotationWarmPoolClaimedBy] = string(machine.UID)
+		// The instance and the node get taken over, so they must be kept when the warm pool machine gets deleted
+		warmMachine.Annotations[AnnotationSkipNodeDeletion] = "true"
+		if warmMachine, err = c.machineClient.ClusterV1alpha1().Machines(warmMachine.Namespace).Update(warmMachine); err != nil {
+			return false, fmt.Errorf("failed to claim warm pool machine %s: %v", warmMachine.Name, err)
+		}
+	}
+	if _, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[AnnotationWarmPoolInstance] = warmMachine.Name
+	}); err != nil {
+		return false, fmt.Errorf("failed to update machine after claiming warm pool machine %s: %v", warmMachine.Name, err)
+	}
+	if err := prov.MigrateUID(warmMachine, machine.UID); err != nil {
+		return false, fmt.Errorf("failed to take over the instance of warm pool machine %s: %v", warmMachine.Name, err)
+	}
+
+	glog.V(3).Infof("Machine %s took over the instance of warm pool machine %s", machine.Name, warmMachine.Name)
+	c.recorder.Eventf(machine, corev1.EventTypeNormal, "WarmPoolInstanceClaimed", "Took over the stopped instance of warm pool machine %s", warmMachine.Name)
+	c.enqueueMachineAfter(machine, 0)
+	return true, nil
+}
+
+// findWarmPoolMachine returns a warm pool machine of the MachineDeployment with a stopped instance and the same spec
+// as the given machine. A warm pool machine the machine already claimed is preferred
+func (c *Controller) findWarmPoolMachine(machine *clusterv1alpha1.Machine, deploymentName string) (*clusterv1alpha1.Machine, error) {
+	warmMachines, err := c.machinesLister.Machines(machine.Namespace).List(labels.SelectorFromSet(labels.Set{WarmPoolLabelName: deploymentName}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list warm pool machines: %v", err)
+	}
+	sort.Slice(warmMachines, func(i, j int) bool {
+		return warmMachines[i].Name < warmMachines[j].Name
+	})
+
+	var found *clusterv1alpha1.Machine
+	for _, warmMachine := range warmMachines {
+		claimedBy := warmMachine.Annotations[AnnotationWarmPoolClaimedBy]
+		if claimedBy == string(machine.UID) {
+			return warmMachine, nil
+		}
+		if found != nil || claimedBy != "" || warmMachine.DeletionTimestamp != nil {
+			continue
+		}
+		if !equality.Semantic.DeepEqual(warmMachine.Spec.ProviderSpec, machine.Spec.ProviderSpec) || warmMachine.Spec.Versions != machine.Spec.Versions {
+			continue
+		}
+		providerStatus, err := providerconfig.GetProviderStatus(warmMachine.Status.ProviderStatus)
+		if err != nil {
+			continue
+		}
+		if condition := providerStatus.GetCondition(providerconfig.WarmPoolInstanceStoppedConditionType); condition != nil && condition.Status == corev1.ConditionTrue {
+			found = warmMachine
+		}
+	}
+	return found, nil
+}
+
+// ensureWarmPoolInstanceStarted starts the instance the machine took over from a warm pool machine and deletes the
+// warm pool machine afterwards. It returns true while the instance is starting
+func (c *Controller) ensureWarmPoolInstanceStarted(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine) (bool, error) {
+	warmMachineName := machine.Annotations[AnnotationWarmPoolInstance]
+	if warmMachineName == "" {
+		return false, nil
+	}
+	starter, ok := prov.(cloudprovidertypes.InstanceStarter)
+	if !ok {
+		return false, fmt.Errorf("cloud provider %T can not start instances", prov)
+	}
+	started, err := starter.StartInstance(machine)
+	if err != nil {
+		return true, fmt.Errorf("failed to start the instance taken over from warm pool machine %s: %v", warmMachineName, err)
+	}
+	if !started {
+		c.enqueueMachineAfter(machine, warmPoolRequeuePeriod)
+		return true, nil
+	}
+
+	if err := c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Delete(warmMachineName, &metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return true, fmt.Errorf("failed to delete warm pool machine %s: %v", warmMachineName, err)
+	}
+	if _, err := c.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		delete(m.Annotations, AnnotationWarmPoolInstance)
+	}); err != nil {
+		return true, fmt.Errorf("failed to update machine after starting its instance: %v", err)
+	}
+	c.recorder.Event(machine, corev1.EventTypeNormal, "WarmPoolInstanceStarted", "Started the instance taken over from the warm pool")
+	return false, nil
+}
+
+// ensureWarmPoolTaintRemoved removes the taint of the warm pool from the node of a machine which took over its instance
+func (c *Controller) ensureWarmPoolTaintRemoved(machine *clusterv1alpha1.Machine, node *corev1.Node) error {
+	if machine.Labels[WarmPoolLabelName] != "" || !hasWarmPoolTaint(node) {
+		return nil
+	}
+	if _, err := c.updateNode(node.Name, func(n *corev1.Node) {
+		var taints []corev1.Taint
+		for _, taint := range n.Spec.Taints {
+			if taint.Key != WarmPoolTaintKey {
+				taints = append(taints, taint)
+			}
+		}
+		n.Spec.Taints = taints
+	}); err != nil {
+		return fmt.Errorf("failed to remove the warm pool taint from node %s: %v", node.Name, err)
+	}
+	glog.V(3).Infof("Removed the warm pool taint from node %s (machine %s)", node.Name, machine.Name)
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	clienttesting "k8s.io/client-go/testing"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	fakecloudprovider "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	machinefake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+)
+
+// warmPoolTestProvider keeps the instances by the UID of their machine, like the providers which tag them do
+type warmPoolTestProvider struct {
+	cloudprovidertypes.Provider
+	instances map[types.UID]bool
+	created   int
+	started   int
+}
+
+func (p *warmPoolTestProvider) Get(machine *clusterv1alpha1.Machine) (instance.Instance, error) {
+	if !p.instances[machine.UID] {
+		return nil, cloudprovidererrors.ErrInstanceNotFound
+	}
+	return fakecloudprovider.CloudProviderInstance{}, nil
+}
+
+func (p *warmPoolTestProvider) Create(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.MachineCreateDeleteData, _ string) (instance.Instance, error) {
+	p.created++
+	p.instances[machine.UID] = true
+	return fakecloudprovider.CloudProviderInstance{}, nil
+}
+
+func (p *warmPoolTestProvider) MigrateUID(machine *clusterv1alpha1.Machine, newUID types.UID) error {
+	if p.instances[machine.UID] {
+		delete(p.instances, machine.UID)
+		p.instances[newUID] = true
+	}
+	return nil
+}
+
+func (p *warmPoolTestProvider) StopInstance(_ *clusterv1alpha1.Machine) (bool, error) {
+	return true, nil
+}
+
+func (p *warmPoolTestProvider) StartInstance(_ *clusterv1alpha1.Machine) (bool, error) {
+	p.started++
+	return true, nil
+}
+
+func TestScaleUpClaimsWarmPoolInstance(t *testing.T) {
+	providerSpec := clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{"cloudProvider":"aws","warmPool":{"size":1}}`)}}
+	stoppedStatus, err := (&providerconfig.ProviderStatus{Conditions: []providerconfig.Condition{{
+		Type:   providerconfig.WarmPoolInstanceStoppedConditionType,
+		Status: corev1.ConditionTrue,
+	}}}).RawExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	warmMachine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "workers-warm-abcde",
+			Namespace:   "kube-system",
+			UID:         "warm-uid",
+			Labels:      map[string]string{WarmPoolLabelName: "workers"},
+			Annotations: map[string]string{},
+		},
+		Spec:   clusterv1alpha1.MachineSpec{ProviderSpec: providerSpec},
+		Status: clusterv1alpha1.MachineStatus{ProviderStatus: stoppedStatus},
+	}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "workers-1234-xyz",
+			Namespace:       "kube-system",
+			UID:             "new-uid",
+			Labels:          map[string]string{"machine-template-hash": "1234"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: "workers-" + rand.SafeEncodeString("1234")}},
+		},
+		Spec: clusterv1alpha1.MachineSpec{ProviderSpec: providerSpec},
+	}
+	providerConfig, err := providerconfig.GetConfig(providerSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{warmMachine, machine})
+	prov := &warmPoolTestProvider{instances: map[types.UID]bool{warmMachine.UID: true}}
+
+	if err := controller.ensureInstanceExistsForMachine(prov, machine, nil, providerConfig); err != nil {
+		t.Fatalf("failed to ensure instance: %v", err)
+	}
+	if prov.created != 0 {
+		t.Fatalf("expected the scale-up to take over the warm pool instance, but %d instances got created", prov.created)
+	}
+	if !prov.instances[machine.UID] || prov.instances[warmMachine.UID] {
+		t.Fatal("expected the instance of the warm pool machine to be migrated to the new machine")
+	}
+	warmMachine, err = controller.machinesLister.Machines("kube-system").Get(warmMachine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmMachine.Annotations[AnnotationWarmPoolClaimedBy] != string(machine.UID) || warmMachine.Annotations[AnnotationSkipNodeDeletion] != "true" {
+		t.Fatalf("expected the warm pool machine to be claimed and keep its instance on deletion, got annotations %v", warmMachine.Annotations)
+	}
+	machine, err = controller.machinesLister.Machines("kube-system").Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machine.Annotations[AnnotationWarmPoolInstance] != warmMachine.Name {
+		t.Fatalf("expected the machine to reference the claimed warm pool machine, got annotations %v", machine.Annotations)
+	}
+
+	// The next sync finds the instance and starts it
+	starting, err := controller.ensureWarmPoolInstanceStarted(prov, machine)
+	if err != nil {
+		t.Fatalf("failed to start instance: %v", err)
+	}
+	if starting || prov.started != 1 {
+		t.Fatalf("expected the instance to be started, starting=%v starts=%d", starting, prov.started)
+	}
+	if _, err := controller.machineClient.ClusterV1alpha1().Machines("kube-system").Get(warmMachine.Name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Fatalf("expected the claimed warm pool machine to be deleted, got %v", err)
+	}
+	machine, err = controller.machinesLister.Machines("kube-system").Get(machine.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := machine.Annotations[AnnotationWarmPoolInstance]; exists {
+		t.Fatal("expected the warm pool annotation to be removed once the instance got started")
+	}
+}
+
+func TestReconcileWarmPool(t *testing.T) {
+	deployment := &clusterv1alpha1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: "kube-system"},
+		Spec: clusterv1alpha1.MachineDeploymentSpec{Template: clusterv1alpha1.MachineTemplateSpec{
+			Spec: clusterv1alpha1.MachineSpec{ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{
+				Raw: []byte(`{"cloudProvider":"aws","warmPool":{"size":2}}`),
+			}}},
+		}},
+	}
+	templateHash, err := warmPoolTemplateHash(&deployment.Spec.Template.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warmMachine := func(name, hash string, annotations map[string]string) *clusterv1alpha1.Machine {
+		machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kube-system",
+			Labels:      map[string]string{WarmPoolLabelName: "workers"},
+			Annotations: map[string]string{annotationWarmPoolTemplateHash: hash},
+		}}
+		for key, value := range annotations {
+			machine.Annotations[key] = value
+		}
+		return machine
+	}
+
+	controller := newTestController(t, []*clusterv1alpha1.Machine{
+		warmMachine("workers-warm-current", templateHash, nil),
+		warmMachine("workers-warm-outdated", "outdated", nil),
+		warmMachine("workers-warm-claimed", templateHash, map[string]string{AnnotationWarmPoolClaimedBy: "new-uid"}),
+	})
+	// The fake clientset does not generate names, so the created machines are only recorded
+	machineClient := controller.machineClient.(*machinefake.Clientset)
+	machineClient.PrependReactor("create", "machines", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		machine := action.(clienttesting.CreateAction).GetObject().(*clusterv1alpha1.Machine).DeepCopy()
+		machine.Name = machine.GenerateName + rand.String(5)
+		return true, machine, nil
+	})
+	if err := controller.reconcileWarmPool(deployment); err != nil {
+		t.Fatalf("failed to reconcile warm pool: %v", err)
+	}
+
+	var created, deleted []string
+	for _, action := range machineClient.Actions() {
+		switch action := action.(type) {
+		case clienttesting.CreateAction:
+			machine := action.GetObject().(*clusterv1alpha1.Machine)
+			if machine.Labels[WarmPoolLabelName] != "workers" || machine.Annotations[annotationWarmPoolTemplateHash] != templateHash {
+				t.Errorf("expected the created machine to belong to the warm pool of the current template, got %v", machine.ObjectMeta)
+			}
+			if len(machine.Spec.Taints) != 1 || machine.Spec.Taints[0].Key != WarmPoolTaintKey {
+				t.Errorf("expected the created machine to have the warm pool taint, got %v", machine.Spec.Taints)
+			}
+			created = append(created, machine.GenerateName)
+		case clienttesting.DeleteAction:
+			deleted = append(deleted, action.GetName())
+		}
+	}
+	if len(created) != 1 || created[0] != "workers-warm-" {
+		t.Errorf("expected one warm pool machine to be created, got %v", created)
+	}
+	if len(deleted) != 1 || deleted[0] != "workers-warm-outdated" {
+		t.Errorf("expected only the warm pool machine of the outdated template to be deleted, got %v", deleted)
+	}
+}
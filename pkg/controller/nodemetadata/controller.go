@@ -0,0 +1,278 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodemetadata applies node label and annotation changes of MachineDeployments which opted into
+// in-place updates to their existing MachineSets, machines and nodes instead of rolling out new machines.
+//
+// The admission webhook pauses such a MachineDeployment when an update only changes the node metadata of its
+// template, so the MachineDeployment controller does not create a new MachineSet for the new template. Once the
+// existing MachineSets match the template again, the MachineDeployment gets resumed.
+package nodemetadata
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterv1alpha1clientset "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset"
+	clusterlistersv1alpha1 "sigs.k8s.io/cluster-api/pkg/client/listers_generated/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/admission"
+	kuberneteshelper "github.com/kubermatic/machine-controller/pkg/kubernetes"
+)
+
+// Controller updates the node metadata of MachineDeployments with a pending in-place update
+type Controller struct {
+	kubeClient              kubernetes.Interface
+	machineClient           clusterv1alpha1clientset.Interface
+	machineDeploymentLister clusterlistersv1alpha1.MachineDeploymentLister
+
+	workqueue workqueue.RateLimitingInterface
+}
+
+// New returns a new in-place node metadata controller
+func New(
+	kubeClient kubernetes.Interface,
+	machineClient clusterv1alpha1clientset.Interface,
+	machineDeploymentInformer cache.SharedIndexInformer,
+	machineDeploymentLister clusterlistersv1alpha1.MachineDeploymentLister,
+) *Controller {
+	controller := &Controller{
+		kubeClient:              kubeClient,
+		machineClient:           machineClient,
+		machineDeploymentLister: machineDeploymentLister,
+		workqueue:               workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(1*time.Second, 5*time.Minute), "NodeMetadata"),
+	}
+
+	machineDeploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueMachineDeployment,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueMachineDeployment(new)
+		},
+	})
+
+	return controller
+}
+
+// Run starts the workers of the controller and blocks until the stop channel gets closed
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	key, quit := c.workqueue.Get()
+	if quit {
+		return false
+	}
+	defer c.workqueue.Done(key)
+
+	if err := c.sync(key.(string)); err != nil {
+		utilruntime.HandleError(fmt.Errorf("%v failed with: %v", key, err))
+		c.workqueue.AddRateLimited(key)
+		return true
+	}
+	c.workqueue.Forget(key)
+	return true
+}
+
+func (c *Controller) enqueueMachineDeployment(obj interface{}) {
+	machineDeployment, ok := obj.(*clusterv1alpha1.MachineDeployment)
+	if !ok {
+		return
+	}
+	if _, pending := machineDeployment.Annotations[admission.InPlaceNodeMetadataUpdatePendingAnnotation]; !pending {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+func (c *Controller) sync(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	listerMachineDeployment, err := c.machineDeploymentLister.MachineDeployments(namespace).Get(name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	machineDeployment := listerMachineDeployment.DeepCopy()
+	resume, pending := machineDeployment.Annotations[admission.InPlaceNodeMetadataUpdatePendingAnnotation]
+	if !pending {
+		return nil
+	}
+
+	machineSets, err := c.machineClient.ClusterV1alpha1().MachineSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list MachineSets: %v", err)
+	}
+	template := &machineDeployment.Spec.Template
+	for i := range machineSets.Items {
+		machineSet := &machineSets.Items[i]
+		if !metav1.IsControlledBy(machineSet, machineDeployment) {
+			continue
+		}
+		// MachineSets with a different template belong to an older revision and get scaled down anyways
+		if !kuberneteshelper.EqualIgnoringNodeMetadata(&machineSet.Spec.Template, template) {
+			continue
+		}
+		if err := c.updateMachineSet(machineSet, template.Spec.ObjectMeta); err != nil {
+			return err
+		}
+	}
+
+	// If the template changed in other ways in the meantime, the resumed MachineDeployment rolls out new machines
+	delete(machineDeployment.Annotations, admission.InPlaceNodeMetadataUpdatePendingAnnotation)
+	if resume, _ := strconv.ParseBool(resume); resume {
+		machineDeployment.Spec.Paused = false
+	}
+	if _, err := c.machineClient.ClusterV1alpha1().MachineDeployments(namespace).Update(machineDeployment); err != nil {
+		return fmt.Errorf("failed to resume MachineDeployment %s: %v", key, err)
+	}
+	glog.V(2).Infof("Updated the node metadata of MachineDeployment %s in place", key)
+	return nil
+}
+
+// updateMachineSet applies the node metadata to the machines and nodes of the MachineSet first and to its template
+// last, so a failed update gets retried for all machines
+func (c *Controller) updateMachineSet(machineSet *clusterv1alpha1.MachineSet, nodeMetadata metav1.ObjectMeta) error {
+	oldNodeMetadata := machineSet.Spec.Template.Spec.ObjectMeta
+	if apiequality.Semantic.DeepEqual(oldNodeMetadata.Labels, nodeMetadata.Labels) &&
+		apiequality.Semantic.DeepEqual(oldNodeMetadata.Annotations, nodeMetadata.Annotations) {
+		return nil
+	}
+
+	machines, err := c.machineClient.ClusterV1alpha1().Machines(machineSet.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list machines: %v", err)
+	}
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if !metav1.IsControlledBy(machine, machineSet) {
+			continue
+		}
+		if err := c.updateMachine(machine, oldNodeMetadata, nodeMetadata); err != nil {
+			return err
+		}
+	}
+
+	machineSet.Spec.Template.Spec.ObjectMeta.Labels = nodeMetadata.Labels
+	machineSet.Spec.Template.Spec.ObjectMeta.Annotations = nodeMetadata.Annotations
+	if _, err := c.machineClient.ClusterV1alpha1().MachineSets(machineSet.Namespace).Update(machineSet); err != nil {
+		return fmt.Errorf("failed to update MachineSet %s/%s: %v", machineSet.Namespace, machineSet.Name, err)
+	}
+	return nil
+}
+
+// updateMachine applies the change of the node metadata to the machine and its node. Labels and annotations
+// which other parties put onto the node are kept.
+func (c *Controller) updateMachine(machine *clusterv1alpha1.Machine, oldNodeMetadata, nodeMetadata metav1.ObjectMeta) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		currentMachine, err := c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Get(machine.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		labels, labelsChanged := applyChange(currentMachine.Spec.ObjectMeta.Labels, oldNodeMetadata.Labels, nodeMetadata.Labels)
+		annotations, annotationsChanged := applyChange(currentMachine.Spec.ObjectMeta.Annotations, oldNodeMetadata.Annotations, nodeMetadata.Annotations)
+		if !labelsChanged && !annotationsChanged {
+			return nil
+		}
+		currentMachine.Spec.ObjectMeta.Labels = labels
+		currentMachine.Spec.ObjectMeta.Annotations = annotations
+		// The webhook only allows spec changes with this annotation and removes it again
+		if currentMachine.Annotations == nil {
+			currentMachine.Annotations = map[string]string{}
+		}
+		currentMachine.Annotations[admission.BypassSpecNoModificationRequirementAnnotation] = "true"
+		_, err = c.machineClient.ClusterV1alpha1().Machines(machine.Namespace).Update(currentMachine)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update machine %s/%s: %v", machine.Namespace, machine.Name, err)
+	}
+
+	if machine.Status.NodeRef == nil {
+		return nil
+	}
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(machine.Status.NodeRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		labels, labelsChanged := applyChange(node.Labels, oldNodeMetadata.Labels, nodeMetadata.Labels)
+		annotations, annotationsChanged := applyChange(node.Annotations, oldNodeMetadata.Annotations, nodeMetadata.Annotations)
+		if !labelsChanged && !annotationsChanged {
+			return nil
+		}
+		node.Labels = labels
+		node.Annotations = annotations
+		_, err = c.kubeClient.CoreV1().Nodes().Update(node)
+		return err
+	})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to update node %s: %v", machine.Status.NodeRef.Name, err)
+	}
+	return nil
+}
+
+// applyChange removes the keys which got removed between old and new from current and sets all keys of new.
+// It returns the resulting map and if it differs from current.
+func applyChange(current, old, new map[string]string) (map[string]string, bool) {
+	result := map[string]string{}
+	for k, v := range current {
+		result[k] = v
+	}
+	for k := range old {
+		if _, exists := new[k]; !exists {
+			delete(result, k)
+		}
+	}
+	for k, v := range new {
+		result[k] = v
+	}
+	return result, !apiequality.Semantic.DeepEqual(current, result)
+}
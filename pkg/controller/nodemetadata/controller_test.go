@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodemetadata
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+	clusterlistersv1alpha1 "sigs.k8s.io/cluster-api/pkg/client/listers_generated/cluster/v1alpha1"
+	machinedeploymentutil "sigs.k8s.io/cluster-api/pkg/controller/machinedeployment/util"
+
+	"github.com/kubermatic/machine-controller/pkg/admission"
+)
+
+func TestLabelChangeUpdatesNodesInPlace(t *testing.T) {
+	oldTemplate := clusterv1alpha1.MachineTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"md": "workers"}},
+		Spec: clusterv1alpha1.MachineSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"role": "batch", "obsolete": "true"}},
+			Versions:   clusterv1alpha1.MachineVersionInfo{Kubelet: "1.14.0"},
+		},
+	}
+	newTemplate := *oldTemplate.DeepCopy()
+	newTemplate.Spec.ObjectMeta.Labels = map[string]string{"role": "web", "tier": "frontend"}
+
+	machineDeployment := &clusterv1alpha1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "workers",
+			Namespace: metav1.NamespaceSystem,
+			UID:       "md-uid",
+			Annotations: map[string]string{
+				admission.InPlaceNodeMetadataUpdateAnnotation:        "true",
+				admission.InPlaceNodeMetadataUpdatePendingAnnotation: "true",
+			},
+		},
+		Spec: clusterv1alpha1.MachineDeploymentSpec{Paused: true, Template: newTemplate},
+	}
+	isController := true
+	machineSetTemplate := *oldTemplate.DeepCopy()
+	machineSetTemplate.Labels["machine-template-hash"] = "1234"
+	machineSet := &clusterv1alpha1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "workers-1234",
+			Namespace: metav1.NamespaceSystem,
+			UID:       "ms-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "MachineDeployment", Name: "workers", UID: "md-uid", Controller: &isController},
+			},
+		},
+		Spec: clusterv1alpha1.MachineSetSpec{Template: machineSetTemplate},
+	}
+	machine := &clusterv1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "workers-1234-abcde",
+			Namespace: metav1.NamespaceSystem,
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "MachineSet", Name: "workers-1234", UID: "ms-uid", Controller: &isController},
+			},
+		},
+		Spec:   oldTemplate.Spec,
+		Status: clusterv1alpha1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-1",
+			Labels: map[string]string{"role": "batch", "obsolete": "true", "kubernetes.io/hostname": "node-1"},
+		},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(machineDeployment); err != nil {
+		t.Fatal(err)
+	}
+	kubeClient := kubefake.NewSimpleClientset(node)
+	machineClient := clusterfake.NewSimpleClientset([]runtime.Object{machineDeployment, machineSet, machine}...)
+	c := &Controller{
+		kubeClient:              kubeClient,
+		machineClient:           machineClient,
+		machineDeploymentLister: clusterlistersv1alpha1.NewMachineDeploymentLister(indexer),
+	}
+
+	if err := c.sync(metav1.NamespaceSystem + "/workers"); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	expectedNodeLabels := map[string]string{"role": "web", "tier": "frontend", "kubernetes.io/hostname": "node-1"}
+	updatedNode, err := kubeClient.CoreV1().Nodes().Get("node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(updatedNode.Labels, expectedNodeLabels) {
+		t.Errorf("expected node labels %v, got %v", expectedNodeLabels, updatedNode.Labels)
+	}
+
+	clusterClient := machineClient.ClusterV1alpha1().Machines(metav1.NamespaceSystem)
+	updatedMachine, err := clusterClient.Get(machine.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(updatedMachine.Spec.ObjectMeta.Labels, newTemplate.Spec.ObjectMeta.Labels) {
+		t.Errorf("expected machine node labels %v, got %v", newTemplate.Spec.ObjectMeta.Labels, updatedMachine.Spec.ObjectMeta.Labels)
+	}
+	if updatedMachine.Annotations[admission.BypassSpecNoModificationRequirementAnnotation] != "true" {
+		t.Errorf("expected the machine update to bypass the spec immutability")
+	}
+
+	updatedMachineDeployment, err := machineClient.ClusterV1alpha1().MachineDeployments(metav1.NamespaceSystem).Get("workers", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updatedMachineDeployment.Spec.Paused {
+		t.Errorf("expected MachineDeployment to be resumed")
+	}
+	if _, pending := updatedMachineDeployment.Annotations[admission.InPlaceNodeMetadataUpdatePendingAnnotation]; pending {
+		t.Errorf("expected pending annotation to be removed")
+	}
+
+	// The MachineDeployment controller must find the existing MachineSet instead of rolling out a new one
+	machineSets, err := machineClient.ClusterV1alpha1().MachineSets(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machineSets.Items) != 1 {
+		t.Fatalf("expected one MachineSet, got %d", len(machineSets.Items))
+	}
+	if newMachineSet := machinedeploymentutil.FindNewMachineSet(updatedMachineDeployment, []*clusterv1alpha1.MachineSet{&machineSets.Items[0]}); newMachineSet == nil {
+		t.Errorf("expected the updated MachineSet to match the template of the MachineDeployment")
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	current := map[string]string{"a": "1", "b": "2", "foreign": "x"}
+	result, changed := applyChange(current, map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "3"})
+	if expected := map[string]string{"a": "3", "foreign": "x"}; !changed || !equality.Semantic.DeepEqual(result, expected) {
+		t.Errorf("expected %v to be changed to %v, got %v", current, expected, result)
+	}
+	if _, changed := applyChange(result, map[string]string{"a": "1"}, map[string]string{"a": "3"}); changed {
+		t.Errorf("expected an applied change to be a no-op")
+	}
+}
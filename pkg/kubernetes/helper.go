@@ -17,10 +17,23 @@ limitations under the License.
 package kubernetes
 
 import (
+	"regexp"
+	"strings"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
+// machineTemplateHashLabel gets set by the MachineDeployment controller on all machines of its MachineSets
+const machineTemplateHashLabel = "machine-template-hash"
+
+// machineTemplateHashRegexp matches the template hashes which are valid tag and label values on all cloud providers
+var machineTemplateHashRegexp = regexp.MustCompile(`^[a-z0-9]{1,63}$`)
+
 // HasFinalizer tells if a object has the given finalizer
 func HasFinalizer(o metav1.Object, name string) bool {
 	return sets.NewString(o.GetFinalizers()...).Has(name)
@@ -32,3 +45,42 @@ func RemoveFinalizer(finalizers []string, toRemove string) []string {
 	set.Delete(toRemove)
 	return set.List()
 }
+
+// MachineDeploymentName returns the name of the MachineDeployment a machine belongs to.
+// The name gets derived from the owning MachineSet, which the MachineDeployment controller
+// names "<deployment>-<encoded template hash>".
+func MachineDeploymentName(machine metav1.Object) (string, bool) {
+	hash, hasHash := machine.GetLabels()[machineTemplateHashLabel]
+	if !hasHash {
+		return "", false
+	}
+	suffix := "-" + rand.SafeEncodeString(hash)
+	for _, ownerRef := range machine.GetOwnerReferences() {
+		if ownerRef.Kind == "MachineSet" && strings.HasSuffix(ownerRef.Name, suffix) {
+			return strings.TrimSuffix(ownerRef.Name, suffix), true
+		}
+	}
+	return "", false
+}
+
+// MachineTemplateHash returns the template hash of the MachineSet a machine belongs to, which identifies the rollout
+// revision of its MachineDeployment. Hashes which are not valid tag values on all cloud providers are ignored.
+func MachineTemplateHash(machine metav1.Object) (string, bool) {
+	hash, hasHash := machine.GetLabels()[machineTemplateHashLabel]
+	if !hasHash || !machineTemplateHashRegexp.MatchString(hash) {
+		return "", false
+	}
+	return hash, true
+}
+
+// EqualIgnoringNodeMetadata tells if two machine templates are equal apart from the template hash label
+// and the labels and annotations which get applied to the nodes of their machines
+func EqualIgnoringNodeMetadata(a, b *clusterv1alpha1.MachineTemplateSpec) bool {
+	aCopy, bCopy := a.DeepCopy(), b.DeepCopy()
+	for _, template := range []*clusterv1alpha1.MachineTemplateSpec{aCopy, bCopy} {
+		delete(template.Labels, machineTemplateHashLabel)
+		template.Spec.ObjectMeta.Labels = nil
+		template.Spec.ObjectMeta.Annotations = nil
+	}
+	return apiequality.Semantic.DeepEqual(aCopy, bCopy)
+}
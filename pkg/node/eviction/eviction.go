@@ -18,6 +18,9 @@ package eviction
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +31,7 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	listerscorev1 "k8s.io/client-go/listers/core/v1"
@@ -39,18 +43,63 @@ const (
 	SkipEvictionAnnotationKey = "kubermatic.io/skip-eviction"
 )
 
+// NamespacePriorities maps namespaces to their eviction priority. Pods in namespaces
+// with a lower priority get evicted first, unlisted namespaces have the priority 0
+type NamespacePriorities map[string]int
+
+// ParseNamespacePriorities parses a comma separated list of namespace=priority pairs
+func ParseNamespacePriorities(s string) (NamespacePriorities, error) {
+	priorities := NamespacePriorities{}
+	if s == "" {
+		return priorities, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.Split(pair, "=")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid namespace priority %q, expected namespace=priority", pair)
+		}
+		priority, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority for namespace %q: %v", parts[0], err)
+		}
+		priorities[parts[0]] = priority
+	}
+	return priorities, nil
+}
+
+// Progress is the state of a running eviction
+type Progress struct {
+	// PodsEvicted is the number of pods which are gone since the eviction started
+	PodsEvicted int
+	// PodsRemaining is the number of pods which still need to be evicted
+	PodsRemaining int
+	// BlockingPod is the pod the eviction currently waits for as namespace/name, empty if it does not wait
+	BlockingPod string
+}
+
+// ProgressFunc gets called whenever the progress of an eviction changed
+type ProgressFunc func(Progress)
+
 type NodeEviction struct {
-	nodeName   string
-	nodeLister listerscorev1.NodeLister
-	client     kubernetes.Interface
+	nodeName            string
+	nodeLister          listerscorev1.NodeLister
+	client              kubernetes.Interface
+	namespacePriorities NamespacePriorities
+	excludePodSelector  labels.Selector
+	progress            ProgressFunc
+	lastProgress        *Progress
 }
 
-// New returns a new NodeEviction
-func New(nodeName string, nodeLister listerscorev1.NodeLister, client kubernetes.Interface) *NodeEviction {
+// New returns a new NodeEviction. Pods matching the excludePodSelector do not get evicted, they
+// get taken down with the node. A nil selector excludes no pods. The progress func may be nil
+func New(nodeName string, nodeLister listerscorev1.NodeLister, client kubernetes.Interface, namespacePriorities NamespacePriorities, excludePodSelector labels.Selector, progress ProgressFunc) *NodeEviction {
 	return &NodeEviction{
-		nodeName:   nodeName,
-		nodeLister: nodeLister,
-		client:     client,
+		nodeName:            nodeName,
+		nodeLister:          nodeLister,
+		client:              client,
+		namespacePriorities: namespacePriorities,
+		excludePodSelector:  excludePodSelector,
+		progress:            progress,
 	}
 }
 
@@ -58,6 +107,11 @@ func New(nodeName string, nodeLister listerscorev1.NodeLister, client kubernetes
 func (ne *NodeEviction) Run() error {
 	listerNode, err := ne.nodeLister.Get(ne.nodeName)
 	if err != nil {
+		// The node got deleted in the meantime - Nothing to evict
+		if kerrors.IsNotFound(err) {
+			glog.V(3).Infof("Skipping eviction for node %s as it does not exist anymore", ne.nodeName)
+			return nil
+		}
 		return fmt.Errorf("failed to get node from lister: %v", err)
 	}
 	node := listerNode.DeepCopy()
@@ -78,20 +132,75 @@ func (ne *NodeEviction) Run() error {
 	}
 	glog.V(6).Infof("Found %v pods to evict for node %s", len(podsToEvict), ne.nodeName)
 
-	if errs := ne.evictPods(podsToEvict); len(errs) > 0 {
-		return fmt.Errorf("failed to evict pods, errors encountered: %v", errs)
-	}
-	glog.V(6).Infof("Successfully created evictions for all pods on node %s!", ne.nodeName)
-
-	glog.V(6).Infof("Waiting for deletion of all pods for node %s", ne.nodeName)
-	if err := ne.waitForDeletion(podsToEvict); err != nil {
-		return fmt.Errorf("failed waiting for pods of node %s to be deleted: %v", ne.nodeName, err)
+	if err := ne.evictPodsByNamespacePriority(podsToEvict); err != nil {
+		return err
 	}
 	glog.V(3).Infof("All pods of node %s were successfully evicted", ne.nodeName)
 
 	return nil
 }
 
+// evictPodsByNamespacePriority evicts the pods in groups by the priority of their namespace.
+// A group only gets evicted after all pods of the previous group are gone.
+func (ne *NodeEviction) evictPodsByNamespacePriority(pods []corev1.Pod) error {
+	ne.reportProgress(Progress{PodsRemaining: len(pods)})
+
+	var evicted int
+	for _, group := range groupPodsByNamespacePriority(pods, ne.namespacePriorities) {
+		if errs := ne.evictPods(group); len(errs) > 0 {
+			return fmt.Errorf("failed to evict pods, errors encountered: %v", errs)
+		}
+		glog.V(6).Infof("Successfully created evictions for %d pods on node %s", len(group), ne.nodeName)
+
+		glog.V(6).Infof("Waiting for deletion of %d pods for node %s", len(group), ne.nodeName)
+		err := ne.waitForDeletion(group, func(remaining []corev1.Pod) {
+			progress := Progress{
+				PodsEvicted:   evicted + len(group) - len(remaining),
+				PodsRemaining: len(pods) - evicted - len(group) + len(remaining),
+			}
+			if len(remaining) > 0 {
+				progress.BlockingPod = remaining[0].Namespace + "/" + remaining[0].Name
+			}
+			ne.reportProgress(progress)
+		})
+		if err != nil {
+			return fmt.Errorf("failed waiting for pods of node %s to be deleted: %v", ne.nodeName, err)
+		}
+		evicted += len(group)
+	}
+	return nil
+}
+
+// reportProgress passes the progress to the progress func if it changed
+func (ne *NodeEviction) reportProgress(progress Progress) {
+	if ne.progress == nil || (ne.lastProgress != nil && *ne.lastProgress == progress) {
+		return
+	}
+	ne.lastProgress = &progress
+	ne.progress(progress)
+}
+
+// groupPodsByNamespacePriority groups the pods by the priority of their namespace, lowest priority first
+func groupPodsByNamespacePriority(pods []corev1.Pod, priorities NamespacePriorities) [][]corev1.Pod {
+	groups := map[int][]corev1.Pod{}
+	for _, pod := range pods {
+		priority := priorities[pod.Namespace]
+		groups[priority] = append(groups[priority], pod)
+	}
+
+	var keys []int
+	for priority := range groups {
+		keys = append(keys, priority)
+	}
+	sort.Ints(keys)
+
+	var sortedGroups [][]corev1.Pod
+	for _, priority := range keys {
+		sortedGroups = append(sortedGroups, groups[priority])
+	}
+	return sortedGroups
+}
+
 func (ne *NodeEviction) cordonNode(node *corev1.Node) error {
 	_, err := ne.updateNode(func(n *corev1.Node) {
 		n.Spec.Unschedulable = true
@@ -137,6 +246,10 @@ func (ne *NodeEviction) getFilteredPods() ([]corev1.Pod, error) {
 		if _, found := candidatePod.ObjectMeta.Annotations[corev1.MirrorPodAnnotationKey]; found {
 			continue
 		}
+		if ne.excludePodSelector != nil && ne.excludePodSelector.Matches(labels.Set(candidatePod.Labels)) {
+			glog.V(6).Infof("Not evicting pod %s/%s on node %s as it matches the drain exclusion selector", candidatePod.Namespace, candidatePod.Name, ne.nodeName)
+			continue
+		}
 		filteredPods = append(filteredPods, candidatePod)
 	}
 
@@ -227,15 +340,25 @@ func (ne *NodeEviction) updateNode(modify func(*corev1.Node)) (*corev1.Node, err
 	return updatedNode, err
 }
 
-func (ne *NodeEviction) waitForDeletion(pods []corev1.Pod) error {
+// waitForDeletion waits until all pods are gone. The pods which still exist get passed to
+// the remaining func after every check
+func (ne *NodeEviction) waitForDeletion(pods []corev1.Pod, remainingFunc func([]corev1.Pod)) error {
 	return wait.Poll(1*time.Second, timeout, func() (bool, error) {
+		var remaining []corev1.Pod
 		for _, pod := range pods {
-			_, err := ne.client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
-			if err != nil && kerrors.IsNotFound(err) {
-				return true, nil
+			currentPod, err := ne.client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if err != nil {
+				if kerrors.IsNotFound(err) {
+					continue
+				}
+				return false, err
+			}
+			// A pod with the same name but a different UID got recreated by its controller
+			if currentPod.UID == pod.UID {
+				remaining = append(remaining, pod)
 			}
-			return false, err
 		}
-		return true, nil
+		remainingFunc(remaining)
+		return len(remaining) == 0, nil
 	})
 }
@@ -19,10 +19,16 @@ package eviction
 import (
 	"testing"
 
+	"github.com/go-test/deep"
+
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 // Unfortunately we can not directly test `EvictNode` as a List with a fieldSelector
@@ -77,3 +83,181 @@ func TestEvictPods(t *testing.T) {
 		})
 	}
 }
+
+func TestEvictPodsByNamespacePriority(t *testing.T) {
+	pods := []runtime.Object{
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "dns", UID: "1"},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "batch", Name: "job", UID: "2"},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "3"},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+	}
+	var literalPods []corev1.Pod
+	for _, pod := range pods {
+		literalPods = append(literalPods, *(pod.(*corev1.Pod)))
+	}
+
+	client := kubefake.NewSimpleClientset(pods...)
+	// The fake client does not delete evicted pods, so we pretend they are gone
+	var evicted []string
+	client.PrependReactor("post", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		evicted = append(evicted, action.GetNamespace()+"/"+action.(clienttesting.GetAction).GetName())
+		return true, nil, nil
+	})
+	client.PrependReactor("get", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		name := action.GetNamespace() + "/" + action.(clienttesting.GetAction).GetName()
+		for _, evictedPod := range evicted {
+			if evictedPod == name {
+				return true, nil, kerrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name)
+			}
+		}
+		return false, nil, nil
+	})
+
+	var progress []Progress
+	ne := &NodeEviction{
+		client:              client,
+		nodeName:            "node1",
+		namespacePriorities: NamespacePriorities{"kube-system": 100, "batch": -10},
+		progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	}
+	if err := ne.evictPodsByNamespacePriority(literalPods); err != nil {
+		t.Fatalf("Got unexpected error when evicting pods: %v", err)
+	}
+
+	expected := []string{"batch/job", "default/web", "kube-system/dns"}
+	if diff := deep.Equal(evicted, expected); diff != nil {
+		t.Errorf("Pods were not evicted in the expected order, diff: %v", diff)
+	}
+
+	expectedProgress := []Progress{
+		{PodsRemaining: 3},
+		{PodsEvicted: 1, PodsRemaining: 2},
+		{PodsEvicted: 2, PodsRemaining: 1},
+		{PodsEvicted: 3, PodsRemaining: 0},
+	}
+	if diff := deep.Equal(progress, expectedProgress); diff != nil {
+		t.Errorf("Unexpected progress of the eviction, diff: %v", diff)
+	}
+}
+
+func TestWaitForDeletionReportsRemainingPods(t *testing.T) {
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", UID: "2"}},
+	}
+	client := kubefake.NewSimpleClientset(&pods[1])
+
+	var progress []Progress
+	ne := &NodeEviction{
+		client:   client,
+		nodeName: "node1",
+		progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	}
+
+	// The db pod does not go away, e.g. because it ignores SIGTERM, so the drain is blocked by it
+	checks := 0
+	client.PrependReactor("get", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		checks++
+		if checks > 4 {
+			return true, nil, kerrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "db")
+		}
+		return false, nil, nil
+	})
+	err := ne.evictPodsByNamespacePriority(pods)
+	if err != nil {
+		t.Fatalf("Got unexpected error when evicting pods: %v", err)
+	}
+
+	expectedProgress := []Progress{
+		{PodsRemaining: 2},
+		{PodsEvicted: 1, PodsRemaining: 1, BlockingPod: "default/db"},
+		{PodsEvicted: 2, PodsRemaining: 0},
+	}
+	if diff := deep.Equal(progress, expectedProgress); diff != nil {
+		t.Errorf("Unexpected progress of the eviction, diff: %v", diff)
+	}
+}
+
+func TestGetFilteredPodsExcludesSelectedPods(t *testing.T) {
+	client := kubefake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "backup", Name: "agent", Labels: map[string]string{"app": "backup-agent"}},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "proxy", Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "mirror"}},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+	)
+
+	ne := &NodeEviction{
+		client:             client,
+		nodeName:           "node1",
+		excludePodSelector: labels.SelectorFromSet(labels.Set{"app": "backup-agent"}),
+	}
+	pods, err := ne.getFilteredPods()
+	if err != nil {
+		t.Fatalf("Got unexpected error when getting the pods to evict: %v", err)
+	}
+	if errs := ne.evictPods(pods); len(errs) > 0 {
+		t.Fatalf("Got unexpected errors=%v when running evictPods", errs)
+	}
+
+	var evictedNamespaces []string
+	for _, action := range client.Actions() {
+		if action.GetSubresource() == "eviction" {
+			evictedNamespaces = append(evictedNamespaces, action.GetNamespace())
+		}
+	}
+	if diff := deep.Equal(evictedNamespaces, []string{"default"}); diff != nil {
+		t.Errorf("Expected only the pod not matching the exclusion selector to be evicted, diff: %v", diff)
+	}
+}
+
+func TestParseNamespacePriorities(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected NamespacePriorities
+		WantErr  bool
+	}{
+		{
+			Name:     "empty",
+			Expected: NamespacePriorities{},
+		},
+		{
+			Name:     "multiple namespaces",
+			Input:    "kube-system=100,batch=-10",
+			Expected: NamespacePriorities{"kube-system": 100, "batch": -10},
+		},
+		{
+			Name:    "missing priority",
+			Input:   "kube-system",
+			WantErr: true,
+		},
+		{
+			Name:    "invalid priority",
+			Input:   "kube-system=high",
+			WantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			priorities, err := ParseNamespacePriorities(test.Input)
+			if (err != nil) != test.WantErr {
+				t.Fatalf("expected error: %t, got: %v", test.WantErr, err)
+			}
+			if diff := deep.Equal(priorities, test.Expected); diff != nil {
+				t.Errorf("unexpected priorities, diff: %v", diff)
+			}
+		})
+	}
+}
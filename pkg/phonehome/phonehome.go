@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// Receiver for the bootstrap status nodes report to the machine-controller.
+// The userdata of a machine contains a URL and a token which are specific to
+// the machine, the node posts the result of its bootstrap together with the
+// tail of the setup log to it.
+//
+
+package phonehome
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
+)
+
+const (
+	statusSuccess = "success"
+	statusFailure = "failure"
+
+	maxRequestBodySize = 64 << 10
+	// maxLogSize is the size of the log tail which gets kept from a report
+	maxLogSize = 4 << 10
+)
+
+// Report is the bootstrap status reported by the node of a machine
+type Report struct {
+	// MachineUID is the UID of the machine the node belongs to
+	MachineUID types.UID
+	// Succeeded is true if the bootstrap of the node finished successfully
+	Succeeded bool
+	// Log is the tail of the bootstrap log of the node
+	Log string
+}
+
+// Receiver is a http.Handler which accepts bootstrap status reports and
+// publishes them on a channel
+type Receiver struct {
+	url     string
+	secret  []byte
+	reports chan Report
+}
+
+// NewReceiver returns a new Receiver. The url is the address under which nodes reach the
+// receiver, the secret is used to derive the tokens of the machines.
+func NewReceiver(url string, secret []byte) *Receiver {
+	return &Receiver{
+		url:     strings.TrimSuffix(url, "/"),
+		secret:  secret,
+		reports: make(chan Report, 100),
+	}
+}
+
+// Reports returns the channel on which all received reports get published
+func (r *Receiver) Reports() <-chan Report {
+	return r.reports
+}
+
+// PhoneHome returns the phone-home configuration for the userdata of the given machine
+func (r *Receiver) PhoneHome(machineUID types.UID) *plugin.PhoneHome {
+	return &plugin.PhoneHome{
+		URL:   fmt.Sprintf("%s/%s", r.url, machineUID),
+		Token: r.token(machineUID),
+	}
+}
+
+func (r *Receiver) token(machineUID types.UID) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(machineUID)) // nolint: errcheck
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	machineUID := types.UID(path.Base(req.URL.Path))
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if machineUID == "" || machineUID == "/" || !hmac.Equal([]byte(token), []byte(r.token(machineUID))) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	status := req.URL.Query().Get("status")
+	if status != statusSuccess && status != statusFailure {
+		http.Error(w, fmt.Sprintf("invalid status %q, must be %q or %q", status, statusSuccess, statusFailure), http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxLogSize {
+		body = body[len(body)-maxLogSize:]
+	}
+
+	report := Report{MachineUID: machineUID, Succeeded: status == statusSuccess, Log: string(body)}
+	glog.V(4).Infof("Received bootstrap status %q for machine %q", status, machineUID)
+	select {
+	case r.reports <- report:
+		w.WriteHeader(http.StatusOK)
+	default:
+		// The node retries the report
+		glog.Warningf("Rejecting bootstrap status for machine %q as the report queue is full", machineUID)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phonehome
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestReceiver(t *testing.T) {
+	receiver := NewReceiver("https://machine-controller.example.com/phone-home/", []byte("secret"))
+	phoneHome := receiver.PhoneHome("machine-uid")
+	if phoneHome.URL != "https://machine-controller.example.com/phone-home/machine-uid" {
+		t.Fatalf("unexpected phone-home url %q", phoneHome.URL)
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		body           string
+		expectedStatus int
+		expectedReport *Report
+	}{
+		{
+			name:           "success",
+			path:           "/phone-home/machine-uid?status=success",
+			token:          phoneHome.Token,
+			body:           "setup finished",
+			expectedStatus: http.StatusOK,
+			expectedReport: &Report{MachineUID: "machine-uid", Succeeded: true, Log: "setup finished"},
+		},
+		{
+			name:           "failure with truncated log",
+			path:           "/phone-home/machine-uid?status=failure",
+			token:          phoneHome.Token,
+			body:           strings.Repeat("a", maxLogSize) + "failed to install packages",
+			expectedStatus: http.StatusOK,
+			expectedReport: &Report{
+				MachineUID: "machine-uid",
+				Log:        strings.Repeat("a", maxLogSize-len("failed to install packages")) + "failed to install packages",
+			},
+		},
+		{
+			name:           "missing token",
+			path:           "/phone-home/machine-uid?status=success",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "token of another machine",
+			path:           "/phone-home/other-machine-uid?status=success",
+			token:          phoneHome.Token,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid status",
+			path:           "/phone-home/machine-uid?status=done",
+			token:          phoneHome.Token,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			resp := httptest.NewRecorder()
+
+			receiver.ServeHTTP(resp, req)
+
+			if resp.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, resp.Code)
+			}
+			var report *Report
+			if len(receiver.reports) > 0 {
+				received := <-receiver.reports
+				report = &received
+			}
+			if diff := deep.Equal(report, test.expectedReport); diff != nil {
+				t.Errorf("unexpected report, diff: %v", diff)
+			}
+		})
+	}
+}
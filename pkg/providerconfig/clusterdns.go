@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultClusterDomain is the DNS domain of the cluster unless the config overrides it
+const DefaultClusterDomain = "cluster.local"
+
+// ClusterDNSConfig returns the DNS server IPs and the DNS domain of the cluster the kubelet configures for pods.
+// The overrides of the config take precedence over the detected DNS server IPs and the default domain.
+func (c *Config) ClusterDNSConfig(detectedIPs []net.IP) ([]net.IP, string, error) {
+	ips := detectedIPs
+	if len(c.ClusterDNS) > 0 {
+		ips = make([]net.IP, 0, len(c.ClusterDNS))
+		for _, rawIP := range c.ClusterDNS {
+			ip := net.ParseIP(rawIP)
+			if ip == nil {
+				return nil, "", fmt.Errorf("invalid clusterDNS %q, must be an IP address", rawIP)
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	domain := DefaultClusterDomain
+	if c.ClusterDomain != "" {
+		if errs := validation.IsDNS1123Subdomain(c.ClusterDomain); len(errs) > 0 {
+			return nil, "", fmt.Errorf("invalid clusterDomain %q: %s", c.ClusterDomain, strings.Join(errs, ", "))
+		}
+		domain = c.ClusterDomain
+	}
+	return ips, domain, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestClusterDNSConfig(t *testing.T) {
+	detectedIPs := []net.IP{net.ParseIP("10.10.10.10")}
+
+	tests := []struct {
+		name           string
+		config         Config
+		expectedIPs    []net.IP
+		expectedDomain string
+		expectedErr    bool
+	}{
+		{
+			name:           "defaults",
+			config:         Config{},
+			expectedIPs:    detectedIPs,
+			expectedDomain: "cluster.local",
+		},
+		{
+			name:           "overrides",
+			config:         Config{ClusterDNS: []string{"10.96.0.10", "fd00::a"}, ClusterDomain: "k8s.example.com"},
+			expectedIPs:    []net.IP{net.ParseIP("10.96.0.10"), net.ParseIP("fd00::a")},
+			expectedDomain: "k8s.example.com",
+		},
+		{
+			name:        "invalid ip",
+			config:      Config{ClusterDNS: []string{"10.96.0"}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid domain",
+			config:      Config{ClusterDomain: "Cluster_Local"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, domain, err := test.config.ClusterDNSConfig(detectedIPs)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr {
+				return
+			}
+			if !reflect.DeepEqual(ips, test.expectedIPs) {
+				t.Errorf("expected ips %v, got %v", test.expectedIPs, ips)
+			}
+			if domain != test.expectedDomain {
+				t.Errorf("expected domain %q, got %q", test.expectedDomain, domain)
+			}
+		})
+	}
+}
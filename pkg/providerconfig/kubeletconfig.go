@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// KubeletConfig holds kubelet settings which only need a restart of the kubelet to change. They get written to
+// an environment file on the node, so they can be changed on existing nodes without re-creating them
+type KubeletConfig struct {
+	// EvictionHard are the thresholds which evict pods immediately, e.g. memory.available<100Mi
+	EvictionHard string `json:"evictionHard,omitempty"`
+	// EvictionSoft are the thresholds which evict pods once they are exceeded for their grace period
+	EvictionSoft string `json:"evictionSoft,omitempty"`
+	// EvictionSoftGracePeriod are the grace periods of the soft thresholds, e.g. memory.available=1m30s
+	EvictionSoftGracePeriod string `json:"evictionSoftGracePeriod,omitempty"`
+	// MaxPods is the number of pods the kubelet can run
+	MaxPods *int32 `json:"maxPods,omitempty"`
+}
+
+// kubeletConfigOperatingSystems are the operating systems whose userdata writes the kubelet config to the
+// environment file the kubelet reads
+var kubeletConfigOperatingSystems = sets.NewString(
+	string(OperatingSystemUbuntu),
+	string(OperatingSystemCentOS),
+)
+
+// KubeletConfigSupported returns true if the kubelet config gets applied on the given operating system
+func KubeletConfigSupported(os OperatingSystem) bool {
+	return kubeletConfigOperatingSystems.Has(string(os))
+}
+
+// evictionSignals are the eviction signals the kubelet supports
+var evictionSignals = sets.NewString(
+	"memory.available",
+	"nodefs.available",
+	"nodefs.inodesFree",
+	"imagefs.available",
+	"imagefs.inodesFree",
+	"allocatableMemory.available",
+	"pid.available",
+)
+
+// ValidateKubeletConfig checks the eviction thresholds and the pod limit of the kubelet config
+func (c *Config) ValidateKubeletConfig() error {
+	kc := c.KubeletConfig
+	if kc == nil {
+		return nil
+	}
+	if _, err := parseEvictionThresholds(kc.EvictionHard); err != nil {
+		return fmt.Errorf("invalid kubeletConfig.evictionHard: %v", err)
+	}
+	soft, err := parseEvictionThresholds(kc.EvictionSoft)
+	if err != nil {
+		return fmt.Errorf("invalid kubeletConfig.evictionSoft: %v", err)
+	}
+	gracePeriods, err := parseEvictionSoftGracePeriods(kc.EvictionSoftGracePeriod)
+	if err != nil {
+		return fmt.Errorf("invalid kubeletConfig.evictionSoftGracePeriod: %v", err)
+	}
+	// The kubelet refuses to start with a soft threshold without grace period
+	for _, signal := range soft.List() {
+		if !gracePeriods.Has(signal) {
+			return fmt.Errorf("kubeletConfig.evictionSoftGracePeriod misses the grace period of %s", signal)
+		}
+	}
+	if kc.MaxPods != nil && *kc.MaxPods <= 0 {
+		return fmt.Errorf("invalid kubeletConfig.maxPods %d, must be positive", *kc.MaxPods)
+	}
+	return nil
+}
+
+// parseEvictionThresholds parses thresholds like memory.available<100Mi,nodefs.available<10% and returns their signals
+func parseEvictionThresholds(thresholds string) (sets.String, error) {
+	signals := sets.NewString()
+	if thresholds == "" {
+		return signals, nil
+	}
+	for _, threshold := range strings.Split(thresholds, ",") {
+		parts := strings.SplitN(threshold, "<", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("threshold %q must have the format <signal><<quantity>", threshold)
+		}
+		signal, value := parts[0], parts[1]
+		if !evictionSignals.Has(signal) {
+			return nil, fmt.Errorf("unknown signal %q, supported: %v", signal, evictionSignals.List())
+		}
+		if strings.HasSuffix(value, "%") {
+			if _, err := resource.ParseQuantity(strings.TrimSuffix(value, "%")); err != nil {
+				return nil, fmt.Errorf("invalid percentage %q of %s", value, signal)
+			}
+		} else if _, err := resource.ParseQuantity(value); err != nil {
+			return nil, fmt.Errorf("invalid quantity %q of %s: %v", value, signal, err)
+		}
+		signals.Insert(signal)
+	}
+	return signals, nil
+}
+
+// parseEvictionSoftGracePeriods parses grace periods like memory.available=1m30s and returns their signals
+func parseEvictionSoftGracePeriods(gracePeriods string) (sets.String, error) {
+	signals := sets.NewString()
+	if gracePeriods == "" {
+		return signals, nil
+	}
+	for _, gracePeriod := range strings.Split(gracePeriods, ",") {
+		parts := strings.SplitN(gracePeriod, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("grace period %q must have the format <signal>=<duration>", gracePeriod)
+		}
+		if !evictionSignals.Has(parts[0]) {
+			return nil, fmt.Errorf("unknown signal %q, supported: %v", parts[0], evictionSignals.List())
+		}
+		if _, err := time.ParseDuration(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid duration %q of %s: %v", parts[1], parts[0], err)
+		}
+		signals.Insert(parts[0])
+	}
+	return signals, nil
+}
+
+// Args returns the kubelet flags of the config, separated by spaces. It is empty for a nil config
+func (kc *KubeletConfig) Args() string {
+	if kc == nil {
+		return ""
+	}
+	var flags []string
+	if kc.EvictionHard != "" {
+		flags = append(flags, "--eviction-hard="+kc.EvictionHard)
+	}
+	if kc.EvictionSoft != "" {
+		flags = append(flags, "--eviction-soft="+kc.EvictionSoft)
+	}
+	if kc.EvictionSoftGracePeriod != "" {
+		flags = append(flags, "--eviction-soft-grace-period="+kc.EvictionSoftGracePeriod)
+	}
+	if kc.MaxPods != nil {
+		flags = append(flags, fmt.Sprintf("--max-pods=%d", *kc.MaxPods))
+	}
+	return strings.Join(flags, " ")
+}
+
+// OnlyKubeletConfigChanged returns true if the specs only differ in the kubeletConfig of their provider spec and the
+// operating system supports the kubelet config, so it can be changed on the existing node
+func OnlyKubeletConfigChanged(oldSpec, spec clusterv1alpha1.MachineSpec) (bool, error) {
+	oldConfig, err := GetConfig(oldSpec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+	config, err := GetConfig(spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+	if equality.Semantic.DeepEqual(oldConfig.KubeletConfig, config.KubeletConfig) || !KubeletConfigSupported(config.OperatingSystem) {
+		return false, nil
+	}
+	oldConfig.KubeletConfig, config.KubeletConfig = nil, nil
+	if !equality.Semantic.DeepEqual(oldConfig, config) {
+		return false, nil
+	}
+	oldSpec.ProviderSpec, spec.ProviderSpec = clusterv1alpha1.ProviderSpec{}, clusterv1alpha1.ProviderSpec{}
+	return equality.Semantic.DeepEqual(oldSpec, spec), nil
+}
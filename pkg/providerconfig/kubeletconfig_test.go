@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestValidateKubeletConfig(t *testing.T) {
+	maxPods, zero := int32(60), int32(0)
+	tests := []struct {
+		name         string
+		config       *KubeletConfig
+		expectedArgs string
+		expectedErr  bool
+	}{
+		{
+			name: "no config",
+		},
+		{
+			name: "all settings",
+			config: &KubeletConfig{
+				EvictionHard:            "memory.available<100Mi,nodefs.available<10%",
+				EvictionSoft:            "memory.available<300Mi",
+				EvictionSoftGracePeriod: "memory.available=1m30s",
+				MaxPods:                 &maxPods,
+			},
+			expectedArgs: "--eviction-hard=memory.available<100Mi,nodefs.available<10% --eviction-soft=memory.available<300Mi --eviction-soft-grace-period=memory.available=1m30s --max-pods=60",
+		},
+		{
+			name:        "unknown signal",
+			config:      &KubeletConfig{EvictionHard: "cpu.available<1"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid quantity",
+			config:      &KubeletConfig{EvictionHard: "memory.available<lots"},
+			expectedErr: true,
+		},
+		{
+			name:        "soft threshold without grace period",
+			config:      &KubeletConfig{EvictionSoft: "memory.available<300Mi"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid grace period",
+			config:      &KubeletConfig{EvictionSoft: "memory.available<300Mi", EvictionSoftGracePeriod: "memory.available=soon"},
+			expectedErr: true,
+		},
+		{
+			name:        "no pods",
+			config:      &KubeletConfig{MaxPods: &zero},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&Config{KubeletConfig: test.config}).ValidateKubeletConfig()
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr {
+				return
+			}
+			if args := test.config.Args(); args != test.expectedArgs {
+				t.Errorf("expected args %q, got %q", test.expectedArgs, args)
+			}
+		})
+	}
+}
+
+func TestOnlyKubeletConfigChanged(t *testing.T) {
+	spec := func(kubelet, providerSpec string) clusterv1alpha1.MachineSpec {
+		return clusterv1alpha1.MachineSpec{
+			Versions:     clusterv1alpha1.MachineVersionInfo{Kubelet: kubelet},
+			ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(providerSpec)}},
+		}
+	}
+	const (
+		withoutConfig = `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.large"},"operatingSystem":"ubuntu"}`
+		withConfig    = `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.large"},"operatingSystem":"ubuntu","kubeletConfig":{"maxPods":60}}`
+	)
+
+	tests := []struct {
+		name     string
+		oldSpec  clusterv1alpha1.MachineSpec
+		spec     clusterv1alpha1.MachineSpec
+		expected bool
+	}{
+		{
+			name:     "kubelet config added",
+			oldSpec:  spec("1.12.1", withoutConfig),
+			spec:     spec("1.12.1", withConfig),
+			expected: true,
+		},
+		{
+			name:     "kubelet config changed",
+			oldSpec:  spec("1.12.1", withConfig),
+			spec:     spec("1.12.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.large"},"operatingSystem":"ubuntu","kubeletConfig":{"maxPods":110}}`),
+			expected: true,
+		},
+		{
+			name:    "kubelet config changed on an operating system without support",
+			oldSpec: spec("1.12.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.large"},"operatingSystem":"coreos"}`),
+			spec:    spec("1.12.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.large"},"operatingSystem":"coreos","kubeletConfig":{"maxPods":60}}`),
+		},
+		{
+			name:    "cloud provider spec changed as well",
+			oldSpec: spec("1.12.1", withoutConfig),
+			spec:    spec("1.12.1", `{"cloudProvider":"aws","cloudProviderSpec":{"instanceType":"t3.xlarge"},"operatingSystem":"ubuntu","kubeletConfig":{"maxPods":60}}`),
+		},
+		{
+			name:    "kubelet version changed as well",
+			oldSpec: spec("1.12.1", withoutConfig),
+			spec:    spec("1.13.0", withConfig),
+		},
+		{
+			name:    "nothing changed",
+			oldSpec: spec("1.12.1", withConfig),
+			spec:    spec("1.12.1", withConfig),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed, err := OnlyKubeletConfigChanged(test.oldSpec, test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// SecretReferences returns the secrets referenced by the secretKeyRefs anywhere in the given provider spec,
+// sorted by namespace and name. Secrets referenced by multiple fields only get returned once.
+func SecretReferences(spec clusterv1alpha1.ProviderSpec) ([]GlobalSecretKeySelector, error) {
+	if spec.Value == nil || len(spec.Value.Raw) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(spec.Value.Raw, &value); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal provider spec: %v", err)
+	}
+
+	refs := map[string]GlobalSecretKeySelector{}
+	if err := collectSecretReferences(value, refs); err != nil {
+		return nil, err
+	}
+
+	var result []GlobalSecretKeySelector
+	for _, ref := range refs {
+		result = append(result, ref)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return secretReferenceKey(result[i]) < secretReferenceKey(result[j])
+	})
+	return result, nil
+}
+
+func collectSecretReferences(value interface{}, refs map[string]GlobalSecretKeySelector) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			if field == "secretKeyRef" {
+				b, err := json.Marshal(fieldValue)
+				if err != nil {
+					return fmt.Errorf("failed to marshal secretKeyRef: %v", err)
+				}
+				var ref GlobalSecretKeySelector
+				if err := json.Unmarshal(b, &ref); err != nil {
+					return fmt.Errorf("failed to unmarshal secretKeyRef: %v", err)
+				}
+				if ref.Name != "" && ref.Namespace != "" {
+					refs[secretReferenceKey(ref)] = GlobalSecretKeySelector{ObjectReference: ref.ObjectReference}
+				}
+				continue
+			}
+			if err := collectSecretReferences(fieldValue, refs); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := collectSecretReferences(item, refs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func secretReferenceKey(ref GlobalSecretKeySelector) string {
+	return ref.Namespace + "/" + ref.Name
+}
+
+// GetSecretVersions returns the resource versions of all secrets referenced by the given provider spec,
+// keyed by namespace/name. They change whenever one of the secrets gets rotated, so results derived from
+// the secrets, like a rendered userdata or a validation result, can be tied to them.
+func (configVarResolver *ConfigVarResolver) GetSecretVersions(spec clusterv1alpha1.ProviderSpec) (map[string]string, error) {
+	refs, err := SecretReferences(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	versions := map[string]string{}
+	for _, ref := range refs {
+		secret, err := configVarResolver.kubeClient.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving secret '%s' from namespace '%s': '%v'", ref.Name, ref.Namespace, err)
+		}
+		versions[secretReferenceKey(ref)] = secret.ResourceVersion
+	}
+	return versions, nil
+}
+
+// SecretVersionsFingerprint returns a stable string representation of the given secret versions,
+// which can be used as part of a cache key
+func SecretVersionsFingerprint(versions map[string]string) string {
+	var entries []string
+	for secret, version := range versions {
+		entries = append(entries, secret+"="+version)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const secretReferencingProviderSpec = `{
+  "cloudProvider": "openstack",
+  "cloudProviderSpec": {
+    "username": {"secretKeyRef": {"namespace": "kube-system", "name": "openstack", "key": "username"}},
+    "password": {"secretKeyRef": {"namespace": "kube-system", "name": "openstack", "key": "password"}},
+    "securityGroups": [{"secretKeyRef": {"namespace": "default", "name": "network", "key": "securityGroup"}}],
+    "flavor": "m1.small"
+  },
+  "operatingSystem": "ubuntu",
+  "sshPublicKeys": ["ssh-rsa AAAA"]
+}`
+
+func TestSecretReferences(t *testing.T) {
+	spec := clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(secretReferencingProviderSpec)}}
+
+	refs, err := SecretReferences(spec)
+	if err != nil {
+		t.Fatalf("failed to get secret references: %v", err)
+	}
+	expected := []GlobalSecretKeySelector{
+		{ObjectReference: corev1.ObjectReference{Namespace: "default", Name: "network"}},
+		{ObjectReference: corev1.ObjectReference{Namespace: "kube-system", Name: "openstack"}},
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected secret references %v, got %v", expected, refs)
+	}
+}
+
+func TestGetSecretVersionsChangeOnRotation(t *testing.T) {
+	spec := clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(secretReferencingProviderSpec)}}
+	openstackSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "openstack", ResourceVersion: "1"}}
+	networkSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "network", ResourceVersion: "7"}}
+	kubeClient := fake.NewSimpleClientset(openstackSecret, networkSecret)
+	resolver := NewConfigVarResolver(kubeClient)
+
+	versions, err := resolver.GetSecretVersions(spec)
+	if err != nil {
+		t.Fatalf("failed to get secret versions: %v", err)
+	}
+	expected := map[string]string{"default/network": "7", "kube-system/openstack": "1"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected secret versions %v, got %v", expected, versions)
+	}
+	fingerprint := SecretVersionsFingerprint(versions)
+	if fingerprint != "default/network=7,kube-system/openstack=1" {
+		t.Errorf("unexpected secret versions fingerprint %q", fingerprint)
+	}
+
+	rotatedSecret := openstackSecret.DeepCopy()
+	rotatedSecret.ResourceVersion = "2"
+	if _, err := kubeClient.CoreV1().Secrets("kube-system").Update(rotatedSecret); err != nil {
+		t.Fatalf("failed to update secret: %v", err)
+	}
+
+	versions, err = resolver.GetSecretVersions(spec)
+	if err != nil {
+		t.Fatalf("failed to get secret versions after the rotation: %v", err)
+	}
+	if versions["kube-system/openstack"] != "2" {
+		t.Errorf("expected the version of the rotated secret to be 2, got %q", versions["kube-system/openstack"])
+	}
+	if SecretVersionsFingerprint(versions) == fingerprint {
+		t.Error("expected the secret versions fingerprint to change after the rotation")
+	}
+}
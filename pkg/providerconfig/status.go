@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ConditionType is the type of a machine condition
+type ConditionType string
+
+const (
+	// PreCreateHookSucceededConditionType reflects whether the pre-create hook allowed the creation of the instance
+	PreCreateHookSucceededConditionType ConditionType = "PreCreateHookSucceeded"
+	// BootstrapSucceededConditionType reflects the bootstrap status the node reported via phone-home
+	BootstrapSucceededConditionType ConditionType = "BootstrapSucceeded"
+	// ReadinessGatePassedConditionType reflects whether the pod selected by the readiness gate runs on the node
+	ReadinessGatePassedConditionType ConditionType = "ReadinessGatePassed"
+	// DaemonSetsReadyConditionType reflects whether the pods of the DaemonSets of the readiness gate are ready on the node
+	DaemonSetsReadyConditionType ConditionType = "DaemonSetsReady"
+	// GPUCapacityReportedConditionType reflects whether the node reports the number of GPUs of the readiness gate
+	GPUCapacityReportedConditionType ConditionType = "GPUCapacityReported"
+	// CredentialsValidConditionType reflects whether the cloud provider accepted the credentials of the machine
+	CredentialsValidConditionType ConditionType = "CredentialsValid"
+	// VolumesDetachedConditionType reflects whether the VolumeAttachments of the node got removed before its instance got terminated
+	VolumesDetachedConditionType ConditionType = "VolumesDetached"
+	// ProviderSpecFieldsKnownConditionType reflects whether the machine-controller knows all fields of the provider spec
+	ProviderSpecFieldsKnownConditionType ConditionType = "ProviderSpecFieldsKnown"
+	// PreDrainDeleteHookSucceededConditionType reflects whether the pre-drain hooks of a deleted machine got removed
+	PreDrainDeleteHookSucceededConditionType ConditionType = "PreDrainDeleteHookSucceeded"
+	// PreTerminateDeleteHookSucceededConditionType reflects whether the pre-terminate hooks of a deleted machine got removed
+	PreTerminateDeleteHookSucceededConditionType ConditionType = "PreTerminateDeleteHookSucceeded"
+	// WarmPoolInstanceStoppedConditionType reflects whether the instance of a warm pool machine got stopped and can be claimed
+	WarmPoolInstanceStoppedConditionType ConditionType = "WarmPoolInstanceStopped"
+	// DrainMaintenanceWindowOpenConditionType reflects whether the node of the machine may be drained because the
+	// drain maintenance window is open
+	DrainMaintenanceWindowOpenConditionType ConditionType = "DrainMaintenanceWindowOpen"
+	// DrainSlotAcquiredConditionType reflects whether the node of the machine may be drained because the drain budget
+	// has a free slot
+	DrainSlotAcquiredConditionType ConditionType = "DrainSlotAcquired"
+	// TopologySpreadSatisfiedConditionType reflects whether the pods of the node of the machine can be rescheduled
+	// without violating their topology spread constraints
+	TopologySpreadSatisfiedConditionType ConditionType = "TopologySpreadSatisfied"
+	// KubeletConfigAppliedConditionType reflects whether the kubelet of the node runs with the kubeletConfig of the machine
+	KubeletConfigAppliedConditionType ConditionType = "KubeletConfigApplied"
+)
+
+// Condition describes the state of a machine at a certain point
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// ProviderStatus contains machine-controller specific information about a machine.
+// It is stored in .status.providerStatus of the machine.
+type ProviderStatus struct {
+	// ControllerVersion is the version of the machine-controller which provisioned the node
+	ControllerVersion string `json:"controllerVersion,omitempty"`
+	// UserDataPluginVersion is the version of the userdata plugin which rendered the userdata of the instance
+	UserDataPluginVersion string `json:"userDataPluginVersion,omitempty"`
+	// UserDataSecretVersions are the resource versions of the secrets referenced by the provider spec, keyed by
+	// namespace/name, at the time the userdata of the instance got rendered
+	UserDataSecretVersions map[string]string `json:"userDataSecretVersions,omitempty"`
+	// UserDataKubeletConfig are the kubelet flags of the kubeletConfig the userdata of the instance got rendered with.
+	// It is empty if the operating system does not support the kubelet config and nil for older machines
+	UserDataKubeletConfig *string `json:"userDataKubeletConfig,omitempty"`
+	// Conditions describe the current state of the machine
+	Conditions []Condition `json:"conditions,omitempty"`
+	// LastProviderError is the most recent failed call to the cloud provider. It gets removed once a call succeeds.
+	LastProviderError *ProviderError `json:"lastProviderError,omitempty"`
+	// NodeClientCertificateExpiration is the expiration of the newest kubelet client certificate issued to the node
+	NodeClientCertificateExpiration *metav1.Time `json:"nodeClientCertificateExpiration,omitempty"`
+	// InstanceType is the instance type the instance got launched with, which differs from the requested one
+	// if the cloud provider fell back to another instance type
+	InstanceType string `json:"instanceType,omitempty"`
+	// DrainStatus is the progress of the most recent drain of the node
+	DrainStatus *DrainStatus `json:"drainStatus,omitempty"`
+	// Identity is the IAM role, service account or managed identity the instance is bound to
+	Identity string `json:"identity,omitempty"`
+}
+
+// DrainStatus describes the progress of the drain of a node
+type DrainStatus struct {
+	// PodsEvicted is the number of pods which got evicted by the current attempt to drain the node
+	PodsEvicted int `json:"podsEvicted"`
+	// PodsRemaining is the number of pods which still need to be evicted
+	PodsRemaining int `json:"podsRemaining"`
+	// BlockingPod is the pod the drain currently waits for as namespace/name
+	BlockingPod string `json:"blockingPod,omitempty"`
+	// LastUpdateTime is when the progress got recorded
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// ProviderError describes a failed call to the cloud provider
+type ProviderError struct {
+	// Operation is the failed call, one of create, get or delete
+	Operation string `json:"operation"`
+	// Reason is the error code returned by the cloud provider, if any
+	Reason string `json:"reason,omitempty"`
+	// Message is the error returned by the cloud provider
+	Message string `json:"message"`
+	// LastTransitionTime is when the error first occurred
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// SetProviderError records the given error. The LastTransitionTime only gets changed if the error changes.
+func (s *ProviderStatus) SetProviderError(providerError ProviderError) {
+	if existing := s.LastProviderError; existing != nil &&
+		existing.Operation == providerError.Operation &&
+		existing.Reason == providerError.Reason &&
+		existing.Message == providerError.Message {
+		return
+	}
+	if providerError.LastTransitionTime.IsZero() {
+		providerError.LastTransitionTime = metav1.Now()
+	}
+	s.LastProviderError = &providerError
+}
+
+// GetProviderStatus parses the given providerStatus. An empty ProviderStatus gets returned if it's not set.
+func GetProviderStatus(providerStatus *runtime.RawExtension) (*ProviderStatus, error) {
+	status := &ProviderStatus{}
+	if providerStatus == nil || len(providerStatus.Raw) == 0 {
+		return status, nil
+	}
+	if err := json.Unmarshal(providerStatus.Raw, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// RawExtension returns the ProviderStatus as raw data.
+func (s *ProviderStatus) RawExtension() (*runtime.RawExtension, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.RawExtension{Raw: b}, nil
+}
+
+// GetCondition returns the condition with the given type or nil if it doesn't exist.
+func (s *ProviderStatus) GetCondition(conditionType ConditionType) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the given condition. The LastTransitionTime only gets changed if the status changes.
+func (s *ProviderStatus) SetCondition(condition Condition) {
+	existing := s.GetCondition(condition.Type)
+	if existing == nil {
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		s.Conditions = append(s.Conditions, condition)
+		return
+	}
+	if existing.Status != condition.Status {
+		existing.Status = condition.Status
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+}
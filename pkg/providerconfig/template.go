@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const templateRefKey = "templateRef"
+
+// ProviderConfigTemplateResource is the resource of the ProviderConfigTemplates. Their spec.value is a provider
+// config, which gets shared by all machines referencing the template
+var ProviderConfigTemplateResource = schema.GroupVersionResource{
+	Group:    "machine-controller.kubermatic.io",
+	Version:  "v1alpha1",
+	Resource: "providerconfigtemplates",
+}
+
+// TemplateRef references a ProviderConfigTemplate in the namespace of the machine
+type TemplateRef struct {
+	Name string `json:"name"`
+}
+
+// TemplateResolver resolves the provider configs which reference a ProviderConfigTemplate
+type TemplateResolver struct {
+	getTemplate func(namespace, name string) ([]byte, error)
+}
+
+// NewTemplateInformer returns an informer for the ProviderConfigTemplates of all namespaces
+func NewTemplateInformer(client dynamic.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	templates := client.Resource(ProviderConfigTemplateResource)
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return templates.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return templates.Watch(options)
+			},
+		},
+		&unstructured.Unstructured{},
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
+
+// NewTemplateResolver returns a TemplateResolver which gets the ProviderConfigTemplates from the cache of the informer
+func NewTemplateResolver(informer cache.SharedIndexInformer) *TemplateResolver {
+	return &TemplateResolver{
+		getTemplate: func(namespace, name string) ([]byte, error) {
+			obj, exists, err := informer.GetIndexer().GetByKey(namespace + "/" + name)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, kerrors.NewNotFound(ProviderConfigTemplateResource.GroupResource(), name)
+			}
+			template, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object of type %T", obj)
+			}
+			value, found, err := unstructured.NestedFieldNoCopy(template.Object, "spec", "value")
+			if err != nil {
+				return nil, fmt.Errorf("invalid spec.value: %v", err)
+			}
+			if !found {
+				return nil, errors.New("spec.value is missing")
+			}
+			return json.Marshal(value)
+		},
+	}
+}
+
+// Resolve returns the given providerSpec with its config merged on top of the referenced ProviderConfigTemplate
+// of the namespace. The config gets applied as a JSON merge patch: objects get merged, all other values including
+// lists replace the ones of the template and null removes them. A providerSpec without a template reference gets
+// returned unchanged
+func (r *TemplateResolver) Resolve(namespace string, spec clusterv1alpha1.ProviderSpec) (clusterv1alpha1.ProviderSpec, error) {
+	ref, overrides, err := splitTemplateRef(spec)
+	if err != nil || ref == nil {
+		return spec, err
+	}
+	if r == nil {
+		return spec, fmt.Errorf("can not resolve ProviderConfigTemplate %s, templates are not supported here", ref.Name)
+	}
+
+	template, err := r.getTemplate(namespace, ref.Name)
+	if err != nil {
+		return spec, fmt.Errorf("failed to get ProviderConfigTemplate %s/%s: %v", namespace, ref.Name, err)
+	}
+	merged, err := mergeTemplate(template, overrides)
+	if err != nil {
+		return spec, fmt.Errorf("invalid provider config of ProviderConfigTemplate %s/%s: %v", namespace, ref.Name, err)
+	}
+
+	resolved := *spec.DeepCopy()
+	resolved.Value = &runtime.RawExtension{Raw: merged}
+	return resolved, nil
+}
+
+// HasTemplateRef returns true if the providerSpec references a ProviderConfigTemplate
+func HasTemplateRef(spec clusterv1alpha1.ProviderSpec) bool {
+	ref, _, err := splitTemplateRef(spec)
+	return err == nil && ref != nil
+}
+
+// splitTemplateRef returns the template reference of the providerSpec and its config without the reference.
+// The reference is nil if the providerSpec references no template
+func splitTemplateRef(spec clusterv1alpha1.ProviderSpec) (*TemplateRef, []byte, error) {
+	if spec.Value == nil {
+		return nil, nil, nil
+	}
+	config := map[string]json.RawMessage{}
+	if err := json.Unmarshal(spec.Value.Raw, &config); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal providerSpec.value: %v", err)
+	}
+	rawRef, ok := config[templateRefKey]
+	if !ok {
+		return nil, nil, nil
+	}
+	ref := &TemplateRef{}
+	if err := json.Unmarshal(rawRef, ref); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal providerSpec.value.%s: %v", templateRefKey, err)
+	}
+	if ref.Name == "" {
+		return nil, nil, fmt.Errorf("providerSpec.value.%s.name is missing", templateRefKey)
+	}
+	delete(config, templateRefKey)
+	overrides, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, overrides, nil
+}
+
+// mergeTemplate applies the overrides to the template and validates the merged config
+func mergeTemplate(template, overrides []byte) ([]byte, error) {
+	templateConfig := map[string]json.RawMessage{}
+	if err := json.Unmarshal(template, &templateConfig); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the template: %v", err)
+	}
+	if _, ok := templateConfig[templateRefKey]; ok {
+		return nil, errors.New("templates must not reference other templates")
+	}
+
+	merged, err := jsonpatch.MergePatch(template, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge the overrides: %v", err)
+	}
+	config := Config{}
+	if err := json.Unmarshal(merged, &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the merged config: %v", err)
+	}
+	if config.CloudProvider == "" {
+		return nil, errors.New("cloudProvider is missing")
+	}
+	if config.OperatingSystem == "" {
+		return nil, errors.New("operatingSystem is missing")
+	}
+	return merged, nil
+}
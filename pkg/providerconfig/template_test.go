@@ -0,0 +1,189 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const testTemplate = `{
+  "sshPublicKeys": ["ssh-rsa AAAA"],
+  "cloudProvider": "hetzner",
+  "cloudProviderSpec": {"token": {"secretKeyRef": {"namespace": "kube-system", "name": "hetzner", "key": "token"}}, "serverType": "cx21", "location": "fsn1"},
+  "operatingSystem": "ubuntu",
+  "operatingSystemSpec": {"distUpgradeOnBoot": false, "disableAutoUpdate": true}
+}`
+
+func TestTemplateResolverResolve(t *testing.T) {
+	templates := map[string]string{
+		"hetzner":   testTemplate,
+		"nested":    `{"templateRef": {"name": "hetzner"}}`,
+		"no-os":     `{"cloudProvider": "hetzner"}`,
+		"malformed": `{"cloudProvider": `,
+	}
+	resolver := &TemplateResolver{
+		getTemplate: func(namespace, name string) ([]byte, error) {
+			if template, ok := templates[name]; ok && namespace == "kube-system" {
+				return []byte(template), nil
+			}
+			return nil, errors.New("not found")
+		},
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "no template reference",
+			value:    `{"cloudProvider": "aws", "operatingSystem": "ubuntu"}`,
+			expected: `{"cloudProvider": "aws", "operatingSystem": "ubuntu"}`,
+		},
+		{
+			name:     "template without overrides",
+			value:    `{"templateRef": {"name": "hetzner"}}`,
+			expected: testTemplate,
+		},
+		{
+			name: "template with overrides",
+			value: `{
+			  "templateRef": {"name": "hetzner"},
+			  "sshPublicKeys": ["ssh-rsa BBBB"],
+			  "cloudProviderSpec": {"serverType": "cx41", "location": null},
+			  "operatingSystemSpec": {"distUpgradeOnBoot": true}
+			}`,
+			expected: `{
+			  "sshPublicKeys": ["ssh-rsa BBBB"],
+			  "cloudProvider": "hetzner",
+			  "cloudProviderSpec": {"token": {"secretKeyRef": {"namespace": "kube-system", "name": "hetzner", "key": "token"}}, "serverType": "cx41"},
+			  "operatingSystem": "ubuntu",
+			  "operatingSystemSpec": {"distUpgradeOnBoot": true, "disableAutoUpdate": true}
+			}`,
+		},
+		{
+			name:    "template reference without name",
+			value:   `{"templateRef": {}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing template",
+			value:   `{"templateRef": {"name": "missing"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "template referencing another template",
+			value:   `{"templateRef": {"name": "nested"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed template",
+			value:   `{"templateRef": {"name": "malformed"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "merged config without operating system",
+			value:   `{"templateRef": {"name": "no-os"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "overrides removing the cloud provider",
+			value:   `{"templateRef": {"name": "hetzner"}, "cloudProvider": null}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(test.value)}}
+			resolved, err := resolver.Resolve("kube-system", spec)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			var actual, expected interface{}
+			if err := json.Unmarshal(resolved.Value.Raw, &actual); err != nil {
+				t.Fatalf("failed to unmarshal the resolved config: %v", err)
+			}
+			if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+				t.Fatalf("failed to unmarshal the expected config: %v", err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected config:\n%s\ngot:\n%s", test.expected, resolved.Value.Raw)
+			}
+		})
+	}
+}
+
+func TestTemplateResolverResolveWithoutResolver(t *testing.T) {
+	var resolver *TemplateResolver
+
+	spec := clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{"cloudProvider": "aws"}`)}}
+	if _, err := resolver.Resolve("kube-system", spec); err != nil {
+		t.Errorf("expected a config without template reference to resolve, got: %v", err)
+	}
+
+	spec = clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{"templateRef": {"name": "hetzner"}}`)}}
+	if _, err := resolver.Resolve("kube-system", spec); err == nil {
+		t.Error("expected an error for a config with template reference")
+	}
+}
+
+func TestTemplateResolverGetsTemplatesFromInformer(t *testing.T) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	template := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(`{"metadata": {"namespace": "kube-system", "name": "hetzner"}, "spec": {"value": `+testTemplate+`}}`), &template.Object); err != nil {
+		t.Fatalf("failed to unmarshal template: %v", err)
+	}
+	if err := informer.GetIndexer().Add(template); err != nil {
+		t.Fatalf("failed to add template: %v", err)
+	}
+	resolver := NewTemplateResolver(informer)
+
+	value, err := resolver.getTemplate("kube-system", "hetzner")
+	if err != nil {
+		t.Fatalf("failed to get template: %v", err)
+	}
+	var actual, expected interface{}
+	if err := json.Unmarshal(value, &actual); err != nil {
+		t.Fatalf("failed to unmarshal the template value: %v", err)
+	}
+	if err := json.Unmarshal([]byte(testTemplate), &expected); err != nil {
+		t.Fatalf("failed to unmarshal the expected value: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected template value:\n%s\ngot:\n%s", testTemplate, value)
+	}
+
+	if _, err := resolver.getTemplate("default", "hetzner"); !kerrors.IsNotFound(err) {
+		t.Errorf("expected a not found error for a template of another namespace, got: %v", err)
+	}
+}
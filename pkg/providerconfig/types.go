@@ -39,9 +39,11 @@ var (
 type OperatingSystem string
 
 const (
-	OperatingSystemCoreos OperatingSystem = "coreos"
-	OperatingSystemUbuntu OperatingSystem = "ubuntu"
-	OperatingSystemCentOS OperatingSystem = "centos"
+	OperatingSystemCoreos       OperatingSystem = "coreos"
+	OperatingSystemUbuntu       OperatingSystem = "ubuntu"
+	OperatingSystemCentOS       OperatingSystem = "centos"
+	OperatingSystemWindows      OperatingSystem = "windows"
+	OperatingSystemBottlerocket OperatingSystem = "bottlerocket"
 )
 
 type CloudProvider string
@@ -58,6 +60,7 @@ const (
 	CloudProviderVsphere      CloudProvider = "vsphere"
 	CloudProviderFake         CloudProvider = "fake"
 	CloudProviderKubeVirt     CloudProvider = "kubevirt"
+	CloudProviderOCI          CloudProvider = "oci"
 )
 
 // DNSConfig contains a machine's DNS configuration
@@ -86,6 +89,30 @@ type Config struct {
 
 	// +optional
 	OverwriteCloudConfig *string `json:"overwriteCloudConfig,omitempty"`
+
+	// ClusterDNS overrides the DNS server IPs the kubelet configures for pods, which default to the
+	// -cluster-dns flag of the controller
+	// +optional
+	ClusterDNS []string `json:"clusterDNS,omitempty"`
+
+	// ClusterDomain overrides the DNS domain of the cluster the kubelet configures for pods, which
+	// defaults to cluster.local
+	// +optional
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+
+	// WarmPool keeps stopped instances for the MachineDeployment, which get started instead of
+	// creating fresh ones on scale-up
+	// +optional
+	WarmPool *WarmPool `json:"warmPool,omitempty"`
+
+	// KubeletConfig holds kubelet settings which can be changed on existing machines, see KubeletConfig
+	// +optional
+	KubeletConfig *KubeletConfig `json:"kubeletConfig,omitempty"`
+
+	// TemplateRef references a ProviderConfigTemplate the config gets merged on top of. It is only
+	// set on unresolved configs, see TemplateResolver
+	// +optional
+	TemplateRef *TemplateRef `json:"templateRef,omitempty"`
 }
 
 // GlobaObjectKeySelector is needed as we can not use v1.SecretKeySelector
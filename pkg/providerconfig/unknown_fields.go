@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// UnknownFields returns the paths of all fields in raw which json.Unmarshal would silently drop when decoding
+// into the type of into. Values of types with a custom UnmarshalJSON, like ConfigVarString or
+// runtime.RawExtension, are not inspected.
+func UnknownFields(raw []byte, into interface{}) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode: %v", err)
+	}
+
+	var unknown []string
+	collectUnknownFields(value, reflect.TypeOf(into), "", &unknown)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+func collectUnknownFields(value interface{}, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		jsonFields(t, fields)
+		for key, fieldValue := range object {
+			fieldType, found := lookupJSONField(fields, key)
+			if !found {
+				*unknown = append(*unknown, joinFieldPath(path, key))
+				continue
+			}
+			collectUnknownFields(fieldValue, fieldType, joinFieldPath(path, key), unknown)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, entry := range object {
+			collectUnknownFields(entry, t.Elem(), joinFieldPath(path, key), unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, entry := range list {
+			collectUnknownFields(entry, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	}
+}
+
+// jsonFields adds the JSON names of the exported fields of the struct type t, including the ones of embedded structs
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			jsonFields(fieldType, fields)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+}
+
+// lookupJSONField finds the field for key the way encoding/json does, preferring an exact match over a
+// case-insensitive one
+func lookupJSONField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if fieldType, found := fields[key]; found {
+		return fieldType, true
+	}
+	for name, fieldType := range fields {
+		if strings.EqualFold(name, key) {
+			return fieldType, true
+		}
+	}
+	return nil, false
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		into     interface{}
+		expected []string
+	}{
+		{
+			name: "known fields only",
+			raw:  `{"cloudProvider": "aws", "cloudProviderSpec": {"anything": true}, "operatingSystem": "ubuntu", "sshPublicKeys": ["ssh-rsa AAAA"]}`,
+		},
+		{
+			name: "field names are matched case-insensitively like encoding/json does",
+			raw:  `{"CloudProvider": "aws"}`,
+		},
+		{
+			name:     "unknown top level field",
+			raw:      `{"cloudProvider": "aws", "cloudProviderSpecs": {}}`,
+			expected: []string{"cloudProviderSpecs"},
+		},
+		{
+			name:     "unknown nested fields",
+			raw:      `{"network": {"cidr": "10.0.0.2/24", "dns": {"servers": ["10.0.0.1"], "search": ["local"]}, "mtu": 9000}}`,
+			expected: []string{"network.dns.search", "network.mtu"},
+		},
+		{
+			name:     "fields of embedded structs",
+			raw:      `{"namespace": "kube-system", "name": "aws", "key": "secretAccessKey", "optional": true}`,
+			into:     GlobalSecretKeySelector{},
+			expected: []string{"optional"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			into := test.into
+			if into == nil {
+				into = Config{}
+			}
+			unknown, err := UnknownFields([]byte(test.raw), into)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(unknown, test.expected) {
+				t.Errorf("expected unknown fields %v, got %v", test.expected, unknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"fmt"
+)
+
+// WarmPool keeps stopped instances for a MachineDeployment. On scale-up a new machine takes over one of them and
+// starts it instead of creating a fresh instance
+type WarmPool struct {
+	// Size is the number of stopped instances the pool keeps
+	Size int `json:"size"`
+}
+
+// warmPoolCloudProviders are the cloud providers which can stop and start instances
+var warmPoolCloudProviders = map[CloudProvider]bool{
+	CloudProviderAWS: true,
+}
+
+// ValidateWarmPool checks the size of the warm pool and if the cloud provider supports it
+func (c *Config) ValidateWarmPool() error {
+	if c.WarmPool == nil {
+		return nil
+	}
+	if c.WarmPool.Size < 0 {
+		return fmt.Errorf("invalid warmPool size %d, must not be negative", c.WarmPool.Size)
+	}
+	if !warmPoolCloudProviders[c.CloudProvider] {
+		return fmt.Errorf("warmPool is not supported on cloud provider %q", c.CloudProvider)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerconfig
+
+import (
+	"testing"
+)
+
+func TestValidateWarmPool(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr bool
+	}{
+		{
+			name:   "no warm pool",
+			config: Config{CloudProvider: CloudProviderHetzner},
+		},
+		{
+			name:   "warm pool on aws",
+			config: Config{CloudProvider: CloudProviderAWS, WarmPool: &WarmPool{Size: 2}},
+		},
+		{
+			name:        "negative size",
+			config:      Config{CloudProvider: CloudProviderAWS, WarmPool: &WarmPool{Size: -1}},
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported cloud provider",
+			config:      Config{CloudProvider: CloudProviderHetzner, WarmPool: &WarmPool{Size: 2}},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.ValidateWarmPool()
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
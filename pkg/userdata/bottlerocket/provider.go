@@ -0,0 +1,248 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// UserData plugin for Bottlerocket.
+//
+
+package bottlerocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
+)
+
+// Provider is a pkg/userdata/plugin.Provider implementation.
+type Provider struct{}
+
+// UserData renders user-data template to string.
+func (p Provider) UserData(
+	spec clusterv1alpha1.MachineSpec,
+	kubeconfig *clientcmdapi.Config,
+	cloudConfig string,
+	cloudProviderName string,
+	clusterDNSIPs []net.IP,
+	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
+) (string, error) {
+
+	funcMap := userdatahelper.TxtFuncMap()
+	funcMap["toml"] = tomlString
+	tmpl, err := template.New("user-data").Funcs(funcMap).Parse(userDataTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse user-data template: %v", err)
+	}
+
+	pconfig, err := providerconfig.GetConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get providerSpec: %v", err)
+	}
+
+	if pconfig.CloudProvider != providerconfig.CloudProviderAWS && pconfig.CloudProvider != providerconfig.CloudProviderVsphere {
+		return "", fmt.Errorf("bottlerocket is not supported on cloud provider %q", pconfig.CloudProvider)
+	}
+
+	if pconfig.Network != nil {
+		return "", errors.New("static IP config is not supported with Bottlerocket")
+	}
+
+	clusterDNSIPs, clusterDomain, err := pconfig.ClusterDNSConfig(clusterDNSIPs)
+	if err != nil {
+		return "", err
+	}
+
+	if len(clusterDNSIPs) == 0 {
+		return "", errors.New("bottlerocket requires a cluster dns ip")
+	}
+
+	bottlerocketConfig, err := LoadConfig(pconfig.OperatingSystemSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get bottlerocket config from provider config: %v", err)
+	}
+
+	var images []string
+	for _, image := range []string{bottlerocketConfig.AdminContainerSource, bottlerocketConfig.ControlContainerSource} {
+		if image != "" {
+			images = append(images, image)
+		}
+	}
+	if err := userdatahelper.ValidateImageReferences(images); err != nil {
+		return "", fmt.Errorf("invalid host container image: %v", err)
+	}
+
+	serverAddr, err := userdatahelper.GetServerAddressFromKubeconfig(kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("error extracting server address from kubeconfig: %v", err)
+	}
+
+	kubernetesCACert, err := userdatahelper.GetCACert(kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("error extracting cacert: %v", err)
+	}
+
+	bootstrapToken, err := getBootstrapToken(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+
+	var adminContainerUserData string
+	if bottlerocketConfig.EnableAdminContainer {
+		if adminContainerUserData, err = adminContainerSSHUserData(pconfig.SSHPublicKeys); err != nil {
+			return "", err
+		}
+	}
+
+	data := struct {
+		MachineSpec            clusterv1alpha1.MachineSpec
+		ProviderSpec           *providerconfig.Config
+		OSConfig               *Config
+		ServerAddr             string
+		KubernetesCACert       string
+		BootstrapToken         string
+		ClusterDNSIPs          []net.IP
+		ClusterDomain          string
+		IsExternal             bool
+		AdminContainerUserData string
+		// SetHostname is true for cloud providers which do not provide the hostname via their metadata
+		SetHostname bool
+	}{
+		MachineSpec:            spec,
+		ProviderSpec:           pconfig,
+		OSConfig:               bottlerocketConfig,
+		ServerAddr:             serverAddr,
+		KubernetesCACert:       base64.StdEncoding.EncodeToString([]byte(kubernetesCACert)),
+		BootstrapToken:         bootstrapToken,
+		ClusterDNSIPs:          clusterDNSIPs,
+		ClusterDomain:          clusterDomain,
+		IsExternal:             externalCloudProvider,
+		AdminContainerUserData: adminContainerUserData,
+		SetHostname:            pconfig.CloudProvider == providerconfig.CloudProviderVsphere,
+	}
+	b := &bytes.Buffer{}
+	err = tmpl.Execute(b, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute user-data template: %v", err)
+	}
+	userdata, err := userdatahelper.CleanupTemplateOutput(b.String())
+	if err != nil {
+		return "", err
+	}
+
+	// EC2 passes the user data as is, while on vSphere it gets read from the guestinfo.userdata
+	// property which can not contain newlines
+	if pconfig.CloudProvider == providerconfig.CloudProviderVsphere {
+		return base64.StdEncoding.EncodeToString([]byte(userdata)), nil
+	}
+	return userdata, nil
+}
+
+// getBootstrapToken returns the token the kubelet uses for the TLS bootstrapping
+func getBootstrapToken(kubeconfig *clientcmdapi.Config) (string, error) {
+	if len(kubeconfig.AuthInfos) != 1 {
+		return "", errors.New("kubeconfig does not contain exactly one user, can not extract bootstrap token")
+	}
+	for _, authInfo := range kubeconfig.AuthInfos {
+		if authInfo.Token == "" {
+			return "", errors.New("kubeconfig does not contain a bootstrap token")
+		}
+		return authInfo.Token, nil
+	}
+	return "", errors.New("no bootstrap token found")
+}
+
+// adminContainerSSHUserData returns the base64 encoded user data of the admin container which
+// configures the authorized ssh keys
+func adminContainerSSHUserData(sshPublicKeys []string) (string, error) {
+	userData := struct {
+		SSH struct {
+			AuthorizedKeys []string `json:"authorized-keys"`
+		} `json:"ssh"`
+	}{}
+	userData.SSH.AuthorizedKeys = sshPublicKeys
+	if userData.SSH.AuthorizedKeys == nil {
+		userData.SSH.AuthorizedKeys = []string{}
+	}
+	b, err := json.Marshal(userData)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal admin container user data: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// tomlString quotes s as a TOML basic string
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// UserData template. Bottlerocket does not use cloud-init, the user data is a TOML document
+// containing the settings which get applied via the Bottlerocket API on boot.
+const userDataTemplate = `[settings.kubernetes]
+api-server = {{ printf "https://%s" .ServerAddr | toml }}
+cluster-certificate = {{ toml .KubernetesCACert }}
+bootstrap-token = {{ toml .BootstrapToken }}
+cluster-dns-ip = {{ index .ClusterDNSIPs 0 | printf "%s" | toml }}
+cluster-domain = {{ toml .ClusterDomain }}
+{{- if .IsExternal }}
+cloud-provider = "external"
+{{- end }}
+
+[settings.host-containers.admin]
+enabled = {{ .OSConfig.EnableAdminContainer }}
+{{- if .OSConfig.AdminContainerSource }}
+source = {{ toml .OSConfig.AdminContainerSource }}
+{{- end }}
+{{- if .AdminContainerUserData }}
+user-data = {{ toml .AdminContainerUserData }}
+{{- end }}
+
+[settings.host-containers.control]
+enabled = {{ not .OSConfig.DisableControlContainer }}
+{{- if .OSConfig.ControlContainerSource }}
+source = {{ toml .OSConfig.ControlContainerSource }}
+{{- end }}
+{{- if .SetHostname }}
+
+[settings.network]
+hostname = {{ toml .MachineSpec.Name }}
+{{- end }}
+`
@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bottlerocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	testhelper "github.com/kubermatic/machine-controller/pkg/test"
+	"github.com/kubermatic/machine-controller/pkg/userdata/cloud"
+)
+
+var (
+	update = flag.Bool("update", false, "update testdata files")
+
+	pemCertificate = `-----BEGIN CERTIFICATE-----
+MIIEWjCCA0KgAwIBAgIJALfRlWsI8YQHMA0GCSqGSIb3DQEBBQUAMHsxCzAJBgNV
+BAYTAlVTMQswCQYDVQQIEwJDQTEWMBQGA1UEBxMNU2FuIEZyYW5jaXNjbzEUMBIG
+A1UEChMLQnJhZGZpdHppbmMxEjAQBgNVBAMTCWxvY2FsaG9zdDEdMBsGCSqGSIb3
+DQEJARYOYnJhZEBkYW5nYS5jb20wHhcNMTQwNzE1MjA0NjA1WhcNMTcwNTA0MjA0
+NjA1WjB7MQswCQYDVQQGEwJVUzELMAkGA1UECBMCQ0ExFjAUBgNVBAcTDVNhbiBG
+cmFuY2lzY28xFDASBgNVBAoTC0JyYWRmaXR6aW5jMRIwEAYDVQQDEwlsb2NhbGhv
+c3QxHTAbBgkqhkiG9w0BCQEWDmJyYWRAZGFuZ2EuY29tMIIBIjANBgkqhkiG9w0B
+AQEFAAOCAQ8AMIIBCgKCAQEAt5fAjp4fTcekWUTfzsp0kyih1OYbsGL0KX1eRbSS
+R8Od0+9Q62Hyny+GFwMTb4A/KU8mssoHvcceSAAbwfbxFK/+s51TobqUnORZrOoT
+ZjkUygbyXDSK99YBbcR1Pip8vwMTm4XKuLtCigeBBdjjAQdgUO28LENGlsMnmeYk
+JfODVGnVmr5Ltb9ANA8IKyTfsnHJ4iOCS/PlPbUj2q7YnoVLposUBMlgUb/CykX3
+mOoLb4yJJQyA/iST6ZxiIEj36D4yWZ5lg7YJl+UiiBQHGCnPdGyipqV06ex0heYW
+caiW8LWZSUQ93jQ+WVCH8hT7DQO1dmsvUmXlq/JeAlwQ/QIDAQABo4HgMIHdMB0G
+A1UdDgQWBBRcAROthS4P4U7vTfjByC569R7E6DCBrQYDVR0jBIGlMIGigBRcAROt
+hS4P4U7vTfjByC569R7E6KF/pH0wezELMAkGA1UEBhMCVVMxCzAJBgNVBAgTAkNB
+MRYwFAYDVQQHEw1TYW4gRnJhbmNpc2NvMRQwEgYDVQQKEwtCcmFkZml0emluYzES
+MBAGA1UEAxMJbG9jYWxob3N0MR0wGwYJKoZIhvcNAQkBFg5icmFkQGRhbmdhLmNv
+bYIJALfRlWsI8YQHMAwGA1UdEwQFMAMBAf8wDQYJKoZIhvcNAQEFBQADggEBAG6h
+U9f9sNH0/6oBbGGy2EVU0UgITUQIrFWo9rFkrW5k/XkDjQm+3lzjT0iGR4IxE/Ao
+eU6sQhua7wrWeFEn47GL98lnCsJdD7oZNhFmQ95Tb/LnDUjs5Yj9brP0NWzXfYU4
+UK2ZnINJRcJpB8iRCaCxE8DdcUF0XqIEq6pA272snoLmiXLMvNl3kYEdm+je6voD
+58SNVEUsztzQyXmJEhCpwVI0A6QCjzXj+qvpmw3ZZHi8JwXei8ZZBLTSFBki8Z7n
+sH9BBH38/SzUmAN4QHSPy1gjqm00OAE8NaYDkh/bzE4d7mLGGMWp/WE3KPSu82HF
+kPe6XoSbiLm/kxk32T0=
+-----END CERTIFICATE-----`
+
+	kubeconfig = &clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"": {
+				Server:                   "https://server:443",
+				CertificateAuthorityData: []byte(pemCertificate),
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"": {
+				Token: "my-token",
+			},
+		},
+	}
+)
+
+// fakeCloudConfigProvider simulates cloud config provider for test.
+type fakeCloudConfigProvider struct {
+	config string
+	name   string
+	err    error
+}
+
+func (p *fakeCloudConfigProvider) GetCloudConfig(spec clusterv1alpha1.MachineSpec) (config string, name string, err error) {
+	return p.config, p.name, p.err
+}
+
+// userDataTestCase contains the data for a table-driven test.
+type userDataTestCase struct {
+	name                  string
+	spec                  clusterv1alpha1.MachineSpec
+	ccProvider            cloud.ConfigProvider
+	osConfig              *Config
+	providerSpec          *providerconfig.Config
+	DNSIPs                []net.IP
+	externalCloudProvider bool
+	phoneHome             *plugin.PhoneHome
+}
+
+func defaultProviderSpec() *providerconfig.Config {
+	return &providerconfig.Config{
+		CloudProvider: "aws",
+		SSHPublicKeys: []string{"ssh-rsa AAABBB", "ssh-rsa CCCDDD"},
+	}
+}
+
+func defaultSpec() clusterv1alpha1.MachineSpec {
+	return clusterv1alpha1.MachineSpec{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Versions: clusterv1alpha1.MachineVersionInfo{
+			Kubelet: "1.19.8",
+		},
+	}
+}
+
+// TestUserDataGeneration runs the data generation for different
+// environments.
+func TestUserDataGeneration(t *testing.T) {
+	t.Parallel()
+
+	tests := []userDataTestCase{
+		{
+			name:         "aws",
+			providerSpec: defaultProviderSpec(),
+			spec:         defaultSpec(),
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "aws",
+				config: "{aws-config:true}",
+			},
+			DNSIPs:   []net.IP{net.ParseIP("10.10.10.10")},
+			osConfig: &Config{},
+		},
+		{
+			name:         "aws-admin-container",
+			providerSpec: defaultProviderSpec(),
+			spec:         defaultSpec(),
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "aws",
+				config: "{aws-config:true}",
+			},
+			DNSIPs:                []net.IP{net.ParseIP("10.10.10.10")},
+			externalCloudProvider: true,
+			osConfig: &Config{
+				EnableAdminContainer:    true,
+				AdminContainerSource:    "public.ecr.aws/bottlerocket/bottlerocket-admin:v0.7.0",
+				DisableControlContainer: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := renderUserData(test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			goldenName := test.name + ".toml"
+			testhelper.CompareOutput(t, goldenName, s, *update)
+		})
+	}
+}
+
+func TestUserDataKubernetesSettings(t *testing.T) {
+	test := userDataTestCase{
+		providerSpec: defaultProviderSpec(),
+		spec:         defaultSpec(),
+		ccProvider:   &fakeCloudConfigProvider{name: "aws"},
+		DNSIPs:       []net.IP{net.ParseIP("10.10.10.10")},
+		osConfig:     &Config{},
+	}
+	s, err := renderUserData(test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSettings := []string{
+		"[settings.kubernetes]",
+		`api-server = "https://server:443"`,
+		fmt.Sprintf("cluster-certificate = %q", base64.StdEncoding.EncodeToString([]byte(pemCertificate))),
+		`bootstrap-token = "my-token"`,
+		`cluster-dns-ip = "10.10.10.10"`,
+		"[settings.host-containers.admin]",
+		"[settings.host-containers.control]",
+	}
+	for _, setting := range expectedSettings {
+		if !strings.Contains(s, setting) {
+			t.Errorf("expected user data to contain %q, got:\n%s", setting, s)
+		}
+	}
+}
+
+func TestUserDataVsphereIsEncoded(t *testing.T) {
+	test := userDataTestCase{
+		providerSpec: &providerconfig.Config{CloudProvider: "vsphere"},
+		spec:         defaultSpec(),
+		ccProvider:   &fakeCloudConfigProvider{name: "vsphere"},
+		DNSIPs:       []net.IP{net.ParseIP("10.10.10.10")},
+		osConfig:     &Config{},
+	}
+	s, err := renderUserData(test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("expected base64 encoded user data: %v", err)
+	}
+	if !strings.Contains(string(decoded), `hostname = "node1"`) {
+		t.Errorf("expected the hostname to be set on vsphere, got:\n%s", decoded)
+	}
+}
+
+func TestUserDataValidation(t *testing.T) {
+	tests := []userDataTestCase{
+		{
+			name: "unsupported cloud provider",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "hetzner",
+			},
+			spec:   defaultSpec(),
+			DNSIPs: []net.IP{net.ParseIP("10.10.10.10")},
+		},
+		{
+			name:         "no cluster dns ip",
+			providerSpec: defaultProviderSpec(),
+			spec:         defaultSpec(),
+		},
+		{
+			name:         "invalid admin container image",
+			providerSpec: defaultProviderSpec(),
+			spec:         defaultSpec(),
+			DNSIPs:       []net.IP{net.ParseIP("10.10.10.10")},
+			osConfig: &Config{
+				AdminContainerSource: "Invalid Image!",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.ccProvider = &fakeCloudConfigProvider{}
+			if _, err := renderUserData(test); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func renderUserData(test userDataTestCase) (string, error) {
+	spec := test.spec
+	rProviderSpec := test.providerSpec
+	osConfigByte, err := json.Marshal(test.osConfig)
+	if err != nil {
+		return "", err
+	}
+	rProviderSpec.OperatingSystemSpec = runtime.RawExtension{
+		Raw: osConfigByte,
+	}
+
+	providerSpecRaw, err := json.Marshal(rProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	spec.ProviderSpec = clusterv1alpha1.ProviderSpec{
+		Value: &runtime.RawExtension{
+			Raw: providerSpecRaw,
+		},
+	}
+	provider := Provider{}
+
+	cloudConfig, cloudProviderName, err := test.ccProvider.GetCloudConfig(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cloud config: %v", err)
+	}
+
+	return provider.UserData(spec, kubeconfig, cloudConfig, cloudProviderName, test.DNSIPs, test.externalCloudProvider, test.phoneHome)
+}
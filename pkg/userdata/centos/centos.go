@@ -20,11 +20,66 @@ import (
 	"encoding/json"
 
 	"k8s.io/apimachinery/pkg/runtime"
+
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
 
 // Config contains specific configuration for CentOS.
 type Config struct {
-	DistUpgradeOnBoot bool `json:"distUpgradeOnBoot"`
+	DistUpgradeOnBoot bool                       `json:"distUpgradeOnBoot"`
+	PowerState        *userdatahelper.PowerState `json:"powerState,omitempty"`
+	// PrewarmImages are pulled with retries before the kubelet starts, so they are already present once the first
+	// pods get scheduled
+	PrewarmImages []string `json:"prewarmImages,omitempty"`
+	// KernelParameters get appended to the kernel command line. The node reboots once to apply them
+	KernelParameters []string `json:"kernelParameters,omitempty"`
+	// AuditLogging installs auditd and loads the given ruleset
+	AuditLogging *userdatahelper.AuditLogging `json:"auditLogging,omitempty"`
+	// Multipath installs multipathd with the given multipath.conf
+	Multipath *userdatahelper.Multipath `json:"multipath,omitempty"`
+	// ISCSIInitiatorName is the iSCSI qualified name the node uses to log into iSCSI targets
+	ISCSIInitiatorName string `json:"iscsiInitiatorName,omitempty"`
+	// NodeLocalDNS prepares the node for the node-local-dns cache DaemonSet
+	NodeLocalDNS *userdatahelper.NodeLocalDNS `json:"nodeLocalDNS,omitempty"`
+	// StorageInterface sets the MTU and routes of the network interface which carries the storage traffic
+	StorageInterface *userdatahelper.StorageInterface `json:"storageInterface,omitempty"`
+	// InstanceStore formats and mounts the NVMe instance-store disks on every boot
+	InstanceStore *userdatahelper.InstanceStore `json:"instanceStore,omitempty"`
+	// PackageUpgrade upgrades the installed packages during bootstrap. This may change the kernel,
+	// so it is disabled unless set
+	PackageUpgrade *userdatahelper.PackageUpgrade `json:"packageUpgrade,omitempty"`
+	// DefaultUser replaces the default user of the image, e.g. if the image does not use the distribution default
+	DefaultUser *userdatahelper.DefaultUser `json:"defaultUser,omitempty"`
+	// SELinux sets the SELinux mode and booleans. SELinux is permissive unless set
+	SELinux *userdatahelper.SELinux `json:"selinux,omitempty"`
+	// Chrony configures chrony as NTP client of the given servers and optionally as NTP server for peers
+	Chrony *userdatahelper.Chrony `json:"chrony,omitempty"`
+	// LocalResolver runs dnsmasq or unbound as caching DNS resolver of the node and points /etc/resolv.conf at it
+	LocalResolver *userdatahelper.LocalResolver `json:"localResolver,omitempty"`
+	// MachineID replaces the machine-id of the image on the first boot, e.g. if instances get cloned from one image
+	MachineID *userdatahelper.MachineID `json:"machineID,omitempty"`
+	// NodeRegistration registers the node with an external inventory like a CMDB on boot and deregisters it on shutdown
+	NodeRegistration *userdatahelper.NodeRegistration `json:"nodeRegistration,omitempty"`
+	// KubeletWatchdog restarts the kubelet or reboots the node after repeated failed health checks of the kubelet
+	KubeletWatchdog *userdatahelper.KubeletWatchdog `json:"kubeletWatchdog,omitempty"`
+	// NodeExporter installs the Prometheus node_exporter and runs it as systemd service
+	NodeExporter *userdatahelper.NodeExporter `json:"nodeExporter,omitempty"`
+	// Conntrack sizes the conntrack table for the memory of the node and loads the kernel modules kube-proxy needs
+	Conntrack *userdatahelper.Conntrack `json:"conntrack,omitempty"`
+	// Timezone of the node as name of the tz database, e.g. Europe/Berlin. Defaults to the timezone of the image
+	Timezone string `json:"timezone,omitempty"`
+	// StaticPods get written to the pod manifest path of the kubelet, which runs them without an API server
+	StaticPods []userdatahelper.StaticPod `json:"staticPods,omitempty"`
+	// PersistentJournal makes journald keep its logs in /var/log/journal, so they survive reboots
+	PersistentJournal *userdatahelper.PersistentJournal `json:"persistentJournal,omitempty"`
+	// ContainerRuntime is the container runtime which gets installed, either docker or containerd. Defaults to docker
+	ContainerRuntime string `json:"containerRuntime,omitempty"`
+	// ImagePulls sets how many images the container runtime and the kubelet pull at the same time, e.g. to speed up
+	// large rollouts
+	ImagePulls *userdatahelper.ImagePulls `json:"imagePulls,omitempty"`
+	// Profile applies a curated bundle of kubelet and docker settings for the kind of node pool, either
+	// general, gpu or storage. Defaults to general, which keeps the defaults
+	Profile string `json:"profile,omitempty"`
 }
 
 // LoadConfig retrieves the CentOS configuration from raw data.
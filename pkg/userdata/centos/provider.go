@@ -32,6 +32,7 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
@@ -47,6 +48,7 @@ func (p Provider) UserData(
 	cloudProviderName string,
 	clusterDNSIPs []net.IP,
 	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
 ) (string, error) {
 	tmpl, err := template.New("user-data").Funcs(userdatahelper.TxtFuncMap()).Parse(userDataTemplate)
 	if err != nil {
@@ -71,11 +73,124 @@ func (p Provider) UserData(
 		return "", errors.New("static IP config is not supported with CentOS")
 	}
 
+	clusterDNSIPs, clusterDomain, err := pconfig.ClusterDNSConfig(clusterDNSIPs)
+	if err != nil {
+		return "", err
+	}
+
+	if err := pconfig.ValidateKubeletConfig(); err != nil {
+		return "", err
+	}
+
 	centosConfig, err := LoadConfig(pconfig.OperatingSystemSpec)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse OperatingSystemSpec: '%v'", err)
 	}
 
+	if err := centosConfig.PowerState.Validate(); err != nil {
+		return "", fmt.Errorf("invalid power state: %v", err)
+	}
+
+	if err := userdatahelper.ValidateImageReferences(centosConfig.PrewarmImages); err != nil {
+		return "", fmt.Errorf("invalid prewarm images: %v", err)
+	}
+
+	if err := userdatahelper.ValidateKernelParameters(centosConfig.KernelParameters); err != nil {
+		return "", err
+	}
+
+	if err := centosConfig.AuditLogging.Validate(); err != nil {
+		return "", fmt.Errorf("invalid audit logging config: %v", err)
+	}
+
+	if err := centosConfig.Multipath.Validate(); err != nil {
+		return "", fmt.Errorf("invalid multipath config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateISCSIInitiatorName(centosConfig.ISCSIInitiatorName); err != nil {
+		return "", err
+	}
+
+	if err := centosConfig.NodeLocalDNS.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node-local DNS config: %v", err)
+	}
+
+	if err := centosConfig.StorageInterface.Validate(); err != nil {
+		return "", fmt.Errorf("invalid storage interface config: %v", err)
+	}
+
+	if err := centosConfig.InstanceStore.Validate(); err != nil {
+		return "", fmt.Errorf("invalid instance-store config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateNodeProfile(centosConfig.Profile); err != nil {
+		return "", err
+	}
+
+	if err := centosConfig.PackageUpgrade.Validate(); err != nil {
+		return "", fmt.Errorf("invalid package upgrade config: %v", err)
+	}
+
+	if err := centosConfig.DefaultUser.Validate(); err != nil {
+		return "", fmt.Errorf("invalid default user config: %v", err)
+	}
+
+	if err := centosConfig.SELinux.Validate(); err != nil {
+		return "", fmt.Errorf("invalid SELinux config: %v", err)
+	}
+
+	if err := centosConfig.Chrony.Validate(); err != nil {
+		return "", fmt.Errorf("invalid chrony config: %v", err)
+	}
+
+	if err := centosConfig.LocalResolver.Validate(); err != nil {
+		return "", fmt.Errorf("invalid local resolver config: %v", err)
+	}
+
+	if err := centosConfig.MachineID.Validate(); err != nil {
+		return "", fmt.Errorf("invalid machine-id config: %v", err)
+	}
+
+	if err := centosConfig.NodeRegistration.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node registration config: %v", err)
+	}
+
+	if err := centosConfig.KubeletWatchdog.Validate(); err != nil {
+		return "", fmt.Errorf("invalid kubelet watchdog config: %v", err)
+	}
+
+	if err := centosConfig.NodeExporter.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node exporter config: %v", err)
+	}
+
+	if err := centosConfig.Conntrack.Validate(); err != nil {
+		return "", fmt.Errorf("invalid conntrack config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateTimezone(centosConfig.Timezone); err != nil {
+		return "", err
+	}
+
+	if err := userdatahelper.ValidateStaticPods(centosConfig.StaticPods); err != nil {
+		return "", fmt.Errorf("invalid static pods: %v", err)
+	}
+
+	if err := centosConfig.PersistentJournal.Validate(); err != nil {
+		return "", fmt.Errorf("invalid persistent journal config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateContainerRuntime(centosConfig.ContainerRuntime); err != nil {
+		return "", err
+	}
+	containerRuntime := centosConfig.ContainerRuntime
+	if containerRuntime == "" {
+		containerRuntime = userdatahelper.ContainerRuntimeDocker
+	}
+
+	if err := centosConfig.ImagePulls.Validate(); err != nil {
+		return "", fmt.Errorf("invalid image pulls config: %v", err)
+	}
+
 	serverAddr, err := userdatahelper.GetServerAddressFromKubeconfig(kubeconfig)
 	if err != nil {
 		return "", fmt.Errorf("error extracting server address from kubeconfig: %v", err)
@@ -99,10 +214,13 @@ func (p Provider) UserData(
 		CloudConfig      string
 		KubeletVersion   string
 		ClusterDNSIPs    []net.IP
+		ClusterDomain    string
 		ServerAddr       string
 		Kubeconfig       string
 		KubernetesCACert string
 		IsExternal       bool
+		PhoneHome        *plugin.PhoneHome
+		ContainerRuntime string
 	}{
 		MachineSpec:      spec,
 		ProviderSpec:     pconfig,
@@ -110,11 +228,14 @@ func (p Provider) UserData(
 		CloudProvider:    cloudProviderName,
 		CloudConfig:      cloudConfig,
 		KubeletVersion:   kubeletVersion.String(),
-		ClusterDNSIPs:    clusterDNSIPs,
+		ClusterDNSIPs:    centosConfig.NodeLocalDNS.ClusterDNSIPs(clusterDNSIPs),
+		ClusterDomain:    clusterDomain,
 		ServerAddr:       serverAddr,
 		Kubeconfig:       kubeconfigString,
 		KubernetesCACert: kubernetesCACert,
 		IsExternal:       externalCloudProvider,
+		PhoneHome:        phoneHome,
+		ContainerRuntime: containerRuntime,
 	}
 	b := &bytes.Buffer{}
 	err = tmpl.Execute(b, data)
@@ -137,6 +258,22 @@ package_reboot_if_required: true
 {{- end }}
 
 ssh_pwauth: no
+{{- with .OSConfig.DefaultUser }}
+
+users:
+- name: "{{ .Name }}"
+  homedir: "{{ .Home }}"
+  shell: /bin/bash
+  groups: wheel
+  sudo: "ALL=(ALL) NOPASSWD:ALL"
+  lock_passwd: true
+  {{- if ne (len $.ProviderSpec.SSHPublicKeys) 0 }}
+  ssh_authorized_keys:
+  {{- range $.ProviderSpec.SSHPublicKeys }}
+  - "{{ . }}"
+  {{- end }}
+  {{- end }}
+{{- else }}
 
 {{- if ne (len .ProviderSpec.SSHPublicKeys) 0 }}
 ssh_authorized_keys:
@@ -144,6 +281,7 @@ ssh_authorized_keys:
   - "{{ . }}"
 {{- end }}
 {{- end }}
+{{- end }}
 
 write_files:
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -157,6 +295,24 @@ write_files:
 - path: "/etc/sysctl.d/k8s.conf"
   content: |
 {{ kernelSettings | indent 4 }}
+{{- if .OSConfig.Conntrack }}
+
+- path: "/etc/modules-load.d/conntrack.conf"
+  content: |
+{{ conntrackKernelModules | indent 4 }}
+{{- end }}
+{{- if eq .ContainerRuntime "containerd" }}
+
+- path: "/etc/yum.repos.d/docker-ce.repo"
+  permissions: "0644"
+  content: |
+    [docker-ce-stable]
+    name=Docker CE Stable - $basearch
+    baseurl=https://download.docker.com/linux/centos/7/$basearch/stable
+    enabled=1
+    gpgcheck=1
+    gpgkey=https://download.docker.com/linux/centos/gpg
+{{- end }}
 
 - path: /etc/sysconfig/selinux
   content: |
@@ -165,24 +321,188 @@ write_files:
     #     enforcing - SELinux security policy is enforced.
     #     permissive - SELinux prints warnings instead of enforcing.
     #     disabled - No SELinux policy is loaded.
-    SELINUX=permissive
+    SELINUX={{ selinuxMode .OSConfig.SELinux }}
     # SELINUXTYPE= can take one of three two values:
     #     targeted - Targeted processes are protected,
     #     minimum - Modification of targeted policy. Only selected processes are protected.
     #     mls - Multi Level Security protection.
     SELINUXTYPE=targeted
+{{- with .OSConfig.AuditLogging }}
+
+- path: "/etc/audit/rules.d/90-machine-controller.rules"
+  permissions: "0600"
+  content: |
+{{ auditRules . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.Multipath }}
+
+- path: "/etc/multipath.conf"
+  permissions: "0644"
+  content: |
+{{ .Config | indent 4 }}
+{{- end }}
+{{- with .OSConfig.ISCSIInitiatorName }}
+
+- path: "/etc/iscsi/initiatorname.iscsi"
+  permissions: "0644"
+  content: |
+    InitiatorName={{ . }}
+{{- end }}
+{{- if .OSConfig.NodeLocalDNS }}
+
+- path: "/opt/bin/setup-nodelocaldns"
+  permissions: "0755"
+  content: |
+{{ nodeLocalDNSScript .OSConfig.NodeLocalDNS | indent 4 }}
+
+- path: "/etc/systemd/system/nodelocaldns.service"
+  permissions: "0644"
+  content: |
+{{ nodeLocalDNSSystemdUnit | indent 4 }}
+{{- end }}
+{{- if .OSConfig.StorageInterface }}
+
+- path: "/opt/bin/setup-storage-interface"
+  permissions: "0755"
+  content: |
+{{ storageInterfaceScript .OSConfig.StorageInterface | indent 4 }}
+
+- path: "/etc/systemd/system/storage-interface.service"
+  permissions: "0644"
+  content: |
+{{ storageInterfaceSystemdUnit | indent 4 }}
+{{- end }}
+{{- if .OSConfig.InstanceStore }}
+
+- path: "/opt/bin/setup-instance-store"
+  permissions: "0755"
+  content: |
+{{ instanceStoreScript .OSConfig.InstanceStore | indent 4 }}
+
+- path: "/etc/systemd/system/instance-store.service"
+  permissions: "0644"
+  content: |
+{{ instanceStoreSystemdUnit | indent 4 }}
+{{- end }}
+{{- with .OSConfig.Chrony }}
+
+- path: "/etc/chrony.conf"
+  permissions: "0644"
+  content: |
+{{ chronyConfig . "/var/lib/chrony/drift" | indent 4 }}
+{{- end }}
+{{- with .OSConfig.LocalResolver }}
+
+- path: "{{ localResolverConfigPath . }}"
+  permissions: "0644"
+  content: |
+{{ localResolverConfig . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.NodeRegistration }}
+
+- path: "/opt/bin/node-registration"
+  permissions: "0755"
+  content: |
+{{ nodeRegistrationScript . | indent 4 }}
+
+- path: "/etc/systemd/system/node-registration.service"
+  permissions: "0644"
+  content: |
+{{ nodeRegistrationSystemdUnit | indent 4 }}
+{{- end }}
+{{- with .OSConfig.KubeletWatchdog }}
+
+- path: "/opt/bin/kubelet-watchdog"
+  permissions: "0755"
+  content: |
+{{ kubeletWatchdogScript . | indent 4 }}
+
+- path: "/etc/systemd/system/kubelet-watchdog.service"
+  permissions: "0644"
+  content: |
+{{ kubeletWatchdogSystemdUnit | indent 4 }}
+
+- path: "/etc/systemd/system/kubelet-watchdog.timer"
+  permissions: "0644"
+  content: |
+{{ kubeletWatchdogSystemdTimer . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.NodeExporter }}
+
+- path: "/opt/bin/setup-node-exporter"
+  permissions: "0755"
+  content: |
+    #!/bin/bash
+    set -xeuo pipefail
+{{ nodeExporterInstallScript . | indent 4 }}
+
+- path: "/etc/systemd/system/node-exporter.service"
+  permissions: "0644"
+  content: |
+{{ nodeExporterSystemdUnit . | indent 4 }}
+{{- end }}
+{{- range .OSConfig.StaticPods }}
+
+- path: "{{ staticPodManifestPath . }}"
+  permissions: "0600"
+  content: |
+{{ .Manifest | trim | indent 4 }}
+{{- end }}
+{{- with .OSConfig.PersistentJournal }}
+
+- path: "/etc/systemd/journald.conf.d/persistent-storage.conf"
+  permissions: "0644"
+  content: |
+{{ journaldConfig . | indent 4 }}
+{{- end }}
 
 - path: "/opt/bin/setup"
   permissions: "0777"
   content: |
     #!/bin/bash
     set -xeuo pipefail
-
-    setenforce 0 || true
+    {{- if .PhoneHome }}
+    trap '/opt/bin/phone-home failure' ERR
+    {{- end }}
+
+    setenforce {{ if eq (selinuxMode .OSConfig.SELinux) "enforcing" }}1{{ else }}0{{ end }} || true
+    {{- if .OSConfig.KernelParameters }}
+
+    # The kernel parameters only get applied once, the reboot below makes them effective
+    if [[ ! -e /etc/kubernetes/kernel-parameters-applied ]]; then
+      grubby --update-kernel=ALL --args="{{ join " " .OSConfig.KernelParameters }}"
+      touch /etc/kubernetes/kernel-parameters-applied
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
+    {{- with .OSConfig.MachineID }}
+
+    # The machine-id only gets replaced once, the reboot below makes the services pick it up
+    if [[ ! -e /etc/kubernetes/machine-id-replaced ]]; then
+{{ machineIDScript . | indent 6 }}
+      touch /etc/kubernetes/machine-id-replaced
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
 
     # As we added some modules and don't want to reboot, restart the service
     systemctl restart systemd-modules-load.service
     sysctl --system
+    {{- with .OSConfig.Conntrack }}
+
+{{ conntrackScript . | indent 4 }}
+    {{- end }}
+    {{- if .OSConfig.PersistentJournal }}
+
+    # journald only stores its logs persistently once the journal directory exists
+    mkdir -p /var/log/journal
+    systemd-tmpfiles --create --prefix /var/log/journal
+    systemctl restart systemd-journald
+    {{- end }}
+    {{- with .OSConfig.Timezone }}
+
+{{ timezoneScript . | indent 4 }}
+    {{- end }}
 
     # Make sure we always disable swap - Otherwise the kubelet won't start
     cp /etc/fstab /etc/fstab.orig
@@ -195,7 +515,7 @@ write_files:
     hostnamectl set-hostname {{ .MachineSpec.Name }}
     {{ end }}
 
-    yum install -y docker-1.13.1 \
+    yum install -y {{ if eq .ContainerRuntime "containerd" }}containerd.io-1.2.6-3.3.el7{{ else }}docker-1.13.1{{ end }} \
       ebtables \
       ethtool \
       nfs-utils \
@@ -205,17 +525,119 @@ write_files:
       wget \
       curl \
       ipvsadm{{ if eq .CloudProvider "vsphere" }} \
-      open-vm-tools{{ end }}
+      open-vm-tools{{ end }}{{ if .OSConfig.AuditLogging }} \
+      audit{{ end }}{{ if .OSConfig.Multipath }} \
+      device-mapper-multipath{{ end }}{{ if .OSConfig.ISCSIInitiatorName }} \
+      iscsi-initiator-utils{{ end }}{{ if .OSConfig.PackageUpgrade }} \
+      yum-utils{{ end }}{{ if .OSConfig.Chrony }} \
+      chrony{{ end }}{{ with .OSConfig.LocalResolver }} \
+      {{ localResolverPackage . }}{{ end }}{{ if .OSConfig.InstanceStore }} \
+      mdadm{{ end }}
+    {{- if .OSConfig.SELinux }}
+    {{- if .OSConfig.SELinux.Booleans }}
+
+{{ selinuxBooleansScript .OSConfig.SELinux | indent 4 }}
+    {{- end }}
+    {{- if and (eq .ContainerRuntime "docker") (eq (selinuxMode .OSConfig.SELinux) "enforcing") }}
+
+    # Containers only get labeled if docker runs with SELinux support
+    grep -q -- '--selinux-enabled' /etc/sysconfig/docker || sed -i "s|^OPTIONS='|OPTIONS='--selinux-enabled |" /etc/sysconfig/docker
+    {{- end }}
+    {{- end }}
+    {{- if eq .ContainerRuntime "docker" }}
+    {{- range nodeProfileDockerFlags .OSConfig.Profile }}
+
+    grep -q -- '{{ . }}' /etc/sysconfig/docker || sed -i "s|^OPTIONS='|OPTIONS='{{ . }} |" /etc/sysconfig/docker
+    {{- end }}
+    {{- with .OSConfig.ImagePulls }}
+
+    grep -q -- '--max-concurrent-downloads' /etc/sysconfig/docker || sed -i "s|^OPTIONS='|OPTIONS='--max-concurrent-downloads={{ .MaxConcurrentDownloads }} |" /etc/sysconfig/docker
+    {{- end }}
+    {{- end }}
+    {{- with .OSConfig.AuditLogging }}
+
+    mkdir -p "$(dirname {{ auditLogFile . }})"
+    sed -i 's|^log_file = .*|log_file = {{ auditLogFile . }}|' /etc/audit/auditd.conf
+    augenrules --load
+    systemctl enable auditd
+    # auditd refuses to be restarted via systemctl
+    service auditd restart
+    {{- end }}
+    {{- if .OSConfig.Multipath }}
+
+    systemctl enable multipathd
+    systemctl restart multipathd
+    {{- end }}
+    {{- if .OSConfig.ISCSIInitiatorName }}
+
+    systemctl enable iscsid
+    systemctl restart iscsid
+    {{- end }}
+    {{- if .OSConfig.Chrony }}
+
+    systemctl enable chronyd
+    systemctl restart chronyd
+    {{- end }}
+    {{- with .OSConfig.LocalResolver }}
+
+{{ localResolverScript . | indent 4 }}
+    {{- end }}
+    {{- with .OSConfig.PackageUpgrade }}
+
+    # The container runtime stays at the version installed above
+    {{- if .SecurityOnly }}
+{{ packageUpgradeScript (printf "yum upgrade -y --security --exclude='%s*'" $.ContainerRuntime) .Attempts | indent 4 }}
+    {{- else }}
+{{ packageUpgradeScript (printf "yum upgrade -y --exclude='%s*'" $.ContainerRuntime) .Attempts | indent 4 }}
+    {{- end }}
+    # Boot into an upgraded kernel before the kubelet starts
+    if ! needs-restarting -r; then
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
+    if [[ -e /var/run/reboot-required ]]; then
+      reboot
+    fi
 
 {{ downloadBinariesScript .KubeletVersion true | indent 4 }}
+    {{- if eq .ContainerRuntime "containerd" }}
+
+{{ crictlSetupScript | indent 4 }}
+    {{ end }}
 
     {{- if eq .CloudProvider "vsphere" }}
     systemctl enable --now vmtoolsd.service
     {{ end -}}
-    systemctl enable --now docker
+    {{ if .OSConfig.InstanceStore -}}
+    systemctl enable --now instance-store.service
+    {{ end -}}
+    systemctl enable --now {{ .ContainerRuntime }}
+    {{- if .OSConfig.NodeLocalDNS }}
+    systemctl enable --now nodelocaldns.service
+    {{- end }}
+    {{- if .OSConfig.StorageInterface }}
+    systemctl enable --now storage-interface.service
+    {{- end }}
+    {{- if .OSConfig.PrewarmImages }}
+    # The images get pulled before the kubelet registers the node, failed pulls do not keep it from joining
+    systemctl enable --now image-prewarm.service || true
+    {{- end }}
     systemctl enable --now kubelet
     systemctl enable --now --no-block kubelet-healthcheck.service
-    systemctl enable --now --no-block docker-healthcheck.service
+    systemctl enable --now --no-block {{ .ContainerRuntime }}-healthcheck.service
+    {{- if .OSConfig.NodeRegistration }}
+    systemctl enable --now --no-block node-registration.service
+    {{- end }}
+    {{- if .OSConfig.KubeletWatchdog }}
+    systemctl enable --now kubelet-watchdog.timer
+    {{- end }}
+    {{- if .OSConfig.NodeExporter }}
+    /opt/bin/setup-node-exporter
+    systemctl enable --now node-exporter.service
+    {{- end }}
+    {{- if .PhoneHome }}
+    /opt/bin/phone-home success
+    {{- end }}
 
 - path: "/opt/bin/supervise.sh"
   permissions: "0755"
@@ -225,15 +647,36 @@ write_files:
     while ! "$@"; do
       sleep 1
     done
+{{- with .PhoneHome }}
+
+- path: "/opt/bin/phone-home"
+  permissions: "0700"
+  content: |
+    #!/bin/bash
+    set -uo pipefail
+    # Reports the bootstrap status together with the tail of the setup log to the machine-controller
+    journalctl -u setup.service -n 100 --no-pager | tail -c 4096 | \
+      curl -sS --retry 5 --max-time 30 -X POST \
+        -H "Authorization: Bearer {{ .Token }}" \
+        -H "Content-Type: text/plain" \
+        --data-binary @- \
+        "{{ .URL }}?status=$1" || true
+{{- end }}
 
 - path: "/etc/systemd/system/kubelet.service"
   content: |
-{{ kubeletSystemdUnit .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .IsExternal | indent 4 }}
+{{ kubeletSystemdUnit .ContainerRuntime .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .ClusterDomain .IsExternal | indent 4 }}
 
 - path: "/etc/systemd/system/kubelet.service.d/extras.conf"
   content: |
     [Service]
-    Environment="KUBELET_EXTRA_ARGS=--cgroup-driver=systemd"
+    Environment="KUBELET_EXTRA_ARGS=--cgroup-driver=systemd{{ if .OSConfig.LocalResolver }} --resolv-conf=/etc/kubernetes/upstream-resolv.conf{{ end }}{{ range containerRuntimeKubeletFlags .ContainerRuntime }} {{ . }}{{ end }}{{ range nodeProfileKubeletFlags .KubeletVersion .OSConfig.Profile }} {{ . }}{{ end }}{{ with .OSConfig.ImagePulls }} {{ imagePullKubeletFlags $.KubeletVersion . | join " " }}{{ end }}"
+{{- with .ProviderSpec.KubeletConfig }}
+
+- path: "/etc/kubernetes/kubelet-config.env"
+  content: |
+    KUBELET_CONFIG_ARGS="{{ .Args }}"
+{{- end }}
 
 - path: "/etc/kubernetes/cloud-config"
   content: |
@@ -266,17 +709,61 @@ write_files:
   permissions: "0644"
   content: |
     export PATH="/opt/bin:$PATH"
+{{- if eq .ContainerRuntime "containerd" }}
+
+- path: "/etc/containerd/config.toml"
+  permissions: "0644"
+  content: |
+{{ containerdConfig "systemd" (eq (selinuxMode .OSConfig.SELinux) "enforcing") .OSConfig.ImagePulls nil | indent 4 }}
+
+- path: "/etc/crictl.yaml"
+  permissions: "0644"
+  content: |
+{{ crictlConfig | indent 4 }}
+{{- with nodeProfileContainerdLimits .OSConfig.Profile }}
+
+- path: /etc/systemd/system/containerd.service.d/10-limits.conf
+  permissions: "0644"
+  content: |
+    [Service]
+{{ join "\n" . | indent 4 }}
+{{- end }}
+{{- end }}
 
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
 {{ kubeletHealthCheckSystemdUnit | indent 4 }}
 
-- path: /etc/systemd/system/docker-healthcheck.service
+- path: /etc/systemd/system/{{ .ContainerRuntime }}-healthcheck.service
   permissions: "0644"
   content: |
-{{ containerRuntimeHealthCheckSystemdUnit | indent 4 }}
+{{ containerRuntimeHealthCheckSystemdUnit .ContainerRuntime | indent 4 }}
+{{- if .OSConfig.PrewarmImages }}
+
+- path: "/opt/bin/prewarm-images"
+  permissions: "0755"
+  content: |
+{{ prewarmImagesScript .ContainerRuntime .OSConfig.PrewarmImages | indent 4 }}
+
+- path: /etc/systemd/system/image-prewarm.service
+  permissions: "0644"
+  content: |
+{{ prewarmImagesSystemdUnit .ContainerRuntime | indent 4 }}
+{{- end }}
 
 runcmd:
 - systemctl enable --now setup.service
+
+{{- with .OSConfig.PowerState }}
+
+power_state:
+  mode: {{ .Mode }}
+  {{- if .Timeout }}
+  timeout: {{ .Timeout }}
+  {{- end }}
+  {{- if .Condition }}
+  condition: {{ .Condition | quote }}
+  {{- end }}
+{{- end }}
 `
@@ -30,6 +30,7 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	testhelper "github.com/kubermatic/machine-controller/pkg/test"
 	"github.com/kubermatic/machine-controller/pkg/userdata/convert"
 )
@@ -83,6 +84,7 @@ type userDataTestCase struct {
 	clusterDNSIPs         []net.IP
 	cloudProviderName     *string
 	externalCloudProvider bool
+	phoneHome             *plugin.PhoneHome
 }
 
 // TestUserDataGeneration runs the data generation for different
@@ -189,7 +191,7 @@ func TestUserDataGeneration(t *testing.T) {
 			t.Fatalf("failed to get cloud config: %v", err)
 		}
 
-		s, err := provider.UserData(test.spec, kubeconfig, cloudConfig, cloudProviderName, test.clusterDNSIPs, test.externalCloudProvider)
+		s, err := provider.UserData(test.spec, kubeconfig, cloudConfig, cloudProviderName, test.clusterDNSIPs, test.externalCloudProvider, test.phoneHome)
 		if err != nil {
 			t.Errorf("error getting userdata: '%v'", err)
 		}
@@ -23,6 +23,7 @@ import (
 
 	ctconfig "github.com/coreos/container-linux-config-transpiler/config"
 
+	apiplugin "github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/userdata/plugin"
 
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -43,8 +44,9 @@ func (j *Ignition) UserData(
 	cloudConfig, cloudProviderName string,
 	clusterDNSIPs []net.IP,
 	externalCloudProvider bool,
+	phoneHome *apiplugin.PhoneHome,
 ) (string, error) {
-	before, err := j.p.UserData(spec, kubeconfig, cloudConfig, cloudProviderName, clusterDNSIPs, externalCloudProvider)
+	before, err := j.p.UserData(spec, kubeconfig, cloudConfig, cloudProviderName, clusterDNSIPs, externalCloudProvider, phoneHome)
 	if err != nil {
 		return "", err
 	}
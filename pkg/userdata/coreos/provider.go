@@ -31,6 +31,7 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
@@ -46,6 +47,7 @@ func (p Provider) UserData(
 	cloudProviderName string,
 	clusterDNSIPs []net.IP,
 	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
 ) (string, error) {
 
 	tmpl, err := template.New("user-data").Funcs(userdatahelper.TxtFuncMap()).Parse(userDataTemplate)
@@ -67,6 +69,11 @@ func (p Provider) UserData(
 		cloudConfig = *pconfig.OverwriteCloudConfig
 	}
 
+	clusterDNSIPs, clusterDomain, err := pconfig.ClusterDNSConfig(clusterDNSIPs)
+	if err != nil {
+		return "", err
+	}
+
 	coreosConfig, err := LoadConfig(pconfig.OperatingSystemSpec)
 	if err != nil {
 		return "", fmt.Errorf("failed to get coreos config from provider config: %v", err)
@@ -91,6 +98,7 @@ func (p Provider) UserData(
 		CloudConfig       string
 		HyperkubeImageTag string
 		ClusterDNSIPs     []net.IP
+		ClusterDomain     string
 		KubernetesCACert  string
 		KubeletVersion    string
 		IsExternal        bool
@@ -103,6 +111,7 @@ func (p Provider) UserData(
 		CloudConfig:       cloudConfig,
 		HyperkubeImageTag: fmt.Sprintf("v%s", kubeletVersion.String()),
 		ClusterDNSIPs:     clusterDNSIPs,
+		ClusterDomain:     clusterDomain,
 		KubernetesCACert:  kubernetesCACert,
 		KubeletVersion:    kubeletVersion.String(),
 		IsExternal:        externalCloudProvider,
@@ -174,7 +183,7 @@ systemd:
           Requires=download-healthcheck-script.service
           After=download-healthcheck-script.service
       contents: |
-{{ containerRuntimeHealthCheckSystemdUnit | indent 10 }}
+{{ containerRuntimeHealthCheckSystemdUnit "docker" | indent 10 }}
 
     - name: kubelet-healthcheck.service
       enabled: true
@@ -220,7 +229,7 @@ systemd:
         ExecStartPre=-/usr/bin/rkt rm --uuid-file=/var/cache/kubelet-pod.uuid
         ExecStartPre=-/bin/rm -rf /var/lib/rkt/cas/tmp/
         ExecStart=/usr/lib/coreos/kubelet-wrapper \
-{{ kubeletFlags .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .IsExternal | indent 10 }}
+{{ kubeletFlags .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .ClusterDomain .IsExternal | indent 10 }}
         ExecStop=-/usr/bin/rkt stop --uuid-file=/var/cache/kubelet-pod.uuid
         Restart=always
         RestartSec=10
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultAuditLogFile is the file auditd writes the audit log to if not configured otherwise
+const DefaultAuditLogFile = "/var/log/audit/audit.log"
+
+// kubeletAuditRules watch the kubelet binary, its systemd units, the kubelet configuration and credentials
+var kubeletAuditRules = []string{
+	"-w /opt/bin/kubelet -p wa -k kubelet",
+	"-w /etc/systemd/system/kubelet.service -p wa -k kubelet",
+	"-w /etc/systemd/system/kubelet.service.d/ -p wa -k kubelet",
+	"-w /etc/kubernetes/ -p wa -k kubelet",
+	"-w /var/lib/kubelet/ -p wa -k kubelet",
+}
+
+// AuditLogging installs and configures auditd on the node.
+type AuditLogging struct {
+	// Rules is the auditd ruleset, one rule per entry as accepted by auditctl.
+	Rules []string `json:"rules"`
+	// LogFile is the file auditd writes the audit log to. Defaults to /var/log/audit/audit.log.
+	LogFile string `json:"logFile,omitempty"`
+	// Kubelet adds rules which audit all changes to the kubelet binary, its configuration and credentials.
+	Kubelet bool `json:"kubelet,omitempty"`
+}
+
+// Validate checks the AuditLogging for invalid values
+func (a *AuditLogging) Validate() error {
+	if a == nil {
+		return nil
+	}
+	if len(a.Rules) == 0 {
+		return errors.New("audit rules must not be empty when audit logging is enabled")
+	}
+	for _, rule := range a.Rules {
+		if strings.TrimSpace(rule) == "" {
+			return errors.New("audit rules must not contain empty rules")
+		}
+		if strings.ContainsAny(rule, "\r\n") {
+			return fmt.Errorf("audit rule %q must not span multiple lines", rule)
+		}
+	}
+	if a.LogFile != "" && (!filepath.IsAbs(a.LogFile) || strings.ContainsAny(a.LogFile, " \t\r\n|'\"")) {
+		return fmt.Errorf("invalid audit log file %q, must be an absolute path", a.LogFile)
+	}
+	return nil
+}
+
+// AuditRules returns the content of the auditd rules file
+func AuditRules(a *AuditLogging) string {
+	rules := append([]string{}, a.Rules...)
+	if a.Kubelet {
+		rules = append(rules, kubeletAuditRules...)
+	}
+	return strings.Join(rules, "\n")
+}
+
+// AuditLogFile returns the configured audit log file or the default one
+func AuditLogFile(a *AuditLogging) string {
+	if a.LogFile == "" {
+		return DefaultAuditLogFile
+	}
+	return a.LogFile
+}
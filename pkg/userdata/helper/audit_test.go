@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestAuditLoggingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		audit   *AuditLogging
+		wantErr bool
+	}{
+		{
+			name:  "not set",
+			audit: nil,
+		},
+		{
+			name:  "rules with log file",
+			audit: &AuditLogging{Rules: []string{"-w /etc/passwd -p wa -k identity"}, LogFile: "/var/log/audit/node.log"},
+		},
+		{
+			name:    "no rules",
+			audit:   &AuditLogging{Kubelet: true},
+			wantErr: true,
+		},
+		{
+			name:    "blank rule",
+			audit:   &AuditLogging{Rules: []string{" "}},
+			wantErr: true,
+		},
+		{
+			name:    "multi line rule",
+			audit:   &AuditLogging{Rules: []string{"-w /etc/passwd -p wa\n-w /etc/shadow -p wa"}},
+			wantErr: true,
+		},
+		{
+			name:    "relative log file",
+			audit:   &AuditLogging{Rules: []string{"-w /etc/passwd -p wa"}, LogFile: "audit.log"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.audit.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
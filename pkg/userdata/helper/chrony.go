@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const defaultChronyPool = "pool.ntp.org"
+
+var ntpServerRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.:-]*[a-zA-Z0-9])?$`)
+
+// Chrony replaces the chrony config of the distribution. The node syncs its time from the configured
+// servers and optionally serves it to peers, e.g. in isolated subnets where only one node reaches an NTP server.
+type Chrony struct {
+	// Servers are the NTP servers to sync from. Defaults to pool.ntp.org
+	Servers []string `json:"servers,omitempty"`
+	// Allow makes the node an NTP server for clients from the given CIDRs
+	Allow []string `json:"allow,omitempty"`
+	// LocalStratum keeps serving the local time at the given stratum while no server is reachable. Requires Allow
+	LocalStratum int `json:"localStratum,omitempty"`
+}
+
+// Validate checks the chrony config for invalid values
+func (c *Chrony) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, server := range c.Servers {
+		if !ntpServerRegexp.MatchString(server) {
+			return fmt.Errorf("invalid NTP server %q", server)
+		}
+	}
+	for _, cidr := range c.Allow {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allow CIDR %q: %v", cidr, err)
+		}
+	}
+	if c.LocalStratum < 0 || c.LocalStratum > 15 {
+		return fmt.Errorf("local stratum must be between 1 and 15, got %d", c.LocalStratum)
+	}
+	if c.LocalStratum > 0 && len(c.Allow) == 0 {
+		return errors.New("local stratum requires allow CIDRs")
+	}
+	return nil
+}
+
+// ChronyConfig returns the chrony config. The drift file path differs between the distributions
+func ChronyConfig(c *Chrony, driftFile string) string {
+	var lines []string
+	if len(c.Servers) == 0 {
+		lines = append(lines, fmt.Sprintf("pool %s iburst", defaultChronyPool))
+	}
+	for _, server := range c.Servers {
+		lines = append(lines, fmt.Sprintf("server %s iburst", server))
+	}
+	lines = append(lines,
+		"driftfile "+driftFile,
+		"makestep 1.0 3",
+		"rtcsync")
+	for _, cidr := range c.Allow {
+		lines = append(lines, "allow "+cidr)
+	}
+	if c.LocalStratum > 0 {
+		lines = append(lines, fmt.Sprintf("local stratum %d", c.LocalStratum))
+	}
+	lines = append(lines, "logdir /var/log/chrony")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChronyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		chrony  *Chrony
+		wantErr bool
+	}{
+		{
+			name:   "not set",
+			chrony: nil,
+		},
+		{
+			name:   "client",
+			chrony: &Chrony{Servers: []string{"10.0.0.1", "ntp.example.com"}},
+		},
+		{
+			name:   "server",
+			chrony: &Chrony{Allow: []string{"10.0.0.0/24", "fd00::/64"}, LocalStratum: 10},
+		},
+		{
+			name:    "invalid server",
+			chrony:  &Chrony{Servers: []string{"ntp.example.com iburst"}},
+			wantErr: true,
+		},
+		{
+			name:    "allow without prefix length",
+			chrony:  &Chrony{Allow: []string{"10.0.0.0"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid allow CIDR",
+			chrony:  &Chrony{Allow: []string{"10.0.0.0/33"}},
+			wantErr: true,
+		},
+		{
+			name:    "local stratum too high",
+			chrony:  &Chrony{Allow: []string{"10.0.0.0/24"}, LocalStratum: 16},
+			wantErr: true,
+		},
+		{
+			name:    "local stratum without allow",
+			chrony:  &Chrony{LocalStratum: 10},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.chrony.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestChronyConfig(t *testing.T) {
+	client := ChronyConfig(&Chrony{}, "/var/lib/chrony/drift")
+	if !strings.Contains(client, "pool pool.ntp.org iburst") {
+		t.Errorf("expected the default pool in the client config, got:\n%s", client)
+	}
+	if strings.Contains(client, "allow") || strings.Contains(client, "local stratum") {
+		t.Errorf("expected no server directives in the client config, got:\n%s", client)
+	}
+
+	server := ChronyConfig(&Chrony{Servers: []string{"10.0.0.1"}, Allow: []string{"10.0.0.0/24", "10.1.0.0/24"}, LocalStratum: 10}, "/var/lib/chrony/drift")
+	for _, directive := range []string{"server 10.0.0.1 iburst", "allow 10.0.0.0/24", "allow 10.1.0.0/24", "local stratum 10"} {
+		if !strings.Contains(server, directive+"\n") {
+			t.Errorf("expected %q in the server config, got:\n%s", directive, server)
+		}
+	}
+	if strings.Contains(server, "pool") {
+		t.Errorf("expected no default pool if servers are set, got:\n%s", server)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultConntrackMaxPerGiB             = 65536
+	defaultConntrackMin                   = 131072
+	defaultConntrackTCPTimeoutEstablished = 86400
+	defaultConntrackTCPTimeoutCloseWait   = 3600
+
+	// maxConntrackEntries keeps the conntrack table below the limit of the kernel
+	maxConntrackEntries = 1 << 30
+)
+
+// conntrackKernelModules are needed by kube-proxy in IPVS and iptables mode
+var conntrackKernelModules = []string{"ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh", "nf_conntrack"}
+
+const conntrackScriptTpl = `# conntrack
+modprobe -a {{ .Modules }}
+{{- if .Max }}
+conntrack_max={{ .Max }}
+{{- else }}
+mem_gib=$(( ($(awk '/^MemTotal:/ {print $2}' /proc/meminfo) + 1048575) / 1048576 ))
+conntrack_max=$(( mem_gib * {{ .MaxPerGiB }} ))
+if (( conntrack_max < {{ .Min }} )); then
+    conntrack_max={{ .Min }}
+fi
+{{- end }}
+{{- if .Buckets }}
+conntrack_buckets={{ .Buckets }}
+{{- else }}
+conntrack_buckets=$(( conntrack_max / 4 ))
+{{- end }}
+echo "options nf_conntrack hashsize=${conntrack_buckets}" > /etc/modprobe.d/nf_conntrack.conf
+echo "${conntrack_buckets}" > /sys/module/nf_conntrack/parameters/hashsize
+cat <<EOF > /etc/sysctl.d/conntrack.conf
+net.netfilter.nf_conntrack_max = ${conntrack_max}
+net.netfilter.nf_conntrack_tcp_timeout_established = {{ .TCPTimeoutEstablished }}
+net.netfilter.nf_conntrack_tcp_timeout_close_wait = {{ .TCPTimeoutCloseWait }}
+EOF
+sysctl -p /etc/sysctl.d/conntrack.conf`
+
+// Conntrack sizes the conntrack table of the node and loads the kernel modules kube-proxy needs, so connections do
+// not get dropped once the table is full under load
+type Conntrack struct {
+	// Max is the number of entries of the conntrack table. Defaults to MaxPerGiB times the memory of the node in GiB,
+	// but at least Min
+	Max int `json:"max,omitempty"`
+	// MaxPerGiB is the number of entries per GiB of memory if Max is not set. Defaults to 65536
+	MaxPerGiB int `json:"maxPerGiB,omitempty"`
+	// Min is the lower bound of the entries if Max is not set. Defaults to 131072
+	Min int `json:"min,omitempty"`
+	// Buckets is the size of the hash table of the conntrack table. Defaults to a quarter of the entries
+	Buckets int `json:"buckets,omitempty"`
+	// TCPTimeoutEstablished is the number of seconds idle established connections are tracked. Defaults to 86400
+	TCPTimeoutEstablished int `json:"tcpTimeoutEstablished,omitempty"`
+	// TCPTimeoutCloseWait is the number of seconds connections in CLOSE_WAIT are tracked. Defaults to 3600
+	TCPTimeoutCloseWait int `json:"tcpTimeoutCloseWait,omitempty"`
+}
+
+// Validate checks the Conntrack config for invalid values
+func (c *Conntrack) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, field := range []struct {
+		name  string
+		value int
+	}{
+		{"max", c.Max},
+		{"maxPerGiB", c.MaxPerGiB},
+		{"min", c.Min},
+		{"buckets", c.Buckets},
+		{"tcpTimeoutEstablished", c.TCPTimeoutEstablished},
+		{"tcpTimeoutCloseWait", c.TCPTimeoutCloseWait},
+	} {
+		if field.value < 0 {
+			return fmt.Errorf("invalid %s %d, must not be negative", field.name, field.value)
+		}
+	}
+	if c.Max > maxConntrackEntries || c.Min > maxConntrackEntries {
+		return fmt.Errorf("invalid max or min, must not be more than %d entries", maxConntrackEntries)
+	}
+	if c.Max != 0 && (c.MaxPerGiB != 0 || c.Min != 0) {
+		return fmt.Errorf("max can not be combined with maxPerGiB or min")
+	}
+	if c.Buckets > maxConntrackEntries {
+		return fmt.Errorf("invalid buckets %d, must not be more than %d", c.Buckets, maxConntrackEntries)
+	}
+	if c.Max != 0 && c.Buckets > c.Max {
+		return fmt.Errorf("invalid buckets %d, must not be more than max %d", c.Buckets, c.Max)
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the config with the defaults filled in
+func (c *Conntrack) withDefaults() Conntrack {
+	config := *c
+	if config.Max == 0 {
+		if config.MaxPerGiB == 0 {
+			config.MaxPerGiB = defaultConntrackMaxPerGiB
+		}
+		if config.Min == 0 {
+			config.Min = defaultConntrackMin
+		}
+	}
+	if config.TCPTimeoutEstablished == 0 {
+		config.TCPTimeoutEstablished = defaultConntrackTCPTimeoutEstablished
+	}
+	if config.TCPTimeoutCloseWait == 0 {
+		config.TCPTimeoutCloseWait = defaultConntrackTCPTimeoutCloseWait
+	}
+	return config
+}
+
+// ConntrackKernelModules returns the modules-load.d config which loads the kernel modules kube-proxy needs on boot
+func ConntrackKernelModules() string {
+	return strings.Join(conntrackKernelModules, "\n") + "\n"
+}
+
+// ConntrackScript returns the script which loads the kernel modules and sizes the conntrack table. The number of
+// entries and buckets gets computed from the memory of the node during the bootstrap, as the userdata does not know
+// the instance type
+func ConntrackScript(c *Conntrack) (string, error) {
+	tmpl, err := template.New("conntrack-script").Parse(conntrackScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse conntrack-script template: %v", err)
+	}
+	data := struct {
+		Conntrack
+		Modules string
+	}{
+		Conntrack: c.withDefaults(),
+		Modules:   strings.Join(conntrackKernelModules, " "),
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute conntrack-script template: %v", err)
+	}
+	return b.String(), nil
+}
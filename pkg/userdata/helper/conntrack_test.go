@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConntrackValidate(t *testing.T) {
+	for _, config := range []*Conntrack{
+		nil,
+		{},
+		{Max: 1048576, Buckets: 262144},
+		{MaxPerGiB: 32768, Min: 65536, TCPTimeoutEstablished: 3600},
+	} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got: %v", config, err)
+		}
+	}
+	for _, config := range []*Conntrack{
+		{Max: -1},
+		{TCPTimeoutCloseWait: -1},
+		{Max: 1048576, MaxPerGiB: 32768},
+		{Max: 65536, Buckets: 131072},
+		{Min: 1 << 31},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestConntrackScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Conntrack
+		expected []string
+	}{
+		{
+			name:   "defaults scale with the memory of the node",
+			config: &Conntrack{},
+			expected: []string{
+				"modprobe -a ip_vs ip_vs_rr ip_vs_wrr ip_vs_sh nf_conntrack",
+				"conntrack_max=$(( mem_gib * 65536 ))",
+				"if (( conntrack_max < 131072 )); then",
+				"conntrack_buckets=$(( conntrack_max / 4 ))",
+				"options nf_conntrack hashsize=${conntrack_buckets}",
+				"net.netfilter.nf_conntrack_max = ${conntrack_max}",
+				"net.netfilter.nf_conntrack_tcp_timeout_established = 86400",
+				"net.netfilter.nf_conntrack_tcp_timeout_close_wait = 3600",
+				"sysctl -p /etc/sysctl.d/conntrack.conf",
+			},
+		},
+		{
+			name:   "fixed size",
+			config: &Conntrack{Max: 1048576, Buckets: 131072, TCPTimeoutEstablished: 3600},
+			expected: []string{
+				"conntrack_max=1048576",
+				"conntrack_buckets=131072",
+				"net.netfilter.nf_conntrack_tcp_timeout_established = 3600",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := ConntrackScript(test.config)
+			if err != nil {
+				t.Fatalf("failed to render script: %v", err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(script, expected) {
+					t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+				}
+			}
+			if test.config.Max != 0 && strings.Contains(script, "mem_gib") {
+				t.Errorf("expected a fixed size to not depend on the memory, got:\n%s", script)
+			}
+		})
+	}
+
+	if modules := ConntrackKernelModules(); !strings.Contains(modules, "nf_conntrack\n") || !strings.Contains(modules, "ip_vs\n") {
+		t.Errorf("expected the kernel modules to contain ip_vs and nf_conntrack, got:\n%s", modules)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// The container runtimes which can be installed on a node
+const (
+	// ContainerRuntimeDocker runs the containers via docker, the kubelet talks to it through the dockershim
+	ContainerRuntimeDocker = "docker"
+	// ContainerRuntimeContainerd runs the containers via the CRI plugin of containerd
+	ContainerRuntimeContainerd = "containerd"
+)
+
+const (
+	containerdEndpoint = "unix:///run/containerd/containerd.sock"
+	// crictl v1.13 speaks the v1alpha2 CRI API, which all supported kubelets use
+	crictlVersion = "v1.13.0"
+)
+
+// The containerd.io package ships a config.toml which disables the CRI plugin, so it always gets replaced
+const containerdConfigTpl = `[plugins.cri]
+{{- if eq .CgroupDriver "systemd" }}
+  systemd_cgroup = true
+{{- end }}
+{{- if .SELinux }}
+  enable_selinux = true
+{{- end }}
+{{- with .ImagePulls }}
+  max_concurrent_downloads = {{ .MaxConcurrentDownloads }}
+{{- end }}
+[plugins.cri.containerd]
+  snapshotter = "overlayfs"
+{{- if .NvidiaGPU }}
+[plugins.cri.containerd.default_runtime]
+  runtime_type = "io.containerd.runtime.v1.linux"
+  runtime_engine = "/usr/bin/nvidia-container-runtime"
+{{- end }}`
+
+const crictlSetupScriptTpl = `if [[ ! -x /opt/bin/crictl ]]; then
+    curl -L https://github.com/kubernetes-sigs/cri-tools/releases/download/{{ .Version }}/crictl-{{ .Version }}-linux-amd64.tar.gz | tar -xvzC /opt/bin -f -
+fi
+# health-monitor.sh looks for crictl below its KUBE_HOME
+mkdir -p /home/kubernetes/bin
+ln -sf /opt/bin/crictl /home/kubernetes/bin/crictl`
+
+// ValidateContainerRuntime checks if the container runtime is supported. An empty runtime is the same as docker
+func ValidateContainerRuntime(runtime string) error {
+	switch runtime {
+	case "", ContainerRuntimeDocker, ContainerRuntimeContainerd:
+		return nil
+	}
+	return fmt.Errorf("invalid container runtime %q, must be either %s or %s", runtime, ContainerRuntimeDocker, ContainerRuntimeContainerd)
+}
+
+// ContainerRuntimeSystemdUnit returns the name of the systemd unit which runs the container runtime
+func ContainerRuntimeSystemdUnit(runtime string) string {
+	if runtime == ContainerRuntimeContainerd {
+		return "containerd.service"
+	}
+	return "docker.service"
+}
+
+// ContainerRuntimeKubeletFlags returns the kubelet flags which point the kubelet to the container runtime
+func ContainerRuntimeKubeletFlags(runtime string) []string {
+	if runtime == ContainerRuntimeContainerd {
+		return []string{
+			"--container-runtime=remote",
+			"--container-runtime-endpoint=" + containerdEndpoint,
+			"--runtime-request-timeout=15m",
+		}
+	}
+	return nil
+}
+
+// ContainerdConfig returns the containerd config.toml which enables the CRI plugin. The cgroup driver must match the
+// one of the kubelet. The nvidia runtime becomes the default runtime if nvidiaGPU is set
+func ContainerdConfig(cgroupDriver string, selinux bool, imagePulls *ImagePulls, nvidiaGPU *NvidiaGPU) (string, error) {
+	tmpl, err := template.New("containerd-config").Funcs(TxtFuncMap()).Parse(containerdConfigTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse containerd-config template: %v", err)
+	}
+
+	data := struct {
+		CgroupDriver string
+		SELinux      bool
+		ImagePulls   *ImagePulls
+		NvidiaGPU    *NvidiaGPU
+	}{
+		CgroupDriver: cgroupDriver,
+		SELinux:      selinux,
+		ImagePulls:   imagePulls,
+		NvidiaGPU:    nvidiaGPU,
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute containerd-config template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// CrictlConfig returns the crictl.yaml which points crictl to containerd
+func CrictlConfig() string {
+	return fmt.Sprintf(`runtime-endpoint: %s
+image-endpoint: %s`, containerdEndpoint, containerdEndpoint)
+}
+
+// CrictlSetupScript returns the script which installs crictl. The prewarming of images and the health checks of
+// containerd use it
+func CrictlSetupScript() (string, error) {
+	tmpl, err := template.New("crictl-setup-script").Funcs(TxtFuncMap()).Parse(crictlSetupScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse crictl-setup-script template: %v", err)
+	}
+
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, struct{ Version string }{Version: crictlVersion}); err != nil {
+		return "", fmt.Errorf("failed to execute crictl-setup-script template: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateContainerRuntime(t *testing.T) {
+	for _, runtime := range []string{"", ContainerRuntimeDocker, ContainerRuntimeContainerd} {
+		if err := ValidateContainerRuntime(runtime); err != nil {
+			t.Errorf("expected container runtime %q to be valid, got: %v", runtime, err)
+		}
+	}
+	if err := ValidateContainerRuntime("cri-o"); err == nil {
+		t.Error("expected an error for an unsupported container runtime")
+	}
+}
+
+func TestContainerRuntimeKubeletFlags(t *testing.T) {
+	if flags := ContainerRuntimeKubeletFlags(ContainerRuntimeDocker); len(flags) != 0 {
+		t.Errorf("expected no kubelet flags for docker, got %v", flags)
+	}
+	expected := []string{
+		"--container-runtime=remote",
+		"--container-runtime-endpoint=unix:///run/containerd/containerd.sock",
+		"--runtime-request-timeout=15m",
+	}
+	if flags := ContainerRuntimeKubeletFlags(ContainerRuntimeContainerd); !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected kubelet flags %v, got %v", expected, flags)
+	}
+}
+
+func TestContainerdConfig(t *testing.T) {
+	config, err := ContainerdConfig("systemd", true, &ImagePulls{MaxConcurrentDownloads: 10}, &NvidiaGPU{DriverVersion: "418.87.01"})
+	if err != nil {
+		t.Fatalf("failed to render the config: %v", err)
+	}
+	for _, expected := range []string{
+		"[plugins.cri]\n  systemd_cgroup = true\n  enable_selinux = true\n  max_concurrent_downloads = 10\n",
+		`snapshotter = "overlayfs"`,
+		`runtime_engine = "/usr/bin/nvidia-container-runtime"`,
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("expected the config to contain %q, got:\n%s", expected, config)
+		}
+	}
+
+	config, err = ContainerdConfig("cgroupfs", false, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to render the config: %v", err)
+	}
+	if config != "[plugins.cri]\n[plugins.cri.containerd]\n  snapshotter = \"overlayfs\"" {
+		t.Errorf("expected a config which only enables the CRI plugin, got:\n%s", config)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+var (
+	// userNameRegex matches the portable user names accepted by useradd
+	userNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+	homeDirRegex  = regexp.MustCompile(`^/[a-zA-Z0-9._/-]*$`)
+)
+
+// maxUserNameLength is the maximum length of a user name supported by useradd
+const maxUserNameLength = 32
+
+// DefaultUser replaces the default user of the image. It gets the SSH keys of the machine and passwordless sudo.
+type DefaultUser struct {
+	// Name of the user
+	Name string `json:"name"`
+	// HomeDir is the home directory of the user. Defaults to /home/<name>
+	HomeDir string `json:"homeDir,omitempty"`
+}
+
+// Validate checks the DefaultUser for invalid values
+func (u *DefaultUser) Validate() error {
+	if u == nil {
+		return nil
+	}
+	if u.Name == "" {
+		return errors.New("name must be set")
+	}
+	if len(u.Name) > maxUserNameLength {
+		return fmt.Errorf("name %q must not be longer than %d characters", u.Name, maxUserNameLength)
+	}
+	if !userNameRegex.MatchString(u.Name) {
+		return fmt.Errorf("invalid name %q, must start with a lowercase letter or underscore followed by lowercase letters, digits, underscores or dashes", u.Name)
+	}
+	if u.Name == "root" {
+		return errors.New("root can not be used as default user")
+	}
+	if u.HomeDir != "" && (!homeDirRegex.MatchString(u.HomeDir) || path.Clean(u.HomeDir) != u.HomeDir || u.HomeDir == "/") {
+		return fmt.Errorf("invalid home directory %q, must be a clean absolute path", u.HomeDir)
+	}
+	return nil
+}
+
+// Home returns the home directory of the user
+func (u *DefaultUser) Home() string {
+	if u.HomeDir != "" {
+		return u.HomeDir
+	}
+	return "/home/" + u.Name
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import "testing"
+
+func TestDefaultUserValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         *DefaultUser
+		expectedHome string
+		wantErr      bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:         "default home directory",
+			user:         &DefaultUser{Name: "cloud-user"},
+			expectedHome: "/home/cloud-user",
+		},
+		{
+			name:         "custom home directory",
+			user:         &DefaultUser{Name: "admin", HomeDir: "/var/lib/admin"},
+			expectedHome: "/var/lib/admin",
+		},
+		{
+			name:    "empty name",
+			user:    &DefaultUser{},
+			wantErr: true,
+		},
+		{
+			name:    "uppercase name",
+			user:    &DefaultUser{Name: "Admin"},
+			wantErr: true,
+		},
+		{
+			name:    "name with shell characters",
+			user:    &DefaultUser{Name: "admin;reboot"},
+			wantErr: true,
+		},
+		{
+			name:    "too long name",
+			user:    &DefaultUser{Name: "a123456789012345678901234567890123"},
+			wantErr: true,
+		},
+		{
+			name:    "root",
+			user:    &DefaultUser{Name: "root"},
+			wantErr: true,
+		},
+		{
+			name:    "relative home directory",
+			user:    &DefaultUser{Name: "admin", HomeDir: "home/admin"},
+			wantErr: true,
+		},
+		{
+			name:    "unclean home directory",
+			user:    &DefaultUser{Name: "admin", HomeDir: "/home/../etc"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.user.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+			if err == nil && test.user != nil && test.user.Home() != test.expectedHome {
+				t.Errorf("expected home directory %q, got %q", test.expectedHome, test.user.Home())
+			}
+		})
+	}
+}
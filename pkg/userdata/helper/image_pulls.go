@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// The kubelet supports limiting parallel image pulls starting with 1.27, older kubelets pull without a limit
+// once the pulls are not serialized anymore
+var maxParallelImagePullsMinKubeletVersion = semver.MustParse("1.27.0")
+
+// ImagePulls configures how many images the container runtime and the kubelet pull at the same time
+type ImagePulls struct {
+	// MaxConcurrentDownloads is the number of layers the container runtime downloads at the same time
+	MaxConcurrentDownloads int `json:"maxConcurrentDownloads"`
+	// MaxParallelImagePulls is the number of images the kubelet pulls at the same time. 1 serializes the pulls.
+	// Defaults to MaxConcurrentDownloads
+	MaxParallelImagePulls int `json:"maxParallelImagePulls,omitempty"`
+}
+
+// Validate checks the ImagePulls config for invalid values
+func (p *ImagePulls) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxConcurrentDownloads < 1 {
+		return errors.New("maxConcurrentDownloads must be a positive number")
+	}
+	if p.MaxParallelImagePulls < 0 {
+		return errors.New("maxParallelImagePulls must be a positive number")
+	}
+	if p.MaxParallelImagePulls > p.MaxConcurrentDownloads {
+		return fmt.Errorf("maxParallelImagePulls %d must not exceed maxConcurrentDownloads %d, the container runtime would queue the additional pulls",
+			p.MaxParallelImagePulls, p.MaxConcurrentDownloads)
+	}
+	return nil
+}
+
+// ParallelImagePulls returns the number of images the kubelet pulls at the same time
+func (p *ImagePulls) ParallelImagePulls() int {
+	if p.MaxParallelImagePulls == 0 {
+		return p.MaxConcurrentDownloads
+	}
+	return p.MaxParallelImagePulls
+}
+
+// ImagePullKubeletFlags returns the kubelet flags which match the pull concurrency of the container runtime
+func ImagePullKubeletFlags(kubeletVersion string, p *ImagePulls) ([]string, error) {
+	version, err := semver.NewVersion(kubeletVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubelet version %q: %v", kubeletVersion, err)
+	}
+
+	parallelPulls := p.ParallelImagePulls()
+	if parallelPulls == 1 {
+		return []string{"--serialize-image-pulls=true"}, nil
+	}
+	flags := []string{"--serialize-image-pulls=false"}
+	if !version.LessThan(maxParallelImagePullsMinKubeletVersion) {
+		flags = append(flags, fmt.Sprintf("--max-parallel-image-pulls=%d", parallelPulls))
+	}
+	return flags, nil
+}
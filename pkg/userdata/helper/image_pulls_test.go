@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImagePullsValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		imagePulls *ImagePulls
+		wantErr    bool
+	}{
+		{
+			name:       "not set",
+			imagePulls: nil,
+		},
+		{
+			name:       "runtime only",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: 10},
+		},
+		{
+			name:       "fewer kubelet pulls",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: 10, MaxParallelImagePulls: 5},
+		},
+		{
+			name:       "serialized kubelet pulls",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: 3, MaxParallelImagePulls: 1},
+		},
+		{
+			name:       "missing runtime downloads",
+			imagePulls: &ImagePulls{MaxParallelImagePulls: 5},
+			wantErr:    true,
+		},
+		{
+			name:       "negative runtime downloads",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: -1},
+			wantErr:    true,
+		},
+		{
+			name:       "negative kubelet pulls",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: 3, MaxParallelImagePulls: -1},
+			wantErr:    true,
+		},
+		{
+			name:       "more kubelet pulls than runtime downloads",
+			imagePulls: &ImagePulls{MaxConcurrentDownloads: 3, MaxParallelImagePulls: 5},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.imagePulls.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestImagePullKubeletFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletVersion string
+		imagePulls     *ImagePulls
+		expected       []string
+	}{
+		{
+			name:           "kubelet pulls default to the runtime downloads",
+			kubeletVersion: "1.27.2",
+			imagePulls:     &ImagePulls{MaxConcurrentDownloads: 10},
+			expected:       []string{"--serialize-image-pulls=false", "--max-parallel-image-pulls=10"},
+		},
+		{
+			name:           "explicit kubelet pulls",
+			kubeletVersion: "1.28.0",
+			imagePulls:     &ImagePulls{MaxConcurrentDownloads: 10, MaxParallelImagePulls: 4},
+			expected:       []string{"--serialize-image-pulls=false", "--max-parallel-image-pulls=4"},
+		},
+		{
+			name:           "serialized kubelet pulls",
+			kubeletVersion: "1.27.2",
+			imagePulls:     &ImagePulls{MaxConcurrentDownloads: 10, MaxParallelImagePulls: 1},
+			expected:       []string{"--serialize-image-pulls=true"},
+		},
+		{
+			name:           "single runtime download",
+			kubeletVersion: "1.27.2",
+			imagePulls:     &ImagePulls{MaxConcurrentDownloads: 1},
+			expected:       []string{"--serialize-image-pulls=true"},
+		},
+		{
+			name:           "kubelet without parallel pull limit",
+			kubeletVersion: "1.20.4",
+			imagePulls:     &ImagePulls{MaxConcurrentDownloads: 10},
+			expected:       []string{"--serialize-image-pulls=false"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags, err := ImagePullKubeletFlags(test.kubeletVersion, test.imagePulls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flags, test.expected) {
+				t.Errorf("expected kubelet flags %v, got %v", test.expected, flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+// imageReferenceRegexp is a simplified version of the grammar from github.com/docker/distribution/reference:
+// [registry[:port]/]path[:tag][@digest]
+var imageReferenceRegexp = regexp.MustCompile(`^` +
+	`([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*(:[0-9]+)?/)?` +
+	`[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*` +
+	`(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?` +
+	`(@sha256:[a-f0-9]{64})?$`)
+
+const prewarmImagesScriptTpl = `#!/bin/bash
+set -euo pipefail
+
+pull() {
+  for i in $(seq 1 5); do
+    if {{ .PullCommand }} "$1"; then
+      return 0
+    fi
+    sleep $((i * 5))
+  done
+  echo "failed to pull image $1" >&2
+  return 1
+}
+{{ range .Images }}
+pull {{ . | quote }}
+{{- end }}`
+
+// ValidateImageReferences checks that all given images are valid image references
+func ValidateImageReferences(images []string) error {
+	for _, image := range images {
+		if len(image) > 255 || !imageReferenceRegexp.MatchString(image) {
+			return fmt.Errorf("invalid image reference %q", image)
+		}
+	}
+	return nil
+}
+
+// PrewarmImagesScript returns a script which pulls the given images via the container runtime, retrying failed pulls
+func PrewarmImagesScript(containerRuntime string, images []string) (string, error) {
+	tmpl, err := template.New("prewarm-images-script").Funcs(TxtFuncMap()).Parse(prewarmImagesScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse prewarm-images-script template: %v", err)
+	}
+
+	data := struct {
+		PullCommand string
+		Images      []string
+	}{
+		PullCommand: "docker pull",
+		Images:      images,
+	}
+	if containerRuntime == ContainerRuntimeContainerd {
+		data.PullCommand = "/opt/bin/crictl pull"
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute prewarm-images-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// PrewarmImagesSystemdUnit returns the systemd unit which pulls the configured images once the container runtime is
+// running. The setup script starts it before the kubelet, a failed pull does not block the node from joining the cluster.
+func PrewarmImagesSystemdUnit(containerRuntime string) string {
+	unit := ContainerRuntimeSystemdUnit(containerRuntime)
+	return `[Unit]
+Requires=` + unit + `
+After=` + unit + `
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/bin/prewarm-images
+
+[Install]
+WantedBy=multi-user.target
+`
+}
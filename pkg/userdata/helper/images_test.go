@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateImageReferences(t *testing.T) {
+	tests := []struct {
+		image   string
+		wantErr bool
+	}{
+		{image: "nginx"},
+		{image: "nginx:1.15"},
+		{image: "k8s.gcr.io/pause:3.1"},
+		{image: "registry.local:5000/team/app:v1.0.0-rc.1"},
+		{image: "quay.io/coreos/etcd@sha256:e7d92cdc71feacf90708cb59182d0df1b911f8ae022d29e8e95d75ca6a99776a"},
+		{image: "", wantErr: true},
+		{image: "Nginx", wantErr: true},
+		{image: "nginx:", wantErr: true},
+		{image: "nginx; rm -rf /", wantErr: true},
+		{image: "quay.io/coreos/etcd@sha256:abc", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			err := ValidateImageReferences([]string{test.image})
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrewarmImagesScript(t *testing.T) {
+	script, err := PrewarmImagesScript(ContainerRuntimeDocker, []string{"k8s.gcr.io/pause:3.1", "registry.local:5000/team/app:v1.0.0"})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+
+	for _, expected := range []string{
+		`for i in $(seq 1 5); do`,
+		`if docker pull "$1"; then`,
+		"\npull \"k8s.gcr.io/pause:3.1\"\npull \"registry.local:5000/team/app:v1.0.0\"",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+		}
+	}
+}
+
+func TestPrewarmImagesScriptContainerd(t *testing.T) {
+	script, err := PrewarmImagesScript(ContainerRuntimeContainerd, []string{"k8s.gcr.io/pause:3.1"})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+	if !strings.Contains(script, `if /opt/bin/crictl pull "$1"; then`) {
+		t.Errorf("expected the script to pull via crictl, got:\n%s", script)
+	}
+	if unit := PrewarmImagesSystemdUnit(ContainerRuntimeContainerd); !strings.Contains(unit, "After=containerd.service") {
+		t.Errorf("expected the unit to start after containerd, got:\n%s", unit)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"text/template"
+)
+
+// mountPathRegexp restricts mount paths to characters which need no quoting in scripts
+var mountPathRegexp = regexp.MustCompile(`^[a-zA-Z0-9/._-]+$`)
+
+const defaultInstanceStoreMountPath = "/mnt/instance-store"
+
+const instanceStoreScriptTpl = `#!/bin/bash
+set -xeuo pipefail
+
+# The content of instance-store disks is lost when the instance stops, so they get set up on every boot
+mapfile -t disks < <(lsblk -dpno NAME,MODEL | awk '/Amazon EC2 NVMe Instance Storage/ {print $1}')
+if [[ ${#disks[@]} -eq 0 ]]; then
+  echo "no instance-store disks found"
+  exit 0
+fi
+
+mount_disk() {
+  if mountpoint -q "$2"; then
+    return 0
+  fi
+  blkid "$1" || mkfs.ext4 -F "$1"
+  mkdir -p "$2"
+  mount -o defaults,noatime "$1" "$2"
+}
+{{ if .RAID0 }}
+if [[ ${#disks[@]} -eq 1 ]]; then
+  mount_disk "${disks[0]}" {{ .MountPath }}
+  exit 0
+fi
+if [[ ! -e /dev/md/instance-store ]]; then
+  mdadm --create /dev/md/instance-store --run --level=0 --raid-devices=${#disks[@]} "${disks[@]}"
+fi
+mount_disk /dev/md/instance-store {{ .MountPath }}
+{{- else }}
+for i in "${!disks[@]}"; do
+  mount_disk "${disks[$i]}" {{ .MountPath }}/$i
+done
+{{- end }}`
+
+// InstanceStore formats and mounts the NVMe instance-store disks of the instance on every boot. The cloud provider
+// must map the instance-store volumes, e.g. with instanceStore on AWS.
+type InstanceStore struct {
+	// MountPath is the directory the disks get mounted at. Defaults to /mnt/instance-store
+	MountPath string `json:"mountPath,omitempty"`
+	// RAID0 stripes all disks into one RAID-0 device mounted at the MountPath. Otherwise every disk gets mounted
+	// at a directory named after its index below the MountPath
+	RAID0 bool `json:"raid0,omitempty"`
+}
+
+// Validate checks the InstanceStore for invalid values
+func (s *InstanceStore) Validate() error {
+	if s == nil || s.MountPath == "" {
+		return nil
+	}
+	if !path.IsAbs(s.MountPath) || path.Clean(s.MountPath) != s.MountPath || s.MountPath == "/" {
+		return fmt.Errorf("invalid mountPath %q, must be a clean absolute path other than /", s.MountPath)
+	}
+	if !mountPathRegexp.MatchString(s.MountPath) {
+		return fmt.Errorf("invalid mountPath %q, must only contain alphanumeric characters, '/', '.', '_' or '-'", s.MountPath)
+	}
+	return nil
+}
+
+// InstanceStoreScript returns a script which formats and mounts the instance-store disks
+func InstanceStoreScript(s *InstanceStore) (string, error) {
+	tmpl, err := template.New("instance-store-script").Funcs(TxtFuncMap()).Parse(instanceStoreScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse instance-store-script template: %v", err)
+	}
+
+	data := *s
+	if data.MountPath == "" {
+		data.MountPath = defaultInstanceStoreMountPath
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute instance-store-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// InstanceStoreSystemdUnit returns the systemd unit which sets up the instance-store disks on every boot before
+// the container runtime and the kubelet start, so pods never write to the root disk instead.
+func InstanceStoreSystemdUnit() string {
+	return `[Unit]
+Before=docker.service containerd.service kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/bin/setup-instance-store
+
+[Install]
+WantedBy=multi-user.target
+`
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstanceStoreValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		instanceStore *InstanceStore
+		wantErr       bool
+	}{
+		{
+			name:          "not set",
+			instanceStore: nil,
+		},
+		{
+			name:          "default mount path",
+			instanceStore: &InstanceStore{RAID0: true},
+		},
+		{
+			name:          "custom mount path",
+			instanceStore: &InstanceStore{MountPath: "/var/lib/cache"},
+		},
+		{
+			name:          "relative mount path",
+			instanceStore: &InstanceStore{MountPath: "var/lib/cache"},
+			wantErr:       true,
+		},
+		{
+			name:          "root",
+			instanceStore: &InstanceStore{MountPath: "/"},
+			wantErr:       true,
+		},
+		{
+			name:          "unclean mount path",
+			instanceStore: &InstanceStore{MountPath: "/var/lib/../cache/"},
+			wantErr:       true,
+		},
+		{
+			name:          "mount path with spaces",
+			instanceStore: &InstanceStore{MountPath: "/var/lib/my cache"},
+			wantErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.instanceStore.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestInstanceStoreScript(t *testing.T) {
+	script, err := InstanceStoreScript(&InstanceStore{MountPath: "/var/lib/cache", RAID0: true})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+	for _, expected := range []string{
+		`mount_disk "${disks[0]}" /var/lib/cache`,
+		`mdadm --create /dev/md/instance-store --run --level=0 --raid-devices=${#disks[@]} "${disks[@]}"`,
+		"mount_disk /dev/md/instance-store /var/lib/cache",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+		}
+	}
+
+	script, err = InstanceStoreScript(&InstanceStore{})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+	if strings.Contains(script, "mdadm") {
+		t.Errorf("expected the script to mount the disks without RAID, got:\n%s", script)
+	}
+	if expected := `mount_disk "${disks[$i]}" /mnt/instance-store/$i`; !strings.Contains(script, expected) {
+		t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// journald accepts sizes in bytes with an optional binary K, M, G or T suffix
+var journalSizeRegexp = regexp.MustCompile(`^[1-9][0-9]*[KMGT]?$`)
+
+// PersistentJournal makes journald store its logs in /var/log/journal, so they survive reboots
+type PersistentJournal struct {
+	// MaxSize caps the disk space the journal uses, e.g. 500M. Defaults to the 5G cap all nodes get
+	MaxSize string `json:"maxSize,omitempty"`
+}
+
+// Validate checks the PersistentJournal config for invalid values
+func (j *PersistentJournal) Validate() error {
+	if j == nil {
+		return nil
+	}
+	if j.MaxSize != "" && !journalSizeRegexp.MatchString(j.MaxSize) {
+		return fmt.Errorf("invalid maxSize %q, must be a number of bytes with an optional K, M, G or T suffix", j.MaxSize)
+	}
+	return nil
+}
+
+// JournaldConfig returns the journald drop-in which enables the persistent storage. It sorts after the
+// max_disk_use.conf drop-in, so its size cap takes precedence
+func JournaldConfig(j *PersistentJournal) string {
+	lines := []string{
+		"[Journal]",
+		"Storage=persistent",
+	}
+	if j.MaxSize != "" {
+		lines = append(lines, "SystemMaxUse="+j.MaxSize)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestPersistentJournalValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		journal *PersistentJournal
+		wantErr bool
+	}{
+		{
+			name:    "not set",
+			journal: nil,
+		},
+		{
+			name:    "default max size",
+			journal: &PersistentJournal{},
+		},
+		{
+			name:    "max size in megabytes",
+			journal: &PersistentJournal{MaxSize: "500M"},
+		},
+		{
+			name:    "max size in bytes",
+			journal: &PersistentJournal{MaxSize: "104857600"},
+		},
+		{
+			name:    "lowercase suffix",
+			journal: &PersistentJournal{MaxSize: "500m"},
+			wantErr: true,
+		},
+		{
+			name:    "decimal suffix",
+			journal: &PersistentJournal{MaxSize: "1GB"},
+			wantErr: true,
+		},
+		{
+			name:    "fraction",
+			journal: &PersistentJournal{MaxSize: "1.5G"},
+			wantErr: true,
+		},
+		{
+			name:    "zero",
+			journal: &PersistentJournal{MaxSize: "0"},
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			journal: &PersistentJournal{MaxSize: "-1G"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.journal.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJournaldConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		journal  *PersistentJournal
+		expected string
+	}{
+		{
+			name:     "default max size",
+			journal:  &PersistentJournal{},
+			expected: "[Journal]\nStorage=persistent",
+		},
+		{
+			name:     "custom max size",
+			journal:  &PersistentJournal{MaxSize: "500M"},
+			expected: "[Journal]\nStorage=persistent\nSystemMaxUse=500M",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if config := JournaldConfig(test.journal); config != test.expected {
+				t.Errorf("expected journald config:\n%s\ngot:\n%s", test.expected, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// kernelParameterRegexp matches a single kernel command line parameter like "quiet" or "hugepages=16".
+// Quotes, whitespace and shell meta characters are not allowed as the parameters end up in the grub config.
+var kernelParameterRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+(=[a-zA-Z0-9_.,:/=+-]+)?$`)
+
+// ValidateKernelParameters checks that all given kernel command line parameters are valid
+func ValidateKernelParameters(parameters []string) error {
+	for _, parameter := range parameters {
+		if !kernelParameterRegexp.MatchString(parameter) {
+			return fmt.Errorf("invalid kernel parameter %q", parameter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestValidateKernelParameters(t *testing.T) {
+	tests := []struct {
+		parameter string
+		wantErr   bool
+	}{
+		{parameter: "quiet"},
+		{parameter: "transparent_hugepage=never"},
+		{parameter: "hugepagesz=1G"},
+		{parameter: "intel_iommu=on"},
+		{parameter: "isolcpus=2-3,6-7"},
+		{parameter: "root=/dev/sda1"},
+		{parameter: "", wantErr: true},
+		{parameter: "=never", wantErr: true},
+		{parameter: "quiet splash", wantErr: true},
+		{parameter: `foo="bar"`, wantErr: true},
+		{parameter: "foo=$(reboot)", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.parameter, func(t *testing.T) {
+			err := ValidateKernelParameters([]string{test.parameter})
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
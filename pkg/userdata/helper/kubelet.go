@@ -26,7 +26,7 @@ import (
 const (
 	kubeletFlagsTpl = `--bootstrap-kubeconfig=/etc/kubernetes/bootstrap-kubelet.conf \
 --kubeconfig=/etc/kubernetes/kubelet.conf \
---pod-manifest-path=/etc/kubernetes/manifests \
+--pod-manifest-path={{ .PodManifestPath }} \
 --allow-privileged=true \
 --network-plugin=cni \
 --cni-conf-dir=/etc/cni/net.d \
@@ -51,13 +51,13 @@ const (
 --anonymous-auth=false \
 --protect-kernel-defaults=true \
 --cluster-dns={{ .ClusterDNSIPs | join "," }} \
---cluster-domain=cluster.local \
+--cluster-domain={{ .ClusterDomain }} \
 --kube-reserved=cpu=100m,memory=100Mi,ephemeral-storage=1Gi \
 --system-reserved=cpu=100m,memory=100Mi,ephemeral-storage=1Gi`
 
 	kubeletSystemdUnitTpl = `[Unit]
-After=docker.service
-Requires=docker.service
+After={{ containerRuntimeSystemdUnit .ContainerRuntime }}
+Requires={{ containerRuntimeSystemdUnit .ContainerRuntime }}
 
 Description=kubelet: The Kubernetes Node Agent
 Documentation=https://kubernetes.io/docs/home/
@@ -70,9 +70,10 @@ CPUAccounting=true
 MemoryAccounting=true
 
 Environment="PATH=/opt/bin:/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin/"
+EnvironmentFile=-/etc/kubernetes/kubelet-config.env
 
-ExecStart=/opt/bin/kubelet $KUBELET_EXTRA_ARGS \
-{{ kubeletFlags .KubeletVersion .CloudProvider .Hostname .ClusterDNSIPs .IsExternal | indent 2 }}
+ExecStart=/opt/bin/kubelet $KUBELET_EXTRA_ARGS $KUBELET_CONFIG_ARGS \
+{{ kubeletFlags .KubeletVersion .CloudProvider .Hostname .ClusterDNSIPs .ClusterDomain .IsExternal | indent 2 }}
 
 [Install]
 WantedBy=multi-user.target`
@@ -93,25 +94,29 @@ func CloudProviderFlags(cpName string, external bool) (string, error) {
 	return fmt.Sprintf(cpFlags, cpName), nil
 }
 
-// KubeletSystemdUnit returns the systemd unit for the kubelet
-func KubeletSystemdUnit(kubeletVersion, cloudProvider, hostname string, dnsIPs []net.IP, external bool) (string, error) {
+// KubeletSystemdUnit returns the systemd unit for the kubelet, which starts after the given container runtime
+func KubeletSystemdUnit(containerRuntime, kubeletVersion, cloudProvider, hostname string, dnsIPs []net.IP, clusterDomain string, external bool) (string, error) {
 	tmpl, err := template.New("kubelet-systemd-unit").Funcs(TxtFuncMap()).Parse(kubeletSystemdUnitTpl)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse kubelet-systemd-unit template: %v", err)
 	}
 
 	data := struct {
-		KubeletVersion string
-		CloudProvider  string
-		Hostname       string
-		ClusterDNSIPs  []net.IP
-		IsExternal     bool
+		ContainerRuntime string
+		KubeletVersion   string
+		CloudProvider    string
+		Hostname         string
+		ClusterDNSIPs    []net.IP
+		ClusterDomain    string
+		IsExternal       bool
 	}{
-		KubeletVersion: kubeletVersion,
-		CloudProvider:  cloudProvider,
-		Hostname:       hostname,
-		ClusterDNSIPs:  dnsIPs,
-		IsExternal:     external,
+		ContainerRuntime: containerRuntime,
+		KubeletVersion:   kubeletVersion,
+		CloudProvider:    cloudProvider,
+		Hostname:         hostname,
+		ClusterDNSIPs:    dnsIPs,
+		ClusterDomain:    clusterDomain,
+		IsExternal:       external,
 	}
 	b := &bytes.Buffer{}
 	err = tmpl.Execute(b, data)
@@ -123,24 +128,28 @@ func KubeletSystemdUnit(kubeletVersion, cloudProvider, hostname string, dnsIPs [
 }
 
 // KubeletFlags returns the kubelet flags
-func KubeletFlags(version, cloudProvider, hostname string, dnsIPs []net.IP, external bool) (string, error) {
+func KubeletFlags(version, cloudProvider, hostname string, dnsIPs []net.IP, clusterDomain string, external bool) (string, error) {
 	tmpl, err := template.New("kubelet-flags").Funcs(TxtFuncMap()).Parse(kubeletFlagsTpl)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse kubelet-flags template: %v", err)
 	}
 
 	data := struct {
-		CloudProvider  string
-		Hostname       string
-		ClusterDNSIPs  []net.IP
-		KubeletVersion string
-		IsExternal     bool
+		CloudProvider   string
+		Hostname        string
+		ClusterDNSIPs   []net.IP
+		ClusterDomain   string
+		KubeletVersion  string
+		IsExternal      bool
+		PodManifestPath string
 	}{
-		CloudProvider:  cloudProvider,
-		Hostname:       hostname,
-		ClusterDNSIPs:  dnsIPs,
-		KubeletVersion: version,
-		IsExternal:     external,
+		CloudProvider:   cloudProvider,
+		Hostname:        hostname,
+		ClusterDNSIPs:   dnsIPs,
+		ClusterDomain:   clusterDomain,
+		KubeletVersion:  version,
+		IsExternal:      external,
+		PodManifestPath: KubeletPodManifestPath,
 	}
 	b := &bytes.Buffer{}
 	err = tmpl.Execute(b, data)
@@ -166,7 +175,19 @@ WantedBy=multi-user.target
 }
 
 // ContainerRuntimeHealthCheckSystemdUnit container-runtime health checking systemd unit
-func ContainerRuntimeHealthCheckSystemdUnit() string {
+func ContainerRuntimeHealthCheckSystemdUnit(runtime string) string {
+	if runtime == ContainerRuntimeContainerd {
+		return `[Unit]
+Requires=containerd.service
+After=containerd.service
+
+[Service]
+Environment="CONTAINER_RUNTIME=remote" "CONTAINER_RUNTIME_NAME=containerd"
+ExecStart=/opt/bin/health-monitor.sh container-runtime
+
+[Install]
+WantedBy=multi-user.target`
+	}
 	return `[Unit]
 Requires=docker.service
 After=docker.service
@@ -27,12 +27,14 @@ import (
 )
 
 type kubeletFlagTestCase struct {
-	name          string
-	version       *semver.Version
-	dnsIPs        []net.IP
-	hostname      string
-	cloudProvider string
-	external      bool
+	name             string
+	version          *semver.Version
+	dnsIPs           []net.IP
+	clusterDomain    string
+	hostname         string
+	cloudProvider    string
+	external         bool
+	containerRuntime string
 }
 
 func TestKubeletSystemdUnit(t *testing.T) {
@@ -72,12 +74,30 @@ func TestKubeletSystemdUnit(t *testing.T) {
 			hostname:      "some-test-node",
 			cloudProvider: "aws",
 		},
+		{
+			name:             "containerd",
+			version:          semver.MustParse("v1.13.5"),
+			dnsIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			hostname:         "some-test-node",
+			containerRuntime: ContainerRuntimeContainerd,
+		},
+		{
+			name:          "cluster-domain",
+			version:       semver.MustParse("v1.13.5"),
+			dnsIPs:        []net.IP{net.ParseIP("10.96.0.10")},
+			clusterDomain: "k8s.example.com",
+			hostname:      "some-test-node",
+		},
 	}...)
 
 	for _, test := range tests {
 		name := fmt.Sprintf("kublet_systemd_unit_%s", test.name)
 		t.Run(name, func(t *testing.T) {
-			out, err := KubeletSystemdUnit(test.version.String(), test.cloudProvider, test.hostname, test.dnsIPs, test.external)
+			clusterDomain := test.clusterDomain
+			if clusterDomain == "" {
+				clusterDomain = "cluster.local"
+			}
+			out, err := KubeletSystemdUnit(test.containerRuntime, test.version.String(), test.cloudProvider, test.hostname, test.dnsIPs, clusterDomain, test.external)
 			if err != nil {
 				t.Error(err)
 			}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultKubeletWatchdogInterval         = time.Minute
+	defaultKubeletWatchdogRestartThreshold = 3
+	defaultKubeletWatchdogRebootThreshold  = 10
+
+	// The health check times out after 10 seconds, shorter intervals would overlap
+	minKubeletWatchdogInterval = 10 * time.Second
+)
+
+const kubeletWatchdogScriptTpl = `#!/bin/bash
+set -euo pipefail
+
+# Counts the consecutive failed health checks, /run gets cleared on reboot
+FAILURES_FILE=/run/kubelet-watchdog/failures
+mkdir -p "$(dirname "${FAILURES_FILE}")"
+failures="$(cat "${FAILURES_FILE}" 2>/dev/null || echo 0)"
+
+if curl -sf -m 10 http://localhost:10248/healthz >/dev/null; then
+  echo 0 > "${FAILURES_FILE}"
+  exit 0
+fi
+
+failures=$((failures + 1))
+echo "${failures}" > "${FAILURES_FILE}"
+echo "Kubelet health check failed ${failures} times in a row"
+
+if (( failures >= {{ .RebootThreshold }} )); then
+  echo "Rebooting the node"
+  systemctl reboot
+elif (( failures % {{ .RestartThreshold }} == 0 )); then
+  echo "Restarting the kubelet"
+  systemctl restart kubelet
+fi`
+
+// KubeletWatchdog periodically checks the health of the kubelet and restarts it or reboots the node after
+// repeated failures, so a node with an unresponsive kubelet does not stay NotReady forever.
+type KubeletWatchdog struct {
+	// Interval between the health checks, e.g. 30s. Defaults to 1m. The checks start 5 minutes after boot
+	Interval string `json:"interval,omitempty"`
+	// RestartThreshold is the number of consecutive failed health checks after which the kubelet gets restarted.
+	// Defaults to 3
+	RestartThreshold int `json:"restartThreshold,omitempty"`
+	// RebootThreshold is the number of consecutive failed health checks after which the node gets rebooted.
+	// It must be greater than the RestartThreshold, so restarting the kubelet gets tried first. Defaults to 10
+	RebootThreshold int `json:"rebootThreshold,omitempty"`
+}
+
+// Validate checks the KubeletWatchdog config for invalid values
+func (w *KubeletWatchdog) Validate() error {
+	if w == nil {
+		return nil
+	}
+	if w.Interval != "" {
+		interval, err := time.ParseDuration(w.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval %q: %v", w.Interval, err)
+		}
+		if interval < minKubeletWatchdogInterval {
+			return fmt.Errorf("interval must be at least %s, got %s", minKubeletWatchdogInterval, w.Interval)
+		}
+	}
+	if w.RestartThreshold < 0 {
+		return errors.New("restartThreshold must not be negative")
+	}
+	if w.RebootThreshold < 0 {
+		return errors.New("rebootThreshold must not be negative")
+	}
+	if restartThreshold, rebootThreshold := w.restartThreshold(), w.rebootThreshold(); rebootThreshold <= restartThreshold {
+		return fmt.Errorf("rebootThreshold (%d) must be greater than restartThreshold (%d)", rebootThreshold, restartThreshold)
+	}
+	return nil
+}
+
+func (w *KubeletWatchdog) interval() time.Duration {
+	if interval, err := time.ParseDuration(w.Interval); err == nil {
+		return interval
+	}
+	return defaultKubeletWatchdogInterval
+}
+
+func (w *KubeletWatchdog) restartThreshold() int {
+	if w.RestartThreshold == 0 {
+		return defaultKubeletWatchdogRestartThreshold
+	}
+	return w.RestartThreshold
+}
+
+func (w *KubeletWatchdog) rebootThreshold() int {
+	if w.RebootThreshold == 0 {
+		return defaultKubeletWatchdogRebootThreshold
+	}
+	return w.RebootThreshold
+}
+
+// KubeletWatchdogScript returns the script which checks the health of the kubelet once and restarts
+// it or reboots the node once the failed checks reach the thresholds.
+func KubeletWatchdogScript(w *KubeletWatchdog) (string, error) {
+	tmpl, err := template.New("kubelet-watchdog-script").Parse(kubeletWatchdogScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse kubelet-watchdog-script template: %v", err)
+	}
+	data := struct {
+		RestartThreshold int
+		RebootThreshold  int
+	}{
+		RestartThreshold: w.restartThreshold(),
+		RebootThreshold:  w.rebootThreshold(),
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute kubelet-watchdog-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// KubeletWatchdogSystemdUnit returns the systemd unit which runs a single health check of the kubelet
+func KubeletWatchdogSystemdUnit() string {
+	return `[Unit]
+After=kubelet.service
+
+[Service]
+Type=oneshot
+ExecStart=/opt/bin/kubelet-watchdog
+`
+}
+
+// KubeletWatchdogSystemdTimer returns the systemd timer which triggers the health checks of the kubelet
+// in the configured interval. The kubelet gets 5 minutes after boot to become healthy.
+func KubeletWatchdogSystemdTimer(w *KubeletWatchdog) string {
+	return fmt.Sprintf(`[Timer]
+OnBootSec=5min
+OnUnitActiveSec=%ds
+AccuracySec=1s
+
+[Install]
+WantedBy=timers.target
+`, int(w.interval().Seconds()))
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKubeletWatchdogValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		watchdog *KubeletWatchdog
+		wantErr  bool
+	}{
+		{
+			name:     "not set",
+			watchdog: nil,
+		},
+		{
+			name:     "defaults",
+			watchdog: &KubeletWatchdog{},
+		},
+		{
+			name:     "custom thresholds",
+			watchdog: &KubeletWatchdog{Interval: "30s", RestartThreshold: 2, RebootThreshold: 6},
+		},
+		{
+			name:     "invalid interval",
+			watchdog: &KubeletWatchdog{Interval: "often"},
+			wantErr:  true,
+		},
+		{
+			name:     "interval too short",
+			watchdog: &KubeletWatchdog{Interval: "5s"},
+			wantErr:  true,
+		},
+		{
+			name:     "negative restart threshold",
+			watchdog: &KubeletWatchdog{RestartThreshold: -1},
+			wantErr:  true,
+		},
+		{
+			name:     "negative reboot threshold",
+			watchdog: &KubeletWatchdog{RebootThreshold: -1},
+			wantErr:  true,
+		},
+		{
+			name:     "reboot threshold equals restart threshold",
+			watchdog: &KubeletWatchdog{RestartThreshold: 5, RebootThreshold: 5},
+			wantErr:  true,
+		},
+		{
+			name:     "restart threshold above the default reboot threshold",
+			watchdog: &KubeletWatchdog{RestartThreshold: 12},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.watchdog.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestKubeletWatchdogRendering(t *testing.T) {
+	tests := []struct {
+		name           string
+		watchdog       *KubeletWatchdog
+		expectedScript []string
+		expectedTimer  string
+	}{
+		{
+			name:           "defaults",
+			watchdog:       &KubeletWatchdog{},
+			expectedScript: []string{"failures >= 10", "failures % 3 == 0"},
+			expectedTimer:  "OnUnitActiveSec=60s",
+		},
+		{
+			name:           "custom thresholds",
+			watchdog:       &KubeletWatchdog{Interval: "2m30s", RestartThreshold: 2, RebootThreshold: 7},
+			expectedScript: []string{"failures >= 7", "failures % 2 == 0"},
+			expectedTimer:  "OnUnitActiveSec=150s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := KubeletWatchdogScript(test.watchdog)
+			if err != nil {
+				t.Fatalf("failed to render kubelet watchdog script: %v", err)
+			}
+			for _, expected := range test.expectedScript {
+				if !strings.Contains(script, expected) {
+					t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+				}
+			}
+			if timer := KubeletWatchdogSystemdTimer(test.watchdog); !strings.Contains(timer, test.expectedTimer) {
+				t.Errorf("expected the timer to contain %q, got:\n%s", test.expectedTimer, timer)
+			}
+		})
+	}
+}
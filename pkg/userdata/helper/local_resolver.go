@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+const (
+	// LocalResolverDnsmasq runs dnsmasq as local resolver
+	LocalResolverDnsmasq = "dnsmasq"
+	// LocalResolverUnbound runs unbound as local resolver
+	LocalResolverUnbound = "unbound"
+
+	defaultLocalResolverCacheSize = 1000
+	// dnsmasq does not cache more than 10000 names
+	maxLocalResolverCacheSize = 10000
+	// glibc and the kubelet only use the first three nameservers of a resolv.conf
+	maxResolvConfNameservers = 3
+)
+
+const localResolverScriptTpl = `systemctl enable {{ .Software }}
+systemctl restart {{ .Software }}
+
+# Pods with dnsPolicy Default and the cluster DNS get the upstream servers, the node itself uses the local resolver
+mkdir -p /etc/kubernetes
+cat <<EOF > /etc/kubernetes/upstream-resolv.conf
+{{- range .KubeletNameservers }}
+nameserver {{ . }}
+{{- end }}
+EOF
+if [[ -d /etc/NetworkManager/conf.d ]]; then
+  # Keeps NetworkManager from overwriting /etc/resolv.conf on DHCP renewals
+  printf '[main]\ndns=none\n' > /etc/NetworkManager/conf.d/90-local-resolver.conf
+  systemctl reload NetworkManager || true
+fi
+# On Ubuntu /etc/resolv.conf is a symlink to the stub of systemd-resolved
+rm -f /etc/resolv.conf
+echo "nameserver 127.0.0.1" > /etc/resolv.conf`
+
+// LocalResolver runs dnsmasq or unbound as caching DNS resolver on the node and points /etc/resolv.conf at it.
+type LocalResolver struct {
+	// Software is the resolver which gets installed, either dnsmasq or unbound. Defaults to dnsmasq
+	Software string `json:"software,omitempty"`
+	// UpstreamServers are the IP addresses of the nameservers the resolver forwards queries to
+	UpstreamServers []string `json:"upstreamServers"`
+	// CacheSize is the number of cached records. Defaults to 1000. unbound reserves 1KiB of message cache and
+	// 2KiB of RRset cache per record
+	CacheSize int `json:"cacheSize,omitempty"`
+}
+
+// Validate checks the LocalResolver for invalid values
+func (r *LocalResolver) Validate() error {
+	if r == nil {
+		return nil
+	}
+	switch r.Software {
+	case "", LocalResolverDnsmasq, LocalResolverUnbound:
+	default:
+		return fmt.Errorf("unknown software %q, must be %s or %s", r.Software, LocalResolverDnsmasq, LocalResolverUnbound)
+	}
+	if len(r.UpstreamServers) == 0 {
+		return errors.New("at least one upstream server is required")
+	}
+	for _, server := range r.UpstreamServers {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return fmt.Errorf("upstream server %q is not a valid ip address", server)
+		}
+		// The node would query itself in a loop
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return fmt.Errorf("upstream server %q must not be a loopback or unspecified address", server)
+		}
+	}
+	if r.CacheSize < 0 || r.CacheSize > maxLocalResolverCacheSize {
+		return fmt.Errorf("cache size must be between 1 and %d, got %d", maxLocalResolverCacheSize, r.CacheSize)
+	}
+	return nil
+}
+
+func (r *LocalResolver) software() string {
+	if r.Software == "" {
+		return LocalResolverDnsmasq
+	}
+	return r.Software
+}
+
+func (r *LocalResolver) cacheSize() int {
+	if r.CacheSize == 0 {
+		return defaultLocalResolverCacheSize
+	}
+	return r.CacheSize
+}
+
+// LocalResolverPackage returns the package which provides the resolver. It is named the same on all distributions
+// as well as its systemd service.
+func LocalResolverPackage(r *LocalResolver) string {
+	return r.software()
+}
+
+// LocalResolverConfigPath returns the path of the config file of the resolver, which replaces the one of the
+// distribution
+func LocalResolverConfigPath(r *LocalResolver) string {
+	if r.software() == LocalResolverUnbound {
+		return "/etc/unbound/unbound.conf"
+	}
+	return "/etc/dnsmasq.conf"
+}
+
+// LocalResolverConfig returns the config of the resolver. It only listens on 127.0.0.1, so it does not conflict with
+// the stub of systemd-resolved on 127.0.0.53
+func LocalResolverConfig(r *LocalResolver) string {
+	var lines []string
+	if r.software() == LocalResolverUnbound {
+		lines = append(lines,
+			"server:",
+			"  interface: 127.0.0.1",
+			"  access-control: 127.0.0.0/8 allow",
+			fmt.Sprintf("  msg-cache-size: %dk", r.cacheSize()),
+			fmt.Sprintf("  rrset-cache-size: %dk", 2*r.cacheSize()),
+			"forward-zone:",
+			`  name: "."`)
+		for _, server := range r.UpstreamServers {
+			lines = append(lines, "  forward-addr: "+server)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines,
+		"listen-address=127.0.0.1",
+		"bind-interfaces",
+		"no-resolv")
+	for _, server := range r.UpstreamServers {
+		lines = append(lines, "server="+server)
+	}
+	lines = append(lines, fmt.Sprintf("cache-size=%d", r.cacheSize()))
+	return strings.Join(lines, "\n")
+}
+
+// LocalResolverScript returns the script which starts the resolver and points /etc/resolv.conf at it. The kubelet
+// gets the upstream servers in /etc/kubernetes/upstream-resolv.conf instead, as pods can not reach the resolver
+// on the loopback address of the node.
+func LocalResolverScript(r *LocalResolver) (string, error) {
+	tmpl, err := template.New("local-resolver-script").Funcs(TxtFuncMap()).Parse(localResolverScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse local-resolver-script template: %v", err)
+	}
+
+	nameservers := r.UpstreamServers
+	if len(nameservers) > maxResolvConfNameservers {
+		nameservers = nameservers[:maxResolvConfNameservers]
+	}
+	data := struct {
+		Software           string
+		KubeletNameservers []string
+	}{
+		Software:           r.software(),
+		KubeletNameservers: nameservers,
+	}
+
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute local-resolver-script template: %v", err)
+	}
+	return b.String(), nil
+}
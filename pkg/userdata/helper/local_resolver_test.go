@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalResolverValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver *LocalResolver
+		wantErr  bool
+	}{
+		{
+			name:     "not set",
+			resolver: nil,
+		},
+		{
+			name:     "dnsmasq with defaults",
+			resolver: &LocalResolver{UpstreamServers: []string{"10.0.0.2"}},
+		},
+		{
+			name:     "unbound",
+			resolver: &LocalResolver{Software: LocalResolverUnbound, UpstreamServers: []string{"10.0.0.2", "fd00::2"}, CacheSize: 10000},
+		},
+		{
+			name:     "unknown software",
+			resolver: &LocalResolver{Software: "bind", UpstreamServers: []string{"10.0.0.2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "no upstream servers",
+			resolver: &LocalResolver{},
+			wantErr:  true,
+		},
+		{
+			name:     "upstream server hostname",
+			resolver: &LocalResolver{UpstreamServers: []string{"dns.example.com"}},
+			wantErr:  true,
+		},
+		{
+			name:     "loopback upstream server",
+			resolver: &LocalResolver{UpstreamServers: []string{"127.0.0.53"}},
+			wantErr:  true,
+		},
+		{
+			name:     "negative cache size",
+			resolver: &LocalResolver{UpstreamServers: []string{"10.0.0.2"}, CacheSize: -1},
+			wantErr:  true,
+		},
+		{
+			name:     "cache size too large",
+			resolver: &LocalResolver{UpstreamServers: []string{"10.0.0.2"}, CacheSize: 10001},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.resolver.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLocalResolverConfig(t *testing.T) {
+	dnsmasq := &LocalResolver{UpstreamServers: []string{"10.0.0.2", "10.0.0.3"}}
+	if path := LocalResolverConfigPath(dnsmasq); path != "/etc/dnsmasq.conf" {
+		t.Errorf("expected dnsmasq to be the default, got config path %s", path)
+	}
+	config := LocalResolverConfig(dnsmasq)
+	for _, directive := range []string{"listen-address=127.0.0.1", "no-resolv", "server=10.0.0.2", "server=10.0.0.3", "cache-size=1000"} {
+		if !strings.Contains(config+"\n", directive+"\n") {
+			t.Errorf("expected %q in the dnsmasq config, got:\n%s", directive, config)
+		}
+	}
+
+	unbound := &LocalResolver{Software: LocalResolverUnbound, UpstreamServers: []string{"10.0.0.2"}, CacheSize: 5000}
+	if path := LocalResolverConfigPath(unbound); path != "/etc/unbound/unbound.conf" {
+		t.Errorf("expected the unbound config path, got %s", path)
+	}
+	config = LocalResolverConfig(unbound)
+	for _, directive := range []string{"interface: 127.0.0.1", "msg-cache-size: 5000k", "rrset-cache-size: 10000k", "forward-addr: 10.0.0.2"} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("expected %q in the unbound config, got:\n%s", directive, config)
+		}
+	}
+}
+
+func TestLocalResolverScript(t *testing.T) {
+	script, err := LocalResolverScript(&LocalResolver{
+		Software:        LocalResolverUnbound,
+		UpstreamServers: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"systemctl restart unbound", "nameserver 10.0.0.4\n", `echo "nameserver 127.0.0.1" > /etc/resolv.conf`} {
+		if !strings.Contains(script, line) {
+			t.Errorf("expected %q in the script, got:\n%s", line, script)
+		}
+	}
+	if strings.Contains(script, "10.0.0.5") {
+		t.Errorf("expected the kubelet to only get three upstream servers, got:\n%s", script)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MachineIDSourceRandom regenerates the machine-id on the first boot
+	MachineIDSourceRandom = "random"
+	// MachineIDSourceInstanceID derives the machine-id from the instance ID known to cloud-init
+	MachineIDSourceInstanceID = "instance-id"
+)
+
+// MachineID replaces the machine-id of the image during the first boot. Instances of cloned images may share it,
+// which causes colliding DHCP leases and node identities.
+type MachineID struct {
+	// Source is either random or instance-id, which keeps the machine-id stable for the lifetime of the instance
+	Source string `json:"source"`
+}
+
+// Validate checks the MachineID for invalid values
+func (m *MachineID) Validate() error {
+	if m == nil {
+		return nil
+	}
+	switch m.Source {
+	case MachineIDSourceRandom, MachineIDSourceInstanceID:
+		return nil
+	}
+	return fmt.Errorf("invalid machine-id source %q, must be %s or %s", m.Source, MachineIDSourceRandom, MachineIDSourceInstanceID)
+}
+
+// MachineIDScript returns a script which replaces /etc/machine-id. The services which already read it only pick
+// up the new machine-id after a reboot.
+func MachineIDScript(m *MachineID) string {
+	lines := []string{"rm -f /etc/machine-id"}
+	if m.Source == MachineIDSourceInstanceID {
+		// The md5 sum has the format of a machine-id, 32 lowercase hex characters
+		lines = append(lines, "md5sum < /var/lib/cloud/data/instance-id | cut -d ' ' -f 1 > /etc/machine-id")
+	} else {
+		lines = append(lines, "systemd-machine-id-setup")
+	}
+	lines = append(lines,
+		"if [[ -d /var/lib/dbus ]]; then",
+		"  ln -sf /etc/machine-id /var/lib/dbus/machine-id",
+		"fi")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMachineIDValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		machineID *MachineID
+		wantErr   bool
+	}{
+		{
+			name:      "not set",
+			machineID: nil,
+		},
+		{
+			name:      "random",
+			machineID: &MachineID{Source: MachineIDSourceRandom},
+		},
+		{
+			name:      "instance-id",
+			machineID: &MachineID{Source: MachineIDSourceInstanceID},
+		},
+		{
+			name:      "empty source",
+			machineID: &MachineID{},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid source",
+			machineID: &MachineID{Source: "hostname"},
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.machineID.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMachineIDScript(t *testing.T) {
+	random := MachineIDScript(&MachineID{Source: MachineIDSourceRandom})
+	if !strings.Contains(random, "systemd-machine-id-setup") || strings.Contains(random, "instance-id") {
+		t.Errorf("expected the machine-id to be regenerated, got:\n%s", random)
+	}
+	fromInstanceID := MachineIDScript(&MachineID{Source: MachineIDSourceInstanceID})
+	if !strings.Contains(fromInstanceID, "/var/lib/cloud/data/instance-id") || strings.Contains(fromInstanceID, "systemd-machine-id-setup") {
+		t.Errorf("expected the machine-id to be derived from the instance ID, got:\n%s", fromInstanceID)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+const (
+	defaultNodeExporterVersion           = "0.18.1"
+	defaultNodeExporterListenAddress     = ":9100"
+	defaultNodeExporterTextfileDirectory = "/var/lib/node_exporter/textfile_collector"
+)
+
+// nodeExporterVersionRegexp matches release versions like 0.18.1
+var nodeExporterVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// The kubelet already listens on these ports
+var kubeletPorts = map[int]bool{10248: true, 10250: true, 10255: true}
+
+const nodeExporterInstallScriptTpl = `# node_exporter
+if [[ "$(/opt/bin/node_exporter --version 2>&1 | awk 'NR==1 {print $3}')" != "{{ .Version }}" ]]; then
+    curl -Lf https://github.com/prometheus/node_exporter/releases/download/v{{ .Version }}/node_exporter-{{ .Version }}.linux-amd64.tar.gz | \
+      tar -xzC /opt/bin --strip-components=1 -f - node_exporter-{{ .Version }}.linux-amd64/node_exporter
+fi
+mkdir -p {{ .TextfileDirectory }}`
+
+const nodeExporterSystemdUnitTpl = `[Unit]
+Description=Prometheus node_exporter
+Requires=network-online.target
+After=network-online.target
+
+[Service]
+User=nobody
+ExecStart=/opt/bin/node_exporter \
+  --web.listen-address={{ .ListenAddress }} \
+  --collector.textfile.directory={{ .TextfileDirectory }}
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// NodeExporter installs the Prometheus node_exporter as systemd service, so the node metrics are available
+// without a privileged DaemonSet.
+type NodeExporter struct {
+	// Version of the node_exporter release, e.g. 0.18.1. Defaults to 0.18.1
+	Version string `json:"version,omitempty"`
+	// ListenAddress is the [host]:port the metrics get served on. Defaults to :9100
+	ListenAddress string `json:"listenAddress,omitempty"`
+	// TextfileDirectory is read by the textfile collector, other services can drop *.prom files with
+	// additional metrics into it. Defaults to /var/lib/node_exporter/textfile_collector
+	TextfileDirectory string `json:"textfileDirectory,omitempty"`
+}
+
+// Validate checks the NodeExporter config for invalid values
+func (e *NodeExporter) Validate() error {
+	if e == nil {
+		return nil
+	}
+	if e.Version != "" && !nodeExporterVersionRegexp.MatchString(e.Version) {
+		return fmt.Errorf("invalid version %q, must look like 0.18.1", e.Version)
+	}
+	if e.ListenAddress != "" {
+		host, rawPort, err := net.SplitHostPort(e.ListenAddress)
+		if err != nil {
+			return fmt.Errorf("invalid listenAddress %q: %v", e.ListenAddress, err)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid listenAddress %q, the host must be an IP address", e.ListenAddress)
+		}
+		port, err := strconv.Atoi(rawPort)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid listenAddress %q, the port must be between 1 and 65535", e.ListenAddress)
+		}
+		if kubeletPorts[port] {
+			return fmt.Errorf("invalid listenAddress %q, port %d is used by the kubelet", e.ListenAddress, port)
+		}
+	}
+	if e.TextfileDirectory != "" {
+		if !path.IsAbs(e.TextfileDirectory) || path.Clean(e.TextfileDirectory) != e.TextfileDirectory || e.TextfileDirectory == "/" {
+			return fmt.Errorf("invalid textfileDirectory %q, must be a clean absolute path other than /", e.TextfileDirectory)
+		}
+		if !mountPathRegexp.MatchString(e.TextfileDirectory) {
+			return fmt.Errorf("invalid textfileDirectory %q, must only contain alphanumeric characters, '/', '.', '_' or '-'", e.TextfileDirectory)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the config with the defaults filled in
+func (e *NodeExporter) withDefaults() NodeExporter {
+	config := *e
+	if config.Version == "" {
+		config.Version = defaultNodeExporterVersion
+	}
+	if config.ListenAddress == "" {
+		config.ListenAddress = defaultNodeExporterListenAddress
+	}
+	if config.TextfileDirectory == "" {
+		config.TextfileDirectory = defaultNodeExporterTextfileDirectory
+	}
+	return config
+}
+
+// NodeExporterInstallScript returns the script which installs the configured node_exporter release
+func NodeExporterInstallScript(e *NodeExporter) (string, error) {
+	return renderNodeExporterTemplate("node-exporter-install-script", nodeExporterInstallScriptTpl, e)
+}
+
+// NodeExporterSystemdUnit returns the systemd unit which runs the node_exporter
+func NodeExporterSystemdUnit(e *NodeExporter) (string, error) {
+	return renderNodeExporterTemplate("node-exporter-systemd-unit", nodeExporterSystemdUnitTpl, e)
+}
+
+func renderNodeExporterTemplate(name, text string, e *NodeExporter) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, e.withDefaults()); err != nil {
+		return "", fmt.Errorf("failed to execute %s template: %v", name, err)
+	}
+	return b.String(), nil
+}
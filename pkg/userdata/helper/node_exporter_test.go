@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeExporterValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *NodeExporter
+		expectedErr bool
+	}{
+		{
+			name:   "not set",
+			config: nil,
+		},
+		{
+			name:   "defaults",
+			config: &NodeExporter{},
+		},
+		{
+			name:   "all set",
+			config: &NodeExporter{Version: "0.17.0", ListenAddress: "127.0.0.1:9101", TextfileDirectory: "/var/lib/metrics"},
+		},
+		{
+			name:        "version with v prefix",
+			config:      &NodeExporter{Version: "v0.18.1"},
+			expectedErr: true,
+		},
+		{
+			name:        "listen address without port",
+			config:      &NodeExporter{ListenAddress: "0.0.0.0"},
+			expectedErr: true,
+		},
+		{
+			name:        "listen address with hostname",
+			config:      &NodeExporter{ListenAddress: "localhost:9100"},
+			expectedErr: true,
+		},
+		{
+			name:        "port out of range",
+			config:      &NodeExporter{ListenAddress: ":70000"},
+			expectedErr: true,
+		},
+		{
+			name:        "port of the kubelet",
+			config:      &NodeExporter{ListenAddress: ":10250"},
+			expectedErr: true,
+		},
+		{
+			name:        "relative textfile directory",
+			config:      &NodeExporter{TextfileDirectory: "var/lib/metrics"},
+			expectedErr: true,
+		},
+		{
+			name:        "textfile directory with spaces",
+			config:      &NodeExporter{TextfileDirectory: "/var/lib/my metrics"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Validate()
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestNodeExporterRendering(t *testing.T) {
+	script, err := NodeExporterInstallScript(&NodeExporter{})
+	if err != nil {
+		t.Fatalf("failed to render the install script: %v", err)
+	}
+	for _, expected := range []string{
+		"https://github.com/prometheus/node_exporter/releases/download/v0.18.1/node_exporter-0.18.1.linux-amd64.tar.gz",
+		"mkdir -p /var/lib/node_exporter/textfile_collector",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the install script to contain %q, got:\n%s", expected, script)
+		}
+	}
+
+	unit, err := NodeExporterSystemdUnit(&NodeExporter{ListenAddress: "127.0.0.1:9101", TextfileDirectory: "/var/lib/metrics"})
+	if err != nil {
+		t.Fatalf("failed to render the systemd unit: %v", err)
+	}
+	for _, expected := range []string{
+		"--web.listen-address=127.0.0.1:9101",
+		"--collector.textfile.directory=/var/lib/metrics",
+	} {
+		if !strings.Contains(unit, expected) {
+			t.Errorf("expected the systemd unit to contain %q, got:\n%s", expected, unit)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// The node profiles apply a curated bundle of kubelet and container runtime settings for a kind of node pool
+const (
+	// NodeProfileGeneral keeps the defaults
+	NodeProfileGeneral = "general"
+	// NodeProfileGPU pins the CPUs of guaranteed pods and aligns them with the NUMA node of their GPUs
+	NodeProfileGPU = "gpu"
+	// NodeProfileStorage suits nodes which run storage systems on large data disks
+	NodeProfileStorage = "storage"
+)
+
+const (
+	storageProfileEvictionHard         = "memory.available<100Mi,nodefs.available<5Gi,nodefs.inodesFree<100000,imagefs.available<5Gi"
+	storageProfileVolumeStatsAggPeriod = "5m"
+	gpuProfileDockerDefaultUlimit      = "memlock=-1:-1"
+	storageProfileDockerDefaultUlimit  = "nofile=1048576:1048576"
+)
+
+var (
+	// The topology manager exists starting with 1.16 and is enabled by default starting with 1.18
+	topologyManagerMinKubeletVersion       = semver.MustParse("1.16.0")
+	topologyManagerDefaultOnKubeletVersion = semver.MustParse("1.18.0")
+)
+
+// ValidateNodeProfile checks if the node profile exists. An empty profile is the same as the general one
+func ValidateNodeProfile(profile string) error {
+	switch profile {
+	case "", NodeProfileGeneral, NodeProfileGPU, NodeProfileStorage:
+		return nil
+	}
+	return fmt.Errorf("invalid profile %q, must be one of %s, %s or %s", profile, NodeProfileGeneral, NodeProfileGPU, NodeProfileStorage)
+}
+
+// NodeProfileKubeletFlags returns the kubelet flags of the node profile
+func NodeProfileKubeletFlags(kubeletVersion, profile string) ([]string, error) {
+	version, err := semver.NewVersion(kubeletVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubelet version %q: %v", kubeletVersion, err)
+	}
+
+	switch profile {
+	case NodeProfileGPU:
+		// Device plugins report the NUMA node of their devices, the topology manager places the pinned CPUs next to them
+		flags := []string{"--cpu-manager-policy=static"}
+		if !version.LessThan(topologyManagerMinKubeletVersion) {
+			if version.LessThan(topologyManagerDefaultOnKubeletVersion) {
+				flags = append(flags, "--feature-gates=TopologyManager=true")
+			}
+			flags = append(flags, "--topology-manager-policy=best-effort")
+		}
+		return flags, nil
+	case NodeProfileStorage:
+		// Percentages of large data disks would keep terabytes free, walking them for the volume stats is expensive
+		return []string{
+			"--eviction-hard=" + storageProfileEvictionHard,
+			"--volume-stats-agg-period=" + storageProfileVolumeStatsAggPeriod,
+		}, nil
+	}
+	return nil, nil
+}
+
+// NodeProfileDockerFlags returns the dockerd flags of the node profile
+func NodeProfileDockerFlags(profile string) []string {
+	switch profile {
+	case NodeProfileGPU:
+		// NCCL and RDMA need to lock the memory they share with the GPUs
+		return []string{"--default-ulimit=" + gpuProfileDockerDefaultUlimit}
+	case NodeProfileStorage:
+		return []string{"--default-ulimit=" + storageProfileDockerDefaultUlimit}
+	}
+	return nil
+}
+
+// NodeProfileContainerdLimits returns the systemd resource limits of containerd for the node profile. Unlike docker,
+// containerd has no default ulimits, its containers inherit the limits of the containerd process
+func NodeProfileContainerdLimits(profile string) []string {
+	switch profile {
+	case NodeProfileGPU:
+		return []string{"LimitMEMLOCK=infinity"}
+	case NodeProfileStorage:
+		return []string{"LimitNOFILE=1048576"}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateNodeProfile(t *testing.T) {
+	for _, profile := range []string{"", NodeProfileGeneral, NodeProfileGPU, NodeProfileStorage} {
+		if err := ValidateNodeProfile(profile); err != nil {
+			t.Errorf("expected profile %q to be valid, got: %v", profile, err)
+		}
+	}
+	if err := ValidateNodeProfile("GPU"); err == nil {
+		t.Error("expected an unknown profile to be invalid")
+	}
+}
+
+func TestNodeProfileKubeletFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletVersion string
+		profile        string
+		expected       []string
+	}{
+		{
+			name:           "general",
+			kubeletVersion: "1.17.0",
+			profile:        NodeProfileGeneral,
+		},
+		{
+			name:           "gpu without topology manager",
+			kubeletVersion: "1.15.3",
+			profile:        NodeProfileGPU,
+			expected:       []string{"--cpu-manager-policy=static"},
+		},
+		{
+			name:           "gpu with topology manager feature gate",
+			kubeletVersion: "1.17.0",
+			profile:        NodeProfileGPU,
+			expected:       []string{"--cpu-manager-policy=static", "--feature-gates=TopologyManager=true", "--topology-manager-policy=best-effort"},
+		},
+		{
+			name:           "gpu with topology manager enabled by default",
+			kubeletVersion: "1.18.2",
+			profile:        NodeProfileGPU,
+			expected:       []string{"--cpu-manager-policy=static", "--topology-manager-policy=best-effort"},
+		},
+		{
+			name:           "storage",
+			kubeletVersion: "1.17.0",
+			profile:        NodeProfileStorage,
+			expected: []string{
+				"--eviction-hard=memory.available<100Mi,nodefs.available<5Gi,nodefs.inodesFree<100000,imagefs.available<5Gi",
+				"--volume-stats-agg-period=5m",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags, err := NodeProfileKubeletFlags(test.kubeletVersion, test.profile)
+			if err != nil {
+				t.Fatalf("failed to get the kubelet flags: %v", err)
+			}
+			if !reflect.DeepEqual(flags, test.expected) {
+				t.Errorf("expected flags %v, got %v", test.expected, flags)
+			}
+		})
+	}
+}
+
+func TestNodeProfileDockerFlags(t *testing.T) {
+	if flags := NodeProfileDockerFlags(NodeProfileGeneral); len(flags) != 0 {
+		t.Errorf("expected no docker flags for the general profile, got %v", flags)
+	}
+	expected := []string{"--default-ulimit=memlock=-1:-1"}
+	if flags := NodeProfileDockerFlags(NodeProfileGPU); !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected docker flags %v, got %v", expected, flags)
+	}
+}
+
+func TestNodeProfileContainerdLimits(t *testing.T) {
+	if limits := NodeProfileContainerdLimits(NodeProfileGeneral); len(limits) != 0 {
+		t.Errorf("expected no containerd limits for the general profile, got %v", limits)
+	}
+	expected := []string{"LimitMEMLOCK=infinity"}
+	if limits := NodeProfileContainerdLimits(NodeProfileGPU); !reflect.DeepEqual(limits, expected) {
+		t.Errorf("expected containerd limits %v, got %v", expected, limits)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const nodeRegistrationScriptTpl = `#!/bin/bash
+set -xeuo pipefail
+
+NODE_HOSTNAME="$(hostname -f)"
+NODE_IPS="$(hostname -I | xargs)"
+NODE_INSTANCE_ID="$(cat /var/lib/cloud/data/instance-id)"
+
+case "$1" in
+register)
+{{ .RegisterCommand | indent 2 }}
+  ;;
+deregister)
+{{- if .DeregisterCommand }}
+{{ .DeregisterCommand | indent 2 }}
+{{- else }}
+  true
+{{- end }}
+  ;;
+esac`
+
+// nodeRegistrationFacts are the facts the registration commands can reference. They are only known
+// at boot, so they get rendered as shell variables which the registration script sets.
+type nodeRegistrationFacts struct {
+	// Hostname is the fully qualified hostname of the node
+	Hostname string
+	// IPs are the space separated IPs of the node
+	IPs string
+	// InstanceID is the ID of the instance at the cloud provider
+	InstanceID string
+}
+
+var nodeRegistrationShellFacts = nodeRegistrationFacts{
+	Hostname:   "${NODE_HOSTNAME}",
+	IPs:        "${NODE_IPS}",
+	InstanceID: "${NODE_INSTANCE_ID}",
+}
+
+// NodeRegistration registers the node with an external inventory like a CMDB when it boots and deregisters
+// it when it shuts down, which includes the deletion of the instance.
+type NodeRegistration struct {
+	// RegisterCommand is a shell command template, e.g. a curl call, which runs on every boot once the network
+	// is online. It can reference the facts {{ .Hostname }}, {{ .IPs }} and {{ .InstanceID }}, which expand to
+	// shell variables and therefore must not be single quoted.
+	RegisterCommand string `json:"registerCommand"`
+	// DeregisterCommand is a shell command template like the RegisterCommand which runs on shutdown
+	DeregisterCommand string `json:"deregisterCommand,omitempty"`
+}
+
+// Validate checks that the NodeRegistration commands are valid templates which only reference known facts
+func (r *NodeRegistration) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if strings.TrimSpace(r.RegisterCommand) == "" {
+		return errors.New("registerCommand must not be empty")
+	}
+	if _, err := renderNodeRegistrationCommand("registerCommand", r.RegisterCommand); err != nil {
+		return err
+	}
+	if _, err := renderNodeRegistrationCommand("deregisterCommand", r.DeregisterCommand); err != nil {
+		return err
+	}
+	return nil
+}
+
+func renderNodeRegistrationCommand(name, command string) (string, error) {
+	tmpl, err := template.New(name).Parse(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, nodeRegistrationShellFacts); err != nil {
+		return "", fmt.Errorf("failed to execute %s template: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+// NodeRegistrationScript returns the script which registers the node when called with register
+// and deregisters it when called with deregister.
+func NodeRegistrationScript(r *NodeRegistration) (string, error) {
+	registerCommand, err := renderNodeRegistrationCommand("registerCommand", r.RegisterCommand)
+	if err != nil {
+		return "", err
+	}
+	deregisterCommand, err := renderNodeRegistrationCommand("deregisterCommand", r.DeregisterCommand)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("node-registration-script").Funcs(TxtFuncMap()).Parse(nodeRegistrationScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse node-registration-script template: %v", err)
+	}
+	data := struct {
+		RegisterCommand   string
+		DeregisterCommand string
+	}{
+		RegisterCommand:   strings.TrimSpace(registerCommand),
+		DeregisterCommand: strings.TrimSpace(deregisterCommand),
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute node-registration-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// NodeRegistrationSystemdUnit returns the systemd unit which registers the node once the network is online.
+// Stopping it on shutdown deregisters the node before the network goes down.
+func NodeRegistrationSystemdUnit() string {
+	return `[Unit]
+Requires=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/bin/node-registration register
+ExecStop=/opt/bin/node-registration deregister
+
+[Install]
+WantedBy=multi-user.target
+`
+}
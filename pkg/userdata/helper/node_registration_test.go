@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeRegistrationValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		nodeRegistration *NodeRegistration
+		wantErr          bool
+	}{
+		{
+			name:             "not set",
+			nodeRegistration: nil,
+		},
+		{
+			name: "register and deregister",
+			nodeRegistration: &NodeRegistration{
+				RegisterCommand:   `curl -X POST "https://cmdb.example.com/nodes/{{ .InstanceID }}?hostname={{ .Hostname }}"`,
+				DeregisterCommand: `curl -X DELETE "https://cmdb.example.com/nodes/{{ .InstanceID }}"`,
+			},
+		},
+		{
+			name:             "empty register command",
+			nodeRegistration: &NodeRegistration{DeregisterCommand: "true"},
+			wantErr:          true,
+		},
+		{
+			name:             "unknown fact",
+			nodeRegistration: &NodeRegistration{RegisterCommand: "register {{ .Region }}"},
+			wantErr:          true,
+		},
+		{
+			name:             "invalid template",
+			nodeRegistration: &NodeRegistration{RegisterCommand: "register", DeregisterCommand: "deregister {{ .Hostname"},
+			wantErr:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.nodeRegistration.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNodeRegistrationScript(t *testing.T) {
+	script, err := NodeRegistrationScript(&NodeRegistration{
+		RegisterCommand:   `curl -X POST -d "{\"name\": \"{{ .Hostname }}\", \"ips\": \"{{ .IPs }}\"}" "https://cmdb.example.com/nodes/{{ .InstanceID }}"`,
+		DeregisterCommand: `curl -X DELETE "https://cmdb.example.com/nodes/{{ .InstanceID }}"`,
+	})
+	if err != nil {
+		t.Fatalf("failed to render node registration script: %v", err)
+	}
+
+	sections := strings.SplitN(script, "deregister)", 2)
+	if len(sections) != 2 {
+		t.Fatalf("expected a register and a deregister step, got:\n%s", script)
+	}
+	register, deregister := sections[0], sections[1]
+	expectedRegister := `curl -X POST -d "{\"name\": \"${NODE_HOSTNAME}\", \"ips\": \"${NODE_IPS}\"}" "https://cmdb.example.com/nodes/${NODE_INSTANCE_ID}"`
+	if !strings.Contains(register, expectedRegister) {
+		t.Errorf("expected the register step to contain %q, got:\n%s", expectedRegister, register)
+	}
+	expectedDeregister := `curl -X DELETE "https://cmdb.example.com/nodes/${NODE_INSTANCE_ID}"`
+	if !strings.Contains(deregister, expectedDeregister) {
+		t.Errorf("expected the deregister step to contain %q, got:\n%s", expectedDeregister, deregister)
+	}
+	for _, fact := range []string{`NODE_HOSTNAME="$(hostname -f)"`, `NODE_IPS=`, `NODE_INSTANCE_ID=`} {
+		if !strings.Contains(script, fact) {
+			t.Errorf("expected the script to set %s, got:\n%s", fact, script)
+		}
+	}
+}
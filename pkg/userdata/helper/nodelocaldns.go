@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"text/template"
+)
+
+// NodeLocalDNSInterface is the name of the dummy interface the node-local DNS cache binds to
+const NodeLocalDNSInterface = "nodelocaldns"
+
+const nodeLocalDNSScriptTpl = `#!/bin/bash
+set -xeuo pipefail
+
+if ! ip link show {{ .Interface }} &>/dev/null; then
+  ip link add {{ .Interface }} type dummy
+fi
+ip addr replace {{ .LocalIP }}/{{ .PrefixLength }} dev {{ .Interface }}
+ip link set {{ .Interface }} up
+
+# Queries to the cache must not be tracked, otherwise they are subject to conntrack races and table exhaustion
+{{- range $chain := list "PREROUTING" "OUTPUT" }}
+{{- range $proto := list "udp" "tcp" }}
+{{ $.IPTables }} -t raw -C {{ $chain }} -d {{ $.LocalIP }} -p {{ $proto }} --dport 53 -j NOTRACK 2>/dev/null || \
+  {{ $.IPTables }} -t raw -A {{ $chain }} -d {{ $.LocalIP }} -p {{ $proto }} --dport 53 -j NOTRACK
+{{- end }}
+{{- end }}`
+
+// NodeLocalDNS prepares the node for the node-local-dns cache DaemonSet.
+type NodeLocalDNS struct {
+	// LocalIP is the link-local address the cache listens on, usually 169.254.20.10.
+	// The kubelet hands it out as nameserver to pods instead of the cluster DNS service.
+	LocalIP string `json:"localIP"`
+}
+
+// Validate checks the NodeLocalDNS for invalid values
+func (n *NodeLocalDNS) Validate() error {
+	if n == nil {
+		return nil
+	}
+	ip := net.ParseIP(n.LocalIP)
+	if ip == nil {
+		return fmt.Errorf("local ip %q is not a valid ip address", n.LocalIP)
+	}
+	// Using any other range risks colliding with node, pod or service addresses
+	if !ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("local ip %q must be a link-local unicast address, e.g. 169.254.20.10", n.LocalIP)
+	}
+	return nil
+}
+
+// ClusterDNSIPs returns the nameservers the kubelet configures for pods. Those are the cluster
+// DNS service IPs unless the node-local DNS cache is enabled.
+func (n *NodeLocalDNS) ClusterDNSIPs(clusterDNSIPs []net.IP) []net.IP {
+	if n == nil {
+		return clusterDNSIPs
+	}
+	return []net.IP{net.ParseIP(n.LocalIP)}
+}
+
+// NodeLocalDNSScript returns a script which creates the dummy interface with the local IP
+// and excludes DNS traffic to it from connection tracking.
+func NodeLocalDNSScript(n *NodeLocalDNS) (string, error) {
+	tmpl, err := template.New("nodelocaldns-script").Funcs(TxtFuncMap()).Parse(nodeLocalDNSScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse nodelocaldns-script template: %v", err)
+	}
+
+	data := struct {
+		Interface    string
+		LocalIP      string
+		PrefixLength int
+		IPTables     string
+	}{
+		Interface:    NodeLocalDNSInterface,
+		LocalIP:      n.LocalIP,
+		PrefixLength: 32,
+		IPTables:     "iptables",
+	}
+	if net.ParseIP(n.LocalIP).To4() == nil {
+		data.PrefixLength = 128
+		data.IPTables = "ip6tables"
+	}
+
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute nodelocaldns-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// NodeLocalDNSSystemdUnit returns the systemd unit which sets up the node-local DNS interface
+// on every boot before the kubelet starts.
+func NodeLocalDNSSystemdUnit() string {
+	return `[Unit]
+Requires=network-online.target
+After=network-online.target
+Before=kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/bin/setup-nodelocaldns
+
+[Install]
+WantedBy=multi-user.target
+`
+}
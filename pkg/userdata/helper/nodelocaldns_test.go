@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNodeLocalDNSValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeLocalDNS *NodeLocalDNS
+		wantErr      bool
+	}{
+		{
+			name:         "not set",
+			nodeLocalDNS: nil,
+		},
+		{
+			name:         "ipv4 link-local",
+			nodeLocalDNS: &NodeLocalDNS{LocalIP: "169.254.20.10"},
+		},
+		{
+			name:         "ipv6 link-local",
+			nodeLocalDNS: &NodeLocalDNS{LocalIP: "fe80::a"},
+		},
+		{
+			name:         "empty",
+			nodeLocalDNS: &NodeLocalDNS{},
+			wantErr:      true,
+		},
+		{
+			name:         "invalid",
+			nodeLocalDNS: &NodeLocalDNS{LocalIP: "169.254.20"},
+			wantErr:      true,
+		},
+		{
+			name:         "private address",
+			nodeLocalDNS: &NodeLocalDNS{LocalIP: "10.10.10.10"},
+			wantErr:      true,
+		},
+		{
+			name:         "loopback",
+			nodeLocalDNS: &NodeLocalDNS{LocalIP: "127.0.0.53"},
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.nodeLocalDNS.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNodeLocalDNSClusterDNSIPs(t *testing.T) {
+	clusterDNSIPs := []net.IP{net.ParseIP("10.10.10.10")}
+
+	var disabled *NodeLocalDNS
+	if ips := disabled.ClusterDNSIPs(clusterDNSIPs); len(ips) != 1 || !ips[0].Equal(clusterDNSIPs[0]) {
+		t.Errorf("expected the cluster DNS IPs to be kept, got %v", ips)
+	}
+
+	enabled := &NodeLocalDNS{LocalIP: "169.254.20.10"}
+	if ips := enabled.ClusterDNSIPs(clusterDNSIPs); len(ips) != 1 || !ips[0].Equal(net.ParseIP("169.254.20.10")) {
+		t.Errorf("expected the local IP to replace the cluster DNS IPs, got %v", ips)
+	}
+}
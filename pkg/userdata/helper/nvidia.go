@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// nvidiaDriverVersionRegex matches driver versions like 418.87.01 or 430.50
+	nvidiaDriverVersionRegex = regexp.MustCompile(`^[0-9]{3}\.[0-9]{2,3}(\.[0-9]{2})?$`)
+	// nvidiaToolkitVersionRegex matches container toolkit versions like 3.1.4
+	nvidiaToolkitVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+// NvidiaGPU pre-installs the NVIDIA driver and container toolkit, so GPU nodes do not depend on a driver
+// installer DaemonSet. The nvidia runtime becomes the default runtime of the container runtime.
+type NvidiaGPU struct {
+	// DriverVersion is the version of the NVIDIA driver, e.g. 418.87.01
+	DriverVersion string `json:"driverVersion"`
+	// ContainerToolkitVersion is the version of the NVIDIA container runtime, e.g. 3.1.4. Defaults to the latest version
+	ContainerToolkitVersion string `json:"containerToolkitVersion,omitempty"`
+}
+
+// Validate checks the NvidiaGPU for invalid values
+func (g *NvidiaGPU) Validate() error {
+	if g == nil {
+		return nil
+	}
+	if g.DriverVersion == "" {
+		return errors.New("driver version must be set")
+	}
+	if !nvidiaDriverVersionRegex.MatchString(g.DriverVersion) {
+		return fmt.Errorf("invalid driver version %q, must look like 418.87.01", g.DriverVersion)
+	}
+	if g.ContainerToolkitVersion != "" && !nvidiaToolkitVersionRegex.MatchString(g.ContainerToolkitVersion) {
+		return fmt.Errorf("invalid container toolkit version %q, must look like 3.1.4", g.ContainerToolkitVersion)
+	}
+	return nil
+}
+
+// DriverPackage returns the pinned apt package of the driver
+func (g *NvidiaGPU) DriverPackage() string {
+	return fmt.Sprintf("cuda-drivers=%s-1", g.DriverVersion)
+}
+
+// ToolkitPackage returns the apt package of the container runtime, pinned if a version is configured
+func (g *NvidiaGPU) ToolkitPackage() string {
+	if g.ContainerToolkitVersion == "" {
+		return "nvidia-container-runtime"
+	}
+	return fmt.Sprintf("nvidia-container-runtime=%s-1", g.ContainerToolkitVersion)
+}
+
+// NvidiaDockerDaemonConfig returns the docker daemon config which makes nvidia the default runtime
+func NvidiaDockerDaemonConfig() string {
+	return `{
+  "default-runtime": "nvidia",
+  "runtimes": {
+    "nvidia": {
+      "path": "nvidia-container-runtime",
+      "runtimeArgs": []
+    }
+  }
+}`
+}
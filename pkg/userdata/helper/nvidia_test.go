@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import "testing"
+
+func TestNvidiaGPUValidate(t *testing.T) {
+	tests := []struct {
+		name            string
+		gpu             *NvidiaGPU
+		expectedDriver  string
+		expectedToolkit string
+		wantErr         bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:            "latest toolkit",
+			gpu:             &NvidiaGPU{DriverVersion: "430.50"},
+			expectedDriver:  "cuda-drivers=430.50-1",
+			expectedToolkit: "nvidia-container-runtime",
+		},
+		{
+			name:            "pinned toolkit",
+			gpu:             &NvidiaGPU{DriverVersion: "418.87.01", ContainerToolkitVersion: "3.1.4"},
+			expectedDriver:  "cuda-drivers=418.87.01-1",
+			expectedToolkit: "nvidia-container-runtime=3.1.4-1",
+		},
+		{
+			name:    "missing driver version",
+			gpu:     &NvidiaGPU{},
+			wantErr: true,
+		},
+		{
+			name:    "driver branch only",
+			gpu:     &NvidiaGPU{DriverVersion: "418"},
+			wantErr: true,
+		},
+		{
+			name:    "driver version with shell characters",
+			gpu:     &NvidiaGPU{DriverVersion: "418.87.01; reboot"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid toolkit version",
+			gpu:     &NvidiaGPU{DriverVersion: "418.87.01", ContainerToolkitVersion: "latest"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.gpu.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+			if err != nil || test.gpu == nil {
+				return
+			}
+			if pkg := test.gpu.DriverPackage(); pkg != test.expectedDriver {
+				t.Errorf("expected driver package %q, got %q", test.expectedDriver, pkg)
+			}
+			if pkg := test.gpu.ToolkitPackage(); pkg != test.expectedToolkit {
+				t.Errorf("expected toolkit package %q, got %q", test.expectedToolkit, pkg)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	defaultPackageUpgradeAttempts = 3
+	maxPackageUpgradeAttempts     = 10
+)
+
+const packageUpgradeScriptTpl = `for attempt in $(seq 1 {{ .Attempts }}); do
+  if {{ .Command }}; then
+    break
+  elif [[ ${attempt} -eq {{ .Attempts }} ]]; then
+    echo "package upgrade failed after {{ .Attempts }} attempts" >&2
+    exit 1
+  fi
+  sleep $((attempt * 10))
+done`
+
+// PackageUpgrade upgrades the installed packages during bootstrap, before the kubelet starts.
+// As this may change the kernel, the node reboots if the upgrade requires it.
+type PackageUpgrade struct {
+	// SecurityOnly restricts the upgrade to security updates
+	SecurityOnly bool `json:"securityOnly,omitempty"`
+	// Retries is the number of attempts for transient failures like unreachable mirrors, defaults to 3
+	Retries int `json:"retries,omitempty"`
+}
+
+// Validate checks the PackageUpgrade for invalid values
+func (p *PackageUpgrade) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Retries < 0 || p.Retries > maxPackageUpgradeAttempts {
+		return fmt.Errorf("package upgrade retries must be between 0 and %d, got %d", maxPackageUpgradeAttempts, p.Retries)
+	}
+	return nil
+}
+
+// Attempts returns how often the upgrade gets tried before the bootstrap fails
+func (p *PackageUpgrade) Attempts() int {
+	if p.Retries == 0 {
+		return defaultPackageUpgradeAttempts
+	}
+	return p.Retries
+}
+
+// PackageUpgradeScript returns a script which runs the given upgrade command until it succeeds
+// or the attempts are exhausted
+func PackageUpgradeScript(command string, attempts int) (string, error) {
+	tmpl, err := template.New("package-upgrade-script").Parse(packageUpgradeScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse package-upgrade-script template: %v", err)
+	}
+
+	data := struct {
+		Command  string
+		Attempts int
+	}{
+		Command:  command,
+		Attempts: attempts,
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute package-upgrade-script template: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackageUpgradeValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		upgrade          *PackageUpgrade
+		expectedAttempts int
+		wantErr          bool
+	}{
+		{
+			name:             "defaults",
+			upgrade:          &PackageUpgrade{},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "custom retries",
+			upgrade:          &PackageUpgrade{SecurityOnly: true, Retries: 5},
+			expectedAttempts: 5,
+		},
+		{
+			name:    "negative retries",
+			upgrade: &PackageUpgrade{Retries: -1},
+			wantErr: true,
+		},
+		{
+			name:    "too many retries",
+			upgrade: &PackageUpgrade{Retries: 11},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.upgrade.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+			if err == nil && test.upgrade.Attempts() != test.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", test.expectedAttempts, test.upgrade.Attempts())
+			}
+		})
+	}
+}
+
+func TestPackageUpgradeScript(t *testing.T) {
+	script, err := PackageUpgradeScript("yum upgrade -y", 4)
+	if err != nil {
+		t.Fatalf("failed to render script: %v", err)
+	}
+	for _, expected := range []string{"seq 1 4", "if yum upgrade -y; then", "-eq 4 ]]", "exit 1"} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected script to contain %q, got:\n%s", expected, script)
+		}
+	}
+}
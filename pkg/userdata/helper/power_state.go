@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+)
+
+// PowerState maps to the cloud-init power_state module, which reboots or powers off
+// the node once cloud-init has finished.
+// https://cloudinit.readthedocs.io/en/latest/topics/modules.html#power-state-change
+type PowerState struct {
+	// Mode is one of reboot, poweroff or halt.
+	Mode string `json:"mode"`
+	// Timeout is the time in seconds to wait for cloud-init to finish before changing the power state.
+	Timeout int `json:"timeout,omitempty"`
+	// Condition is a shell command, the power state only gets changed if it exits with 0.
+	Condition string `json:"condition,omitempty"`
+}
+
+var powerStateModes = []string{"reboot", "poweroff", "halt"}
+
+// Validate checks the PowerState for invalid values
+func (p *PowerState) Validate() error {
+	if p == nil {
+		return nil
+	}
+	validMode := false
+	for _, mode := range powerStateModes {
+		if p.Mode == mode {
+			validMode = true
+			break
+		}
+	}
+	if !validMode {
+		return fmt.Errorf("invalid power state mode %q, must be one of %v", p.Mode, powerStateModes)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("power state timeout must not be negative, got %d", p.Timeout)
+	}
+	return nil
+}
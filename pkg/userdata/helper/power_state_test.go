@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestPowerStateValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		powerState *PowerState
+		wantErr    bool
+	}{
+		{
+			name:       "not set",
+			powerState: nil,
+		},
+		{
+			name:       "reboot",
+			powerState: &PowerState{Mode: "reboot", Timeout: 30},
+		},
+		{
+			name:       "poweroff with condition",
+			powerState: &PowerState{Mode: "poweroff", Condition: "true"},
+		},
+		{
+			name:       "invalid mode",
+			powerState: &PowerState{Mode: "shutdown"},
+			wantErr:    true,
+		},
+		{
+			name:       "empty mode",
+			powerState: &PowerState{},
+			wantErr:    true,
+		},
+		{
+			name:       "negative timeout",
+			powerState: &PowerState{Mode: "halt", Timeout: -1},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.powerState.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
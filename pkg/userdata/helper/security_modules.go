@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// SecurityModeEnforcing denies and logs policy violations
+	SecurityModeEnforcing = "enforcing"
+	// SecurityModePermissive only logs policy violations. For AppArmor this is the complain mode
+	SecurityModePermissive = "permissive"
+
+	appArmorProfileDir = "/etc/apparmor.d"
+)
+
+var (
+	seLinuxBooleanRegexp  = regexp.MustCompile(`^[a-z0-9_]+$`)
+	appArmorProfileRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+func validateSecurityMode(mode string) error {
+	switch mode {
+	case "", SecurityModeEnforcing, SecurityModePermissive:
+		return nil
+	}
+	return fmt.Errorf("invalid mode %q, must be %s or %s", mode, SecurityModeEnforcing, SecurityModePermissive)
+}
+
+// SELinux configures SELinux on distributions which ship it, e.g. CentOS.
+type SELinux struct {
+	// Mode is either enforcing or permissive. Defaults to permissive.
+	Mode string `json:"mode,omitempty"`
+	// Booleans get set persistently, e.g. container_manage_cgroup: true
+	Booleans map[string]bool `json:"booleans,omitempty"`
+}
+
+// Validate checks the SELinux config for invalid values
+func (s *SELinux) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if err := validateSecurityMode(s.Mode); err != nil {
+		return err
+	}
+	for name := range s.Booleans {
+		if !seLinuxBooleanRegexp.MatchString(name) {
+			return fmt.Errorf("invalid SELinux boolean %q", name)
+		}
+	}
+	return nil
+}
+
+// SELinuxMode returns the configured SELinux mode or the default permissive mode
+func SELinuxMode(s *SELinux) string {
+	if s == nil || s.Mode == "" {
+		return SecurityModePermissive
+	}
+	return s.Mode
+}
+
+// SELinuxBooleansScript returns a script which persistently sets the configured SELinux booleans.
+// setsebool fails if SELinux is disabled, the SELinux config only takes effect after a reboot then.
+func SELinuxBooleansScript(s *SELinux) string {
+	var names []string
+	for name := range s.Booleans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var values []string
+	for _, name := range names {
+		value := "off"
+		if s.Booleans[name] {
+			value = "on"
+		}
+		values = append(values, fmt.Sprintf("%s=%s", name, value))
+	}
+	return fmt.Sprintf(`if selinuxenabled; then
+  setsebool -P %s
+fi`, strings.Join(values, " "))
+}
+
+// AppArmor loads additional AppArmor profiles on distributions which ship AppArmor, e.g. Ubuntu.
+type AppArmor struct {
+	// Mode is either enforcing or permissive, which loads the profiles in complain mode. Defaults to enforcing.
+	Mode string `json:"mode,omitempty"`
+	// Profiles get written to /etc/apparmor.d and loaded before the kubelet starts
+	Profiles []AppArmorProfile `json:"profiles"`
+}
+
+// AppArmorProfile is an AppArmor profile. Pods use it via the profile name declared in the content.
+type AppArmorProfile struct {
+	// Name is the file name of the profile in /etc/apparmor.d
+	Name string `json:"name"`
+	// Content is the profile in the AppArmor policy language
+	Content string `json:"content"`
+}
+
+// Validate checks the AppArmor config for invalid values
+func (a *AppArmor) Validate() error {
+	if a == nil {
+		return nil
+	}
+	if err := validateSecurityMode(a.Mode); err != nil {
+		return err
+	}
+	if len(a.Profiles) == 0 {
+		return errors.New("AppArmor profiles must not be empty")
+	}
+	names := map[string]bool{}
+	for _, profile := range a.Profiles {
+		if !appArmorProfileRegexp.MatchString(profile.Name) {
+			return fmt.Errorf("invalid AppArmor profile name %q", profile.Name)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("duplicate AppArmor profile %q", profile.Name)
+		}
+		names[profile.Name] = true
+		if strings.TrimSpace(profile.Content) == "" {
+			return fmt.Errorf("AppArmor profile %q must not be empty", profile.Name)
+		}
+	}
+	return nil
+}
+
+// AppArmorProfilePath returns the path the given profile gets written to
+func AppArmorProfilePath(profile AppArmorProfile) string {
+	return appArmorProfileDir + "/" + profile.Name
+}
+
+// AppArmorSetupScript returns a script which loads the configured profiles. In permissive mode the
+// profiles get linked into force-complain, so they are also loaded in complain mode after a reboot.
+func AppArmorSetupScript(a *AppArmor) string {
+	lines := []string{"systemctl enable --now apparmor"}
+	if a.Mode == SecurityModePermissive {
+		lines = append(lines, fmt.Sprintf("mkdir -p %s/force-complain", appArmorProfileDir))
+	}
+	for _, profile := range a.Profiles {
+		path := AppArmorProfilePath(profile)
+		if a.Mode == SecurityModePermissive {
+			lines = append(lines,
+				fmt.Sprintf("ln -sf %s %s/force-complain/%s", path, appArmorProfileDir, profile.Name),
+				fmt.Sprintf("apparmor_parser -r -C %s", path))
+		} else {
+			lines = append(lines,
+				fmt.Sprintf("rm -f %s/force-complain/%s", appArmorProfileDir, profile.Name),
+				fmt.Sprintf("apparmor_parser -r %s", path))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSELinuxValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		selinux *SELinux
+		wantErr bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:    "enforcing with booleans",
+			selinux: &SELinux{Mode: SecurityModeEnforcing, Booleans: map[string]bool{"container_manage_cgroup": true}},
+		},
+		{
+			name:    "invalid mode",
+			selinux: &SELinux{Mode: "disabled"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid boolean",
+			selinux: &SELinux{Booleans: map[string]bool{"virt_use_nfs; reboot": true}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.selinux.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSELinuxMode(t *testing.T) {
+	if mode := SELinuxMode(nil); mode != SecurityModePermissive {
+		t.Errorf("expected default mode %q, got %q", SecurityModePermissive, mode)
+	}
+	if mode := SELinuxMode(&SELinux{Mode: SecurityModeEnforcing}); mode != SecurityModeEnforcing {
+		t.Errorf("expected mode %q, got %q", SecurityModeEnforcing, mode)
+	}
+}
+
+func TestSELinuxBooleansScript(t *testing.T) {
+	script := SELinuxBooleansScript(&SELinux{Booleans: map[string]bool{"virt_use_nfs": false, "container_manage_cgroup": true}})
+	expected := `if selinuxenabled; then
+  setsebool -P container_manage_cgroup=on virt_use_nfs=off
+fi`
+	if script != expected {
+		t.Errorf("expected script:\n%s\ngot:\n%s", expected, script)
+	}
+}
+
+func TestAppArmorValidate(t *testing.T) {
+	profile := AppArmorProfile{Name: "k8s-deny-write", Content: "profile k8s-deny-write {}"}
+	tests := []struct {
+		name     string
+		appArmor *AppArmor
+		wantErr  bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:     "permissive",
+			appArmor: &AppArmor{Mode: SecurityModePermissive, Profiles: []AppArmorProfile{profile}},
+		},
+		{
+			name:     "invalid mode",
+			appArmor: &AppArmor{Mode: "complain", Profiles: []AppArmorProfile{profile}},
+			wantErr:  true,
+		},
+		{
+			name:     "no profiles",
+			appArmor: &AppArmor{},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid profile name",
+			appArmor: &AppArmor{Profiles: []AppArmorProfile{{Name: "../passwd", Content: "profile x {}"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate profile",
+			appArmor: &AppArmor{Profiles: []AppArmorProfile{profile, profile}},
+			wantErr:  true,
+		},
+		{
+			name:     "empty profile",
+			appArmor: &AppArmor{Profiles: []AppArmorProfile{{Name: "empty"}}},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.appArmor.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAppArmorSetupScript(t *testing.T) {
+	profiles := []AppArmorProfile{{Name: "k8s-deny-write", Content: "profile k8s-deny-write {}"}}
+	tests := []struct {
+		name     string
+		mode     string
+		expected []string
+	}{
+		{
+			name: "enforcing by default",
+			expected: []string{
+				"systemctl enable --now apparmor",
+				"rm -f /etc/apparmor.d/force-complain/k8s-deny-write",
+				"apparmor_parser -r /etc/apparmor.d/k8s-deny-write",
+			},
+		},
+		{
+			name: "permissive",
+			mode: SecurityModePermissive,
+			expected: []string{
+				"systemctl enable --now apparmor",
+				"mkdir -p /etc/apparmor.d/force-complain",
+				"ln -sf /etc/apparmor.d/k8s-deny-write /etc/apparmor.d/force-complain/k8s-deny-write",
+				"apparmor_parser -r -C /etc/apparmor.d/k8s-deny-write",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := AppArmorSetupScript(&AppArmor{Mode: test.mode, Profiles: profiles})
+			if expected := strings.Join(test.expected, "\n"); script != expected {
+				t.Errorf("expected script:\n%s\ngot:\n%s", expected, script)
+			}
+		})
+	}
+}
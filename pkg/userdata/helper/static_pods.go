@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// KubeletPodManifestPath is the directory the kubelet runs static pods from, see its --pod-manifest-path flag
+const KubeletPodManifestPath = "/etc/kubernetes/manifests"
+
+// StaticPod is a pod manifest which gets written to the pod manifest path of the kubelet,
+// so the kubelet runs it on the node without the need for a scheduler or an API server.
+type StaticPod struct {
+	// Name of the manifest file, it gets written to /etc/kubernetes/manifests/<name>.yaml
+	Name string `json:"name"`
+	// Manifest is the v1 Pod as yaml or json
+	Manifest string `json:"manifest"`
+}
+
+// ValidateStaticPods checks that the names of the static pods are unique and their manifests are valid pods
+func ValidateStaticPods(pods []StaticPod) error {
+	names := sets.NewString()
+	for _, pod := range pods {
+		if errs := validation.IsDNS1123Subdomain(pod.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid static pod name %q: %s", pod.Name, strings.Join(errs, ", "))
+		}
+		if names.Has(pod.Name) {
+			return fmt.Errorf("static pod name %q is not unique", pod.Name)
+		}
+		names.Insert(pod.Name)
+
+		if err := validateStaticPodManifest(pod.Manifest); err != nil {
+			return fmt.Errorf("invalid manifest of static pod %s: %v", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateStaticPodManifest(manifest string) error {
+	if strings.TrimSpace(manifest) == "" {
+		return errors.New("manifest is empty")
+	}
+	pod := &corev1.Pod{}
+	if err := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096).Decode(pod); err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if pod.APIVersion != "v1" || pod.Kind != "Pod" {
+		return fmt.Errorf("manifest must be a v1 Pod, got apiVersion %q and kind %q", pod.APIVersion, pod.Kind)
+	}
+	if pod.Name == "" {
+		return errors.New("metadata.name is missing")
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return errors.New("spec.containers must not be empty")
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "" || container.Image == "" {
+			return errors.New("all containers need a name and an image")
+		}
+	}
+	return nil
+}
+
+// StaticPodManifestPath returns the path the manifest of the static pod gets written to
+func StaticPodManifestPath(pod StaticPod) string {
+	return path.Join(KubeletPodManifestPath, pod.Name+".yaml")
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const validStaticPodManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: edge-proxy
+  namespace: kube-system
+spec:
+  hostNetwork: true
+  containers:
+  - name: proxy
+    image: nginx:1.15
+`
+
+func TestValidateStaticPods(t *testing.T) {
+	tests := []struct {
+		name    string
+		pods    []StaticPod
+		wantErr bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name: "yaml manifest",
+			pods: []StaticPod{{Name: "edge-proxy", Manifest: validStaticPodManifest}},
+		},
+		{
+			name: "json manifest",
+			pods: []StaticPod{{Name: "edge-proxy", Manifest: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"edge-proxy"},"spec":{"containers":[{"name":"proxy","image":"nginx:1.15"}]}}`}},
+		},
+		{
+			name:    "invalid name",
+			pods:    []StaticPod{{Name: "../kubelet", Manifest: validStaticPodManifest}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			pods:    []StaticPod{{Name: "edge-proxy", Manifest: validStaticPodManifest}, {Name: "edge-proxy", Manifest: validStaticPodManifest}},
+			wantErr: true,
+		},
+		{
+			name:    "empty manifest",
+			pods:    []StaticPod{{Name: "edge-proxy"}},
+			wantErr: true,
+		},
+		{
+			name:    "unparsable manifest",
+			pods:    []StaticPod{{Name: "edge-proxy", Manifest: "apiVersion: v1\nkind: [Pod"}},
+			wantErr: true,
+		},
+		{
+			name:    "not a pod",
+			pods:    []StaticPod{{Name: "edge-proxy", Manifest: strings.Replace(validStaticPodManifest, "kind: Pod", "kind: Deployment", 1)}},
+			wantErr: true,
+		},
+		{
+			name:    "no containers",
+			pods:    []StaticPod{{Name: "edge-proxy", Manifest: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: edge-proxy\n"}},
+			wantErr: true,
+		},
+		{
+			name:    "container without image",
+			pods:    []StaticPod{{Name: "edge-proxy", Manifest: strings.Replace(validStaticPodManifest, "    image: nginx:1.15\n", "", 1)}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateStaticPods(test.pods)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStaticPodManifestPathIsConfigured(t *testing.T) {
+	path := StaticPodManifestPath(StaticPod{Name: "edge-proxy"})
+	if path != "/etc/kubernetes/manifests/edge-proxy.yaml" {
+		t.Errorf("unexpected manifest path %q", path)
+	}
+
+	// The kubelet only runs the static pods if it watches the directory they get written to
+	flags, err := KubeletFlags("1.12.0", "", "", []net.IP{net.ParseIP("10.10.10.10")}, "cluster.local", false)
+	if err != nil {
+		t.Fatalf("failed to render kubelet flags: %v", err)
+	}
+	if !strings.Contains(flags, "--pod-manifest-path="+KubeletPodManifestPath+" ") {
+		t.Errorf("expected the kubelet flags to contain --pod-manifest-path=%s, got:\n%s", KubeletPodManifestPath, flags)
+	}
+}
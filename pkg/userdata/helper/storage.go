@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// iqnRegex matches iSCSI qualified names like iqn.2019-06.com.example:node1
+	iqnRegex = regexp.MustCompile(`^iqn\.[0-9]{4}-(0[1-9]|1[0-2])\.[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*(:[^\s]+)?$`)
+	// euiRegex matches IEEE EUI-64 based names like eui.02004567A425678D
+	euiRegex = regexp.MustCompile(`^eui\.[0-9A-Fa-f]{16}$`)
+)
+
+// maxISCSINameLength is the maximum length of an iSCSI name as defined in RFC 3720
+const maxISCSINameLength = 223
+
+// Multipath installs and configures multipathd for SAN attached storage.
+type Multipath struct {
+	// Config is the content of /etc/multipath.conf
+	Config string `json:"config"`
+}
+
+// Validate checks the Multipath for invalid values
+func (m *Multipath) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if strings.TrimSpace(m.Config) == "" {
+		return errors.New("multipath config must not be empty when multipath is enabled")
+	}
+	return nil
+}
+
+// ValidateISCSIInitiatorName checks that the given name is a valid iqn or eui iSCSI name.
+// An empty name is valid and keeps the initiator name generated by the distribution.
+func ValidateISCSIInitiatorName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if len(name) > maxISCSINameLength {
+		return fmt.Errorf("iSCSI initiator name %q must not be longer than %d characters", name, maxISCSINameLength)
+	}
+	if !iqnRegex.MatchString(name) && !euiRegex.MatchString(name) {
+		return fmt.Errorf("invalid iSCSI initiator name %q, must be in iqn.YYYY-MM.reversed.domain[:identifier] or eui.<16 hex digits> format", name)
+	}
+	return nil
+}
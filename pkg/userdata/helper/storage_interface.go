@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"text/template"
+)
+
+// interfaceNameRegexp matches the names the kernel accepts for network interfaces
+var interfaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+const (
+	minStorageInterfaceMTU = 576
+	maxStorageInterfaceMTU = 9216
+)
+
+const storageInterfaceScriptTpl = `#!/bin/bash
+set -xeuo pipefail
+
+# Additional interfaces may show up after the network is online
+for i in $(seq 1 60); do
+  if [[ -e /sys/class/net/{{ .Name }} ]]; then
+    break
+  fi
+  sleep 1
+done
+
+{{- if .MTU }}
+ip link set dev {{ .Name }} mtu {{ .MTU }}
+{{- end }}
+ip link set dev {{ .Name }} up
+{{- range .Routes }}
+ip route replace {{ .Destination }}{{ with .Gateway }} via {{ . }}{{ end }} dev {{ $.Name }}
+{{- end }}`
+
+// StorageInterface configures the network interface which carries the storage traffic of the node, e.g. the
+// additional interface a cloud provider attached for it. Its address must be configured by the image, usually via DHCP
+type StorageInterface struct {
+	// Name is the name of the interface on the node, e.g. ens6. It depends on the provider and the image
+	Name string `json:"name"`
+	// MTU of the interface, e.g. 9000 for jumbo frames. The MTU the network hands out is kept if not set
+	MTU int `json:"mtu,omitempty"`
+	// Routes send the traffic to the storage backends through the interface
+	Routes []StorageRoute `json:"routes,omitempty"`
+}
+
+// StorageRoute is a route via the storage interface
+type StorageRoute struct {
+	// Destination is a CIDR, e.g. 10.20.0.0/16
+	Destination string `json:"destination"`
+	// Gateway is only required if the destination is not in the network of the interface
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Validate checks the StorageInterface for invalid values
+func (s *StorageInterface) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if !interfaceNameRegexp.MatchString(s.Name) {
+		return fmt.Errorf("interface name %q is invalid", s.Name)
+	}
+	if s.MTU != 0 && (s.MTU < minStorageInterfaceMTU || s.MTU > maxStorageInterfaceMTU) {
+		return fmt.Errorf("mtu must be between %d and %d, got %d", minStorageInterfaceMTU, maxStorageInterfaceMTU, s.MTU)
+	}
+	for _, route := range s.Routes {
+		_, destination, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return fmt.Errorf("route destination %q is not a valid CIDR", route.Destination)
+		}
+		if route.Gateway == "" {
+			continue
+		}
+		gateway := net.ParseIP(route.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("route gateway %q is not a valid ip address", route.Gateway)
+		}
+		if (gateway.To4() == nil) != (destination.IP.To4() == nil) {
+			return fmt.Errorf("route gateway %q and destination %q must be of the same ip family", route.Gateway, route.Destination)
+		}
+	}
+	return nil
+}
+
+// StorageInterfaceScript returns a script which waits for the storage interface and sets its MTU and routes
+func StorageInterfaceScript(s *StorageInterface) (string, error) {
+	tmpl, err := template.New("storage-interface-script").Funcs(TxtFuncMap()).Parse(storageInterfaceScriptTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse storage-interface-script template: %v", err)
+	}
+
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, s); err != nil {
+		return "", fmt.Errorf("failed to execute storage-interface-script template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// StorageInterfaceSystemdUnit returns the systemd unit which configures the storage interface on every boot
+// before the kubelet starts, so pods never use storage without the routes.
+func StorageInterfaceSystemdUnit() string {
+	return `[Unit]
+Requires=network-online.target
+After=network-online.target
+Before=kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/bin/setup-storage-interface
+
+[Install]
+WantedBy=multi-user.target
+`
+}
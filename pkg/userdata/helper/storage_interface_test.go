@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStorageInterfaceValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		storageInterface *StorageInterface
+		wantErr          bool
+	}{
+		{
+			name:             "not set",
+			storageInterface: nil,
+		},
+		{
+			name: "mtu and routes",
+			storageInterface: &StorageInterface{
+				Name: "ens6",
+				MTU:  9000,
+				Routes: []StorageRoute{
+					{Destination: "10.20.0.0/16", Gateway: "10.10.0.1"},
+					{Destination: "fd00:20::/64"},
+				},
+			},
+		},
+		{
+			name:             "missing name",
+			storageInterface: &StorageInterface{},
+			wantErr:          true,
+		},
+		{
+			name:             "name with spaces",
+			storageInterface: &StorageInterface{Name: "ens6; reboot"},
+			wantErr:          true,
+		},
+		{
+			name:             "name too long",
+			storageInterface: &StorageInterface{Name: "enp0s31f6storage0"},
+			wantErr:          true,
+		},
+		{
+			name:             "mtu too small",
+			storageInterface: &StorageInterface{Name: "ens6", MTU: 500},
+			wantErr:          true,
+		},
+		{
+			name:             "mtu too large",
+			storageInterface: &StorageInterface{Name: "ens6", MTU: 65000},
+			wantErr:          true,
+		},
+		{
+			name:             "invalid destination",
+			storageInterface: &StorageInterface{Name: "ens6", Routes: []StorageRoute{{Destination: "10.20.0.0"}}},
+			wantErr:          true,
+		},
+		{
+			name:             "invalid gateway",
+			storageInterface: &StorageInterface{Name: "ens6", Routes: []StorageRoute{{Destination: "10.20.0.0/16", Gateway: "10.10.0"}}},
+			wantErr:          true,
+		},
+		{
+			name:             "gateway of other ip family",
+			storageInterface: &StorageInterface{Name: "ens6", Routes: []StorageRoute{{Destination: "10.20.0.0/16", Gateway: "fd00::1"}}},
+			wantErr:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.storageInterface.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStorageInterfaceScript(t *testing.T) {
+	script, err := StorageInterfaceScript(&StorageInterface{
+		Name: "ens6",
+		MTU:  9000,
+		Routes: []StorageRoute{
+			{Destination: "10.20.0.0/16", Gateway: "10.10.0.1"},
+			{Destination: "10.30.0.0/16"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+
+	for _, expected := range []string{
+		"if [[ -e /sys/class/net/ens6 ]]; then",
+		"ip link set dev ens6 mtu 9000\nip link set dev ens6 up\n",
+		"ip route replace 10.20.0.0/16 via 10.10.0.1 dev ens6\nip route replace 10.30.0.0/16 dev ens6",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+		}
+	}
+
+	script, err = StorageInterfaceScript(&StorageInterface{Name: "ens6"})
+	if err != nil {
+		t.Fatalf("failed to render the script: %v", err)
+	}
+	if strings.Contains(script, "mtu") || strings.Contains(script, "ip route") {
+		t.Errorf("expected the script to keep the mtu and routes, got:\n%s", script)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestValidateISCSIInitiatorName(t *testing.T) {
+	tests := []struct {
+		name    string
+		iqn     string
+		wantErr bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name: "iqn with identifier",
+			iqn:  "iqn.2019-06.com.example:storage-node1",
+		},
+		{
+			name: "iqn without identifier",
+			iqn:  "iqn.1994-05.com.redhat",
+		},
+		{
+			name: "eui",
+			iqn:  "eui.02004567A425678D",
+		},
+		{
+			name:    "missing date",
+			iqn:     "iqn.com.example:node1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid month",
+			iqn:     "iqn.2019-13.com.example:node1",
+			wantErr: true,
+		},
+		{
+			name:    "upper case domain",
+			iqn:     "iqn.2019-06.com.Example:node1",
+			wantErr: true,
+		},
+		{
+			name:    "whitespace in identifier",
+			iqn:     "iqn.2019-06.com.example:node 1",
+			wantErr: true,
+		},
+		{
+			name:    "short eui",
+			iqn:     "eui.02004567",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateISCSIInitiatorName(test.iqn)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -36,6 +36,49 @@ func TxtFuncMap() template.FuncMap {
 	funcMap["journalDConfig"] = JournalDConfig
 	funcMap["kubeletHealthCheckSystemdUnit"] = KubeletHealthCheckSystemdUnit
 	funcMap["containerRuntimeHealthCheckSystemdUnit"] = ContainerRuntimeHealthCheckSystemdUnit
+	funcMap["prewarmImagesScript"] = PrewarmImagesScript
+	funcMap["prewarmImagesSystemdUnit"] = PrewarmImagesSystemdUnit
+	funcMap["auditRules"] = AuditRules
+	funcMap["auditLogFile"] = AuditLogFile
+	funcMap["nodeLocalDNSScript"] = NodeLocalDNSScript
+	funcMap["nodeLocalDNSSystemdUnit"] = NodeLocalDNSSystemdUnit
+	funcMap["storageInterfaceScript"] = StorageInterfaceScript
+	funcMap["storageInterfaceSystemdUnit"] = StorageInterfaceSystemdUnit
+	funcMap["packageUpgradeScript"] = PackageUpgradeScript
+	funcMap["selinuxMode"] = SELinuxMode
+	funcMap["selinuxBooleansScript"] = SELinuxBooleansScript
+	funcMap["appArmorProfilePath"] = AppArmorProfilePath
+	funcMap["appArmorSetupScript"] = AppArmorSetupScript
+	funcMap["nvidiaDockerDaemonConfig"] = NvidiaDockerDaemonConfig
+	funcMap["chronyConfig"] = ChronyConfig
+	funcMap["localResolverPackage"] = LocalResolverPackage
+	funcMap["localResolverConfigPath"] = LocalResolverConfigPath
+	funcMap["localResolverConfig"] = LocalResolverConfig
+	funcMap["localResolverScript"] = LocalResolverScript
+	funcMap["machineIDScript"] = MachineIDScript
+	funcMap["nodeRegistrationScript"] = NodeRegistrationScript
+	funcMap["nodeRegistrationSystemdUnit"] = NodeRegistrationSystemdUnit
+	funcMap["kubeletWatchdogScript"] = KubeletWatchdogScript
+	funcMap["kubeletWatchdogSystemdUnit"] = KubeletWatchdogSystemdUnit
+	funcMap["kubeletWatchdogSystemdTimer"] = KubeletWatchdogSystemdTimer
+	funcMap["nodeExporterInstallScript"] = NodeExporterInstallScript
+	funcMap["nodeExporterSystemdUnit"] = NodeExporterSystemdUnit
+	funcMap["timezoneScript"] = TimezoneScript
+	funcMap["conntrackKernelModules"] = ConntrackKernelModules
+	funcMap["conntrackScript"] = ConntrackScript
+	funcMap["staticPodManifestPath"] = StaticPodManifestPath
+	funcMap["journaldConfig"] = JournaldConfig
+	funcMap["imagePullKubeletFlags"] = ImagePullKubeletFlags
+	funcMap["instanceStoreScript"] = InstanceStoreScript
+	funcMap["instanceStoreSystemdUnit"] = InstanceStoreSystemdUnit
+	funcMap["nodeProfileKubeletFlags"] = NodeProfileKubeletFlags
+	funcMap["nodeProfileDockerFlags"] = NodeProfileDockerFlags
+	funcMap["nodeProfileContainerdLimits"] = NodeProfileContainerdLimits
+	funcMap["containerRuntimeSystemdUnit"] = ContainerRuntimeSystemdUnit
+	funcMap["containerRuntimeKubeletFlags"] = ContainerRuntimeKubeletFlags
+	funcMap["containerdConfig"] = ContainerdConfig
+	funcMap["crictlConfig"] = CrictlConfig
+	funcMap["crictlSetupScript"] = CrictlSetupScript
 
 	return funcMap
 }
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// timezoneRegexp matches the names of the tz database like Europe/Berlin or Etc/GMT+2, it keeps the name safe to use
+// in the setup script
+var timezoneRegexp = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// ValidateTimezone checks if the timezone is a name of the tz database. An empty timezone keeps the one of the image
+func ValidateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	// time.LoadLocation maps Local to the timezone of the machine-controller
+	if !timezoneRegexp.MatchString(timezone) || timezone == "Local" {
+		return fmt.Errorf("invalid timezone %q, must be a name of the tz database like Europe/Berlin", timezone)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q, must be a name of the tz database like Europe/Berlin: %v", timezone, err)
+	}
+	return nil
+}
+
+// TimezoneScript returns the script which sets the timezone of the node. timedatectl needs systemd-timedated, which
+// might not be reachable that early during the boot, so /etc/localtime gets linked directly then
+func TimezoneScript(timezone string) string {
+	return fmt.Sprintf(`if ! timedatectl set-timezone %[1]s; then
+    ln -sf /usr/share/zoneinfo/%[1]s /etc/localtime
+fi`, timezone)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTimezone(t *testing.T) {
+	for _, timezone := range []string{"", "UTC", "Europe/Berlin", "America/Argentina/Buenos_Aires", "Etc/GMT+2"} {
+		if err := ValidateTimezone(timezone); err != nil {
+			t.Errorf("expected timezone %q to be valid, got: %v", timezone, err)
+		}
+	}
+	for _, timezone := range []string{"Local", "Europe/Atlantis", "../../etc/passwd", "/usr/share/zoneinfo/UTC", "Europe/Berlin; reboot"} {
+		if err := ValidateTimezone(timezone); err == nil {
+			t.Errorf("expected an error for timezone %q", timezone)
+		}
+	}
+}
+
+func TestTimezoneScript(t *testing.T) {
+	script := TimezoneScript("Europe/Berlin")
+	for _, expected := range []string{
+		"timedatectl set-timezone Europe/Berlin",
+		"ln -sf /usr/share/zoneinfo/Europe/Berlin /etc/localtime",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+		}
+	}
+}
@@ -44,9 +44,11 @@ var (
 	// supportedOS contains a list of operating systems the machine
 	// controller supports.
 	supportedOS = []providerconfig.OperatingSystem{
+		providerconfig.OperatingSystemBottlerocket,
 		providerconfig.OperatingSystemCentOS,
 		providerconfig.OperatingSystemCoreos,
 		providerconfig.OperatingSystemUbuntu,
+		providerconfig.OperatingSystemWindows,
 	}
 )
 
@@ -48,6 +48,7 @@ const (
 type Plugin struct {
 	debug   bool
 	command string
+	version string
 }
 
 // newPlugin creates a new plugin manager. It starts the named
@@ -59,9 +60,26 @@ func newPlugin(os providerconfig.OperatingSystem, debug bool) (*Plugin, error) {
 	if err := p.findPlugin(string(os)); err != nil {
 		return nil, err
 	}
+	p.version = p.queryVersion()
 	return p, nil
 }
 
+// Version returns the version of the plugin.
+func (p *Plugin) Version() string {
+	return p.version
+}
+
+// queryVersion asks the plugin executable for its version. An older plugin won't know
+// the flag, which is not worth failing for.
+func (p *Plugin) queryVersion() string {
+	out, err := exec.Command(p.command, "-version").Output()
+	if err != nil {
+		glog.Warningf("failed to get version of plugin %q: %v", p.command, err)
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
 // UserData retrieves the user data of the given resource via
 // plugin handling the communication.
 func (p *Plugin) UserData(
@@ -71,6 +89,7 @@ func (p *Plugin) UserData(
 	cloudProviderName string,
 	clusterDNSIPs []net.IP,
 	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
 ) (string, error) {
 	// Prepare command.
 	var argv []string
@@ -86,6 +105,7 @@ func (p *Plugin) UserData(
 		CloudConfig:           cloudConfig,
 		DNSIPs:                clusterDNSIPs,
 		ExternalCloudProvider: externalCloudProvider,
+		PhoneHome:             phoneHome,
 	}
 	reqj, err := json.Marshal(req)
 	if err != nil {
@@ -45,6 +45,7 @@ type Provider interface {
 		cloudProviderName string,
 		clusterDNSIPs []net.IP,
 		externalCloudProvider bool,
+		phoneHome *plugin.PhoneHome,
 	) (string, error)
 }
 
@@ -85,6 +86,7 @@ func (p *Plugin) Run() error {
 		req.CloudProviderName,
 		req.DNSIPs,
 		req.ExternalCloudProvider,
+		req.PhoneHome,
 	)
 	var resp plugin.UserDataResponse
 	if err != nil {
@@ -32,6 +32,7 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
@@ -47,6 +48,7 @@ func (p Provider) UserData(
 	cloudProviderName string,
 	clusterDNSIPs []net.IP,
 	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
 ) (string, error) {
 
 	tmpl, err := template.New("user-data").Funcs(userdatahelper.TxtFuncMap()).Parse(userDataTemplate)
@@ -72,11 +74,128 @@ func (p Provider) UserData(
 		return "", errors.New("static IP config is not supported with Ubuntu")
 	}
 
+	clusterDNSIPs, clusterDomain, err := pconfig.ClusterDNSConfig(clusterDNSIPs)
+	if err != nil {
+		return "", err
+	}
+
+	if err := pconfig.ValidateKubeletConfig(); err != nil {
+		return "", err
+	}
+
 	ubuntuConfig, err := LoadConfig(pconfig.OperatingSystemSpec)
 	if err != nil {
 		return "", fmt.Errorf("failed to get ubuntu config from provider config: %v", err)
 	}
 
+	if err := ubuntuConfig.PowerState.Validate(); err != nil {
+		return "", fmt.Errorf("invalid power state: %v", err)
+	}
+
+	if err := userdatahelper.ValidateImageReferences(ubuntuConfig.PrewarmImages); err != nil {
+		return "", fmt.Errorf("invalid prewarm images: %v", err)
+	}
+
+	if err := userdatahelper.ValidateKernelParameters(ubuntuConfig.KernelParameters); err != nil {
+		return "", err
+	}
+
+	if err := ubuntuConfig.AuditLogging.Validate(); err != nil {
+		return "", fmt.Errorf("invalid audit logging config: %v", err)
+	}
+
+	if err := ubuntuConfig.Multipath.Validate(); err != nil {
+		return "", fmt.Errorf("invalid multipath config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateISCSIInitiatorName(ubuntuConfig.ISCSIInitiatorName); err != nil {
+		return "", err
+	}
+
+	if err := ubuntuConfig.NodeLocalDNS.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node-local DNS config: %v", err)
+	}
+
+	if err := ubuntuConfig.StorageInterface.Validate(); err != nil {
+		return "", fmt.Errorf("invalid storage interface config: %v", err)
+	}
+
+	if err := ubuntuConfig.InstanceStore.Validate(); err != nil {
+		return "", fmt.Errorf("invalid instance-store config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateNodeProfile(ubuntuConfig.Profile); err != nil {
+		return "", err
+	}
+
+	if err := ubuntuConfig.PackageUpgrade.Validate(); err != nil {
+		return "", fmt.Errorf("invalid package upgrade config: %v", err)
+	}
+
+	if err := ubuntuConfig.DefaultUser.Validate(); err != nil {
+		return "", fmt.Errorf("invalid default user config: %v", err)
+	}
+
+	if err := ubuntuConfig.NvidiaGPU.Validate(); err != nil {
+		return "", fmt.Errorf("invalid NVIDIA GPU config: %v", err)
+	}
+
+	if err := ubuntuConfig.AppArmor.Validate(); err != nil {
+		return "", fmt.Errorf("invalid AppArmor config: %v", err)
+	}
+
+	if err := ubuntuConfig.Chrony.Validate(); err != nil {
+		return "", fmt.Errorf("invalid chrony config: %v", err)
+	}
+
+	if err := ubuntuConfig.LocalResolver.Validate(); err != nil {
+		return "", fmt.Errorf("invalid local resolver config: %v", err)
+	}
+
+	if err := ubuntuConfig.MachineID.Validate(); err != nil {
+		return "", fmt.Errorf("invalid machine-id config: %v", err)
+	}
+
+	if err := ubuntuConfig.NodeRegistration.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node registration config: %v", err)
+	}
+
+	if err := ubuntuConfig.KubeletWatchdog.Validate(); err != nil {
+		return "", fmt.Errorf("invalid kubelet watchdog config: %v", err)
+	}
+
+	if err := ubuntuConfig.NodeExporter.Validate(); err != nil {
+		return "", fmt.Errorf("invalid node exporter config: %v", err)
+	}
+
+	if err := ubuntuConfig.Conntrack.Validate(); err != nil {
+		return "", fmt.Errorf("invalid conntrack config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateTimezone(ubuntuConfig.Timezone); err != nil {
+		return "", err
+	}
+
+	if err := userdatahelper.ValidateStaticPods(ubuntuConfig.StaticPods); err != nil {
+		return "", fmt.Errorf("invalid static pods: %v", err)
+	}
+
+	if err := ubuntuConfig.PersistentJournal.Validate(); err != nil {
+		return "", fmt.Errorf("invalid persistent journal config: %v", err)
+	}
+
+	if err := userdatahelper.ValidateContainerRuntime(ubuntuConfig.ContainerRuntime); err != nil {
+		return "", err
+	}
+	containerRuntime := ubuntuConfig.ContainerRuntime
+	if containerRuntime == "" {
+		containerRuntime = userdatahelper.ContainerRuntimeDocker
+	}
+
+	if err := ubuntuConfig.ImagePulls.Validate(); err != nil {
+		return "", fmt.Errorf("invalid image pulls config: %v", err)
+	}
+
 	serverAddr, err := userdatahelper.GetServerAddressFromKubeconfig(kubeconfig)
 	if err != nil {
 		return "", fmt.Errorf("error extracting server address from kubeconfig: %v", err)
@@ -99,23 +218,29 @@ func (p Provider) UserData(
 		CloudProvider    string
 		CloudConfig      string
 		ClusterDNSIPs    []net.IP
+		ClusterDomain    string
 		ServerAddr       string
 		KubeletVersion   string
 		Kubeconfig       string
 		KubernetesCACert string
 		IsExternal       bool
+		PhoneHome        *plugin.PhoneHome
+		ContainerRuntime string
 	}{
 		MachineSpec:      spec,
 		ProviderSpec:     pconfig,
 		OSConfig:         ubuntuConfig,
 		CloudProvider:    cloudProviderName,
 		CloudConfig:      cloudConfig,
-		ClusterDNSIPs:    clusterDNSIPs,
+		ClusterDNSIPs:    ubuntuConfig.NodeLocalDNS.ClusterDNSIPs(clusterDNSIPs),
+		ClusterDomain:    clusterDomain,
 		ServerAddr:       serverAddr,
 		KubeletVersion:   kubeletVersion.String(),
 		Kubeconfig:       kubeconfigString,
 		KubernetesCACert: kubernetesCACert,
 		IsExternal:       externalCloudProvider,
+		PhoneHome:        phoneHome,
+		ContainerRuntime: containerRuntime,
 	}
 	b := &bytes.Buffer{}
 	err = tmpl.Execute(b, data)
@@ -133,11 +258,26 @@ hostname: {{ .MachineSpec.Name }}
 {{ end }}
 
 ssh_pwauth: no
+{{- with .OSConfig.DefaultUser }}
+
+users:
+- name: "{{ .Name }}"
+  homedir: "{{ .Home }}"
+  shell: /bin/bash
+  groups: sudo
+  sudo: "ALL=(ALL) NOPASSWD:ALL"
+  lock_passwd: true
+  ssh_authorized_keys:
+{{- range $.ProviderSpec.SSHPublicKeys }}
+  - "{{ . }}"
+{{- end }}
+{{- else }}
 
 ssh_authorized_keys:
 {{- range .ProviderSpec.SSHPublicKeys }}
 - "{{ . }}"
 {{- end }}
+{{- end }}
 
 write_files:
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -151,10 +291,175 @@ write_files:
 - path: "/etc/sysctl.d/k8s.conf"
   content: |
 {{ kernelSettings | indent 4 }}
+{{- if .OSConfig.Conntrack }}
+
+- path: "/etc/modules-load.d/conntrack.conf"
+  content: |
+{{ conntrackKernelModules | indent 4 }}
+{{- end }}
 
 - path: "/etc/apt/sources.list.d/docker.list"
   permissions: "0644"
   content: deb [arch=amd64] https://download.docker.com/linux/ubuntu bionic stable
+{{- if .OSConfig.NvidiaGPU }}
+
+- path: "/etc/apt/sources.list.d/nvidia.list"
+  permissions: "0644"
+  content: |
+    deb https://developer.download.nvidia.com/compute/cuda/repos/ubuntu1804/x86_64 /
+    deb https://nvidia.github.io/libnvidia-container/ubuntu18.04/$(ARCH) /
+    deb https://nvidia.github.io/nvidia-container-runtime/ubuntu18.04/$(ARCH) /
+{{- if eq .ContainerRuntime "docker" }}
+
+- path: "/etc/docker/daemon.json"
+  permissions: "0644"
+  content: |
+{{ nvidiaDockerDaemonConfig | indent 4 }}
+{{- end }}
+{{- end }}
+{{- if .OSConfig.KernelParameters }}
+
+- path: "/etc/default/grub.d/90-machine-controller.cfg"
+  content: |
+    GRUB_CMDLINE_LINUX_DEFAULT="$GRUB_CMDLINE_LINUX_DEFAULT {{ join " " .OSConfig.KernelParameters }}"
+{{- end }}
+{{- with .OSConfig.AuditLogging }}
+
+- path: "/etc/audit/rules.d/90-machine-controller.rules"
+  permissions: "0600"
+  content: |
+{{ auditRules . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.Multipath }}
+
+- path: "/etc/multipath.conf"
+  permissions: "0644"
+  content: |
+{{ .Config | indent 4 }}
+{{- end }}
+{{- with .OSConfig.ISCSIInitiatorName }}
+
+- path: "/etc/iscsi/initiatorname.iscsi"
+  permissions: "0644"
+  content: |
+    InitiatorName={{ . }}
+{{- end }}
+{{- if .OSConfig.NodeLocalDNS }}
+
+- path: "/opt/bin/setup-nodelocaldns"
+  permissions: "0755"
+  content: |
+{{ nodeLocalDNSScript .OSConfig.NodeLocalDNS | indent 4 }}
+
+- path: "/etc/systemd/system/nodelocaldns.service"
+  permissions: "0644"
+  content: |
+{{ nodeLocalDNSSystemdUnit | indent 4 }}
+{{- end }}
+{{- if .OSConfig.StorageInterface }}
+
+- path: "/opt/bin/setup-storage-interface"
+  permissions: "0755"
+  content: |
+{{ storageInterfaceScript .OSConfig.StorageInterface | indent 4 }}
+
+- path: "/etc/systemd/system/storage-interface.service"
+  permissions: "0644"
+  content: |
+{{ storageInterfaceSystemdUnit | indent 4 }}
+{{- end }}
+{{- if .OSConfig.InstanceStore }}
+
+- path: "/opt/bin/setup-instance-store"
+  permissions: "0755"
+  content: |
+{{ instanceStoreScript .OSConfig.InstanceStore | indent 4 }}
+
+- path: "/etc/systemd/system/instance-store.service"
+  permissions: "0644"
+  content: |
+{{ instanceStoreSystemdUnit | indent 4 }}
+{{- end }}
+{{- with .OSConfig.AppArmor }}
+{{- range .Profiles }}
+
+- path: "{{ appArmorProfilePath . }}"
+  permissions: "0644"
+  content: |
+{{ .Content | indent 4 }}
+{{- end }}
+{{- end }}
+{{- with .OSConfig.Chrony }}
+
+- path: "/etc/chrony/chrony.conf"
+  permissions: "0644"
+  content: |
+{{ chronyConfig . "/var/lib/chrony/chrony.drift" | indent 4 }}
+{{- end }}
+{{- with .OSConfig.LocalResolver }}
+
+- path: "{{ localResolverConfigPath . }}"
+  permissions: "0644"
+  content: |
+{{ localResolverConfig . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.NodeRegistration }}
+
+- path: "/opt/bin/node-registration"
+  permissions: "0755"
+  content: |
+{{ nodeRegistrationScript . | indent 4 }}
+
+- path: "/etc/systemd/system/node-registration.service"
+  permissions: "0644"
+  content: |
+{{ nodeRegistrationSystemdUnit | indent 4 }}
+{{- end }}
+{{- with .OSConfig.KubeletWatchdog }}
+
+- path: "/opt/bin/kubelet-watchdog"
+  permissions: "0755"
+  content: |
+{{ kubeletWatchdogScript . | indent 4 }}
+
+- path: "/etc/systemd/system/kubelet-watchdog.service"
+  permissions: "0644"
+  content: |
+{{ kubeletWatchdogSystemdUnit | indent 4 }}
+
+- path: "/etc/systemd/system/kubelet-watchdog.timer"
+  permissions: "0644"
+  content: |
+{{ kubeletWatchdogSystemdTimer . | indent 4 }}
+{{- end }}
+{{- with .OSConfig.NodeExporter }}
+
+- path: "/opt/bin/setup-node-exporter"
+  permissions: "0755"
+  content: |
+    #!/bin/bash
+    set -xeuo pipefail
+{{ nodeExporterInstallScript . | indent 4 }}
+
+- path: "/etc/systemd/system/node-exporter.service"
+  permissions: "0644"
+  content: |
+{{ nodeExporterSystemdUnit . | indent 4 }}
+{{- end }}
+{{- range .OSConfig.StaticPods }}
+
+- path: "{{ staticPodManifestPath . }}"
+  permissions: "0600"
+  content: |
+{{ .Manifest | trim | indent 4 }}
+{{- end }}
+{{- with .OSConfig.PersistentJournal }}
+
+- path: "/etc/systemd/journald.conf.d/persistent-storage.conf"
+  permissions: "0644"
+  content: |
+{{ journaldConfig . | indent 4 }}
+{{- end }}
 
 - path: "/opt/docker.asc"
   permissions: "0400"
@@ -227,12 +532,34 @@ write_files:
   content: |
     #!/bin/bash
     set -xeuo pipefail
+    {{- if .PhoneHome }}
+    trap '/opt/bin/phone-home failure' ERR
+    {{- end }}
 
     # As we added some modules and don't want to reboot, restart the service
     systemctl restart systemd-modules-load.service
     sysctl --system
+    {{- with .OSConfig.Conntrack }}
+
+{{ conntrackScript . | indent 4 }}
+    {{- end }}
+    {{- if .OSConfig.PersistentJournal }}
+
+    # journald only stores its logs persistently once the journal directory exists
+    mkdir -p /var/log/journal
+    systemd-tmpfiles --create --prefix /var/log/journal
+    systemctl restart systemd-journald
+    {{- end }}
+    {{- with .OSConfig.Timezone }}
+
+{{ timezoneScript . | indent 4 }}
+    {{- end }}
 
     apt-key add /opt/docker.asc
+    {{- if .OSConfig.NvidiaGPU }}
+    curl -fsSL https://developer.download.nvidia.com/compute/cuda/repos/ubuntu1804/x86_64/7fa2af80.pub | apt-key add -
+    curl -fsSL https://nvidia.github.io/nvidia-container-runtime/gpgkey | apt-key add -
+    {{- end }}
     apt-get update
 
     # Make sure we always disable swap - Otherwise the kubelet won't start'.
@@ -240,8 +567,13 @@ write_files:
     cat /etc/fstab.orig | awk '$3 ~ /^swap$/ && $1 !~ /^#/ {$0="# commented out by cloudinit\n#"$0} 1' > /etc/fstab.noswap
     mv /etc/fstab.noswap /etc/fstab
     swapoff -a
+    {{- if eq .ContainerRuntime "containerd" }}
+
+    export CR_PKG='containerd.io=1.2.6-3'
+    {{- else }}
 
     export CR_PKG='docker-ce=5:18.09.2~3-0~ubuntu-bionic'
+    {{- end }}
 
     DEBIAN_FRONTEND=noninteractive apt-get -o Dpkg::Options::="--force-confdef" -o Dpkg::Options::="--force-confold" install -y \
       curl \
@@ -262,25 +594,138 @@ write_files:
       util-linux \
       ${CR_PKG} \
       ipvsadm{{ if eq .CloudProvider "vsphere" }} \
-      open-vm-tools{{ end }}
+      open-vm-tools{{ end }}{{ if .OSConfig.AuditLogging }} \
+      auditd{{ end }}{{ if .OSConfig.Multipath }} \
+      multipath-tools{{ end }}{{ if .OSConfig.ISCSIInitiatorName }} \
+      open-iscsi{{ end }}{{ if .OSConfig.PackageUpgrade }} \
+      unattended-upgrades{{ end }}{{ if .OSConfig.AppArmor }} \
+      apparmor{{ end }}{{ if .OSConfig.Chrony }} \
+      chrony{{ end }}{{ with .OSConfig.LocalResolver }} \
+      {{ localResolverPackage . }}{{ end }}{{ if .OSConfig.InstanceStore }} \
+      mdadm{{ end }}
+    {{- if eq .ContainerRuntime "containerd" }}
+
+    apt-mark hold containerd.io
+    {{- else }}
 
     # If something failed during package installation but docker got installed, we need to put it on hold
     apt-mark hold docker.io || true
     apt-mark hold docker-ce || true
+    {{- end }}
+    {{- with .OSConfig.AuditLogging }}
+
+    mkdir -p "$(dirname {{ auditLogFile . }})"
+    sed -i 's|^log_file = .*|log_file = {{ auditLogFile . }}|' /etc/audit/auditd.conf
+    augenrules --load
+    systemctl enable auditd
+    service auditd restart
+    {{- end }}
+    {{- if .OSConfig.Multipath }}
+
+    systemctl enable multipathd
+    systemctl restart multipathd
+    {{- end }}
+    {{- if .OSConfig.ISCSIInitiatorName }}
+
+    systemctl enable iscsid
+    systemctl restart iscsid
+    {{- end }}
+    {{- if .OSConfig.Chrony }}
+
+    # chrony replaces systemd-timesyncd
+    systemctl enable chrony
+    systemctl restart chrony
+    {{- end }}
+    {{- with .OSConfig.LocalResolver }}
+
+{{ localResolverScript . | indent 4 }}
+    {{- end }}
+    {{- with .OSConfig.AppArmor }}
+
+    # The profiles must be loaded before the kubelet starts pods which reference them
+{{ appArmorSetupScript . | indent 4 }}
+    {{- end }}
+    {{- with .OSConfig.NvidiaGPU }}
+
+    # The driver gets built for the running kernel, the container runtime picks up the nvidia runtime on restart
+    DEBIAN_FRONTEND=noninteractive apt-get -o Dpkg::Options::="--force-confdef" -o Dpkg::Options::="--force-confold" install -y \
+      linux-headers-$(uname -r) \
+      {{ .DriverPackage }} \
+      {{ .ToolkitPackage }}
+    apt-mark hold cuda-drivers nvidia-container-runtime
+    systemctl restart {{ $.ContainerRuntime }}
+    if ! nvidia-smi; then
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
 
     {{- if .OSConfig.DistUpgradeOnBoot }}
     DEBIAN_FRONTEND=noninteractive apt-get -o Dpkg::Options::="--force-confdef" -o Dpkg::Options::="--force-confold" dist-upgrade -y
     {{- end }}
+    {{- with .OSConfig.PackageUpgrade }}
+    # Held packages like the container runtime do not get upgraded, a kernel update triggers the reboot below
+    {{- if .SecurityOnly }}
+{{ packageUpgradeScript "unattended-upgrade -v" .Attempts | indent 4 }}
+    {{- else }}
+{{ packageUpgradeScript "DEBIAN_FRONTEND=noninteractive apt-get -o Dpkg::Options::=\"--force-confdef\" -o Dpkg::Options::=\"--force-confold\" upgrade -y" .Attempts | indent 4 }}
+    {{- end }}
+    {{- end }}
+    {{- with .OSConfig.MachineID }}
+    # The machine-id only gets replaced once, the reboot below makes the services pick it up
+    if [[ ! -e /etc/kubernetes/machine-id-replaced ]]; then
+{{ machineIDScript . | indent 6 }}
+      touch /etc/kubernetes/machine-id-replaced
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
+    {{- if .OSConfig.KernelParameters }}
+    # The kernel parameters only get applied once, the reboot below makes them effective
+    if [[ ! -e /etc/kubernetes/kernel-parameters-applied ]]; then
+      update-grub
+      touch /etc/kubernetes/kernel-parameters-applied
+      touch /var/run/reboot-required
+    fi
+    {{- end }}
     if [[ -e /var/run/reboot-required ]]; then
       reboot
     fi
 
 {{ downloadBinariesScript .KubeletVersion true | indent 4 }}
+    {{- if eq .ContainerRuntime "containerd" }}
 
-    systemctl enable --now docker
+{{ crictlSetupScript | indent 4 }}
+    {{- end }}
+
+    {{ if .OSConfig.InstanceStore -}}
+    systemctl enable --now instance-store.service
+    {{ end -}}
+    systemctl enable --now {{ .ContainerRuntime }}
+    {{- if .OSConfig.NodeLocalDNS }}
+    systemctl enable --now nodelocaldns.service
+    {{- end }}
+    {{- if .OSConfig.StorageInterface }}
+    systemctl enable --now storage-interface.service
+    {{- end }}
+    {{- if .OSConfig.PrewarmImages }}
+    # The images get pulled before the kubelet registers the node, failed pulls do not keep it from joining
+    systemctl enable --now image-prewarm.service || true
+    {{- end }}
     systemctl enable --now kubelet
     systemctl enable --now --no-block kubelet-healthcheck.service
-    systemctl enable --now --no-block docker-healthcheck.service
+    systemctl enable --now --no-block {{ .ContainerRuntime }}-healthcheck.service
+    {{- if .OSConfig.NodeRegistration }}
+    systemctl enable --now --no-block node-registration.service
+    {{- end }}
+    {{- if .OSConfig.KubeletWatchdog }}
+    systemctl enable --now kubelet-watchdog.timer
+    {{- end }}
+    {{- if .OSConfig.NodeExporter }}
+    /opt/bin/setup-node-exporter
+    systemctl enable --now node-exporter.service
+    {{- end }}
+    {{- if .PhoneHome }}
+    /opt/bin/phone-home success
+    {{- end }}
 
 - path: "/opt/bin/supervise.sh"
   permissions: "0755"
@@ -290,15 +735,36 @@ write_files:
     while ! "$@"; do
       sleep 1
     done
+{{- with .PhoneHome }}
+
+- path: "/opt/bin/phone-home"
+  permissions: "0700"
+  content: |
+    #!/bin/bash
+    set -uo pipefail
+    # Reports the bootstrap status together with the tail of the setup log to the machine-controller
+    journalctl -u setup.service -n 100 --no-pager | tail -c 4096 | \
+      curl -sS --retry 5 --max-time 30 -X POST \
+        -H "Authorization: Bearer {{ .Token }}" \
+        -H "Content-Type: text/plain" \
+        --data-binary @- \
+        "{{ .URL }}?status=$1" || true
+{{- end }}
 
 - path: "/etc/systemd/system/kubelet.service"
   content: |
-{{ kubeletSystemdUnit .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .IsExternal | indent 4 }}
+{{ kubeletSystemdUnit .ContainerRuntime .KubeletVersion .CloudProvider .MachineSpec.Name .ClusterDNSIPs .ClusterDomain .IsExternal | indent 4 }}
 
 - path: "/etc/systemd/system/kubelet.service.d/extras.conf"
   content: |
     [Service]
-    Environment="KUBELET_EXTRA_ARGS=--resolv-conf=/run/systemd/resolve/resolv.conf"
+    Environment="KUBELET_EXTRA_ARGS=--resolv-conf={{ if .OSConfig.LocalResolver }}/etc/kubernetes/upstream-resolv.conf{{ else }}/run/systemd/resolve/resolv.conf{{ end }}{{ range containerRuntimeKubeletFlags .ContainerRuntime }} {{ . }}{{ end }}{{ range nodeProfileKubeletFlags .KubeletVersion .OSConfig.Profile }} {{ . }}{{ end }}{{ with .OSConfig.ImagePulls }} {{ imagePullKubeletFlags $.KubeletVersion . | join " " }}{{ end }}"
+{{- with .ProviderSpec.KubeletConfig }}
+
+- path: "/etc/kubernetes/kubelet-config.env"
+  content: |
+    KUBELET_CONFIG_ARGS="{{ .Args }}"
+{{- end }}
 
 - path: "/etc/kubernetes/cloud-config"
   content: |
@@ -332,23 +798,69 @@ write_files:
   content: |
     export PATH="/opt/bin:$PATH"
 
+{{- if eq .ContainerRuntime "containerd" }}
+
+- path: "/etc/containerd/config.toml"
+  permissions: "0644"
+  content: |
+{{ containerdConfig "cgroupfs" false .OSConfig.ImagePulls .OSConfig.NvidiaGPU | indent 4 }}
+
+- path: "/etc/crictl.yaml"
+  permissions: "0644"
+  content: |
+{{ crictlConfig | indent 4 }}
+{{- with nodeProfileContainerdLimits .OSConfig.Profile }}
+
+- path: /etc/systemd/system/containerd.service.d/10-limits.conf
+  permissions: "0644"
+  content: |
+    [Service]
+{{ join "\n" . | indent 4 }}
+{{- end }}
+{{- else }}
+
 - path: /etc/systemd/system/docker.service.d/10-storage.conf
   permissions: "0644"
   content: |
     [Service]
     ExecStart=
-    ExecStart=/usr/bin/dockerd -H fd:// --storage-driver=overlay2
+    ExecStart=/usr/bin/dockerd -H fd:// --storage-driver=overlay2{{ with .OSConfig.ImagePulls }} --max-concurrent-downloads={{ .MaxConcurrentDownloads }}{{ end }}{{ range nodeProfileDockerFlags .OSConfig.Profile }} {{ . }}{{ end }}
+{{- end }}
 
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
 {{ kubeletHealthCheckSystemdUnit | indent 4 }}
 
-- path: /etc/systemd/system/docker-healthcheck.service
+- path: /etc/systemd/system/{{ .ContainerRuntime }}-healthcheck.service
+  permissions: "0644"
+  content: |
+{{ containerRuntimeHealthCheckSystemdUnit .ContainerRuntime | indent 4 }}
+{{- if .OSConfig.PrewarmImages }}
+
+- path: "/opt/bin/prewarm-images"
+  permissions: "0755"
+  content: |
+{{ prewarmImagesScript .ContainerRuntime .OSConfig.PrewarmImages | indent 4 }}
+
+- path: /etc/systemd/system/image-prewarm.service
   permissions: "0644"
   content: |
-{{ containerRuntimeHealthCheckSystemdUnit | indent 4 }}
+{{ prewarmImagesSystemdUnit .ContainerRuntime | indent 4 }}
+{{- end }}
 
 runcmd:
 - systemctl enable --now setup.service
+
+{{- with .OSConfig.PowerState }}
+
+power_state:
+  mode: {{ .Mode }}
+  {{- if .Timeout }}
+  timeout: {{ .Timeout }}
+  {{- end }}
+  {{- if .Condition }}
+  condition: {{ .Condition | quote }}
+  {{- end }}
+{{- end }}
 `
@@ -25,6 +25,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/Masterminds/semver"
@@ -34,10 +35,12 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
 	testhelper "github.com/kubermatic/machine-controller/pkg/test"
 	"github.com/kubermatic/machine-controller/pkg/userdata/cloud"
 	"github.com/kubermatic/machine-controller/pkg/userdata/convert"
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
 
 var (
@@ -89,6 +92,8 @@ const (
 	defaultVersion = "1.11.3"
 )
 
+var kubeletMaxPods int32 = 60
+
 type fakeCloudConfigProvider struct {
 	config string
 	name   string
@@ -109,6 +114,7 @@ type userDataTestCase struct {
 	DNSIPs                []net.IP
 	kubernetesCACert      string
 	externalCloudProvider bool
+	phoneHome             *plugin.PhoneHome
 }
 
 func simpleVersionTests() []userDataTestCase {
@@ -335,48 +341,915 @@ func TestUserDataGeneration(t *testing.T) {
 				DistUpgradeOnBoot: false,
 			},
 		},
-	}...)
-
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			spec := test.spec
-			rProviderSpec := test.providerSpec
-			osConfigByte, err := json.Marshal(test.osConfig)
-			if err != nil {
-				t.Fatal(err)
-			}
-			rProviderSpec.OperatingSystemSpec = runtime.RawExtension{
-				Raw: osConfigByte,
-			}
-
-			providerSpecRaw, err := json.Marshal(rProviderSpec)
-			if err != nil {
-				t.Fatal(err)
-			}
-			spec.ProviderSpec = clusterv1alpha1.ProviderSpec{
-				Value: &runtime.RawExtension{
-					Raw: providerSpecRaw,
+		{
+			name: "power-state",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
 				},
-			}
-			provider := Provider{}
-
-			cloudConfig, cloudProviderName, err := test.ccProvider.GetCloudConfig(spec)
-			if err != nil {
-				t.Fatalf("failed to get cloud config: %v", err)
-			}
-
-			s, err := provider.UserData(spec, kubeconfig, cloudConfig, cloudProviderName, test.DNSIPs, test.externalCloudProvider)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			// Check if we can gzip it.
-			if _, err := convert.GzipString(s); err != nil {
-				t.Fatal(err)
-			}
-			goldenName := test.name + ".yaml"
-			testhelper.CompareOutput(t, goldenName, s, *update)
-		})
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				PowerState: &userdatahelper.PowerState{
+					Mode:      "reboot",
+					Timeout:   30,
+					Condition: "test -f /var/run/reboot-required",
+				},
+			},
+		},
+		{
+			name: "prewarm-images",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				PrewarmImages: []string{
+					"k8s.gcr.io/pause:3.1",
+					"quay.io/calico/node:v3.5.1",
+					"registry.local:5000/team/app@sha256:e7d92cdc71feacf90708cb59182d0df1b911f8ae022d29e8e95d75ca6a99776a",
+				},
+			},
+		},
+		{
+			name: "kernel-parameters",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				KernelParameters:  []string{"transparent_hugepage=never", "hugepagesz=1G", "hugepages=4"},
+			},
+		},
+		{
+			name: "audit-logging",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				AuditLogging: &userdatahelper.AuditLogging{
+					Rules:   []string{"-w /etc/passwd -p wa -k identity", "-a always,exit -F arch=b64 -S execve -k exec"},
+					LogFile: "/var/log/audit/node.log",
+					Kubelet: true,
+				},
+			},
+		},
+		{
+			name: "phone-home",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+			phoneHome: &plugin.PhoneHome{
+				URL:   "https://machine-controller.example.com/phone-home/machine-uid",
+				Token: "token",
+			},
+		},
+		{
+			name: "multipath-iscsi",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				Multipath: &userdatahelper.Multipath{
+					Config: "defaults {\n    user_friendly_names yes\n    find_multipaths yes\n}",
+				},
+				ISCSIInitiatorName: "iqn.2019-06.com.example:node1",
+			},
+		},
+		{
+			name: "node-local-dns",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				NodeLocalDNS: &userdatahelper.NodeLocalDNS{
+					LocalIP: "169.254.20.10",
+				},
+			},
+		},
+		{
+			name: "package-upgrade-security-only",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+				PackageUpgrade: &userdatahelper.PackageUpgrade{
+					SecurityOnly: true,
+					Retries:      5,
+				},
+			},
+		},
+		{
+			name: "apparmor-profiles",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				AppArmor: &userdatahelper.AppArmor{
+					Profiles: []userdatahelper.AppArmorProfile{
+						{
+							Name:    "k8s-deny-write",
+							Content: "#include <tunables/global>\n\nprofile k8s-deny-write flags=(attach_disconnected) {\n  #include <abstractions/base>\n  file,\n  deny /** w,\n}",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "default-user",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DefaultUser: &userdatahelper.DefaultUser{
+					Name:    "cloud-user",
+					HomeDir: "/var/lib/cloud-user",
+				},
+			},
+		},
+		{
+			name: "nvidia-gpu",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				NvidiaGPU: &userdatahelper.NvidiaGPU{
+					DriverVersion:           "418.87.01",
+					ContainerToolkitVersion: "3.1.4",
+				},
+			},
+		},
+		{
+			name: "chrony-server",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				Chrony: &userdatahelper.Chrony{
+					Servers:      []string{"10.0.0.1"},
+					Allow:        []string{"10.0.1.0/24", "10.0.2.0/24"},
+					LocalStratum: 10,
+				},
+			},
+		},
+		{
+			name: "machine-id-from-instance-id",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				MachineID: &userdatahelper.MachineID{
+					Source: "instance-id",
+				},
+			},
+		},
+		{
+			name: "node-registration",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				NodeRegistration: &userdatahelper.NodeRegistration{
+					RegisterCommand:   `curl -sS -X POST -d "{\"name\": \"{{ .Hostname }}\", \"ips\": \"{{ .IPs }}\"}" "https://cmdb.example.com/nodes/{{ .InstanceID }}"`,
+					DeregisterCommand: `curl -sS -X DELETE "https://cmdb.example.com/nodes/{{ .InstanceID }}"`,
+				},
+			},
+		},
+		{
+			name: "kubelet-watchdog",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				KubeletWatchdog: &userdatahelper.KubeletWatchdog{
+					Interval:         "30s",
+					RestartThreshold: 2,
+					RebootThreshold:  6,
+				},
+			},
+		},
+		{
+			name: "node-exporter",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				NodeExporter: &userdatahelper.NodeExporter{
+					ListenAddress: "0.0.0.0:9100",
+				},
+			},
+		},
+		{
+			name: "timezone",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				Timezone: "Europe/Berlin",
+			},
+		},
+		{
+			name: "conntrack",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				Conntrack: &userdatahelper.Conntrack{
+					MaxPerGiB:             32768,
+					TCPTimeoutEstablished: 3600,
+				},
+			},
+		},
+		{
+			name: "cluster-dns-overrides",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+				ClusterDNS:    []string{"10.96.0.10"},
+				ClusterDomain: "k8s.example.com",
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+		},
+		{
+			name: "kubelet-config",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+				KubeletConfig: &providerconfig.KubeletConfig{
+					EvictionHard: "memory.available<200Mi,nodefs.available<10%",
+					MaxPods:      &kubeletMaxPods,
+				},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+		},
+		{
+			name: "static-pods",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				StaticPods: []userdatahelper.StaticPod{
+					{
+						Name:     "edge-proxy",
+						Manifest: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: edge-proxy\n  namespace: kube-system\nspec:\n  hostNetwork: true\n  containers:\n  - name: proxy\n    image: nginx:1.15\n",
+					},
+				},
+			},
+		},
+		{
+			name: "persistent-journal",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				PersistentJournal: &userdatahelper.PersistentJournal{
+					MaxSize: "500M",
+				},
+			},
+		},
+		{
+			name: "storage-interface",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				StorageInterface: &userdatahelper.StorageInterface{
+					Name: "ens6",
+					MTU:  9000,
+					Routes: []userdatahelper.StorageRoute{
+						{Destination: "10.20.0.0/16", Gateway: "10.10.0.1"},
+					},
+				},
+			},
+		},
+		{
+			name: "image-pulls",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.27.2",
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				ImagePulls: &userdatahelper.ImagePulls{
+					MaxConcurrentDownloads: 8,
+				},
+			},
+		},
+		{
+			name: "instance-store",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				InstanceStore: &userdatahelper.InstanceStore{
+					MountPath: "/var/lib/cache",
+					RAID0:     true,
+				},
+			},
+		},
+		{
+			name: "gpu-profile",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.17.0",
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				Profile: userdatahelper.NodeProfileGPU,
+			},
+		},
+		{
+			name: "containerd",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.13.5",
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				ContainerRuntime: userdatahelper.ContainerRuntimeContainerd,
+				PrewarmImages:    []string{"k8s.gcr.io/pause:3.1"},
+				ImagePulls: &userdatahelper.ImagePulls{
+					MaxConcurrentDownloads: 8,
+				},
+				Profile: userdatahelper.NodeProfileGPU,
+			},
+		},
+		{
+			name: "local-resolver",
+			providerSpec: &providerconfig.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				LocalResolver: &userdatahelper.LocalResolver{
+					Software:        userdatahelper.LocalResolverDnsmasq,
+					UpstreamServers: []string{"10.0.0.2", "10.0.0.3"},
+					CacheSize:       5000,
+				},
+			},
+		},
+	}...)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec
+			rProviderSpec := test.providerSpec
+			osConfigByte, err := json.Marshal(test.osConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rProviderSpec.OperatingSystemSpec = runtime.RawExtension{
+				Raw: osConfigByte,
+			}
+
+			providerSpecRaw, err := json.Marshal(rProviderSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec.ProviderSpec = clusterv1alpha1.ProviderSpec{
+				Value: &runtime.RawExtension{
+					Raw: providerSpecRaw,
+				},
+			}
+			provider := Provider{}
+
+			cloudConfig, cloudProviderName, err := test.ccProvider.GetCloudConfig(spec)
+			if err != nil {
+				t.Fatalf("failed to get cloud config: %v", err)
+			}
+
+			s, err := provider.UserData(spec, kubeconfig, cloudConfig, cloudProviderName, test.DNSIPs, test.externalCloudProvider, test.phoneHome)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Check if we can gzip it.
+			if _, err := convert.GzipString(s); err != nil {
+				t.Fatal(err)
+			}
+			goldenName := test.name + ".yaml"
+			testhelper.CompareOutput(t, goldenName, s, *update)
+		})
+	}
+}
+
+func TestUserDataContainerRuntimePerMachineDeployment(t *testing.T) {
+	t.Parallel()
+
+	render := func(machineDeployment string, osConfig *Config) string {
+		osConfigRaw, err := json.Marshal(osConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		providerSpecRaw, err := json.Marshal(&providerconfig.Config{
+			SSHPublicKeys:       []string{"ssh-rsa AAABBB"},
+			OperatingSystemSpec: runtime.RawExtension{Raw: osConfigRaw},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := clusterv1alpha1.MachineSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: machineDeployment + "-5f7b9c-x2k4j",
+			},
+			ProviderSpec: clusterv1alpha1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: providerSpecRaw},
+			},
+			Versions: clusterv1alpha1.MachineVersionInfo{
+				Kubelet: defaultVersion,
+			},
+		}
+		userData, err := Provider{}.UserData(spec, kubeconfig, "", "", []net.IP{net.ParseIP("10.10.10.10")}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return userData
+	}
+
+	tests := []struct {
+		runtime     string
+		userData    string
+		expected    []string
+		notExpected []string
+	}{
+		{
+			runtime:  userdatahelper.ContainerRuntimeDocker,
+			userData: render("docker-pool", &Config{}),
+			expected: []string{
+				"export CR_PKG='docker-ce=",
+				"/etc/systemd/system/docker.service.d/10-storage.conf",
+				"systemctl enable --now docker\n",
+				"Requires=docker.service",
+			},
+			notExpected: []string{"containerd", "--container-runtime=remote"},
+		},
+		{
+			runtime:  userdatahelper.ContainerRuntimeContainerd,
+			userData: render("containerd-pool", &Config{ContainerRuntime: userdatahelper.ContainerRuntimeContainerd}),
+			expected: []string{
+				"export CR_PKG='containerd.io=",
+				"/etc/containerd/config.toml",
+				"systemctl enable --now containerd\n",
+				"Requires=containerd.service",
+				"--container-runtime=remote --container-runtime-endpoint=unix:///run/containerd/containerd.sock",
+			},
+			notExpected: []string{"docker-ce=", "dockerd", "docker.service"},
+		},
+	}
+
+	for _, test := range tests {
+		for _, expected := range test.expected {
+			if !strings.Contains(test.userData, expected) {
+				t.Errorf("expected the %s user-data to contain %q", test.runtime, expected)
+			}
+		}
+		for _, notExpected := range test.notExpected {
+			if strings.Contains(test.userData, notExpected) {
+				t.Errorf("expected the %s user-data not to contain %q", test.runtime, notExpected)
+			}
+		}
 	}
 }
 
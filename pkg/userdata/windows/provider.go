@@ -0,0 +1,234 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//
+// UserData plugin for Windows.
+//
+
+package windows
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"text/template"
+
+	"github.com/Masterminds/semver"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
+)
+
+// The kubelet supports containerd on Windows starting with 1.20
+var minKubeletVersion = semver.MustParse("1.20.0")
+
+// Provider is a pkg/userdata/plugin.Provider implementation.
+type Provider struct{}
+
+// UserData renders user-data template to string.
+func (p Provider) UserData(
+	spec clusterv1alpha1.MachineSpec,
+	kubeconfig *clientcmdapi.Config,
+	cloudConfig string,
+	cloudProviderName string,
+	clusterDNSIPs []net.IP,
+	externalCloudProvider bool,
+	phoneHome *plugin.PhoneHome,
+) (string, error) {
+
+	tmpl, err := template.New("user-data").Funcs(userdatahelper.TxtFuncMap()).Parse(userDataTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse user-data template: %v", err)
+	}
+
+	kubeletVersion, err := semver.NewVersion(spec.Versions.Kubelet)
+	if err != nil {
+		return "", fmt.Errorf("invalid kubelet version: %v", err)
+	}
+	if kubeletVersion.LessThan(minKubeletVersion) {
+		return "", fmt.Errorf("windows nodes require a kubelet version >= %s", minKubeletVersion)
+	}
+
+	pconfig, err := providerconfig.GetConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get providerSpec: %v", err)
+	}
+
+	if pconfig.CloudProvider != providerconfig.CloudProviderAWS {
+		return "", fmt.Errorf("windows is not supported on cloud provider %q", pconfig.CloudProvider)
+	}
+
+	if pconfig.OverwriteCloudConfig != nil {
+		cloudConfig = *pconfig.OverwriteCloudConfig
+	}
+
+	if pconfig.Network != nil {
+		return "", errors.New("static IP config is not supported with Windows")
+	}
+
+	clusterDNSIPs, clusterDomain, err := pconfig.ClusterDNSConfig(clusterDNSIPs)
+	if err != nil {
+		return "", err
+	}
+
+	windowsConfig, err := LoadConfig(pconfig.OperatingSystemSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to get windows config from provider config: %v", err)
+	}
+
+	if _, err := semver.NewVersion(windowsConfig.ContainerdVersion); err != nil {
+		return "", fmt.Errorf("invalid containerd version: %v", err)
+	}
+
+	if err := userdatahelper.ValidateImageReferences([]string{windowsConfig.PauseImage}); err != nil {
+		return "", fmt.Errorf("invalid pause image: %v", err)
+	}
+
+	if err := windowsConfig.ImagePulls.Validate(); err != nil {
+		return "", fmt.Errorf("invalid image pulls config: %v", err)
+	}
+
+	kubeconfigString, err := userdatahelper.StringifyKubeconfig(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+
+	kubernetesCACert, err := userdatahelper.GetCACert(kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("error extracting cacert: %v", err)
+	}
+
+	data := struct {
+		MachineSpec      clusterv1alpha1.MachineSpec
+		ProviderSpec     *providerconfig.Config
+		OSConfig         *Config
+		CloudProvider    string
+		CloudConfig      string
+		ClusterDNSIPs    []net.IP
+		ClusterDomain    string
+		KubeletVersion   string
+		Kubeconfig       string
+		KubernetesCACert string
+		IsExternal       bool
+	}{
+		MachineSpec:      spec,
+		ProviderSpec:     pconfig,
+		OSConfig:         windowsConfig,
+		CloudProvider:    cloudProviderName,
+		CloudConfig:      cloudConfig,
+		ClusterDNSIPs:    clusterDNSIPs,
+		ClusterDomain:    clusterDomain,
+		KubeletVersion:   kubeletVersion.String(),
+		Kubeconfig:       kubeconfigString,
+		KubernetesCACert: kubernetesCACert,
+		IsExternal:       externalCloudProvider,
+	}
+	b := &bytes.Buffer{}
+	err = tmpl.Execute(b, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute user-data template: %v", err)
+	}
+	return userdatahelper.CleanupTemplateOutput(b.String())
+}
+
+// UserData template. It is a PowerShell script in the format of EC2Launch which runs on every boot,
+// so all steps must be idempotent.
+const userDataTemplate = `<powershell>
+$ErrorActionPreference = "Stop"
+$ProgressPreference = "SilentlyContinue"
+
+# Installing the containers feature requires a reboot, the script continues on the next boot
+if ((Get-WindowsFeature -Name Containers).InstallState -ne "Installed") {
+  Install-WindowsFeature -Name Containers
+  Restart-Computer -Force
+  exit
+}
+
+New-Item -ItemType Directory -Force -Path C:\etc\kubernetes\pki, C:\etc\cni\net.d, C:\opt\cni\bin, "C:\Program Files\containerd" | Out-Null
+
+Set-Content -Path C:\etc\kubernetes\bootstrap-kubelet.conf -Value @'
+{{ .Kubeconfig }}
+'@
+
+Set-Content -Path C:\etc\kubernetes\pki\ca.crt -Value @'
+{{ .KubernetesCACert }}
+'@
+
+Set-Content -Path C:\etc\kubernetes\cloud-config -Value @'
+{{ .CloudConfig }}
+'@
+
+if (-not (Get-Service -Name containerd -ErrorAction SilentlyContinue)) {
+  $containerdArchive = "$env:TEMP\containerd.tar.gz"
+  Invoke-WebRequest -UseBasicParsing -OutFile $containerdArchive -Uri "https://github.com/containerd/containerd/releases/download/v{{ .OSConfig.ContainerdVersion }}/containerd-{{ .OSConfig.ContainerdVersion }}-windows-amd64.tar.gz"
+  tar.exe -xzf $containerdArchive -C "C:\Program Files\containerd" --strip-components=1
+  $containerdConfig = & "C:\Program Files\containerd\containerd.exe" config default | Out-String
+  $containerdConfig = $containerdConfig -replace 'sandbox_image = ".*"', 'sandbox_image = "{{ .OSConfig.PauseImage }}"'
+{{- with .OSConfig.ImagePulls }}
+  $containerdConfig = $containerdConfig -replace 'max_concurrent_downloads = [0-9]+', 'max_concurrent_downloads = {{ .MaxConcurrentDownloads }}'
+{{- end }}
+  Set-Content -Path "C:\Program Files\containerd\config.toml" -Value $containerdConfig -Encoding ascii
+  & "C:\Program Files\containerd\containerd.exe" --register-service
+}
+Start-Service -Name containerd
+
+if (-not (Get-Service -Name kubelet -ErrorAction SilentlyContinue)) {
+  Invoke-WebRequest -UseBasicParsing -OutFile C:\etc\kubernetes\kubelet.exe -Uri "https://storage.googleapis.com/kubernetes-release/release/v{{ .KubeletVersion }}/bin/windows/amd64/kubelet.exe"
+  $kubeletArgs = @(
+    "--windows-service",
+    "--bootstrap-kubeconfig=C:\etc\kubernetes\bootstrap-kubelet.conf",
+    "--kubeconfig=C:\etc\kubernetes\kubelet.conf",
+    "--cert-dir=C:\etc\kubernetes\pki",
+    "--client-ca-file=C:\etc\kubernetes\pki\ca.crt",
+    "--rotate-certificates=true",
+    "--authorization-mode=Webhook",
+    "--authentication-token-webhook=true",
+    "--anonymous-auth=false",
+    "--read-only-port=0",
+    "--container-runtime=remote",
+    "--container-runtime-endpoint=npipe:////./pipe/containerd-containerd",
+    "--pod-infra-container-image={{ .OSConfig.PauseImage }}",
+    "--network-plugin=cni",
+    "--cni-bin-dir=C:\opt\cni\bin",
+    "--cni-conf-dir=C:\etc\cni\net.d",
+    "--cgroups-per-qos=false",
+    "--enforce-node-allocatable=",
+    "--resolv-conf=",
+{{- with .OSConfig.ImagePulls }}
+{{- range imagePullKubeletFlags $.KubeletVersion . }}
+    "{{ . }}",
+{{- end }}
+{{- end }}
+{{- if .IsExternal }}
+    "--cloud-provider=external",
+{{- else if .CloudProvider }}
+    "--cloud-provider={{ .CloudProvider }}",
+    "--cloud-config=C:\etc\kubernetes\cloud-config",
+{{- end }}
+    "--cluster-dns={{ .ClusterDNSIPs | join "," }}",
+    "--cluster-domain={{ .ClusterDomain }}"
+  ) -join " "
+  New-Service -Name kubelet -StartupType Automatic -DependsOn containerd -BinaryPathName "C:\etc\kubernetes\kubelet.exe $kubeletArgs"
+}
+Start-Service -Name kubelet
+</powershell>
+<persist>true</persist>
+`
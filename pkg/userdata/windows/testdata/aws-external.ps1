@@ -0,0 +1,106 @@
+<powershell>
+$ErrorActionPreference = "Stop"
+$ProgressPreference = "SilentlyContinue"
+
+# Installing the containers feature requires a reboot, the script continues on the next boot
+if ((Get-WindowsFeature -Name Containers).InstallState -ne "Installed") {
+  Install-WindowsFeature -Name Containers
+  Restart-Computer -Force
+  exit
+}
+
+New-Item -ItemType Directory -Force -Path C:\etc\kubernetes\pki, C:\etc\cni\net.d, C:\opt\cni\bin, "C:\Program Files\containerd" | Out-Null
+
+Set-Content -Path C:\etc\kubernetes\bootstrap-kubelet.conf -Value @'
+apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUVXakNDQTBLZ0F3SUJBZ0lKQUxmUmxXc0k4WVFITUEwR0NTcUdTSWIzRFFFQkJRVUFNSHN4Q3pBSkJnTlYKQkFZVEFsVlRNUXN3Q1FZRFZRUUlFd0pEUVRFV01CUUdBMVVFQnhNTlUyRnVJRVp5WVc1amFYTmpiekVVTUJJRwpBMVVFQ2hNTFFuSmhaR1pwZEhwcGJtTXhFakFRQmdOVkJBTVRDV3h2WTJGc2FHOXpkREVkTUJzR0NTcUdTSWIzCkRRRUpBUllPWW5KaFpFQmtZVzVuWVM1amIyMHdIaGNOTVRRd056RTFNakEwTmpBMVdoY05NVGN3TlRBME1qQTAKTmpBMVdqQjdNUXN3Q1FZRFZRUUdFd0pWVXpFTE1Ba0dBMVVFQ0JNQ1EwRXhGakFVQmdOVkJBY1REVk5oYmlCRwpjbUZ1WTJselkyOHhGREFTQmdOVkJBb1RDMEp5WVdSbWFYUjZhVzVqTVJJd0VBWURWUVFERXdsc2IyTmhiR2h2CmMzUXhIVEFiQmdrcWhraUc5dzBCQ1FFV0RtSnlZV1JBWkdGdVoyRXVZMjl0TUlJQklqQU5CZ2txaGtpRzl3MEIKQVFFRkFBT0NBUThBTUlJQkNnS0NBUUVBdDVmQWpwNGZUY2VrV1VUZnpzcDBreWloMU9ZYnNHTDBLWDFlUmJTUwpSOE9kMCs5UTYySHlueStHRndNVGI0QS9LVThtc3NvSHZjY2VTQUFid2ZieEZLLytzNTFUb2JxVW5PUlpyT29UClpqa1V5Z2J5WERTSzk5WUJiY1IxUGlwOHZ3TVRtNFhLdUx0Q2lnZUJCZGpqQVFkZ1VPMjhMRU5HbHNNbm1lWWsKSmZPRFZHblZtcjVMdGI5QU5BOElLeVRmc25ISjRpT0NTL1BsUGJVajJxN1lub1ZMcG9zVUJNbGdVYi9DeWtYMwptT29MYjR5SkpReUEvaVNUNlp4aUlFajM2RDR5V1o1bGc3WUpsK1VpaUJRSEdDblBkR3lpcHFWMDZleDBoZVlXCmNhaVc4TFdaU1VROTNqUStXVkNIOGhUN0RRTzFkbXN2VW1YbHEvSmVBbHdRL1FJREFRQUJvNEhnTUlIZE1CMEcKQTFVZERnUVdCQlJjQVJPdGhTNFA0VTd2VGZqQnlDNTY5UjdFNkRDQnJRWURWUjBqQklHbE1JR2lnQlJjQVJPdApoUzRQNFU3dlRmakJ5QzU2OVI3RTZLRi9wSDB3ZXpFTE1Ba0dBMVVFQmhNQ1ZWTXhDekFKQmdOVkJBZ1RBa05CCk1SWXdGQVlEVlFRSEV3MVRZVzRnUm5KaGJtTnBjMk52TVJRd0VnWURWUVFLRXd0Q2NtRmtabWwwZW1sdVl6RVMKTUJBR0ExVUVBeE1KYkc5allXeG9iM04wTVIwd0d3WUpLb1pJaHZjTkFRa0JGZzVpY21Ga1FHUmhibWRoTG1OdgpiWUlKQUxmUmxXc0k4WVFITUF3R0ExVWRFd1FGTUFNQkFmOHdEUVlKS29aSWh2Y05BUUVGQlFBRGdnRUJBRzZoClU5ZjlzTkgwLzZvQmJHR3kyRVZVMFVnSVRVUUlyRldvOXJGa3JXNWsvWGtEalFtKzNsempUMGlHUjRJeEUvQW8KZVU2c1FodWE3d3JXZUZFbjQ3R0w5OGxuQ3NKZEQ3b1pOaEZtUTk1VGIvTG5EVWpzNVlqOWJyUDBOV3pYZllVNApVSzJabklOSlJjSnBCOGlSQ2FDeEU4RGRjVUYwWHFJRXE2cEEyNzJzbm9MbWlYTE12Tmwza1lFZG0ramU2dm9ECjU4U05WRVVzenR6UXlYbUpFaENwd1ZJMEE2UUNqelhqK3F2cG13M1paSGk4SndYZWk4WlpCTFRTRkJraThaN24Kc0g5QkJIMzgvU3pVbUFONFFIU1B5MWdqcW0wME9BRThOYVlEa2gvYnpFNGQ3bUxHR01XcC9XRTNLUFN1ODJIRgprUGU2WG9TYmlMbS9reGszMlQwPQotLS0tLUVORCBDRVJUSUZJQ0FURS0tLS0t
+    server: https://server:443
+  name: ""
+contexts: []
+current-context: ""
+kind: Config
+preferences: {}
+users:
+- name: ""
+  user:
+    token: my-token
+
+'@
+
+Set-Content -Path C:\etc\kubernetes\pki\ca.crt -Value @'
+-----BEGIN CERTIFICATE-----
+MIIEWjCCA0KgAwIBAgIJALfRlWsI8YQHMA0GCSqGSIb3DQEBBQUAMHsxCzAJBgNV
+BAYTAlVTMQswCQYDVQQIEwJDQTEWMBQGA1UEBxMNU2FuIEZyYW5jaXNjbzEUMBIG
+A1UEChMLQnJhZGZpdHppbmMxEjAQBgNVBAMTCWxvY2FsaG9zdDEdMBsGCSqGSIb3
+DQEJARYOYnJhZEBkYW5nYS5jb20wHhcNMTQwNzE1MjA0NjA1WhcNMTcwNTA0MjA0
+NjA1WjB7MQswCQYDVQQGEwJVUzELMAkGA1UECBMCQ0ExFjAUBgNVBAcTDVNhbiBG
+cmFuY2lzY28xFDASBgNVBAoTC0JyYWRmaXR6aW5jMRIwEAYDVQQDEwlsb2NhbGhv
+c3QxHTAbBgkqhkiG9w0BCQEWDmJyYWRAZGFuZ2EuY29tMIIBIjANBgkqhkiG9w0B
+AQEFAAOCAQ8AMIIBCgKCAQEAt5fAjp4fTcekWUTfzsp0kyih1OYbsGL0KX1eRbSS
+R8Od0+9Q62Hyny+GFwMTb4A/KU8mssoHvcceSAAbwfbxFK/+s51TobqUnORZrOoT
+ZjkUygbyXDSK99YBbcR1Pip8vwMTm4XKuLtCigeBBdjjAQdgUO28LENGlsMnmeYk
+JfODVGnVmr5Ltb9ANA8IKyTfsnHJ4iOCS/PlPbUj2q7YnoVLposUBMlgUb/CykX3
+mOoLb4yJJQyA/iST6ZxiIEj36D4yWZ5lg7YJl+UiiBQHGCnPdGyipqV06ex0heYW
+caiW8LWZSUQ93jQ+WVCH8hT7DQO1dmsvUmXlq/JeAlwQ/QIDAQABo4HgMIHdMB0G
+A1UdDgQWBBRcAROthS4P4U7vTfjByC569R7E6DCBrQYDVR0jBIGlMIGigBRcAROt
+hS4P4U7vTfjByC569R7E6KF/pH0wezELMAkGA1UEBhMCVVMxCzAJBgNVBAgTAkNB
+MRYwFAYDVQQHEw1TYW4gRnJhbmNpc2NvMRQwEgYDVQQKEwtCcmFkZml0emluYzES
+MBAGA1UEAxMJbG9jYWxob3N0MR0wGwYJKoZIhvcNAQkBFg5icmFkQGRhbmdhLmNv
+bYIJALfRlWsI8YQHMAwGA1UdEwQFMAMBAf8wDQYJKoZIhvcNAQEFBQADggEBAG6h
+U9f9sNH0/6oBbGGy2EVU0UgITUQIrFWo9rFkrW5k/XkDjQm+3lzjT0iGR4IxE/Ao
+eU6sQhua7wrWeFEn47GL98lnCsJdD7oZNhFmQ95Tb/LnDUjs5Yj9brP0NWzXfYU4
+UK2ZnINJRcJpB8iRCaCxE8DdcUF0XqIEq6pA272snoLmiXLMvNl3kYEdm+je6voD
+58SNVEUsztzQyXmJEhCpwVI0A6QCjzXj+qvpmw3ZZHi8JwXei8ZZBLTSFBki8Z7n
+sH9BBH38/SzUmAN4QHSPy1gjqm00OAE8NaYDkh/bzE4d7mLGGMWp/WE3KPSu82HF
+kPe6XoSbiLm/kxk32T0=
+-----END CERTIFICATE-----
+'@
+
+Set-Content -Path C:\etc\kubernetes\cloud-config -Value @'
+{aws-config:true}
+'@
+
+if (-not (Get-Service -Name containerd -ErrorAction SilentlyContinue)) {
+  $containerdArchive = "$env:TEMP\containerd.tar.gz"
+  Invoke-WebRequest -UseBasicParsing -OutFile $containerdArchive -Uri "https://github.com/containerd/containerd/releases/download/v1.5.2/containerd-1.5.2-windows-amd64.tar.gz"
+  tar.exe -xzf $containerdArchive -C "C:\Program Files\containerd" --strip-components=1
+  $containerdConfig = & "C:\Program Files\containerd\containerd.exe" config default | Out-String
+  $containerdConfig = $containerdConfig -replace 'sandbox_image = ".*"', 'sandbox_image = "mcr.microsoft.com/oss/kubernetes/pause:3.5"'
+  Set-Content -Path "C:\Program Files\containerd\config.toml" -Value $containerdConfig -Encoding ascii
+  & "C:\Program Files\containerd\containerd.exe" --register-service
+}
+Start-Service -Name containerd
+
+if (-not (Get-Service -Name kubelet -ErrorAction SilentlyContinue)) {
+  Invoke-WebRequest -UseBasicParsing -OutFile C:\etc\kubernetes\kubelet.exe -Uri "https://storage.googleapis.com/kubernetes-release/release/v1.20.4/bin/windows/amd64/kubelet.exe"
+  $kubeletArgs = @(
+    "--windows-service",
+    "--bootstrap-kubeconfig=C:\etc\kubernetes\bootstrap-kubelet.conf",
+    "--kubeconfig=C:\etc\kubernetes\kubelet.conf",
+    "--cert-dir=C:\etc\kubernetes\pki",
+    "--client-ca-file=C:\etc\kubernetes\pki\ca.crt",
+    "--rotate-certificates=true",
+    "--authorization-mode=Webhook",
+    "--authentication-token-webhook=true",
+    "--anonymous-auth=false",
+    "--read-only-port=0",
+    "--container-runtime=remote",
+    "--container-runtime-endpoint=npipe:////./pipe/containerd-containerd",
+    "--pod-infra-container-image=mcr.microsoft.com/oss/kubernetes/pause:3.5",
+    "--network-plugin=cni",
+    "--cni-bin-dir=C:\opt\cni\bin",
+    "--cni-conf-dir=C:\etc\cni\net.d",
+    "--cgroups-per-qos=false",
+    "--enforce-node-allocatable=",
+    "--resolv-conf=",
+    "--cloud-provider=external",
+    "--cluster-dns=10.10.10.10,10.10.10.11",
+    "--cluster-domain=cluster.local"
+  ) -join " "
+  New-Service -Name kubelet -StartupType Automatic -DependsOn containerd -BinaryPathName "C:\etc\kubernetes\kubelet.exe $kubeletArgs"
+}
+Start-Service -Name kubelet
+</powershell>
+<persist>true</persist>
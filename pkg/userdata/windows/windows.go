@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package windows
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
+)
+
+const (
+	defaultContainerdVersion = "1.4.4"
+	defaultPauseImage        = "mcr.microsoft.com/oss/kubernetes/pause:3.4.1"
+)
+
+// Config contains specific configuration for Windows.
+type Config struct {
+	// ContainerdVersion is the version of containerd which gets installed
+	ContainerdVersion string `json:"containerdVersion,omitempty"`
+	// PauseImage is the sandbox image. It must match the Windows version of the image
+	PauseImage string `json:"pauseImage,omitempty"`
+	// ImagePulls sets how many images containerd and the kubelet pull at the same time, e.g. to speed up large rollouts
+	ImagePulls *userdatahelper.ImagePulls `json:"imagePulls,omitempty"`
+}
+
+// LoadConfig retrieves the Windows configuration from raw data.
+func LoadConfig(r runtime.RawExtension) (*Config, error) {
+	cfg := Config{}
+	if len(r.Raw) != 0 {
+		if err := json.Unmarshal(r.Raw, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ContainerdVersion == "" {
+		cfg.ContainerdVersion = defaultContainerdVersion
+	}
+	if cfg.PauseImage == "" {
+		cfg.PauseImage = defaultPauseImage
+	}
+	return &cfg, nil
+}
+
+// Spec return the configuration as raw data.
+func (cfg *Config) Spec() (*runtime.RawExtension, error) {
+	ext := &runtime.RawExtension{}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ext.Raw = b
+	return ext, nil
+}
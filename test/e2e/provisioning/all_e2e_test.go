@@ -23,9 +23,12 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 
+	userdatahelper "github.com/kubermatic/machine-controller/pkg/userdata/helper"
+
 	"k8s.io/client-go/kubernetes/scheme"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
@@ -37,17 +40,20 @@ func init() {
 }
 
 const (
-	DOManifest      = "./testdata/machinedeployment-digitalocean.yaml"
-	AWSManifest     = "./testdata/machinedeployment-aws.yaml"
-	AzureManifest   = "./testdata/machinedeployment-azure.yaml"
-	GCEManifest     = "./testdata/machinedeployment-gce.yaml"
-	HZManifest      = "./testdata/machinedeployment-hetzner.yaml"
-	PacketManifest  = "./testdata/machinedeployment-packet.yaml"
-	LinodeManifest  = "./testdata/machinedeployment-linode.yaml"
-	VSPhereManifest = "./testdata/machinedeployment-vsphere.yaml"
+	DOManifest        = "./testdata/machinedeployment-digitalocean.yaml"
+	AWSManifest       = "./testdata/machinedeployment-aws.yaml"
+	AzureManifest     = "./testdata/machinedeployment-azure.yaml"
+	AzureZoneManifest = "./testdata/machinedeployment-azure-zone.yaml"
+	GCEManifest       = "./testdata/machinedeployment-gce.yaml"
+	HZManifest        = "./testdata/machinedeployment-hetzner.yaml"
+	HZMigrateManifest = "./testdata/machinedeployment-hetzner-migrate.yaml"
+	PacketManifest    = "./testdata/machinedeployment-packet.yaml"
+	LinodeManifest    = "./testdata/machinedeployment-linode.yaml"
+	VSPhereManifest   = "./testdata/machinedeployment-vsphere.yaml"
 	//	vssip_manifest         = "./testdata/machinedeployment-vsphere-static-ip.yaml"
 	OSManifest             = "./testdata/machinedeployment-openstack.yaml"
 	OSUpgradeManifest      = "./testdata/machinedeployment-openstack-upgrade.yml"
+	OSStorageNetManifest   = "./testdata/machinedeployment-openstack-storage-network.yaml"
 	invalidMachineManifest = "./testdata/machine-invalid.yaml"
 	kubevirtManifest       = "./testdata/machinedeployment-kubevirt.yaml"
 )
@@ -379,13 +385,395 @@ func TestDeploymentControllerUpgradesMachineE2E(t *testing.T) {
 
 	// act
 	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+	// Hetzner instances usually join within a few minutes, the budgets fail the test early when a phase hangs
+	budgets := map[string]time.Duration{
+		phaseCreation:     10 * time.Minute,
+		phaseNewNodeReady: 10 * time.Minute,
+	}
 
 	scenario := scenario{
 		name:              "MachineDeployment upgrade",
 		osName:            "ubuntu",
 		containerRuntime:  "docker",
 		kubernetesVersion: "1.10.5",
-		executor:          verifyCreateUpdateAndDelete,
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			timing, err := verifyCreateUpdateAndDelete(kubeConfig, manifestPath, parameters, budgets, ScenarioOptions{}, timeout)
+			if timing != nil {
+				t.Logf("node of the new MachineSet got ready after %s", timing.Duration(phaseNewNodeReady))
+			}
+			return err
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestDeploymentControllerRollsBackMachineE2E verifies the machineDeployment controller reuses the original
+// machineSet when the template of a machineDeployment gets rolled back
+func TestDeploymentControllerRollsBackMachineE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment rollback",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyUpdateAndRollback(kubeConfig, manifestPath, parameters, ScenarioOptions{}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestDeploymentControllerScalesMachinesE2E verifies the machineDeployment controller converges to
+// the desired amount of machines when scaling a machineDeployment up and down
+func TestDeploymentControllerScalesMachinesE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment scaling",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyCreateScaleAndDelete(kubeConfig, manifestPath, parameters, 2, 3, ScenarioOptions{}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestDeploymentControllerDrainsNodeOnDeleteE2E verifies the node of a machine gets drained with respect to
+// PodDisruptionBudgets before its instance gets deleted
+func TestDeploymentControllerDrainsNodeOnDeleteE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment drain on delete",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyDrainOnDelete(kubeConfig, manifestPath, parameters, ScenarioOptions{}, timeout)
+		},
 	}
 	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
 }
+
+// TestDeploymentControllerMigratesMachinesE2E verifies a MachineDeployment can be moved to another instance type
+// and location, with a ready node from the new config existing before the old instance gets deleted
+func TestDeploymentControllerMigratesMachinesE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment migration",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyMigrate(kubeConfig, manifestPath, HZMigrateManifest, parameters, ScenarioOptions{PollInterval: migrationCheckPeriod}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestDeploymentControllerReapplyIsNoOpE2E verifies that re-applying an unchanged MachineDeployment does not replace its machines
+func TestDeploymentControllerReapplyIsNoOpE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment reapply",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyReapplyIsNoOp(kubeConfig, manifestPath, parameters, ScenarioOptions{}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestMachineDeploymentDefaultingE2E verifies that the webhook defaults under-specified MachineDeployments
+func TestMachineDeploymentDefaultingE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment defaulting",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor:          verifyDefaulting,
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestKubeletVersionUpgradeE2E verifies that the node replacing a machine after a kubelet version upgrade of its
+// MachineDeployment reports the new version
+func TestKubeletVersionUpgradeE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment kubelet upgrade",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.13.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifyKubeletVersionUpgrade(kubeConfig, manifestPath, parameters, ScenarioOptions{}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestUserDataAppliedE2E verifies that the files and systemd units of the userdata exist on the node
+func TestUserDataAppliedE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment userdata",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: verifyUserDataApplied([]nodeAssertion{
+			{path: "/etc/systemd/journald.conf.d/max_disk_use.conf", content: userdatahelper.JournalDConfig()},
+			{unit: "kubelet.service"},
+			{unit: "docker.service"},
+		}, ScenarioOptions{}),
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestOpenstackStorageNetworkE2E creates an instance with an additional NIC in the storage network and checks
+// that the userdata configured it before the kubelet started
+func TestOpenstackStorageNetworkE2E(t *testing.T) {
+	t.Parallel()
+
+	osAuthURL := os.Getenv("OS_AUTH_URL")
+	osDomain := os.Getenv("OS_DOMAIN")
+	osPassword := os.Getenv("OS_PASSWORD")
+	osRegion := os.Getenv("OS_REGION")
+	osUsername := os.Getenv("OS_USERNAME")
+	osTenant := os.Getenv("OS_TENANT_NAME")
+	osNetwork := os.Getenv("OS_NETWORK_NAME")
+	osStorageNetwork := os.Getenv("OS_STORAGE_NETWORK_NAME")
+
+	if osAuthURL == "" || osUsername == "" || osPassword == "" || osDomain == "" || osRegion == "" || osTenant == "" || osStorageNetwork == "" {
+		t.Fatal("unable to run test, all of OS_AUTH_URL, OS_USERNAME, OS_PASSOWRD, OS_REGION, OS_TENANT, OS_DOMAIN and OS_STORAGE_NETWORK_NAME must be set!")
+	}
+
+	params := []string{
+		fmt.Sprintf("<< IDENTITY_ENDPOINT >>=%s", osAuthURL),
+		fmt.Sprintf("<< USERNAME >>=%s", osUsername),
+		fmt.Sprintf("<< PASSWORD >>=%s", osPassword),
+		fmt.Sprintf("<< DOMAIN_NAME >>=%s", osDomain),
+		fmt.Sprintf("<< REGION >>=%s", osRegion),
+		fmt.Sprintf("<< TENANT_NAME >>=%s", osTenant),
+		fmt.Sprintf("<< NETWORK_NAME >>=%s", osNetwork),
+		fmt.Sprintf("<< STORAGE_NETWORK_NAME >>=%s", osStorageNetwork),
+	}
+	// ens4 is the second virtio NIC of the Ubuntu image, the manifest sets its MTU to 1400
+	scenario := scenario{
+		name:              "OpenStack storage network",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: verifyUserDataApplied([]nodeAssertion{
+			{path: "/sys/class/net/ens4/mtu", content: "1400\n"},
+			{path: "/sys/class/net/ens4/operstate", content: "up\n"},
+			{unit: "storage-interface.service"},
+		}, ScenarioOptions{}),
+	}
+
+	testScenario(t, scenario, *testRunIdentifier, params, OSStorageNetManifest, false)
+}
+
+// TestSelfHealingE2E verifies a machine gets replaced after its node failed out-of-band
+func TestSelfHealingE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+
+	// act
+	params := []string{fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken)}
+
+	scenario := scenario{
+		name:              "MachineDeployment self-healing",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor: func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+			return verifySelfHealing(kubeConfig, manifestPath, parameters, ScenarioOptions{}, timeout)
+		},
+	}
+	testScenario(t, scenario, *testRunIdentifier, params, HZManifest, false)
+}
+
+// TestZonePlacementE2E verifies all machines of a MachineDeployment get created in the zone of its provider spec
+func TestZonePlacementE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	awsKeyID := os.Getenv("AWS_E2E_TESTS_KEY_ID")
+	awsSecret := os.Getenv("AWS_E2E_TESTS_SECRET")
+	if len(awsKeyID) == 0 || len(awsSecret) == 0 {
+		t.Fatal("unable to run the test suite, AWS_E2E_TESTS_KEY_ID or AWS_E2E_TESTS_SECRET environment variables cannot be empty")
+	}
+	azureTenantID := os.Getenv("AZURE_E2E_TESTS_TENANT_ID")
+	azureSubscriptionID := os.Getenv("AZURE_E2E_TESTS_SUBSCRIPTION_ID")
+	azureClientID := os.Getenv("AZURE_E2E_TESTS_CLIENT_ID")
+	azureClientSecret := os.Getenv("AZURE_E2E_TESTS_CLIENT_SECRET")
+	if len(azureTenantID) == 0 || len(azureSubscriptionID) == 0 || len(azureClientID) == 0 || len(azureClientSecret) == 0 {
+		t.Fatal("unable to run the test suite, AZURE_TENANT_ID, AZURE_SUBSCRIPTION_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET environment variables cannot be empty")
+	}
+
+	// act
+	awsParams := []string{fmt.Sprintf("<< AWS_ACCESS_KEY_ID >>=%s", awsKeyID),
+		fmt.Sprintf("<< AWS_SECRET_ACCESS_KEY >>=%s", awsSecret),
+	}
+	azureParams := []string{
+		fmt.Sprintf("<< AZURE_TENANT_ID >>=%s", azureTenantID),
+		fmt.Sprintf("<< AZURE_SUBSCRIPTION_ID >>=%s", azureSubscriptionID),
+		fmt.Sprintf("<< AZURE_CLIENT_ID >>=%s", azureClientID),
+		fmt.Sprintf("<< AZURE_CLIENT_SECRET >>=%s", azureClientSecret),
+	}
+
+	awsScenario := scenario{
+		name:              "AWS zone placement",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor:          verifyZonePlacement("eu-central-1a", ScenarioOptions{}),
+	}
+	t.Run(awsScenario.name, func(t *testing.T) {
+		testScenario(t, awsScenario, fmt.Sprintf("aws-%s", *testRunIdentifier), awsParams, AWSManifest, true)
+	})
+
+	// Azure labels the nodes with the location and the zone
+	azureScenario := scenario{
+		name:              "Azure zone placement",
+		osName:            "ubuntu",
+		containerRuntime:  "docker",
+		kubernetesVersion: "1.10.5",
+		executor:          verifyZonePlacement("westeurope-2", ScenarioOptions{}),
+	}
+	t.Run(azureScenario.name, func(t *testing.T) {
+		testScenario(t, azureScenario, fmt.Sprintf("azure-%s", *testRunIdentifier), azureParams, AzureZoneManifest, true)
+	})
+}
+
+// TestConcurrentScenariosE2E runs MachineDeployment update scenarios of several operating systems at the same time
+func TestConcurrentScenariosE2E(t *testing.T) {
+	t.Parallel()
+
+	// test data
+	hzToken := os.Getenv("HZ_E2E_TOKEN")
+	if len(hzToken) == 0 {
+		t.Fatal("unable to run the test suite, HZ_E2E_TOKEN environment variable cannot be empty")
+	}
+	kubeConfig, err := e2eKubeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	var scenarios []Scenario
+	for _, osName := range []string{"ubuntu", "centos"} {
+		scenarios = append(scenarios, Scenario{
+			Name:         fmt.Sprintf("concurrent %s %s", osName, *testRunIdentifier),
+			ManifestPath: HZManifest,
+			Parameters: []string{
+				fmt.Sprintf("<< HETZNER_TOKEN >>=%s", hzToken),
+				fmt.Sprintf("<< OS_NAME >>=%s", osName),
+				"<< KUBERNETES_VERSION >>=1.10.5",
+				fmt.Sprintf("<< YOUR_PUBLIC_KEY >>=%s", os.Getenv("E2E_SSH_PUBKEY")),
+			},
+			Verify: createUpdateAndDeleteScenario(nil, ScenarioOptions{}),
+		})
+	}
+	results, err := RunScenarios(scenarios, kubeConfig, 2, 25*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to run scenarios: %v", err)
+	}
+
+	// assert
+	for _, result := range results {
+		if !result.Passed {
+			t.Errorf("scenario %q failed after %s: %v", result.Name, result.Duration, result.Err)
+			continue
+		}
+		t.Logf("scenario %q passed after %s", result.Name, result.Duration)
+	}
+}
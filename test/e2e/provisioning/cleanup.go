@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// machineDeletionGracePeriodEnv overrides the time cleanupMachineDeployment waits for machines to get deleted,
+	// e.g. "15m", before it removes their finalizers
+	machineDeletionGracePeriodEnv     = "E2E_MACHINE_DELETION_GRACE_PERIOD"
+	defaultMachineDeletionGracePeriod = 10 * time.Minute
+)
+
+// cleanupMachineDeployment deletes the MachineDeployment and waits until it and all of its MachineSets and machines
+// are gone. It gets deferred by the scenarios, so nothing is left running at the cloud provider if a scenario fails
+// partway through. A MachineDeployment which was never created or got deleted already is not an error.
+//
+// Machines which are still being deleted after the grace period get their finalizers removed. Their instances may be
+// left behind in this case, which gets logged. Errors are only logged, so they never mask the error of the scenario
+func cleanupMachineDeployment(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) {
+	current := &clusterv1alpha1.MachineDeployment{}
+	nn := types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}
+	if err := getObject(client, nn, current); err != nil {
+		if !kerrors.IsNotFound(err) {
+			glog.Errorf("Failed to clean up MachineDeployment %s: failed to get it: %v", nn.Name, err)
+		}
+		return
+	}
+
+	glog.Infof("Cleaning up MachineDeployment %s", nn.Name)
+	if current.DeletionTimestamp == nil {
+		// Foreground deletion keeps the MachineDeployment until its MachineSets and machines are gone
+		if err := retryOnTransient(func() error {
+			return client.Delete(context.Background(), current, ctrlruntimeclient.PropagationPolicy(metav1.DeletePropagationForeground))
+		}, transientRetryAttempts, transientRetryBackoff); err != nil && !kerrors.IsNotFound(err) {
+			glog.Errorf("Failed to clean up MachineDeployment %s: failed to delete it: %v", nn.Name, err)
+			return
+		}
+	}
+
+	gracePeriod := machineDeletionGracePeriod()
+	start := time.Now()
+	if err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		// current keeps the UID of the deleted MachineDeployment, so its MachineSets and machines still match
+		machineSets, err := getMachingMachineSets(current, client)
+		if err != nil {
+			glog.V(2).Infof("Failed to list MachineSets of MachineDeployment %s during cleanup: %v", nn.Name, err)
+			return false, nil
+		}
+		machines, err := getMatchingMachines(current, client)
+		if err != nil {
+			glog.V(2).Infof("Failed to list machines of MachineDeployment %s during cleanup: %v", nn.Name, err)
+			return false, nil
+		}
+		if time.Since(start) > gracePeriod {
+			for i := range machines {
+				forceDeleteMachine(&machines[i], client)
+			}
+		}
+		if len(machineSets) != 0 || len(machines) != 0 {
+			return false, nil
+		}
+		err = client.Get(context.Background(), nn, &clusterv1alpha1.MachineDeployment{})
+		return kerrors.IsNotFound(err), nil
+	}); err != nil {
+		glog.Errorf("Failed to clean up MachineDeployment %s: its MachineSets and machines did not get deleted: %v", nn.Name, err)
+		return
+	}
+	glog.Infof("Cleaned up MachineDeployment %s", nn.Name)
+}
+
+// forceDeleteMachine removes the finalizers of a machine which is being deleted, so it gets deleted without its
+// instance and node getting cleaned up
+func forceDeleteMachine(machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) {
+	if machine.DeletionTimestamp == nil || len(machine.Finalizers) == 0 {
+		return
+	}
+	glog.Errorf("Machine %s is still being deleted after the grace period, removing its finalizers %v. Its instance may be left behind at the cloud provider", machine.Name, machine.Finalizers)
+	machine.Finalizers = nil
+	if err := client.Update(context.Background(), machine); err != nil && !kerrors.IsNotFound(err) {
+		glog.Errorf("Failed to remove the finalizers of machine %s: %v", machine.Name, err)
+	}
+}
+
+// machineDeletionGracePeriod returns the grace period from the environment or the default if it is unset or invalid
+func machineDeletionGracePeriod() time.Duration {
+	value := os.Getenv(machineDeletionGracePeriodEnv)
+	if value == "" {
+		return defaultMachineDeletionGracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil {
+		glog.Errorf("Invalid %s %q, using the default of %v: %v", machineDeletionGracePeriodEnv, value, defaultMachineDeletionGracePeriod, err)
+		return defaultMachineDeletionGracePeriod
+	}
+	return gracePeriod
+}
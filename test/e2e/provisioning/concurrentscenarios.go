@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxScenarioPrefixLength keeps the names of the machines and nodes derived from the prefix below the length limits
+const maxScenarioPrefixLength = 40
+
+var invalidNameCharsRegexp = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Scenario is a MachineDeployment scenario which RunScenarios can run concurrently with other scenarios
+type Scenario struct {
+	// Name identifies the scenario in the results
+	Name string
+	// ManifestPath is the path of the MachineDeployment manifest
+	ManifestPath string
+	// Parameters replace the placeholders of the manifest. RunScenarios sets << MACHINE_NAME >>
+	Parameters []string
+	// Verify runs the scenario for the given MachineDeployment, which is not created yet
+	Verify func(client ctrlruntimeclient.Client, machineDeployment *clusterv1alpha1.MachineDeployment, timeout time.Duration) error
+}
+
+// ScenarioResult is the outcome of a scenario run by RunScenarios
+type ScenarioResult struct {
+	Name     string
+	Passed   bool
+	Err      error
+	Duration time.Duration
+}
+
+// createUpdateAndDeleteScenario returns the Verify function of a scenario which runs verifyCreateUpdateAndDelete
+func createUpdateAndDeleteScenario(budgets map[string]time.Duration, opts ScenarioOptions) func(ctrlruntimeclient.Client, *clusterv1alpha1.MachineDeployment, time.Duration) error {
+	return func(client ctrlruntimeclient.Client, machineDeployment *clusterv1alpha1.MachineDeployment, timeout time.Duration) error {
+		_, err := createUpdateAndDelete(client, machineDeployment, budgets, opts, timeout)
+		return err
+	}
+}
+
+// RunScenarios runs the given scenarios against the cluster of the kubeconfig, at most maxParallel at the same time.
+// Each scenario gets its own namespace and name prefix, so the machines of concurrent scenarios never get mixed up.
+// The results are in the order of the scenarios, an error is only returned if no scenario could be run.
+func RunScenarios(scenarios []Scenario, kubeConfig string, maxParallel int, timeout time.Duration) ([]ScenarioResult, error) {
+	if maxParallel < 1 {
+		return nil, fmt.Errorf("maxParallel must be at least 1, got %d", maxParallel)
+	}
+	client, err := newClient(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ScenarioResult, len(scenarios))
+	semaphore := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i := range scenarios {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			scenario := scenarios[i]
+			start := time.Now()
+			err := runIsolatedScenario(client, scenario, scenarioPrefix(i, scenario.Name), timeout)
+			results[i] = ScenarioResult{Name: scenario.Name, Passed: err == nil, Err: err, Duration: time.Since(start)}
+			if err != nil {
+				glog.Errorf("Scenario %q failed after %s: %v", scenario.Name, results[i].Duration, err)
+			} else {
+				glog.Infof("Scenario %q passed after %s", scenario.Name, results[i].Duration)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// runIsolatedScenario runs the scenario in a namespace of its own, which gets deleted afterwards
+func runIsolatedScenario(client ctrlruntimeclient.Client, scenario Scenario, prefix string, timeout time.Duration) error {
+	// The first replacement of a placeholder wins
+	parameters := append([]string{fmt.Sprintf("<< MACHINE_NAME >>=%s", prefix)}, scenario.Parameters...)
+	machineDeployment, err := parseMachineDeployment(scenario.ManifestPath, parameters)
+	if err != nil {
+		return fmt.Errorf("failed to prepare MachineDeployment: %v", err)
+	}
+	machineDeployment.Namespace = prefix
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: prefix}}
+	if err := client.Create(context.Background(), namespace); err != nil {
+		return fmt.Errorf("failed to create namespace %s: %v", prefix, err)
+	}
+	defer func() {
+		if err := client.Delete(context.Background(), namespace); err != nil {
+			glog.Errorf("Failed to delete namespace %s of scenario %q: %v", prefix, scenario.Name, err)
+		}
+	}()
+
+	return scenario.Verify(client, machineDeployment, timeout)
+}
+
+// scenarioPrefix returns the namespace and name prefix of the scenario with the given index
+func scenarioPrefix(index int, name string) string {
+	prefix := fmt.Sprintf("e2e-%d-%s", index, invalidNameCharsRegexp.ReplaceAllString(strings.ToLower(name), "-"))
+	if len(prefix) > maxScenarioPrefixLength {
+		prefix = prefix[:maxScenarioPrefixLength]
+	}
+	return strings.TrimRight(prefix, "-")
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// verifyDefaulting creates a MachineDeployment from the manifest without any of the fields the mutating webhook
+// defaults and verifies they got populated, while the selector and the providerSpec were kept. The MachineDeployment
+// is paused, so it creates no machines. If none of the defaults got populated, the webhook is considered disabled
+// and the scenario gets skipped
+func verifyDefaulting(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, ScenarioOptions{}, timeout)
+	submittedProviderConfig, err := providerconfig.GetConfig(machineDeployment.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse the providerSpec of %s: %v", manifestPath, err)
+	}
+	submittedCloudProviderSpec, err := cloudProviderSpec(machineDeployment.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse the providerSpec of %s: %v", manifestPath, err)
+	}
+	submittedSelector := machineDeployment.Spec.Selector.DeepCopy()
+
+	machineDeployment.Spec.Paused = true
+	machineDeployment.Spec.Replicas = nil
+	machineDeployment.Spec.MinReadySeconds = nil
+	machineDeployment.Spec.RevisionHistoryLimit = nil
+	machineDeployment.Spec.ProgressDeadlineSeconds = nil
+	machineDeployment.Spec.Strategy = nil
+
+	glog.Infof("creating the under-specified MachineDeployment %s", machineDeployment.Name)
+	if err := client.Create(context.Background(), machineDeployment); err != nil {
+		return fmt.Errorf("failed to create the under-specified MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+
+	defaulted := &clusterv1alpha1.MachineDeployment{}
+	if err := client.Get(context.Background(), types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}, defaulted); err != nil {
+		return fmt.Errorf("failed to get MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+
+	var verifyErr error
+	if hasNoDefaults(defaulted) {
+		verifyErr = skipError{reason: fmt.Sprintf("MachineDeployment %s got no defaults, the mutating webhook seems to be disabled", defaulted.Name)}
+	} else {
+		mismatches := defaultingMismatches(defaulted)
+		if !reflect.DeepEqual(&defaulted.Spec.Selector, submittedSelector) {
+			mismatches = append(mismatches, fmt.Sprintf("selector is %v, expected the submitted %v", defaulted.Spec.Selector, *submittedSelector))
+		}
+		providerSpecMismatches, err := providerSpecMismatches(defaulted.Spec.Template.Spec.ProviderSpec, submittedProviderConfig, submittedCloudProviderSpec)
+		mismatches = append(mismatches, providerSpecMismatches...)
+		if err != nil {
+			verifyErr = err
+		} else if len(mismatches) > 0 {
+			verifyErr = fmt.Errorf("MachineDeployment %s was not defaulted as expected: %s", defaulted.Name, strings.Join(mismatches, ", "))
+		}
+	}
+
+	if err := deleteAndAssure(defaulted, client, ScenarioOptions{}, timeout); err != nil {
+		return fmt.Errorf("failed to delete MachineDeployment %s: %v", defaulted.Name, err)
+	}
+	if verifyErr == nil {
+		glog.Infof("The webhook defaulted MachineDeployment %s as expected", defaulted.Name)
+	}
+	return verifyErr
+}
+
+// hasNoDefaults returns true if none of the fields the webhook defaults is set
+func hasNoDefaults(md *clusterv1alpha1.MachineDeployment) bool {
+	return md.Spec.Replicas == nil &&
+		md.Spec.MinReadySeconds == nil &&
+		md.Spec.RevisionHistoryLimit == nil &&
+		md.Spec.ProgressDeadlineSeconds == nil &&
+		md.Spec.Strategy == nil
+}
+
+// defaultingMismatches describes every field the webhook defaults which is missing or has a different value
+func defaultingMismatches(md *clusterv1alpha1.MachineDeployment) []string {
+	var mismatches []string
+	checkInt32 := func(field string, actual *int32, expected int32) {
+		if actual == nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not set, expected %d", field, expected))
+		} else if *actual != expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %d, expected %d", field, *actual, expected))
+		}
+	}
+	checkIntOrString := func(field string, actual *intstr.IntOrString, expected intstr.IntOrString) {
+		if actual == nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not set, expected %s", field, expected.String()))
+		} else if *actual != expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, expected %s", field, actual.String(), expected.String()))
+		}
+	}
+
+	checkInt32("replicas", md.Spec.Replicas, 1)
+	checkInt32("minReadySeconds", md.Spec.MinReadySeconds, 0)
+	checkInt32("revisionHistoryLimit", md.Spec.RevisionHistoryLimit, 1)
+	checkInt32("progressDeadlineSeconds", md.Spec.ProgressDeadlineSeconds, 600)
+
+	switch {
+	case md.Spec.Strategy == nil:
+		mismatches = append(mismatches, "strategy is not set")
+	case md.Spec.Strategy.Type != common.RollingUpdateMachineDeploymentStrategyType:
+		mismatches = append(mismatches, fmt.Sprintf("strategy type is %q, expected %q", md.Spec.Strategy.Type, common.RollingUpdateMachineDeploymentStrategyType))
+	case md.Spec.Strategy.RollingUpdate == nil:
+		mismatches = append(mismatches, "rollingUpdate strategy is not set")
+	default:
+		checkIntOrString("maxSurge", md.Spec.Strategy.RollingUpdate.MaxSurge, intstr.FromInt(1))
+		checkIntOrString("maxUnavailable", md.Spec.Strategy.RollingUpdate.MaxUnavailable, intstr.FromInt(0))
+	}
+	return mismatches
+}
+
+// providerSpecMismatches describes every submitted field of the providerSpec which the webhook dropped or changed.
+// Fields the cloud provider defaults may get added
+func providerSpecMismatches(providerSpec clusterv1alpha1.ProviderSpec, submittedConfig *providerconfig.Config, submittedCloudProviderSpec map[string]interface{}) ([]string, error) {
+	config, err := providerconfig.GetConfig(providerSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the defaulted providerSpec: %v", err)
+	}
+	spec, err := cloudProviderSpec(providerSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the defaulted providerSpec: %v", err)
+	}
+
+	var mismatches []string
+	if config.CloudProvider != submittedConfig.CloudProvider {
+		mismatches = append(mismatches, fmt.Sprintf("cloudProvider is %q, expected %q", config.CloudProvider, submittedConfig.CloudProvider))
+	}
+	if config.OperatingSystem != submittedConfig.OperatingSystem {
+		mismatches = append(mismatches, fmt.Sprintf("operatingSystem is %q, expected %q", config.OperatingSystem, submittedConfig.OperatingSystem))
+	}
+	for key, value := range submittedCloudProviderSpec {
+		if actual, ok := spec[key]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("cloudProviderSpec.%s got dropped", key))
+		} else if !reflect.DeepEqual(actual, value) {
+			mismatches = append(mismatches, fmt.Sprintf("cloudProviderSpec.%s got changed", key))
+		}
+	}
+	return mismatches, nil
+}
@@ -17,8 +17,8 @@ limitations under the License.
 package provisioning
 
 import (
-	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -27,86 +27,408 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	dutil "sigs.k8s.io/cluster-api/pkg/controller/machinedeployment/util"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func verifyCreateUpdateAndDelete(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+// verifyCreateUpdateAndDelete verifies a MachineDeployment rolls over its machine on an update of its template.
+// The returned timing holds the durations of all phases which ran, also when the scenario failed. Phases which
+// exceed their budget fail the scenario
+func verifyCreateUpdateAndDelete(kubeConfig, manifestPath string, parameters []string, budgets map[string]time.Duration, opts ScenarioOptions, timeout time.Duration) (*ScenarioTiming, error) {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return nil, err
+	}
+	return createUpdateAndDelete(client, machineDeployment, budgets, opts, timeout)
+}
+
+// createUpdateAndDelete runs the scenario of verifyCreateUpdateAndDelete for the given, not yet created MachineDeployment
+func createUpdateAndDelete(client ctrlruntimeclient.Client, machineDeployment *clusterv1alpha1.MachineDeployment, budgets map[string]time.Duration, opts ScenarioOptions, timeout time.Duration) (*ScenarioTiming, error) {
+	var err error
+	singleReplicaDeployment(machineDeployment)
+
+	timing := newScenarioTiming(machineDeployment.Name, budgets)
+	defer timing.log()
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+
+	if err := timing.measure(phaseCreation, timeout, func(timeout time.Duration) error {
+		created, err := createAndAssure(machineDeployment, client, opts, timeout)
+		if err != nil {
+			return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
+		}
+		machineDeployment = created
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	var newestMachineSet, oldMachineSet clusterv1alpha1.MachineSet
+	if err := timing.measure(phaseNewMachineSet, timeout, func(timeout time.Duration) error {
+		if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+			md.Spec.Template.Labels["testUpdate"] = "true"
+			if md.Spec.Template.Spec.Labels == nil {
+				md.Spec.Template.Spec.Labels = map[string]string{}
+			}
+			md.Spec.Template.Spec.Labels["testUpdate"] = "true"
+			if md.Spec.Template.Spec.Annotations == nil {
+				md.Spec.Template.Spec.Annotations = map[string]string{}
+			}
+			md.Spec.Template.Spec.Annotations["testUpdate"] = "true"
+		}); err != nil {
+			return fmt.Errorf("failed to update MachineDeployment %s after modifying it: %v", machineDeployment.Name, err)
+		}
+
+		glog.Infof("Waiting for second MachineSet to appear after updating MachineDeployment %s", machineDeployment.Name)
+		var machineSets []clusterv1alpha1.MachineSet
+		if err := opts.poll(timeout, func() (bool, error) {
+			machineSets, err = getMachingMachineSets(machineDeployment, client)
+			if err != nil {
+				return false, err
+			}
+			if len(machineSets) != 2 {
+				return false, err
+			}
+			for _, machineSet := range machineSets {
+				if *machineSet.Spec.Replicas != int32(1) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Found second MachineSet for MachineDeployment %s!", machineDeployment.Name)
+
+		if machineSets[0].CreationTimestamp.Before(&machineSets[1].CreationTimestamp) {
+			newestMachineSet = machineSets[1]
+			oldMachineSet = machineSets[0]
+		} else {
+			newestMachineSet = machineSets[0]
+			oldMachineSet = machineSets[1]
+		}
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := timing.measure(phaseNewNodeReady, timeout, func(timeout time.Duration) error {
+		glog.Infof("Waiting for new MachineSets node to appear")
+		var machines []clusterv1alpha1.Machine
+		if err := opts.poll(timeout, func() (bool, error) {
+			machines, err = getMatchingMachinesForMachineset(&newestMachineSet, client)
+			if err != nil {
+				return false, err
+			}
+			if len(machines) != 1 {
+				return false, nil
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		glog.Infof("New MachineSet %s appeared with %v machines", newestMachineSet.Name, len(machines))
+
+		glog.Infof("Waiting for new MachineSet %s to get a ready node", newestMachineSet.Name)
+		if err := opts.poll(timeout, func() (bool, error) {
+			return hasMachineReadyNode(&machines[0], client)
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Found ready node for MachineSet %s", newestMachineSet.Name)
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := timing.measure(phaseOldMachineSetScaleDown, timeout, func(timeout time.Duration) error {
+		glog.Infof("Waiting for old MachineSet %s to be scaled down and have no associated machines",
+			oldMachineSet.Name)
+		if err := opts.poll(timeout, func() (bool, error) {
+			machineSet := &clusterv1alpha1.MachineSet{}
+			if err := getObject(client, types.NamespacedName{Namespace: oldMachineSet.Namespace, Name: oldMachineSet.Name}, machineSet); err != nil {
+				return false, err
+			}
+			if *machineSet.Spec.Replicas != int32(0) {
+				return false, nil
+			}
+			machines, err := getMatchingMachinesForMachineset(machineSet, client)
+			if err != nil {
+				return false, err
+			}
+			return len(machines) == 0, nil
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Old MachineSet %s got scaled down and has no associated machines anymore", oldMachineSet.Name)
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := timing.measure(phaseNodeMetadata, timeout, func(timeout time.Duration) error {
+		expected := map[string]string{"testUpdate": "true"}
+		return verifyNodeLabelsAndAnnotations(machineDeployment, client, expected, expected, opts, timeout)
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := timing.measure(phaseScaleToZero, timeout, func(timeout time.Duration) error {
+		glog.Infof("Setting replicas of MachineDeployment %s to 0 and waiting until it has no associated machines", machineDeployment.Name)
+		if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+			md.Spec.Replicas = getInt32Ptr(0)
+		}); err != nil {
+			return fmt.Errorf("failed to update replicas of MachineDeployment %s: %v", machineDeployment.Name, err)
+		}
+		glog.Infof("Successfully set replicas of MachineDeployment %s to 0", machineDeployment.Name)
+
+		glog.Infof("Waiting for MachineDeployment %s to not have any associated machines", machineDeployment.Name)
+		if err := opts.poll(timeout, func() (bool, error) {
+			machines, err := getMatchingMachines(machineDeployment, client)
+			return len(machines) == 0, err
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Successfully waited for MachineDeployment %s to not have any associated machines", machineDeployment.Name)
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := timing.measure(phaseDeletion, timeout, func(timeout time.Duration) error {
+		glog.Infof("Deleting MachineDeployment %s and waiting for it to disappear", machineDeployment.Name)
+		if err := deleteObject(client, machineDeployment); err != nil {
+			return fmt.Errorf("failed to delete MachineDeployment %s: %v", machineDeployment.Name, err)
+		}
+		if err := opts.poll(timeout, func() (bool, error) {
+			err := getObject(client, types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}, &clusterv1alpha1.MachineDeployment{})
+			if kerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Successfully deleted MachineDeployment %s!", machineDeployment.Name)
+		return nil
+	}); err != nil {
+		return timing, withMachineDiagnostics(err, machineDeployment, client)
+	}
+	return timing, nil
+}
+
+// verifyCreateScaleAndDelete creates a MachineDeployment with initialReplicas machines, scales it to scaledReplicas
+// and back and verifies each time that it converges to exactly the desired amount of machines, all with a ready node
+func verifyCreateScaleAndDelete(kubeConfig, manifestPath string, parameters []string, initialReplicas, scaledReplicas int32, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	machineDeployment.Spec.Replicas = getInt32Ptr(initialReplicas)
+
+	machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
+	}
+	if err := waitForReadyMachines(machineDeployment, client, initialReplicas, opts, timeout); err != nil {
+		return err
+	}
+
+	for _, replicas := range []int32{scaledReplicas, initialReplicas} {
+		glog.Infof("Scaling MachineDeployment %s to %d replicas", machineDeployment.Name, replicas)
+		if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+			md.Spec.Replicas = getInt32Ptr(replicas)
+		}); err != nil {
+			return fmt.Errorf("failed to update replicas of MachineDeployment %s: %v", machineDeployment.Name, err)
+		}
+		if err := waitForReadyMachines(machineDeployment, client, replicas, opts, timeout); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	glog.Infof("Successfully finished scaling test for MachineDeployment %s", machineDeployment.Name)
+	return nil
+}
+
+// waitForReadyMachines waits until the MachineDeployment has exactly the given amount of machines and all of them
+// have a ready node. Machines which are being deleted count as well, they must be gone before the rollout converged
+func waitForReadyMachines(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, replicas int32, opts ScenarioOptions, timeout time.Duration) error {
+	glog.Infof("Waiting for MachineDeployment %s to have %d machines with ready nodes", machineDeployment.Name, replicas)
+	var machineNames, notReady []string
+	if err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+		machineNames, notReady = nil, nil
+		for i := range machines {
+			machineNames = append(machineNames, machines[i].Name)
+			ready, err := hasMachineReadyNode(&machines[i], client)
+			if err != nil {
+				return false, err
+			}
+			if !ready || machines[i].DeletionTimestamp != nil {
+				notReady = append(notReady, machines[i].Name)
+			}
+		}
+		return len(machines) == int(replicas) && len(notReady) == 0, nil
+	}); err != nil {
+		return fmt.Errorf("MachineDeployment %s did not converge to %d machines with ready nodes: %v. It has the %d machines %v, of which %v have no ready node or are being deleted",
+			machineDeployment.Name, replicas, err, len(machineNames), machineNames, notReady)
+	}
+	glog.Infof("MachineDeployment %s has %d machines with ready nodes", machineDeployment.Name, replicas)
+	return nil
+}
+
+// verifyUpdateAndRollback updates the template of a MachineDeployment and reverts it afterwards. The rollback must
+// reuse the original MachineSet instead of creating a new one and scale down the intermediate MachineSet. During the
+// whole rollout the MachineDeployment must never have more than replicas+maxSurge machines
+func verifyUpdateAndRollback(kubeConfig, manifestPath string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
 
 	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
 	if err != nil {
 		return err
 	}
-	// This test inherently relies on replicas being one so we enforce that
-	machineDeployment.Spec.Replicas = getInt32Ptr(1)
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	singleReplicaDeployment(machineDeployment)
 
-	machineDeployment, err = createAndAssure(machineDeployment, client, timeout)
+	machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
 	if err != nil {
 		return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
 	}
 
+	machineSets, err := getMachingMachineSets(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	if len(machineSets) != 1 {
+		return fmt.Errorf("expected MachineDeployment %s to have exactly one MachineSet, got %d", machineDeployment.Name, len(machineSets))
+	}
+	originalMachineSet := machineSets[0]
+	originalTemplate := machineDeployment.Spec.Template.DeepCopy()
+
+	maxMachines := int(*machineDeployment.Spec.Replicas + dutil.MaxSurge(*machineDeployment))
+	surgeWatcher := newMachineSurgeWatcher(machineDeployment, client, maxMachines)
+	go surgeWatcher.run()
+	defer surgeWatcher.stop()
+
 	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
-		md.Spec.Template.Labels["testUpdate"] = "true"
+		md.Spec.Template.Labels["testRollback"] = "true"
 	}); err != nil {
 		return fmt.Errorf("failed to update MachineDeployment %s after modifying it: %v", machineDeployment.Name, err)
 	}
 
-	glog.Infof("Waiting for second MachineSet to appear after updating MachineDeployment %s", machineDeployment.Name)
-	var machineSets []clusterv1alpha1.MachineSet
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
-		machineSets, err = getMachingMachineSets(machineDeployment, client)
+	glog.Infof("Waiting for intermediate MachineSet to appear after updating MachineDeployment %s", machineDeployment.Name)
+	var intermediateMachineSet clusterv1alpha1.MachineSet
+	if err := opts.poll(timeout, func() (bool, error) {
+		machineSets, err := getMachingMachineSets(machineDeployment, client)
 		if err != nil {
 			return false, err
 		}
-		if len(machineSets) != 2 {
-			return false, err
-		}
 		for _, machineSet := range machineSets {
-			if *machineSet.Spec.Replicas != int32(1) {
-				return false, nil
+			if machineSet.UID != originalMachineSet.UID {
+				intermediateMachineSet = machineSet
+				return true, nil
 			}
 		}
-		return true, nil
+		return false, nil
 	}); err != nil {
+		return fmt.Errorf("failed waiting for intermediate MachineSet of MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+	glog.Infof("Found intermediate MachineSet %s for MachineDeployment %s", intermediateMachineSet.Name, machineDeployment.Name)
+
+	if err := waitForMachineSetRollout(&intermediateMachineSet, &originalMachineSet, client, opts, timeout); err != nil {
 		return err
 	}
-	glog.Infof("Found second MachineSet for MachineDeployment %s!", machineDeployment.Name)
 
-	glog.Infof("Waiting for new MachineSets node to appear")
-	var newestMachineSet, oldMachineSet clusterv1alpha1.MachineSet
-	if machineSets[0].CreationTimestamp.Before(&machineSets[1].CreationTimestamp) {
-		newestMachineSet = machineSets[1]
-		oldMachineSet = machineSets[0]
-	} else {
-		newestMachineSet = machineSets[0]
-		oldMachineSet = machineSets[1]
-	}
-	var machines []clusterv1alpha1.Machine
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
-		machines, err = getMatchingMachinesForMachineset(&newestMachineSet, client)
+	glog.Infof("Rolling back the template of MachineDeployment %s", machineDeployment.Name)
+	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+		md.Spec.Template = *originalTemplate.DeepCopy()
+	}); err != nil {
+		return fmt.Errorf("failed to roll back MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+
+	glog.Infof("Waiting for original MachineSet %s to get scaled up again", originalMachineSet.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
+		machineSets, err := getMachingMachineSets(machineDeployment, client)
 		if err != nil {
 			return false, err
 		}
-		if len(machines) != 1 {
-			return false, nil
+		var reused bool
+		for _, machineSet := range machineSets {
+			switch machineSet.UID {
+			case originalMachineSet.UID:
+				// The hash of the template must still match the original one, otherwise the MachineSet got adopted
+				// for a different template
+				if machineSet.Labels[dutil.DefaultMachineDeploymentUniqueLabelKey] != originalMachineSet.Labels[dutil.DefaultMachineDeploymentUniqueLabelKey] {
+					return false, fmt.Errorf("template hash of original MachineSet %s changed from %q to %q", machineSet.Name,
+						originalMachineSet.Labels[dutil.DefaultMachineDeploymentUniqueLabelKey], machineSet.Labels[dutil.DefaultMachineDeploymentUniqueLabelKey])
+				}
+				reused = *machineSet.Spec.Replicas == *machineDeployment.Spec.Replicas
+			case intermediateMachineSet.UID:
+			default:
+				return false, fmt.Errorf("rollback of MachineDeployment %s created the new MachineSet %s instead of reusing %s",
+					machineDeployment.Name, machineSet.Name, originalMachineSet.Name)
+			}
 		}
-		return true, nil
+		return reused, nil
 	}); err != nil {
+		return fmt.Errorf("failed waiting for original MachineSet %s to get reused: %v", originalMachineSet.Name, err)
+	}
+	glog.Infof("Original MachineSet %s got reused for the rollback", originalMachineSet.Name)
+
+	if err := waitForMachineSetRollout(&originalMachineSet, &intermediateMachineSet, client, opts, timeout); err != nil {
 		return err
 	}
-	glog.Infof("New MachineSet %s appeared with %v machines", newestMachineSet.Name, len(machines))
 
-	glog.Infof("Waiting for new MachineSet %s to get a ready node", newestMachineSet.Name)
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
-		return hasMachineReadyNode(&machines[0], client)
-	}); err != nil {
+	surgeWatcher.stop()
+	if err := surgeWatcher.err(); err != nil {
 		return err
 	}
-	glog.Infof("Found ready node for MachineSet %s", newestMachineSet.Name)
 
-	glog.Infof("Waiting for old MachineSet %s to be scaled down and have no associated machines",
-		oldMachineSet.Name)
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	glog.Infof("Successfully finished rollback test for MachineDeployment %s", machineDeployment.Name)
+	return nil
+}
+
+// waitForMachineSetRollout waits until the machines of the new MachineSet have ready nodes and the old MachineSet
+// got scaled down and has no machines anymore
+func waitForMachineSetRollout(newMachineSet, oldMachineSet *clusterv1alpha1.MachineSet, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) error {
+	glog.Infof("Waiting for MachineSet %s to get ready nodes", newMachineSet.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
+		machineSet := &clusterv1alpha1.MachineSet{}
+		if err := getObject(client, types.NamespacedName{Namespace: newMachineSet.Namespace, Name: newMachineSet.Name}, machineSet); err != nil {
+			return false, err
+		}
+		machines, err := getMatchingMachinesForMachineset(machineSet, client)
+		if err != nil {
+			return false, err
+		}
+		if len(machines) != int(*machineSet.Spec.Replicas) {
+			return false, nil
+		}
+		for i := range machines {
+			if ready, err := hasMachineReadyNode(&machines[i], client); err != nil || !ready {
+				return false, err
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for MachineSet %s to get ready nodes: %v", newMachineSet.Name, err)
+	}
+	glog.Infof("Found ready nodes for MachineSet %s", newMachineSet.Name)
+
+	glog.Infof("Waiting for MachineSet %s to be scaled down and have no associated machines", oldMachineSet.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
 		machineSet := &clusterv1alpha1.MachineSet{}
-		if err := client.Get(context.Background(), types.NamespacedName{Namespace: oldMachineSet.Namespace, Name: oldMachineSet.Name}, machineSet); err != nil {
+		if err := getObject(client, types.NamespacedName{Namespace: oldMachineSet.Namespace, Name: oldMachineSet.Name}, machineSet); err != nil {
 			return false, err
 		}
 		if *machineSet.Spec.Replicas != int32(0) {
@@ -118,40 +440,59 @@ func verifyCreateUpdateAndDelete(kubeConfig, manifestPath string, parameters []s
 		}
 		return len(machines) == 0, nil
 	}); err != nil {
-		return err
+		return fmt.Errorf("failed waiting for MachineSet %s to be scaled down: %v", oldMachineSet.Name, err)
 	}
-	glog.Infof("Old MachineSet %s got scaled down and has no associated machines anymore", oldMachineSet.Name)
+	glog.Infof("MachineSet %s got scaled down and has no associated machines anymore", oldMachineSet.Name)
+	return nil
+}
 
-	glog.Infof("Setting replicas of MachineDeployment %s to 0 and waiting until it has no associated machines", machineDeployment.Name)
-	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
-		md.Spec.Replicas = getInt32Ptr(0)
-	}); err != nil {
-		return fmt.Errorf("failed to update replicas of MachineDeployment %s: %v", machineDeployment.Name, err)
-	}
-	glog.Infof("Successfully set replicas of MachineDeployment %s to 0", machineDeployment.Name)
+// machineSurgeWatcher periodically counts the machines of a MachineDeployment, including the ones being deleted,
+// and remembers if there were ever more than allowed
+type machineSurgeWatcher struct {
+	machineDeployment *clusterv1alpha1.MachineDeployment
+	client            ctrlruntimeclient.Client
+	maxMachines       int
 
-	glog.Infof("Waiting for MachineDeployment %s to not have any associated machines", machineDeployment.Name)
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
-		machines, err := getMatchingMachines(machineDeployment, client)
-		return len(machines) == 0, err
-	}); err != nil {
-		return err
-	}
-	glog.Infof("Successfully waited for MachineDeployment %s to not have any associated machines", machineDeployment.Name)
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	lock     sync.Mutex
+	surgeErr error
+}
 
-	glog.Infof("Deleting MachineDeployment %s and waiting for it to disappear", machineDeployment.Name)
-	if err := client.Delete(context.Background(), machineDeployment); err != nil {
-		return fmt.Errorf("failed to delete MachineDeployment %s: %v", machineDeployment.Name, err)
+func newMachineSurgeWatcher(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, maxMachines int) *machineSurgeWatcher {
+	return &machineSurgeWatcher{
+		machineDeployment: machineDeployment.DeepCopy(),
+		client:            client,
+		maxMachines:       maxMachines,
+		stopCh:            make(chan struct{}),
 	}
-	if err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
-		err := client.Get(context.Background(), types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}, &clusterv1alpha1.MachineDeployment{})
-		if kerrors.IsNotFound(err) {
-			return true, nil
+}
+
+func (w *machineSurgeWatcher) run() {
+	wait.Until(func() {
+		machines, err := getMatchingMachines(w.machineDeployment, w.client)
+		if err != nil {
+			glog.Errorf("Failed to count the machines of MachineDeployment %s: %v", w.machineDeployment.Name, err)
+			return
 		}
-		return false, err
-	}); err != nil {
-		return err
-	}
-	glog.Infof("Successfully deleted MachineDeployment %s!", machineDeployment.Name)
-	return nil
+		if len(machines) <= w.maxMachines {
+			return
+		}
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if w.surgeErr == nil {
+			w.surgeErr = fmt.Errorf("MachineDeployment %s had %d machines at once, expected at most %d", w.machineDeployment.Name, len(machines), w.maxMachines)
+		}
+	}, 2*time.Second, w.stopCh)
+}
+
+func (w *machineSurgeWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+func (w *machineSurgeWatcher) err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.surgeErr
 }
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxDiagnosticEvents is the amount of the most recent events which get included in the diagnostics
+	maxDiagnosticEvents = 25
+	// maxDiagnosticMessageLength truncates event and status messages, cloud provider errors can be quite long
+	maxDiagnosticMessageLength = 300
+
+	redacted = "<redacted>"
+)
+
+// credentialKeyRegexp matches the keys of cloud provider spec fields which contain credentials
+var credentialKeyRegexp = regexp.MustCompile(`(?i)(token|password|secret|privatekey|accesskey|apikey|credentials|kubeconfig)`)
+
+// withMachineDiagnostics appends the diagnostics of the MachineDeployment to the given error. nil is returned for a
+// nil error
+func withMachineDiagnostics(err error, machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%v\n%s", err, collectMachineDiagnostics(machineDeployment, client))
+}
+
+// collectMachineDiagnostics returns a dump of the MachineSets, machines and their events of the MachineDeployment, so a
+// failed scenario can be debugged without access to the cluster. Credentials from the cloud provider spec of the
+// MachineDeployment get redacted, they may show up in error messages of the cloud provider.
+func collectMachineDiagnostics(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diagnostics of MachineDeployment %s/%s:\n", machineDeployment.Namespace, machineDeployment.Name)
+
+	involvedObjects := sets.NewString("MachineDeployment/" + machineDeployment.Name)
+	machineSets, err := getMachingMachineSets(machineDeployment, client)
+	if err != nil {
+		fmt.Fprintf(&b, "  failed to get MachineSets: %v\n", err)
+	}
+	for _, machineSet := range machineSets {
+		involvedObjects.Insert("MachineSet/" + machineSet.Name)
+		var replicas int32
+		if machineSet.Spec.Replicas != nil {
+			replicas = *machineSet.Spec.Replicas
+		}
+		fmt.Fprintf(&b, "  MachineSet %s: replicas=%d current=%d ready=%d available=%d\n", machineSet.Name, replicas,
+			machineSet.Status.Replicas, machineSet.Status.ReadyReplicas, machineSet.Status.AvailableReplicas)
+
+		machines, err := getMatchingMachinesForMachineset(&machineSet, client)
+		if err != nil {
+			fmt.Fprintf(&b, "    failed to get machines: %v\n", err)
+			continue
+		}
+		for _, machine := range machines {
+			involvedObjects.Insert("Machine/" + machine.Name)
+			writeMachineDiagnostics(&b, &machine)
+		}
+	}
+
+	events := &corev1.EventList{}
+	if err := client.List(context.Background(), &ctrlruntimeclient.ListOptions{Namespace: machineDeployment.Namespace}, events); err != nil {
+		fmt.Fprintf(&b, "  failed to list events: %v\n", err)
+	} else {
+		writeEventDiagnostics(&b, events.Items, involvedObjects)
+	}
+
+	return redactCredentials(b.String(), machineDeployment)
+}
+
+func writeMachineDiagnostics(b *strings.Builder, machine *clusterv1alpha1.Machine) {
+	node := "<none>"
+	if machine.Status.NodeRef != nil {
+		node = machine.Status.NodeRef.Name
+	}
+	fmt.Fprintf(b, "    Machine %s: node=%s created=%s", machine.Name, node, machine.CreationTimestamp.UTC())
+	if machine.DeletionTimestamp != nil {
+		fmt.Fprintf(b, " deleted=%s", machine.DeletionTimestamp.UTC())
+	}
+	b.WriteString("\n")
+	if machine.Status.ErrorReason != nil || machine.Status.ErrorMessage != nil {
+		var reason, message string
+		if machine.Status.ErrorReason != nil {
+			reason = string(*machine.Status.ErrorReason)
+		}
+		if machine.Status.ErrorMessage != nil {
+			message = *machine.Status.ErrorMessage
+		}
+		fmt.Fprintf(b, "      error: %s: %s\n", reason, truncateMessage(message))
+	}
+
+	providerStatus, err := providerconfig.GetProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		fmt.Fprintf(b, "      failed to get provider status: %v\n", err)
+		return
+	}
+	for _, condition := range providerStatus.Conditions {
+		fmt.Fprintf(b, "      condition %s=%s: %s %s\n", condition.Type, condition.Status, condition.Reason, truncateMessage(condition.Message))
+	}
+	if providerError := providerStatus.LastProviderError; providerError != nil {
+		fmt.Fprintf(b, "      last provider error on %s: %s %s\n", providerError.Operation, providerError.Reason, truncateMessage(providerError.Message))
+	}
+}
+
+// writeEventDiagnostics writes the most recent events of the given objects, older ones get omitted
+func writeEventDiagnostics(b *strings.Builder, allEvents []corev1.Event, involvedObjects sets.String) {
+	var events []corev1.Event
+	for _, event := range allEvents {
+		if involvedObjects.Has(event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+
+	fmt.Fprintf(b, "  Events (%d):\n", len(events))
+	if omitted := len(events) - maxDiagnosticEvents; omitted > 0 {
+		fmt.Fprintf(b, "    ... %d older events omitted\n", omitted)
+		events = events[omitted:]
+	}
+	for _, event := range events {
+		fmt.Fprintf(b, "    %s %s %s/%s %s (x%d): %s\n", event.LastTimestamp.UTC(), event.Type,
+			event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, event.Count, truncateMessage(event.Message))
+	}
+}
+
+func truncateMessage(message string) string {
+	if len(message) <= maxDiagnosticMessageLength {
+		return message
+	}
+	return message[:maxDiagnosticMessageLength] + "...(truncated)"
+}
+
+// redactCredentials replaces the values of all credential fields of the cloud provider spec of the MachineDeployment
+func redactCredentials(dump string, machineDeployment *clusterv1alpha1.MachineDeployment) string {
+	providerSpec := machineDeployment.Spec.Template.Spec.ProviderSpec
+	if providerSpec.Value == nil {
+		return dump
+	}
+	config := providerconfig.Config{}
+	if err := json.Unmarshal(providerSpec.Value.Raw, &config); err != nil {
+		return dump
+	}
+	var spec interface{}
+	if err := json.Unmarshal(config.CloudProviderSpec.Raw, &spec); err != nil {
+		return dump
+	}
+
+	var credentials []string
+	collectCredentials(spec, false, &credentials)
+	for _, credential := range credentials {
+		dump = strings.Replace(dump, credential, redacted, -1)
+	}
+	return dump
+}
+
+// collectCredentials collects all string values below keys which match credentialKeyRegexp
+func collectCredentials(value interface{}, isCredential bool, credentials *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			collectCredentials(child, isCredential || credentialKeyRegexp.MatchString(key), credentials)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectCredentials(child, isCredential, credentials)
+		}
+	case string:
+		// Short values like "true" would redact unrelated parts of the dump
+		if isCredential && len(v) >= 8 {
+			*credentials = append(*credentials, v)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/node/eviction"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	drainTestPodImage = "k8s.gcr.io/pause:3.1"
+	// drainBlockedPeriod is how long the deletion must stay blocked while the PodDisruptionBudget forbids the eviction
+	drainBlockedPeriod = 2 * time.Minute
+)
+
+// verifyDrainOnDelete verifies the node of a machine gets cordoned and drained before its instance gets deleted.
+// A pod protected by a PodDisruptionBudget which allows no disruption runs on the node, the deletion must block
+// until the budget gets lifted and evict the pod afterwards, instead of the pod disappearing together with the node
+func verifyDrainOnDelete(kubeConfig, manifestPath string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	singleReplicaDeployment(machineDeployment)
+	// The node must get drained for this test
+	delete(machineDeployment.Spec.Template.Spec.Annotations, eviction.SkipEvictionAnnotationKey)
+
+	machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
+	}
+
+	machines, err := getMatchingMachines(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	if len(machines) != 1 {
+		return fmt.Errorf("expected MachineDeployment %s to have exactly one machine, got %d", machineDeployment.Name, len(machines))
+	}
+	machine := machines[0]
+	node, err := getReadyNodeForMachine(&machine, client)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("machine %s has no ready node", machine.Name)
+	}
+
+	pdb, pod := drainTestObjects(machineDeployment, node.Name)
+	defer func() {
+		for _, obj := range []runtime.Object{pdb, pod} {
+			if err := client.Delete(context.Background(), obj); err != nil && !kerrors.IsNotFound(err) {
+				glog.Errorf("Failed to clean up drain test object: %v", err)
+			}
+		}
+	}()
+	if err := client.Create(context.Background(), pdb); err != nil {
+		return fmt.Errorf("failed to create PodDisruptionBudget %s: %v", pdb.Name, err)
+	}
+	if err := client.Create(context.Background(), pod); err != nil {
+		return fmt.Errorf("failed to create pod %s: %v", pod.Name, err)
+	}
+
+	glog.Infof("Waiting for drain test pod %s to run on node %s", pod.Name, node.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
+		current := &corev1.Pod{}
+		if err := client.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, current); err != nil {
+			return false, err
+		}
+		return current.Status.Phase == corev1.PodRunning, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for pod %s to run: %v", pod.Name, err)
+	}
+	// The disruption controller must have observed the pod, otherwise the budget does not block the eviction yet
+	if err := opts.poll(timeout, func() (bool, error) {
+		current := &policyv1beta1.PodDisruptionBudget{}
+		if err := client.Get(context.Background(), types.NamespacedName{Namespace: pdb.Namespace, Name: pdb.Name}, current); err != nil {
+			return false, err
+		}
+		return current.Status.ObservedGeneration >= current.Generation && current.Status.CurrentHealthy == 1, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for PodDisruptionBudget %s to observe pod %s: %v", pdb.Name, pod.Name, err)
+	}
+
+	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+		md.Spec.Replicas = getInt32Ptr(0)
+	}); err != nil {
+		return fmt.Errorf("failed to scale MachineDeployment %s to zero: %v", machineDeployment.Name, err)
+	}
+
+	glog.Infof("Waiting for node %s to get cordoned", node.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
+		current := &corev1.Node{}
+		if err := client.Get(context.Background(), types.NamespacedName{Name: node.Name}, current); err != nil {
+			return false, err
+		}
+		return current.Spec.Unschedulable, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for node %s to get cordoned: %v", node.Name, err)
+	}
+
+	glog.Infof("Verifying the PodDisruptionBudget %s blocks the deletion of machine %s", pdb.Name, machine.Name)
+	if err := verifyDrainBlocked(&machine, pod, client, opts); err != nil {
+		return err
+	}
+
+	// Lifting the budget lets the eviction proceed
+	if err := client.Delete(context.Background(), pdb); err != nil {
+		return fmt.Errorf("failed to delete PodDisruptionBudget %s: %v", pdb.Name, err)
+	}
+
+	glog.Infof("Waiting for pod %s to get evicted from node %s", pod.Name, node.Name)
+	if err := opts.poll(timeout, func() (bool, error) {
+		podErr := client.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &corev1.Pod{})
+		if podErr != nil && !kerrors.IsNotFound(podErr) {
+			return false, podErr
+		}
+		nodeErr := client.Get(context.Background(), types.NamespacedName{Name: node.Name}, &corev1.Node{})
+		if nodeErr != nil && !kerrors.IsNotFound(nodeErr) {
+			return false, nodeErr
+		}
+
+		switch {
+		case kerrors.IsNotFound(podErr) && kerrors.IsNotFound(nodeErr):
+			return false, fmt.Errorf("node %s got deleted before pod %s was evicted", node.Name, pod.Name)
+		case kerrors.IsNotFound(podErr):
+			return true, nil
+		case kerrors.IsNotFound(nodeErr):
+			return false, fmt.Errorf("node %s got deleted while pod %s still exists", node.Name, pod.Name)
+		}
+		return false, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for pod %s to get evicted: %v", pod.Name, err)
+	}
+	glog.Infof("Pod %s got evicted while node %s still existed", pod.Name, node.Name)
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	glog.Infof("Successfully finished drain test for MachineDeployment %s", machineDeployment.Name)
+	return nil
+}
+
+// verifyDrainBlocked checks for drainBlockedPeriod that neither the pod nor the machine disappear while the
+// PodDisruptionBudget allows no disruption
+func verifyDrainBlocked(machine *clusterv1alpha1.Machine, pod *corev1.Pod, client ctrlruntimeclient.Client, opts ScenarioOptions) error {
+	err := opts.poll(drainBlockedPeriod, func() (bool, error) {
+		current := &corev1.Pod{}
+		if err := client.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, current); err != nil {
+			return false, fmt.Errorf("failed to get pod %s although its PodDisruptionBudget allows no disruption: %v", pod.Name, err)
+		}
+		if current.DeletionTimestamp != nil {
+			return false, fmt.Errorf("pod %s got deleted although its PodDisruptionBudget allows no disruption", pod.Name)
+		}
+		if err := client.Get(context.Background(), types.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, &clusterv1alpha1.Machine{}); err != nil {
+			return false, fmt.Errorf("failed to get machine %s although its node can not be drained: %v", machine.Name, err)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// drainTestObjects returns a pod bound to the node and a PodDisruptionBudget which allows no disruption of it
+func drainTestObjects(machineDeployment *clusterv1alpha1.MachineDeployment, nodeName string) (*policyv1beta1.PodDisruptionBudget, *corev1.Pod) {
+	name := "drain-test-" + machineDeployment.Name
+	podLabels := map[string]string{"app": name}
+	minAvailable := intstr.FromInt(1)
+	var gracePeriod int64 = 5
+
+	pdb := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: machineDeployment.Namespace},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     &metav1.LabelSelector{MatchLabels: podLabels},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: machineDeployment.Namespace, Labels: podLabels},
+		Spec: corev1.PodSpec{
+			NodeName:                      nodeName,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			// The pod must keep running on the cordoned node
+			Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers:  []corev1.Container{{Name: "pause", Image: drainTestPodImage}},
+		},
+	}
+	return pdb, pod
+}
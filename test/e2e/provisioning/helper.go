@@ -106,6 +106,16 @@ func runScenarios(st *testing.T, excludeSelector *scenarioSelector, testParams [
 // args: kubeConfig, maifestPath, scenarioParams, timeout
 type scenarioExecutor func(string, string, []string, time.Duration) error
 
+// skipError is returned by executors whose scenario can not run against the cluster, e.g. because a component it
+// verifies is disabled. The scenario gets skipped instead of failed
+type skipError struct {
+	reason string
+}
+
+func (e skipError) Error() string {
+	return e.reason
+}
+
 func testScenario(t *testing.T, testCase scenario, cloudProvider string, testParams []string, manifestPath string, parallelize bool) {
 
 	if parallelize {
@@ -127,17 +137,8 @@ func testScenario(t *testing.T, testCase scenario, cloudProvider string, testPar
 	// only used by OpenStack scenarios
 	scenarioParams = append(scenarioParams, fmt.Sprintf("<< OS_IMAGE >>=%s", openStackImages[testCase.osName]))
 
-	// default kubeconfig to the hardcoded path at which `make e2e-cluster` creates its new kubeconfig
-	gopath := os.Getenv("GOPATH")
-	projectDir := filepath.Join(gopath, "src/github.com/kubermatic/machine-controller")
-	kubeConfig := filepath.Join(projectDir, ".kubeconfig")
-
-	if _, err := os.Stat(kubeConfig); err == nil {
-		// it exists at hardcoded path
-	} else if os.IsNotExist(err) {
-		// it doesn't exist, fall back to $KUBECONFIG
-		kubeConfig = os.Getenv("KUBECONFIG")
-	} else {
+	kubeConfig, err := e2eKubeConfig()
+	if err != nil {
 		t.Fatal(err)
 	}
 
@@ -148,10 +149,30 @@ func testScenario(t *testing.T, testCase scenario, cloudProvider string, testPar
 	// the global timeout is set to 20 minutes and the verify tool waits up to 60 hours for a machine to show up.
 	// thus one faulty scenario prevents from showing the results for the whole group, which is confusing because it looks like all tests are broken.
 	if err := testCase.executor(kubeConfig, manifestPath, scenarioParams, 25*time.Minute); err != nil {
+		if skipped, ok := err.(skipError); ok {
+			t.Skipf("skipping verify: %s", skipped.reason)
+		}
 		t.Errorf("verify failed due to error=%v", err)
 	}
 }
 
+// e2eKubeConfig returns the path of the kubeconfig of the e2e cluster
+func e2eKubeConfig() (string, error) {
+	// default kubeconfig to the hardcoded path at which `make e2e-cluster` creates its new kubeconfig
+	gopath := os.Getenv("GOPATH")
+	projectDir := filepath.Join(gopath, "src/github.com/kubermatic/machine-controller")
+	kubeConfig := filepath.Join(projectDir, ".kubeconfig")
+
+	if _, err := os.Stat(kubeConfig); err == nil {
+		// it exists at hardcoded path
+		return kubeConfig, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	// it doesn't exist, fall back to $KUBECONFIG
+	return os.Getenv("KUBECONFIG"), nil
+}
+
 func buildScenarios() []scenario {
 	var all []scenario
 	for _, version := range versions {
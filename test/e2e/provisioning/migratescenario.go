@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// migrationCheckPeriod is the poll interval of the migration scenario. It is short, so the deletion of an old
+// machine gets noticed before its instance is gone
+const migrationCheckPeriod = 2 * time.Second
+
+// verifyMigrate creates a MachineDeployment from oldManifest and migrates it to the template of newManifest, e.g.
+// to change the instance type or availability zone. The new machine must use the cloud provider config of
+// newManifest and have a ready node before any old machine gets deleted, so the migration causes no downtime
+func verifyMigrate(kubeConfig, oldManifest, newManifest string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, oldManifest, parameters)
+	if err != nil {
+		return fmt.Errorf("failed to prepare MachineDeployment from %s: %v", oldManifest, err)
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	_, newMachineDeployment, err := prepareMachineDeployment(kubeConfig, newManifest, parameters)
+	if err != nil {
+		return fmt.Errorf("failed to prepare MachineDeployment from %s: %v", newManifest, err)
+	}
+	if newMachineDeployment.Name != machineDeployment.Name {
+		return fmt.Errorf("both manifests must describe the same MachineDeployment, got %s and %s", machineDeployment.Name, newMachineDeployment.Name)
+	}
+	newCloudProviderSpec, err := cloudProviderSpec(newMachineDeployment.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse the providerSpec of %s: %v", newManifest, err)
+	}
+	oldCloudProviderSpec, err := cloudProviderSpec(machineDeployment.Spec.Template.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse the providerSpec of %s: %v", oldManifest, err)
+	}
+	if reflect.DeepEqual(oldCloudProviderSpec, newCloudProviderSpec) {
+		return fmt.Errorf("the cloud provider config of %s does not differ from the one of %s", newManifest, oldManifest)
+	}
+	singleReplicaDeployment(machineDeployment)
+
+	machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
+	}
+
+	oldMachines, err := getMatchingMachines(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	oldMachineNames := sets.NewString()
+	for _, machine := range oldMachines {
+		oldMachineNames.Insert(machine.Name)
+	}
+
+	glog.Infof("Migrating MachineDeployment %s to the template of %s", machineDeployment.Name, newManifest)
+	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+		md.Spec.Template.Spec = *newMachineDeployment.Spec.Template.Spec.DeepCopy()
+	}); err != nil {
+		return fmt.Errorf("failed to apply the template of %s to MachineDeployment %s: %v", newManifest, machineDeployment.Name, err)
+	}
+
+	if err := waitForMigration(machineDeployment, client, oldMachineNames, newCloudProviderSpec, opts, timeout); err != nil {
+		return err
+	}
+	glog.Infof("MachineDeployment %s got migrated without downtime", machineDeployment.Name)
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	glog.Infof("Successfully finished migration test for MachineDeployment %s", machineDeployment.Name)
+	return nil
+}
+
+// waitForMigration waits until the old machines are gone and a new machine has a ready node. It fails as soon as
+// a new machine does not use the expected cloud provider config or an old machine gets deleted while no new
+// machine has a ready node yet
+func waitForMigration(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, oldMachineNames sets.String, expectedCloudProviderSpec map[string]interface{}, opts ScenarioOptions, timeout time.Duration) error {
+	var newNodeReady bool
+	err := opts.poll(timeout, func() (bool, error) {
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+
+		var oldMachineLeft, oldMachineDeleting bool
+		for i, machine := range machines {
+			if oldMachineNames.Has(machine.Name) {
+				oldMachineLeft = true
+				if machine.DeletionTimestamp != nil {
+					oldMachineDeleting = true
+				}
+				continue
+			}
+
+			spec, err := cloudProviderSpec(machine.Spec.ProviderSpec)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse the providerSpec of machine %s: %v", machine.Name, err)
+			}
+			if !reflect.DeepEqual(spec, expectedCloudProviderSpec) {
+				return false, fmt.Errorf("machine %s does not use the cloud provider config of the new template, got %v", machine.Name, spec)
+			}
+			if !newNodeReady {
+				if newNodeReady, err = hasMachineReadyNode(&machines[i], client); err != nil {
+					return false, err
+				}
+				if newNodeReady {
+					glog.Infof("Machine %s of the new template has a ready node", machine.Name)
+				}
+			}
+		}
+
+		if (oldMachineDeleting || !oldMachineLeft) && !newNodeReady {
+			return false, fmt.Errorf("an old machine of MachineDeployment %s got deleted before a new machine had a ready node", machineDeployment.Name)
+		}
+		return newNodeReady && !oldMachineLeft, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for migration of MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+	return nil
+}
+
+// cloudProviderSpec returns the cloud provider specific part of the providerSpec in a comparable form
+func cloudProviderSpec(providerSpec clusterv1alpha1.ProviderSpec) (map[string]interface{}, error) {
+	if providerSpec.Value == nil {
+		return nil, fmt.Errorf("providerSpec.value is nil")
+	}
+	config := providerconfig.Config{}
+	if err := json.Unmarshal(providerSpec.Value.Raw, &config); err != nil {
+		return nil, err
+	}
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(config.CloudProviderSpec.Raw, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// defaultPollInterval is the default interval of the rollout waits of the MachineDeployment scenarios
+	defaultPollInterval = 5 * time.Second
+	// machineReadyCheckPeriod is the default interval of the shared waits for machines and nodes
+	machineReadyCheckPeriod = 15 * time.Second
+)
+
+// ScenarioOptions tunes how the scenarios poll the cluster. The zero value keeps the default interval of each wait
+type ScenarioOptions struct {
+	// PollInterval is the time between two checks of all waits, defaults to 5s for the rollout waits of the
+	// MachineDeployment scenarios and to 15s for the shared waits for machines and nodes
+	PollInterval time.Duration
+	// PollJitter randomly extends each interval by up to the given factor, e.g. 0.5 waits between 5s and 7.5s.
+	// This keeps parallel scenarios from hitting rate limited provider APIs at the same instant
+	PollJitter float64
+}
+
+// poll works like wait.Poll with the interval and jitter of the options, the interval defaults to 5s
+func (o ScenarioOptions) poll(timeout time.Duration, condition wait.ConditionFunc) error {
+	return o.pollEvery(defaultPollInterval, timeout, condition)
+}
+
+// pollEvery works like poll, but the interval defaults to the given one
+func (o ScenarioOptions) pollEvery(defaultInterval, timeout time.Duration, condition wait.ConditionFunc) error {
+	interval := o.PollInterval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if o.PollJitter <= 0 {
+		return wait.Poll(interval, timeout, condition)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-deadline.C:
+			return wait.ErrWaitTimeout
+		case <-time.After(wait.Jitter(interval, o.PollJitter)):
+		}
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// reapplyGracePeriod is how long the MachineDeployment must stay unchanged after the re-apply. The MachineDeployment
+	// controller creates a new MachineSet within seconds if it considers the template changed
+	reapplyGracePeriod = 2 * time.Minute
+	// reappliedAnnotation makes the re-apply an actual update of the MachineDeployment, without touching its template
+	reappliedAnnotation = "machine-controller.kubermatic.io/e2e-reapplied"
+)
+
+// verifyReapplyIsNoOp creates a MachineDeployment and re-applies the template of the manifest once its node is ready.
+// As the template does not change semantically, the MachineDeployment must keep its only MachineSet and machine
+func verifyReapplyIsNoOp(kubeConfig, manifestPath string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	singleReplicaDeployment(machineDeployment)
+
+	created, err := createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return withMachineDiagnostics(fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err), machineDeployment, client)
+	}
+	machineDeployment = created
+
+	machineSets, err := getMachingMachineSets(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	if len(machineSets) != 1 {
+		return withMachineDiagnostics(fmt.Errorf("expected MachineDeployment %s to have one MachineSet before the re-apply, got %d", machineDeployment.Name, len(machineSets)), machineDeployment, client)
+	}
+	machines, err := getMatchingMachines(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	if len(machines) != 1 {
+		return withMachineDiagnostics(fmt.Errorf("expected MachineDeployment %s to have one machine before the re-apply, got %d", machineDeployment.Name, len(machines)), machineDeployment, client)
+	}
+	machineSet, machine := machineSets[0], machines[0]
+
+	// Parse the manifest again, so the template gets submitted the same way as when applying the manifest twice
+	reapplied, err := parseMachineDeployment(manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Re-applying the template of MachineDeployment %s", machineDeployment.Name)
+	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+		md.Spec.Template = reapplied.Spec.Template
+		if md.Annotations == nil {
+			md.Annotations = map[string]string{}
+		}
+		md.Annotations[reappliedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	}); err != nil {
+		return fmt.Errorf("failed to re-apply the template of MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+
+	if err := assureNoChurn(machineDeployment, client, machineSet, machine, opts, reapplyGracePeriod); err != nil {
+		return withMachineDiagnostics(err, machineDeployment, client)
+	}
+	glog.Infof("MachineDeployment %s kept MachineSet %s and machine %s after the re-apply", machineDeployment.Name, machineSet.Name, machine.Name)
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("Failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	return nil
+}
+
+// assureNoChurn fails as soon as the MachineDeployment gets another MachineSet or the machine gets replaced during the
+// grace period. A rollout would create a new MachineSet, so a single MachineSet tells a no-op update from a rollout
+func assureNoChurn(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, machineSet clusterv1alpha1.MachineSet, machine clusterv1alpha1.Machine, opts ScenarioOptions, gracePeriod time.Duration) error {
+	err := opts.pollEvery(machineReadyCheckPeriod, gracePeriod, func() (bool, error) {
+		machineSets, err := getMachingMachineSets(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+		if len(machineSets) != 1 || machineSets[0].UID != machineSet.UID {
+			var names []string
+			for _, ms := range machineSets {
+				names = append(names, ms.Name)
+			}
+			return false, fmt.Errorf("expected MachineDeployment %s to keep only MachineSet %s after the re-apply, got %v", machineDeployment.Name, machineSet.Name, names)
+		}
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+		for _, m := range machines {
+			if m.UID != machine.UID || !m.CreationTimestamp.Equal(&machine.CreationTimestamp) {
+				return false, fmt.Errorf("expected machine %s (created %s) to stay the only machine of MachineDeployment %s after the re-apply, found machine %s (created %s)",
+					machine.Name, machine.CreationTimestamp, machineDeployment.Name, m.Name, m.CreationTimestamp)
+			}
+			if m.DeletionTimestamp != nil {
+				return false, fmt.Errorf("machine %s of MachineDeployment %s got deleted after the re-apply", m.Name, machineDeployment.Name)
+			}
+		}
+		if len(machines) != 1 {
+			return false, fmt.Errorf("expected MachineDeployment %s to keep one machine after the re-apply, got %d", machineDeployment.Name, len(machines))
+		}
+		return false, nil
+	})
+	// The condition never succeeds, the grace period passing without an error means there was no churn
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
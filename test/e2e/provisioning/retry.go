@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	transientRetryAttempts = 5
+	// transientRetryBackoff gets doubled after every failed attempt
+	transientRetryBackoff = 2 * time.Second
+)
+
+// isTransientError returns true for API errors which may succeed when retried, e.g. because the apiserver or the
+// webhook, which calls the cloud provider API, is overloaded. All other errors are terminal
+func isTransientError(err error) bool {
+	return kerrors.IsConflict(err) ||
+		kerrors.IsServerTimeout(err) ||
+		kerrors.IsTimeout(err) ||
+		kerrors.IsTooManyRequests(err) ||
+		kerrors.IsServiceUnavailable(err) ||
+		kerrors.IsInternalError(err)
+}
+
+// retryOnTransient calls fn until it succeeds, returns a terminal error or maxAttempts got exhausted. Terminal errors
+// get returned unchanged. fn must fetch the objects it updates itself, so a conflict gets retried with the latest version
+func retryOnTransient(fn func() error, maxAttempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = fn(); err == nil || !isTransientError(err) {
+			return err
+		}
+		if attempt < maxAttempts {
+			glog.Infof("Retrying after transient error in attempt %d/%d: %v", attempt, maxAttempts, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("still failing after %d attempts: %v", maxAttempts, err)
+}
+
+// getObject gets the object, retrying on transient errors
+func getObject(client ctrlruntimeclient.Client, key types.NamespacedName, obj runtime.Object) error {
+	return retryOnTransient(func() error {
+		return client.Get(context.Background(), key, obj)
+	}, transientRetryAttempts, transientRetryBackoff)
+}
+
+// createObject creates the object, retrying on transient errors. If an attempt timed out although the apiserver
+// created the object, the next attempt fails because it already exists, which counts as success
+func createObject(client ctrlruntimeclient.Client, obj runtime.Object) error {
+	retried := false
+	return retryOnTransient(func() error {
+		err := client.Create(context.Background(), obj)
+		if retried && kerrors.IsAlreadyExists(err) {
+			return nil
+		}
+		retried = true
+		return err
+	}, transientRetryAttempts, transientRetryBackoff)
+}
+
+// deleteObject deletes the object, retrying on transient errors
+func deleteObject(client ctrlruntimeclient.Client, obj runtime.Object) error {
+	return retryOnTransient(func() error {
+		return client.Delete(context.Background(), obj)
+	}, transientRetryAttempts, transientRetryBackoff)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// verifySelfHealing verifies a machine whose node failed out-of-band gets replaced. The kubelet of the ready node gets
+// stopped, so the node can not register again, and the node gets deleted afterwards. The machine-controller must delete
+// the machine once its -join-cluster-timeout is over and the MachineSet must create a replacement with a ready node.
+// The original machine must be gone by then, so the MachineDeployment does not count two machines against one replica
+func verifySelfHealing(kubeConfig, manifestPath string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	singleReplicaDeployment(machineDeployment)
+
+	machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return withMachineDiagnostics(fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err), machineDeployment, client)
+	}
+
+	machines, err := getMatchingMachines(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	if len(machines) != 1 {
+		return fmt.Errorf("expected MachineDeployment %s to have exactly one machine, got %d", machineDeployment.Name, len(machines))
+	}
+	original := machines[0]
+	node, err := getReadyNodeForMachine(&original, client)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("machine %s has no ready node", original.Name)
+	}
+
+	if err := failNode(machineDeployment, node, client, opts, timeout); err != nil {
+		return err
+	}
+
+	if err := waitForMachineReplacement(machineDeployment, &original, client, opts, timeout); err != nil {
+		return withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("Failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	glog.Infof("MachineDeployment %s replaced machine %s after its node failed", machineDeployment.Name, original.Name)
+	return nil
+}
+
+// failNode stops the kubelet of the node with a privileged pod, waits until the node is not ready anymore and deletes it
+func failNode(machineDeployment *clusterv1alpha1.MachineDeployment, node *corev1.Node, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) error {
+	pod := kubeletStopPod(machineDeployment, node.Name)
+	glog.Infof("Stopping the kubelet of node %s with pod %s", node.Name, pod.Name)
+	if err := client.Create(context.Background(), pod); err != nil {
+		return fmt.Errorf("failed to create pod %s: %v", pod.Name, err)
+	}
+	// The kubelet does not report the status of the pod anymore, so it gets removed without waiting for the kubelet
+	defer func() {
+		if err := client.Delete(context.Background(), pod, ctrlruntimeclient.GracePeriodSeconds(0)); err != nil && !kerrors.IsNotFound(err) {
+			glog.Errorf("Failed to delete pod %s: %v", pod.Name, err)
+		}
+	}()
+
+	if err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		current := &corev1.Node{}
+		if err := client.Get(context.Background(), types.NamespacedName{Name: node.Name}, current); err != nil {
+			return false, err
+		}
+		for _, condition := range current.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				return condition.Status != corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for node %s to become not ready after stopping its kubelet: %v", node.Name, err)
+	}
+
+	glog.Infof("Deleting node %s", node.Name)
+	if err := deleteObject(client, node); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// kubeletStopPod returns a privileged pod in the PID namespace of the host, which stops the kubelet of the node
+func kubeletStopPod(machineDeployment *clusterv1alpha1.MachineDeployment, nodeName string) *corev1.Pod {
+	privileged := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubelet-stop-" + machineDeployment.Name, Namespace: machineDeployment.Namespace},
+		Spec: corev1.PodSpec{
+			NodeName:      nodeName,
+			HostPID:       true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            "kubelet-stop",
+				Image:           nodeInspectionImage,
+				Command:         []string{"nsenter", "-t", "1", "-m", "--", "systemctl", "stop", "kubelet.service"},
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+}
+
+// waitForMachineReplacement waits until the original machine is gone and the MachineDeployment got back to its replicas
+// with ready nodes
+func waitForMachineReplacement(machineDeployment *clusterv1alpha1.MachineDeployment, original *clusterv1alpha1.Machine, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) error {
+	glog.Infof("Waiting for machine %s of MachineDeployment %s to get replaced", original.Name, machineDeployment.Name)
+	if err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		err := client.Get(context.Background(), types.NamespacedName{Namespace: original.Namespace, Name: original.Name}, &clusterv1alpha1.Machine{})
+		return kerrors.IsNotFound(err), nil
+	}); err != nil {
+		return fmt.Errorf("machine %s did not get deleted after its node failed, the machine-controller must run with -join-cluster-timeout: %v", original.Name, err)
+	}
+
+	// Machines which are being deleted count as well, so the original machine can not be counted against the replica
+	if err := waitForReadyMachines(machineDeployment, client, *machineDeployment.Spec.Replicas, opts, timeout); err != nil {
+		return err
+	}
+	machines, err := getMatchingMachines(machineDeployment, client)
+	if err != nil {
+		return err
+	}
+	for _, machine := range machines {
+		if machine.UID == original.UID {
+			return fmt.Errorf("expected machine %s to be replaced, but it is still part of MachineDeployment %s", original.Name, machineDeployment.Name)
+		}
+	}
+	return nil
+}
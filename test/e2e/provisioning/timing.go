@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The phases of verifyCreateUpdateAndDelete
+const (
+	phaseCreation               = "creation"
+	phaseNewMachineSet          = "newMachineSet"
+	phaseNewNodeReady           = "newNodeReady"
+	phaseOldMachineSetScaleDown = "oldMachineSetScaleDown"
+	phaseNodeMetadata           = "nodeMetadata"
+	phaseScaleToZero            = "scaleToZero"
+	phaseDeletion               = "deletion"
+)
+
+// ScenarioTiming records how long the phases of a scenario took, so a failed run tells whether e.g. the instance
+// creation, the node join or the MachineSet reconciliation was slow
+type ScenarioTiming struct {
+	Scenario string        `json:"scenario"`
+	Phases   []PhaseTiming `json:"phases"`
+
+	// budgets are the maximum durations of the phases
+	budgets map[string]time.Duration
+}
+
+// PhaseTiming is the start and end of a single phase of a scenario
+type PhaseTiming struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Duration is in nanoseconds when encoded to JSON
+	Duration time.Duration `json:"duration"`
+}
+
+// newScenarioTiming returns a ScenarioTiming which fails phases exceeding their budget. Phases without a budget
+// are only limited by the timeout of the scenario
+func newScenarioTiming(scenario string, budgets map[string]time.Duration) *ScenarioTiming {
+	return &ScenarioTiming{Scenario: scenario, budgets: budgets}
+}
+
+// measure runs the phase and records its duration. The phase gets the timeout of the scenario or its budget,
+// whichever is shorter, so a slow phase fails fast instead of using up the timeout of the whole scenario
+func (t *ScenarioTiming) measure(name string, timeout time.Duration, phase func(timeout time.Duration) error) error {
+	budget, hasBudget := t.budgets[name]
+	if hasBudget && budget < timeout {
+		timeout = budget
+	}
+
+	start := time.Now()
+	err := phase(timeout)
+	end := time.Now()
+	t.Phases = append(t.Phases, PhaseTiming{Name: name, Start: start, End: end, Duration: end.Sub(start)})
+
+	if hasBudget && end.Sub(start) > budget {
+		return fmt.Errorf("phase %s of scenario %s took %s, exceeding its budget of %s: %v", name, t.Scenario, end.Sub(start), budget, err)
+	}
+	if err != nil {
+		return fmt.Errorf("phase %s of scenario %s failed: %v", name, t.Scenario, err)
+	}
+	return nil
+}
+
+// Duration returns how long the named phase took, 0 if it did not run
+func (t *ScenarioTiming) Duration(name string) time.Duration {
+	for _, phase := range t.Phases {
+		if phase.Name == name {
+			return phase.Duration
+		}
+	}
+	return 0
+}
+
+// log emits the timing as a single JSON line
+func (t *ScenarioTiming) log() {
+	encoded, err := json.Marshal(t)
+	if err != nil {
+		glog.Errorf("Failed to encode timing of scenario %s: %v", t.Scenario, err)
+		return
+	}
+	glog.Infof("Scenario timing: %s", encoded)
+}
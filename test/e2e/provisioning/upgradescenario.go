@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/golang/glog"
+
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// verifyKubeletVersionUpgrade creates a MachineDeployment, then updates the kubelet version of its template to the next
+// version of the supported versions. It succeeds once the node of the replacement machine reports the new version
+func verifyKubeletVersionUpgrade(kubeConfig, manifestPath string, parameters []string, opts ScenarioOptions, timeout time.Duration) error {
+
+	client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+	if err != nil {
+		return err
+	}
+	defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+	singleReplicaDeployment(machineDeployment)
+
+	upgradeVersion, err := nextKubeletVersion(machineDeployment.Spec.Template.Spec.Versions.Kubelet)
+	if err != nil {
+		return err
+	}
+
+	created, err := createAndAssure(machineDeployment, client, opts, timeout)
+	if err != nil {
+		return withMachineDiagnostics(fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err), machineDeployment, client)
+	}
+	machineDeployment = created
+	if err := waitForKubeletVersion(machineDeployment, client, machineDeployment.Spec.Template.Spec.Versions.Kubelet, opts, timeout); err != nil {
+		return withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	glog.Infof("Upgrading the kubelet of MachineDeployment %s from %s to %s", machineDeployment.Name, machineDeployment.Spec.Template.Spec.Versions.Kubelet, upgradeVersion)
+	if err := updateMachineDeployment(machineDeployment, client, func(md *clusterv1alpha1.MachineDeployment) {
+		md.Spec.Template.Spec.Versions.Kubelet = upgradeVersion
+	}); err != nil {
+		return fmt.Errorf("failed to update the kubelet version of MachineDeployment %s: %v", machineDeployment.Name, err)
+	}
+	if err := waitForKubeletVersion(machineDeployment, client, upgradeVersion, opts, timeout); err != nil {
+		return withMachineDiagnostics(err, machineDeployment, client)
+	}
+
+	if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+		return fmt.Errorf("Failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+	}
+	return nil
+}
+
+// waitForKubeletVersion waits until a machine of the MachineDeployment which requests the kubelet version has a ready
+// node reporting that version
+func waitForKubeletVersion(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, version string, opts ScenarioOptions, timeout time.Duration) error {
+	glog.Infof("Waiting for a node of MachineDeployment %s to report kubelet version %s", machineDeployment.Name, version)
+	err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+		for i := range machines {
+			if machines[i].Spec.Versions.Kubelet != version {
+				continue
+			}
+			if ready, err := hasMachineReadyNodeWithVersion(&machines[i], client); err != nil || ready {
+				return ready, err
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for a node of MachineDeployment %s with kubelet version %s: %v", machineDeployment.Name, version, err)
+	}
+	glog.Infof("Found a ready node with kubelet version %s for MachineDeployment %s", version, machineDeployment.Name)
+	return nil
+}
+
+// nextKubeletVersion returns the lowest of the supported versions which is newer than the given version
+func nextKubeletVersion(version string) (string, error) {
+	current, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse kubelet version %q: %v", version, err)
+	}
+	var next *semver.Version
+	for _, v := range versions {
+		if v.GreaterThan(current) && (next == nil || v.LessThan(next)) {
+			next = v
+		}
+	}
+	if next == nil {
+		return "", fmt.Errorf("no supported kubelet version is newer than %s", version)
+	}
+	return next.String(), nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nodeInspectionImage must provide sh and nsenter
+const nodeInspectionImage = "busybox:1.30"
+
+// nodeAssertion is checked on the host of a node. Either path and content or unit must be set
+type nodeAssertion struct {
+	// path is a file on the host which must have exactly the given content. The content must not exceed
+	// 4096 bytes, the size limit of termination messages
+	path    string
+	content string
+	// unit is a systemd unit which must be active
+	unit string
+}
+
+func (a nodeAssertion) String() string {
+	if a.unit != "" {
+		return fmt.Sprintf("unit %s is active", a.unit)
+	}
+	return fmt.Sprintf("file %s has the expected content", a.path)
+}
+
+// command returns the command which writes the actual state of the host into the termination message of the container.
+// nsenter only enters the mount namespace of the host, the redirection still writes into the container
+func (a nodeAssertion) command() []string {
+	if a.unit != "" {
+		return []string{"sh", "-c", `nsenter -t 1 -m -- systemctl is-active "$0" > /dev/termination-log 2>&1; exit 0`, a.unit}
+	}
+	return []string{"sh", "-c", `nsenter -t 1 -m -- cat "$0" > /dev/termination-log 2>&1; exit 0`, a.path}
+}
+
+// check returns an error if the actual state of the host does not match the assertion
+func (a nodeAssertion) check(actual string) error {
+	if a.unit != "" {
+		if strings.TrimSpace(actual) != "active" {
+			return fmt.Errorf("%s: expected state active, got %q", a, strings.TrimSpace(actual))
+		}
+		return nil
+	}
+	if actual != a.content {
+		return fmt.Errorf("%s: expected content %q, got %q", a, a.content, actual)
+	}
+	return nil
+}
+
+// verifyUserDataApplied returns a scenario which creates a MachineDeployment and checks the assertions on the host of
+// its node once it is ready, e.g. for files and systemd units the userdata of the machine is expected to create
+func verifyUserDataApplied(assertions []nodeAssertion, opts ScenarioOptions) scenarioExecutor {
+	return func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+
+		client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+		if err != nil {
+			return err
+		}
+		defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+		singleReplicaDeployment(machineDeployment)
+
+		created, err := createAndAssure(machineDeployment, client, opts, timeout)
+		if err != nil {
+			return withMachineDiagnostics(fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err), machineDeployment, client)
+		}
+		machineDeployment = created
+
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return err
+		}
+		if len(machines) != 1 {
+			return fmt.Errorf("expected MachineDeployment %s to have exactly one machine, got %d", machineDeployment.Name, len(machines))
+		}
+		node, err := getReadyNodeForMachine(&machines[0], client)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("machine %s has no ready node", machines[0].Name)
+		}
+
+		// The MachineDeployment gets deleted even if an assertion failed, so no instance is left behind
+		verifyErr := runNodeAssertions(machineDeployment, node.Name, assertions, client, opts, timeout)
+		if verifyErr == nil {
+			glog.Infof("All %d assertions passed on node %s of MachineDeployment %s", len(assertions), node.Name, machineDeployment.Name)
+		}
+
+		if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+			return fmt.Errorf("Failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+		}
+		return verifyErr
+	}
+}
+
+// runNodeAssertions checks the assertions with a privileged pod on the node. Every assertion gets its own container,
+// which reports the actual state of the host in its termination message. The pod gets deleted afterwards
+func runNodeAssertions(machineDeployment *clusterv1alpha1.MachineDeployment, nodeName string, assertions []nodeAssertion, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) (err error) {
+	pod := nodeInspectionPod(machineDeployment, nodeName, assertions)
+	if err := client.Create(context.Background(), pod); err != nil {
+		return fmt.Errorf("failed to create node inspection pod %s: %v", pod.Name, err)
+	}
+	defer func() {
+		if cleanupErr := deleteNodeInspectionPod(pod, client, opts, timeout); cleanupErr != nil {
+			if err == nil {
+				err = cleanupErr
+			} else {
+				glog.Errorf("%v", cleanupErr)
+			}
+		}
+	}()
+
+	glog.Infof("Waiting for node inspection pod %s to complete on node %s", pod.Name, nodeName)
+	current := &corev1.Pod{}
+	if err := opts.poll(timeout, func() (bool, error) {
+		if err := client.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, current); err != nil {
+			return false, err
+		}
+		return current.Status.Phase == corev1.PodSucceeded || current.Status.Phase == corev1.PodFailed, nil
+	}); err != nil {
+		return fmt.Errorf("failed waiting for node inspection pod %s to complete, phase %q: %v", pod.Name, current.Status.Phase, err)
+	}
+
+	actual := map[string]string{}
+	for _, status := range current.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			actual[status.Name] = status.State.Terminated.Message
+		}
+	}
+	var failed []string
+	for i, assertion := range assertions {
+		message, ok := actual[nodeAssertionContainerName(i)]
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: container %s reported nothing", assertion, nodeAssertionContainerName(i)))
+			continue
+		}
+		if err := assertion.check(message); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d assertions failed on node %s:\n%s", len(failed), len(assertions), nodeName, strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// nodeInspectionPod returns a privileged pod in the PID namespace of the host, which runs one container per assertion
+func nodeInspectionPod(machineDeployment *clusterv1alpha1.MachineDeployment, nodeName string, assertions []nodeAssertion) *corev1.Pod {
+	privileged := true
+	var gracePeriod int64
+	var containers []corev1.Container
+	for i, assertion := range assertions {
+		containers = append(containers, corev1.Container{
+			Name:            nodeAssertionContainerName(i),
+			Image:           nodeInspectionImage,
+			Command:         assertion.command(),
+			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+		})
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-inspection-" + machineDeployment.Name, Namespace: machineDeployment.Namespace},
+		Spec: corev1.PodSpec{
+			NodeName:                      nodeName,
+			HostPID:                       true,
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers:                    containers,
+		},
+	}
+}
+
+func nodeAssertionContainerName(i int) string {
+	return fmt.Sprintf("assertion-%d", i)
+}
+
+// deleteNodeInspectionPod deletes the pod and waits until it is gone, so it does not block the drain of the node
+func deleteNodeInspectionPod(pod *corev1.Pod, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) error {
+	if err := client.Delete(context.Background(), pod); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete node inspection pod %s: %v", pod.Name, err)
+	}
+	if err := opts.poll(timeout, func() (bool, error) {
+		err := client.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &corev1.Pod{})
+		if kerrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}); err != nil {
+		return fmt.Errorf("failed waiting for node inspection pod %s to get deleted: %v", pod.Name, err)
+	}
+	return nil
+}
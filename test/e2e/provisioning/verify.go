@@ -20,9 +20,11 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver"
 	"github.com/golang/glog"
 
 	machinecontroller "github.com/kubermatic/machine-controller/pkg/controller/machine"
@@ -32,18 +34,12 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/retry"
 	clusterv1alpha1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const (
-	machineReadyCheckPeriod = 15 * time.Second
-)
-
 func verifyCreateMachineFails(kubeConfig, manifestPath string, parameters []string, _ time.Duration) error {
 	client, machine, err := prepareMachine(kubeConfig, manifestPath, parameters)
 	if err != nil {
@@ -61,13 +57,14 @@ func verifyCreateAndDelete(kubeConfig, manifestPath string, parameters []string,
 	if err != nil {
 		return err
 	}
+	defer cleanupMachineDeployment(machineDeployment, client, ScenarioOptions{}, timeout)
 
-	machineDeployment, err = createAndAssure(machineDeployment, client, timeout)
+	machineDeployment, err = createAndAssure(machineDeployment, client, ScenarioOptions{}, timeout)
 	if err != nil {
 		return fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err)
 	}
 
-	if err := deleteAndAssure(machineDeployment, client, timeout); err != nil {
+	if err := deleteAndAssure(machineDeployment, client, ScenarioOptions{}, timeout); err != nil {
 		return fmt.Errorf("Failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
 	}
 
@@ -77,23 +74,39 @@ func verifyCreateAndDelete(kubeConfig, manifestPath string, parameters []string,
 
 func prepareMachineDeployment(kubeConfig, manifestPath string, parameters []string) (ctrlruntimeclient.Client, *clusterv1alpha1.MachineDeployment, error) {
 
-	client, manifest, err := prepare(kubeConfig, manifestPath, parameters)
+	client, err := newClient(kubeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	newMachineDeployment, err := parseMachineDeployment(manifestPath, parameters)
 	if err != nil {
 		return nil, nil, err
 	}
+	return client, newMachineDeployment, nil
+}
+
+// parseMachineDeployment reads the MachineDeployment from the manifest after replacing the given parameters
+func parseMachineDeployment(manifestPath string, parameters []string) (*clusterv1alpha1.MachineDeployment, error) {
+	if len(manifestPath) == 0 {
+		return nil, fmt.Errorf("manifest path must be defined")
+	}
+	manifest, err := readAndModifyManifest(manifestPath, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the manifest, due to: %v", err)
+	}
+
 	newMachineDeployment := &clusterv1alpha1.MachineDeployment{}
 	manifestReader := strings.NewReader(manifest)
 	manifestDecoder := yaml.NewYAMLToJSONDecoder(manifestReader)
-	err = manifestDecoder.Decode(newMachineDeployment)
-	if err != nil {
-		return nil, nil, err
+	if err := manifestDecoder.Decode(newMachineDeployment); err != nil {
+		return nil, err
 	}
 	// Enforce the kube-system namespace, otherwise cleanup wont work
 	newMachineDeployment.Namespace = "kube-system"
 	// Dont evict during testing
 	newMachineDeployment.Spec.Template.Spec.Annotations = map[string]string{eviction.SkipEvictionAnnotationKey: "true"}
 
-	return client, newMachineDeployment, nil
+	return newMachineDeployment, nil
 }
 
 func prepareMachine(kubeConfig, manifestPath string, parameters []string) (ctrlruntimeclient.Client, *clusterv1alpha1.Machine, error) {
@@ -122,14 +135,9 @@ func prepare(kubeConfig, manifestPath string, parameters []string) (ctrlruntimec
 		return nil, "", fmt.Errorf("kubeconfig and manifest path must be defined")
 	}
 
-	// init kube related stuff
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	client, err := newClient(kubeConfig)
 	if err != nil {
-		return nil, "", fmt.Errorf("Error building kubeconfig: %v", err)
-	}
-	client, err := ctrlruntimeclient.New(cfg, ctrlruntimeclient.Options{})
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to create Client: %v", err)
+		return nil, "", err
 	}
 
 	// prepare the manifest
@@ -141,7 +149,23 @@ func prepare(kubeConfig, manifestPath string, parameters []string) (ctrlruntimec
 	return client, manifest, nil
 }
 
-func createAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, timeout time.Duration) (*clusterv1alpha1.MachineDeployment, error) {
+// newClient returns a client for the cluster of the kubeconfig. The client can be shared by concurrent scenarios
+func newClient(kubeConfig string) (ctrlruntimeclient.Client, error) {
+	if len(kubeConfig) == 0 {
+		return nil, fmt.Errorf("kubeconfig must be defined")
+	}
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error building kubeconfig: %v", err)
+	}
+	client, err := ctrlruntimeclient.New(cfg, ctrlruntimeclient.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Client: %v", err)
+	}
+	return client, nil
+}
+
+func createAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) (*clusterv1alpha1.MachineDeployment, error) {
 	// we expect that no node for machine exists in the cluster
 	err := assureNodeForMachineDeployment(machineDeployment, client, false)
 	if err != nil {
@@ -149,12 +173,12 @@ func createAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, clien
 	}
 
 	glog.Infof("creating a new \"%s\" MachineDeployment\n", machineDeployment.Name)
-	if err := client.Create(context.Background(), machineDeployment); err != nil {
+	if err := createObject(client, machineDeployment); err != nil {
 		return nil, err
 	}
 
 	var pollErr error
-	err = wait.Poll(machineReadyCheckPeriod, timeout, func() (bool, error) {
+	err = opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
 		pollErr = assureNodeForMachineDeployment(machineDeployment, client, true)
 		if pollErr == nil {
 			return true, nil
@@ -167,7 +191,7 @@ func createAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, clien
 	glog.Infof("Found a node for MachineDeployment %s", machineDeployment.Name)
 
 	glog.Infof("Waiting for node of MachineDeployment %s to become ready", machineDeployment.Name)
-	err = wait.Poll(machineReadyCheckPeriod, timeout, func() (bool, error) {
+	err = opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
 		machines, pollErr := getMatchingMachines(machineDeployment, client)
 		if pollErr != nil || len(machines) < 1 {
 			return false, nil
@@ -190,23 +214,115 @@ func createAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, clien
 }
 
 func hasMachineReadyNode(machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) (bool, error) {
+	node, err := getReadyNodeForMachine(machine, client)
+	return node != nil, err
+}
+
+// hasMachineReadyNodeWithVersion returns true if the machine has a ready node whose kubelet reports the version of
+// spec.versions.kubelet. A ready node reporting another version fails, as its kubelet will not change anymore
+func hasMachineReadyNodeWithVersion(machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) (bool, error) {
+	node, err := getReadyNodeForMachine(machine, client)
+	if err != nil || node == nil {
+		return false, err
+	}
+	matches, err := kubeletVersionMatches(node.Status.NodeInfo.KubeletVersion, machine.Spec.Versions.Kubelet)
+	if err != nil {
+		return false, err
+	}
+	if !matches {
+		return false, fmt.Errorf("node %s of machine %s reports kubelet version %s, expected %s",
+			node.Name, machine.Name, node.Status.NodeInfo.KubeletVersion, machine.Spec.Versions.Kubelet)
+	}
+	return true, nil
+}
+
+// kubeletVersionMatches compares the version a kubelet reports with the requested one. Some distros append a suffix
+// to the reported version, e.g. v1.14.0+k3s.1 or v1.13.5-1+a1b2c3d4, so only the major, minor and patch version get
+// compared, plus the pre-release if one got requested
+func kubeletVersionMatches(reported, requested string) (bool, error) {
+	reportedVersion, err := semver.NewVersion(reported)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse reported kubelet version %q: %v", reported, err)
+	}
+	requestedVersion, err := semver.NewVersion(requested)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse requested kubelet version %q: %v", requested, err)
+	}
+	if reportedVersion.Major() != requestedVersion.Major() ||
+		reportedVersion.Minor() != requestedVersion.Minor() ||
+		reportedVersion.Patch() != requestedVersion.Patch() {
+		return false, nil
+	}
+	return requestedVersion.Prerelease() == "" || reportedVersion.Prerelease() == requestedVersion.Prerelease(), nil
+}
+
+// getReadyNodeForMachine returns the ready node of the machine, nil if it has none
+func getReadyNodeForMachine(machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) (*corev1.Node, error) {
 	nodes := &corev1.NodeList{}
 	if err := client.List(context.Background(), &ctrlruntimeclient.ListOptions{}, nodes); err != nil {
-		return false, fmt.Errorf("failed to list nodes: %v", err)
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
 	}
-	for _, node := range nodes.Items {
+	for i, node := range nodes.Items {
 		if isNodeForMachine(&node, machine) {
 			for _, condition := range node.Status.Conditions {
 				if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
-					return true, nil
+					return &nodes.Items[i], nil
 				}
 			}
 		}
 	}
-	return false, nil
+	return nil, nil
+}
+
+// verifyNodeLabelsAndAnnotations waits until the ready nodes of all machines of the MachineDeployment have the expected
+// labels and annotations. Other labels and annotations, e.g. the ones set by the kubelet or the cloud provider, are ignored
+func verifyNodeLabelsAndAnnotations(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, expectedLabels, expectedAnnotations map[string]string, opts ScenarioOptions, timeout time.Duration) error {
+	glog.Infof("Waiting for the nodes of MachineDeployment %s to get the labels %v and annotations %v", machineDeployment.Name, expectedLabels, expectedAnnotations)
+	var mismatches []string
+	err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return false, err
+		}
+		if len(machines) == 0 {
+			mismatches = []string{"no machines"}
+			return false, nil
+		}
+		mismatches = nil
+		for i := range machines {
+			node, err := getReadyNodeForMachine(&machines[i], client)
+			if err != nil {
+				return false, err
+			}
+			if node == nil {
+				mismatches = append(mismatches, fmt.Sprintf("machine %s has no ready node", machines[i].Name))
+				continue
+			}
+			mismatches = append(mismatches, missingEntries("label", node.Name, node.Labels, expectedLabels)...)
+			mismatches = append(mismatches, missingEntries("annotation", node.Name, node.Annotations, expectedAnnotations)...)
+		}
+		return len(mismatches) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("nodes of MachineDeployment %s did not get the expected labels and annotations: %v (%s)", machineDeployment.Name, err, strings.Join(mismatches, ", "))
+	}
+	glog.Infof("The nodes of MachineDeployment %s have the expected labels and annotations", machineDeployment.Name)
+	return nil
+}
+
+// missingEntries describes every expected key of the node which is missing or has a different value
+func missingEntries(kind, nodeName string, actual, expected map[string]string) []string {
+	var missing []string
+	for key, value := range expected {
+		if actualValue, ok := actual[key]; !ok || actualValue != value {
+			missing = append(missing, fmt.Sprintf("node %s has %s %s=%q, expected %q", nodeName, kind, key, actualValue, value))
+		}
+	}
+	sort.Strings(missing)
+	return missing
 }
 
-func deleteAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, timeout time.Duration) error {
+func deleteAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, opts ScenarioOptions, timeout time.Duration) error {
 	glog.Infof("Starting to clean up MachineDeployment %s", machineDeployment.Name)
 
 	// We first scale down to 0, because once the machineSets are deleted we can not
@@ -219,7 +335,7 @@ func deleteAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, clien
 	}
 
 	// Ensure machines are gone
-	if err := wait.Poll(machineReadyCheckPeriod, timeout, func() (bool, error) {
+	if err := opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
 		ownedMachines, err := getMatchingMachines(machineDeployment, client)
 		if err != nil {
 			return false, err
@@ -236,7 +352,7 @@ func deleteAndAssure(machineDeployment *clusterv1alpha1.MachineDeployment, clien
 	if err := client.Delete(context.Background(), machineDeployment); err != nil {
 		return fmt.Errorf("unable to remove MachineDeployment %s, due to %v", machineDeployment.Name, err)
 	}
-	return wait.Poll(machineReadyCheckPeriod, timeout, func() (bool, error) {
+	return opts.pollEvery(machineReadyCheckPeriod, timeout, func() (bool, error) {
 		err := client.Get(context.Background(), types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}, &clusterv1alpha1.MachineDeployment{})
 		if kerrors.IsNotFound(err) {
 			return true, nil
@@ -388,17 +504,24 @@ func getInt32Ptr(i int32) *int32 {
 	return &i
 }
 
+// singleReplicaDeployment sets the replicas of the MachineDeployment to one, for the scenarios which inherently rely on
+// it having a single machine
+func singleReplicaDeployment(md *clusterv1alpha1.MachineDeployment) {
+	md.Spec.Replicas = getInt32Ptr(1)
+}
+
 func updateMachineDeployment(md *clusterv1alpha1.MachineDeployment, client ctrlruntimeclient.Client, modify func(*clusterv1alpha1.MachineDeployment)) error {
 	// Store Namespace and Name here because after an error md will be nil
 	name := md.Name
 	namespace := md.Namespace
 
-	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+	// A conflict gets retried with the MachineDeployment fetched again, so the modification is applied to its latest version
+	return retryOnTransient(func() error {
 		md := &clusterv1alpha1.MachineDeployment{}
 		if err := client.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, md); err != nil {
 			return err
 		}
 		modify(md)
 		return client.Update(context.Background(), md)
-	})
+	}, transientRetryAttempts, transientRetryBackoff)
 }
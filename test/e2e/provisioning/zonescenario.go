@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	zonePlacementReplicas = 3
+	nodeZoneLabel         = "failure-domain.beta.kubernetes.io/zone"
+)
+
+// verifyZonePlacement returns a scenario which creates a MachineDeployment with several machines and checks all of
+// their nodes got the zone label of the cloud provider set to the expected zone, so none of them got spread elsewhere
+func verifyZonePlacement(expectedZone string, opts ScenarioOptions) scenarioExecutor {
+	return func(kubeConfig, manifestPath string, parameters []string, timeout time.Duration) error {
+
+		client, machineDeployment, err := prepareMachineDeployment(kubeConfig, manifestPath, parameters)
+		if err != nil {
+			return err
+		}
+		defer cleanupMachineDeployment(machineDeployment, client, opts, timeout)
+		machineDeployment.Spec.Replicas = getInt32Ptr(zonePlacementReplicas)
+
+		machineDeployment, err = createAndAssure(machineDeployment, client, opts, timeout)
+		if err != nil {
+			return withMachineDiagnostics(fmt.Errorf("failed to verify creation of node for MachineDeployment: %v", err), machineDeployment, client)
+		}
+		if err := waitForReadyMachines(machineDeployment, client, zonePlacementReplicas, opts, timeout); err != nil {
+			return withMachineDiagnostics(err, machineDeployment, client)
+		}
+
+		machines, err := getMatchingMachines(machineDeployment, client)
+		if err != nil {
+			return err
+		}
+		for i := range machines {
+			node, err := getReadyNodeForMachine(&machines[i], client)
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return fmt.Errorf("machine %s has no ready node", machines[i].Name)
+			}
+			if zone := node.Labels[nodeZoneLabel]; zone != expectedZone {
+				return fmt.Errorf("expected node %s of machine %s to be in zone %q, got %q", node.Name, machines[i].Name, expectedZone, zone)
+			}
+		}
+		glog.Infof("All %d nodes of MachineDeployment %s are in zone %s", len(machines), machineDeployment.Name, expectedZone)
+
+		if err := deleteAndAssure(machineDeployment, client, opts, timeout); err != nil {
+			return fmt.Errorf("failed to verify if a machine/node has been created/deleted, due to: \n%v", err)
+		}
+		return nil
+	}
+}